@@ -45,6 +45,50 @@ var (
 
 		This command extracts files from existing images to use as input to a build.
 		It expects to be run inside of a container.`)
+
+	buildServerLong = templates.LongDesc(`
+		Runs builds for other builder pods.
+
+		This command stays resident, accepting builds on the unix socket named by
+		$BUILD_SERVER_SOCKET and running them one at a time.  Builds reuse its storage,
+		so base images pulled for one build are there for the next, and its
+		configuration and credentials.  The other builder commands hand their builds
+		off to it when they find the socket.`)
+
+	localBuildLong = templates.LongDesc(`
+		Run a build outside of a cluster
+
+		This command runs the build described by a Build manifest, in YAML or JSON, taking the
+		same steps as the containers of a build pod, so that a build which failed in a cluster can
+		be reproduced on a workstation.  The secrets and configmaps the build uses are read from
+		directories, named for them, in the directories given by --secrets and --configmaps.  The
+		output of the build must be a DockerImage, or its status.outputDockerImageReference must be
+		set.
+
+		It expects to be run inside of a privileged container of the builder image.`)
+
+	pruneLong = templates.LongDesc(`
+		Remove what earlier builds left in their storage
+
+		This command removes the containers, images and mounts which builds left in the
+		container storage, such as those of builds which crashed, so that storage kept on a
+		volume between builds does not grow without bound.  Containers older than --max-age
+		are removed, as are untagged images.  Tagged images, such as the base images kept for
+		later builds, are removed once they are older than --max-age, and the oldest are removed
+		until the images fit in --max-size.  Without --max-age every container and untagged
+		image is removed and leftover mounts are released, so no build may be using the storage.
+
+		It expects to be run inside of a container of the builder image, with the storage the
+		builds use mounted.`)
+
+	pruneExample = templates.Examples(`
+		# List what would be removed from a cache volume holding the storage of builds
+		openshift-builder prune --root /cache/storage --max-age 72h --max-size 50Gi --dry-run`)
+
+	localBuildExample = templates.Examples(`
+		# Reproduce a build, whose pull secret is ./secrets/pull-secret/.dockerconfigjson
+		podman run --privileged -v $PWD:/local:z docker.io/openshift/origin-docker-builder \
+		  openshift-builder local --build /local/build.yaml --secrets /local/secrets`)
 )
 
 // NewCmdVersion provides a shim around version for
@@ -136,3 +180,80 @@ func NewCommandExtractImageContent(name string) *cobra.Command {
 	cmd.AddCommand(NewCmdVersion(name, version.Get(), os.Stdout))
 	return cmd
 }
+
+// NewCommandBuilder provides a CLI handler for the builder binary when it is
+// run by its own name, whose subcommands do not run in a build pod.
+func NewCommandBuilder(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: "Run builds",
+		Run: func(c *cobra.Command, args []string) {
+			c.Help()
+		},
+	}
+	cmd.AddCommand(NewCommandLocalBuild("local"))
+	cmd.AddCommand(NewCommandPrune("prune"))
+	cmd.AddCommand(NewCmdVersion(name, version.Get(), os.Stdout))
+	return cmd
+}
+
+// NewCommandLocalBuild provides a CLI handler for running a build outside of
+// a cluster.
+func NewCommandLocalBuild(name string) *cobra.Command {
+	opts := cmd.LocalBuildOptions{}
+	c := &cobra.Command{
+		Use:     name + " --build FILE",
+		Short:   "Run a build outside of a cluster",
+		Long:    localBuildLong,
+		Example: localBuildExample,
+		Run: func(c *cobra.Command, args []string) {
+			if len(opts.BuildFile) == 0 {
+				kcmdutil.CheckErr(kcmdutil.UsageErrorf(c, "--build is required"))
+			}
+			err := cmd.RunLocalBuild(c.OutOrStderr(), opts)
+			kcmdutil.CheckErr(err)
+		},
+	}
+	c.Flags().StringVar(&opts.BuildFile, "build", "", "The path of the Build manifest to run.")
+	c.Flags().StringVar(&opts.SecretsDir, "secrets", "", "The directory holding a directory for each of the secrets the build uses.")
+	c.Flags().StringVar(&opts.ConfigMapsDir, "configmaps", "", "The directory holding a directory for each of the build's input configmaps.")
+	return c
+}
+
+// NewCommandPrune provides a CLI handler for pruning the storage of earlier
+// builds.
+func NewCommandPrune(name string) *cobra.Command {
+	opts := cmd.PruneOptions{}
+	c := &cobra.Command{
+		Use:     name,
+		Short:   "Remove what earlier builds left in their storage",
+		Long:    pruneLong,
+		Example: pruneExample,
+		Run: func(c *cobra.Command, args []string) {
+			err := cmd.RunPrune(c.OutOrStderr(), opts)
+			kcmdutil.CheckErr(err)
+		},
+	}
+	c.Flags().StringVar(&opts.GraphRoot, "root", "", "The directory of the storage to prune, if not the one builds are configured to use.")
+	c.Flags().StringVar(&opts.RunRoot, "runroot", "", "The directory of the storage's state, if not the one builds are configured to use.")
+	c.Flags().DurationVar(&opts.MaxAge, "max-age", 0, "How old containers and images may be before they are removed. If unset, every container and untagged image is removed.")
+	c.Flags().StringVar(&opts.MaxSize, "max-size", "", "The total size, such as 50Gi, which the images kept may use.")
+	c.Flags().BoolVar(&opts.DryRun, "dry-run", false, "List what would be removed without removing it.")
+	return c
+}
+
+// NewCommandBuildServer provides a CLI handler for a resident builder which
+// runs the builds of other builder pods.
+func NewCommandBuildServer(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: "Run builds for other builder pods",
+		Long:  buildServerLong,
+		Run: func(c *cobra.Command, args []string) {
+			err := cmd.RunBuildServer(c.OutOrStderr())
+			kcmdutil.CheckErr(err)
+		},
+	}
+	cmd.AddCommand(NewCmdVersion(name, version.Get(), os.Stdout))
+	return cmd
+}
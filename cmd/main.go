@@ -18,6 +18,8 @@ import (
 	"k8s.io/component-base/logs"
 
 	"github.com/openshift/builder/pkg/build/builder"
+	"github.com/openshift/builder/pkg/build/builder/server"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 	"github.com/openshift/builder/pkg/version"
 	"github.com/openshift/library-go/pkg/serviceability"
 )
@@ -26,6 +28,24 @@ func main() {
 	if reexec.Init() {
 		return
 	}
+	// hand the build off to a resident builder, if there is one
+	basename := filepath.Base(os.Args[0])
+	if socket := os.Getenv(builderutil.BuildServerSocket); len(socket) > 0 && server.Commands[basename] {
+		if _, err := os.Stat(socket); err == nil {
+			files, err := server.ReadForwardedFiles()
+			if err != nil {
+				fmt.Printf("Error: unable to read the build's secrets and configmaps: %v\n", err)
+				os.Exit(1)
+			}
+			code, err := server.Submit(socket, server.Request{Command: basename, Args: os.Args[1:], Env: os.Environ(), Files: files}, os.Stderr)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(code)
+		}
+	}
+	builder.MaybeReexecRootless()
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGTERM)
@@ -63,7 +83,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	basename := filepath.Base(os.Args[0])
 	command := CommandFor(basename)
 	if err := command.Execute(); err != nil {
 		os.Exit(1)
@@ -140,6 +159,10 @@ func CommandFor(basename string) *cobra.Command {
 		cmd = NewCommandManageDockerfile(basename)
 	case "openshift-extract-image-content":
 		cmd = NewCommandExtractImageContent(basename)
+	case "openshift-builder-server":
+		cmd = NewCommandBuildServer(basename)
+	case "openshift-builder":
+		cmd = NewCommandBuilder(basename)
 	default:
 		fmt.Printf("unknown command name: %s\n", basename)
 		os.Exit(1)
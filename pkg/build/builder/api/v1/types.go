@@ -0,0 +1,62 @@
+package v1
+
+// The types here are the messages of builder.proto in their proto3 JSON
+// form, in which the API is served until gRPC is among the builder's
+// dependencies.
+
+// Paths at which the methods of the Builder service are served over HTTP.
+const (
+	// BuildsPath accepts StartBuild requests.
+	BuildsPath = "/v1/builds"
+	// LogsSuffix and CancelSuffix follow the path of a build, BuildsPath
+	// and its ID, for StreamBuildLogs and CancelBuild.
+	LogsSuffix   = "/logs"
+	CancelSuffix = "/cancel"
+)
+
+// StartBuildRequest describes the builder command to run.
+type StartBuildRequest struct {
+	// Command is the name of the builder command, such as
+	// openshift-docker-build.
+	Command string `json:"command"`
+	// Args are the command's arguments, such as --loglevel=5.
+	Args []string `json:"args,omitempty"`
+	// Env is the environment of the command, such as the BUILD it is to
+	// run, as NAME=value pairs added to the server's own.
+	Env []string `json:"env,omitempty"`
+}
+
+// BuildRef names a build started by StartBuild.
+type BuildRef struct {
+	ID string `json:"id"`
+}
+
+// Phase is where a build is in its life.
+type Phase string
+
+const (
+	// PhaseQueued is a build waiting for the builds before it to finish.
+	PhaseQueued    Phase = "PHASE_QUEUED"
+	PhaseRunning   Phase = "PHASE_RUNNING"
+	PhaseSucceeded Phase = "PHASE_SUCCEEDED"
+	PhaseFailed    Phase = "PHASE_FAILED"
+	PhaseCancelled Phase = "PHASE_CANCELLED"
+)
+
+// Finished returns whether a build in phase p has finished.
+func (p Phase) Finished() bool {
+	return p == PhaseSucceeded || p == PhaseFailed || p == PhaseCancelled
+}
+
+// BuildStatus is the status of a build.
+type BuildStatus struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Phase   Phase  `json:"phase"`
+	// ExitCode is the exit code of a finished build's command.
+	ExitCode int32 `json:"exitCode,omitempty"`
+	// StartTime and CompletionTime are RFC 3339 times at which the build
+	// started running and finished, once it has.
+	StartTime      string `json:"startTime,omitempty"`
+	CompletionTime string `json:"completionTime,omitempty"`
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// getArtifactExtraction returns the paths of the built image which the build
+// strategy's environment requests be extracted, and the directory of the
+// build pod they are extracted to, if any.
+func getArtifactExtraction(build *buildapiv1.Build) ([]string, string, error) {
+	value, _ := buildStrategyEnv(build, builderutil.ArtifactPaths)
+	var paths []string
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		if !path.IsAbs(p) {
+			return nil, "", fmt.Errorf("invalid %s entry %q: it must be an absolute path", builderutil.ArtifactPaths, p)
+		}
+		paths = append(paths, path.Clean(p))
+	}
+	output, _ := buildStrategyEnv(build, builderutil.ArtifactsOutput)
+	switch {
+	case len(paths) == 0 && len(output) == 0:
+		return nil, "", nil
+	case len(paths) == 0:
+		return nil, "", fmt.Errorf("%s requires %s", builderutil.ArtifactsOutput, builderutil.ArtifactPaths)
+	case len(output) == 0:
+		return nil, "", fmt.Errorf("%s requires %s", builderutil.ArtifactPaths, builderutil.ArtifactsOutput)
+	case !filepath.IsAbs(output):
+		return nil, "", fmt.Errorf("invalid %s value %q: it must be an absolute path", builderutil.ArtifactsOutput, output)
+	}
+	return paths, output, nil
+}
+
+// extractImageArtifacts copies the paths of the local image name which were
+// requested for the build, such as compiled binaries, into the output
+// directory requested for the build, such as a volume shared with another
+// container of the build pod.  The artifacts of a multi-platform build are
+// copied into a subdirectory for each platform, named like linux-arm64.
+func extractImageArtifacts(client DockerClient, build *buildapiv1.Build, name, platform string) error {
+	paths, output, err := getArtifactExtraction(build)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	if len(platform) > 0 {
+		output = filepath.Join(output, strings.Replace(platform, "/", "-", -1))
+	}
+	mounter, ok := client.(imageMounter)
+	if !ok {
+		return fmt.Errorf("extracting artifacts is not supported by this build client")
+	}
+	root, unmount, err := mounter.MountImage(name)
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v", name, err)
+	}
+	defer unmount()
+	log.V(0).Infof("\nExtracting artifacts to %s ...", output)
+	for _, p := range paths {
+		// symbolic links are resolved within the image's root filesystem
+		source, err := resolveInRoot(root, p)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("unable to extract %s from the image: %v", p, err)
+		}
+		if err := copyImageSourceFromFilesytem(source, output); err != nil {
+			return fmt.Errorf("unable to extract %s from the image: %v", p, err)
+		}
+		log.V(0).Infof("Extracted %s", p)
+	}
+	return nil
+}
+
+// resolveInRoot returns the location of p, an absolute path within the root
+// filesystem at root, resolving its symbolic links as if root were the root
+// directory, so that no link leads outside of it.
+func resolveInRoot(root, p string) (string, error) {
+	const maxLinks = 255
+	links := 0
+	resolved := "/"
+	remaining := strings.Split(path.Clean(p), "/")
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+		if len(component) == 0 || component == "." {
+			continue
+		}
+		next := path.Join(resolved, component)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// missing paths are reported by the caller
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetArtifactExtraction(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          []corev1.EnvVar
+		expectPaths  []string
+		expectOutput string
+		expectErr    bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "paths and output",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_ARTIFACT_PATHS", Value: "/usr/local/bin/app, /dist/"},
+				{Name: "BUILD_ARTIFACTS_OUTPUT", Value: "/artifacts"},
+			},
+			expectPaths:  []string{"/usr/local/bin/app", "/dist"},
+			expectOutput: "/artifacts",
+		},
+		{
+			name:      "paths without output",
+			env:       []corev1.EnvVar{{Name: "BUILD_ARTIFACT_PATHS", Value: "/dist"}},
+			expectErr: true,
+		},
+		{
+			name:      "output without paths",
+			env:       []corev1.EnvVar{{Name: "BUILD_ARTIFACTS_OUTPUT", Value: "/artifacts"}},
+			expectErr: true,
+		},
+		{
+			name: "relative path",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_ARTIFACT_PATHS", Value: "dist"},
+				{Name: "BUILD_ARTIFACTS_OUTPUT", Value: "/artifacts"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			paths, output, err := getArtifactExtraction(build)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(paths, test.expectPaths) || output != test.expectOutput {
+				t.Errorf("expected %v, %q, got %v, %q", test.expectPaths, test.expectOutput, paths, output)
+			}
+		})
+	}
+}
+
+func TestExtractImageArtifacts(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	output := filepath.Join(tmp, "output")
+	writeTestFile(t, filepath.Join(root, "dist", "app.whl"), "wheel")
+	writeTestFile(t, filepath.Join(root, "usr", "bin", "app"), "binary")
+	// a link out of the image resolves within it
+	writeTestFile(t, filepath.Join(tmp, "host", "secret"), "host")
+	if err := os.Symlink(filepath.Join(tmp, "host"), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_ARTIFACT_PATHS", Value: "/dist,/usr/bin/app"},
+			{Name: "BUILD_ARTIFACTS_OUTPUT", Value: output},
+		},
+	}
+	client := &fakeMountingClient{FakeDocker: NewFakeDockerClient(), root: root}
+	if err := extractImageArtifacts(client, build, "image", "linux/arm64"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range []string{"linux-arm64/dist/app.whl", "linux-arm64/app"} {
+		if _, err := os.Stat(filepath.Join(output, file)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", file, err)
+		}
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env[0].Value = "/escape/secret"
+	if err := extractImageArtifacts(client, build, "image", ""); err == nil {
+		t.Errorf("expected an error for a path which only exists outside of the image")
+	}
+}
@@ -0,0 +1,50 @@
+package attestation
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// PayloadType is the DSSE payload type of in-toto statements.
+	PayloadType = "application/vnd.in-toto+json"
+	// EnvelopeMediaType is the media type of DSSE envelopes.
+	EnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+)
+
+// Envelope is a DSSE envelope holding a signed payload.  Payload and Sig are
+// base64 encoded when marshalled to JSON.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a signature of an envelope's payload.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// PAE returns the DSSE pre-authentication encoding of payload, which is what
+// is actually signed.
+func PAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// Sign returns a DSSE envelope holding statement, signed by signer.
+func Sign(statement *Statement, signer Signer) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(PAE(PayloadType, payload))
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign the statement: %v", err)
+	}
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     payload,
+		Signatures:  []Signature{{KeyID: signer.KeyID(), Sig: sig}},
+	}, nil
+}
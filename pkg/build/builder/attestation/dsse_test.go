@@ -0,0 +1,70 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	statement := NewProvenance(Build{Image: "registry.example.com/ns/app", Digest: "sha256:abc"})
+	verify := map[string]func(pae, sig []byte) bool{
+		"ecdsa": func(pae, sig []byte) bool {
+			sum := sha256.Sum256(pae)
+			return ecdsa.VerifyASN1(&ecKey.PublicKey, sum[:], sig)
+		},
+		"rsa": func(pae, sig []byte) bool {
+			sum := sha256.Sum256(pae)
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, sum[:], sig) == nil
+		},
+		"ed25519": func(pae, sig []byte) bool {
+			return ed25519.Verify(edKey.Public().(ed25519.PublicKey), pae, sig)
+		},
+	}
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecKey, "rsa": rsaKey, "ed25519": edKey} {
+		signer, err := NewSigner(key)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		envelope, err := Sign(statement, signer)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if envelope.PayloadType != PayloadType || len(envelope.Signatures) != 1 || envelope.Signatures[0].KeyID != signer.KeyID() {
+			t.Errorf("%s: unexpected envelope %#v", name, envelope)
+			continue
+		}
+		var decoded Statement
+		if err := json.Unmarshal(envelope.Payload, &decoded); err != nil || decoded.Subject[0].Name != "registry.example.com/ns/app" {
+			t.Errorf("%s: unexpected payload %s: %v", name, string(envelope.Payload), err)
+		}
+		if !verify[name](PAE(envelope.PayloadType, envelope.Payload), envelope.Signatures[0].Sig) {
+			t.Errorf("%s: the signature does not verify", name)
+		}
+	}
+}
+
+func TestPAE(t *testing.T) {
+	if pae := string(PAE("http://example.com/HelloWorld", []byte("hello world"))); pae != "DSSEv1 29 http://example.com/HelloWorld 11 hello world" {
+		t.Errorf("unexpected PAE %q", pae)
+	}
+}
@@ -0,0 +1,167 @@
+// Package attestation generates signed in-toto statements of the SLSA
+// provenance of the images built by the builder.
+package attestation
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// StatementType is the type of in-toto statements.
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// ProvenancePredicateType is the type of SLSA provenance predicates.
+	ProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	// BuildType identifies builds run by this builder in provenance.
+	BuildType = "https://github.com/openshift/builder/build@v1"
+)
+
+// Statement is an in-toto statement, asserting a predicate about its
+// subjects.
+type Statement struct {
+	Type          string      `json:"_type"`
+	Subject       []Subject   `json:"subject"`
+	PredicateType string      `json:"predicateType"`
+	Predicate     interface{} `json:"predicate"`
+}
+
+// Subject is an artifact which a statement is about.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is a SLSA provenance predicate, describing how its subject was
+// built.
+type Provenance struct {
+	Builder    ProvenanceBuilder `json:"builder"`
+	BuildType  string            `json:"buildType"`
+	Invocation Invocation        `json:"invocation"`
+	Metadata   *Metadata         `json:"metadata,omitempty"`
+	Materials  []Material        `json:"materials,omitempty"`
+}
+
+// ProvenanceBuilder identifies the builder which ran the build.
+type ProvenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+// Invocation describes what the build was asked to do.
+type Invocation struct {
+	ConfigSource ConfigSource      `json:"configSource,omitempty"`
+	Parameters   map[string]string `json:"parameters,omitempty"`
+}
+
+// ConfigSource is the source from which the build's recipe was taken.
+type ConfigSource struct {
+	URI        string            `json:"uri,omitempty"`
+	Digest     map[string]string `json:"digest,omitempty"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+// Metadata holds the times at which the build ran.
+type Metadata struct {
+	BuildInvocationID string     `json:"buildInvocationId,omitempty"`
+	BuildStartedOn    *time.Time `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn   *time.Time `json:"buildFinishedOn,omitempty"`
+}
+
+// Material is an input to the build, such as its source or a base image.
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Build describes a build, from which a provenance statement is generated.
+type Build struct {
+	// Image is the name of the repository the image was pushed to
+	Image string
+	// Digest is the digest of the pushed image
+	Digest string
+	// BuilderID is the URI identifying the builder
+	BuilderID string
+	// InvocationID uniquely identifies the build
+	InvocationID string
+	// SourceURI is the URI of the git repository the image was built from,
+	// if any
+	SourceURI string
+	// SourceCommit is the commit of SourceURI which was built
+	SourceCommit string
+	// EntryPoint is the path, relative to the source, of the build's
+	// recipe, such as a Dockerfile
+	EntryPoint string
+	// BaseImages are the references of the images the build started from,
+	// including the builder image of a source build
+	BaseImages []string
+	// Parameters are the build's configurable parameters
+	Parameters map[string]string
+	// StartedOn and FinishedOn are when the build started and finished
+	StartedOn  *time.Time
+	FinishedOn *time.Time
+}
+
+// NewProvenance returns an in-toto statement of the SLSA provenance of the
+// image built by build.
+func NewProvenance(build Build) *Statement {
+	provenance := Provenance{
+		Builder:   ProvenanceBuilder{ID: build.BuilderID},
+		BuildType: BuildType,
+		Invocation: Invocation{
+			Parameters: build.Parameters,
+		},
+	}
+	if len(build.SourceURI) > 0 {
+		source := Material{URI: gitURI(build.SourceURI)}
+		if len(build.SourceCommit) > 0 {
+			source.Digest = map[string]string{"sha1": build.SourceCommit}
+		}
+		provenance.Invocation.ConfigSource = ConfigSource{
+			URI:        source.URI,
+			Digest:     source.Digest,
+			EntryPoint: build.EntryPoint,
+		}
+		provenance.Materials = append(provenance.Materials, source)
+	}
+	for _, image := range build.BaseImages {
+		material := Material{URI: "pkg:docker/" + image}
+		if i := strings.Index(image, "@"); i != -1 {
+			material.URI = "pkg:docker/" + image[:i]
+			material.Digest = splitDigest(image[i+1:])
+		}
+		provenance.Materials = append(provenance.Materials, material)
+	}
+	if len(build.InvocationID) > 0 || build.StartedOn != nil || build.FinishedOn != nil {
+		provenance.Metadata = &Metadata{
+			BuildInvocationID: build.InvocationID,
+			BuildStartedOn:    build.StartedOn,
+			BuildFinishedOn:   build.FinishedOn,
+		}
+	}
+	return &Statement{
+		Type: StatementType,
+		Subject: []Subject{
+			{Name: build.Image, Digest: splitDigest(build.Digest)},
+		},
+		PredicateType: ProvenancePredicateType,
+		Predicate:     provenance,
+	}
+}
+
+// gitURI returns uri in the git+ form used for materials, unless it is
+// already a URI with an explicit git scheme.
+func gitURI(uri string) string {
+	if strings.HasPrefix(uri, "git+") || strings.HasPrefix(uri, "git://") {
+		return uri
+	}
+	return "git+" + uri
+}
+
+// splitDigest returns digest, such as "sha256:abc", as an in-toto digest
+// set.
+func splitDigest(digest string) map[string]string {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return map[string]string{parts[0]: parts[1]}
+}
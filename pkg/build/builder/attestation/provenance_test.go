@@ -0,0 +1,77 @@
+package attestation
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewProvenance(t *testing.T) {
+	started := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+	statement := NewProvenance(Build{
+		Image:        "registry.example.com/ns/app",
+		Digest:       "sha256:abc",
+		BuilderID:    "https://github.com/openshift/builder@v4.5.0",
+		InvocationID: "uid",
+		SourceURI:    "https://github.com/openshift/ruby-hello-world",
+		SourceCommit: "0123456789",
+		EntryPoint:   "Dockerfile",
+		BaseImages:   []string{"centos@sha256:def", "busybox:latest"},
+		Parameters:   map[string]string{"strategy": "Docker"},
+		StartedOn:    &started,
+		FinishedOn:   &finished,
+	})
+
+	data, err := json.Marshal(statement)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded := struct {
+		Type          string    `json:"_type"`
+		Subject       []Subject `json:"subject"`
+		PredicateType string    `json:"predicateType"`
+		Predicate     struct {
+			Builder    ProvenanceBuilder `json:"builder"`
+			BuildType  string            `json:"buildType"`
+			Invocation Invocation        `json:"invocation"`
+			Metadata   Metadata          `json:"metadata"`
+			Materials  []Material        `json:"materials"`
+		} `json:"predicate"`
+	}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.Type != StatementType || decoded.PredicateType != ProvenancePredicateType || decoded.Predicate.BuildType != BuildType {
+		t.Errorf("unexpected statement types: %s", string(data))
+	}
+	if expected := []Subject{{Name: "registry.example.com/ns/app", Digest: map[string]string{"sha256": "abc"}}}; !reflect.DeepEqual(decoded.Subject, expected) {
+		t.Errorf("expected subject %v, got %v", expected, decoded.Subject)
+	}
+	if decoded.Predicate.Builder.ID != "https://github.com/openshift/builder@v4.5.0" {
+		t.Errorf("unexpected builder %v", decoded.Predicate.Builder)
+	}
+	expectedSource := ConfigSource{
+		URI:        "git+https://github.com/openshift/ruby-hello-world",
+		Digest:     map[string]string{"sha1": "0123456789"},
+		EntryPoint: "Dockerfile",
+	}
+	if !reflect.DeepEqual(decoded.Predicate.Invocation.ConfigSource, expectedSource) {
+		t.Errorf("expected config source %v, got %v", expectedSource, decoded.Predicate.Invocation.ConfigSource)
+	}
+	if decoded.Predicate.Invocation.Parameters["strategy"] != "Docker" {
+		t.Errorf("unexpected parameters %v", decoded.Predicate.Invocation.Parameters)
+	}
+	expectedMaterials := []Material{
+		{URI: "git+https://github.com/openshift/ruby-hello-world", Digest: map[string]string{"sha1": "0123456789"}},
+		{URI: "pkg:docker/centos", Digest: map[string]string{"sha256": "def"}},
+		{URI: "pkg:docker/busybox:latest"},
+	}
+	if !reflect.DeepEqual(decoded.Predicate.Materials, expectedMaterials) {
+		t.Errorf("expected materials %v, got %v", expectedMaterials, decoded.Predicate.Materials)
+	}
+	if decoded.Predicate.Metadata.BuildInvocationID != "uid" || !decoded.Predicate.Metadata.BuildStartedOn.Equal(started) || !decoded.Predicate.Metadata.BuildFinishedOn.Equal(finished) {
+		t.Errorf("unexpected metadata %s", string(data))
+	}
+}
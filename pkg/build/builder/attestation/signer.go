@@ -0,0 +1,246 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Signer signs attestations.
+type Signer interface {
+	// KeyID returns a hint identifying the key which signs, if any
+	KeyID() string
+	// Sign returns the signature of data
+	Sign(data []byte) ([]byte, error)
+	// Certificates returns the PEM encoded certificate chain binding the
+	// signing key to an identity, if there is one
+	Certificates() []byte
+}
+
+// keySigner is a Signer which signs with a private key.
+type keySigner struct {
+	key          crypto.Signer
+	keyID        string
+	certificates []byte
+}
+
+// NewSigner returns a Signer which signs with key, which must be an ECDSA,
+// RSA or Ed25519 private key.
+func NewSigner(key crypto.Signer) (Signer, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &keySigner{key: key, keyID: hex.EncodeToString(sum[:])}, nil
+}
+
+// LoadSigner returns a Signer which signs with the unencrypted PEM encoded
+// private key at path, such as one mounted from a secret.
+func LoadSigner(path string) (Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the signing key %s: %v", path, err)
+	}
+	return NewSigner(key)
+}
+
+// parsePrivateKey parses the first PEM encoded private key in data.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		default:
+			if strings.Contains(block.Type, "ENCRYPTED") {
+				return nil, fmt.Errorf("encrypted private keys are not supported")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+}
+
+func (s *keySigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *keySigner) Certificates() []byte {
+	return s.certificates
+}
+
+func (s *keySigner) Sign(data []byte) ([]byte, error) {
+	switch s.key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		return s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	default:
+		// Ed25519 signs the message itself.
+		return s.key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+}
+
+// NewKeylessSigner returns a Signer which signs with an ephemeral key, bound
+// to the identity in the OIDC token by a short-lived certificate issued by
+// the Fulcio certificate authority at fulcioURL.  Signatures are not
+// recorded in a transparency log.
+func NewKeylessSigner(fulcioURL, token string) (Signer, error) {
+	subject, err := tokenSubject(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer := &keySigner{key: key}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	// Fulcio requires proof that the requester holds the private key, as a
+	// signature of the token's subject.
+	proof, err := signer.Sign([]byte(subject))
+	if err != nil {
+		return nil, err
+	}
+	signer.certificates, err = requestCertificate(fulcioURL, token, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), proof)
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// tokenSubject returns the subject, or the email address if there is one,
+// of the identity in the OIDC token.
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("the identity token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode the identity token: %v", err)
+	}
+	claims := struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("unable to decode the identity token: %v", err)
+	}
+	if len(claims.Email) > 0 {
+		return claims.Email, nil
+	}
+	if len(claims.Subject) == 0 {
+		return "", fmt.Errorf("the identity token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// fulcioCertificateRequest is the body of a Fulcio v2 signingCert request.
+type fulcioCertificateRequest struct {
+	Credentials struct {
+		OIDCIdentityToken string `json:"oidcIdentityToken"`
+	} `json:"credentials"`
+	PublicKeyRequest struct {
+		PublicKey struct {
+			Algorithm string `json:"algorithm"`
+			Content   string `json:"content"`
+		} `json:"publicKey"`
+		ProofOfPossession []byte `json:"proofOfPossession"`
+	} `json:"publicKeyRequest"`
+}
+
+type fulcioChain struct {
+	Chain struct {
+		Certificates []string `json:"certificates"`
+	} `json:"chain"`
+}
+
+// fulcioCertificateResponse is the body of a Fulcio v2 signingCert
+// response, which holds one of the two kinds of certificate chain.
+type fulcioCertificateResponse struct {
+	SignedCertificateEmbeddedSCT *fulcioChain `json:"signedCertificateEmbeddedSct"`
+	SignedCertificateDetachedSCT *fulcioChain `json:"signedCertificateDetachedSct"`
+}
+
+// requestCertificate asks the Fulcio instance at fulcioURL for a certificate
+// binding publicKey to the identity in token, and returns its PEM encoded
+// chain.
+func requestCertificate(fulcioURL, token string, publicKey, proof []byte) ([]byte, error) {
+	request := fulcioCertificateRequest{}
+	request.Credentials.OIDCIdentityToken = strings.TrimSpace(token)
+	request.PublicKeyRequest.PublicKey.Algorithm = "ECDSA"
+	request.PublicKeyRequest.PublicKey.Content = string(publicKey)
+	request.PublicKeyRequest.ProofOfPossession = proof
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(fulcioURL, "/")+"/api/v2/signingCert", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("requesting a signing certificate from %s failed: %s: %s", fulcioURL, resp.Status, strings.TrimSpace(string(data)))
+	}
+	response := fulcioCertificateResponse{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("unable to decode the signing certificate from %s: %v", fulcioURL, err)
+	}
+	chain := response.SignedCertificateEmbeddedSCT
+	if chain == nil {
+		chain = response.SignedCertificateDetachedSCT
+	}
+	if chain == nil || len(chain.Chain.Certificates) == 0 {
+		return nil, fmt.Errorf("no signing certificate was returned by %s", fulcioURL)
+	}
+	certificates := []byte{}
+	for _, certificate := range chain.Chain.Certificates {
+		certificates = append(certificates, strings.TrimSpace(certificate)+"\n"...)
+	}
+	return certificates, nil
+}
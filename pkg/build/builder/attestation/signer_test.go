@@ -0,0 +1,152 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing-key")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests := []struct {
+		name      string
+		content   []byte
+		expectErr bool
+	}{
+		{
+			name:    "ec",
+			content: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+		},
+		{
+			name:    "rsa",
+			content: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		},
+		{
+			name: "pkcs8 after parameters",
+			content: append(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{0x06}}),
+				pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})...),
+		},
+		{
+			name:      "encrypted",
+			content:   pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: []byte("secret")}),
+			expectErr: true,
+		},
+		{
+			name:      "no key",
+			content:   []byte("not a key"),
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(path, test.content, 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+		signer, err := LoadSigner(path)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(signer.KeyID()) != 64 || len(signer.Certificates()) != 0 {
+			t.Errorf("%s: unexpected signer %#v", test.name, signer)
+		}
+		if _, err := signer.Sign([]byte("data")); err != nil {
+			t.Errorf("%s: unexpected error signing: %v", test.name, err)
+		}
+	}
+}
+
+func TestNewKeylessSigner(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:ns:builder"}`))
+	token := "header." + claims + ".signature"
+	certificates := []string{
+		"-----BEGIN CERTIFICATE-----\nbGVhZg==\n-----END CERTIFICATE-----",
+		"-----BEGIN CERTIFICATE-----\ncm9vdA==\n-----END CERTIFICATE-----\n",
+	}
+
+	var path string
+	var request fulcioCertificateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := fulcioCertificateResponse{SignedCertificateEmbeddedSCT: &fulcioChain{}}
+		response.SignedCertificateEmbeddedSCT.Chain.Certificates = certificates
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	signer, err := NewKeylessSigner(server.URL, token+"\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/api/v2/signingCert" || request.Credentials.OIDCIdentityToken != token {
+		t.Errorf("unexpected request to %s: %#v", path, request)
+	}
+	block, _ := pem.Decode([]byte(request.PublicKeyRequest.PublicKey.Content))
+	if block == nil {
+		t.Fatalf("no public key in the request: %#v", request)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := sha256.Sum256([]byte("system:serviceaccount:ns:builder"))
+	if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), sum[:], request.PublicKeyRequest.ProofOfPossession) {
+		t.Errorf("the proof of possession does not verify")
+	}
+	if expected := certificates[0] + "\n" + certificates[1]; string(signer.Certificates()) != expected {
+		t.Errorf("expected certificates %q, got %q", expected, string(signer.Certificates()))
+	}
+
+	if _, err := NewKeylessSigner(server.URL, "not a token"); err == nil {
+		t.Errorf("expected an error for an invalid token")
+	}
+}
@@ -0,0 +1,197 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	idocker "github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// maxThrottledRead is the most bytes which a throttled reader reads at once,
+// so that the bytes read are spread out over time.
+const maxThrottledRead = 32 * 1024
+
+var (
+	// pullLimiter limits the rate at which the build reads the blobs it
+	// pulls from registries, if set
+	pullLimiter *bandwidthLimiter
+	// pushLimiter limits the rate at which the build sends the blobs it
+	// pushes to registries, if set
+	pushLimiter *bandwidthLimiter
+
+	throttleRegistryTransport sync.Once
+)
+
+// ConfigureBandwidthLimits applies the limits on the bandwidth of the pulls
+// and pushes of the build requested by the build strategy's environment, if
+// any.  The limits are applied by the docker transport of containers/image,
+// which every pull and push of the build's images goes through, and are
+// shared by all of the build's pulls, or pushes, at once.
+func ConfigureBandwidthLimits(build *buildapiv1.Build) error {
+	var err error
+	if pullLimiter, err = newBandwidthLimiter(build, builderutil.PullBandwidthLimit, "pulls"); err != nil {
+		return err
+	}
+	if pushLimiter, err = newBandwidthLimiter(build, builderutil.PushBandwidthLimit, "pushes"); err != nil {
+		return err
+	}
+	if pullLimiter != nil || pushLimiter != nil {
+		throttleRegistryTransport.Do(func() {
+			transports.Delete(idocker.Transport.Name())
+			transports.Register(throttledTransport{ImageTransport: idocker.Transport})
+		})
+	}
+	return nil
+}
+
+// newBandwidthLimiter returns a limiter for the rate, in bytes per second,
+// of the transfers in the named build strategy environment variable, or nil
+// if it is not set.
+func newBandwidthLimiter(build *buildapiv1.Build, name, transfers string) (*bandwidthLimiter, error) {
+	value, _ := buildStrategyEnv(build, name)
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.Value() <= 0 {
+		return nil, fmt.Errorf("invalid %s value %q: must be a positive number of bytes per second such as 20Mi", name, value)
+	}
+	log.V(2).Infof("Limiting the bandwidth of %s to %s per second.", transfers, value)
+	return &bandwidthLimiter{bytesPerSecond: quantity.Value()}, nil
+}
+
+// bandwidthLimiter spaces out the bytes transferred by all of its callers to
+// no more than bytesPerSecond.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+
+	lock sync.Mutex
+	// next is when the bytes transferred so far have taken their share of
+	// the bandwidth
+	next time.Time
+}
+
+// wait records the transfer of n bytes and waits until the bytes transferred
+// before them have taken their share of the bandwidth, or ctx is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledTransport is the docker transport, whose image sources read
+// blobs no faster than pullLimiter allows and whose destinations send them
+// no faster than pushLimiter allows.
+type throttledTransport struct {
+	types.ImageTransport
+}
+
+func (t throttledTransport) ParseReference(reference string) (types.ImageReference, error) {
+	ref, err := t.ImageTransport.ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReference{ImageReference: ref}, nil
+}
+
+// throttledReference is an image reference whose sources and destinations
+// are throttled.
+type throttledReference struct {
+	types.ImageReference
+}
+
+func (r *throttledReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := r.ImageReference.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledSource{ImageSource: src}, nil
+}
+
+func (r *throttledReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := r.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledDestination{ImageDestination: dest}, nil
+}
+
+// throttledSource is an image source whose blobs are read no faster than
+// pullLimiter allows.
+type throttledSource struct {
+	types.ImageSource
+}
+
+func (s *throttledSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, size, err := s.ImageSource.GetBlob(ctx, info, cache)
+	if err != nil || pullLimiter == nil {
+		return blob, size, err
+	}
+	return &throttledReadCloser{Reader: &throttledReader{ctx: ctx, r: blob, limiter: pullLimiter}, Closer: blob}, size, nil
+}
+
+// throttledDestination is an image destination whose blobs are sent no
+// faster than pushLimiter allows.
+type throttledDestination struct {
+	types.ImageDestination
+}
+
+func (d *throttledDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	if pushLimiter != nil {
+		stream = &throttledReader{ctx: ctx, r: stream, limiter: pushLimiter}
+	}
+	return d.ImageDestination.PutBlob(ctx, stream, inputInfo, cache, isConfig)
+}
+
+// throttledReader reads from r no faster than limiter allows.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > maxThrottledRead {
+		b = b[:maxThrottledRead]
+	}
+	n, err := r.r.Read(b)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+type throttledReadCloser struct {
+	io.Reader
+	io.Closer
+}
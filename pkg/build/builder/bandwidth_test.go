@@ -0,0 +1,157 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestConfigureBandwidthLimits(t *testing.T) {
+	defer func() { pullLimiter, pushLimiter = nil, nil }()
+
+	if err := ConfigureBandwidthLimits(testBuildWithEnv()); err != nil {
+		t.Fatal(err)
+	}
+	if pullLimiter != nil || pushLimiter != nil {
+		t.Errorf("expected no limits by default")
+	}
+
+	build := testBuildWithEnv(
+		corev1.EnvVar{Name: "BUILD_PULL_BANDWIDTH_LIMIT", Value: "20Mi"},
+		corev1.EnvVar{Name: "BUILD_PUSH_BANDWIDTH_LIMIT", Value: " 1M "},
+	)
+	if err := ConfigureBandwidthLimits(build); err != nil {
+		t.Fatal(err)
+	}
+	if pullLimiter == nil || pullLimiter.bytesPerSecond != 20*1024*1024 {
+		t.Errorf("unexpected pull limit %#v", pullLimiter)
+	}
+	if pushLimiter == nil || pushLimiter.bytesPerSecond != 1000*1000 {
+		t.Errorf("unexpected push limit %#v", pushLimiter)
+	}
+	ref, err := alltransports.ParseImageName("docker://registry.example.com/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ref.(*throttledReference); !ok {
+		t.Errorf("expected the docker transport to be throttled, got %T", ref)
+	}
+	if ref.Transport().Name() != "docker" || ref.DockerReference().String() != "registry.example.com/app:latest" {
+		t.Errorf("unexpected reference %s to %v", ref.Transport().Name(), ref.DockerReference())
+	}
+
+	for _, value := range []string{"fast", "0", "-1Mi"} {
+		if err := ConfigureBandwidthLimits(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_PUSH_BANDWIDTH_LIMIT", Value: value})); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	limiter := &bandwidthLimiter{bytesPerSecond: 100 * 1024}
+	data := make([]byte, 40*1024)
+	start := time.Now()
+	// the readers share the limit, and each read waits until the bytes read
+	// before it have taken their share, so 80KiB read 32KiB at a time take
+	// until the first 72KiB have
+	for i := 0; i < 2; i++ {
+		r := &throttledReader{ctx: context.Background(), r: bytes.NewReader(data), limiter: limiter}
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil || n != int64(len(data)) {
+			t.Fatalf("expected to read %d bytes, got %d: %v", len(data), n, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 650*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected reading 80KiB at 100KiB/s to take 0.72s, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &throttledReader{ctx: ctx, r: bytes.NewReader(data), limiter: &bandwidthLimiter{bytesPerSecond: 1}}
+	if _, err := io.Copy(ioutil.Discard, r); err != context.Canceled {
+		t.Errorf("expected the read to be cancelled, got %v", err)
+	}
+}
+
+// fakeBlobReference is a reference whose sources return blob for every blob
+// and whose destinations read what they are sent into received.
+type fakeBlobReference struct {
+	types.ImageReference
+	blob     []byte
+	received []byte
+}
+
+func (r *fakeBlobReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return &fakeBlobSource{ref: r}, nil
+}
+
+func (r *fakeBlobReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return &fakeBlobDestination{ref: r}, nil
+}
+
+type fakeBlobSource struct {
+	types.ImageSource
+	ref *fakeBlobReference
+}
+
+func (s *fakeBlobSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(bytes.NewReader(s.ref.blob)), int64(len(s.ref.blob)), nil
+}
+
+type fakeBlobDestination struct {
+	types.ImageDestination
+	ref *fakeBlobReference
+}
+
+func (d *fakeBlobDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	if _, ok := stream.(*throttledReader); !ok {
+		return types.BlobInfo{}, io.ErrUnexpectedEOF
+	}
+	received, err := ioutil.ReadAll(stream)
+	d.ref.received = received
+	return inputInfo, err
+}
+
+func TestThrottledReference(t *testing.T) {
+	defer func() { pullLimiter, pushLimiter = nil, nil }()
+	pullLimiter = &bandwidthLimiter{bytesPerSecond: 1024 * 1024}
+	pushLimiter = &bandwidthLimiter{bytesPerSecond: 1024 * 1024}
+
+	fake := &fakeBlobReference{blob: []byte("layer")}
+	ref := &throttledReference{ImageReference: fake}
+	ctx := context.Background()
+
+	src, err := ref.NewImageSource(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _, err := src.GetBlob(ctx, types.BlobInfo{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := blob.(*throttledReadCloser); !ok {
+		t.Errorf("expected the blob to be throttled, got %T", blob)
+	}
+	if data, err := ioutil.ReadAll(blob); err != nil || string(data) != "layer" {
+		t.Errorf("unexpected blob %q: %v", data, err)
+	}
+
+	dest, err := ref.NewImageDestination(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dest.PutBlob(ctx, bytes.NewReader([]byte("pushed")), types.BlobInfo{}, nil, false); err != nil {
+		t.Fatalf("expected the pushed blob to be throttled: %v", err)
+	}
+	if string(fake.received) != "pushed" {
+		t.Errorf("unexpected pushed blob %q", fake.received)
+	}
+}
@@ -0,0 +1,220 @@
+package builder
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// binaryProgressInterval is how often the progress of receiving a binary
+// input is logged.
+const binaryProgressInterval = 10 * time.Second
+
+var (
+	// gzipMagic starts gzip streams.
+	gzipMagic = []byte{0x1f, 0x8b}
+	// tarMagic is at tarMagicOffset in the first header of ustar, pax and
+	// GNU tar archives.
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// GetBinaryInputMaxSize returns the most bytes that the binary input of
+// build may be, or zero if it is not limited.
+func GetBinaryInputMaxSize(build *buildapiv1.Build) (int64, error) {
+	value, _ := buildStrategyEnv(build, builderutil.BinaryInputMaxSize)
+	if value = strings.TrimSpace(value); len(value) == 0 {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid %s value %q: must be a positive quantity such as 2Gi", builderutil.BinaryInputMaxSize, value)
+	}
+	return quantity.Value(), nil
+}
+
+// binaryInputReader counts the bytes read from a binary input, logs how
+// many have been received as they arrive, and fails reads past the input's
+// maximum size.
+type binaryInputReader struct {
+	r io.Reader
+	// max is the most bytes which may be read, or zero if it is not limited
+	max      int64
+	n        int64
+	started  time.Time
+	reported time.Time
+	// err is set once the input exceeds max, and returned by every later
+	// read, since a reader which has buffered the input may not make any
+	err error
+}
+
+func newBinaryInputReader(r io.Reader, max int64) *binaryInputReader {
+	now := time.Now()
+	return &binaryInputReader{r: r, max: max, started: now, reported: now}
+}
+
+func (r *binaryInputReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.max > 0 && r.n > r.max {
+		r.err = fmt.Errorf("the binary input exceeds the maximum size of %d bytes set by %s", r.max, builderutil.BinaryInputMaxSize)
+		return n, r.err
+	}
+	if now := time.Now(); now.Sub(r.reported) >= binaryProgressInterval {
+		r.reported = now
+		log.V(0).Infof("Received %d bytes (%s) ...", r.n, r.rate(now))
+	}
+	return n, err
+}
+
+// rate returns how fast the input has been received until now.
+func (r *binaryInputReader) rate(now time.Time) string {
+	seconds := now.Sub(r.started).Seconds()
+	if seconds <= 0 {
+		return "0 bytes/s"
+	}
+	return fmt.Sprintf("%.0f bytes/s", float64(r.n)/seconds)
+}
+
+// done logs how much of the input was received, and how fast.
+func (r *binaryInputReader) done() {
+	now := time.Now()
+	log.V(0).Infof("Received %d bytes in %v (%s)", r.n, now.Sub(r.started).Round(time.Millisecond), r.rate(now))
+}
+
+// extractBinaryArchive extracts the archive streamed from in into dir as it
+// arrives.  Plain and gzipped tar archives are extracted directly; others,
+// such as zip archives, are handed to bsdtar.
+func extractBinaryArchive(in io.Reader, dir string) error {
+	br := bufio.NewReaderSize(in, 64*1024)
+	header, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTarStream(gz, dir)
+	case len(header) > tarMagicOffset && bytes.HasPrefix(header[tarMagicOffset:], tarMagic):
+		return extractTarStream(br, dir)
+	}
+
+	args := []string{"-x", "-o", "-m", "-f", "-", "-C", dir}
+	if log.Is(6) {
+		args = append(args, "-v")
+	}
+	cmd := exec.Command("bsdtar", args...)
+	cmd.Stdin = br
+	out, err := cmd.CombinedOutput()
+	log.V(4).Infof("Extracting...\n%s", string(out))
+	return err
+}
+
+// extractTarStream extracts the tar stream r into dir, as bsdtar -x -o -m
+// would: the files are owned by the builder, are modified when they are
+// extracted, and are never written outside of dir.
+func extractTarStream(r io.Reader, dir string) error {
+	dir = filepath.Clean(dir)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, err := binaryEntryPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			continue
+		}
+		log.V(6).Infof("x %s", hdr.Name)
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink:
+		default:
+			log.V(4).Infof("Skipping %s, which is not a file, directory or link", hdr.Name)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, path)
+		case tar.TypeLink:
+			var target string
+			if target, err = binaryEntryPath(dir, hdr.Linkname); err == nil {
+				err = os.Link(target, path)
+			}
+		default:
+			err = writeBinaryEntry(tr, path, mode)
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %v", hdr.Name, err)
+		}
+	}
+}
+
+// writeBinaryEntry writes the contents of the file r to a new file at path.
+func writeBinaryEntry(r io.Reader, path string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// binaryEntryPath returns where the archive entry name is extracted to in
+// dir, refusing names which are absolute, lead out of dir, or lead through
+// a symbolic link that an earlier entry extracted.
+func binaryEntryPath(dir, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.Contains("/"+filepath.ToSlash(name)+"/", "/../") {
+		return "", fmt.Errorf("refusing to extract %q outside of the build directory", name)
+	}
+	dir = filepath.Clean(dir)
+	path := filepath.Join(dir, name)
+	for parent := filepath.Dir(path); parent != dir && strings.HasPrefix(parent, dir); parent = filepath.Dir(parent) {
+		if st, err := os.Lstat(parent); err == nil && st.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to extract %q through the symbolic link %s", name, parent)
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+type binaryEntry struct {
+	name, linkname, contents string
+	typeflag                 byte
+}
+
+func binaryTar(t *testing.T, entries []binaryEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.linkname, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.contents))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractInputBinary(t *testing.T) {
+	archive := binaryTar(t, []binaryEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "src/", typeflag: tar.TypeDir},
+		{name: "src/main.go", contents: "package main\n", typeflag: tar.TypeReg},
+		{name: "Dockerfile", contents: "FROM scratch\n", typeflag: tar.TypeReg},
+		{name: "link", linkname: "src/main.go", typeflag: tar.TypeSymlink},
+		{name: "hardlink", linkname: "Dockerfile", typeflag: tar.TypeLink},
+	})
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(archive)
+	gz.Close()
+
+	tests := []struct {
+		name    string
+		input   []byte
+		maxSize int64
+		escape  bool
+	}{
+		{name: "tar", input: archive},
+		{name: "gzipped tar", input: gzipped.Bytes()},
+		{name: "within the maximum size", input: archive, maxSize: int64(len(archive))},
+		{name: "too large", input: archive, maxSize: 1024, escape: true},
+		{name: "leaving the directory", input: binaryTar(t, []binaryEntry{{name: "../escaped", contents: "x", typeflag: tar.TypeReg}}), escape: true},
+		{name: "absolute", input: binaryTar(t, []binaryEntry{{name: "/escaped", contents: "x", typeflag: tar.TypeReg}}), escape: true},
+		{
+			name: "through a symlink",
+			input: binaryTar(t, []binaryEntry{
+				{name: "out", linkname: "..", typeflag: tar.TypeSymlink},
+				{name: "out/escaped", contents: "x", typeflag: tar.TypeReg},
+			}),
+			escape: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, err := ioutil.TempDir("", "binary")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(parent)
+			dir := filepath.Join(parent, "input")
+
+			err = ExtractInputBinary(bytes.NewReader(test.input), &buildapiv1.BinaryBuildSource{}, dir, test.maxSize)
+			if test.escape {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				if _, err := os.Stat(filepath.Join(parent, "escaped")); err == nil {
+					t.Errorf("a file was extracted outside of the build directory")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, expected := range map[string]string{
+				"src/main.go": "package main\n",
+				"Dockerfile":  "FROM scratch\n",
+				"link":        "package main\n",
+				"hardlink":    "FROM scratch\n",
+			} {
+				contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				} else if string(contents) != expected {
+					t.Errorf("%s: expected %q, got %q", name, expected, contents)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractInputBinaryAsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := &buildapiv1.BinaryBuildSource{AsFile: "app.jar"}
+	if err := ExtractInputBinary(bytes.NewBufferString("not an archive"), source, dir, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(dir, "app.jar")); err != nil || string(contents) != "not an archive" {
+		t.Errorf("unexpected contents %q: %v", contents, err)
+	}
+
+	source.AsFile = "big.jar"
+	if err := ExtractInputBinary(bytes.NewBufferString("not an archive"), source, dir, 4); err == nil {
+		t.Errorf("expected an error for an input larger than the maximum size")
+	}
+}
+
+func TestGetBinaryInputMaxSize(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  int64
+		expectErr bool
+	}{
+		{value: "", expected: 0},
+		{value: "2Gi", expected: 2 * 1024 * 1024 * 1024},
+		{value: "500M", expected: 500 * 1000 * 1000},
+		{value: "0", expectErr: true},
+		{value: "huge", expectErr: true},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_BINARY_MAX_SIZE", Value: test.value}},
+		}
+		size, err := GetBinaryInputMaxSize(build)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+		}
+		if size != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.value, test.expected, size)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+// buildCacheMarker is the file in each BuildConfig's cache directory whose
+// modification time records when the cache was last used by a build.
+const buildCacheMarker = ".last-used"
+
+// BuildCache manages a directory, typically on a persistent volume, which
+// keeps a blob cache for each BuildConfig between builds.  Caches are laid out
+// as <Root>/<namespace>/<buildconfig>.
+type BuildCache struct {
+	// Root is the directory under which the caches are kept.
+	Root string
+	// MaxSize, if greater than zero, is the number of bytes that all of the
+	// caches may use in total.
+	MaxSize int64
+	// MaxAge, if greater than zero, is how long a cache may go unused before
+	// it is removed.
+	MaxAge time.Duration
+}
+
+// Dir returns the cache directory for the BuildConfig that build was started
+// from, creating it if needed and marking it as used.  An empty string is
+// returned for builds which were not started from a BuildConfig.
+func (c *BuildCache) Dir(build *buildapiv1.Build) (string, error) {
+	name := build.Annotations[buildapiv1.BuildConfigAnnotation]
+	if len(name) == 0 {
+		name = build.Labels[buildapiv1.BuildConfigLabel]
+	}
+	if len(name) == 0 || len(build.Namespace) == 0 {
+		return "", nil
+	}
+	dir := filepath.Join(c.Root, build.Namespace, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	marker := filepath.Join(dir, buildCacheMarker)
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err := os.Chtimes(marker, now, now); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+type buildCacheEntry struct {
+	dir      string
+	lastUsed time.Time
+	size     int64
+}
+
+// Prune removes the caches which have not been used within MaxAge.  Then, if
+// the remaining caches use more than MaxSize, the least recently used caches
+// are removed, followed by the oldest files of the cache at keep, until they
+// fit.  The cache at keep is never removed entirely.
+func (c *BuildCache) Prune(keep string) error {
+	dirs, err := filepath.Glob(filepath.Join(c.Root, "*", "*"))
+	if err != nil {
+		return err
+	}
+	entries := []buildCacheEntry{}
+	var kept *buildCacheEntry
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		entry := buildCacheEntry{dir: dir, lastUsed: info.ModTime()}
+		if marker, err := os.Stat(filepath.Join(dir, buildCacheMarker)); err == nil {
+			entry.lastUsed = marker.ModTime()
+		}
+		if dir != keep && c.MaxAge > 0 && time.Since(entry.lastUsed) > c.MaxAge {
+			log.V(2).Infof("Removing build cache %s, unused since %s", dir, entry.lastUsed.Format(time.RFC3339))
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.size, err = dirSize(dir); err != nil {
+			return err
+		}
+		if dir == keep {
+			kept = &entry
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if c.MaxSize <= 0 {
+		return nil
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	if kept != nil {
+		total += kept.size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+	for _, entry := range entries {
+		if total <= c.MaxSize {
+			return nil
+		}
+		log.V(2).Infof("Removing build cache %s to reduce the cache size", entry.dir)
+		if err := os.RemoveAll(entry.dir); err != nil {
+			return err
+		}
+		total -= entry.size
+	}
+	if total <= c.MaxSize || kept == nil {
+		return nil
+	}
+	return pruneOldestFiles(kept.dir, total-c.MaxSize)
+}
+
+// pruneOldestFiles removes files from dir, oldest first, until at least
+// excess bytes have been freed.
+func pruneOldestFiles(dir string, excess int64) error {
+	type cachedFile struct {
+		path string
+		info os.FileInfo
+	}
+	files := []cachedFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && info.Name() != buildCacheMarker {
+			files = append(files, cachedFile{path: path, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, file := range files {
+		if excess <= 0 {
+			break
+		}
+		log.V(4).Infof("Removing %s to reduce the build cache size", file.path)
+		if err := os.Remove(file.path); err != nil {
+			return err
+		}
+		excess -= file.info.Size()
+	}
+	return nil
+}
+
+// dirSize returns the number of bytes used by the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,136 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestBuildCacheDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "build-cache-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	cache := &BuildCache{Root: root}
+
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "ns"}}
+	dir, err := cache.Dir(build)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if dir != "" {
+		t.Errorf("expected no cache for a build without a BuildConfig, got %s", dir)
+	}
+
+	build.Annotations = map[string]string{buildapiv1.BuildConfigAnnotation: "app"}
+	dir, err = cache.Dir(build)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if dir != filepath.Join(root, "ns", "app") {
+		t.Errorf("unexpected cache directory %s", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, buildCacheMarker)); err != nil {
+		t.Errorf("expected the cache to be marked as used: %v", err)
+	}
+}
+
+func TestBuildCachePrune(t *testing.T) {
+	now := time.Now()
+	// writeCache creates a cache used age ago holding files of the given
+	// sizes, each older than the last
+	writeCache := func(t *testing.T, root, name string, age time.Duration, sizes ...int) string {
+		dir := filepath.Join(root, "ns", name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("%v", err)
+		}
+		for i, size := range sizes {
+			path := filepath.Join(dir, string(rune('a'+i)))
+			if err := ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+				t.Fatalf("%v", err)
+			}
+			modTime := now.Add(-time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		marker := filepath.Join(dir, buildCacheMarker)
+		if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.Chtimes(marker, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return dir
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		maxAge  time.Duration
+		want    []string
+		removed []string
+	}{
+		{
+			name:    "no limits",
+			want:    []string{"current/a", "current/b", "recent/a", "old/a"},
+			removed: []string{},
+		},
+		{
+			name:    "max age",
+			maxAge:  24 * time.Hour,
+			want:    []string{"current/a", "current/b", "recent/a"},
+			removed: []string{"old"},
+		},
+		{
+			name:    "max size removes least recently used",
+			maxSize: 250,
+			want:    []string{"current/a", "current/b", "recent/a"},
+			removed: []string{"old"},
+		},
+		{
+			name:    "max size removes oldest files of current cache",
+			maxSize: 150,
+			want:    []string{"current/a"},
+			removed: []string{"old", "recent", "current/b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "build-cache-")
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer os.RemoveAll(root)
+			current := writeCache(t, root, "current", 0, 100, 100)
+			writeCache(t, root, "recent", time.Hour, 50)
+			writeCache(t, root, "old", 48*time.Hour, 50)
+
+			cache := &BuildCache{Root: root, MaxSize: test.maxSize, MaxAge: test.maxAge}
+			if err := cache.Prune(current); err != nil {
+				t.Fatalf("%v", err)
+			}
+			for _, path := range test.want {
+				if !exists(filepath.Join(root, "ns", path)) {
+					t.Errorf("expected %s to be kept", path)
+				}
+			}
+			for _, path := range test.removed {
+				if exists(filepath.Join(root, "ns", path)) {
+					t.Errorf("expected %s to be removed", path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	imagereference "github.com/openshift/library-go/pkg/image/reference"
+)
+
+// buildContextsDir is the directory of a Docker strategy build's context
+// into which its named contexts which are directories are copied.
+const buildContextsDir = ".openshift-build-contexts"
+
+// buildContextImagePrefixes are the prefixes of the named contexts which are
+// images.
+var buildContextImagePrefixes = []string{"image://", "docker-image://"}
+
+// buildContextName matches the names which a named context may have, which
+// are those of a Dockerfile stage.
+var buildContextName = regexp.MustCompile(`^[a-z][a-z0-9._-]*$`)
+
+// buildContext is a named context of a Docker strategy build, which its
+// Dockerfile can COPY --from by name.
+type buildContext struct {
+	name string
+	// path is the directory, relative to the root of the build's source,
+	// which the context holds, if it is not an image
+	path string
+	// image is the image which the context holds, if it is not a directory
+	image string
+}
+
+// getBuildContexts returns the named contexts which the build strategy's
+// environment adds to the build, in the order they are given.
+func getBuildContexts(build *buildapiv1.Build) ([]buildContext, error) {
+	value, ok := buildStrategyEnv(build, builderutil.BuildContexts)
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	var contexts []buildContext
+	names := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a name=source pair", builderutil.BuildContexts, value, entry)
+		}
+		context := buildContext{name: strings.TrimSpace(parts[0])}
+		source := strings.TrimSpace(parts[1])
+		if !buildContextName.MatchString(context.name) {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a valid name, which must be lowercase letters, digits, '.', '_' and '-', starting with a letter", builderutil.BuildContexts, value, context.name)
+		}
+		if names[context.name] {
+			return nil, fmt.Errorf("invalid %s value %q: %q is named more than once", builderutil.BuildContexts, value, context.name)
+		}
+		names[context.name] = true
+		for _, prefix := range buildContextImagePrefixes {
+			if strings.HasPrefix(source, prefix) {
+				ref, err := imagereference.Parse(strings.TrimPrefix(source, prefix))
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.BuildContexts, value, err)
+				}
+				// reduce the name to a minimal canonical form for the
+				// daemon, as for the strategy's From
+				context.image = ref.DaemonMinimal().Exact()
+				break
+			}
+		}
+		if len(context.image) == 0 {
+			path := filepath.Clean(source)
+			if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../") {
+				return nil, fmt.Errorf("invalid %s value %q: the directory of %q must be within the build's source", builderutil.BuildContexts, value, context.name)
+			}
+			context.path = path
+		}
+		contexts = append(contexts, context)
+	}
+	return contexts, nil
+}
+
+// addBuildContexts makes the named contexts available to the stages of the
+// Dockerfile node.  The FROM images and COPY --from flags which name an
+// image context are replaced by its image.  A stage, FROM scratch, holding
+// the files of each directory context is added, under its name, before the
+// stages of the Dockerfile, whose indices in COPY --from flags are shifted
+// to match.  A context may not have the name of a stage of the Dockerfile.
+func addBuildContexts(node *parser.Node, contexts []buildContext) error {
+	if node == nil || len(contexts) == 0 {
+		return nil
+	}
+	stages, _, err := dockerfileStages(node, nil)
+	if err != nil {
+		return err
+	}
+	stageNames := map[string]bool{}
+	for _, stage := range stages {
+		stageNames[stage.Name] = true
+	}
+	images := map[string]string{}
+	var stageText bytes.Buffer
+	directories := 0
+	for _, context := range contexts {
+		if stageNames[context.name] {
+			return fmt.Errorf("invalid %s value: the Dockerfile already has a stage named %q", builderutil.BuildContexts, context.name)
+		}
+		if len(context.image) > 0 {
+			images[context.name] = context.image
+			continue
+		}
+		fmt.Fprintf(&stageText, "FROM scratch AS %s\nCOPY %s/ /\n", context.name, filepath.ToSlash(filepath.Join(buildContextsDir, context.name)))
+		directories++
+	}
+
+	for _, stage := range stages {
+		for _, child := range stage.Node.Children {
+			switch {
+			case child.Value == dockercmd.From && child.Next != nil:
+				if image, ok := images[child.Next.Value]; ok {
+					child.Next.Value = image
+				}
+			case child.Value == dockercmd.Copy:
+				ref, ok := nodeHasFromRef(child)
+				if !ok {
+					continue
+				}
+				if image, ok := images[ref]; ok {
+					nodeReplaceFromRef(child, image)
+				} else if index, err := strconv.Atoi(ref); err == nil && index >= 0 && index < len(stages) && directories > 0 {
+					nodeReplaceFromRef(child, strconv.Itoa(index+directories))
+				}
+			}
+		}
+	}
+	if directories == 0 {
+		return nil
+	}
+
+	added, err := imagebuilder.ParseDockerfile(&stageText)
+	if err != nil {
+		return err
+	}
+	// the stages go after the ARGs before the first FROM, which are shared
+	// by every stage
+	first := len(node.Children)
+	for i, child := range node.Children {
+		if child.Value == dockercmd.From {
+			first = i
+			break
+		}
+	}
+	children := append([]*parser.Node{}, node.Children[:first]...)
+	children = append(children, added.Children...)
+	node.Children = append(children, node.Children[first:]...)
+	return nil
+}
+
+// copyBuildContexts copies the named contexts which are directories under
+// sourceDir, the root of the build's source, into the buildContextsDir of
+// contextDir, from which the stages added for them by addBuildContexts copy
+// them.
+func copyBuildContexts(contexts []buildContext, sourceDir, contextDir string) error {
+	for _, context := range contexts {
+		if len(context.path) == 0 {
+			continue
+		}
+		src := filepath.Join(sourceDir, context.path)
+		if info, err := os.Stat(src); err != nil || !info.IsDir() {
+			return fmt.Errorf("the directory %s of build context %q does not exist in the build's source", context.path, context.name)
+		}
+		dest := filepath.Join(contextDir, buildContextsDir, context.name)
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		log.V(0).Infof("Adding %s to the build as context %q", context.path, context.name)
+		if out, err := exec.Command("cp", "-a", src+"/.", dest).CombinedOutput(); err != nil {
+			return fmt.Errorf("unable to copy build context %q: %v: %s", context.name, err, out)
+		}
+	}
+	return nil
+}
+
+// buildContextIgnorePatterns returns the .dockerignore patterns which keep
+// the named contexts copied into the build's context in it, whatever the
+// patterns of the context itself exclude.
+func buildContextIgnorePatterns(contexts []buildContext) []string {
+	for _, context := range contexts {
+		if len(context.path) > 0 {
+			return []string{"!" + buildContextsDir, "!" + buildContextsDir + "/**"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/library-go/pkg/git"
+)
+
+func TestGetBuildContexts(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect []buildContext
+		err    bool
+	}{
+		{value: ""},
+		{
+			value: "shared=lib/shared/, tools = image://docker.io/library/golang:1.15,base=docker-image://registry.example.com/base:1",
+			expect: []buildContext{
+				{name: "shared", path: "lib/shared"},
+				{name: "tools", image: "docker.io/golang:1.15"},
+				{name: "base", image: "registry.example.com/base:1"},
+			},
+		},
+		{value: "shared", err: true},
+		{value: "shared=", err: true},
+		{value: "Shared=lib", err: true},
+		{value: "1=lib", err: true},
+		{value: "shared=/etc", err: true},
+		{value: "shared=..", err: true},
+		{value: "shared=lib/../../other", err: true},
+		{value: "tools=image://Not An Image", err: true},
+		{value: "shared=lib,shared=image://golang", err: true},
+	}
+	for _, test := range tests {
+		contexts, err := getBuildContexts(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXTS", Value: test.value}))
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error: %v", test.value, err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(contexts, test.expect) {
+			t.Errorf("%q: expected %#v, got %#v", test.value, test.expect, contexts)
+		}
+	}
+}
+
+func TestAddBuildParametersBuildContexts(t *testing.T) {
+	dockerfile := heredoc.Doc(`
+		ARG GO_VERSION=1.14
+		FROM golang:${GO_VERSION} AS builder
+		COPY --from=shared / /src/shared
+		RUN go build
+		FROM tools
+		COPY --from=0 /app /app
+		COPY --from=tools /usr/bin/tool /usr/bin/tool
+		`)
+	tests := []struct {
+		name     string
+		contexts string
+		expect   []string
+		// names are those of the contexts which must not be taken for
+		// images
+		names []string
+		err   bool
+	}{
+		{
+			name:     "directories and images",
+			contexts: "shared=lib,tools=image://registry.example.com/tools:1",
+			expect: []string{
+				"ARG GO_VERSION=1.14\nFROM scratch AS shared\nCOPY .openshift-build-contexts/shared/ /\nFROM golang:${GO_VERSION} AS builder\n",
+				"COPY --from=shared / /src/shared\n",
+				"FROM registry.example.com/tools:1\n",
+				"COPY --from=1 /app /app\n",
+				"COPY --from=registry.example.com/tools:1 /usr/bin/tool /usr/bin/tool\n",
+			},
+			names: []string{"shared", "tools"},
+		},
+		{
+			name:     "images only",
+			contexts: "tools=image://registry.example.com/tools:1",
+			expect: []string{
+				"ARG GO_VERSION=1.14\nFROM golang:${GO_VERSION} AS builder\n",
+				"COPY --from=0 /app /app\n",
+			},
+			names: []string{"tools"},
+		},
+		{
+			name:     "named for a stage",
+			contexts: "builder=lib",
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "build-contexts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeTestFile(t, filepath.Join(dir, "Dockerfile"), dockerfile)
+			err = addBuildParameters(dir, testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXTS", Value: test.contexts}), &git.SourceInfo{})
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err {
+				return
+			}
+			out, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, text := range test.expect {
+				if !strings.Contains(string(out), text) {
+					t.Errorf("expected %q in:\n%s", text, out)
+				}
+			}
+			images, err := findReferencedImages(filepath.Join(dir, "Dockerfile"), nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, image := range images {
+				for _, name := range test.names {
+					if image == name {
+						t.Errorf("expected the context %s not to be pulled as an image, got %v", image, images)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCopyBuildContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-contexts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "lib", "shared", "util.go"), "package shared")
+	writeTestFile(t, filepath.Join(dir, "app", ".dockerignore"), "*\n!main.go\n")
+	contextDir := filepath.Join(dir, "app")
+	contexts := []buildContext{{name: "shared", path: "lib/shared"}, {name: "tools", image: "golang:1.15"}}
+
+	for i := 0; i < 2; i++ {
+		if err := copyBuildContexts(contexts, dir, contextDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(contextDir, ".openshift-build-contexts", "shared", "util.go")); err != nil || string(data) != "package shared" {
+		t.Errorf("expected the context to be copied, got %q: %v", data, err)
+	}
+	if err := copyBuildContexts([]buildContext{{name: "missing", path: "lib/missing"}}, dir, contextDir); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+
+	build := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXTS", Value: "shared=lib/shared,tools=image://golang:1.15"})
+	if err := applyDockerContextIgnore(build, contextDir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(contextDir, ".dockerignore"))
+	if expect := "*\n!main.go\n!.openshift-build-contexts\n!.openshift-build-contexts/**\n"; string(data) != expect {
+		t.Errorf("expected .dockerignore %q, got %q", expect, data)
+	}
+}
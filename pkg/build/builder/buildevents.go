@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cloudevents"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// The types of the CloudEvents which the builder sends.
+const (
+	buildStartedEvent   = "io.openshift.build.started"
+	buildStageEvent     = "io.openshift.build.stage.started"
+	buildCompletedEvent = "io.openshift.build.completed"
+	buildFailedEvent    = "io.openshift.build.failed"
+)
+
+// buildEventsObserver is the name under which CloudEvents observe the stages
+// of the build.
+const buildEventsObserver = "cloudevents"
+
+// buildEvents sends the CloudEvents of the build, once they are configured.
+var buildEvents = struct {
+	sync.Mutex
+	sender *cloudevents.Sender
+	build  *buildapiv1.Build
+	// stage is the stage the build last entered
+	stage buildapiv1.StageName
+}{}
+
+// buildEventData is the data of the builder's CloudEvents.
+type buildEventData struct {
+	Name      string                `json:"name"`
+	Namespace string                `json:"namespace"`
+	Stage     buildapiv1.StageName  `json:"stage,omitempty"`
+	Phase     buildapiv1.BuildPhase `json:"phase,omitempty"`
+	// Reason, Message, FailureCode and Error say why a build failed
+	Reason      buildapiv1.StatusReason    `json:"reason,omitempty"`
+	Message     string                     `json:"message,omitempty"`
+	FailureCode FailureCode                `json:"failureCode,omitempty"`
+	Error       string                     `json:"error,omitempty"`
+	OutputImage string                     `json:"outputImage,omitempty"`
+	ImageDigest string                     `json:"imageDigest,omitempty"`
+	Revision    *buildapiv1.SourceRevision `json:"revision,omitempty"`
+	// RevisionDetails are the details of the revision beyond Revision
+	RevisionDetails *RevisionDetails `json:"revisionDetails,omitempty"`
+}
+
+// getCloudEventsSink returns the URL of the sink to which the build's
+// CloudEvents are sent, if the build strategy's environment, or else the
+// builder pod's, sets one.
+func getCloudEventsSink(build *buildapiv1.Build) (string, error) {
+	name := builderutil.CloudEventsSink
+	sink, _ := buildStrategyEnv(build, name)
+	if sink = strings.TrimSpace(sink); len(sink) == 0 {
+		name, sink = builderutil.KnativeSink, strings.TrimSpace(os.Getenv(builderutil.KnativeSink))
+	}
+	if len(sink) == 0 {
+		return "", nil
+	}
+	u, err := url.Parse(sink)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", fmt.Errorf("invalid %s value %q: it must be an http or https URL", name, sink)
+	}
+	return sink, nil
+}
+
+// ConfigureCloudEvents arranges for CloudEvents to be sent to the sink the
+// build requests, if any, as the build starts and enters each of its stages.
+// EmitBuildCompletion sends the last of them.
+func ConfigureCloudEvents(build *buildapiv1.Build) error {
+	sink, err := getCloudEventsSink(build)
+	if err != nil || len(sink) == 0 {
+		return err
+	}
+	source := fmt.Sprintf("/apis/build.openshift.io/v1/namespaces/%s/builds/%s", build.Namespace, build.Name)
+	buildEvents.Lock()
+	buildEvents.sender = cloudevents.NewSender(sink, source)
+	buildEvents.build = build
+	buildEvents.stage = ""
+	buildEvents.sender.Emit(cloudevents.Event{
+		Type: buildStartedEvent,
+		Data: buildEventData{Name: build.Name, Namespace: build.Namespace, Phase: buildapiv1.BuildPhaseRunning, Revision: buildEventRevision(build)},
+	})
+	buildEvents.Unlock()
+	timing.ObserveStages(buildEventsObserver, emitStageEvent)
+	return nil
+}
+
+// emitStageEvent sends an event when the build enters stage.
+func emitStageEvent(stage buildapiv1.StageName) {
+	buildEvents.Lock()
+	defer buildEvents.Unlock()
+	if buildEvents.sender == nil || stage == buildEvents.stage {
+		return
+	}
+	buildEvents.stage = stage
+	build := buildEvents.build
+	buildEvents.sender.Emit(cloudevents.Event{
+		Type:    buildStageEvent,
+		Subject: string(stage),
+		Data:    buildEventData{Name: build.Name, Namespace: build.Namespace, Stage: stage, Phase: buildapiv1.BuildPhaseRunning},
+	})
+}
+
+// EmitBuildCompletion sends the event saying that the build completed, or
+// that buildErr failed it, with its output image and source revision, and
+// waits for the build's events to be sent.
+func EmitBuildCompletion(build *buildapiv1.Build, buildErr error) {
+	timing.ObserveStages(buildEventsObserver, nil)
+	buildEvents.Lock()
+	sender := buildEvents.sender
+	buildEvents.sender, buildEvents.build = nil, nil
+	buildEvents.Unlock()
+	if sender == nil {
+		return
+	}
+
+	eventType := buildCompletedEvent
+	data := buildEventData{
+		Name:            build.Name,
+		Namespace:       build.Namespace,
+		Phase:           buildapiv1.BuildPhaseComplete,
+		OutputImage:     build.Status.OutputDockerImageReference,
+		Revision:        buildEventRevision(build),
+		RevisionDetails: readRevisionDetails(),
+	}
+	if build.Status.Output.To != nil {
+		data.ImageDigest = build.Status.Output.To.ImageDigest
+	}
+	if buildErr != nil {
+		eventType = buildFailedEvent
+		data.Phase = buildapiv1.BuildPhaseFailed
+		if build.Status.Phase == buildapiv1.BuildPhaseCancelled {
+			data.Phase = buildapiv1.BuildPhaseCancelled
+		}
+		data.Reason, data.Message, data.Error = build.Status.Reason, build.Status.Message, buildErr.Error()
+		data.FailureCode = BuildFailureCode(build)
+	}
+	sender.Emit(cloudevents.Event{Type: eventType, Data: data})
+	sender.Close()
+}
+
+// buildEventRevision returns the revision of the source being built, if it
+// is known.
+func buildEventRevision(build *buildapiv1.Build) *buildapiv1.SourceRevision {
+	sourceInfo, err := readSourceInfo()
+	if err != nil || (sourceInfo == nil && build.Spec.Revision == nil) {
+		return nil
+	}
+	return GetSourceRevision(build, sourceInfo)
+}
@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+)
+
+func TestGetCloudEventsSink(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        []corev1.EnvVar
+		kSink      string
+		expectSink string
+		expectErr  bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:       "build strategy",
+			env:        []corev1.EnvVar{{Name: "BUILD_CLOUDEVENTS_SINK", Value: "http://broker.example.com/events"}},
+			kSink:      "http://other.example.com",
+			expectSink: "http://broker.example.com/events",
+		},
+		{
+			name:       "sink binding",
+			kSink:      "http://broker-ingress.knative-eventing.svc/myproject/default",
+			expectSink: "http://broker-ingress.knative-eventing.svc/myproject/default",
+		},
+		{
+			name:      "not http",
+			env:       []corev1.EnvVar{{Name: "BUILD_CLOUDEVENTS_SINK", Value: "broker.example.com"}},
+			expectErr: true,
+		},
+	}
+	defer os.Unsetenv("K_SINK")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("K_SINK", test.kSink)
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			sink, err := getCloudEventsSink(build)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if sink != test.expectSink {
+				t.Errorf("expected %q, got %q", test.expectSink, sink)
+			}
+		})
+	}
+}
+
+func TestBuildEvents(t *testing.T) {
+	var lock sync.Mutex
+	var types, subjects []string
+	var last buildEventData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		types = append(types, r.Header.Get("Ce-Type"))
+		subjects = append(subjects, r.Header.Get("Ce-Subject"))
+		last = buildEventData{}
+		if err := json.NewDecoder(r.Body).Decode(&last); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	build := &buildapiv1.Build{}
+	build.Name = "app-1"
+	build.Namespace = "myproject"
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_CLOUDEVENTS_SINK", Value: server.URL}},
+	}
+	build.Spec.Revision = &buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{Commit: "abc123"}}
+	if err := ConfigureCloudEvents(build); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timing.SetStage(buildapiv1.StagePullImages)
+	timing.SetStage(buildapiv1.StagePullImages)
+	timing.SetStage(buildapiv1.StageBuild)
+	build.Status.Phase = buildapiv1.BuildPhaseFailed
+	build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+	EmitBuildCompletion(build, errors.New("RUN make failed"))
+	// the stages are no longer observed once the build has finished
+	timing.SetStage(buildapiv1.StagePushImage)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if expected := []string{buildStartedEvent, buildStageEvent, buildStageEvent, buildFailedEvent}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected events %v, got %v", expected, types)
+	}
+	if expected := []string{"", "PullImages", "Build", ""}; !reflect.DeepEqual(subjects, expected) {
+		t.Errorf("expected subjects %v, got %v", expected, subjects)
+	}
+	if last.Phase != buildapiv1.BuildPhaseFailed || last.Reason != buildapiv1.StatusReasonDockerBuildFailed || last.Error != "RUN make failed" {
+		t.Errorf("unexpected failure event %#v", last)
+	}
+	if last.Revision == nil || last.Revision.Git == nil || last.Revision.Git.Commit != "abc123" {
+		t.Errorf("expected the event to hold the source revision, got %#v", last.Revision)
+	}
+}
@@ -0,0 +1,250 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+)
+
+// cancellationPollInterval is how often the build is checked for
+// cancellation when it cannot be watched, and how long the builder waits
+// before watching it again once a watch ends.
+var cancellationPollInterval = 5 * time.Second
+
+// maxCancellationRetryInterval is the longest the builder waits before
+// checking the build for cancellation again after a failure.
+const maxCancellationRetryInterval = time.Minute
+
+// childProcessGracePeriod is how long the builder's child processes are
+// given to exit after the build is cancelled before they are killed.
+var childProcessGracePeriod = 10 * time.Second
+
+// cancellation holds the context which the build's git, buildah, pull and
+// push operations run in, and which is cancelled when the build is.  There
+// is only ever one build per process, so it is kept at package level.
+var cancellation = struct {
+	sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled bool
+	stage     string
+}{}
+
+func init() {
+	cancellation.ctx, cancellation.cancel = context.WithCancel(context.Background())
+}
+
+// BuildContext returns the context which is cancelled when the build is.
+func BuildContext() context.Context {
+	cancellation.Lock()
+	defer cancellation.Unlock()
+	return cancellation.ctx
+}
+
+// CancelBuild cancels the build's operations and stops its child processes,
+// recording the stage the build was in.  reason says why it was cancelled.
+func CancelBuild(reason string) {
+	cancellation.Lock()
+	defer cancellation.Unlock()
+	if cancellation.cancelled {
+		return
+	}
+	cancellation.cancelled = true
+	cancellation.stage = utillog.Stage()
+	log.V(0).Infof("Cancelling the build during stage %q: %s", cancellation.stage, reason)
+	cancellation.cancel()
+
+	killed := killChildProcesses(syscall.SIGTERM)
+	if len(killed) == 0 {
+		return
+	}
+	go func() {
+		time.Sleep(childProcessGracePeriod)
+		for _, pid := range killed {
+			if p, err := os.FindProcess(pid); err == nil && p.Kill() == nil {
+				log.V(4).Infof("Killed process %d, which did not exit once the build was cancelled", pid)
+			}
+		}
+	}()
+}
+
+// BuildCancelled returns whether the build has been cancelled and, if so,
+// the stage it was in.
+func BuildCancelled() (bool, string) {
+	cancellation.Lock()
+	defer cancellation.Unlock()
+	return cancellation.cancelled, cancellation.stage
+}
+
+// SetCancelledStatus records in build that it was cancelled, and during which
+// stage, if it was.  A build stopped because a stage exceeded its timeout
+// is recorded as having failed in that stage instead, one which stalled as
+// having failed for making no progress, and one stopped by the disk watchdog
+// as having failed for lack of space.  It returns whether the build was
+// stopped.
+func SetCancelledStatus(build *buildapiv1.Build) bool {
+	cancelled, stage := BuildCancelled()
+	if !cancelled {
+		return false
+	}
+	if timedOut, timeout := stageTimedOut(); len(timedOut) > 0 {
+		build.Status.Phase = buildapiv1.BuildPhaseFailed
+		build.Status.Reason = StatusReasonStageTimedOut
+		build.Status.Message = fmt.Sprintf("The %s stage of the build did not finish within its timeout of %v.", timedOut, timeout)
+		return true
+	}
+	if stalled, idle := buildStalled(); idle > 0 {
+		build.Status.Phase = buildapiv1.BuildPhaseFailed
+		build.Status.Reason = StatusReasonBuildStalled
+		if len(stalled) > 0 {
+			build.Status.Message = fmt.Sprintf("The build made no progress during the %s stage for %v.", stalled, idle)
+		} else {
+			build.Status.Message = fmt.Sprintf("The build made no progress for %v.", idle)
+		}
+		return true
+	}
+	if exhausted := diskSpaceExhausted(); len(exhausted) > 0 {
+		build.Status.Phase = buildapiv1.BuildPhaseFailed
+		build.Status.Reason = StatusReasonOutOfDiskSpace
+		build.Status.Message = fmt.Sprintf("The build was stopped before it ran out of disk space. %s", exhausted)
+		setBuildFailureCode(build, FailureDiskFull)
+		return true
+	}
+	build.Status.Phase = buildapiv1.BuildPhaseCancelled
+	build.Status.Reason = buildapiv1.StatusReasonCancelledBuild
+	if len(stage) > 0 {
+		build.Status.Message = fmt.Sprintf("The build was cancelled during the %s stage.", stage)
+	} else {
+		build.Status.Message = "The build was cancelled before it started."
+	}
+	return true
+}
+
+// WatchForCancellation cancels the build when the builder is asked to stop
+// by a signal, as it is when its pod is deleted, or when build is marked as
+// cancelled.  The returned function stops watching, stops timing the stage
+// the build is in against its timeout and stops the heartbeat and the disk
+// watchdog.
+func WatchForCancellation(build *buildapiv1.Build, client buildclientv1.BuildInterface) func() {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case sig := <-signals:
+			CancelBuild(fmt.Sprintf("received %v", sig))
+		case <-stop:
+		}
+	}()
+	if client != nil {
+		go watchBuildCancelled(build, client, stop)
+	}
+	return func() {
+		stopStageTimer()
+		stopHeartbeat()
+		stopDiskWatchdog()
+		signal.Stop(signals)
+		close(stop)
+	}
+}
+
+// watchBuildCancelled cancels the build once it is marked as cancelled, or
+// until stop is closed.  Watching builds needs the watch verb on builds,
+// which the system:image-builder role of the builder service account does
+// not grant, so if the watch is forbidden the build is read with get, which
+// it does grant, every cancellationPollInterval instead.  Other failures are
+// retried, less and less often, unless the build can no longer be read, as
+// when it has been deleted.
+func watchBuildCancelled(build *buildapiv1.Build, client buildclientv1.BuildInterface, stop <-chan struct{}) {
+	poll := false
+	delay := cancellationPollInterval
+	failed := false
+	for {
+		var cancelled bool
+		var err error
+		if poll {
+			cancelled, err = getBuildCancelled(build, client)
+		} else {
+			// without a resource version, the watch starts with the build
+			// as it is now, so a cancellation while it was not being
+			// watched is still seen
+			var w watch.Interface
+			w, err = client.Watch(metav1.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", build.Name).String(),
+			})
+			if err == nil {
+				cancelled = watchForCancelled(w, stop)
+			}
+		}
+		switch {
+		case cancelled:
+			CancelBuild("the build was cancelled")
+			return
+		case errors.IsForbidden(err) && !poll:
+			log.V(0).Infof("Unable to watch build %s for cancellation, checking it every %v instead: %v", build.Name, cancellationPollInterval, err)
+			poll = true
+		case errors.IsForbidden(err) || errors.IsNotFound(err):
+			log.V(0).Infof("Unable to check build %s for cancellation, no longer checking: %v", build.Name, err)
+			return
+		case err != nil:
+			if !failed {
+				log.V(0).Infof("Unable to check build %s for cancellation, retrying: %v", build.Name, err)
+			} else {
+				log.V(4).Infof("Unable to check build %s for cancellation, retrying in %v: %v", build.Name, delay, err)
+			}
+			failed = true
+			if delay *= 2; delay > maxCancellationRetryInterval {
+				delay = maxCancellationRetryInterval
+			}
+		default:
+			failed = false
+			delay = cancellationPollInterval
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// getBuildCancelled returns whether build is marked as cancelled.
+func getBuildCancelled(build *buildapiv1.Build, client buildclientv1.BuildInterface) (bool, error) {
+	latest, err := client.Get(build.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return latest.Status.Cancelled, nil
+}
+
+// watchForCancelled returns true once w reports that the build has been
+// cancelled, or false if w ends or stop is closed first.
+func watchForCancelled(w watch.Interface, stop <-chan struct{}) bool {
+	defer w.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return false
+			}
+			if b, ok := event.Object.(*buildapiv1.Build); ok && b.Status.Cancelled {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	clienttesting "k8s.io/client-go/testing"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
+)
+
+// resetCancellation undoes the cancellation of the build by an earlier test.
+func resetCancellation() {
+	cancellation.Lock()
+	defer cancellation.Unlock()
+	cancellation.ctx, cancellation.cancel = context.WithCancel(context.Background())
+	cancellation.cancelled, cancellation.stage = false, ""
+}
+
+func TestCancelBuild(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+	defer utillog.SetStage("")
+
+	build := &buildapiv1.Build{}
+	if SetCancelledStatus(build) || build.Status.Phase != "" {
+		t.Errorf("expected a build which was not cancelled to be left alone, got %#v", build.Status)
+	}
+
+	sleep := exec.Command("sleep", "60")
+	if err := sleep.Start(); err != nil {
+		t.Fatalf("unable to start a child process: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- sleep.Wait() }()
+
+	utillog.SetStage(string(buildapiv1.StagePushImage))
+	CancelBuild("testing")
+	CancelBuild("testing again")
+
+	select {
+	case <-BuildContext().Done():
+	default:
+		t.Errorf("expected the build context to be cancelled")
+	}
+	if cancelled, stage := BuildCancelled(); !cancelled || stage != "PushImage" {
+		t.Errorf("expected the build to be cancelled during PushImage, got %v, %q", cancelled, stage)
+	}
+	if !SetCancelledStatus(build) || build.Status.Phase != buildapiv1.BuildPhaseCancelled || build.Status.Reason != buildapiv1.StatusReasonCancelledBuild ||
+		build.Status.Message != "The build was cancelled during the PushImage stage." {
+		t.Errorf("unexpected status %#v", build.Status)
+	}
+
+	if runtime.GOOS != "linux" {
+		sleep.Process.Kill()
+		return
+	}
+	select {
+	case err := <-exited:
+		if err == nil {
+			t.Errorf("expected the child process to be stopped by a signal")
+		}
+	case <-time.After(5 * time.Second):
+		sleep.Process.Kill()
+		t.Errorf("expected the child process to be stopped when the build was cancelled")
+	}
+}
+
+func TestWatchForCancellation(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	client := buildfake.NewSimpleClientset(build).BuildV1().Builds("ns")
+	stop := WatchForCancellation(build, client)
+	defer stop()
+
+	select {
+	case <-BuildContext().Done():
+		t.Fatalf("expected the build not to be cancelled yet")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelled := build.DeepCopy()
+	cancelled.Status.Cancelled = true
+	if _, err := client.Update(cancelled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-BuildContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the build to be cancelled once it was marked as cancelled")
+	}
+}
+
+func TestWatchBuildCancelledForbidden(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+	defer func(interval time.Duration) { cancellationPollInterval = interval }(cancellationPollInterval)
+	cancellationPollInterval = 10 * time.Millisecond
+
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	clientset := buildfake.NewSimpleClientset(build)
+	clientset.PrependWatchReactor("builds", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		return true, nil, errors.NewForbidden(buildapiv1.Resource("builds"), "", fmt.Errorf("no watch"))
+	})
+	client := clientset.BuildV1().Builds("ns")
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchBuildCancelled(build, client, stop)
+
+	// the build is read instead of watched
+	cancelled := build.DeepCopy()
+	cancelled.Status.Cancelled = true
+	if _, err := client.Update(cancelled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-BuildContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the build to be cancelled once it was marked as cancelled")
+	}
+
+	// a build which is gone is no longer checked
+	resetCancellation()
+	if err := client.Delete(build.Name, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		watchBuildCancelled(build, client, stop)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the builder to stop checking a deleted build")
+	}
+}
@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+var (
+	// caTrustAnchorsDir holds the builder's additional trusted CA
+	// certificates, from which update-ca-trust extracts its trust store.
+	caTrustAnchorsDir = "/etc/pki/ca-trust/source/anchors"
+	// caTrustExtractedDir holds the trust store extracted by
+	// update-ca-trust, in every format which TLS clients read.
+	caTrustExtractedDir = "/etc/pki/ca-trust/extracted"
+	// updateCATrust extracts the builder's trust store.
+	updateCATrust = func() error {
+		out, err := exec.Command("update-ca-trust", "extract").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("update-ca-trust failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+)
+
+// getCABundleMount adds the certificates of the build input secret named by
+// the build strategy's environment, if any, to the builder's trust store,
+// and returns the mount which makes the extracted trust store available to
+// the RUN instructions, and so the assemble script, of the build.  Images
+// which read their trust store from /etc/pki/ca-trust/extracted, like those
+// based on RHEL or Fedora, then trust the certificates, and those of the
+// cluster, without them being committed to the image.  The returned function
+// removes the certificates from the builder's trust store again, once the
+// build is done with them, so that they are not trusted by later builds run
+// by the same builder.
+func getCABundleMount(build *buildapiv1.Build) (*BuildMount, func(), error) {
+	name, _ := buildStrategyEnv(build, builderutil.CABundleSecret)
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return nil, func() {}, nil
+	}
+	dir, ok := inputSecretDir(build, name)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s names %q, which is not an input secret of the build", builderutil.CABundleSecret, name)
+	}
+	remove, err := addCATrustAnchors(name, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.V(0).Infof("Trusting the CA certificates of the secret %q in RUN instructions", name)
+	return &BuildMount{
+		Source:      caTrustExtractedDir,
+		Destination: "/etc/pki/ca-trust/extracted",
+	}, remove, nil
+}
+
+// addCATrustAnchors adds the PEM encoded certificates in dir, the mount of the
+// secret name, to the builder's trust store, and returns the function which
+// removes them from it.
+func addCATrustAnchors(name, dir string) (func(), error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(caTrustAnchorsDir, 0755); err != nil {
+		return nil, err
+	}
+	var anchors []string
+	removeAnchors := func() {
+		for _, anchor := range anchors {
+			if err := os.Remove(anchor); err != nil && !os.IsNotExist(err) {
+				log.V(0).Infof("warning: Unable to remove the CA certificate %s: %v", anchor, err)
+			}
+		}
+	}
+	for _, file := range files {
+		// skip the directories and links kept by the kubelet to update
+		// secret volumes atomically
+		if strings.HasPrefix(file.Name(), "..") || file.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			removeAnchors()
+			return nil, err
+		}
+		if !strings.Contains(string(data), "-----BEGIN CERTIFICATE-----") {
+			log.V(0).Infof("warning: Ignoring %s in the secret %q, which holds no PEM encoded certificates", file.Name(), name)
+			continue
+		}
+		anchor := filepath.Join(caTrustAnchorsDir, fmt.Sprintf("build-%s-%s", name, file.Name()))
+		if err := ioutil.WriteFile(anchor, data, 0644); err != nil {
+			removeAnchors()
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("the secret %q named by %s holds no PEM encoded certificates", name, builderutil.CABundleSecret)
+	}
+	if err := updateCATrust(); err != nil {
+		removeAnchors()
+		return nil, err
+	}
+	return func() {
+		removeAnchors()
+		if err := updateCATrust(); err != nil {
+			log.V(0).Infof("warning: Unable to remove the CA certificates of the secret %q from the trust store: %v", name, err)
+		}
+	}, nil
+}
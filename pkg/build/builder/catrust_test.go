@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+const testCACertificate = `-----BEGIN CERTIFICATE-----
+MIIBszCCAVmgAwIBAgIUWmQ1
+-----END CERTIFICATE-----
+`
+
+func TestAddCATrustAnchors(t *testing.T) {
+	defer func(anchors string, update func() error) {
+		caTrustAnchorsDir, updateCATrust = anchors, update
+	}(caTrustAnchorsDir, updateCATrust)
+
+	tmp, err := ioutil.TempDir("", "catrust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	caTrustAnchorsDir = filepath.Join(tmp, "anchors")
+	updates := 0
+	updateCATrust = func() error {
+		updates++
+		return nil
+	}
+
+	secret := filepath.Join(tmp, "secret")
+	if err := os.MkdirAll(filepath.Join(secret, "..data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(secret, "..data", "ca.crt"), []byte(testCACertificate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "ca.crt"), filepath.Join(secret, "ca.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(secret, "README"), []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remove, err := addCATrustAnchors("proxy-ca", secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updates != 1 {
+		t.Errorf("expected the trust store to be extracted once, got %d", updates)
+	}
+	files, err := ioutil.ReadDir(caTrustAnchorsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "build-proxy-ca-ca.crt" {
+		t.Errorf("expected only build-proxy-ca-ca.crt to be added, got %v", files)
+	}
+
+	empty := filepath.Join(tmp, "empty")
+	if err := os.MkdirAll(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := addCATrustAnchors("empty", empty); err == nil {
+		t.Errorf("expected an error for a secret without certificates")
+	}
+	if updates != 1 {
+		t.Errorf("expected the trust store not to be extracted again, got %d", updates)
+	}
+
+	// the certificates are not trusted by later builds
+	remove()
+	if files, err := ioutil.ReadDir(caTrustAnchorsDir); err != nil || len(files) != 0 {
+		t.Errorf("expected the certificates to be removed, got %v: %v", files, err)
+	}
+	if updates != 2 {
+		t.Errorf("expected the trust store to be extracted again without them, got %d", updates)
+	}
+}
+
+func TestGetCABundleMount(t *testing.T) {
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	if mount, _, err := getCABundleMount(build); mount != nil || err != nil {
+		t.Errorf("expected no mount by default, got %v, %v", mount, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_CA_BUNDLE_SECRET", Value: "proxy-ca"}}
+	if _, _, err := getCABundleMount(build); err == nil {
+		t.Errorf("expected an error for a secret which is not a build input")
+	}
+}
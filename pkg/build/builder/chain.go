@@ -0,0 +1,198 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/openshift/imagebuilder"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+// chainedDockerfileName is the name of the Dockerfile in the context of the
+// chained step of a build.
+const chainedDockerfileName = ".chained.Dockerfile"
+
+// chainedStep is the second step of a chained build, which builds the image
+// the build outputs from artifacts of the image built by its first step, so
+// that the tools which built the artifacts are left out of the output.
+type chainedStep struct {
+	// Dockerfile is the path of the step's Dockerfile
+	Dockerfile string
+	// Artifacts are copied from the first step's image into the step's
+	// context
+	Artifacts s2iapi.VolumeList
+}
+
+// getChainedStep returns the chained step which the build strategy's
+// environment requests, if any, whose Dockerfile is in the context directory
+// of the build's source at dir.
+func getChainedStep(build *buildapiv1.Build, dir string) (*chainedStep, error) {
+	dockerfilePath, _ := buildStrategyEnv(build, builderutil.ChainedDockerfile)
+	dockerfilePath = strings.TrimSpace(dockerfilePath)
+	mapping, _ := buildStrategyEnv(build, builderutil.ChainedArtifacts)
+	mapping = strings.TrimSpace(mapping)
+	switch {
+	case len(dockerfilePath) == 0 && len(mapping) == 0:
+		return nil, nil
+	case len(dockerfilePath) == 0:
+		return nil, fmt.Errorf("%s requires %s", builderutil.ChainedArtifacts, builderutil.ChainedDockerfile)
+	case len(mapping) == 0:
+		return nil, fmt.Errorf("%s requires %s", builderutil.ChainedDockerfile, builderutil.ChainedArtifacts)
+	case path.IsAbs(dockerfilePath) || strings.HasPrefix(path.Clean(dockerfilePath), ".."):
+		return nil, fmt.Errorf("invalid %s value %q: it must be a path within the build's context directory", builderutil.ChainedDockerfile, dockerfilePath)
+	}
+	step := &chainedStep{Dockerfile: filepath.Join(dir, build.Spec.Source.ContextDir, filepath.FromSlash(dockerfilePath))}
+	if err := step.Artifacts.Set(mapping); err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.ChainedArtifacts, mapping, err)
+	}
+	for _, artifact := range step.Artifacts {
+		switch {
+		case !path.IsAbs(artifact.Source):
+			return nil, fmt.Errorf("invalid %s mapping %q -> %q: the source must be an absolute path", builderutil.ChainedArtifacts, artifact.Source, artifact.Destination)
+		case path.IsAbs(artifact.Destination) || strings.HasPrefix(path.Clean(artifact.Destination), ".."):
+			return nil, fmt.Errorf("invalid %s mapping %q -> %q: the destination must be a directory within the context", builderutil.ChainedArtifacts, artifact.Source, artifact.Destination)
+		}
+	}
+	return step, nil
+}
+
+// buildChainedStep builds tag from the step's Dockerfile, in a context
+// holding the step's artifacts copied from the local image, which the build's
+// first step built.  The Dockerfile is given the build's environment and
+// labels, as the Dockerfile of a Docker strategy build is, so that they
+// describe the image the build outputs.
+func buildChainedStep(ctx context.Context, client DockerClient, build *buildapiv1.Build, step *chainedStep, image, tag string, cgLimits *s2iapi.CGroupLimits) error {
+	contextDir, err := ioutil.TempDir("", "chained-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(contextDir)
+
+	if err := copyChainedArtifacts(client, step.Artifacts, image, contextDir); err != nil {
+		return err
+	}
+	sourceInfo, err := readSourceInfo()
+	if err != nil {
+		return fmt.Errorf("error reading git source info: %v", err)
+	}
+	if err := writeChainedDockerfile(build, sourceInfo, step.Dockerfile, filepath.Join(contextDir, chainedDockerfileName)); err != nil {
+		return err
+	}
+
+	buildArgs, epoch, err := dockerBuildArgs(build, sourceInfo)
+	if err != nil {
+		return err
+	}
+
+	opts := docker.BuildImageOptions{
+		Context:             ctx,
+		Name:                tag,
+		RmTmpContainer:      true,
+		ForceRmTmpContainer: true,
+		OutputStream:        os.Stdout,
+		Dockerfile:          chainedDockerfileName,
+		BuildArgs:           buildArgs,
+		ContextDir:          contextDir,
+	}
+	if cgLimits != nil {
+		opts.CPUPeriod = cgLimits.CPUPeriod
+		opts.CPUQuota = cgLimits.CPUQuota
+		opts.CPUShares = cgLimits.CPUShares
+		opts.Memory = cgLimits.MemoryLimitBytes
+		opts.Memswap = cgLimits.MemorySwap
+		opts.CgroupParent = cgLimits.Parent
+	}
+	if authPath := os.Getenv(dockercfg.PullAuthType); len(authPath) != 0 {
+		auth, err := GetDockerAuthConfiguration(authPath)
+		if err != nil {
+			return err
+		}
+		opts.AuthConfigs = *auth
+	}
+
+	log.V(0).Infof("\nBuilding the chained step from %s ...", filepath.Base(step.Dockerfile))
+	if err := client.BuildImage(opts); err != nil {
+		return err
+	}
+	if epoch == nil {
+		return nil
+	}
+	return makeImageReproducible(client, tag, *epoch)
+}
+
+// copyChainedArtifacts copies artifacts from the local image into dir.
+func copyChainedArtifacts(client DockerClient, artifacts s2iapi.VolumeList, image, dir string) error {
+	mounter, ok := client.(imageMounter)
+	if !ok {
+		return fmt.Errorf("chained builds are not supported by this build client")
+	}
+	root, unmount, err := mounter.MountImage(image)
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v", image, err)
+	}
+	defer unmount()
+	for _, artifact := range artifacts {
+		// symbolic links are resolved within the image's root filesystem
+		source, err := resolveInRoot(root, artifact.Source)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("unable to copy %s from the first step's image: %v", artifact.Source, err)
+		}
+		if err := copyImageSourceFromFilesytem(source, filepath.Join(dir, filepath.FromSlash(artifact.Destination))); err != nil {
+			return fmt.Errorf("unable to copy %s from the first step's image: %v", artifact.Source, err)
+		}
+		log.V(4).Infof("Copied %s from the first step's image to %s", artifact.Source, artifact.Destination)
+	}
+	return nil
+}
+
+// writeChainedDockerfile writes the Dockerfile at source to target, with the
+// build's environment and labels appended.
+func writeChainedDockerfile(build *buildapiv1.Build, sourceInfo *git.SourceInfo, source, target string) error {
+	in, err := ioutil.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("unable to read the Dockerfile of the chained step: %v", err)
+	}
+	node, err := imagebuilder.ParseDockerfile(bytes.NewBuffer(in))
+	if err != nil {
+		return fmt.Errorf("unable to parse the Dockerfile of the chained step: %v", err)
+	}
+	stage := lastStage(node)
+	if err := appendEnv(node, stage, buildEnv(build, sourceInfo)); err != nil {
+		return err
+	}
+	labels, err := buildLabels(build, sourceInfo)
+	if err != nil {
+		return err
+	}
+	if err := appendLabel(node, stage, labels); err != nil {
+		return err
+	}
+	if build.Spec.Strategy.DockerStrategy != nil {
+		if err := insertEnvAfterFrom(node, build.Spec.Strategy.DockerStrategy.Env); err != nil {
+			return err
+		}
+	}
+	if err := replaceImagesFromSource(node, build.Spec.Source.Images, strategyBuildArgs(build)); err != nil {
+		return err
+	}
+	out := dockerfile.Write(node)
+	log.V(4).Infof("Building the chained step with the Dockerfile:\n%s", string(out))
+	return ioutil.WriteFile(target, out, 0644)
+}
@@ -0,0 +1,181 @@
+package builder
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetChainedStep(t *testing.T) {
+	tests := []struct {
+		name             string
+		contextDir       string
+		env              []corev1.EnvVar
+		expectDockerfile string
+		expectArtifacts  s2iapi.VolumeList
+		expectErr        bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:       "dockerfile and artifacts",
+			contextDir: "app",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CHAINED_DOCKERFILE", Value: "runtime/Dockerfile"},
+				{Name: "BUILD_CHAINED_ARTIFACTS", Value: "/go/bin/app:bin;/etc/app:."},
+			},
+			expectDockerfile: "/src/app/runtime/Dockerfile",
+			expectArtifacts: s2iapi.VolumeList{
+				{Source: "/go/bin/app", Destination: "bin"},
+				{Source: "/etc/app", Destination: "."},
+			},
+		},
+		{
+			name:      "dockerfile without artifacts",
+			env:       []corev1.EnvVar{{Name: "BUILD_CHAINED_DOCKERFILE", Value: "Dockerfile.runtime"}},
+			expectErr: true,
+		},
+		{
+			name:      "artifacts without dockerfile",
+			env:       []corev1.EnvVar{{Name: "BUILD_CHAINED_ARTIFACTS", Value: "/go/bin/app:."}},
+			expectErr: true,
+		},
+		{
+			name: "dockerfile outside the context",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CHAINED_DOCKERFILE", Value: "../Dockerfile.runtime"},
+				{Name: "BUILD_CHAINED_ARTIFACTS", Value: "/go/bin/app:."},
+			},
+			expectErr: true,
+		},
+		{
+			name: "relative source",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CHAINED_DOCKERFILE", Value: "Dockerfile.runtime"},
+				{Name: "BUILD_CHAINED_ARTIFACTS", Value: "go/bin/app:."},
+			},
+			expectErr: true,
+		},
+		{
+			name: "absolute destination",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CHAINED_DOCKERFILE", Value: "Dockerfile.runtime"},
+				{Name: "BUILD_CHAINED_ARTIFACTS", Value: "/go/bin/app:/bin"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Source.ContextDir = test.contextDir
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			step, err := getChainedStep(build, "/src")
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			switch {
+			case len(test.expectDockerfile) == 0 && step != nil:
+				t.Errorf("expected no chained step, got %#v", step)
+			case len(test.expectDockerfile) > 0 && step == nil:
+				t.Errorf("expected a chained step")
+			case step != nil:
+				if step.Dockerfile != test.expectDockerfile {
+					t.Errorf("expected Dockerfile %s, got %s", test.expectDockerfile, step.Dockerfile)
+				}
+				if len(step.Artifacts) != len(test.expectArtifacts) {
+					t.Fatalf("expected artifacts %v, got %v", test.expectArtifacts, step.Artifacts)
+				}
+				for i := range step.Artifacts {
+					if step.Artifacts[i] != test.expectArtifacts[i] {
+						t.Errorf("expected artifacts %v, got %v", test.expectArtifacts, step.Artifacts)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildChainedStep(t *testing.T) {
+	root, err := ioutil.TempDir("", "chained-root")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, filepath.Join(root, "go/bin/app"), "binary")
+	writeTestFile(t, filepath.Join(root, "etc/app/config.yaml"), "config")
+	source, err := ioutil.TempDir("", "chained-source")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(source)
+	writeTestFile(t, filepath.Join(source, "Dockerfile.runtime"), "FROM ubi-minimal\nCOPY bin/app /usr/bin/app\n")
+
+	build := &buildapiv1.Build{}
+	build.Name = "app-1"
+	build.Namespace = "myproject"
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		BuildArgs: []corev1.EnvVar{{Name: "VERSION", Value: "1.0"}},
+	}
+	step := &chainedStep{
+		Dockerfile: filepath.Join(source, "Dockerfile.runtime"),
+		Artifacts: s2iapi.VolumeList{
+			{Source: "/go/bin/app", Destination: "bin"},
+			{Source: "/etc/app", Destination: "config"},
+		},
+	}
+
+	built := false
+	client := &fakeMountingClient{FakeDocker: &FakeDocker{}, root: root}
+	client.buildImageFunc = func(opts docker.BuildImageOptions) error {
+		built = true
+		if opts.Name != "output" {
+			t.Errorf("expected to build output, got %s", opts.Name)
+		}
+		if len(opts.BuildArgs) != 1 || opts.BuildArgs[0].Name != "VERSION" {
+			t.Errorf("expected the build's build args, got %v", opts.BuildArgs)
+		}
+		for file, content := range map[string]string{"bin/app": "binary", "config/app/config.yaml": "config"} {
+			data, err := ioutil.ReadFile(filepath.Join(opts.ContextDir, file))
+			if err != nil || string(data) != content {
+				t.Errorf("expected %s in the context to hold %q, got %q: %v", file, content, data, err)
+			}
+		}
+		dockerfile, err := ioutil.ReadFile(filepath.Join(opts.ContextDir, opts.Dockerfile))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, expected := range []string{"COPY bin/app /usr/bin/app", `"io.openshift.build.name"="app-1"`} {
+			if !strings.Contains(string(dockerfile), expected) {
+				t.Errorf("expected the Dockerfile to contain %s, got:\n%s", expected, dockerfile)
+			}
+		}
+		return nil
+	}
+
+	if err := buildChainedStep(context.Background(), client, build, step, "first-step", "output", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !built {
+		t.Errorf("expected the chained step to be built")
+	}
+	if len(client.mounted) != 1 || client.mounted[0] != "first-step" {
+		t.Errorf("expected the first step's image to be mounted, got %v", client.mounted)
+	}
+
+	step.Artifacts = s2iapi.VolumeList{{Source: "/missing", Destination: "."}}
+	if err := buildChainedStep(context.Background(), client, build, step, "first-step", "output", nil); err == nil {
+		t.Errorf("expected an error copying a missing artifact")
+	}
+}
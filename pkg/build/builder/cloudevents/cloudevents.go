@@ -0,0 +1,138 @@
+package cloudevents
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+var log = utillog.ToFile(os.Stderr, 2)
+
+// specVersion is the version of the CloudEvents specification the events
+// conform to.
+const specVersion = "1.0"
+
+// maxQueuedEvents is how many events may wait to be sent before further
+// events are dropped, so that a slow sink never holds up the build.
+const maxQueuedEvents = 100
+
+// Event is a CloudEvent, which is sent to the sink in the HTTP binary
+// content mode: its attributes are headers and its data, encoded as JSON, is
+// the body.
+type Event struct {
+	// Type is the type of the event, such as io.openshift.build.started
+	Type string
+	// Subject is what the event is about within its source, such as the
+	// stage of the build, if anything
+	Subject string
+	Time    time.Time
+	Data    interface{}
+}
+
+// Sender sends the events of one source to a sink in the order in which
+// they were emitted, without blocking the emitter.
+type Sender struct {
+	sink   string
+	source string
+	client *http.Client
+
+	lock   sync.Mutex
+	queue  chan Event
+	closed bool
+	done   chan struct{}
+}
+
+// NewSender returns a Sender of events from source, a URI reference, to the
+// sink, the URL of an HTTP endpoint which accepts CloudEvents, such as a
+// Knative broker.
+func NewSender(sink, source string) *Sender {
+	s := &Sender{
+		sink:   sink,
+		source: source,
+		client: &http.Client{Timeout: 30 * time.Second},
+		queue:  make(chan Event, maxQueuedEvents),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Emit queues event to be sent, dropping it if the queue is full or the
+// Sender is closed.
+func (s *Sender) Emit(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case s.queue <- event:
+	default:
+		log.V(0).Infof("warning: Dropped the %s event, as the event sink is not keeping up", event.Type)
+	}
+}
+
+// Close sends the events which are queued and stops the Sender.
+func (s *Sender) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+	<-s.done
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		if err := s.send(event); err != nil {
+			log.V(0).Infof("warning: Failed to send the %s event: %v", event.Type, err)
+		}
+	}
+}
+
+// send POSTs event to the sink.
+func (s *Sender) send(event Event) error {
+	body, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", specVersion)
+	req.Header.Set("Ce-Id", hex.EncodeToString(id))
+	req.Header.Set("Ce-Source", s.source)
+	req.Header.Set("Ce-Type", event.Type)
+	req.Header.Set("Ce-Time", event.Time.UTC().Format(time.RFC3339Nano))
+	if len(event.Subject) > 0 {
+		req.Header.Set("Ce-Subject", event.Subject)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the event sink responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSender(t *testing.T) {
+	var lock sync.Mutex
+	var types []string
+	var headers http.Header
+	var data map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		types = append(types, r.Header.Get("Ce-Type"))
+		headers = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+		if r.Header.Get("Ce-Type") == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL, "/apis/build.openshift.io/v1/namespaces/myproject/builds/app-1")
+	sender.Emit(Event{Type: "io.openshift.build.started", Data: map[string]string{"name": "app-1"}})
+	sender.Emit(Event{Type: "rejected", Data: map[string]string{}})
+	sender.Emit(Event{Type: "io.openshift.build.stage.started", Subject: "Build", Data: map[string]string{"stage": "Build"}})
+	sender.Close()
+	// events emitted once the sender is closed are dropped
+	sender.Emit(Event{Type: "io.openshift.build.completed"})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if expected := []string{"io.openshift.build.started", "rejected", "io.openshift.build.stage.started"}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected events %v, got %v", expected, types)
+	}
+	for header, expected := range map[string]string{
+		"Ce-Specversion": "1.0",
+		"Ce-Source":      "/apis/build.openshift.io/v1/namespaces/myproject/builds/app-1",
+		"Ce-Subject":     "Build",
+		"Content-Type":   "application/json",
+	} {
+		if value := headers.Get(header); value != expected {
+			t.Errorf("expected %s %q, got %q", header, expected, value)
+		}
+	}
+	if len(headers.Get("Ce-Id")) == 0 || len(headers.Get("Ce-Time")) == 0 {
+		t.Errorf("expected the event to have an ID and a time, got %v", headers)
+	}
+	if data["stage"] != "Build" {
+		t.Errorf("unexpected data %v", data)
+	}
+}
@@ -1,13 +1,15 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	restclient "k8s.io/client-go/rest"
@@ -19,7 +21,10 @@ import (
 	buildapiv1 "github.com/openshift/api/build/v1"
 	bld "github.com/openshift/builder/pkg/build/builder"
 	"github.com/openshift/builder/pkg/build/builder/cmd/scmauth"
+	"github.com/openshift/builder/pkg/build/builder/metrics"
+	"github.com/openshift/builder/pkg/build/builder/server"
 	"github.com/openshift/builder/pkg/build/builder/timing"
+	"github.com/openshift/builder/pkg/build/builder/tracing"
 	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
 	"github.com/openshift/builder/pkg/version"
@@ -64,6 +69,9 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 	var err error
 
 	cfg.out = out
+	// processes the kernel killed for using too much memory before this
+	// step of the build started did not fail it
+	bld.RecordOOMKillsAtStart()
 
 	buildStr := os.Getenv("BUILD")
 
@@ -78,6 +86,15 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 	if !ok {
 		return nil, fmt.Errorf("build string %s is not a build: %#v", buildStr, obj)
 	}
+	// the build's secrets are masked in the output from here on
+	if rulesPath, ok := os.LookupEnv(builderutil.LogScrubRulesPath); ok && len(rulesPath) > 0 {
+		if _, err := os.Stat(rulesPath); err == nil {
+			if err := bld.LoadLogScrubRules(rulesPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	bld.ConfigureLogScrubbing(cfg.build)
 	if log.Is(4) {
 		redactedBuild := builderutil.SafeForLoggingBuild(cfg.build)
 		bytes, err := runtime.Encode(buildJSONCodec, redactedBuild)
@@ -103,38 +120,40 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 				systemContext.RegistriesDirPath = registriesDirPath
 			}
 		}
+		if mirrorsPath, ok := os.LookupEnv("BUILD_IMAGE_MIRRORS_PATH"); ok && len(mirrorsPath) > 0 {
+			if _, err := os.Stat(mirrorsPath); err == nil {
+				if err := bld.LoadImageMirrors(mirrorsPath); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if registryTLSPath, ok := os.LookupEnv("BUILD_REGISTRY_TLS_CONFIG_PATH"); ok && len(registryTLSPath) > 0 {
+			if _, err := os.Stat(registryTLSPath); err == nil {
+				if err := bld.LoadRegistryTLSConfig(registryTLSPath); err != nil {
+					return nil, err
+				}
+			}
+		}
 		if signaturePolicyPath, ok := os.LookupEnv("BUILD_SIGNATURE_POLICY_PATH"); ok && len(signaturePolicyPath) > 0 {
 			if _, err := os.Stat(signaturePolicyPath); err == nil {
 				systemContext.SignaturePolicyPath = signaturePolicyPath
 			}
 		}
 
-		storeOptions, err := storage.DefaultStoreOptions(false, 0)
+		storeOptions, err := buildStoreOptions(cfg.build)
 		if err != nil {
 			return nil, err
 		}
-		if driver, ok := os.LookupEnv("BUILD_STORAGE_DRIVER"); ok {
-			storeOptions.GraphDriverName = driver
-		}
-		if storageOptions, ok := os.LookupEnv("BUILD_STORAGE_OPTIONS"); ok {
-			if err := json.Unmarshal([]byte(storageOptions), &storeOptions.GraphDriverOptions); err != nil {
-				log.V(0).Infof("Error parsing BUILD_STORAGE_OPTIONS (%q): %v", storageOptions, err)
-				return nil, err
-			}
-		}
-		if storageConfPath, ok := os.LookupEnv("BUILD_STORAGE_CONF_PATH"); ok && len(storageConfPath) > 0 {
-			if _, err := os.Stat(storageConfPath); err == nil {
-				storage.ReloadConfigurationFile(storageConfPath, &storeOptions)
-			}
-		}
-
 		store, err := storage.GetStore(storeOptions)
 		cfg.store = store
 		if err != nil {
 			return nil, err
 		}
 		cfg.cleanup = func() {
-			if _, err := store.Shutdown(false); err != nil {
+			// a cancelled build may leave layers mounted, which would
+			// otherwise be left for the kubelet to deal with
+			cancelled, _ := bld.BuildCancelled()
+			if _, err := store.Shutdown(cancelled); err != nil {
 				log.V(0).Infof("Error shutting down storage: %v", err)
 			}
 		}
@@ -147,6 +166,17 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 		if blobCacheDir, isSet := os.LookupEnv("BUILD_BLOBCACHE_DIR"); isSet {
 			cfg.blobCache = blobCacheDir
 		}
+		// A cache directory kept between builds, $BUILD_CACHE_DIR, takes the
+		// place of the blob cache with one specific to the build's BuildConfig.
+		if cacheDir := os.Getenv(builderutil.BuildCacheDir); len(cacheDir) > 0 {
+			dir, err := setupBuildCache(cfg.build, cacheDir)
+			if err != nil {
+				return nil, err
+			}
+			if len(dir) > 0 {
+				cfg.blobCache = dir
+			}
+		}
 
 		imageOptimizationPolicy := buildapiv1.ImageOptimizationNone
 		if s := cfg.build.Spec.Strategy.DockerStrategy; s != nil {
@@ -159,8 +189,16 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 			// Always use base-image+single-layer builds for S2I builds.
 			imageOptimizationPolicy = buildapiv1.ImageOptimizationSkipLayers
 		}
+		squash, err := bld.GetSquashPolicy(cfg.build)
+		if err != nil {
+			return nil, err
+		}
+		if squash == bld.SquashBuild {
+			// Skipping layers commits what the build adds as one layer.
+			imageOptimizationPolicy = buildapiv1.ImageOptimizationSkipLayers
+		}
 
-		dockerClient, err := bld.GetDaemonlessClient(systemContext, store, os.Getenv("BUILD_ISOLATION"), cfg.blobCache, imageOptimizationPolicy)
+		dockerClient, err := bld.GetDaemonlessClient(systemContext, store, bld.GetIsolationSpec(cfg.build), cfg.blobCache, imageOptimizationPolicy, squash == bld.SquashAll)
 		if err != nil {
 			return nil, fmt.Errorf("no daemonless store: %v", err)
 		}
@@ -173,6 +211,17 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 		cfg.dockerEndpoint = "n/a"
 	}
 
+	cfg.buildsClient, err = newBuildsClient(cfg.build)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// newBuildsClient returns the client through which the status of the build
+// is updated.  RunLocalBuild replaces it, as there is no server to update.
+var newBuildsClient = func(build *buildapiv1.Build) (buildclientv1.BuildInterface, error) {
 	// buildsClient (KUBERNETES_SERVICE_HOST, KUBERNETES_SERVICE_PORT)
 	clientConfig, err := restclient.InClusterConfig()
 	if err != nil {
@@ -182,12 +231,104 @@ func newBuilderConfigFromEnvironment(out io.Writer, needsDocker bool) (*builderC
 	if err != nil {
 		return nil, fmt.Errorf("failed to get client: %v", err)
 	}
-	cfg.buildsClient = buildsClient.Builds(cfg.build.Namespace)
+	return buildsClient.Builds(build.Namespace), nil
+}
 
-	return cfg, nil
+// setupBuildCache returns the directory under cacheDir which holds the blob
+// cache for the build's BuildConfig, after pruning the caches there according
+// to $BUILD_CACHE_MAX_SIZE and $BUILD_CACHE_MAX_AGE.
+func setupBuildCache(build *buildapiv1.Build, cacheDir string) (string, error) {
+	cache := &bld.BuildCache{Root: cacheDir}
+	if value := os.Getenv(builderutil.BuildCacheMaxSize); len(value) > 0 {
+		size, err := resource.ParseQuantity(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s value %q: %v", builderutil.BuildCacheMaxSize, value, err)
+		}
+		cache.MaxSize = size.Value()
+	}
+	if value := os.Getenv(builderutil.BuildCacheMaxAge); len(value) > 0 {
+		age, err := time.ParseDuration(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s value %q: %v", builderutil.BuildCacheMaxAge, value, err)
+		}
+		cache.MaxAge = age
+	}
+	dir, err := cache.Dir(build)
+	if err != nil {
+		return "", fmt.Errorf("unable to set up the build cache in %s: %v", cacheDir, err)
+	}
+	if len(dir) == 0 {
+		log.V(0).Infof("Not using the build cache, the build was not started from a BuildConfig")
+	} else {
+		log.V(0).Infof("Using build cache %s", dir)
+	}
+	if err := cache.Prune(dir); err != nil {
+		log.V(0).Infof("warning: Failed to prune the build cache in %s: %v", cacheDir, err)
+	}
+	return dir, nil
 }
 
-func (c *builderConfig) setupGitEnvironment() (string, []string, error) {
+// buildStoreOptions returns the options of the container storage used by
+// build: those of the builder image's configuration, or of the file named by
+// $BUILD_STORAGE_CONF_PATH, with the storage driver and options chosen by the
+// build or the builder pod.
+func buildStoreOptions(build *buildapiv1.Build) (storage.StoreOptions, error) {
+	storeOptions, err := storage.DefaultStoreOptions(false, 0)
+	if err != nil {
+		return storeOptions, err
+	}
+	if storageConfPath, ok := os.LookupEnv("BUILD_STORAGE_CONF_PATH"); ok && len(storageConfPath) > 0 {
+		if _, err := os.Stat(storageConfPath); err == nil {
+			storage.ReloadConfigurationFile(storageConfPath, &storeOptions)
+		}
+	}
+	// the storage driver and options chosen by the build, or else by
+	// the builder pod, take precedence over the configuration file
+	if err := bld.ConfigureStorage(build, &storeOptions); err != nil {
+		return storeOptions, err
+	}
+	bld.ConfigureRootlessStorage(build, &storeOptions)
+
+	if value := os.Getenv(builderutil.AdditionalImageStores); len(value) > 0 {
+		if option := additionalImageStoreOption(storeOptions.GraphDriverName, value); len(option) > 0 {
+			storeOptions.GraphDriverOptions = append(storeOptions.GraphDriverOptions, option)
+		}
+	}
+	return storeOptions, nil
+}
+
+// additionalImageStoreOption returns the storage driver option which adds the
+// directories in value, a comma-separated list, as read-only image stores
+// whose layers are reused rather than pulled again.  Directories which do not
+// exist, as on nodes without a shared store, are skipped.
+func additionalImageStoreOption(driver, value string) string {
+	if driver != "overlay" && driver != "overlay2" {
+		log.V(0).Infof("warning: Ignoring %s, additional image stores are not supported by the %q storage driver", builderutil.AdditionalImageStores, driver)
+		return ""
+	}
+	var stores []string
+	for _, dir := range strings.Split(value, ",") {
+		dir = strings.TrimSpace(dir)
+		if len(dir) == 0 {
+			continue
+		}
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() || !filepath.IsAbs(dir) {
+			log.V(0).Infof("warning: Not using %s as an additional image store, it is not an existing absolute directory", dir)
+			continue
+		}
+		log.V(2).Infof("Using %s as an additional image store", dir)
+		stores = append(stores, dir)
+	}
+	if len(stores) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s.imagestore=%s", driver, strings.Join(stores, ","))
+}
+
+// setupGitEnvironment returns the environment in which git fetches the
+// build's source with the credentials of its source secret, which may also
+// hold the signing keys of verification.
+func (c *builderConfig) setupGitEnvironment(verification *bld.SourceVerificationPolicy) (string, []string, error) {
 
 	// For now, we only handle git. If not specified, we're done
 	gitSource := c.build.Spec.Source.Git
@@ -195,8 +336,20 @@ func (c *builderConfig) setupGitEnvironment() (string, []string, error) {
 		return "", []string{}, nil
 	}
 
+	hostKeyPolicy, knownHosts, err := bld.GetSSHHostKeyPolicy(c.build, c.sourceSecretDir)
+	if err != nil {
+		return "", nil, err
+	}
+	hostKeys := scmauth.SSHHostKeys{Policy: hostKeyPolicy, KnownHostsFile: knownHosts}
+
+	hostSecrets, err := bld.GetGitSubmoduleSecrets(c.build)
+	if err != nil {
+		return "", nil, err
+	}
+
 	sourceSecret := c.build.Spec.Source.SourceSecret
 	gitEnv := []string{"GIT_ASKPASS=true"}
+	secretsEnv := []string{}
 	// If a source secret is present, set it up and add its environment variables
 	if sourceSecret != nil {
 		// TODO: this should be refactored to let each source type manage which secrets
@@ -205,17 +358,39 @@ func (c *builderConfig) setupGitEnvironment() (string, []string, error) {
 		if err != nil {
 			return "", nil, fmt.Errorf("cannot parse build URL: %s", gitSource.URI)
 		}
-		scmAuths := scmauth.GitAuths(sourceURL)
+		scmAuths := scmauth.GitAuths(sourceURL, hostKeys)
 
-		secretsEnv, overrideURL, err := scmAuths.Setup(c.sourceSecretDir)
+		// a source secret may hold only the credentials of other hosts, the
+		// keys of known hosts or signing keys
+		present, err := scmAuths.Present(c.sourceSecretDir)
 		if err != nil {
 			return c.sourceSecretDir, nil, fmt.Errorf("cannot setup source secret: %v", err)
 		}
-		if overrideURL != nil {
-			gitSource.URI = overrideURL.String()
+		if present || (len(hostSecrets) == 0 && len(knownHosts) == 0 && (verification == nil || len(verification.KeyFiles) == 0)) {
+			env, overrideURL, err := scmAuths.Setup(c.sourceSecretDir)
+			if err != nil {
+				return c.sourceSecretDir, nil, fmt.Errorf("cannot setup source secret: %v", err)
+			}
+			if overrideURL != nil {
+				gitSource.URI = overrideURL.String()
+			}
+			secretsEnv = env
+		}
+	}
+	// Hosts which no source secret has set up ssh for still verify host keys
+	// as requested
+	if secretsEnv, err = scmauth.SetupSSHHostKeys(hostKeys, secretsEnv); err != nil {
+		return c.sourceSecretDir, nil, fmt.Errorf("cannot setup SSH host key verification: %v", err)
+	}
+	// Credentials for other hosts, such as those of submodules, are scoped so
+	// that git only presents them to their own host
+	if len(hostSecrets) > 0 {
+		secretsEnv, err = scmauth.SetupHostSecrets(c.sourceSecretDir, hostSecrets, hostKeys, secretsEnv)
+		if err != nil {
+			return c.sourceSecretDir, nil, fmt.Errorf("cannot setup submodule secrets: %v", err)
 		}
-		gitEnv = append(gitEnv, secretsEnv...)
 	}
+	gitEnv = append(gitEnv, secretsEnv...)
 	if gitSource.HTTPProxy != nil && len(*gitSource.HTTPProxy) > 0 {
 		gitEnv = append(gitEnv, fmt.Sprintf("HTTP_PROXY=%s", *gitSource.HTTPProxy))
 		gitEnv = append(gitEnv, fmt.Sprintf("http_proxy=%s", *gitSource.HTTPProxy))
@@ -232,23 +407,31 @@ func (c *builderConfig) setupGitEnvironment() (string, []string, error) {
 }
 
 // clone is responsible for cloning the source referenced in the buildconfig
-func (c *builderConfig) clone() error {
-	ctx := timing.NewContext(context.Background())
+func (c *builderConfig) clone() (err error) {
+	ctx := timing.NewContext(bld.BuildContext())
 	var sourceRev *buildapiv1.SourceRevision
 	defer func() {
 		c.build.Status.Stages = timing.GetStages(ctx)
+		bld.SetCancelledStatus(c.build)
+		bld.RecordBuildFailure(c.build, err)
 		bld.HandleBuildStatusUpdate(c.build, c.buildsClient, sourceRev)
 	}()
-	secretTmpDir, gitEnv, err := c.setupGitEnvironment()
+	verification, err := bld.GetSourceVerificationPolicy(c.build, c.sourceSecretDir)
+	if err != nil {
+		c.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		c.build.Status.Reason = buildapiv1.StatusReasonFetchSourceFailed
+		c.build.Status.Message = builderutil.StatusMessageFetchSourceFailed
+		return err
+	}
+	secretTmpDir, gitEnv, err := c.setupGitEnvironment(verification)
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(secretTmpDir)
 
-	gitClient := git.NewRepositoryWithEnv(gitEnv)
+	gitClient := bld.NewGitClient(gitEnv)
 
-	buildDir := bld.InputContentPath
-	sourceInfo, err := bld.GitClone(ctx, gitClient, c.build.Spec.Source.Git, c.build.Spec.Revision, buildDir)
+	cloneOptions, err := bld.GetGitCloneOptions(c.build)
 	if err != nil {
 		c.build.Status.Phase = buildapiv1.BuildPhaseFailed
 		c.build.Status.Reason = buildapiv1.StatusReasonFetchSourceFailed
@@ -256,11 +439,16 @@ func (c *builderConfig) clone() error {
 		return err
 	}
 
-	if sourceInfo != nil {
-		sourceRev = bld.GetSourceRevision(c.build, sourceInfo)
+	if err := bld.RunBuildHook(ctx, c.dockerClient, c.build, nil, bld.HookPreSource); err != nil {
+		return err
 	}
 
-	err = bld.ExtractInputBinary(os.Stdin, c.build.Spec.Source.Binary, buildDir)
+	buildDir := bld.InputContentPath
+	var sourceInfo *git.SourceInfo
+	fetchers, err := bld.NewSourceFetchers(c.build, gitClient, cloneOptions, os.Stdin)
+	if err == nil {
+		sourceInfo, err = bld.FetchSource(ctx, fetchers, buildDir)
+	}
 	if err != nil {
 		c.build.Status.Phase = buildapiv1.BuildPhaseFailed
 		c.build.Status.Reason = buildapiv1.StatusReasonFetchSourceFailed
@@ -268,6 +456,10 @@ func (c *builderConfig) clone() error {
 		return err
 	}
 
+	if sourceInfo != nil {
+		sourceRev = bld.GetSourceRevision(c.build, sourceInfo)
+	}
+
 	if len(c.build.Spec.Source.ContextDir) > 0 {
 		if _, err := os.Stat(filepath.Join(buildDir, c.build.Spec.Source.ContextDir)); os.IsNotExist(err) {
 			err = fmt.Errorf("provided context directory does not exist: %s", c.build.Spec.Source.ContextDir)
@@ -278,13 +470,27 @@ func (c *builderConfig) clone() error {
 		}
 	}
 
-	return nil
+	if err := bld.VerifySource(gitEnv, c.build, buildDir, verification); err != nil {
+		c.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		c.build.Status.Reason = bld.StatusReasonSourceVerificationFailed
+		c.build.Status.Message = builderutil.StatusMessageSourceVerificationFailed
+		return err
+	}
+	if details, err := bld.GetRevisionDetails(gitClient, c.build, buildDir); err != nil {
+		log.V(0).Infof("warning: Unable to read the details of the source revision: %v", err)
+	} else {
+		bld.RecordRevisionDetails(details)
+	}
+
+	return bld.RunBuildHook(ctx, c.dockerClient, c.build, nil, bld.HookPostSource)
 }
 
-func (c *builderConfig) extractImageContent() error {
-	ctx := timing.NewContext(context.Background())
+func (c *builderConfig) extractImageContent() (err error) {
+	ctx := timing.NewContext(bld.BuildContext())
 	defer func() {
 		c.build.Status.Stages = timing.GetStages(ctx)
+		bld.SetCancelledStatus(c.build)
+		bld.RecordBuildFailure(c.build, err)
 		bld.HandleBuildStatusUpdate(c.build, c.buildsClient, nil)
 	}()
 
@@ -334,7 +540,162 @@ func runBuild(out io.Writer, builder builder) error {
 	if cfg.cleanup != nil {
 		defer cfg.cleanup()
 	}
-	return cfg.execute(builder)
+	if err := bld.ResolveBuildEnvReferences(cfg.build); err != nil {
+		cfg.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		cfg.build.Status.Reason = buildapiv1.StatusReasonUnresolvableEnvironmentVariable
+		cfg.build.Status.Message = builderutil.StatusMessageUnresolvableEnvironmentVariable
+		bld.HandleBuildStatusUpdate(cfg.build, cfg.buildsClient, nil)
+		return err
+	}
+	if err := bld.ConfigureImageRetries(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureTransientRetries(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigurePushConcurrency(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureRegistryTokenAuth(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureProgressReporting(cfg.build, cfg.buildsClient); err != nil {
+		return err
+	}
+	if err := bld.ConfigureStageTimeouts(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureHeartbeat(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureImageFormat(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureImageCompression(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureHermeticBuild(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureProcessLimits(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureBandwidthLimits(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigurePullPlatform(cfg.build); err != nil {
+		return err
+	}
+	if err := bld.ConfigureStageParallelism(cfg.build); err != nil {
+		return err
+	}
+	storageRoot := ""
+	if cfg.store != nil {
+		storageRoot = cfg.store.GraphRoot()
+	}
+	if err := bld.ConfigureDiskWatchdog(cfg.build, storageRoot); err != nil {
+		return err
+	}
+	if cfg.store != nil {
+		bld.ReportVFSFallback(cfg.build, cfg.buildsClient, cfg.store.GraphDriverName())
+	}
+	if err := bld.ConfigureCloudEvents(cfg.build); err != nil {
+		return err
+	}
+	finishMetrics, err := setupMetrics(cfg.build)
+	if err != nil {
+		return err
+	}
+	stopWatching := bld.WatchForCancellation(cfg.build, cfg.buildsClient)
+	err = cfg.execute(builder)
+	stopWatching()
+	if err != nil {
+		bld.SetCancelledStatus(cfg.build)
+		bld.RecordBuildFailure(cfg.build, err)
+		bld.HandleBuildStatusUpdate(cfg.build, cfg.buildsClient, nil)
+	}
+	finishMetrics(err)
+	bld.NotifyBuildCompletion(cfg.build, err)
+	bld.EmitBuildCompletion(cfg.build, err)
+	exportTrace(cfg.build)
+	return err
+}
+
+// setupMetrics serves metrics about the build on $BUILD_METRICS_ADDRESS while
+// it runs, if set.  The returned function records the result of the build and
+// pushes the metrics to the Pushgateway at $BUILD_METRICS_PUSHGATEWAY, if set.
+func setupMetrics(build *buildapiv1.Build) (func(error), error) {
+	metrics.SetBuild(build)
+	stop := func() {}
+	if address := os.Getenv(builderutil.MetricsAddress); len(address) > 0 {
+		var err error
+		if stop, err = metrics.Serve(address); err != nil {
+			return nil, fmt.Errorf("unable to serve metrics on %s: %v", address, err)
+		}
+	}
+	return func(buildErr error) {
+		metrics.RecordResult(build, buildErr)
+		if gateway := os.Getenv(builderutil.MetricsPushgateway); len(gateway) > 0 {
+			if err := metrics.Push(gateway); err != nil {
+				log.V(0).Infof("warning: Failed to push metrics to %s: %v", gateway, err)
+			}
+		}
+		stop()
+	}, nil
+}
+
+// exportTrace exports the timings of the stages the build has recorded as
+// spans to the OpenTelemetry collector at $OTEL_EXPORTER_OTLP_ENDPOINT, if
+// set.  Each of the build's containers exports the stages it ran.
+func exportTrace(build *buildapiv1.Build) {
+	url := tracing.TracesURL(os.Getenv(builderutil.OTLPTracesEndpoint), os.Getenv(builderutil.OTLPEndpoint))
+	if len(url) == 0 {
+		return
+	}
+	if err := tracing.Export(url, build, build.Status.Stages, os.Getenv(builderutil.TraceParent)); err != nil {
+		log.V(0).Infof("warning: Failed to export build trace to %s: %v", url, err)
+	}
+}
+
+// withLogFormat calls run with the process output in the format requested by
+// $BUILD_LOG_FORMAT, either "text" (the default) or "json", and with the
+// secrets of the build masked in it.  The builder masks them in its own
+// messages and in the error run returns, and, in the JSON format, whose
+// pipes all of the process output goes through, in that of the processes it
+// runs as well.
+func withLogFormat(run func() error) error {
+	format := os.Getenv(builderutil.LogFormat)
+	switch format {
+	case "", "text":
+		return scrubError(run())
+	case "json":
+	default:
+		return fmt.Errorf("invalid %s value %q, expected \"text\" or \"json\"", builderutil.LogFormat, format)
+	}
+	restore, err := utillog.RedirectToJSON()
+	if err != nil {
+		return fmt.Errorf("unable to format output as JSON: %v", err)
+	}
+	defer restore()
+	if err := scrubError(run()); err != nil {
+		// the caller reports the error once the output is restored, but
+		// record it alongside the rest of the build's output as well
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// scrubError returns err with the secrets of the build masked, as the caller
+// reports it where the output is not scrubbed.
+func scrubError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if scrubbed := utillog.Scrub(err.Error()); scrubbed != err.Error() {
+		return errors.New(scrubbed)
+	}
+	return err
 }
 
 // RunDockerBuild creates a docker builder and runs its build
@@ -347,7 +708,9 @@ func RunDockerBuild(out io.Writer) error {
 	case log.Is(0):
 		serviceability.InitLogrus("WARN")
 	}
-	return runBuild(out, dockerBuilder{})
+	return withLogFormat(func() error {
+		return runBuild(out, dockerBuilder{})
+	})
 }
 
 // RunS2IBuild creates a S2I builder and runs its build
@@ -360,7 +723,9 @@ func RunS2IBuild(out io.Writer) error {
 	case log.Is(0):
 		serviceability.InitLogrus("WARN")
 	}
-	return runBuild(out, s2iBuilder{})
+	return withLogFormat(func() error {
+		return runBuild(out, s2iBuilder{})
+	})
 }
 
 // RunGitClone performs a git clone using the build defined in the environment
@@ -373,15 +738,31 @@ func RunGitClone(out io.Writer) error {
 	case log.Is(0):
 		serviceability.InitLogrus("WARN")
 	}
-	logVersion()
-	cfg, err := newBuilderConfigFromEnvironment(out, false)
-	if err != nil {
+	return withLogFormat(func() error {
+		logVersion()
+		// the source build hooks run in containers of their own
+		cfg, err := newBuilderConfigFromEnvironment(out, bld.HasBuildHooks(bld.HookPreSource, bld.HookPostSource))
+		if err != nil {
+			return err
+		}
+		if cfg.cleanup != nil {
+			defer cfg.cleanup()
+		}
+		if err := bld.ConfigureStageTimeouts(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigureHeartbeat(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigureTransientRetries(cfg.build); err != nil {
+			return err
+		}
+		stopWatching := bld.WatchForCancellation(cfg.build, cfg.buildsClient)
+		err = cfg.clone()
+		stopWatching()
+		exportTrace(cfg.build)
 		return err
-	}
-	if cfg.cleanup != nil {
-		defer cfg.cleanup()
-	}
-	return cfg.clone()
+	})
 }
 
 // RunManageDockerfile manipulates the dockerfile for docker builds.
@@ -400,15 +781,53 @@ func RunManageDockerfile(out io.Writer) error {
 	case log.Is(0):
 		serviceability.InitLogrus("WARN")
 	}
+	return withLogFormat(func() error {
+		logVersion()
+		cfg, err := newBuilderConfigFromEnvironment(out, false)
+		if err != nil {
+			return err
+		}
+		if cfg.cleanup != nil {
+			defer cfg.cleanup()
+		}
+		return bld.ManageDockerfile(bld.InputContentPath, cfg.build)
+	})
+}
+
+// defaultBuildServerSocket is where the build server listens, unless
+// $BUILD_SERVER_SOCKET says otherwise.
+const defaultBuildServerSocket = "/var/run/openshift-builder/builder.sock"
+
+// buildServerWorkDir holds the work directories of the builds which the build
+// server runs.
+const buildServerWorkDir = "/var/tmp/openshift-builder/builds"
+
+// RunBuildServer accepts builds on $BUILD_SERVER_SOCKET, running them one at a
+// time with the server's storage and configuration until it is stopped.  It
+// runs once per node, as by a daemonset sharing the socket's directory with
+// the build pods of the node through a host path volume, so that the images
+// which one build pulls are there for the next.  The secrets and configmaps
+// of each build are forwarded by its pod with each of its steps.
+func RunBuildServer(out io.Writer) error {
 	logVersion()
-	cfg, err := newBuilderConfigFromEnvironment(out, false)
+	socket := os.Getenv(builderutil.BuildServerSocket)
+	if len(socket) == 0 {
+		socket = defaultBuildServerSocket
+	}
+	executable, err := os.Executable()
 	if err != nil {
+		return fmt.Errorf("unable to find the builder binary: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
 		return err
 	}
-	if cfg.cleanup != nil {
-		defer cfg.cleanup()
+	listener, err := server.Listen(socket)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", socket, err)
 	}
-	return bld.ManageDockerfile(bld.InputContentPath, cfg.build)
+	log.V(0).Infof("Accepting builds on %s", socket)
+	s := &server.Server{Executable: executable, Env: os.Environ(), WorkDir: buildServerWorkDir, ForwardFiles: true}
+	return s.Serve(listener)
 }
 
 // RunExtractImageContent extracts files from existing images
@@ -422,15 +841,36 @@ func RunExtractImageContent(out io.Writer) error {
 	case log.Is(0):
 		serviceability.InitLogrus("WARN")
 	}
-	logVersion()
-	cfg, err := newBuilderConfigFromEnvironment(out, true)
-	if err != nil {
+	return withLogFormat(func() error {
+		logVersion()
+		cfg, err := newBuilderConfigFromEnvironment(out, true)
+		if err != nil {
+			return err
+		}
+		if cfg.cleanup != nil {
+			defer cfg.cleanup()
+		}
+		if err := bld.ConfigureStageTimeouts(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigureHeartbeat(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigureBandwidthLimits(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigurePullPlatform(cfg.build); err != nil {
+			return err
+		}
+		if err := bld.ConfigureRegistryTokenAuth(cfg.build); err != nil {
+			return err
+		}
+		stopWatching := bld.WatchForCancellation(cfg.build, cfg.buildsClient)
+		err = cfg.extractImageContent()
+		stopWatching()
+		exportTrace(cfg.build)
 		return err
-	}
-	if cfg.cleanup != nil {
-		defer cfg.cleanup()
-	}
-	return cfg.extractImageContent()
+	})
 }
 
 // logVersion logs the version of openshift-builder.
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	bld "github.com/openshift/builder/pkg/build/builder"
+)
+
+// TestCloneVerifiesSSHHostKeys clones over ssh with the environment which
+// the clone step sets up, and checks how the ssh which git runs is told to
+// verify the keys of the host.
+func TestCloneVerifiesSSHHostKeys(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		secret map[string]string
+	}{
+		{
+			name:   "ssh key",
+			secret: map[string]string{"ssh-privatekey": "key\n", "known_hosts": "git.example.com ssh-ed25519 AAAA\n"},
+		},
+		{
+			name:   "known hosts only",
+			secret: map[string]string{"known_hosts": "git.example.com ssh-ed25519 AAAA\n"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testCloneVerifiesSSHHostKeys(t, test.secret)
+		})
+	}
+}
+
+func testCloneVerifiesSSHHostKeys(t *testing.T, secret map[string]string) {
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the source secret, as it is mounted into the clone step
+	secretDir := filepath.Join(dir, "secret")
+	if err := os.Mkdir(secretDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range secret {
+		if err := ioutil.WriteFile(filepath.Join(secretDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an ssh which records its arguments in place of connecting
+	binDir := filepath.Join(dir, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	args := filepath.Join(dir, "args")
+	if err := ioutil.WriteFile(filepath.Join(binDir, "ssh"), []byte("#!/bin/sh\necho \"$@\" >> "+args+"\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "ssh://git@git.example.com/app.git"}
+	build.Spec.Source.SourceSecret = &corev1.LocalObjectReference{Name: "source"}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: []corev1.EnvVar{{Name: "BUILD_SSH_HOST_KEY_POLICY", Value: "strict"}}}
+	c := &builderConfig{build: build, sourceSecretDir: secretDir}
+	_, gitEnv, err := c.setupGitEnvironment(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := bld.GitClone(context.Background(), bld.NewGitClient(gitEnv), build.Spec.Source.Git, nil, filepath.Join(dir, "source"), bld.GitCloneOptions{}); err == nil {
+		t.Fatalf("expected the clone to fail")
+	}
+	data, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatalf("expected git to run ssh: %v", err)
+	}
+	for _, expected := range []string{"-o StrictHostKeyChecking=yes", "-o UserKnownHostsFile=" + filepath.Join(secretDir, "known_hosts"), "git.example.com"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected ssh to be run with %q, got %q", expected, string(data))
+		}
+	}
+}
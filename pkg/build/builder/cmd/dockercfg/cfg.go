@@ -73,12 +73,17 @@ func SetSystemContextFilePath(sc *types.SystemContext, path string) {
 	sc.AuthFilePath = path
 }
 
-// GetDockerAuth returns a valid Docker AuthConfiguration entry, and whether it was read
-// from the local dockercfg file
+// GetDockerAuth returns a valid Docker AuthConfiguration entry, and whether one was
+// found, from the first resolver of the Keychain which has credentials for the image
 func (h *Helper) GetDockerAuth(imageName, authType string) (docker.AuthConfiguration, bool) {
 	log.V(3).Infof("Locating docker auth for image %s and type %s", imageName, authType)
-	searchPaths := h.GetDockerAuthSearchPaths(authType)
+	auth, _, ok := Keychain.resolve(imageName, authType)
+	return auth, ok
+}
 
+// getKeyringAuth returns the credentials for the image from the dockercfg
+// files in searchPaths, and whether it has them.
+func getKeyringAuth(imageName string, searchPaths []string) (docker.AuthConfiguration, bool) {
 	cfg, err := GetDockerConfig(searchPaths)
 	if err != nil {
 		klog.Errorf("Reading docker config from %v failed: %v", searchPaths, err)
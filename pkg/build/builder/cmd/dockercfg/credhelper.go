@@ -0,0 +1,127 @@
+package dockercfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerHubServerURL is the server URL that credential helpers store the
+// credentials of Docker Hub under.
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// credentialHelperCommand returns the command which runs the credential
+// helper named helper, such as ecr-login, gcr or acr-env, with the given
+// action.
+var credentialHelperCommand = func(helper, action string) *exec.Cmd {
+	return exec.Command("docker-credential-"+helper, action)
+}
+
+// credentialHelperConfig is the part of a docker config.json which names the
+// credential helpers that credentials are taken from.
+type credentialHelperConfig struct {
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// credentialHelperResponse is the output of a credential helper's get action.
+type credentialHelperResponse struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// getCredentialHelperAuth returns the credentials for the registry of
+// imageName from the credential helper which the docker config.json in
+// searchPaths names for it, and whether there was one.  Helpers such as those
+// of ECR, GCR and ACR exchange the cloud identity of the build for a
+// short-lived registry token.
+func getCredentialHelperAuth(imageName string, searchPaths []string) (docker.AuthConfiguration, bool) {
+	helper, serverURL := credentialHelperFor(imageName, searchPaths, true)
+	if len(helper) == 0 {
+		return docker.AuthConfiguration{}, false
+	}
+	auth, err := runCredentialHelper(helper, serverURL)
+	if err != nil {
+		log.V(0).Infof("warning: Failed to get the credentials for %s from the credential helper %q: %v", serverURL, helper, err)
+		return docker.AuthConfiguration{}, false
+	}
+	log.V(3).Infof("Using %s user from the credential helper %q for Docker authentication for image %s", auth.Username, helper, imageName)
+	return auth, true
+}
+
+// credentialHelperFor returns the credential helper which the docker
+// config.json in searchPaths names for the registry of imageName in
+// credHelpers, or else in credsStore if store is set, and the server URL the
+// helper keeps the registry's credentials under.
+func credentialHelperFor(imageName string, searchPaths []string, store bool) (string, string) {
+	path := GetDockerConfigPath(searchPaths)
+	if len(path) == 0 {
+		return "", ""
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+	var cfg credentialHelperConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", ""
+	}
+	registry := reference.Domain(named)
+	helper, ok := cfg.CredHelpers[registry]
+	if !ok && store {
+		helper = cfg.CredsStore
+	}
+	if registry == "docker.io" {
+		return helper, dockerHubServerURL
+	}
+	return helper, registry
+}
+
+// runCredentialHelper returns the credentials for serverURL from the
+// credential helper named helper.
+func runCredentialHelper(helper, serverURL string) (docker.AuthConfiguration, error) {
+	cmd := credentialHelperCommand(helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var response credentialHelperResponse
+	if err := json.Unmarshal(out, &response); err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("error parsing the credential helper's output: %v", err)
+	}
+	if len(response.Secret) == 0 {
+		return docker.AuthConfiguration{}, fmt.Errorf("the credential helper returned no secret")
+	}
+	return docker.AuthConfiguration{
+		Username:      response.Username,
+		Password:      response.Secret,
+		ServerAddress: serverURL,
+	}, nil
+}
+
+// RefreshDockerAuth returns new credentials for imageName, to replace auth
+// when a registry refuses it because the token it holds has expired, as can
+// happen when a push takes longer than the token's lifetime.  It returns
+// false unless the credentials come from a resolver whose credentials expire.
+func (h *Helper) RefreshDockerAuth(imageName, authType string, auth docker.AuthConfiguration) (docker.AuthConfiguration, bool) {
+	refreshed, resolver, ok := Keychain.resolve(imageName, authType)
+	if !ok || !resolver.ShortLived() {
+		return auth, false
+	}
+	log.V(0).Infof("Refreshed the credentials for image %s from %s", imageName, resolver.Name())
+	return refreshed, true
+}
@@ -0,0 +1,61 @@
+package dockercfg
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetCredentialHelperAuth(t *testing.T) {
+	defer func(command func(helper, action string) *exec.Cmd) {
+		credentialHelperCommand = command
+	}(credentialHelperCommand)
+	var helpers []string
+	credentialHelperCommand = func(helper, action string) *exec.Cmd {
+		helpers = append(helpers, helper)
+		if helper == "broken" {
+			return exec.Command("sh", "-c", "echo no credentials >&2; exit 1")
+		}
+		// echo the server URL read from stdin back as the username
+		return exec.Command("sh", "-c", `read url; echo "{\"ServerURL\": \"$url\", \"Username\": \"$url\", \"Secret\": \"`+helper+`-token\"}"`)
+	}
+
+	dir, err := ioutil.TempDir("", "credhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := `{"auths": {"quay.io": {"auth": "Zm9vOmJhcg=="}}, "credHelpers": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login", "gcr.io": "broken"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, ok := getCredentialHelperAuth("123456789012.dkr.ecr.us-east-1.amazonaws.com/app:latest", []string{dir})
+	if !ok || auth.Username != "123456789012.dkr.ecr.us-east-1.amazonaws.com" || auth.Password != "ecr-login-token" {
+		t.Errorf("expected credentials from ecr-login, got %#v, %v", auth, ok)
+	}
+	if _, ok := getCredentialHelperAuth("gcr.io/project/app:latest", []string{dir}); ok {
+		t.Errorf("expected no credentials from a failing helper")
+	}
+	if _, ok := getCredentialHelperAuth("quay.io/app:latest", []string{dir}); ok {
+		t.Errorf("expected no credentials from a helper for a registry without one")
+	}
+	if auth, ok := NewHelper().GetDockerAuth("quay.io/app:latest", "TMP_CREDHELPER_UNSET_ENV"); ok {
+		t.Errorf("expected no credentials without a config, got %#v", auth)
+	}
+
+	content = `{"credsStore": "desktop"}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helpers = nil
+	auth, ok = getCredentialHelperAuth("busybox", []string{dir})
+	if !ok || auth.Username != "https://index.docker.io/v1/" || auth.Password != "desktop-token" {
+		t.Errorf("expected Docker Hub credentials from the credentials store, got %#v, %v", auth, ok)
+	}
+	if len(helpers) != 1 || helpers[0] != "desktop" {
+		t.Errorf("expected the desktop helper to be run, got %v", helpers)
+	}
+}
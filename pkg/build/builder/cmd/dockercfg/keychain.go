@@ -0,0 +1,158 @@
+package dockercfg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ServiceAccountTokenPath is where the kubelet mounts the bound service
+// account token of the build pod.
+const ServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Resolver looks up registry credentials from one source.
+type Resolver interface {
+	// Name identifies the source in the builder's log.
+	Name() string
+	// Resolve returns the credentials for imageName, for the push or pull
+	// that authType selects, and whether the source has them.
+	Resolve(imageName, authType string) (docker.AuthConfiguration, bool)
+	// ShortLived returns whether the credentials expire, and are worth
+	// resolving again when a registry refuses them.
+	ShortLived() bool
+}
+
+// ResolverChain is an ordered list of resolvers.  The credentials for an
+// image are taken from the first resolver which has them.
+type ResolverChain []Resolver
+
+// Keychain is the ResolverChain that the builder looks up registry
+// credentials with.  Builders which take credentials from other sources can
+// add resolvers to it before a build starts.  The credential helpers named by
+// the mounted secret come before the node's pull secret, as the secret's
+// auths do.
+var Keychain = ResolverChain{
+	MountedSecretResolver{},
+	CredentialHelperResolver{},
+	NodePullSecretResolver{Dir: "/var/lib/kubelet"},
+}
+
+// resolve returns the credentials for imageName, and the resolver they were
+// taken from, from the first resolver of c which has them.
+func (c ResolverChain) resolve(imageName, authType string) (docker.AuthConfiguration, Resolver, bool) {
+	for _, resolver := range c {
+		if auth, ok := resolver.Resolve(imageName, authType); ok {
+			log.V(2).Infof("Using credentials from %s for image %s", resolver.Name(), imageName)
+			return auth, resolver, true
+		}
+	}
+	log.V(2).Infof("No credentials found for image %s", imageName)
+	return docker.AuthConfiguration{}, nil, false
+}
+
+// MountedSecretResolver resolves credentials from the auths of the push or
+// pull secret mounted into the build pod.
+type MountedSecretResolver struct{}
+
+func (MountedSecretResolver) Name() string {
+	return "the mounted secret"
+}
+
+func (MountedSecretResolver) Resolve(imageName, authType string) (docker.AuthConfiguration, bool) {
+	searchPaths := NewHelper().GetDockerAuthSearchPaths(authType)
+	// a registry's credential helper takes precedence over its auths
+	if helper, _ := credentialHelperFor(imageName, searchPaths, false); len(helper) > 0 {
+		return docker.AuthConfiguration{}, false
+	}
+	return getKeyringAuth(imageName, searchPaths)
+}
+
+func (MountedSecretResolver) ShortLived() bool {
+	return false
+}
+
+// NodePullSecretResolver resolves the credentials of pulls from the node's
+// pull secret, the config.json in Dir, if it is mounted into the build pod.
+type NodePullSecretResolver struct {
+	Dir string
+}
+
+func (r NodePullSecretResolver) Name() string {
+	return "the node pull secret in " + r.Dir
+}
+
+func (r NodePullSecretResolver) Resolve(imageName, authType string) (docker.AuthConfiguration, bool) {
+	if authType != PullAuthType || len(GetDockerConfigPath([]string{r.Dir})) == 0 {
+		return docker.AuthConfiguration{}, false
+	}
+	return getKeyringAuth(imageName, []string{filepath.Clean(r.Dir)})
+}
+
+func (NodePullSecretResolver) ShortLived() bool {
+	return false
+}
+
+// CredentialHelperResolver resolves credentials with the credential helpers
+// that the push or pull secret mounted into the build pod names.
+type CredentialHelperResolver struct{}
+
+func (CredentialHelperResolver) Name() string {
+	return "a credential helper"
+}
+
+func (CredentialHelperResolver) Resolve(imageName, authType string) (docker.AuthConfiguration, bool) {
+	return getCredentialHelperAuth(imageName, NewHelper().GetDockerAuthSearchPaths(authType))
+}
+
+func (CredentialHelperResolver) ShortLived() bool {
+	return true
+}
+
+// ServiceAccountTokenResolver resolves the credentials of pushes to, and
+// pulls from, Registries from the build pod's bound service account token at
+// TokenPath, which the integrated registry accepts in place of a password, so
+// that builds need no dockercfg secret for it.  The kubelet rotates the token
+// before it expires, so it is read again each time it is resolved.
+type ServiceAccountTokenResolver struct {
+	TokenPath  string
+	Registries []string
+}
+
+func (r ServiceAccountTokenResolver) Name() string {
+	return "the service account token"
+}
+
+func (r ServiceAccountTokenResolver) Resolve(imageName, authType string) (docker.AuthConfiguration, bool) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return docker.AuthConfiguration{}, false
+	}
+	registry := reference.Domain(named)
+	for _, candidate := range r.Registries {
+		if candidate != registry {
+			continue
+		}
+		token, err := ioutil.ReadFile(r.TokenPath)
+		if err != nil {
+			log.V(4).Infof("Unable to read the service account token %s: %v", r.TokenPath, err)
+			return docker.AuthConfiguration{}, false
+		}
+		if len(strings.TrimSpace(string(token))) == 0 {
+			return docker.AuthConfiguration{}, false
+		}
+		// the registry checks the token, and ignores the user name
+		return docker.AuthConfiguration{
+			Username:      "serviceaccount",
+			Password:      strings.TrimSpace(string(token)),
+			ServerAddress: registry,
+		}, true
+	}
+	return docker.AuthConfiguration{}, false
+}
+
+func (ServiceAccountTokenResolver) ShortLived() bool {
+	return true
+}
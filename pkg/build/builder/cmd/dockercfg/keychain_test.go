@@ -0,0 +1,173 @@
+package dockercfg
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type fakeResolver struct {
+	name   string
+	images map[string]string
+	calls  *[]string
+}
+
+func (r fakeResolver) Name() string {
+	return r.name
+}
+
+func (r fakeResolver) Resolve(imageName, authType string) (docker.AuthConfiguration, bool) {
+	*r.calls = append(*r.calls, r.name)
+	password, ok := r.images[imageName]
+	return docker.AuthConfiguration{Username: r.name, Password: password}, ok
+}
+
+func (r fakeResolver) ShortLived() bool {
+	return r.name == "token"
+}
+
+func TestKeychain(t *testing.T) {
+	defer func(keychain ResolverChain) {
+		Keychain = keychain
+	}(Keychain)
+	var calls []string
+	Keychain = ResolverChain{
+		fakeResolver{name: "secret", images: map[string]string{"quay.io/app": "secret-password"}, calls: &calls},
+		fakeResolver{name: "token", images: map[string]string{"quay.io/app": "other", "registry.example.com/app": "token-password"}, calls: &calls},
+	}
+
+	auth, ok := NewHelper().GetDockerAuth("quay.io/app", PushAuthType)
+	if !ok || auth.Username != "secret" || len(calls) != 1 {
+		t.Errorf("expected credentials from the first resolver, got %#v, %v after %v", auth, ok, calls)
+	}
+	if _, ok := NewHelper().RefreshDockerAuth("quay.io/app", PushAuthType, auth); ok {
+		t.Errorf("expected long-lived credentials not to be refreshed")
+	}
+
+	calls = nil
+	auth, ok = NewHelper().GetDockerAuth("registry.example.com/app", PushAuthType)
+	if !ok || auth.Username != "token" || len(calls) != 2 {
+		t.Errorf("expected credentials from the second resolver, got %#v, %v after %v", auth, ok, calls)
+	}
+	if refreshed, ok := NewHelper().RefreshDockerAuth("registry.example.com/app", PushAuthType, auth); !ok || refreshed.Password != "token-password" {
+		t.Errorf("expected short-lived credentials to be refreshed, got %#v, %v", refreshed, ok)
+	}
+
+	if _, ok := NewHelper().GetDockerAuth("docker.io/library/busybox", PushAuthType); ok {
+		t.Errorf("expected no credentials for an image no resolver has")
+	}
+}
+
+func TestMountedSecretResolverDefersToCredentialHelpers(t *testing.T) {
+	defer func(command func(helper, action string) *exec.Cmd) {
+		credentialHelperCommand = command
+	}(credentialHelperCommand)
+	credentialHelperCommand = func(helper, action string) *exec.Cmd {
+		return exec.Command("echo", `{"Username": "AWS", "Secret": "token"}`)
+	}
+
+	dir, err := ioutil.TempDir("", "keychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := `{"auths": {"quay.io": {"auth": "Zm9vOmJhcg=="}, "123456789012.dkr.ecr.us-east-1.amazonaws.com": {"auth": "Zm9vOmJhcg=="}}, "credHelpers": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	const authType = "TMP_KEYCHAIN_DOCKER_CFG_PATH"
+	os.Setenv(authType, dir)
+	defer os.Unsetenv(authType)
+
+	if auth, ok := (MountedSecretResolver{}).Resolve("quay.io/app", authType); !ok || auth.Username != "foo" {
+		t.Errorf("expected the mounted secret's credentials for quay.io, got %#v, %v", auth, ok)
+	}
+	if _, ok := (MountedSecretResolver{}).Resolve("123456789012.dkr.ecr.us-east-1.amazonaws.com/app", authType); ok {
+		t.Errorf("expected the mounted secret to defer to the credential helper")
+	}
+	if auth, ok := NewHelper().GetDockerAuth("123456789012.dkr.ecr.us-east-1.amazonaws.com/app", authType); !ok || auth.Password != "token" {
+		t.Errorf("expected the credential helper's credentials, got %#v, %v", auth, ok)
+	}
+}
+
+func TestKeychainPrefersCredentialHelpersToNodePullSecret(t *testing.T) {
+	defer func(keychain ResolverChain) {
+		Keychain = keychain
+	}(Keychain)
+	defer func(command func(helper, action string) *exec.Cmd) {
+		credentialHelperCommand = command
+	}(credentialHelperCommand)
+	credentialHelperCommand = func(helper, action string) *exec.Cmd {
+		return exec.Command("echo", `{"Username": "AWS", "Secret": "token"}`)
+	}
+
+	dir, err := ioutil.TempDir("", "keychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretDir, nodeDir := filepath.Join(dir, "secret"), filepath.Join(dir, "node")
+	for path, content := range map[string]string{
+		filepath.Join(secretDir, "config.json"): `{"credHelpers": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}}`,
+		filepath.Join(nodeDir, "config.json"):   `{"auths": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": {"auth": "Zm9vOmJhcg=="}}}`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv(PullAuthType, secretDir)
+	defer os.Unsetenv(PullAuthType)
+	Keychain = append(ResolverChain{}, Keychain...)
+	for i, resolver := range Keychain {
+		if _, ok := resolver.(NodePullSecretResolver); ok {
+			Keychain[i] = NodePullSecretResolver{Dir: nodeDir}
+		}
+	}
+
+	if auth, ok := NewHelper().GetDockerAuth("123456789012.dkr.ecr.us-east-1.amazonaws.com/app", PullAuthType); !ok || auth.Password != "token" {
+		t.Errorf("expected the credential helper's credentials rather than the node pull secret's, got %#v, %v", auth, ok)
+	}
+}
+
+func TestServiceAccountTokenResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sa-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	resolver := ServiceAccountTokenResolver{TokenPath: tokenPath, Registries: []string{"image-registry.openshift-image-registry.svc:5000"}}
+	image := "image-registry.openshift-image-registry.svc:5000/ns/app:latest"
+
+	if _, ok := resolver.Resolve(image, PushAuthType); ok {
+		t.Errorf("expected no credentials without a token")
+	}
+	if err := ioutil.WriteFile(tokenPath, []byte("first-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, ok := resolver.Resolve(image, PushAuthType)
+	if !ok || auth.Password != "first-token" || auth.ServerAddress != "image-registry.openshift-image-registry.svc:5000" {
+		t.Errorf("unexpected credentials %#v, %v", auth, ok)
+	}
+	if _, ok := resolver.Resolve("quay.io/ns/app:latest", PushAuthType); ok {
+		t.Errorf("expected no credentials for another registry")
+	}
+
+	// the kubelet rotates the token in place
+	if err := ioutil.WriteFile(tokenPath, []byte("second-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if auth, ok := resolver.Resolve(image, PullAuthType); !ok || auth.Password != "second-token" {
+		t.Errorf("expected the rotated token, got %#v, %v", auth, ok)
+	}
+	if !resolver.ShortLived() {
+		t.Errorf("expected the token to be refreshed when it is refused")
+	}
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	bld "github.com/openshift/builder/pkg/build/builder"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
+	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+)
+
+// LocalBuildOptions are what a build run outside of a cluster is given in
+// place of its pod.
+type LocalBuildOptions struct {
+	// BuildFile is the path of the Build manifest, in YAML or JSON
+	BuildFile string
+	// SecretsDir holds a directory, named for the secret, holding the keys
+	// of each of the secrets the build uses: its source, push and pull
+	// secrets and its input secrets
+	SecretsDir string
+	// ConfigMapsDir holds a directory, named for the configmap, holding the
+	// keys of each of the build's input configmaps
+	ConfigMapsDir string
+}
+
+// RunLocalBuild runs the build in opts.BuildFile outside of a cluster, by
+// running in turn the same steps as the containers of a build pod, with the
+// secrets and configmaps the build uses taken from local directories.  There
+// is no server to update, so the build's final status is logged.
+func RunLocalBuild(out io.Writer, opts LocalBuildOptions) error {
+	build, err := readLocalBuild(opts.BuildFile)
+	if err != nil {
+		return err
+	}
+	steps := []func(io.Writer) error{RunGitClone}
+	if len(build.Spec.Source.Images) > 0 {
+		steps = append(steps, RunExtractImageContent)
+	}
+	switch {
+	case build.Spec.Strategy.DockerStrategy != nil:
+		steps = append(steps, RunManageDockerfile, RunDockerBuild)
+	case build.Spec.Strategy.SourceStrategy != nil:
+		steps = append(steps, RunS2IBuild)
+	default:
+		return fmt.Errorf("only Docker and Source strategy builds can be run locally")
+	}
+
+	env, err := localBuildEnv(build, opts.SecretsDir)
+	if err != nil {
+		return err
+	}
+	if err := bld.LinkLocalBuildInputs(build, opts.SecretsDir, opts.ConfigMapsDir); err != nil {
+		return err
+	}
+	data, err := runtime.Encode(buildJSONCodec, build)
+	if err != nil {
+		return err
+	}
+	env["BUILD"] = string(data)
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	client := buildfake.NewSimpleClientset(build)
+	newBuildsClient = func(build *buildapiv1.Build) (buildclientv1.BuildInterface, error) {
+		return client.BuildV1().Builds(build.Namespace), nil
+	}
+	for _, step := range steps {
+		if err = step(out); err != nil {
+			break
+		}
+	}
+
+	if result, getErr := client.BuildV1().Builds(build.Namespace).Get(build.Name, metav1.GetOptions{}); getErr == nil {
+		status := result.Status
+		if err == nil && len(status.Phase) == 0 {
+			status.Phase = buildapiv1.BuildPhaseComplete
+		}
+		log.V(0).Infof("\nBuild %s/%s: phase %s, reason %q, message %q, output %q", build.Namespace, build.Name, status.Phase, status.Reason, status.Message, status.OutputDockerImageReference)
+	}
+	return err
+}
+
+// readLocalBuild reads the Build manifest at path, giving it a name and
+// namespace if it has none, and the reference its output is pushed to, which
+// the build controller would resolve.
+func readLocalBuild(path string) (*buildapiv1.Build, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is left as it is
+	data, err = yaml.ToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	obj, _, err := buildJSONCodec.Decode(data, nil, &buildapiv1.Build{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	build, ok := obj.(*buildapiv1.Build)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a build: %#v", path, obj)
+	}
+	if len(build.Name) == 0 {
+		build.Name = "local"
+	}
+	if len(build.Namespace) == 0 {
+		build.Namespace = "default"
+	}
+	if to := build.Spec.Output.To; to != nil && len(build.Status.OutputDockerImageReference) == 0 {
+		if to.Kind != "DockerImage" {
+			return nil, fmt.Errorf("the output of the build is an %s, so status.outputDockerImageReference must name the image it is pushed to", to.Kind)
+		}
+		build.Status.OutputDockerImageReference = to.Name
+	}
+	return build, nil
+}
+
+// localBuildEnv returns the environment in which a build pod finds the
+// build's source, push and pull secrets, pointing at their directories in
+// secretsDir.
+func localBuildEnv(build *buildapiv1.Build, secretsDir string) (map[string]string, error) {
+	env := map[string]string{}
+	secrets := map[string]*corev1.LocalObjectReference{
+		"SOURCE_SECRET_PATH":   build.Spec.Source.SourceSecret,
+		dockercfg.PushAuthType: build.Spec.Output.PushSecret,
+	}
+	switch {
+	case build.Spec.Strategy.DockerStrategy != nil:
+		secrets[dockercfg.PullAuthType] = build.Spec.Strategy.DockerStrategy.PullSecret
+	case build.Spec.Strategy.SourceStrategy != nil:
+		secrets[dockercfg.PullAuthType] = build.Spec.Strategy.SourceStrategy.PullSecret
+	}
+	for i, image := range build.Spec.Source.Images {
+		secrets[fmt.Sprintf("%s%d", dockercfg.PullSourceAuthType, i)] = image.PullSecret
+	}
+	for name, secret := range secrets {
+		if secret == nil || len(secret.Name) == 0 {
+			continue
+		}
+		if len(secretsDir) == 0 {
+			return nil, fmt.Errorf("the build's secret %q requires a directory of secrets", secret.Name)
+		}
+		dir, err := filepath.Abs(filepath.Join(secretsDir, secret.Name))
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("the build's secret %q is not a directory in %s", secret.Name, secretsDir)
+		}
+		env[name] = dir
+	}
+	return env, nil
+}
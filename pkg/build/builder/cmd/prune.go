@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/containers/storage"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	bld "github.com/openshift/builder/pkg/build/builder"
+)
+
+// PruneOptions are what "openshift-builder prune" is given.
+type PruneOptions struct {
+	// GraphRoot, if set, is the directory of the storage to prune in place
+	// of the one configured for builds
+	GraphRoot string
+	// RunRoot, if set, is the directory of the storage's state in place of
+	// the one configured for builds
+	RunRoot string
+	// MaxAge is how old containers and images may be before they are
+	// removed; when zero every container and untagged image is removed
+	MaxAge time.Duration
+	// MaxSize, if set, is the quantity of storage, such as 20Gi, which the
+	// images kept may use in total
+	MaxSize string
+	// DryRun lists what would be removed without removing it
+	DryRun bool
+}
+
+// RunPrune removes the containers, images and mounts which earlier builds
+// left in the storage which builds are configured to use, or the one given
+// by opts, according to the age and size policies of opts.
+func RunPrune(out io.Writer, opts PruneOptions) error {
+	if opts.MaxAge < 0 {
+		return fmt.Errorf("invalid --max-age value %v: must not be negative", opts.MaxAge)
+	}
+	pruneOpts := bld.PruneOptions{MaxAge: opts.MaxAge, DryRun: opts.DryRun}
+	if len(opts.MaxSize) > 0 {
+		size, err := resource.ParseQuantity(opts.MaxSize)
+		if err != nil || size.Sign() <= 0 {
+			return fmt.Errorf("invalid --max-size value %q: must be a positive quantity such as 20Gi", opts.MaxSize)
+		}
+		pruneOpts.MaxSize = size.Value()
+	}
+
+	// the storage is configured as it is for a build which chooses nothing
+	// itself, so that the builder pod's settings are honored
+	storeOptions, err := buildStoreOptions(&buildapiv1.Build{})
+	if err != nil {
+		return err
+	}
+	if len(opts.GraphRoot) > 0 {
+		storeOptions.GraphRoot = opts.GraphRoot
+	}
+	if len(opts.RunRoot) > 0 {
+		storeOptions.RunRoot = opts.RunRoot
+	}
+	store, err := storage.GetStore(storeOptions)
+	if err != nil {
+		return fmt.Errorf("unable to open the storage at %s: %v", storeOptions.GraphRoot, err)
+	}
+	defer func() {
+		if _, err := store.Shutdown(false); err != nil {
+			log.V(0).Infof("Error shutting down storage: %v", err)
+		}
+	}()
+	log.V(0).Infof("Pruning the %s storage at %s", store.GraphDriverName(), store.GraphRoot())
+	return bld.PruneStorage(store, pruneOpts)
+}
@@ -0,0 +1,215 @@
+package scmauth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	HostUserPassGitConfig = `# credential git config for %[1]s
+[credential "%[1]s"]
+   helper = store --file=%[2]s
+`
+	HostCACertConfig = `# SSL cert for %[1]s
+[http "%[1]s"]
+   sslCAInfo = %[2]s
+`
+)
+
+// SetupHostSecrets lays down the credentials of each of the git hosts of
+// hostPrefixes, found in the keys of the source secret in secretsDir whose
+// names are those of the credentials prefixed by the host's prefix and a
+// dash, such as gitlab-ssh-privatekey, so that git only presents them to that
+// host.  This allows repositories on other hosts, such as submodules, to be
+// fetched with different credentials than the main source repository, with
+// the one secret which is mounted while the source is fetched.
+// Username/password/token, ca.crt and ssh-privatekey secrets are supported.
+// The keys of the hosts are verified as hostKeys says.  The env returned by
+// SCMAuths.Setup for the main source secret, if any, is passed in and the
+// combined environment is returned.
+func SetupHostSecrets(secretsDir string, hostPrefixes map[string]string, hostKeys SSHHostKeys, env []string) ([]string, error) {
+	context := NewDefaultSCMContext()
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if err := context.Set(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := []string{}
+	for host := range hostPrefixes {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	files, err := ioutil.ReadDir(secretsDir)
+	if err != nil {
+		return nil, err
+	}
+	sshConfig := &bytes.Buffer{}
+	for _, host := range hosts {
+		prefix := hostPrefixes[host] + "-"
+		log.V(4).Infof("Setting up credentials in the %s* keys of %s for git host %q", prefix, secretsDir, host)
+		handled := false
+		for _, file := range files {
+			switch file.Name() {
+			case prefix + SSHPrivateKeyMethodName:
+				fmt.Fprintf(sshConfig, "Host %s\n  IdentityFile %s\n", host, filepath.Join(secretsDir, file.Name()))
+				secretKnownHosts := ""
+				if fileExists(files, prefix+knownHostsFileName) {
+					secretKnownHosts = filepath.Join(secretsDir, prefix+knownHostsFileName)
+				}
+				options, err := hostKeys.options(secretKnownHosts)
+				if err != nil {
+					return nil, err
+				}
+				for _, o := range options {
+					fmt.Fprintf(sshConfig, "  %s %s\n", o.key, o.value)
+				}
+				handled = true
+			case prefix + CACertName:
+				if err := setupHostCACert(host, filepath.Join(secretsDir, file.Name()), context); err != nil {
+					return nil, err
+				}
+				handled = true
+			}
+		}
+		if fileExists(files, prefix+UsernameSecret) || fileExists(files, prefix+PasswordSecret) || fileExists(files, prefix+TokenSecret) {
+			if err := setupHostUsernamePassword(host, secretsDir, prefix, context); err != nil {
+				return nil, err
+			}
+			handled = true
+		}
+		if !handled {
+			return nil, fmt.Errorf("no credentials for git host %q were found in the %s* keys of the secret in %s", host, prefix, secretsDir)
+		}
+	}
+
+	if sshConfig.Len() > 0 {
+		if err := setupHostSSH(sshConfig.String(), context); err != nil {
+			return nil, err
+		}
+	}
+	return context.Env(), nil
+}
+
+// setupHostUsernamePassword writes a credential store containing the
+// username/password or token of the keys of secretsDir with prefix for both
+// http and https access to host, and a gitconfig fragment that scopes the
+// store to that host.
+func setupHostUsernamePassword(host, secretsDir, prefix string, context SCMAuthContext) error {
+	usernameSecret, err := readSecret(secretsDir, prefix+UsernameSecret)
+	if err != nil {
+		return err
+	}
+	passwordSecret, err := readSecret(secretsDir, prefix+PasswordSecret)
+	if err != nil {
+		return err
+	}
+	tokenSecret, err := readSecret(secretsDir, prefix+TokenSecret)
+	if err != nil {
+		return err
+	}
+
+	for _, scheme := range []string{"https", "http"} {
+		_, gitconfigURL, err := doSetup(url.URL{Scheme: scheme, Host: host}, usernameSecret, passwordSecret, tokenSecret)
+		if err != nil {
+			return err
+		}
+		if gitconfigURL == nil {
+			continue
+		}
+		gitcredentials, err := ioutil.TempFile("", "gitcredentials.")
+		if err != nil {
+			return err
+		}
+		defer gitcredentials.Close()
+		gitconfig, err := ioutil.TempFile("", "gitcredentialscfg.")
+		if err != nil {
+			return err
+		}
+		defer gitconfig.Close()
+
+		configContent := fmt.Sprintf(HostUserPassGitConfig, scheme+"://"+host, gitcredentials.Name())
+
+		log.V(5).Infof("Adding username/password credentials for %s to git config:\n%s\n", host, configContent)
+
+		fmt.Fprintf(gitconfig, "%s", configContent)
+		fmt.Fprintf(gitcredentials, "%s", gitconfigURL.String())
+
+		if err := ensureGitConfigIncludes(gitconfig.Name(), context); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupHostCACert writes a gitconfig fragment that trusts the CA certificate
+// in caCert for https access to host.
+func setupHostCACert(host, caCert string, context SCMAuthContext) error {
+	gitconfig, err := ioutil.TempFile("", "ca.crt.")
+	if err != nil {
+		return err
+	}
+	defer gitconfig.Close()
+	content := fmt.Sprintf(HostCACertConfig, "https://"+host, caCert)
+	log.V(5).Infof("Adding CACert Auth for %s to %s:\n%s\n", host, gitconfig.Name(), content)
+	if _, err := gitconfig.WriteString(content); err != nil {
+		return err
+	}
+	return ensureGitConfigIncludes(gitconfig.Name(), context)
+}
+
+// setupHostSSH writes the given per-host ssh configuration and a wrapper
+// script that passes it to ssh.  If the main source secret already set up an
+// SSH wrapper, the new wrapper delegates to it so its key remains available.
+func setupHostSSH(config string, context *defaultSCMContext) error {
+	configFile, err := ioutil.TempFile("", "gitsshconfig")
+	if err != nil {
+		return err
+	}
+	defer configFile.Close()
+	if _, err := configFile.WriteString(config); err != nil {
+		return err
+	}
+
+	script, err := ioutil.TempFile("", "gitssh")
+	if err != nil {
+		return err
+	}
+	defer script.Close()
+	if err := script.Chmod(0711); err != nil {
+		return err
+	}
+	command := "ssh"
+	if existing, ok := context.Get("GIT_SSH"); ok {
+		command = existing
+		// the new wrapper replaces the existing one
+		delete(context.vars, "GIT_SSH")
+	}
+	content := fmt.Sprintf("#!/bin/sh\n%s -F %s \"$@\"\n", command, configFile.Name())
+	log.V(5).Infof("Adding per-host SSH Auth:\n%s\n%s\n", config, content)
+	if _, err := script.WriteString(content); err != nil {
+		return err
+	}
+	return context.Set("GIT_SSH", script.Name())
+}
+
+// fileExists returns true if a file with the given name is in files
+func fileExists(files []os.FileInfo, name string) bool {
+	for _, file := range files {
+		if file.Name() == name {
+			return true
+		}
+	}
+	return false
+}
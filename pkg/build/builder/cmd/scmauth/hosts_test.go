@@ -0,0 +1,85 @@
+package scmauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	builder "github.com/openshift/builder/pkg/build/builder"
+)
+
+func TestSetupHostSecrets(t *testing.T) {
+	dir := secretDir(t, "ssh-privatekey", "gh-username", "gh-password", "gh-ca.crt", "gl-ssh-privatekey")
+	defer os.RemoveAll(dir)
+
+	env, err := SetupHostSecrets(dir, map[string]string{
+		"github.com":         "gh",
+		"gitlab.example.com": "gl",
+	}, SSHHostKeys{}, []string{"GIT_SSH=/tmp/mainwrapper"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vars := map[string]string{}
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		vars[parts[0]] = parts[1]
+	}
+
+	gitconfig, ok := vars["GIT_CONFIG"]
+	if !ok {
+		t.Fatalf("GIT_CONFIG is not set: %v", env)
+	}
+	defer cleanupConfig(gitconfig)
+	lines, err := builder.ReadLines(gitconfig)
+	if err != nil {
+		t.Fatalf("cannot read file %s: %v", gitconfig, err)
+	}
+	content := ""
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "path = ") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(strings.TrimPrefix(line, "path = "))
+		if err != nil {
+			t.Fatalf("cannot read included config: %v", err)
+		}
+		content += string(buf)
+	}
+	for _, search := range []string{`[credential "https://github.com"]`, `[credential "http://github.com"]`, `[http "https://github.com"]`} {
+		if !strings.Contains(content, search) {
+			t.Errorf("could not find %q in git config:\n%s", search, content)
+		}
+	}
+
+	script, ok := vars["GIT_SSH"]
+	if !ok {
+		t.Fatalf("GIT_SSH is not set: %v", env)
+	}
+	defer os.Remove(script)
+	buf, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatalf("cannot read ssh wrapper: %v", err)
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) < 4 || fields[1] != "/tmp/mainwrapper" || fields[2] != "-F" {
+		t.Fatalf("ssh wrapper had wrong contents %s", string(buf))
+	}
+	defer os.Remove(fields[3])
+	buf, err = ioutil.ReadFile(fields[3])
+	if err != nil {
+		t.Fatalf("cannot read ssh config: %v", err)
+	}
+	if !strings.Contains(string(buf), "Host gitlab.example.com\n  IdentityFile "+filepath.Join(dir, "gl-ssh-privatekey")) || strings.Contains(string(buf), "github.com") {
+		t.Errorf("ssh config had wrong contents %s", string(buf))
+	}
+}
+
+func TestSetupHostSecretsNoHandler(t *testing.T) {
+	dir := secretDir(t, "username", "gl-username")
+	defer os.RemoveAll(dir)
+	if _, err := SetupHostSecrets(dir, map[string]string{"github.com": "gh"}, SSHHostKeys{}, nil); err == nil {
+		t.Errorf("expected error for secret without credentials")
+	}
+}
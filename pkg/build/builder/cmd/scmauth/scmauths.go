@@ -11,9 +11,9 @@ import (
 
 type SCMAuths []SCMAuth
 
-func GitAuths(sourceURL *s2igit.URL) SCMAuths {
+func GitAuths(sourceURL *s2igit.URL, hostKeys SSHHostKeys) SCMAuths {
 	auths := SCMAuths{
-		&SSHPrivateKey{},
+		&SSHPrivateKey{HostKeys: hostKeys},
 		&UsernamePassword{SourceURL: *sourceURL},
 		&CACert{SourceURL: *sourceURL},
 		&GitConfig{},
@@ -52,6 +52,15 @@ func (a SCMAuths) doSetup(secretsDir string) (*defaultSCMContext, error) {
 
 }
 
+// Present returns whether any of a handles the secrets in secretsDir.
+func (a SCMAuths) Present(secretsDir string) (bool, error) {
+	files, err := ioutil.ReadDir(secretsDir)
+	if err != nil {
+		return false, err
+	}
+	return len(a.present(files)) > 0, nil
+}
+
 func (a SCMAuths) Setup(secretsDir string) (env []string, overrideURL *url.URL, err error) {
 	files, err := ioutil.ReadDir(secretsDir)
 	if err != nil {
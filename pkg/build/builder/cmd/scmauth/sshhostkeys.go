@@ -0,0 +1,133 @@
+package scmauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The policies which select how ssh verifies the keys of git hosts.
+const (
+	// SSHHostKeysDefault verifies host keys against the known_hosts of the
+	// secret holding the ssh-privatekey, if it has one, and otherwise does
+	// not verify them
+	SSHHostKeysDefault = ""
+	// SSHHostKeysStrict fails to connect to hosts whose keys are not known
+	SSHHostKeysStrict = "strict"
+	// SSHHostKeysAcceptNew trusts the keys of hosts which are not known,
+	// but fails to connect to known hosts whose keys have changed
+	SSHHostKeysAcceptNew = "accept-new"
+	// SSHHostKeysInsecure never verifies host keys
+	SSHHostKeysInsecure = "insecure"
+)
+
+// SSHHostKeys selects how ssh verifies the keys of git hosts.
+type SSHHostKeys struct {
+	// Policy is one of the SSHHostKeys* policies
+	Policy string
+	// KnownHostsFile, if set, holds the known host keys in place of the
+	// known_hosts of secrets
+	KnownHostsFile string
+}
+
+// sshOption is an ssh configuration option and its value.
+type sshOption struct {
+	key, value string
+}
+
+// options returns the ssh options which verify host keys as h says, given
+// the known_hosts file of a secret, if there is one.
+func (h SSHHostKeys) options(secretKnownHosts string) ([]sshOption, error) {
+	knownHosts := h.KnownHostsFile
+	if len(knownHosts) == 0 {
+		knownHosts = secretKnownHosts
+	}
+	switch h.Policy {
+	case SSHHostKeysDefault:
+		if len(knownHosts) == 0 {
+			return []sshOption{{"StrictHostKeyChecking", "no"}}, nil
+		}
+		return []sshOption{{"UserKnownHostsFile", knownHosts}}, nil
+	case SSHHostKeysStrict:
+		options := []sshOption{{"StrictHostKeyChecking", "yes"}}
+		if len(knownHosts) > 0 {
+			options = append(options, sshOption{"UserKnownHostsFile", knownHosts})
+		}
+		return options, nil
+	case SSHHostKeysAcceptNew:
+		// ssh records the keys it accepts, and known_hosts mounted
+		// from a secret or configmap is read-only
+		writable, err := ioutil.TempFile("", "known_hosts")
+		if err != nil {
+			return nil, err
+		}
+		defer writable.Close()
+		if len(knownHosts) > 0 {
+			content, err := ioutil.ReadFile(knownHosts)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := writable.Write(content); err != nil {
+				return nil, err
+			}
+		}
+		return []sshOption{{"StrictHostKeyChecking", "accept-new"}, {"UserKnownHostsFile", writable.Name()}}, nil
+	case SSHHostKeysInsecure:
+		return []sshOption{{"StrictHostKeyChecking", "no"}, {"UserKnownHostsFile", "/dev/null"}}, nil
+	}
+	return nil, fmt.Errorf("unknown SSH host key policy %q", h.Policy)
+}
+
+// sshCommandLineOptions returns options as ssh command line arguments.
+func sshCommandLineOptions(options []sshOption) string {
+	args := []string{}
+	for _, o := range options {
+		args = append(args, fmt.Sprintf("-o %s=%s", o.key, o.value))
+	}
+	return strings.Join(args, " ")
+}
+
+// SetupSSHHostKeys makes git verify the keys of ssh hosts as hostKeys says,
+// when no source secret has set up ssh already, and returns env with the
+// settings which do so added.  The default policy leaves the defaults of ssh
+// in place.
+func SetupSSHHostKeys(hostKeys SSHHostKeys, env []string) ([]string, error) {
+	if hostKeys.Policy == SSHHostKeysDefault {
+		return env, nil
+	}
+	context := NewDefaultSCMContext()
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if err := context.Set(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := context.Get("GIT_SSH"); ok {
+		return env, nil
+	}
+
+	options, err := hostKeys.options("")
+	if err != nil {
+		return nil, err
+	}
+	script, err := ioutil.TempFile("", "gitssh")
+	if err != nil {
+		return nil, err
+	}
+	defer script.Close()
+	if err := script.Chmod(0711); err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("#!/bin/sh\nssh %s \"$@\"\n", sshCommandLineOptions(options))
+	log.V(5).Infof("Adding SSH host key verification:\n%s\n", content)
+	if _, err := script.WriteString(content); err != nil {
+		return nil, err
+	}
+	if err := context.Set("GIT_SSH", script.Name()); err != nil {
+		return nil, err
+	}
+	return context.Env(), nil
+}
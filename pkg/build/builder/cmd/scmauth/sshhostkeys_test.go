@@ -0,0 +1,111 @@
+package scmauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSSHHostKeysOptions(t *testing.T) {
+	dir := secretDir(t, "known_hosts")
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	tests := []struct {
+		name             string
+		hostKeys         SSHHostKeys
+		secretKnownHosts string
+		expected         []sshOption
+		expectErr        bool
+	}{
+		{name: "default", expected: []sshOption{{"StrictHostKeyChecking", "no"}}},
+		{name: "default with known_hosts", secretKnownHosts: knownHosts, expected: []sshOption{{"UserKnownHostsFile", knownHosts}}},
+		{name: "strict", hostKeys: SSHHostKeys{Policy: SSHHostKeysStrict}, expected: []sshOption{{"StrictHostKeyChecking", "yes"}}},
+		{
+			name:             "strict with known_hosts",
+			hostKeys:         SSHHostKeys{Policy: SSHHostKeysStrict, KnownHostsFile: "/etc/known_hosts"},
+			secretKnownHosts: knownHosts,
+			expected:         []sshOption{{"StrictHostKeyChecking", "yes"}, {"UserKnownHostsFile", "/etc/known_hosts"}},
+		},
+		{name: "insecure", hostKeys: SSHHostKeys{Policy: SSHHostKeysInsecure}, expected: []sshOption{{"StrictHostKeyChecking", "no"}, {"UserKnownHostsFile", "/dev/null"}}},
+		{name: "unknown", hostKeys: SSHHostKeys{Policy: "ask"}, expectErr: true},
+	}
+	for _, test := range tests {
+		options, err := test.hostKeys.options(test.secretKnownHosts)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(options, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, options)
+		}
+	}
+
+	options, err := SSHHostKeys{Policy: SSHHostKeysAcceptNew}.options(knownHosts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(options) != 2 || options[0] != (sshOption{"StrictHostKeyChecking", "accept-new"}) || options[1].key != "UserKnownHostsFile" {
+		t.Fatalf("unexpected options %v", options)
+	}
+	defer os.Remove(options[1].value)
+	if content, err := ioutil.ReadFile(options[1].value); err != nil || string(content) != "test" {
+		t.Errorf("expected a writable copy of known_hosts, got %q: %v", content, err)
+	}
+}
+
+func TestSSHPrivateKeyStrictSetup(t *testing.T) {
+	context := NewDefaultSCMContext()
+	sshKey := &SSHPrivateKey{HostKeys: SSHHostKeys{Policy: SSHHostKeysStrict}}
+	secretDir := secretDir(t, "ssh-privatekey", "known_hosts")
+	defer os.RemoveAll(secretDir)
+
+	if err := sshKey.Setup(secretDir, context); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fileName, isSet := context.Get("GIT_SSH")
+	if !isSet {
+		t.Fatalf("GIT_SSH is not set")
+	}
+	defer os.Remove(fileName)
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("problem reading ssh file %v", err)
+	}
+	str := string(buf)
+	if !strings.Contains(str, "-o StrictHostKeyChecking=yes") || !strings.Contains(str, "-o UserKnownHostsFile="+filepath.Join(secretDir, "known_hosts")) {
+		t.Errorf("ssh script had wrong contents %s", str)
+	}
+}
+
+func TestSetupSSHHostKeys(t *testing.T) {
+	env, err := SetupSSHHostKeys(SSHHostKeys{}, []string{"A=B"})
+	if err != nil || !reflect.DeepEqual(env, []string{"A=B"}) {
+		t.Errorf("expected the default policy to leave the environment alone, got %v: %v", env, err)
+	}
+
+	env, err = SetupSSHHostKeys(SSHHostKeys{Policy: SSHHostKeysStrict}, []string{"GIT_SSH=/tmp/mainwrapper"})
+	if err != nil || !reflect.DeepEqual(env, []string{"GIT_SSH=/tmp/mainwrapper"}) {
+		t.Errorf("expected an existing ssh wrapper to be kept, got %v: %v", env, err)
+	}
+
+	env, err = SetupSSHHostKeys(SSHHostKeys{Policy: SSHHostKeysStrict, KnownHostsFile: "/etc/known_hosts"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(env) != 1 || !strings.HasPrefix(env[0], "GIT_SSH=") {
+		t.Fatalf("expected GIT_SSH to be set, got %v", env)
+	}
+	script := strings.TrimPrefix(env[0], "GIT_SSH=")
+	defer os.Remove(script)
+	buf, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatalf("cannot read ssh wrapper: %v", err)
+	}
+	if expected := "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/etc/known_hosts \"$@\""; !strings.Contains(string(buf), expected) {
+		t.Errorf("expected the ssh wrapper to run %s, got %s", expected, buf)
+	}
+}
@@ -10,11 +10,14 @@ const SSHPrivateKeyMethodName = "ssh-privatekey"
 const knownHostsFileName = "known_hosts"
 
 // SSHPrivateKey implements SCMAuth interface for using SSH private keys.
-type SSHPrivateKey struct{}
+type SSHPrivateKey struct {
+	// HostKeys is how the keys of git hosts are verified
+	HostKeys SSHHostKeys
+}
 
 // Setup creates a wrapper script for SSH command to be able to use the provided
 // SSH key while accessing private repository.
-func (_ SSHPrivateKey) Setup(baseDir string, context SCMAuthContext) error {
+func (k SSHPrivateKey) Setup(baseDir string, context SCMAuthContext) error {
 	script, err := ioutil.TempFile("", "gitssh")
 	if err != nil {
 		return err
@@ -42,12 +45,15 @@ func (_ SSHPrivateKey) Setup(baseDir string, context SCMAuthContext) error {
 		return fmt.Errorf("could not find the ssh-privatekey file for the ssh secret stored at %s", baseDir)
 	}
 	// let's see if known_hosts was included in the secret
-	content := "#!/bin/sh\nssh -i " + filepath.Join(baseDir, SSHPrivateKeyMethodName)
-	if !foundKnownHosts {
-		content = content + " -o StrictHostKeyChecking=false \"$@\"\n"
-	} else {
-		content = content + " -o UserKnownHostsFile=" + filepath.Join(baseDir, knownHostsFileName) + " \"$@\"\n"
+	secretKnownHosts := ""
+	if foundKnownHosts {
+		secretKnownHosts = filepath.Join(baseDir, knownHostsFileName)
+	}
+	options, err := k.HostKeys.options(secretKnownHosts)
+	if err != nil {
+		return err
 	}
+	content := "#!/bin/sh\nssh -i " + filepath.Join(baseDir, SSHPrivateKeyMethodName) + " " + sshCommandLineOptions(options) + " \"$@\"\n"
 	log.V(5).Infof("Adding Private SSH Auth:\n%s\n", content)
 
 	if _, err := script.WriteString(content); err != nil {
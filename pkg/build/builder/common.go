@@ -2,6 +2,7 @@ package builder
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -10,10 +11,12 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/distribution/reference"
+	docker "github.com/fsouza/go-dockerclient"
 	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
 	"github.com/openshift/imagebuilder/dockerfile/parser"
 
@@ -21,11 +24,11 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/openshift/imagebuilder"
 	imagereference "github.com/openshift/library-go/pkg/image/reference"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
 	s2igit "github.com/openshift/source-to-image/pkg/scm/git"
 	"github.com/openshift/source-to-image/pkg/util"
 
@@ -38,10 +41,6 @@ import (
 	"github.com/openshift/library-go/pkg/git"
 )
 
-// postCommitAlias is a unique key to use for an alias in
-// buildPostCommit so that we don't duplicate anyone's alias
-var postCommitAlias = "appimage" + strings.Replace(string(uuid.NewUUID()), "-", "", -1)
-
 const (
 	// containerNamePrefix prefixes the name of containers launched by a build.
 	// We cannot reuse the prefix "k8s" because we don't want the containers to
@@ -53,8 +52,6 @@ const (
 	// SecretBuildSourceBaseMountPath is the path that the controller will have
 	// mounted secret input content within the build pod
 	secretBuildSourceBaseMountPath = "/var/run/secrets/openshift.io/build"
-	// BuildWorkDirMount is the working directory within the build pod, mounted as a volume.
-	buildWorkDirMount = "/tmp/build"
 )
 
 var (
@@ -62,11 +59,27 @@ var (
 	// client facing libraries should not be using log
 	log = utillog.ToFile(os.Stderr, 2)
 
+	// tmpDir holds the files which the steps of a build share: /tmp, unless
+	// the build server gives the build a directory of its own.
+	tmpDir = buildTmpDir()
+	// BuildWorkDirMount is the working directory within the build pod, mounted as a volume.
+	buildWorkDirMount = filepath.Join(tmpDir, "build")
+	// authFilePath is the registry auth file of the build's image operations.
+	authFilePath = filepath.Join(tmpDir, "config.json")
+
 	// InputContentPath is the path at which the build inputs will be available
 	// to all the build containers.
 	InputContentPath = filepath.Join(buildWorkDirMount, "inputs")
 )
 
+// buildTmpDir returns $BUILD_WORK_DIR, if it is set, or else /tmp.
+func buildTmpDir() string {
+	if dir := os.Getenv(builderutil.BuildWorkDir); len(dir) > 0 {
+		return dir
+	}
+	return "/tmp"
+}
+
 // KeyValue can be used to build ordered lists of key-value pairs.
 type KeyValue struct {
 	Key   string
@@ -82,6 +95,7 @@ type GitClient interface {
 	SubmoduleUpdate(dir string, init, recursive bool) error
 	TimedListRemote(timeout time.Duration, url string, args ...string) (string, string, error)
 	GetInfo(location string) (*git.SourceInfo, []error)
+	Run(dir string, args ...string) (string, string, error)
 }
 
 // localObjectBuildSource is a build source that is copied into a build from a Kubernetes
@@ -132,9 +146,10 @@ func (s secretSource) IsSecret() bool {
 // buildInfo returns a slice of KeyValue pairs with build metadata to be
 // inserted into Docker images produced by build.
 func buildInfo(build *buildapiv1.Build, sourceInfo *git.SourceInfo) []KeyValue {
-	kv := []KeyValue{
-		{"OPENSHIFT_BUILD_NAME", build.Name},
-		{"OPENSHIFT_BUILD_NAMESPACE", build.Namespace},
+	var kv []KeyValue
+	// the name and namespace differ between otherwise identical builds
+	if !isReproducibleBuild(build) {
+		kv = append(kv, KeyValue{"OPENSHIFT_BUILD_NAME", build.Name}, KeyValue{"OPENSHIFT_BUILD_NAMESPACE", build.Namespace})
 	}
 	if build.Spec.Source.Git != nil {
 		kv = append(kv, KeyValue{"OPENSHIFT_BUILD_SOURCE", build.Spec.Source.Git.URI})
@@ -157,6 +172,55 @@ func buildInfo(build *buildapiv1.Build, sourceInfo *git.SourceInfo) []KeyValue {
 	return kv
 }
 
+// buildStrategyEnv returns the value of the named variable from the
+// environment of the build's strategy, and whether it was set.  If the
+// variable is listed more than once, the last value wins.
+func buildStrategyEnv(build *buildapiv1.Build, name string) (string, bool) {
+	value, found := "", false
+	for _, e := range buildStrategyEnvVars(build) {
+		if e.Name == name {
+			value, found = e.Value, true
+		}
+	}
+	return value, found
+}
+
+// buildStrategyEnvVars returns the environment of the build's strategy.
+func buildStrategyEnvVars(build *buildapiv1.Build) []corev1.EnvVar {
+	switch {
+	case build.Spec.Strategy.DockerStrategy != nil:
+		return build.Spec.Strategy.DockerStrategy.Env
+	case build.Spec.Strategy.SourceStrategy != nil:
+		return build.Spec.Strategy.SourceStrategy.Env
+	case build.Spec.Strategy.CustomStrategy != nil:
+		return build.Spec.Strategy.CustomStrategy.Env
+	}
+	return nil
+}
+
+// inputSecretDir returns the directory at which the build input secret with
+// the given name is mounted, and whether the build has such an input secret.
+func inputSecretDir(build *buildapiv1.Build, name string) (string, bool) {
+	for _, s := range build.Spec.Source.Secrets {
+		if s.Secret.Name == name {
+			return filepath.Join(secretBuildSourceBaseMountPath, name), true
+		}
+	}
+	return "", false
+}
+
+// inputConfigMapDir returns the directory at which the build input configmap
+// with the given name is mounted, and whether the build has such an input
+// configmap.
+func inputConfigMapDir(build *buildapiv1.Build, name string) (string, bool) {
+	for _, c := range build.Spec.Source.ConfigMaps {
+		if c.ConfigMap.Name == name {
+			return filepath.Join(configMapBuildSourceBaseMountPath, name), true
+		}
+	}
+	return "", false
+}
+
 // randomBuildTag generates a random tag used for building images in such a way
 // that the built image can be referred to unambiguously even in the face of
 // concurrent builds with the same name in the same namespace.
@@ -183,33 +247,74 @@ func containerName(strategyName, buildName, namespace, containerPurpose string)
 		uid)
 }
 
-// buildPostCommit transforms the supplied BuildPostCommitSpec into dockerfile commands.
-func buildPostCommit(postCommitSpec buildapiv1.BuildPostCommitSpec) string {
+// postCommitHookCommand returns the entrypoint and command which run the
+// supplied BuildPostCommitSpec in a container.  A script is run by /bin/sh,
+// with the args as its positional parameters, and args alone are passed to
+// the image's entrypoint.
+func postCommitHookCommand(postCommitSpec buildapiv1.BuildPostCommitSpec) ([]string, []string) {
 	command := postCommitSpec.Command
 	args := postCommitSpec.Args
-	script := postCommitSpec.Script
-
-	if script == "" && len(command) == 0 && len(args) == 0 {
-		// Post commit hook is not set, return early.
-		return ""
-	}
-
-	log.V(4).Infof("Post commit hook spec: %+v", postCommitSpec)
-
-	if script != "" {
-		// The `-i` flag is needed to support CentOS and RHEL images
-		// that use Software Collections (SCL), in order to have the
-		// appropriate collections enabled in the shell. E.g., in the
-		// Ruby image, this is necessary to make `ruby`, `bundle` and
-		// other binaries available in the PATH.
+	if script := postCommitSpec.Script; script != "" {
+		// -i enables Software Collections in CentOS and RHEL images, so
+		// that binaries such as ruby and bundle are in the PATH
 		command = []string{"/bin/sh", "-ic"}
-		args = append([]string{script}, args...)
+		args = append([]string{script, command[0]}, args...)
+	}
+	return command, args
+}
 
-		return strings.TrimSpace(fmt.Sprintf("%s '%s'", strings.Join(command, " "), strings.Join(args, " ")))
+// limitedHostConfig returns the HostConfig of a container which is limited to
+// the resources of the build.
+func limitedHostConfig(cgLimits *s2iapi.CGroupLimits) *docker.HostConfig {
+	hostConfig := &docker.HostConfig{}
+	if cgLimits != nil {
+		hostConfig.CPUPeriod = cgLimits.CPUPeriod
+		hostConfig.CPUQuota = cgLimits.CPUQuota
+		hostConfig.CPUShares = cgLimits.CPUShares
+		hostConfig.Memory = cgLimits.MemoryLimitBytes
+		hostConfig.MemorySwap = cgLimits.MemorySwap
+		hostConfig.CgroupParent = cgLimits.Parent
+	}
+	return hostConfig
+}
 
+// runPostCommitHook runs the supplied BuildPostCommitSpec, if it is set, in a
+// container of image, the image which was just built, with the build's
+// resource limits.  Its output is streamed into the build log, and it fails
+// if the hook exits with a non-zero status.
+func runPostCommitHook(ctx context.Context, client DockerClient, build *buildapiv1.Build, image string, cgLimits *s2iapi.CGroupLimits) error {
+	command, args := postCommitHookCommand(build.Spec.PostCommit)
+	if len(command) == 0 && len(args) == 0 {
+		return nil
+	}
+	runner, ok := client.(containerRunner)
+	if !ok {
+		return fmt.Errorf("running post commit hooks is not supported by this build client")
+	}
+	createOpts := docker.CreateContainerOptions{
+		Context: ctx,
+		Name:    containerName("post-commit", build.Name, build.Namespace, "hook"),
+		Config: &docker.Config{
+			Image:      image,
+			Entrypoint: command,
+			Cmd:        args,
+		},
+		HostConfig: limitedHostConfig(cgLimits),
+	}
+	attachOpts := docker.AttachToContainerOptions{
+		OutputStream: os.Stdout,
+		ErrorStream:  os.Stderr,
+	}
+	log.V(0).Infof("\nRunning post commit hook ...")
+	log.V(4).Infof("Post commit hook entrypoint %q, command %q", command, args)
+	timing.SetStage(buildapiv1.StagePostCommit)
+	startTime := metav1.Now()
+	err := runner.RunContainer(createOpts, attachOpts)
+	timing.RecordNewStep(ctx, buildapiv1.StagePostCommit, buildapiv1.StepExecPostCommitHook, startTime, metav1.Now())
+	if err != nil {
+		return fmt.Errorf("post commit hook failed: %v", err)
 	}
-
-	return strings.TrimSpace(fmt.Sprintf("%s %s", strings.Join(command, " "), strings.Join(args, " ")))
+	return nil
 }
 
 // GetSourceRevision returns a SourceRevision object either from the build (if it already had one)
@@ -271,6 +376,12 @@ func HandleBuildStatusUpdate(build *buildapiv1.Build, client buildclientv1.Build
 		latestBuild.Status.Message = build.Status.Message
 		latestBuild.Status.Output.To = build.Status.Output.To
 		latestBuild.Status.Stages = timing.AppendStageAndStepInfo(latestBuild.Status.Stages, build.Status.Stages)
+		if code, ok := build.Annotations[builderutil.FailureCodeAnnotation]; ok {
+			if latestBuild.Annotations == nil {
+				latestBuild.Annotations = map[string]string{}
+			}
+			latestBuild.Annotations[builderutil.FailureCodeAnnotation] = code
+		}
 
 		_, err = client.UpdateDetails(latestBuild.Name, latestBuild)
 
@@ -321,7 +432,7 @@ func toS2ISourceInfo(sourceInfo *git.SourceInfo) *s2igit.SourceInfo {
 
 // buildLabels returns a slice of KeyValue pairs in a format that appendLabel can
 // consume.
-func buildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) []dockerfile.KeyValue {
+func buildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) ([]dockerfile.KeyValue, error) {
 	labels := map[string]string{}
 	if sourceInfo == nil {
 		sourceInfo = &git.SourceInfo{}
@@ -337,9 +448,20 @@ func buildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) []dockerfi
 		// differ from git's view (see PotentialPRRetryAsFetch for details).
 		labels[builderutil.DefaultDockerLabelNamespace+"build.commit.ref"] = build.Spec.Source.Git.Ref
 	}
-	addBuildLabels(labels, build)
+	addRevisionLabels(labels, readRevisionDetails())
+	if !isReproducibleBuild(build) {
+		addBuildLabels(labels, build)
+	}
+	data := newLabelTemplateData(build, sourceInfo)
+	for k, v := range ociSourceLabels(data) {
+		labels[k] = v
+	}
+	imageLabels, err := expandImageLabels(build, data)
+	if err != nil {
+		return nil, err
+	}
 
-	kv := make([]dockerfile.KeyValue, 0, len(labels)+len(build.Spec.Output.ImageLabels))
+	kv := make([]dockerfile.KeyValue, 0, len(labels)+len(imageLabels))
 	keys := make([]string, 0, len(labels))
 	for k := range labels {
 		keys = append(keys, k)
@@ -349,10 +471,10 @@ func buildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) []dockerfi
 		kv = append(kv, dockerfile.KeyValue{Key: k, Value: labels[k]})
 	}
 	// override autogenerated labels with user provided labels
-	for _, lbl := range build.Spec.Output.ImageLabels {
+	for _, lbl := range imageLabels {
 		kv = append(kv, dockerfile.KeyValue{Key: lbl.Name, Value: lbl.Value})
 	}
-	return kv
+	return kv, nil
 }
 
 // readSourceInfo reads the persisted git info from disk (if any) back into a SourceInfo
@@ -384,10 +506,31 @@ func addBuildParameters(dir string, build *buildapiv1.Build, sourceInfo *git.Sou
 	dockerfilePath := getDockerfilePath(dir, build)
 
 	in, err := ioutil.ReadFile(dockerfilePath)
+	if os.IsNotExist(err) {
+		if rel, relErr := filepath.Rel(dir, dockerfilePath); relErr == nil {
+			return fmt.Errorf("no Dockerfile was found at %s in the build's source", rel)
+		}
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	node, err := imagebuilder.ParseDockerfile(bytes.NewBuffer(in))
+	// RUN instructions with here-documents and COPY --link are rewritten in
+	// forms which the builder understands.
+	expanded, err := dockerfile.ExpandHeredocs(in)
+	if err != nil {
+		return err
+	}
+	node, err := imagebuilder.ParseDockerfile(bytes.NewBuffer(expanded))
+	if err != nil {
+		return err
+	}
+	dockerfile.RemoveLinkFlags(node)
+
+	// The build's parameters apply to the stage it builds, the last unless
+	// it names another.
+	target, _ := buildStrategyEnv(build, builderutil.BuildTarget)
+	stage, err := findTargetStage(node, target)
 	if err != nil {
 		return err
 	}
@@ -399,24 +542,23 @@ func addBuildParameters(dir string, build *buildapiv1.Build, sourceInfo *git.Sou
 		if ref, err := imagereference.Parse(name); err == nil {
 			name = ref.DaemonMinimal().Exact()
 		}
-		err := replaceLastFrom(node, name, "")
+		err := replaceFrom(node, stage, name, "")
 		if err != nil {
 			return err
 		}
 	}
 
 	// Append build info as environment variables.
-	if err := appendEnv(node, buildEnv(build, sourceInfo)); err != nil {
+	if err := appendEnv(node, stage, buildEnv(build, sourceInfo)); err != nil {
 		return err
 	}
 
 	// Append build labels.
-	if err := appendLabel(node, buildLabels(build, sourceInfo)); err != nil {
+	labels, err := buildLabels(build, sourceInfo)
+	if err != nil {
 		return err
 	}
-
-	// Append post commit
-	if err := appendPostCommit(node, buildPostCommit(build.Spec.PostCommit)); err != nil {
+	if err := appendLabel(node, stage, labels); err != nil {
 		return err
 	}
 
@@ -425,7 +567,26 @@ func addBuildParameters(dir string, build *buildapiv1.Build, sourceInfo *git.Sou
 		return err
 	}
 
-	if err := replaceImagesFromSource(node, build.Spec.Source.Images); err != nil {
+	if err := replaceImagesFromSource(node, build.Spec.Source.Images, strategyBuildArgs(build)); err != nil {
+		return err
+	}
+
+	// Per-stage overrides are applied last, so that they take precedence.
+	overrides, err := getFromOverrides(build)
+	if err != nil {
+		return err
+	}
+	if err := replaceStageFroms(node, overrides); err != nil {
+		return err
+	}
+
+	// Named contexts are added after the overrides, whose stage indices are
+	// those of the Dockerfile as it was written.
+	contexts, err := getBuildContexts(build)
+	if err != nil {
+		return err
+	}
+	if err := addBuildContexts(node, contexts); err != nil {
 		return err
 	}
 
@@ -436,8 +597,11 @@ func addBuildParameters(dir string, build *buildapiv1.Build, sourceInfo *git.Sou
 
 // replaceImagesFromSource updates a single or multi-stage Dockerfile with any replacement
 // image sources ('FROM <name>' and 'COPY --from=<name>'). It operates on exact string matches
-// and performs no interpretation of names from the Dockerfile.
-func replaceImagesFromSource(node *parser.Node, imageSources []buildapiv1.ImageSource) error {
+// of the names in the Dockerfile, or of those names with the ARGs declared before the first
+// FROM, overridden by buildArgs, expanded.  The 'COPY --from=<name>' triggers of ONBUILD
+// instructions, which refer to the stages of the Dockerfiles built from the image, are only
+// updated for exact matches.
+func replaceImagesFromSource(node *parser.Node, imageSources []buildapiv1.ImageSource, buildArgs map[string]string) error {
 	replacements := make(map[string]string)
 	for _, image := range imageSources {
 		if image.From.Kind != "DockerImage" || len(image.From.Name) == 0 {
@@ -447,8 +611,15 @@ func replaceImagesFromSource(node *parser.Node, imageSources []buildapiv1.ImageS
 			replacements[name] = image.From.Name
 		}
 	}
+	replacement := func(name string, args []string) (string, bool) {
+		if replacement, ok := replacements[name]; ok {
+			return replacement, true
+		}
+		replacement, ok := replacements[expandImageName(name, args)]
+		return replacement, ok
+	}
 	names := make(map[string]string)
-	stages, err := imagebuilder.NewStages(node, imagebuilder.NewBuilder(make(map[string]string)))
+	stages, args, err := dockerfileStages(node, buildArgs)
 	if err != nil {
 		return err
 	}
@@ -457,7 +628,7 @@ func replaceImagesFromSource(node *parser.Node, imageSources []buildapiv1.ImageS
 			switch {
 			case child.Value == dockercmd.From && child.Next != nil:
 				image := child.Next.Value
-				if replacement, ok := replacements[image]; ok {
+				if replacement, ok := replacement(image, args); ok {
 					child.Next.Value = replacement
 				}
 				names[stage.Name] = image
@@ -465,20 +636,33 @@ func replaceImagesFromSource(node *parser.Node, imageSources []buildapiv1.ImageS
 				if ref, ok := nodeHasFromRef(child); ok {
 					if len(ref) > 0 {
 						if _, ok := names[ref]; !ok {
-							if replacement, ok := replacements[ref]; ok {
+							if replacement, ok := replacement(ref, args); ok {
 								nodeReplaceFromRef(child, replacement)
 							}
 						}
 					}
 				}
+			case child.Value == dockercmd.Onbuild && child.Next != nil && len(child.Next.Children) > 0:
+				trigger := child.Next.Children[0]
+				if trigger.Value != dockercmd.Copy {
+					continue
+				}
+				if ref, ok := nodeHasFromRef(trigger); ok {
+					if replacement, ok := replacements[ref]; ok {
+						nodeReplaceFromRef(trigger, replacement)
+					}
+				}
 			}
 		}
 	}
 	return nil
 }
 
-// findReferencedImages returns all qualified images referenced by the Dockerfile, or returns an error.
-func findReferencedImages(dockerfilePath string) ([]string, error) {
+// findReferencedImages returns all qualified images referenced by the stages of the
+// Dockerfile which the stage named by target depends on, or by all of its stages if
+// target is empty, with the ARGs declared before its first FROM, overridden by
+// buildArgs, expanded, or returns an error.
+func findReferencedImages(dockerfilePath string, buildArgs map[string]string, target string) ([]string, error) {
 	if len(dockerfilePath) == 0 {
 		return nil, nil
 	}
@@ -488,7 +672,11 @@ func findReferencedImages(dockerfilePath string) ([]string, error) {
 	}
 	names := make(map[string]string)
 	images := sets.NewString()
-	stages, err := imagebuilder.NewStages(node, imagebuilder.NewBuilder(make(map[string]string)))
+	stages, args, err := dockerfileStages(node, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	needed, err := targetDependencies(stages, target, args)
 	if err != nil {
 		return nil, err
 	}
@@ -496,16 +684,17 @@ func findReferencedImages(dockerfilePath string) ([]string, error) {
 		for _, child := range stage.Node.Children {
 			switch {
 			case child.Value == dockercmd.From && child.Next != nil:
-				image := child.Next.Value
+				image := expandImageName(child.Next.Value, args)
 				names[stage.Name] = image
-				if _, ok := names[image]; !ok {
+				names[strconv.Itoa(stage.Position)] = image
+				if _, ok := names[image]; !ok && (needed == nil || needed[stage.Position]) {
 					images.Insert(image)
 				}
-			case child.Value == dockercmd.Copy:
+			case child.Value == dockercmd.Copy && (needed == nil || needed[stage.Position]):
 				if ref, ok := nodeHasFromRef(child); ok {
 					if len(ref) > 0 {
 						if _, ok := names[ref]; !ok {
-							images.Insert(ref)
+							images.Insert(expandImageName(ref, args))
 						}
 					}
 				}
@@ -515,6 +704,52 @@ func findReferencedImages(dockerfilePath string) ([]string, error) {
 	return images.List(), nil
 }
 
+// targetDependencies returns the positions of the stage named by target and
+// of the earlier stages which it copies from or builds on, directly or not,
+// or nil, for all stages, if target is empty.  The names of the stages are
+// matched with args expanded.
+func targetDependencies(stages imagebuilder.Stages, target string, args []string) (map[int]bool, error) {
+	if len(target) == 0 {
+		return nil, nil
+	}
+	positions := make(map[string]int)
+	for _, stage := range stages {
+		positions[strconv.Itoa(stage.Position)] = stage.Position
+	}
+	for _, stage := range stages {
+		positions[strings.ToLower(stage.Name)] = stage.Position
+	}
+	position, ok := positions[strings.ToLower(target)]
+	if !ok || !strings.EqualFold(stages[position].Name, target) {
+		return nil, fmt.Errorf("invalid %s value %q: the Dockerfile has no stage of that name", builderutil.BuildTarget, target)
+	}
+	needed := map[int]bool{}
+	pending := []int{position}
+	for len(pending) > 0 {
+		position, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		if needed[position] {
+			continue
+		}
+		needed[position] = true
+		for _, child := range stages[position].Node.Children {
+			var ref string
+			switch {
+			case child.Value == dockercmd.From && child.Next != nil:
+				ref = child.Next.Value
+			case child.Value == dockercmd.Copy:
+				ref, _ = nodeHasFromRef(child)
+			}
+			if len(ref) == 0 {
+				continue
+			}
+			if dependency, ok := positions[strings.ToLower(expandImageName(ref, args))]; ok && dependency < position {
+				pending = append(pending, dependency)
+			}
+		}
+	}
+	return needed, nil
+}
+
 func overwriteFile(name string, out []byte) error {
 	f, err := os.OpenFile(name, os.O_TRUNC|os.O_WRONLY, 0)
 	if err != nil {
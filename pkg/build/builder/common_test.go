@@ -1,6 +1,9 @@
 package builder
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -11,16 +14,30 @@ import (
 	"testing"
 
 	"github.com/MakeNowJust/heredoc"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/diff"
 
 	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
 	"github.com/openshift/library-go/pkg/git"
 )
 
+// testBuildWithEnv returns a Docker strategy build, app-1 in namespace ns,
+// whose strategy has env as its environment.
+func testBuildWithEnv(env ...corev1.EnvVar) *buildapiv1.Build {
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: env}
+	return build
+}
+
 func TestBuildInfo(t *testing.T) {
 	b := &buildapiv1.Build{
 		ObjectMeta: metav1.ObjectMeta{
@@ -122,66 +139,90 @@ func TestContainerName(t *testing.T) {
 	}
 }
 
-func TestBuildPostCommit(t *testing.T) {
+func TestPostCommitHookCommand(t *testing.T) {
 	tests := []struct {
-		postCommit buildapiv1.BuildPostCommitSpec
-		want       string
+		postCommit     buildapiv1.BuildPostCommitSpec
+		wantEntrypoint []string
+		wantCmd        []string
 	}{
-		{
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo", "hello"},
-			},
-			want: "echo hello",
-		},
+		{},
 		{
 			postCommit: buildapiv1.BuildPostCommitSpec{
 				Command: []string{"ls"},
-				Args:    []string{"-l", "/tmp/hello"}},
-			want: "ls -l /tmp/hello",
-		},
-		{
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Script: "echo hello $1 world",
+				Args:    []string{"-l", "/tmp/hello"},
 			},
-			want: "/bin/sh -ic 'echo hello $1 world'",
+			wantEntrypoint: []string{"ls"},
+			wantCmd:        []string{"-l", "/tmp/hello"},
 		},
 		{
 			postCommit: buildapiv1.BuildPostCommitSpec{
-				Script: "echo",
-				Args:   []string{"hello", "$1", "world"},
+				Args: []string{"rake", "test"},
 			},
-			want: "/bin/sh -ic 'echo hello $1 world'",
-		},
-		{
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo", "hello", "$1", "world"},
-			},
-			want: "echo hello $1 world",
-		},
-		{
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo"},
-				Args:    []string{"hello", "$1", "world"},
-			},
-			want: "echo hello $1 world",
+			wantCmd: []string{"rake", "test"},
 		},
 		{
 			postCommit: buildapiv1.BuildPostCommitSpec{
 				Script: "echo hello $1 world",
+				Args:   []string{"big"},
 			},
-			want: "/bin/sh -ic 'echo hello $1 world'",
+			wantEntrypoint: []string{"/bin/sh", "-ic"},
+			wantCmd:        []string{"echo hello $1 world", "/bin/sh", "big"},
 		},
 	}
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			got := buildPostCommit(test.postCommit)
-			if got != test.want {
-				t.Errorf("unexpected results for \"%#v\"\n wanted: \"%s\", got: \"%s\"", test.postCommit, test.want, got)
+			entrypoint, cmd := postCommitHookCommand(test.postCommit)
+			if !reflect.DeepEqual(entrypoint, test.wantEntrypoint) || !reflect.DeepEqual(cmd, test.wantCmd) {
+				t.Errorf("expected %q %q, got %q %q", test.wantEntrypoint, test.wantCmd, entrypoint, cmd)
 			}
 		})
 	}
 }
 
+type fakeRunningDocker struct {
+	*FakeDocker
+	createOpts *docker.CreateContainerOptions
+	run        func(createOpts docker.CreateContainerOptions)
+	err        error
+}
+
+func (d *fakeRunningDocker) RunContainer(createOpts docker.CreateContainerOptions, attachOpts docker.AttachToContainerOptions) error {
+	d.createOpts = &createOpts
+	if d.run != nil {
+		d.run(createOpts)
+	}
+	return d.err
+}
+
+func TestRunPostCommitHook(t *testing.T) {
+	ctx := timing.NewContext(context.Background())
+	build := &buildapiv1.Build{}
+	client := &fakeRunningDocker{FakeDocker: NewFakeDockerClient()}
+	if err := runPostCommitHook(ctx, client, build, "image", nil); err != nil || client.createOpts != nil {
+		t.Errorf("expected no hook to run, got %v, %v", client.createOpts, err)
+	}
+
+	build.Spec.PostCommit.Script = "bundle exec rake test"
+	limits := &s2iapi.CGroupLimits{MemoryLimitBytes: 1024, CPUQuota: 100, Parent: "parent"}
+	if err := runPostCommitHook(ctx, client, build, "image", limits); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.createOpts == nil || client.createOpts.Config.Image != "image" {
+		t.Fatalf("expected the hook to run in the built image, got %#v", client.createOpts)
+	}
+	if host := client.createOpts.HostConfig; host.Memory != 1024 || host.CPUQuota != 100 || host.CgroupParent != "parent" {
+		t.Errorf("expected the build's resource limits, got %#v", host)
+	}
+
+	client.err = errors.New("exit status 1")
+	if err := runPostCommitHook(ctx, client, build, "image", nil); err == nil {
+		t.Errorf("expected the hook to fail")
+	}
+	if err := runPostCommitHook(ctx, NewFakeDockerClient(), build, "image", nil); err == nil {
+		t.Errorf("expected an error from a client which cannot run containers")
+	}
+}
+
 func Test_addBuildParameters(t *testing.T) {
 	type want struct {
 		Err bool
@@ -205,11 +246,32 @@ func Test_addBuildParameters(t *testing.T) {
 				`),
 			want: want{
 				Out: heredoc.Doc(`
+				ARG GOLANG_CONTAINER=golang:latest
 				FROM $GOLANG_CONTAINER
 				RUN echo "hello world"
 				`),
 			},
 		},
+		{
+			original: heredoc.Doc(`
+				ARG VERSION=1
+				FROM base:${VERSION}
+				COPY --from=tools:$VERSION /a /b
+				ONBUILD COPY --from=tools:1 /a /c
+				`),
+			build: []buildapiv1.ImageSource{
+				{From: corev1.ObjectReference{Kind: "DockerImage", Name: "nginx:latest"}, As: []string{"base:1"}},
+				{From: corev1.ObjectReference{Kind: "DockerImage", Name: "approved/tools:1"}, As: []string{"tools:1"}},
+			},
+			want: want{
+				Out: heredoc.Doc(`
+				ARG VERSION=1
+				FROM nginx:latest
+				COPY --from=approved/tools:1 /a /b
+				ONBUILD COPY --from=approved/tools:1 /a /c
+				`),
+			},
+		},
 		{
 			original: heredoc.Doc(`
 				FROM scratch
@@ -524,6 +586,64 @@ func Test_addBuildParameters(t *testing.T) {
 	}
 }
 
+func TestAddBuildParametersTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builder-dockertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "Dockerfile"), heredoc.Doc(`
+		FROM golang:1.21 as build
+		RUN go build ./...
+		FROM ubi8 as test
+		COPY --from=build /app /app
+		RUN /app --test
+		FROM ubi8-minimal
+		COPY --from=build /app /app
+		`))
+
+	build := &buildapiv1.Build{}
+	build.Name = "app-1"
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		From: &corev1.ObjectReference{Kind: "DockerImage", Name: "ubi9"},
+		Env:  []corev1.EnvVar{{Name: builderutil.BuildTarget, Value: "test"}},
+	}
+	if err := addBuildParameters(dir, build, &git.SourceInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := dockerfile.Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := imagebuilder.SplitBy(node, dockercmd.From)
+	if len(stages) != 3 {
+		t.Fatalf("expected 3 stages, got:\n%s", out)
+	}
+	// the target stage is built on the strategy's image, and is the one
+	// labelled, the others are left as they were
+	expected := []string{
+		"FROM golang:1.21 as build\nENV \"BUILD_TARGET\"=\"test\"\nRUN go build ./...\n",
+		"FROM ubi9 as test\nENV \"BUILD_TARGET\"=\"test\"\nCOPY --from=build /app /app\nRUN /app --test\n" +
+			"ENV \"OPENSHIFT_BUILD_NAME\"=\"app-1\" \"OPENSHIFT_BUILD_NAMESPACE\"=\"\"\n" +
+			"LABEL \"io.openshift.build.name\"=\"app-1\" \"io.openshift.build.namespace\"=\"\"\n",
+		"FROM ubi8-minimal\nENV \"BUILD_TARGET\"=\"test\"\nCOPY --from=build /app /app\n",
+	}
+	for i, stage := range stages {
+		if got := string(dockerfile.Write(stage)); got != expected[i] {
+			t.Errorf("expected stage %d to be:\n%s\ngot:\n%s", i, expected[i], got)
+		}
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env[0].Value = "missing"
+	if err := addBuildParameters(dir, build, &git.SourceInfo{}); err == nil {
+		t.Errorf("expected an error for a target which is not a stage")
+	}
+}
+
 func Test_findReferencedImages(t *testing.T) {
 	type want struct {
 		Images []string
@@ -531,6 +651,8 @@ func Test_findReferencedImages(t *testing.T) {
 	}
 	tests := []struct {
 		original string
+		args     map[string]string
+		target   string
 		want     want
 	}{
 		{
@@ -612,6 +734,45 @@ func Test_findReferencedImages(t *testing.T) {
 				Images: []string{"other", "scratch", "test"},
 			},
 		},
+		{
+			original: heredoc.Doc(`
+				ARG VERSION=1
+				ARG TOOLS=tools
+				FROM base:${VERSION} as build
+				FROM base:$VERSION-slim
+				COPY --from=build /a /b
+				COPY --from=${TOOLS}:latest /a /c
+				`),
+			args: map[string]string{"VERSION": "2", "UNDECLARED": "x"},
+			want: want{
+				Images: []string{"base:2", "base:2-slim", "tools:latest"},
+			},
+		},
+		{
+			original: heredoc.Doc(`
+				FROM golang:1.21 as build
+				FROM tools as lint
+				FROM build as test
+				COPY --from=0 /a /b
+				COPY --from=docs:latest /a /c
+				FROM ubi8-minimal
+				COPY --from=build /a /b
+				`),
+			target: "test",
+			want: want{
+				Images: []string{"docs:latest", "golang:1.21"},
+			},
+		},
+		{
+			original: heredoc.Doc(`
+				FROM golang:1.21 as build
+				FROM ubi8-minimal
+				`),
+			target: "missing",
+			want: want{
+				Err: true,
+			},
+		},
 	}
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
@@ -628,7 +789,7 @@ func Test_findReferencedImages(t *testing.T) {
 			if _, err := dockerfile.Parse(strings.NewReader(test.original)); err != nil {
 				t.Fatal(err)
 			}
-			images, err := findReferencedImages(f.Name())
+			images, err := findReferencedImages(f.Name(), test.args, test.target)
 			got := want{
 				Images: images,
 				Err:    err != nil,
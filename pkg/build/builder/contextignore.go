@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	s2iconstants "github.com/openshift/source-to-image/pkg/api/constants"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+const (
+	// dockerIgnoreFile is the file of patterns which Docker and buildah
+	// exclude from a build's context.
+	dockerIgnoreFile = ".dockerignore"
+	// containerIgnoreFile is the file of patterns which podman and buildah
+	// exclude from a build's context, in preference to dockerIgnoreFile.
+	containerIgnoreFile = ".containerignore"
+)
+
+// getContextExcludes returns the patterns of the files which the build
+// strategy's environment excludes from the build's context.
+func getContextExcludes(build *buildapiv1.Build) ([]string, error) {
+	value, ok := buildStrategyEnv(build, builderutil.ContextExcludes)
+	if !ok {
+		return nil, nil
+	}
+	var excludes []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, err := filepath.Match(strings.TrimPrefix(pattern, "!"), ""); err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a valid pattern: %v", builderutil.ContextExcludes, value, pattern, err)
+		}
+		excludes = append(excludes, pattern)
+	}
+	return excludes, nil
+}
+
+// readIgnoreFile returns the lines of the ignore file at path, if there is
+// one.
+func readIgnoreFile(path string) ([]string, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n"), true, nil
+}
+
+// applyDockerContextIgnore writes the patterns of the files to exclude from
+// the context of a Docker strategy build into the .dockerignore of
+// contextDir, which is read when the context's files, including the build's
+// secrets and configmaps copied into it, are added to the image.  The
+// patterns are those of the context's .containerignore, or else its
+// .dockerignore, followed by those of the build strategy's environment.  The
+// named contexts copied into the context are never excluded.
+func applyDockerContextIgnore(build *buildapiv1.Build, contextDir string) error {
+	excludes, err := getContextExcludes(build)
+	if err != nil {
+		return err
+	}
+	contexts, err := getBuildContexts(build)
+	if err != nil {
+		return err
+	}
+	keep := buildContextIgnorePatterns(contexts)
+	patterns, ok, err := readIgnoreFile(filepath.Join(contextDir, containerIgnoreFile))
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.V(0).Infof("Excluding the files matched by %s from the build context", containerIgnoreFile)
+	} else if len(excludes) == 0 && len(keep) == 0 {
+		// the .dockerignore, if any, is used as it is
+		return nil
+	} else if patterns, ok, err = readIgnoreFile(filepath.Join(contextDir, dockerIgnoreFile)); err != nil {
+		return err
+	} else if !ok && len(excludes) == 0 {
+		// nothing is excluded
+		return nil
+	}
+	if len(excludes) > 0 {
+		log.V(0).Infof("Excluding %s from the build context", strings.Join(excludes, ", "))
+		patterns = append(patterns, excludes...)
+	}
+	patterns = append(patterns, keep...)
+	return ioutil.WriteFile(filepath.Join(contextDir, dockerIgnoreFile), []byte(strings.Join(patterns, "\n")+"\n"), 0664)
+}
+
+// applyS2IContextIgnore adds the patterns of the files which the build
+// strategy's environment excludes from the build's context to the .s2iignore
+// of contextDir, whose files S2I removes before it adds the source to the
+// image.
+func applyS2IContextIgnore(build *buildapiv1.Build, contextDir string) error {
+	excludes, err := getContextExcludes(build)
+	if err != nil || len(excludes) == 0 {
+		return err
+	}
+	path := filepath.Join(contextDir, s2iconstants.IgnoreFile)
+	patterns, _, err := readIgnoreFile(path)
+	if err != nil {
+		return err
+	}
+	log.V(0).Infof("Excluding %s from the build's source", strings.Join(excludes, ", "))
+	patterns = append(patterns, excludes...)
+	return ioutil.WriteFile(path, []byte(strings.Join(patterns, "\n")+"\n"), 0664)
+}
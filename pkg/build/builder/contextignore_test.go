@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetContextExcludes(t *testing.T) {
+	excludes, err := getContextExcludes(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXT_EXCLUDES", Value: " .git, **/node_modules,, !keep.txt "}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{".git", "**/node_modules", "!keep.txt"}; !reflect.DeepEqual(excludes, expect) {
+		t.Errorf("expected %v, got %v", expect, excludes)
+	}
+	if _, err := getContextExcludes(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXT_EXCLUDES", Value: "[a-"})); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestApplyDockerContextIgnore(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		excludes string
+		expect   string
+	}{
+		{
+			name:  "nothing to ignore",
+			files: map[string]string{},
+		},
+		{
+			name:   "only a .dockerignore",
+			files:  map[string]string{".dockerignore": "*.md\n"},
+			expect: "*.md\n",
+		},
+		{
+			name:   "a .containerignore is preferred",
+			files:  map[string]string{".dockerignore": "*.md\n", ".containerignore": "*.log\n"},
+			expect: "*.log\n",
+		},
+		{
+			name:     "excludes follow the .dockerignore",
+			files:    map[string]string{".dockerignore": "*.md\n!README.md\n"},
+			excludes: ".git,node_modules",
+			expect:   "*.md\n!README.md\n.git\nnode_modules\n",
+		},
+		{
+			name:     "excludes alone",
+			files:    map[string]string{},
+			excludes: ".git",
+			expect:   ".git\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "context-ignore")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.files {
+				writeTestFile(t, filepath.Join(dir, name), content)
+			}
+			if err := applyDockerContextIgnore(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXT_EXCLUDES", Value: test.excludes}), dir); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, ".dockerignore"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if string(data) != test.expect {
+				t.Errorf("expected .dockerignore %q, got %q", test.expect, data)
+			}
+		})
+	}
+}
+
+func TestApplyS2IContextIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "context-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	build := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_CONTEXT_EXCLUDES", Value: "node_modules"})
+
+	if err := applyS2IContextIgnore(build, dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, ".s2iignore")); string(data) != "node_modules\n" {
+		t.Errorf("unexpected .s2iignore %q", data)
+	}
+
+	writeTestFile(t, filepath.Join(dir, ".s2iignore"), "# tests\ntest")
+	if err := applyS2IContextIgnore(build, dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, ".s2iignore")); string(data) != "# tests\ntest\nnode_modules\n" {
+		t.Errorf("unexpected .s2iignore %q", data)
+	}
+}
@@ -3,28 +3,41 @@
 package builder
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/containers/buildah"
 	"github.com/containers/buildah/imagebuildah"
 	"github.com/containers/buildah/util"
+	idocker "github.com/containers/image/v5/docker"
 	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
 	"github.com/containers/image/v5/pkg/docker/config"
+	istorage "github.com/containers/image/v5/storage"
 	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/storage"
 	"github.com/containers/storage/pkg/archive"
 	docker "github.com/fsouza/go-dockerclient"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/openshift/imagebuilder"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 
 	buildapiv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	"github.com/openshift/builder/pkg/build/builder/metrics"
 	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 )
 
@@ -52,22 +65,135 @@ func pullDaemonlessImage(sc types.SystemContext, store storage.Store, imageName
 		return fmt.Errorf("unable to pull using empty image name")
 	}
 
-	_, err := alltransports.ParseImageName("docker://" + imageName)
+	systemContext := registrySystemContext(sc, imageName)
+	dockercfg.SetSystemContextFilePath(&systemContext, dockercfg.GetDockerConfigPath(searchPaths))
+
+	// the image of a manifest list for the build's pull platform is pulled
+	// by its digest, and named imageName once it is pulled
+	pullName := imageName
+	if len(pullPlatform) > 0 {
+		var err error
+		if pullName, err = platformImageName(&systemContext, imageName, pullPlatform); err != nil {
+			return fmt.Errorf("unable to find the %s image of %s: %v", pullPlatform, imageName, err)
+		}
+		if pullName != imageName {
+			log.V(0).Infof("Using %s for the %s image of %s", pullName, pullPlatform, imageName)
+		}
+	}
+
+	src, err := alltransports.ParseImageName("docker://" + pullName)
 	if err != nil {
-		return fmt.Errorf("error parsing image name to pull %s: %v", "docker://"+imageName, err)
+		return fmt.Errorf("error parsing image name to pull %s: %v", "docker://"+pullName, err)
 	}
 
-	systemContext := sc
-	dockercfg.SetSystemContextFilePath(&systemContext, dockercfg.GetDockerConfigPath(searchPaths))
+	layers, size, err := manifestLayers(&systemContext, src)
+	if err != nil {
+		log.V(4).Infof("Unable to measure the size of %s before pulling it: %v", imageName, err)
+	} else if err := requireDiskSpace(uint64(size), "pull "+imageName); err != nil {
+		return err
+	}
+	progress := startTransferProgress("Pulling", imageName, layers, size)
+	defer progress.finish()
 
 	options := buildah.PullOptions{
-		ReportWriter:  os.Stderr,
+		ReportWriter:  &progressReportWriter{w: os.Stderr, p: progress},
 		Store:         store,
 		SystemContext: &systemContext,
 		BlobDirectory: blobCacheDirectory,
 	}
-	_, err = buildah.Pull(context.TODO(), "docker://"+imageName, options)
-	return err
+	imageID, err := buildah.Pull(BuildContext(), "docker://"+pullName, options)
+	if err != nil {
+		return err
+	}
+	if pullName != imageName {
+		if err := tagDaemonlessImage(sc, store, pullName, imageName); err != nil {
+			return err
+		}
+	}
+	if ref, err := istorage.Transport.ParseStoreReference(store, "@"+imageID); err == nil {
+		if _, size, err := manifestLayers(&systemContext, ref); err == nil {
+			metrics.AddBytesPulled(size)
+		} else {
+			log.V(4).Infof("Unable to measure the size of %s: %v", imageName, err)
+		}
+	}
+	return nil
+}
+
+// resolvePlatformImage returns the digest reference of the image for
+// platform of imageName in its registry: the image which its manifest list
+// lists for the platform, and its variant if it names one, or else the image
+// itself, if it is for the platform.
+func resolvePlatformImage(sc types.SystemContext, imageName, platform string, searchPaths []string) (string, error) {
+	platformOS, arch, _, err := parsePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+	named, err := ireference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", imageName, err)
+	}
+	ref, err := alltransports.ParseImageName("docker://" + imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", "docker://"+imageName, err)
+	}
+	systemContext := registrySystemContext(sc, imageName)
+	dockercfg.SetSystemContextFilePath(&systemContext, dockercfg.GetDockerConfigPath(searchPaths))
+
+	if name, err := platformImageName(&systemContext, imageName, platform); err != nil || name != imageName {
+		return name, err
+	}
+
+	// an image which is not a manifest list must be for the platform
+	ctx := context.TODO()
+	img, err := ref.NewImage(ctx, &systemContext)
+	if err != nil {
+		return "", err
+	}
+	defer img.Close()
+	info, err := img.Inspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if info.Os != platformOS || info.Architecture != arch {
+		return "", fmt.Errorf("no image for the platform was found, only one for %s/%s", info.Os, info.Architecture)
+	}
+	blob, _, err := img.Manifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	imageDigest, err := manifest.Digest(blob)
+	if err != nil {
+		return "", err
+	}
+	return named.Name() + "@" + imageDigest.String(), nil
+}
+
+// manifestLayers returns the number of layers listed in the manifest of the
+// image at ref and their total size, which is the number of bytes transferred
+// when the image is copied somewhere none of its layers are present.
+func manifestLayers(sc *types.SystemContext, ref types.ImageReference) (int, int64, error) {
+	ctx := context.TODO()
+	src, err := ref.NewImageSource(ctx, sc)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	blob, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := manifest.FromBlob(blob, mimeType)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, layer := range m.LayerInfos() {
+		if layer.Size > 0 {
+			size += layer.Size
+		}
+	}
+	return len(m.LayerInfos()), size, nil
 }
 
 func daemonlessProcessLimits() (defaultProcessLimits []string) {
@@ -80,6 +206,12 @@ func daemonlessProcessLimits() (defaultProcessLimits []string) {
 		}
 	}
 	rlim = unix.Rlimit{Cur: 1048576, Max: 1048576}
+	// the pids controller of the builder's cgroup stops RUN instructions
+	// from starting more processes than it allows in any case, but tell
+	// them so rather than having fork fail unexpectedly
+	if pids := getPidsLimit(cgroupRoot); pids > 0 && uint64(pids) < rlim.Cur {
+		rlim = unix.Rlimit{Cur: uint64(pids), Max: uint64(pids)}
+	}
 	if err := unix.Setrlimit(unix.RLIMIT_NPROC, &rlim); err == nil {
 		defaultProcessLimits = append(defaultProcessLimits, fmt.Sprintf("nproc=%d:%d", rlim.Cur, rlim.Max))
 	} else {
@@ -90,7 +222,7 @@ func daemonlessProcessLimits() (defaultProcessLimits []string) {
 	return defaultProcessLimits
 }
 
-func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation buildah.Isolation, contextDir string, optimization buildapiv1.ImageOptimizationPolicy, opts *docker.BuildImageOptions, blobCacheDirectory string) error {
+func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation buildah.Isolation, contextDir string, optimization buildapiv1.ImageOptimizationPolicy, squash bool, opts *docker.BuildImageOptions, mounts []BuildMount, blobCacheDirectory string) error {
 	log.V(2).Infof("Building...")
 
 	args := make(map[string]string)
@@ -107,18 +239,39 @@ func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation
 	layers := false
 	switch optimization {
 	case buildapiv1.ImageOptimizationSkipLayers, buildapiv1.ImageOptimizationSkipLayersAndWarn:
+		// each stage runs its instructions in a single container, which is
+		// committed once, adding one layer to its base image
+		log.V(2).Infof("Skipping layers, the instructions of each stage are committed as one layer.")
 		layers = false
 	case buildapiv1.ImageOptimizationNone:
 		layers = true
 	default:
 		return fmt.Errorf("internal error: image optimization policy %q not fully implemented", string(optimization))
 	}
+	if squash {
+		log.V(2).Infof("Squashing the image into a single layer.")
+	}
 
 	systemContext := sc
 	// if credsDir, ok := os.LookupEnv("PULL_DOCKERCFG_PATH"); ok {
 	// 	systemContext.AuthFilePath = filepath.Join(credsDir, "config.json")
 	// }
-	systemContext.AuthFilePath = "/tmp/config.json"
+	systemContext.AuthFilePath = authFilePath
+
+	if len(opts.Platform) > 0 {
+		platformOS, arch, variant, err := parsePlatform(opts.Platform)
+		if err != nil {
+			return err
+		}
+		log.V(2).Infof("Building for platform %q.", opts.Platform)
+		// base images cannot be selected by variant, which is only recorded
+		// in the manifest list
+		if len(variant) > 0 {
+			log.V(2).Infof("Using the first %s/%s base images for variant %q.", platformOS, arch, variant)
+		}
+		systemContext.OSChoice = platformOS
+		systemContext.ArchitectureChoice = arch
+	}
 
 	for registry, ac := range opts.AuthConfigs.Configs {
 		log.V(5).Infof("Setting authentication for registry %q at %q.", registry, ac.ServerAddress)
@@ -143,9 +296,19 @@ func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation
 			Options:     []string{"ro", "nodev", "noexec", "nosuid"},
 		})
 	}
+	for _, mount := range mounts {
+		transientMounts = append(transientMounts, imagebuildah.Mount{
+			Source:      mount.Source,
+			Destination: mount.Destination,
+			Type:        "bind",
+			Options:     []string{"ro", "nodev", "noexec", "nosuid"},
+		})
+	}
 
+	namespaceOptions, networkPolicy := daemonlessNetwork()
 	options := imagebuildah.BuildOptions{
 		ContextDirectory: contextDir,
+		Target:           opts.Target,
 		PullPolicy:       pullPolicy,
 		Isolation:        isolation,
 		TransientMounts:  transientMounts,
@@ -154,19 +317,23 @@ func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation
 		Out:              opts.OutputStream,
 		Err:              opts.OutputStream,
 		ReportWriter:     opts.OutputStream,
-		OutputFormat:     buildah.Dockerv2ImageManifest,
+		OutputFormat:     daemonlessManifestType(),
 		SystemContext:    &systemContext,
-		NamespaceOptions: buildah.NamespaceOptions{
-			{Name: string(specs.NetworkNamespace), Host: true},
-		},
-		CommonBuildOpts: &buildah.CommonBuildOptions{
+		NamespaceOptions: namespaceOptions,
+		ConfigureNetwork: networkPolicy,
+		CommonBuildOpts: rootlessBuildOptions(&buildah.CommonBuildOptions{
 			HTTPProxy:    true,
+			CPUPeriod:    uint64(opts.CPUPeriod),
+			CPUQuota:     opts.CPUQuota,
+			CPUShares:    uint64(opts.CPUShares),
 			Memory:       opts.Memory,
 			MemorySwap:   opts.Memswap,
 			CgroupParent: opts.CgroupParent,
-			Ulimit:       daemonlessProcessLimits(),
-		},
+			Ulimit:       mergeUlimits(daemonlessProcessLimits(), buildUlimits),
+			ShmSize:      buildShmSize,
+		}),
 		Layers:                  layers,
+		Squash:                  squash,
 		NoCache:                 opts.NoCache,
 		RemoveIntermediateCtrs:  opts.RmTmpContainer,
 		ForceRmIntermediateCtrs: true,
@@ -174,8 +341,102 @@ func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation
 		DropCapabilities:        dropCapabilities(),
 	}
 
+	if err := buildIndependentStages(opts.Context, store, options, opts.Dockerfile); err != nil {
+		return hermeticBuildError(err)
+	}
 	_, _, err := imagebuildah.BuildDockerfiles(opts.Context, store, options, opts.Dockerfile)
-	return err
+	return hermeticBuildError(err)
+}
+
+// buildIndependentStages builds the stages of the Dockerfile at path, which
+// is relative to the build's context directory, that depend on no other
+// stage, up to stageParallelism of them at a time.  The build of the whole
+// Dockerfile which follows then finds their layers in its cache rather than
+// building them one after another.  Builds which do not cache their layers
+// build their stages one after another.
+func buildIndependentStages(ctx context.Context, store storage.Store, options imagebuildah.BuildOptions, path string) error {
+	if stageParallelism < 2 {
+		return nil
+	}
+	if !options.Layers || options.NoCache {
+		log.V(2).Infof("Not building independent stages in parallel, the build does not cache its layers.")
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(options.ContextDirectory, path)
+	}
+	node, err := imagebuilder.ParseFile(path)
+	if err != nil {
+		return err
+	}
+	stages, err := independentStages(node, options.Args, options.Target)
+	if err != nil || len(stages) < 2 {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "stages")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return buildStagesInParallel(stages, stageParallelism, options.Out, func(stage parallelStage, out io.Writer) error {
+		stagePath := filepath.Join(dir, stage.name+".Dockerfile")
+		if err := ioutil.WriteFile(stagePath, stage.dockerfile, 0644); err != nil {
+			return err
+		}
+		stageOptions := options
+		stageOptions.Target = ""
+		stageOptions.Output = ""
+		stageOptions.Out, stageOptions.Err, stageOptions.ReportWriter = out, out, out
+		_, _, err := imagebuildah.BuildDockerfiles(ctx, store, stageOptions, stagePath)
+		return err
+	})
+}
+
+// daemonlessNetwork returns the network namespace of the containers which run
+// the steps of a build.  They share the builder's network, unless the build
+// is hermetic, when they get a private network namespace with no interfaces
+// besides loopback, so that any attempt to reach the network fails at once
+// instead of waiting for a timeout.
+func daemonlessNetwork() (buildah.NamespaceOptions, buildah.NetworkConfigurationPolicy) {
+	if HermeticBuild {
+		log.V(0).Infof("Networking is disabled for the steps of this hermetic build.")
+		return buildah.NamespaceOptions{
+			{Name: string(specs.NetworkNamespace), Host: false},
+		}, buildah.NetworkDisabled
+	}
+	return buildah.NamespaceOptions{
+		{Name: string(specs.NetworkNamespace), Host: true},
+	}, buildah.NetworkDefault
+}
+
+// hermeticBuildError explains a step's failure in a hermetic build, which is
+// most likely caused by the step trying to reach the network.
+func hermeticBuildError(err error) error {
+	if err == nil || !HermeticBuild {
+		return err
+	}
+	return fmt.Errorf("%v (networking is disabled because %s is set: a step of a hermetic build which accesses the network fails)", err, builderutil.Hermetic)
+}
+
+// setDaemonlessCompression sets the compression of the layers pushed with sc
+// to the DefaultImageCompression.
+func setDaemonlessCompression(sc *types.SystemContext) error {
+	algorithm, err := compression.AlgorithmByName(DefaultImageCompression)
+	if err != nil {
+		return err
+	}
+	sc.CompressionFormat = &algorithm
+	sc.CompressionLevel = imageCompressionLevel
+	return nil
+}
+
+// daemonlessManifestType returns the manifest type of the images committed
+// and pushed in the DefaultImageFormat.
+func daemonlessManifestType() string {
+	if DefaultImageFormat == imageFormatOCI {
+		return buildah.OCIv1ImageManifest
+	}
+	return buildah.Dockerv2ImageManifest
 }
 
 func tagDaemonlessImage(sc types.SystemContext, store storage.Store, buildTag, pushTag string) error {
@@ -247,8 +508,8 @@ func pushDaemonlessImage(sc types.SystemContext, store storage.Store, imageName
 		return "", fmt.Errorf("error parsing destination image name %s: %v", "docker://"+imageName, err)
 	}
 
-	systemContext := sc
-	systemContext.AuthFilePath = "/tmp/config.json"
+	systemContext := registrySystemContext(sc, imageName)
+	systemContext.AuthFilePath = authFilePath
 
 	if authConfig.Username != "" && authConfig.Password != "" {
 		log.V(2).Infof("Setting authentication secret for %q.", authConfig.ServerAddress)
@@ -260,21 +521,45 @@ func pushDaemonlessImage(sc types.SystemContext, store storage.Store, imageName
 		log.V(2).Infof("No authentication secret provided for pushing to registry.")
 	}
 
+	if err := setDaemonlessCompression(&systemContext); err != nil {
+		return "", err
+	}
+	dest = limitPushConcurrency(dest)
+
+	var layers int
+	var size int64
+	if local, err := istorage.Transport.ParseStoreReference(store, imageName); err == nil {
+		if layers, size, err = manifestLayers(&systemContext, local); err != nil {
+			log.V(4).Infof("Unable to measure the size of %s before pushing it: %v", imageName, err)
+		}
+	}
+	progress := startTransferProgress("Pushing", imageName, layers, size)
+	defer progress.finish()
+	dest = &progressReference{ImageReference: dest, p: progress}
+
 	options := buildah.PushOptions{
 		Compression:   archive.Gzip,
 		ReportWriter:  os.Stdout,
 		Store:         store,
 		SystemContext: &systemContext,
+		ManifestType:  daemonlessManifestType(),
 		BlobDirectory: blobCacheDirectory,
 	}
 
 	// return the digest of the image
-	_, digest, err := buildah.Push(context.TODO(), imageName, dest, options)
+	_, digest, err := buildah.Push(BuildContext(), imageName, dest, options)
 	logName := imageName
 	if dref := dest.DockerReference(); dref != nil {
 		if named, ok := dref.(ireference.Named); ok {
 			if canonical, err := ireference.WithDigest(ireference.TrimNamed(named), digest); err == nil {
 				logName = canonical.String()
+				if pushed, err := idocker.NewReference(canonical); err == nil && len(digest) > 0 {
+					if _, size, err := manifestLayers(&systemContext, pushed); err == nil {
+						metrics.AddBytesPushed(size)
+					} else {
+						log.V(4).Infof("Unable to measure the size of %s: %v", logName, err)
+					}
+				}
 			}
 		}
 	}
@@ -282,6 +567,316 @@ func pushDaemonlessImage(sc types.SystemContext, store storage.Store, imageName
 	return string(digest), err
 }
 
+// limitPushConcurrency returns dest, limited to pushing as many blobs at once
+// as configured for its registry.
+func limitPushConcurrency(dest types.ImageReference) types.ImageReference {
+	named := dest.DockerReference()
+	if named == nil {
+		return dest
+	}
+	limit := pushConcurrency(ireference.Domain(named))
+	if limit <= 0 {
+		return dest
+	}
+	log.V(2).Infof("Pushing at most %d blobs at once to %s.", limit, ireference.Domain(named))
+	return &limitedReference{ImageReference: dest, limit: limit}
+}
+
+// limitedReference is an image reference whose destinations push at most
+// limit blobs at once.
+type limitedReference struct {
+	types.ImageReference
+	limit int
+}
+
+func (r *limitedReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := r.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedDestination{ImageDestination: dest, slots: make(chan struct{}, r.limit)}, nil
+}
+
+// limitedDestination is an image destination which pushes at most as many
+// blobs at once as it has slots.
+type limitedDestination struct {
+	types.ImageDestination
+	slots chan struct{}
+}
+
+func (d *limitedDestination) HasThreadSafePutBlob() bool {
+	return cap(d.slots) > 1 && d.ImageDestination.HasThreadSafePutBlob()
+}
+
+func (d *limitedDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return types.BlobInfo{}, ctx.Err()
+	}
+	defer func() { <-d.slots }()
+	return d.ImageDestination.PutBlob(ctx, stream, inputInfo, cache, isConfig)
+}
+
+// pushDaemonlessManifestList pushes a manifest list referring to the images,
+// already pushed to the repository of imageName, for each of the instances,
+// and returns its digest.
+func pushDaemonlessManifestList(sc types.SystemContext, imageName string, instances []ManifestListInstance, authConfig docker.AuthConfiguration) (string, error) {
+	log.V(2).Infof("Pushing manifest list %q.", imageName)
+
+	if imageName == "" {
+		return "", fmt.Errorf("unable to push using empty destination image name")
+	}
+
+	dest, err := alltransports.ParseImageName("docker://" + imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing destination image name %s: %v", "docker://"+imageName, err)
+	}
+	named, ok := dest.DockerReference().(ireference.Named)
+	if !ok {
+		return "", fmt.Errorf("unable to determine the repository of %s", imageName)
+	}
+
+	systemContext := registrySystemContext(sc, imageName)
+	systemContext.AuthFilePath = authFilePath
+	if authConfig.Username != "" && authConfig.Password != "" {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: authConfig.Username,
+			Password: authConfig.Password,
+		}
+	}
+
+	ctx := BuildContext()
+	descriptors := []manifest.Schema2ManifestDescriptor{}
+	for _, instance := range instances {
+		canonical, err := instanceReference(named, instance)
+		if err != nil {
+			return "", err
+		}
+		ref, err := idocker.NewReference(canonical)
+		if err != nil {
+			return "", err
+		}
+		src, err := ref.NewImageSource(ctx, &systemContext)
+		if err != nil {
+			return "", err
+		}
+		manifestBytes, mimeType, err := src.GetManifest(ctx, nil)
+		src.Close()
+		if err != nil {
+			return "", fmt.Errorf("unable to read the manifest for platform %s: %v", instance.Platform, err)
+		}
+		descriptor, err := manifestListDescriptor(instance, canonical, mimeType, int64(len(manifestBytes)))
+		if err != nil {
+			return "", err
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	var listBytes []byte
+	if DefaultImageFormat == imageFormatOCI {
+		listBytes, err = json.Marshal(ociIndexFromComponents(descriptors))
+	} else {
+		listBytes, err = manifest.Schema2ListFromComponents(descriptors).Serialize()
+	}
+	if err != nil {
+		return "", err
+	}
+	destination, err := dest.NewImageDestination(ctx, &systemContext)
+	if err != nil {
+		return "", err
+	}
+	defer destination.Close()
+	if err := destination.PutManifest(ctx, listBytes, nil); err != nil {
+		return "", err
+	}
+	if err := destination.Commit(ctx, nil); err != nil {
+		return "", err
+	}
+
+	listDigest, err := manifest.Digest(listBytes)
+	if err != nil {
+		return "", err
+	}
+	if canonical, err := ireference.WithDigest(ireference.TrimNamed(named), listDigest); err == nil {
+		log.V(0).Infof("Successfully pushed %s", canonical.String())
+	}
+	return string(listDigest), nil
+}
+
+// instanceReference returns the canonical reference, in the repository of
+// named, to the image pushed for instance.
+func instanceReference(named ireference.Named, instance ManifestListInstance) (ireference.Canonical, error) {
+	ref, err := ireference.ParseNormalizedNamed(ireference.TrimNamed(named).Name() + "@" + instance.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest %q for platform %s: %v", instance.Digest, instance.Platform, err)
+	}
+	canonical, ok := ref.(ireference.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("invalid digest %q for platform %s", instance.Digest, instance.Platform)
+	}
+	return canonical, nil
+}
+
+// manifestListDescriptor returns the manifest list entry for the image at ref
+// pushed for the platform of instance.
+func manifestListDescriptor(instance ManifestListInstance, ref ireference.Canonical, mimeType string, size int64) (manifest.Schema2ManifestDescriptor, error) {
+	platformOS, arch, variant, err := parsePlatform(instance.Platform)
+	if err != nil {
+		return manifest.Schema2ManifestDescriptor{}, err
+	}
+	return manifest.Schema2ManifestDescriptor{
+		Schema2Descriptor: manifest.Schema2Descriptor{
+			MediaType: mimeType,
+			Size:      size,
+			Digest:    ref.Digest(),
+		},
+		Platform: manifest.Schema2PlatformSpec{
+			Architecture: arch,
+			OS:           platformOS,
+			Variant:      variant,
+		},
+	}, nil
+}
+
+// ociIndex is an OCI image index, the OCI counterpart of a manifest list.
+type ociIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []ociIndexDescriptor `json:"manifests"`
+}
+
+// ociIndexDescriptor is an entry of an OCI image index.
+type ociIndexDescriptor struct {
+	ociDescriptor
+	Platform ociPlatform `json:"platform"`
+}
+
+// ociPlatform is the platform of an entry of an OCI image index.
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ociIndexFromComponents returns the OCI image index with the same entries
+// as the manifest list made of descriptors.
+func ociIndexFromComponents(descriptors []manifest.Schema2ManifestDescriptor) ociIndex {
+	index := ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests:     []ociIndexDescriptor{},
+	}
+	for _, descriptor := range descriptors {
+		index.Manifests = append(index.Manifests, ociIndexDescriptor{
+			ociDescriptor: ociDescriptor{
+				MediaType: descriptor.MediaType,
+				Digest:    descriptor.Digest.String(),
+				Size:      descriptor.Size,
+			},
+			Platform: ociPlatform{
+				Architecture: descriptor.Platform.Architecture,
+				OS:           descriptor.Platform.OS,
+				Variant:      descriptor.Platform.Variant,
+			},
+		})
+	}
+	return index
+}
+
+// layerCacheImages returns the IDs of the images in store which make up the
+// layers of the image name, starting with the one closest to its base image.
+// These are the unnamed intermediate images left by a layered build, followed
+// by the image itself.
+func layerCacheImages(sc types.SystemContext, store storage.Store, name string) ([]string, error) {
+	systemContext := sc
+	_, img, err := util.FindImage(store, "", &systemContext, name)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, storage.ErrImageUnknown
+	}
+
+	// the depth of each of the image's layers, counting down from its top layer
+	depth := map[string]int{}
+	for id, i := img.TopLayer, 0; id != ""; i++ {
+		depth[id] = i
+		layer, err := store.Layer(id)
+		if err != nil {
+			return nil, err
+		}
+		id = layer.Parent
+	}
+
+	images, err := store.Images()
+	if err != nil {
+		return nil, err
+	}
+	intermediates := []storage.Image{}
+	for _, image := range images {
+		if _, ok := depth[image.TopLayer]; !ok || image.ID == img.ID || len(image.Names) > 0 {
+			continue
+		}
+		intermediates = append(intermediates, image)
+	}
+	sort.SliceStable(intermediates, func(i, j int) bool {
+		di, dj := depth[intermediates[i].TopLayer], depth[intermediates[j].TopLayer]
+		if di != dj {
+			return di > dj
+		}
+		return intermediates[i].Created.Before(intermediates[j].Created)
+	})
+
+	ids := []string{}
+	for _, image := range intermediates {
+		ids = append(ids, image.ID)
+	}
+	return append(ids, img.ID), nil
+}
+
+// pushDaemonlessLayerCache pushes the images making up the layers of the
+// image name to the layer cache at cacheRef.
+func pushDaemonlessLayerCache(sc types.SystemContext, store storage.Store, name, cacheRef string, authConfig docker.AuthConfiguration, blobCacheDirectory string) error {
+	ids, err := layerCacheImages(sc, store, name)
+	if err != nil {
+		return err
+	}
+
+	systemContext := registrySystemContext(sc, cacheRef)
+	systemContext.AuthFilePath = authFilePath
+	if authConfig.Username != "" && authConfig.Password != "" {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: authConfig.Username,
+			Password: authConfig.Password,
+		}
+	}
+	if err := setDaemonlessCompression(&systemContext); err != nil {
+		return err
+	}
+	options := buildah.PushOptions{
+		Compression:   archive.Gzip,
+		Store:         store,
+		SystemContext: &systemContext,
+		ManifestType:  daemonlessManifestType(),
+		BlobDirectory: blobCacheDirectory,
+	}
+
+	for i, id := range ids {
+		cacheTag := layerCacheTag(cacheRef, i+1)
+		dest, err := alltransports.ParseImageName("docker://" + cacheTag)
+		if err != nil {
+			return fmt.Errorf("error parsing layer cache image name %s: %v", "docker://"+cacheTag, err)
+		}
+		log.V(4).Infof("Pushing layer cache image %s as %s.", id, cacheTag)
+		if _, _, err := buildah.Push(BuildContext(), id, limitPushConcurrency(dest), options); err != nil {
+			return err
+		}
+	}
+	log.V(0).Infof("Pushed %d layer cache images to %s", len(ids), cacheRef)
+	return nil
+}
+
 func inspectDaemonlessImage(sc types.SystemContext, store storage.Store, name string) (*docker.Image, error) {
 	systemContext := sc
 
@@ -345,6 +940,19 @@ func inspectDaemonlessImage(sc types.SystemContext, store storage.Store, name st
 		created = *oconfig.Created
 	}
 
+	repoDigests := []string{}
+	if img.Digest != "" {
+		for _, name := range img.Names {
+			named, err := ireference.ParseNormalizedNamed(name)
+			if err != nil {
+				continue
+			}
+			if canonical, err := ireference.WithDigest(ireference.TrimNamed(named), img.Digest); err == nil {
+				repoDigests = append(repoDigests, canonical.String())
+			}
+		}
+	}
+
 	return &docker.Image{
 		ID:              img.ID,
 		RepoTags:        []string{},
@@ -359,7 +967,7 @@ func inspectDaemonlessImage(sc types.SystemContext, store storage.Store, name st
 		Architecture:    oconfig.Architecture,
 		Size:            size,
 		VirtualSize:     size,
-		RepoDigests:     []string{},
+		RepoDigests:     repoDigests,
 		RootFS:          rootfs,
 		OS:              oconfig.OS,
 	}, nil
@@ -378,13 +986,17 @@ func daemonlessRun(ctx context.Context, store storage.Store, isolation buildah.I
 	builderOptions := buildah.BuilderOptions{
 		Container: createOpts.Name,
 		FromImage: createOpts.Config.Image,
-		CommonBuildOpts: &buildah.CommonBuildOptions{
+		CommonBuildOpts: rootlessBuildOptions(&buildah.CommonBuildOptions{
 			HTTPProxy:    true,
+			CPUPeriod:    uint64(createOpts.HostConfig.CPUPeriod),
+			CPUQuota:     createOpts.HostConfig.CPUQuota,
+			CPUShares:    uint64(createOpts.HostConfig.CPUShares),
 			Memory:       createOpts.HostConfig.Memory,
 			MemorySwap:   createOpts.HostConfig.MemorySwap,
 			CgroupParent: createOpts.HostConfig.CgroupParent,
-			Ulimit:       daemonlessProcessLimits(),
-		},
+			Ulimit:       mergeUlimits(daemonlessProcessLimits(), buildUlimits),
+			ShmSize:      buildShmSize,
+		}),
 		BlobDirectory: blobCacheDirectory,
 	}
 
@@ -406,12 +1018,536 @@ func daemonlessRun(ctx context.Context, store storage.Store, isolation buildah.I
 		Isolation:        isolation,
 		Entrypoint:       entrypoint,
 		Cmd:              createOpts.Config.Cmd,
+		Env:              createOpts.Config.Env,
+		WorkingDir:       createOpts.Config.WorkingDir,
 		Stdout:           attachOpts.OutputStream,
 		Stderr:           attachOpts.ErrorStream,
 		DropCapabilities: dropCapabilities(),
 	}
+	if HermeticBuild {
+		runOptions.NamespaceOptions, runOptions.ConfigureNetwork = daemonlessNetwork()
+	}
+	for _, bind := range createOpts.HostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("error calling daemonlessRun: invalid bind %q", bind)
+		}
+		options := []string{"rbind", "ro"}
+		if len(parts) == 3 && parts[2] == "rw" {
+			options = []string{"rbind", "rw"}
+		}
+		runOptions.Mounts = append(runOptions.Mounts, specs.Mount{
+			Source:      parts[0],
+			Destination: parts[1],
+			Type:        "bind",
+			Options:     options,
+		})
+	}
+
+	return hermeticBuildError(builder.Run(append(entrypoint, createOpts.Config.Cmd...), runOptions))
+}
+
+// mountDaemonlessImage mounts the root filesystem of the local image
+// imageName in a temporary working container, returning its location and a
+// function which unmounts it and removes the container.
+func mountDaemonlessImage(sc types.SystemContext, store storage.Store, imageName string) (string, func(), error) {
+	systemContext := sc
+	builder, err := buildah.NewBuilder(context.TODO(), store, buildah.BuilderOptions{
+		FromImage:     imageName,
+		PullPolicy:    buildah.PullNever,
+		SystemContext: &systemContext,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	root, err := builder.Mount("")
+	if err != nil {
+		builder.Delete()
+		return "", nil, err
+	}
+	return root, func() {
+		if err := builder.Unmount(); err != nil {
+			log.V(0).Infof("warning: Failed to unmount %s: %v", imageName, err)
+		}
+		if err := builder.Delete(); err != nil {
+			log.V(0).Infof("warning: Failed to remove the working container for %s: %v", imageName, err)
+		}
+	}, nil
+}
+
+// byteCounter is an io.Writer which counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// daemonlessImageSize returns the total size of the layers of the local image
+// imageName, uncompressed, and, if compressed is set, compressed as
+// pushDaemonlessImage compresses them.
+func daemonlessImageSize(sc types.SystemContext, store storage.Store, imageName string, compressed bool) (int64, int64, error) {
+	systemContext := sc
+	if err := setDaemonlessCompression(&systemContext); err != nil {
+		return 0, 0, err
+	}
+	ctx := context.TODO()
+
+	ref, img, err := util.FindImage(store, "", &systemContext, imageName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if img == nil {
+		return 0, 0, storage.ErrImageUnknown
+	}
+	image, err := ref.NewImage(ctx, &systemContext)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer image.Close()
+	src, err := ref.NewImageSource(ctx, &systemContext)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	var uncompressedSize, compressedSize byteCounter
+	for _, layer := range image.LayerInfos() {
+		err := func() error {
+			rc, _, err := src.GetBlob(ctx, layer, none.NoCache)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			uncompressed, err := archive.DecompressStream(rc)
+			if err != nil {
+				return err
+			}
+			defer uncompressed.Close()
+			if !compressed {
+				_, err = io.Copy(&uncompressedSize, uncompressed)
+				return err
+			}
+			compressor, err := compression.CompressStream(&compressedSize, *systemContext.CompressionFormat, systemContext.CompressionLevel)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.MultiWriter(&uncompressedSize, compressor), uncompressed); err != nil {
+				compressor.Close()
+				return err
+			}
+			return compressor.Close()
+		}()
+		if err != nil {
+			return 0, 0, fmt.Errorf("error reading layer %s: %v", layer.Digest, err)
+		}
+	}
+	return int64(uncompressedSize), int64(compressedSize), nil
+}
+
+// daemonlessImageReference returns a reference to the image name, in the
+// local store or, if remote is set, in its registry, and the system context
+// with which to read it, using auth for the registry.
+func daemonlessImageReference(sc types.SystemContext, store storage.Store, name string, remote bool, auth docker.AuthConfiguration) (types.ImageReference, *types.SystemContext, error) {
+	if !remote {
+		systemContext := sc
+		ref, img, err := util.FindImage(store, "", &systemContext, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if img == nil {
+			return nil, nil, storage.ErrImageUnknown
+		}
+		return ref, &systemContext, nil
+	}
+	ref, err := alltransports.ParseImageName("docker://" + name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing image name %s: %v", "docker://"+name, err)
+	}
+	systemContext := registrySystemContext(sc, name)
+	systemContext.AuthFilePath = authFilePath
+	if auth.Username != "" && auth.Password != "" {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: auth.Username,
+			Password: auth.Password,
+		}
+	}
+	return ref, &systemContext, nil
+}
+
+// daemonlessImageDiffIDs returns the digests of the uncompressed layers of
+// the image name, which is local or, if remote is set, in its registry.
+func daemonlessImageDiffIDs(sc types.SystemContext, store storage.Store, name string, remote bool, auth docker.AuthConfiguration) ([]string, error) {
+	ref, systemContext, err := daemonlessImageReference(sc, store, name, remote, auth)
+	if err != nil {
+		return nil, err
+	}
+	ctx := BuildContext()
+	image, err := ref.NewImage(ctx, systemContext)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+	oconfig, err := image.OCIConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := make([]string, 0, len(oconfig.RootFS.DiffIDs))
+	for _, diffID := range oconfig.RootFS.DiffIDs {
+		diffIDs = append(diffIDs, diffID.String())
+	}
+	return diffIDs, nil
+}
+
+// readDaemonlessImageLayer calls read with the uncompressed content of the
+// index'th layer of the image name, which is local or, if remote is set, in
+// its registry.
+func readDaemonlessImageLayer(sc types.SystemContext, store storage.Store, name string, remote bool, auth docker.AuthConfiguration, index int, read func(io.Reader) error) error {
+	ref, systemContext, err := daemonlessImageReference(sc, store, name, remote, auth)
+	if err != nil {
+		return err
+	}
+	ctx := BuildContext()
+	image, err := ref.NewImage(ctx, systemContext)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	src, err := ref.NewImageSource(ctx, systemContext)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
 
-	return builder.Run(append(entrypoint, createOpts.Config.Cmd...), runOptions)
+	layers := image.LayerInfos()
+	if index < 0 || index >= len(layers) {
+		return fmt.Errorf("image %s has no layer %d", name, index)
+	}
+	rc, _, err := src.GetBlob(ctx, layers[index], none.NoCache)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	uncompressed, err := archive.DecompressStream(rc)
+	if err != nil {
+		return err
+	}
+	defer uncompressed.Close()
+	return read(uncompressed)
+}
+
+// normalizedImage is the image written by normalizeDaemonlessImage.
+type normalizedImage struct {
+	ref          types.ImageReference
+	manifest     []byte
+	manifestType string
+}
+
+func (i *normalizedImage) Reference() types.ImageReference {
+	return i.ref
+}
+
+func (i *normalizedImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return i.manifest, i.manifestType, nil
+}
+
+func (i *normalizedImage) Signatures(ctx context.Context) ([][]byte, error) {
+	return nil, nil
+}
+
+// normalizeDaemonlessImage replaces the local image imageName with a copy
+// dated created, whose layers have the timestamps of their files clamped to
+// created, and whose configuration leaves out what differs between builds.
+// Layers whose files are all older than created, such as those of the base
+// image, are kept as they are.
+func normalizeDaemonlessImage(sc types.SystemContext, store storage.Store, imageName string, created time.Time) error {
+	systemContext := sc
+	ctx := context.TODO()
+
+	ref, img, err := util.FindImage(store, "", &systemContext, imageName)
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return storage.ErrImageUnknown
+	}
+	image, err := ref.NewImage(ctx, &systemContext)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	src, err := ref.NewImageSource(ctx, &systemContext)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	manifestBytes, manifestType, err := image.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	man, err := manifest.FromBlob(manifestBytes, manifestType)
+	if err != nil {
+		return err
+	}
+	oconfig, err := image.OCIConfig(ctx)
+	if err != nil {
+		return err
+	}
+	configBlob, err := image.ConfigBlob(ctx)
+	if err != nil {
+		return err
+	}
+	layers := image.LayerInfos()
+	if len(layers) != len(oconfig.RootFS.DiffIDs) {
+		return fmt.Errorf("image %s has %d layers but its configuration lists %d", imageName, len(layers), len(oconfig.RootFS.DiffIDs))
+	}
+
+	destRef, err := istorage.Transport.ParseStoreReference(store, imageName)
+	if err != nil {
+		return err
+	}
+	dest, err := destRef.NewImageDestination(ctx, &systemContext)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	diffIDs := make([]string, len(layers))
+	for i, layer := range layers {
+		normalized, changed, err := normalizeDaemonlessLayer(ctx, src, dest, layer, created)
+		if err != nil {
+			return fmt.Errorf("error normalizing layer %s of image %s: %v", layer.Digest, imageName, err)
+		}
+		layers[i] = normalized
+		diffIDs[i] = oconfig.RootFS.DiffIDs[i].String()
+		if changed {
+			// the rewritten layer is stored uncompressed
+			diffIDs[i] = normalized.Digest.String()
+		}
+	}
+	if err := man.UpdateLayerInfos(layers); err != nil {
+		return err
+	}
+
+	configBlob, err = normalizeImageConfig(configBlob, created, diffIDs)
+	if err != nil {
+		return err
+	}
+	configInfo, err := dest.PutBlob(ctx, bytes.NewReader(configBlob), types.BlobInfo{Size: int64(len(configBlob))}, none.NoCache, true)
+	if err != nil {
+		return err
+	}
+	switch m := man.(type) {
+	case *manifest.Schema2:
+		m.ConfigDescriptor.Digest = configInfo.Digest
+		m.ConfigDescriptor.Size = configInfo.Size
+	case *manifest.OCI1:
+		m.Config.Digest = configInfo.Digest
+		m.Config.Size = configInfo.Size
+	default:
+		return fmt.Errorf("image %s has a manifest of unsupported type %s", imageName, manifestType)
+	}
+	manifestBytes, err = man.Serialize()
+	if err != nil {
+		return err
+	}
+	if err := dest.PutManifest(ctx, manifestBytes, nil); err != nil {
+		return err
+	}
+	if err := dest.Commit(ctx, &normalizedImage{ref: destRef, manifest: manifestBytes, manifestType: manifestType}); err != nil {
+		return err
+	}
+
+	// the name has moved to the normalized image, so the original can go
+	// unless it has other names
+	if original, err := store.Image(img.ID); err == nil && len(original.Names) == 0 {
+		if _, err := store.DeleteImage(img.ID, true); err != nil {
+			log.V(0).Infof("warning: Failed to remove the image %s that %s was normalized from: %v", img.ID, imageName, err)
+		}
+	}
+	return nil
+}
+
+// normalizeDaemonlessLayer stores layer of the image read by src in dest,
+// with the timestamps of its files clamped to created if any of them are
+// later, and returns the stored layer and whether it was rewritten.
+func normalizeDaemonlessLayer(ctx context.Context, src types.ImageSource, dest types.ImageDestination, layer types.BlobInfo, created time.Time) (types.BlobInfo, bool, error) {
+	rc, _, err := src.GetBlob(ctx, layer, none.NoCache)
+	if err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	defer rc.Close()
+	uncompressed, err := archive.DecompressStream(rc)
+	if err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	defer uncompressed.Close()
+
+	f, err := ioutil.TempFile("", "normalized-layer")
+	if err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	changed, err := clampLayerTimestamps(uncompressed, f, created)
+	if err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	if !changed {
+		reused, _, err := dest.TryReusingBlob(ctx, layer, none.NoCache, false)
+		if err != nil {
+			return types.BlobInfo{}, false, err
+		}
+		if reused {
+			return layer, false, nil
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	normalized, err := dest.PutBlob(ctx, f, types.BlobInfo{Size: -1}, none.NoCache, false)
+	if err != nil {
+		return types.BlobInfo{}, false, err
+	}
+	normalized.MediaType = layer.MediaType
+	return normalized, true, nil
+}
+
+// ociDescriptor describes a blob or manifest in an ociArtifactManifest.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociArtifactManifest is an OCI image manifest holding an artifact as its
+// only layer.  The vendored image-spec predates the artifactType and subject
+// fields, which let registries that support the referrers API list the
+// artifact among the referrers of the image it describes.
+type ociArtifactManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Subject       *ociDescriptor    `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+const (
+	// ociManifestMediaType is the media type of OCI image manifests.
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// ociIndexMediaType is the media type of OCI image indexes.
+	ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+	// ociConfigMediaType is the media type of OCI image configurations,
+	// which the configuration of artifacts also uses so that older
+	// registries accept them.
+	ociConfigMediaType = "application/vnd.oci.image.config.v1+json"
+)
+
+// ociEmptyConfig is the configuration blob of artifact manifests.
+var ociEmptyConfig = []byte("{}")
+
+// pushDaemonlessArtifact pushes artifact to the repository of imageName as the
+// only layer of an OCI manifest tagged tag, whose subject is the image with
+// the given digest in that repository.  The digest of the manifest is
+// returned.
+func pushDaemonlessArtifact(sc types.SystemContext, imageName, subjectDigest, tag string, artifact Artifact, authConfig docker.AuthConfiguration) (string, error) {
+	named, err := ireference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", imageName, err)
+	}
+	repository := ireference.TrimNamed(named)
+	subjectNamed, err := ireference.ParseNormalizedNamed(repository.String() + "@" + subjectDigest)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest %q: %v", subjectDigest, err)
+	}
+	tagged, err := ireference.WithTag(repository, tag)
+	if err != nil {
+		return "", err
+	}
+	log.V(2).Infof("Pushing %s artifact %s.", artifact.MediaType, tagged.String())
+
+	systemContext := registrySystemContext(sc, imageName)
+	systemContext.AuthFilePath = authFilePath
+	if authConfig.Username != "" && authConfig.Password != "" {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: authConfig.Username,
+			Password: authConfig.Password,
+		}
+	}
+
+	ctx := BuildContext()
+	subjectRef, err := idocker.NewReference(subjectNamed)
+	if err != nil {
+		return "", err
+	}
+	src, err := subjectRef.NewImageSource(ctx, &systemContext)
+	if err != nil {
+		return "", err
+	}
+	subjectBytes, subjectType, err := src.GetManifest(ctx, nil)
+	src.Close()
+	if err != nil {
+		return "", fmt.Errorf("unable to read the manifest of %s: %v", subjectNamed.String(), err)
+	}
+
+	destRef, err := idocker.NewReference(tagged)
+	if err != nil {
+		return "", err
+	}
+	dest, err := destRef.NewImageDestination(ctx, &systemContext)
+	if err != nil {
+		return "", err
+	}
+	defer dest.Close()
+	config, err := dest.PutBlob(ctx, bytes.NewReader(ociEmptyConfig), types.BlobInfo{Size: int64(len(ociEmptyConfig))}, none.NoCache, true)
+	if err != nil {
+		return "", err
+	}
+	layer, err := dest.PutBlob(ctx, bytes.NewReader(artifact.Content), types.BlobInfo{Size: int64(len(artifact.Content))}, none.NoCache, false)
+	if err != nil {
+		return "", err
+	}
+	manifestBytes, err := json.Marshal(ociArtifactManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		ArtifactType:  artifact.MediaType,
+		Config: ociDescriptor{
+			MediaType: ociConfigMediaType,
+			Digest:    config.Digest.String(),
+			Size:      config.Size,
+		},
+		Layers: []ociDescriptor{{
+			MediaType:   artifact.MediaType,
+			Digest:      layer.Digest.String(),
+			Size:        layer.Size,
+			Annotations: artifact.LayerAnnotations,
+		}},
+		Subject: &ociDescriptor{
+			MediaType: subjectType,
+			Digest:    subjectDigest,
+			Size:      int64(len(subjectBytes)),
+		},
+		Annotations: artifact.Annotations,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := dest.PutManifest(ctx, manifestBytes, nil); err != nil {
+		return "", err
+	}
+	if err := dest.Commit(ctx, nil); err != nil {
+		return "", err
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", err
+	}
+	return string(manifestDigest), nil
 }
 
 // DaemonlessClient is a daemonless DockerClient-like implementation.
@@ -421,23 +1557,18 @@ type DaemonlessClient struct {
 	Isolation               buildah.Isolation
 	BlobCacheDirectory      string
 	ImageOptimizationPolicy buildapiv1.ImageOptimizationPolicy
-	builders                map[string]*buildah.Builder
+	// Squash squashes the layers of built images, including those of
+	// their base images, into one
+	Squash   bool
+	builders map[string]*buildah.Builder
 }
 
 // GetDaemonlessClient returns a valid implemenatation of the DockerClient
 // interface, or an error if the implementation couldn't be created.
-func GetDaemonlessClient(systemContext types.SystemContext, store storage.Store, isolationSpec, blobCacheDirectory string, imageOptimizationPolicy buildapiv1.ImageOptimizationPolicy) (client DockerClient, err error) {
-	isolation := buildah.IsolationDefault
-	switch strings.ToLower(isolationSpec) {
-	case "chroot":
-		isolation = buildah.IsolationChroot
-	case "oci":
-		isolation = buildah.IsolationOCI
-	case "rootless":
-		isolation = buildah.IsolationOCIRootless
-	case "":
-	default:
-		return nil, fmt.Errorf("unrecognized BUILD_ISOLATION setting %q", strings.ToLower(isolationSpec))
+func GetDaemonlessClient(systemContext types.SystemContext, store storage.Store, isolationSpec, blobCacheDirectory string, imageOptimizationPolicy buildapiv1.ImageOptimizationPolicy, squash bool) (client DockerClient, err error) {
+	isolation, err := getIsolation(isolationSpec)
+	if err != nil {
+		return nil, err
 	}
 
 	if blobCacheDirectory != "" {
@@ -450,12 +1581,17 @@ func GetDaemonlessClient(systemContext types.SystemContext, store storage.Store,
 		Isolation:               isolation,
 		BlobCacheDirectory:      blobCacheDirectory,
 		ImageOptimizationPolicy: imageOptimizationPolicy,
+		Squash:                  squash,
 		builders:                make(map[string]*buildah.Builder),
 	}, nil
 }
 
 func (d *DaemonlessClient) BuildImage(opts docker.BuildImageOptions) error {
-	return buildDaemonlessImage(d.SystemContext, d.Store, d.Isolation, opts.ContextDir, d.ImageOptimizationPolicy, &opts, d.BlobCacheDirectory)
+	return buildDaemonlessImage(d.SystemContext, d.Store, d.Isolation, opts.ContextDir, d.ImageOptimizationPolicy, d.Squash, &opts, nil, d.BlobCacheDirectory)
+}
+
+func (d *DaemonlessClient) BuildImageWithMounts(opts docker.BuildImageOptions, mounts []BuildMount) error {
+	return buildDaemonlessImage(d.SystemContext, d.Store, d.Isolation, opts.ContextDir, d.ImageOptimizationPolicy, d.Squash, &opts, mounts, d.BlobCacheDirectory)
 }
 
 func (d *DaemonlessClient) PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) (string, error) {
@@ -466,6 +1602,38 @@ func (d *DaemonlessClient) PushImage(opts docker.PushImageOptions, auth docker.A
 	return pushDaemonlessImage(d.SystemContext, d.Store, imageName, auth, d.BlobCacheDirectory)
 }
 
+func (d *DaemonlessClient) PushManifestList(name string, instances []ManifestListInstance, auth docker.AuthConfiguration) (string, error) {
+	return pushDaemonlessManifestList(d.SystemContext, name, instances, auth)
+}
+
+func (d *DaemonlessClient) PushLayerCache(name, cacheRef string, auth docker.AuthConfiguration) error {
+	return pushDaemonlessLayerCache(d.SystemContext, d.Store, name, cacheRef, auth, d.BlobCacheDirectory)
+}
+
+func (d *DaemonlessClient) PushArtifact(name, subjectDigest, tag string, artifact Artifact, auth docker.AuthConfiguration) (string, error) {
+	return pushDaemonlessArtifact(d.SystemContext, name, subjectDigest, tag, artifact, auth)
+}
+
+func (d *DaemonlessClient) MountImage(name string) (string, func(), error) {
+	return mountDaemonlessImage(d.SystemContext, d.Store, name)
+}
+
+func (d *DaemonlessClient) ImageSize(name string, compressed bool) (int64, int64, error) {
+	return daemonlessImageSize(d.SystemContext, d.Store, name, compressed)
+}
+
+func (d *DaemonlessClient) ImageDiffIDs(name string, remote bool, auth docker.AuthConfiguration) ([]string, error) {
+	return daemonlessImageDiffIDs(d.SystemContext, d.Store, name, remote, auth)
+}
+
+func (d *DaemonlessClient) ReadImageLayer(name string, remote bool, auth docker.AuthConfiguration, index int, read func(io.Reader) error) error {
+	return readDaemonlessImageLayer(d.SystemContext, d.Store, name, remote, auth, index, read)
+}
+
+func (d *DaemonlessClient) NormalizeImage(name string, created time.Time) error {
+	return normalizeDaemonlessImage(d.SystemContext, d.Store, name, created)
+}
+
 func (d *DaemonlessClient) RemoveImage(name string) error {
 	return removeDaemonlessImage(d.SystemContext, d.Store, name)
 }
@@ -491,6 +1659,14 @@ func (d *DaemonlessClient) CreateContainer(opts docker.CreateContainerOptions) (
 	return &docker.Container{ID: builder.ContainerID}, nil
 }
 
+func (d *DaemonlessClient) RunContainer(createOpts docker.CreateContainerOptions, attachOpts docker.AttachToContainerOptions) error {
+	ctx := createOpts.Context
+	if ctx == nil {
+		ctx = BuildContext()
+	}
+	return daemonlessRun(ctx, d.Store, d.Isolation, createOpts, attachOpts, d.BlobCacheDirectory)
+}
+
 func (d *DaemonlessClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
 	builder, ok := d.builders[opts.ID]
 	if !ok {
@@ -514,6 +1690,10 @@ func (d *DaemonlessClient) RemoveContainer(opts docker.RemoveContainerOptions) e
 	return err
 }
 
+func (d *DaemonlessClient) ResolvePlatformImage(name, platform string, searchPaths []string) (string, error) {
+	return resolvePlatformImage(d.SystemContext, name, platform, searchPaths)
+}
+
 func (d *DaemonlessClient) PullImage(opts docker.PullImageOptions, searchPaths []string) error {
 	imageName := opts.Repository
 	if opts.Tag != "" {
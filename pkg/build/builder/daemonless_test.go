@@ -1,9 +1,26 @@
 package builder
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/containers/buildah"
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	docker "github.com/fsouza/go-dockerclient"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 )
@@ -30,3 +47,260 @@ func TestParseDropCapabilities(t *testing.T) {
 		os.Unsetenv(builderutil.DropCapabilities)
 	}
 }
+
+func TestManifestListDescriptor(t *testing.T) {
+	named, err := ireference.ParseNormalizedNamed("registry.example.com/ns/app:latest")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	instance := ManifestListInstance{
+		Platform: "linux/arm64/v8",
+		Digest:   "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+	}
+	ref, err := instanceReference(named, instance)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ref.String() != "registry.example.com/ns/app@"+instance.Digest {
+		t.Errorf("unexpected reference %s", ref.String())
+	}
+	descriptor, err := manifestListDescriptor(instance, ref, manifest.DockerV2Schema2MediaType, 1234)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if descriptor.Digest.String() != instance.Digest || descriptor.Size != 1234 || descriptor.MediaType != manifest.DockerV2Schema2MediaType {
+		t.Errorf("unexpected descriptor %#v", descriptor.Schema2Descriptor)
+	}
+	if descriptor.Platform.OS != "linux" || descriptor.Platform.Architecture != "arm64" || descriptor.Platform.Variant != "v8" {
+		t.Errorf("unexpected platform %#v", descriptor.Platform)
+	}
+
+	if _, err := instanceReference(named, ManifestListInstance{Platform: "linux/amd64", Digest: "invalid"}); err == nil {
+		t.Errorf("expected an error for an invalid digest")
+	}
+}
+
+func TestOCIIndexFromComponents(t *testing.T) {
+	named, err := ireference.ParseNormalizedNamed("registry.example.com/ns/app:latest")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	descriptors := []manifest.Schema2ManifestDescriptor{}
+	for _, instance := range []ManifestListInstance{
+		{Platform: "linux/amd64", Digest: "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"},
+		{Platform: "linux/arm64/v8", Digest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+	} {
+		ref, err := instanceReference(named, instance)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		descriptor, err := manifestListDescriptor(instance, ref, ociManifestMediaType, 1234)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	data, err := json.Marshal(ociIndexFromComponents(descriptors))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b","size":1234,"platform":{"architecture":"amd64","os":"linux"}},` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef","size":1234,"platform":{"architecture":"arm64","os":"linux","variant":"v8"}}]}`
+	if string(data) != expected {
+		t.Errorf("expected index %s, got %s", expected, string(data))
+	}
+	if mimeType := manifest.GuessMIMEType(data); mimeType != ociIndexMediaType {
+		t.Errorf("expected the index to be recognized as %s, got %s", ociIndexMediaType, mimeType)
+	}
+}
+
+// fakeRegistry is a minimal registry which serves one manifest and records
+// the blobs and manifests pushed to it.
+type fakeRegistry struct {
+	manifest     []byte
+	manifestType string
+	blobs        map[string][]byte
+	manifests    map[string][]byte
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/manifests/") && req.Method == http.MethodGet:
+		w.Header().Set("Content-Type", r.manifestType)
+		w.Write(r.manifest)
+	case strings.Contains(path, "/manifests/") && req.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(req.Body)
+		r.manifests[path[strings.LastIndex(path, "/")+1:]] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasSuffix(path, "/blobs/uploads/") && req.Method == http.MethodPost:
+		w.Header().Set("Location", "/v2/upload")
+		w.WriteHeader(http.StatusAccepted)
+	case path == "/v2/upload" && req.Method == http.MethodPatch:
+		body, _ := ioutil.ReadAll(req.Body)
+		r.blobs["pending"] = body
+		w.Header().Set("Location", "/v2/upload")
+		w.WriteHeader(http.StatusAccepted)
+	case path == "/v2/upload" && req.Method == http.MethodPut:
+		r.blobs[req.URL.Query().Get("digest")] = r.blobs["pending"]
+		delete(r.blobs, "pending")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPushDaemonlessArtifact(t *testing.T) {
+	registry := &fakeRegistry{
+		manifest:     []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`),
+		manifestType: manifest.DockerV2Schema2MediaType,
+		blobs:        map[string][]byte{},
+		manifests:    map[string][]byte{},
+	}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	subjectDigest, err := manifest.Digest(registry.manifest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	artifact := Artifact{
+		MediaType:        "application/spdx+json",
+		Content:          []byte(`{"spdxVersion":"SPDX-2.3"}`),
+		Annotations:      map[string]string{"key": "value"},
+		LayerAnnotations: map[string]string{"layer": "value"},
+	}
+	tag := artifactTag(subjectDigest.String(), "sbom")
+	sc := types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	manifestDigest, err := pushDaemonlessArtifact(sc, host+"/ns/app:latest", subjectDigest.String(), tag, artifact, docker.AuthConfiguration{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pushed, ok := registry.manifests[tag]
+	if !ok {
+		t.Fatalf("expected a manifest to be pushed as %s, got %v", tag, registry.manifests)
+	}
+	if actual, _ := manifest.Digest(pushed); actual.String() != manifestDigest {
+		t.Errorf("expected digest %s, got %s", actual, manifestDigest)
+	}
+	if mimeType := manifest.GuessMIMEType(pushed); mimeType != ociManifestMediaType {
+		t.Errorf("expected the artifact to be an OCI manifest, got %q", mimeType)
+	}
+	var m ociArtifactManifest
+	if err := json.Unmarshal(pushed, &m); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m.Subject == nil || m.Subject.Digest != subjectDigest.String() || m.Subject.MediaType != manifest.DockerV2Schema2MediaType || m.Subject.Size != int64(len(registry.manifest)) {
+		t.Errorf("unexpected subject %#v", m.Subject)
+	}
+	if len(m.Layers) != 1 || m.Layers[0].MediaType != artifact.MediaType || string(registry.blobs[m.Layers[0].Digest]) != string(artifact.Content) || m.Layers[0].Annotations["layer"] != "value" {
+		t.Errorf("unexpected layers %#v", m.Layers)
+	}
+	if string(registry.blobs[m.Config.Digest]) != "{}" || m.ArtifactType != artifact.MediaType || m.Annotations["key"] != "value" {
+		t.Errorf("unexpected manifest %s", string(pushed))
+	}
+}
+
+// countingDestination is an image destination which records the largest
+// number of blobs put at once.
+type countingDestination struct {
+	types.ImageDestination
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (d *countingDestination) HasThreadSafePutBlob() bool {
+	return true
+}
+
+func (d *countingDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	d.mu.Lock()
+	d.current++
+	if d.current > d.max {
+		d.max = d.current
+	}
+	d.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	d.mu.Lock()
+	d.current--
+	d.mu.Unlock()
+	return inputInfo, nil
+}
+
+func TestLimitedDestination(t *testing.T) {
+	for _, limit := range []int{1, 2} {
+		counting := &countingDestination{}
+		dest := &limitedDestination{ImageDestination: counting, slots: make(chan struct{}, limit)}
+		if dest.HasThreadSafePutBlob() != (limit > 1) {
+			t.Errorf("limit %d: unexpected HasThreadSafePutBlob %v", limit, dest.HasThreadSafePutBlob())
+		}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dest.PutBlob(context.Background(), nil, types.BlobInfo{}, nil, false)
+			}()
+		}
+		wg.Wait()
+		if counting.max != limit {
+			t.Errorf("limit %d: expected at most %d blobs at once, got %d", limit, limit, counting.max)
+		}
+	}
+}
+
+func TestLimitPushConcurrency(t *testing.T) {
+	defer func(limit int, registries map[string]int) {
+		DefaultPushConcurrency, registryPushConcurrency = limit, registries
+	}(DefaultPushConcurrency, registryPushConcurrency)
+	DefaultPushConcurrency, registryPushConcurrency = 0, map[string]int{"quay.io": 2}
+
+	ref, err := alltransports.ParseImageName("docker://registry.example.com/ns/app:latest")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if limited := limitPushConcurrency(ref); limited != ref {
+		t.Errorf("expected pushes to be unlimited, got %#v", limited)
+	}
+	ref, err = alltransports.ParseImageName("docker://quay.io/ns/app:latest")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if limited, ok := limitPushConcurrency(ref).(*limitedReference); !ok || limited.limit != 2 || limited.DockerReference().String() != "quay.io/ns/app:latest" {
+		t.Errorf("expected pushes to quay.io to be limited to 2, got %#v", limited)
+	}
+}
+
+func TestDaemonlessNetwork(t *testing.T) {
+	defer func(hermetic bool) {
+		HermeticBuild = hermetic
+	}(HermeticBuild)
+
+	HermeticBuild = false
+	namespaces, policy := daemonlessNetwork()
+	if ns := namespaces.Find(string(specs.NetworkNamespace)); ns == nil || !ns.Host || policy != buildah.NetworkDefault {
+		t.Errorf("expected the host network, got %#v, %v", ns, policy)
+	}
+	if err := hermeticBuildError(errors.New("exit status 1")); err.Error() != "exit status 1" {
+		t.Errorf("expected the error to be unchanged, got %v", err)
+	}
+
+	HermeticBuild = true
+	namespaces, policy = daemonlessNetwork()
+	if ns := namespaces.Find(string(specs.NetworkNamespace)); ns == nil || ns.Host || policy != buildah.NetworkDisabled {
+		t.Errorf("expected a private network with networking disabled, got %#v, %v", ns, policy)
+	}
+	if err := hermeticBuildError(errors.New("exit status 1")); !strings.Contains(err.Error(), "BUILD_HERMETIC") {
+		t.Errorf("expected the error to explain that networking is disabled, got %v", err)
+	}
+	if err := hermeticBuildError(nil); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
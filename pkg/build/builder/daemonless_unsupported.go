@@ -33,6 +33,9 @@ func (d *DaemonlessClient) RemoveImage(name string) error {
 func (d *DaemonlessClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
 	return nil, errors.New("creating containers not supported on this platform")
 }
+func (d *DaemonlessClient) RunContainer(createOpts docker.CreateContainerOptions, attachOpts docker.AttachToContainerOptions) error {
+	return errors.New("running containers not supported on this platform")
+}
 func (d *DaemonlessClient) PullImage(opts docker.PullImageOptions, searchPaths []string) error {
 	return errors.New("pulling images not supported on this platform")
 }
@@ -53,6 +56,6 @@ func buildDaemonlessImage(sc types.SystemContext, store storage.Store, isolation
 }
 
 // GetDaemonlessClient returns an error.
-func GetDaemonlessClient(systemContext types.SystemContext, store storage.Store, isolationSpec, blobCacheDirectory string, imageOptimizationPolicy buildapiv1.ImageOptimizationPolicy) (client DockerClient, err error) {
+func GetDaemonlessClient(systemContext types.SystemContext, store storage.Store, isolationSpec, blobCacheDirectory string, imageOptimizationPolicy buildapiv1.ImageOptimizationPolicy, squash bool) (client DockerClient, err error) {
 	return nil, errors.New("building images without an engine not supported on this platform")
 }
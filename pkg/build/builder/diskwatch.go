@@ -0,0 +1,207 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// StatusReasonOutOfDiskSpace is the reason a build fails when it is stopped
+// because the free space of a volume it writes to fell below the minimum it
+// sets.
+const StatusReasonOutOfDiskSpace buildapiv1.StatusReason = "OutOfDiskSpace"
+
+// diskWatchObserver is the name under which the disk watchdog observes the
+// stages of the build.
+const diskWatchObserver = "disk-watchdog"
+
+// diskWatchInterval is how often the watchdog checks the free space of the
+// volumes between the stages of the build.
+var diskWatchInterval = 5 * time.Second
+
+// getDiskSpace returns the device, and the available and total bytes, of
+// the filesystem holding a path.
+var getDiskSpace = diskSpace
+
+// watchedVolume is a volume which the build writes to, named for what it
+// holds.
+type watchedVolume struct {
+	name string
+	path string
+}
+
+// diskWatch holds the volumes which the watchdog watches.
+var diskWatch = struct {
+	sync.Mutex
+	volumes     []watchedVolume
+	storageRoot string
+	minFree     uint64
+	stage       buildapiv1.StageName
+	stop        chan struct{}
+	// exhausted describes the volume whose free space fell below minFree,
+	// if one did
+	exhausted string
+}{}
+
+// ConfigureDiskWatchdog watches the free space of the volumes holding the
+// container storage, at storageRoot, and the build's context, logging it as
+// the build enters each of its stages.  If the build sets a minimum with
+// BUILD_DISK_MIN_FREE, the build is stopped, and fails saying which volume
+// is short of how much space, once the free space of either falls below it,
+// rather than failing with ENOSPC part way through writing a layer.
+func ConfigureDiskWatchdog(build *buildapiv1.Build, storageRoot string) error {
+	var minFree uint64
+	if value, _ := buildStrategyEnv(build, builderutil.DiskMinFree); len(strings.TrimSpace(value)) > 0 {
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil || quantity.Sign() < 0 {
+			return fmt.Errorf("invalid %s value %q: must be a quantity such as 1Gi", builderutil.DiskMinFree, value)
+		}
+		minFree = uint64(quantity.Value())
+	}
+
+	// volumes on the same filesystem are watched once
+	var volumes []watchedVolume
+	devices := map[uint64]int{}
+	for _, volume := range []watchedVolume{{"container storage", storageRoot}, {"build context", buildWorkDirMount}} {
+		if len(volume.path) == 0 {
+			continue
+		}
+		device, _, _, err := getDiskSpace(volume.path)
+		if err != nil {
+			log.V(4).Infof("Unable to watch the free space of the %s at %s: %v", volume.name, volume.path, err)
+			continue
+		}
+		if i, ok := devices[device]; ok {
+			volumes[i].name += " and " + volume.name
+			continue
+		}
+		devices[device] = len(volumes)
+		volumes = append(volumes, volume)
+	}
+
+	diskWatch.Lock()
+	defer diskWatch.Unlock()
+	diskWatch.volumes, diskWatch.storageRoot, diskWatch.minFree = volumes, storageRoot, minFree
+	if len(volumes) == 0 {
+		timing.ObserveStages(diskWatchObserver, nil)
+		return nil
+	}
+	timing.ObserveStages(diskWatchObserver, observeDiskSpace)
+	if minFree > 0 && diskWatch.stop == nil {
+		diskWatch.stop = make(chan struct{})
+		go watchDiskSpace(diskWatch.stop)
+	}
+	return nil
+}
+
+// observeDiskSpace logs the free space of the volumes as the build enters
+// stage, and checks it against the minimum.
+func observeDiskSpace(stage buildapiv1.StageName) {
+	diskWatch.Lock()
+	diskWatch.stage = stage
+	diskWatch.Unlock()
+	checkDiskSpace(true)
+}
+
+// watchDiskSpace checks the free space of the volumes against the minimum
+// until stop is closed or it falls below it.
+func watchDiskSpace(stop <-chan struct{}) {
+	ticker := time.NewTicker(diskWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !checkDiskSpace(false) {
+				return
+			}
+		}
+	}
+}
+
+// checkDiskSpace checks the free space of the volumes against the minimum,
+// logging it if logUsage is set, and stops the build if it has fallen below
+// the minimum.  It returns false if it has.
+func checkDiskSpace(logUsage bool) bool {
+	diskWatch.Lock()
+	volumes, minFree, stage := diskWatch.volumes, diskWatch.minFree, diskWatch.stage
+	diskWatch.Unlock()
+	for _, volume := range volumes {
+		_, free, total, err := getDiskSpace(volume.path)
+		if err != nil {
+			log.V(4).Infof("Unable to check the free space of the %s at %s: %v", volume.name, volume.path, err)
+			continue
+		}
+		if logUsage {
+			log.V(2).Infof("Disk space at stage %s: %s free of %s on the %s at %s", stage, formatDiskSpace(free), formatDiskSpace(total), volume.name, volume.path)
+		}
+		if free >= minFree {
+			continue
+		}
+		exhausted := fmt.Sprintf("needed %s free on the %s at %s, have %s at stage %s", formatDiskSpace(minFree), volume.name, volume.path, formatDiskSpace(free), stage)
+		diskWatch.Lock()
+		diskWatch.exhausted = exhausted
+		diskWatch.Unlock()
+		log.V(0).Infof("error: The build is running out of disk space: %s. Free up space on the volume, give the build a larger one, or lower %s.", exhausted, builderutil.DiskMinFree)
+		CancelBuild("it is running out of disk space")
+		return false
+	}
+	return true
+}
+
+// requireDiskSpace returns an error if the container storage has less than
+// needed bytes free, on top of the minimum which the build keeps free, to
+// carry out action.
+func requireDiskSpace(needed uint64, action string) error {
+	diskWatch.Lock()
+	storageRoot, minFree, stage := diskWatch.storageRoot, diskWatch.minFree, diskWatch.stage
+	diskWatch.Unlock()
+	if len(storageRoot) == 0 || needed == 0 {
+		return nil
+	}
+	_, free, _, err := getDiskSpace(storageRoot)
+	if err != nil || free >= needed+minFree {
+		return nil
+	}
+	return fmt.Errorf("not enough disk space to %s: needed %s free on the container storage at %s, have %s at stage %s", action, formatDiskSpace(needed+minFree), storageRoot, formatDiskSpace(free), stage)
+}
+
+// stopDiskWatchdog stops checking the free space of the volumes.
+func stopDiskWatchdog() {
+	diskWatch.Lock()
+	defer diskWatch.Unlock()
+	if diskWatch.stop != nil {
+		close(diskWatch.stop)
+		diskWatch.stop = nil
+	}
+}
+
+// diskSpaceExhausted describes the volume whose free space fell below the
+// minimum, stopping the build, if one did.
+func diskSpaceExhausted() string {
+	diskWatch.Lock()
+	defer diskWatch.Unlock()
+	return diskWatch.exhausted
+}
+
+// formatDiskSpace formats bytes in the largest binary unit of which there is
+// at least one.
+func formatDiskSpace(bytes uint64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	value, unit := float64(bytes)/1024, 0
+	for value >= 1024 && unit < 4 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, "KMGTP"[unit])
+}
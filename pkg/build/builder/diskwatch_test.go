@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// fakeDisks replaces the free space of the filesystems holding paths for a
+// test, returning a function which sets it.
+func fakeDisks(devices map[string]uint64) func(path string, free uint64) {
+	var lock sync.Mutex
+	free := map[string]uint64{}
+	getDiskSpace = func(path string) (uint64, uint64, uint64, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		device, ok := devices[path]
+		if !ok {
+			return 0, 0, 0, fmt.Errorf("no such path %s", path)
+		}
+		return device, free[path], 100 << 30, nil
+	}
+	return func(path string, bytes uint64) {
+		lock.Lock()
+		defer lock.Unlock()
+		free[path] = bytes
+	}
+}
+
+// resetDiskWatchdog undoes the disk watchdog configured by a test.
+func resetDiskWatchdog() {
+	stopDiskWatchdog()
+	timing.ObserveStages(diskWatchObserver, nil)
+	getDiskSpace = diskSpace
+	diskWatch.Lock()
+	defer diskWatch.Unlock()
+	diskWatch.volumes, diskWatch.storageRoot, diskWatch.minFree, diskWatch.stage, diskWatch.exhausted = nil, "", 0, "", ""
+}
+
+func TestConfigureDiskWatchdog(t *testing.T) {
+	defer resetDiskWatchdog()
+	fakeDisks(map[string]uint64{"/var/lib/containers": 1, buildWorkDirMount: 1, "/other": 2})
+
+	if err := ConfigureDiskWatchdog(testBuildWithEnv(), "/var/lib/containers"); err != nil {
+		t.Fatal(err)
+	}
+	if len(diskWatch.volumes) != 1 || diskWatch.volumes[0].name != "container storage and build context" || diskWatch.minFree != 0 || diskWatch.stop != nil {
+		t.Errorf("expected the volumes on one filesystem to be watched once without a minimum, got %#v", diskWatch.volumes)
+	}
+
+	if err := ConfigureDiskWatchdog(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_DISK_MIN_FREE", Value: " 2Gi "}), "/other"); err != nil {
+		t.Fatal(err)
+	}
+	if len(diskWatch.volumes) != 2 || diskWatch.minFree != 2<<30 || diskWatch.stop == nil {
+		t.Errorf("expected both volumes to be watched for 2Gi, got %#v with %d", diskWatch.volumes, diskWatch.minFree)
+	}
+
+	for _, value := range []string{"lots", "-1Gi"} {
+		if err := ConfigureDiskWatchdog(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_DISK_MIN_FREE", Value: value}), "/other"); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestDiskWatchdog(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+	defer resetDiskWatchdog()
+	defer utillog.SetStage("")
+	defer func(interval time.Duration) { diskWatchInterval = interval }(diskWatchInterval)
+	diskWatchInterval = 10 * time.Millisecond
+
+	setFree := fakeDisks(map[string]uint64{"/var/lib/containers": 1, buildWorkDirMount: 2})
+	setFree("/var/lib/containers", 10<<30)
+	setFree(buildWorkDirMount, 10<<30)
+	if err := ConfigureDiskWatchdog(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_DISK_MIN_FREE", Value: "1Gi"}), "/var/lib/containers"); err != nil {
+		t.Fatal(err)
+	}
+
+	timing.SetStage(buildapiv1.StagePullImages)
+	if err := requireDiskSpace(8<<30, "pull base:latest"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := requireDiskSpace(12<<30, "pull base:latest")
+	if expect := "not enough disk space to pull base:latest: needed 13.0GiB free on the container storage at /var/lib/containers, have 10.0GiB at stage PullImages"; err == nil || err.Error() != expect {
+		t.Errorf("expected %q, got %v", expect, err)
+	}
+	if code := classifyFailure(err); code != FailureDiskFull {
+		t.Errorf("expected the error to be classified as %s, got %s", FailureDiskFull, code)
+	}
+
+	timing.SetStage(buildapiv1.StageBuild)
+	select {
+	case <-BuildContext().Done():
+		t.Fatalf("expected the build not to be stopped while it has enough space")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	setFree(buildWorkDirMount, 512<<20)
+	select {
+	case <-BuildContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the build to be stopped when the build context ran low on space")
+	}
+	build := &buildapiv1.Build{}
+	if !SetCancelledStatus(build) || build.Status.Phase != buildapiv1.BuildPhaseFailed || build.Status.Reason != StatusReasonOutOfDiskSpace ||
+		build.Status.Message != "The build was stopped before it ran out of disk space. needed 1.0GiB free on the build context at /tmp/build, have 512.0MiB at stage Build" {
+		t.Errorf("unexpected status %#v", build.Status)
+	}
+	if code := BuildFailureCode(build); code != FailureDiskFull {
+		t.Errorf("expected failure code %s, got %s", FailureDiskFull, code)
+	}
+}
+
+func TestFormatDiskSpace(t *testing.T) {
+	tests := map[uint64]string{
+		0:               "0B",
+		1023:            "1023B",
+		1536:            "1.5KiB",
+		5 << 20:         "5.0MiB",
+		3 << 29:         "1.5GiB",
+		2 << 40:         "2.0TiB",
+		uint64(3) << 50: "3.0PiB",
+		uint64(3) << 60: "3072.0PiB",
+	}
+	for bytes, expect := range tests {
+		if got := formatDiskSpace(bytes); got != expect {
+			t.Errorf("%d: expected %q, got %q", bytes, expect, got)
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
@@ -17,15 +20,24 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/attestation"
 	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	"github.com/openshift/builder/pkg/build/builder/metrics"
 	"github.com/openshift/builder/pkg/build/builder/timing"
 	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
 	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+	"github.com/openshift/library-go/pkg/git"
 )
 
-// defaultDockerfilePath is the default path of the Dockerfile
-const defaultDockerfilePath = "Dockerfile"
+const (
+	// defaultDockerfilePath is the default path of the Dockerfile
+	defaultDockerfilePath = "Dockerfile"
+	// defaultContainerfilePath is the path of the Dockerfile of a context
+	// which has a Containerfile but no Dockerfile, as podman and buildah
+	// name it
+	defaultContainerfilePath = "Containerfile"
+)
 
 // DockerBuilder builds Docker images given a git repository URL
 type DockerBuilder struct {
@@ -34,6 +46,9 @@ type DockerBuilder struct {
 	client       buildclientv1.BuildInterface
 	cgLimits     *s2iapi.CGroupLimits
 	inputDir     string
+	// caBundle mounts the trust store, with the build's CA certificates,
+	// into the RUN instructions of the build, if it has some
+	caBundle *BuildMount
 }
 
 // NewDockerBuilder creates a new instance of DockerBuilder
@@ -51,7 +66,7 @@ func NewDockerBuilder(dockerClient DockerClient, buildsClient buildclientv1.Buil
 func (d *DockerBuilder) Build() error {
 
 	var err error
-	ctx := timing.NewContext(context.Background())
+	ctx := timing.NewContext(BuildContext())
 	defer func() {
 		d.build.Status.Stages = timing.AppendStageAndStepInfo(d.build.Status.Stages, timing.GetStages(ctx))
 		HandleBuildStatusUpdate(d.build, d.client, nil)
@@ -61,6 +76,14 @@ func (d *DockerBuilder) Build() error {
 		d.build.Spec.Source.Dockerfile == nil && d.build.Spec.Source.Images == nil {
 		return fmt.Errorf("must provide a value for at least one of source, binary, images, or dockerfile")
 	}
+	// the build's CA certificates are trusted until its image is pushed
+	caBundle, removeCABundle, err := getCABundleMount(d.build)
+	if err != nil {
+		return err
+	}
+	defer removeCABundle()
+	d.caBundle = caBundle
+
 	var push bool
 	pushTag := d.build.Status.OutputDockerImageReference
 
@@ -79,13 +102,69 @@ func (d *DockerBuilder) Build() error {
 	buildTag := randomBuildTag(d.build.Namespace, d.build.Name)
 	dockerfilePath := getDockerfilePath(buildDir, d.build)
 
-	imageNames, err := findReferencedImages(dockerfilePath)
+	target, _ := buildStrategyEnv(d.build, builderutil.BuildTarget)
+	imageNames, err := findReferencedImages(dockerfilePath, strategyBuildArgs(d.build), target)
 	if err != nil {
 		return err
 	}
 	if len(imageNames) == 0 {
 		return fmt.Errorf("no FROM image in Dockerfile")
 	}
+	if err := checkDockerfileLint(d.build, dockerfilePath); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageDockerfileLintFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	if err := checkSkipLayers(d.build, dockerfilePath); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageSkipLayersUnsupported
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+
+	var outputs []string
+	if push {
+		if outputs, err = getAdditionalOutputs(d.build, pushTag); err != nil {
+			return err
+		}
+	}
+
+	platforms, err := getBuildPlatforms(d.build)
+	if err != nil {
+		return err
+	}
+	pin, err := getBaseImagePinning(d.build)
+	if err != nil {
+		return err
+	}
+	chain, err := getChainedStep(d.build, buildDir)
+	if err != nil {
+		return err
+	}
+	dryRun, err := getDryRun(d.build)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		plan, err := dockerBuildPlan(ctx, d.build, dockerfilePath, imageNames, platforms, chain, push, pushTag, outputs)
+		if err != nil {
+			return err
+		}
+		return printBuildPlan(os.Stdout, d.build, plan)
+	}
+	if len(platforms) > 0 {
+		if pin {
+			return fmt.Errorf("%s is not supported when building for multiple platforms", builderutil.PinBaseImages)
+		}
+		if chain != nil {
+			return fmt.Errorf("%s is not supported when building for multiple platforms", builderutil.ChainedDockerfile)
+		}
+		return d.buildPlatforms(ctx, buildDir, buildTag, pushTag, push, platforms, imageNames, outputs)
+	}
+
 	for _, imageName := range imageNames {
 		if imageName == "scratch" {
 			log.V(4).Infof("\nSkipping image \"scratch\"")
@@ -99,9 +178,11 @@ func (d *DockerBuilder) Build() error {
 			}
 			imageExists = false
 		}
-		// if forcePull or the image does not exist on the node we should pull the image first
-		if d.build.Spec.Strategy.DockerStrategy.ForcePull || !imageExists {
+		// if forcePull or the image does not exist on the node we should pull the image first,
+		// as we should when the image of another platform than the node's is wanted
+		if d.build.Spec.Strategy.DockerStrategy.ForcePull || !imageExists || len(pullPlatform) > 0 {
 			searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
+			timing.SetStage(buildapiv1.StagePullImages)
 			log.V(0).Infof("\nPulling image %s ...", imageName)
 			startTime := metav1.Now()
 			err = d.pullImage(imageName, searchPaths)
@@ -119,8 +200,36 @@ func (d *DockerBuilder) Build() error {
 		}
 	}
 
+	if pin {
+		digests, err := resolveBaseImageDigests(d.dockerClient, imageNames)
+		if err == nil {
+			err = pinBaseImages(dockerfilePath, digests, strategyBuildArgs(d.build), target)
+		}
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessagePinBaseImagesFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		imageNames = pinnedImageNames(imageNames, digests)
+	}
+
+	cacheFrom, cacheTo := getLayerCacheRefs(d.build)
+	for _, cacheRef := range cacheFrom {
+		d.importLayerCache(cacheRef)
+	}
+
+	// with a chained step, the image built from the Dockerfile is only the
+	// first of two
+	stepTag := buildTag
+	if chain != nil {
+		stepTag = randomBuildTag(d.build.Namespace, d.build.Name)
+	}
+
+	timing.SetStage(buildapiv1.StageBuild)
 	startTime := metav1.Now()
-	err = d.dockerBuild(ctx, buildDir, buildTag)
+	err = d.dockerBuild(ctx, buildDir, stepTag, "")
 
 	timing.RecordNewStep(ctx, buildapiv1.StageBuild, buildapiv1.StepDockerBuild, startTime, metav1.Now())
 
@@ -132,6 +241,76 @@ func (d *DockerBuilder) Build() error {
 		return err
 	}
 
+	if chain != nil {
+		startTime = metav1.Now()
+		err = buildChainedStep(ctx, d.dockerClient, d.build, chain, stepTag, buildTag, d.cgLimits)
+
+		timing.RecordNewStep(ctx, buildapiv1.StageBuild, buildapiv1.StepDockerBuild, startTime, metav1.Now())
+
+		if removeErr := removeImage(d.dockerClient, stepTag); removeErr != nil {
+			log.V(0).Infof("warning: Failed to remove the image of the first step %v: %v", stepTag, removeErr)
+		}
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageChainedBuildFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+	}
+
+	if err := runPostCommitHook(ctx, d.dockerClient, d.build, buildTag, d.cgLimits); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonPostCommitHookFailed
+		d.build.Status.Message = builderutil.StatusMessagePostCommitHookFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	if err := runTestStage(ctx, d.dockerClient, d.build, buildTag, d.cgLimits); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageTestStageFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	if err := checkImageSizeBudget(d.dockerClient, d.build, buildTag); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageImageSizeBudgetExceeded
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	if push {
+		reportLayerDiff(d.dockerClient, d.client, d.build, buildTag, pushTag)
+	}
+	if err := scanImage(d.dockerClient, d.build, buildTag); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageVulnerabilityScanFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	if err := extractImageArtifacts(d.dockerClient, d.build, buildTag, ""); err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageExtractArtifactsFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+
+	sbom, err := generateImageSBOM(d.dockerClient, d.build, buildTag)
+	if err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		d.build.Status.Message = builderutil.StatusMessageGenerateSBOMFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+
+	if len(cacheTo) > 0 {
+		d.exportLayerCache(buildTag, cacheTo)
+	}
+
 	if push {
 		if err := tagImage(d.dockerClient, buildTag, pushTag); err != nil {
 			return err
@@ -151,6 +330,27 @@ func (d *DockerBuilder) Build() error {
 		if authPresent {
 			log.V(4).Infof("Authenticating Docker push with user %q", pushAuthConfig.Username)
 		}
+		signer, err := getProvenanceSigner(d.build)
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageSignProvenanceFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		signing, err := getImageSigning(d.build)
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageSignImageFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		if err := RunBuildHook(ctx, d.dockerClient, d.build, d.cgLimits, HookPrePush, "OUTPUT_IMAGE="+pushTag); err != nil {
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		timing.SetStage(buildapiv1.StagePushImage)
 		log.V(0).Infof("\nPushing image %s ...", pushTag)
 		startTime = metav1.Now()
 		digest, err := d.pushImage(pushTag, pushAuthConfig)
@@ -171,24 +371,404 @@ func (d *DockerBuilder) Build() error {
 			}
 			HandleBuildStatusUpdate(d.build, d.client, nil)
 		}
+
+		if len(outputs) > 0 {
+			startTime = metav1.Now()
+			_, err := pushAdditionalOutputs(d.dockerClient, pushTag, outputs, d.pushImage)
+
+			timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushDockerImage, startTime, metav1.Now())
+
+			if err != nil {
+				d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(d.build, d.client, nil)
+				return err
+			}
+		}
 		log.V(0).Infof("Push successful")
+
+		if sbom != nil && len(digest) > 0 {
+			if err := attachImageSBOM(d.dockerClient, pushTag, digest, sbom, pushAuthConfig); err != nil {
+				d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(d.build, d.client, nil)
+				return err
+			}
+		}
+		if signer != nil && len(digest) > 0 {
+			if err := attachImageProvenance(d.dockerClient, signer, d.build, pushTag, digest, baseImageNames(imageNames), pushAuthConfig); err != nil {
+				d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(d.build, d.client, nil)
+				return err
+			}
+		}
+		if signing != nil && len(digest) > 0 {
+			if err := signImage(d.dockerClient, signing, pushTag, digest, pushAuthConfig); err != nil {
+				d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(d.build, d.client, nil)
+				return err
+			}
+		}
+		if err := RunBuildHook(ctx, d.dockerClient, d.build, d.cgLimits, HookPostPush, "OUTPUT_IMAGE="+pushTag, "OUTPUT_IMAGE_DIGEST="+digest); err != nil {
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
 	}
 	return nil
 }
 
-func (d *DockerBuilder) pullImage(name string, searchPaths []string) error {
-	repository, tag := docker.ParseRepositoryTag(name)
-	options := docker.PullImageOptions{
-		Repository: repository,
-		Tag:        tag,
+// buildPlatforms builds the image once for each of the given platforms and,
+// if push is set, pushes each image to pushTag and to each of outputs before
+// replacing the tags with a manifest list of all of them.  Base images are
+// pulled during each build so that the variant for the platform being built
+// is used.
+func (d *DockerBuilder) buildPlatforms(ctx context.Context, buildDir, buildTag, pushTag string, push bool, platforms, imageNames, outputs []string) error {
+	lister, ok := d.dockerClient.(manifestListPusher)
+	if !ok {
+		return fmt.Errorf("building images for multiple platforms is not supported by this build client")
+	}
+
+	var pushAuthConfig docker.AuthConfiguration
+	var authPresent bool
+	var signer attestation.Signer
+	var signing *imageSigning
+	push = push && pushTag != ""
+	if push {
+		pushAuthConfig, authPresent = dockercfg.NewHelper().GetDockerAuth(
+			pushTag,
+			dockercfg.PushAuthType,
+		)
+		if authPresent {
+			log.V(4).Infof("Authenticating Docker push with user %q", pushAuthConfig.Username)
+		}
+		var err error
+		if signer, err = getProvenanceSigner(d.build); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageSignProvenanceFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		if signing, err = getImageSigning(d.build); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageSignImageFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+		// the image for each platform is pushed as soon as it is built
+		if err := RunBuildHook(ctx, d.dockerClient, d.build, d.cgLimits, HookPrePush, "OUTPUT_IMAGE="+pushTag); err != nil {
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+	}
+
+	instances := []ManifestListInstance{}
+	for _, platform := range platforms {
+		platformTag := buildTag + "-" + strings.Replace(platform, "/", "-", -1)
+		timing.SetStage(buildapiv1.StageBuild)
+		log.V(0).Infof("\nBuilding for platform %s ...", platform)
+		startTime := metav1.Now()
+		err := d.dockerBuild(ctx, buildDir, platformTag, platform)
+
+		timing.RecordNewStep(ctx, buildapiv1.StageBuild, buildapiv1.StepDockerBuild, startTime, metav1.Now())
+
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageDockerBuildFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return fmt.Errorf("failed to build for platform %s: %v", platform, err)
+		}
+
+		if err := runPostCommitHook(ctx, d.dockerClient, d.build, platformTag, d.cgLimits); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonPostCommitHookFailed
+			d.build.Status.Message = builderutil.StatusMessagePostCommitHookFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return fmt.Errorf("failed to run the post commit hook for platform %s: %v", platform, err)
+		}
+		if err := runTestStage(ctx, d.dockerClient, d.build, platformTag, d.cgLimits); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageTestStageFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return fmt.Errorf("failed to run the test stage for platform %s: %v", platform, err)
+		}
+		if err := checkImageSizeBudget(d.dockerClient, d.build, platformTag); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageImageSizeBudgetExceeded
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return fmt.Errorf("the image for platform %s failed its size budget check: %v", platform, err)
+		}
+		if err := scanImage(d.dockerClient, d.build, platformTag); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageVulnerabilityScanFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return fmt.Errorf("the image for platform %s failed its vulnerability scan: %v", platform, err)
+		}
+		if err := extractImageArtifacts(d.dockerClient, d.build, platformTag, platform); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageExtractArtifactsFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+
+		sbom, err := generateImageSBOM(d.dockerClient, d.build, platformTag)
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			d.build.Status.Message = builderutil.StatusMessageGenerateSBOMFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+
+		if push {
+			if err := tagImage(d.dockerClient, platformTag, pushTag); err != nil {
+				return err
+			}
+			timing.SetStage(buildapiv1.StagePushImage)
+			log.V(0).Infof("\nPushing image %s for platform %s ...", pushTag, platform)
+			startTime = metav1.Now()
+			digest, err := d.pushImage(pushTag, pushAuthConfig)
+
+			timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushDockerImage, startTime, metav1.Now())
+
+			if err != nil {
+				d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(d.build, d.client, nil)
+				return reportPushFailure(err, authPresent, pushAuthConfig)
+			}
+			if sbom != nil && len(digest) > 0 {
+				if err := attachImageSBOM(d.dockerClient, pushTag, digest, sbom, pushAuthConfig); err != nil {
+					d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+					d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+					d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+					HandleBuildStatusUpdate(d.build, d.client, nil)
+					return err
+				}
+			}
+			if signer != nil && len(digest) > 0 {
+				if err := attachImageProvenance(d.dockerClient, signer, d.build, pushTag, digest, baseImageNames(imageNames), pushAuthConfig); err != nil {
+					d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+					d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+					d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+					HandleBuildStatusUpdate(d.build, d.client, nil)
+					return err
+				}
+			}
+			if len(outputs) > 0 {
+				startTime = metav1.Now()
+				_, err := pushAdditionalOutputs(d.dockerClient, platformTag, outputs, d.pushImage)
+
+				timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushDockerImage, startTime, metav1.Now())
+
+				if err != nil {
+					d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+					d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+					d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+					HandleBuildStatusUpdate(d.build, d.client, nil)
+					return err
+				}
+			}
+			instances = append(instances, ManifestListInstance{Platform: platform, Digest: digest})
+		}
+
+		if err := removeImage(d.dockerClient, platformTag); err != nil {
+			log.V(0).Infof("warning: Failed to remove temporary build tag %v: %v", platformTag, err)
+		}
 	}
 
-	if options.Tag == "" && strings.Contains(name, "@") {
-		options.Repository = name
+	if !push {
+		return nil
 	}
 
-	return retryImageAction("Pull", func() (pullErr error) {
-		return d.dockerClient.PullImage(options, searchPaths)
+	timing.SetStage(buildapiv1.StagePushImage)
+	log.V(0).Infof("\nPushing manifest list %s ...", pushTag)
+	startTime := metav1.Now()
+	var digest string
+	err := retryImageAction("Push", func() (pushErr error) {
+		digest, pushErr = lister.PushManifestList(pushTag, instances, pushAuthConfig)
+		return pushErr
+	})
+
+	timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushDockerImage, startTime, metav1.Now())
+
+	if err != nil {
+		d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+		d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return reportPushFailure(err, authPresent, pushAuthConfig)
+	}
+
+	for _, instance := range instances {
+		log.V(0).Infof("Platform %s: %s", instance.Platform, instance.Digest)
+	}
+	if len(digest) > 0 {
+		d.build.Status.Output.To = &buildapiv1.BuildStatusOutputTo{
+			ImageDigest: digest,
+		}
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+	}
+
+	// The platform images were pushed to each output, so the manifest
+	// list, which refers to them by digest, can be pushed there too.
+	for _, output := range outputs {
+		outputAuthConfig, outputAuthPresent := dockercfg.NewHelper().GetDockerAuth(output, dockercfg.PushAuthType)
+		log.V(0).Infof("\nPushing manifest list %s ...", output)
+		startTime = metav1.Now()
+		var outputDigest string
+		err := retryImageAction("Push", func() (pushErr error) {
+			outputDigest, pushErr = lister.PushManifestList(output, instances, outputAuthConfig)
+			return pushErr
+		})
+
+		timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushDockerImage, startTime, metav1.Now())
+
+		if err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+			d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return reportPushFailure(err, outputAuthPresent, outputAuthConfig)
+		}
+		if len(outputDigest) > 0 {
+			log.V(0).Infof("Pushed manifest list %s with digest %s", output, outputDigest)
+		}
+	}
+	log.V(0).Infof("Push successful")
+
+	if signing != nil && len(digest) > 0 {
+		if err := signImage(d.dockerClient, signing, pushTag, digest, pushAuthConfig); err != nil {
+			d.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			d.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+			d.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+			HandleBuildStatusUpdate(d.build, d.client, nil)
+			return err
+		}
+	}
+	if err := RunBuildHook(ctx, d.dockerClient, d.build, d.cgLimits, HookPostPush, "OUTPUT_IMAGE="+pushTag, "OUTPUT_IMAGE_DIGEST="+digest); err != nil {
+		HandleBuildStatusUpdate(d.build, d.client, nil)
+		return err
+	}
+	return nil
+}
+
+// getBuildPlatforms returns the platforms, in os/arch[/variant] form, that the
+// build strategy's environment requests images be built for.
+func getBuildPlatforms(build *buildapiv1.Build) ([]string, error) {
+	value, ok := buildStrategyEnv(build, builderutil.BuildPlatforms)
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	platforms := []string{}
+	seen := map[string]bool{}
+	for _, platform := range strings.Split(value, ",") {
+		platform = strings.TrimSpace(platform)
+		if len(platform) == 0 || seen[platform] {
+			continue
+		}
+		if _, _, _, err := parsePlatform(platform); err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.BuildPlatforms, value, err)
+		}
+		seen[platform] = true
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// getLayerCacheRefs returns the layer caches that the build strategy's
+// environment requests be imported before and exported after the build.
+// Layer caches are only used by builds which keep their layers.
+func getLayerCacheRefs(build *buildapiv1.Build) (cacheFrom []string, cacheTo string) {
+	from, _ := buildStrategyEnv(build, builderutil.BuildCacheFrom)
+	for _, ref := range strings.Split(from, ",") {
+		if ref = strings.TrimSpace(ref); len(ref) > 0 {
+			cacheFrom = append(cacheFrom, ref)
+		}
+	}
+	to, _ := buildStrategyEnv(build, builderutil.BuildCacheTo)
+	cacheTo = strings.TrimSpace(to)
+	if len(cacheFrom) == 0 && len(cacheTo) == 0 {
+		return nil, ""
+	}
+	if s := build.Spec.Strategy.DockerStrategy; s != nil && s.ImageOptimizationPolicy != nil && *s.ImageOptimizationPolicy != buildapiv1.ImageOptimizationNone {
+		log.V(0).Infof("warning: Ignoring %s and %s, the %s image optimization policy does not keep layers", builderutil.BuildCacheFrom, builderutil.BuildCacheTo, *s.ImageOptimizationPolicy)
+		return nil, ""
+	}
+	if squash, err := GetSquashPolicy(build); err == nil && squash != SquashNone {
+		log.V(0).Infof("warning: Ignoring %s and %s, squashed builds do not keep layers", builderutil.BuildCacheFrom, builderutil.BuildCacheTo)
+		return nil, ""
+	}
+	return cacheFrom, cacheTo
+}
+
+// importLayerCache pulls the images of the layer cache at cacheRef, in order,
+// until one is not found.  The build reuses the layers of any of them whose
+// history matches the Dockerfile.  Failures only mean that less of the cache
+// is used, so they are logged rather than returned.
+func (d *DockerBuilder) importLayerCache(cacheRef string) {
+	searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
+	log.V(0).Infof("\nImporting layer cache %s ...", cacheRef)
+	count := 0
+	for {
+		repository, tag := docker.ParseRepositoryTag(layerCacheTag(cacheRef, count+1))
+		if err := d.dockerClient.PullImage(docker.PullImageOptions{Repository: repository, Tag: tag}, searchPaths); err != nil {
+			log.V(2).Infof("Stopped importing layer cache at %s:%s: %v", repository, tag, err)
+			break
+		}
+		count++
+	}
+	log.V(0).Infof("Imported %d layer cache images from %s", count, cacheRef)
+	metrics.RecordLayerCacheImport(count)
+}
+
+// exportLayerCache pushes the images making up the layers of the image name
+// to the layer cache at cacheRef.  Failures are logged rather than returned,
+// since the build itself succeeded.
+func (d *DockerBuilder) exportLayerCache(name, cacheRef string) {
+	pusher, ok := d.dockerClient.(layerCachePusher)
+	if !ok {
+		log.V(0).Infof("warning: Exporting a layer cache is not supported by this build client")
+		return
+	}
+	authConfig, _ := dockercfg.NewHelper().GetDockerAuth(cacheRef, dockercfg.PushAuthType)
+	log.V(0).Infof("\nExporting layer cache to %s ...", cacheRef)
+	err := retryImageAction("Push", func() error {
+		return pusher.PushLayerCache(name, cacheRef, authConfig)
+	})
+	if err != nil {
+		log.V(0).Infof("warning: Failed to export the layer cache to %s: %v", cacheRef, err)
+	}
+}
+
+func (d *DockerBuilder) pullImage(name string, searchPaths []string) error {
+	return pullImageFromMirrors(d.dockerClient, name, func(name string) error {
+		repository, tag := docker.ParseRepositoryTag(name)
+		options := docker.PullImageOptions{
+			Repository: repository,
+			Tag:        tag,
+		}
+
+		if options.Tag == "" && strings.Contains(name, "@") {
+			options.Repository = name
+		}
+
+		return retryImageAction("Pull", func() (pullErr error) {
+			return d.dockerClient.PullImage(options, searchPaths)
+		})
 	})
 }
 
@@ -201,7 +781,9 @@ func (d *DockerBuilder) pushImage(name string, authConfig docker.AuthConfigurati
 	var err error
 	sha := ""
 	retryImageAction("Push", func() (pushErr error) {
-		sha, err = d.dockerClient.PushImage(options, authConfig)
+		sha, err = pushWithRefreshedAuth(name, authConfig, func(authConfig docker.AuthConfiguration) (string, error) {
+			return d.dockerClient.PushImage(options, authConfig)
+		})
 		return err
 	})
 	return sha, err
@@ -211,9 +793,12 @@ func (d *DockerBuilder) pushImage(name string, authConfig docker.AuthConfigurati
 // mounted in the builder pod to a directory where the is the Dockerfile, so
 // users can ADD or COPY the files inside their Dockerfile.
 func (d *DockerBuilder) copyConfigMaps(configs []buildapiv1.ConfigMapBuildSource, targetDir string) error {
-	var err error
+	files, err := getInputFiles(d.build)
+	if err != nil {
+		return err
+	}
 	for _, c := range configs {
-		err = d.copyLocalObject(configMapSource(c), configMapBuildSourceBaseMountPath, targetDir)
+		err = d.copyLocalObject(configMapSource(c), configMapBuildSourceBaseMountPath, targetDir, files)
 		if err != nil {
 			return err
 		}
@@ -225,9 +810,12 @@ func (d *DockerBuilder) copyConfigMaps(configs []buildapiv1.ConfigMapBuildSource
 // mounted in the builder pod to a directory where the is the Dockerfile, so
 // users can ADD or COPY the files inside their Dockerfile.
 func (d *DockerBuilder) copySecrets(secrets []buildapiv1.SecretBuildSource, targetDir string) error {
-	var err error
+	files, err := getInputFiles(d.build)
+	if err != nil {
+		return err
+	}
 	for _, s := range secrets {
-		err = d.copyLocalObject(secretSource(s), secretBuildSourceBaseMountPath, targetDir)
+		err = d.copyLocalObject(secretSource(s), secretBuildSourceBaseMountPath, targetDir, files)
 		if err != nil {
 			return err
 		}
@@ -235,11 +823,18 @@ func (d *DockerBuilder) copySecrets(secrets []buildapiv1.SecretBuildSource, targ
 	return nil
 }
 
-func (d *DockerBuilder) copyLocalObject(s localObjectBuildSource, sourceDir, targetDir string) error {
+// copyLocalObject copies the files of the build source s, mounted under
+// sourceDir, into targetDir.  If files places any of its keys, only those
+// keys are copied, to their own paths.
+func (d *DockerBuilder) copyLocalObject(s localObjectBuildSource, sourceDir, targetDir string, files map[string][]inputFile) error {
 	dstDir := filepath.Join(targetDir, s.DestinationPath())
 	if err := os.MkdirAll(dstDir, 0777); err != nil {
 		return err
 	}
+	if f, ok := files[filepath.Join(sourceDir, s.LocalObjectRef().Name)]; ok {
+		log.V(3).Infof("Placing files from the build source %q under %q", s.LocalObjectRef().Name, dstDir)
+		return placeInputFiles(filepath.Join(sourceDir, s.LocalObjectRef().Name), dstDir, f)
+	}
 	log.V(3).Infof("Copying files from the build source %q to %q", s.LocalObjectRef().Name, dstDir)
 
 	// Build sources contain nested directories and fairly baroque links. To prevent extra data being
@@ -287,28 +882,32 @@ func (d *DockerBuilder) copyLocalObject(s localObjectBuildSource, sourceDir, tar
 	return nil
 }
 
-// dockerBuild performs a docker build on the source that has been retrieved
-func (d *DockerBuilder) dockerBuild(ctx context.Context, dir string, tag string) error {
+// dockerBuild performs a docker build on the source that has been retrieved.
+// If platform is set, the image is built for that os/arch[/variant].
+func (d *DockerBuilder) dockerBuild(ctx context.Context, dir string, tag string, platform string) error {
 	var noCache bool
 	var forcePull bool
-	var buildArgs []docker.BuildArg
 	dockerfilePath := defaultDockerfilePath
 	if d.build.Spec.Strategy.DockerStrategy != nil {
 		if d.build.Spec.Source.ContextDir != "" {
 			dir = filepath.Join(dir, d.build.Spec.Source.ContextDir)
 		}
-		if d.build.Spec.Strategy.DockerStrategy.DockerfilePath != "" {
-			dockerfilePath = d.build.Spec.Strategy.DockerStrategy.DockerfilePath
-		}
-		for _, ba := range d.build.Spec.Strategy.DockerStrategy.BuildArgs {
-			buildArgs = append(buildArgs, docker.BuildArg{Name: ba.Name, Value: ba.Value})
-		}
+		dockerfilePath = dockerfileName(dir, d.build)
 		noCache = d.build.Spec.Strategy.DockerStrategy.NoCache
-		forcePull = d.build.Spec.Strategy.DockerStrategy.ForcePull
+		// the base images were just pulled, and a second pull would
+		// bypass their mirrors, or pull them for the node's platform
+		forcePull = d.build.Spec.Strategy.DockerStrategy.ForcePull && !imageMirrorsConfigured() && len(pullPlatform) == 0
+	}
+	sourceInfo, err := readSourceInfo()
+	if err != nil {
+		return fmt.Errorf("error reading git source info: %v", err)
+	}
+	buildArgs, epoch, err := dockerBuildArgs(d.build, sourceInfo)
+	if err != nil {
+		return err
 	}
 
 	var auth *docker.AuthConfigurations
-	var err error
 	path := os.Getenv(dockercfg.PullAuthType)
 	if len(path) != 0 {
 		auth, err = GetDockerAuthConfiguration(path)
@@ -317,12 +916,71 @@ func (d *DockerBuilder) dockerBuild(ctx context.Context, dir string, tag string)
 		}
 	}
 
-	if err = d.copySecrets(d.build.Spec.Source.Secrets, dir); err != nil {
+	var mounts []BuildMount
+	secretMounts := false
+	if value, ok := buildStrategyEnv(d.build, builderutil.SecretMounts); ok && len(value) > 0 {
+		if secretMounts, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", builderutil.SecretMounts, value, err)
+		}
+	}
+	if secretMounts {
+		mounts = secretBuildMounts(d.build.Spec.Source.Secrets)
+	} else if err = d.copySecrets(d.build.Spec.Source.Secrets, dir); err != nil {
+		return err
+	}
+	sshAgentForwarding := false
+	if value, ok := buildStrategyEnv(d.build, builderutil.SSHAgent); ok && len(value) > 0 {
+		if sshAgentForwarding, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", builderutil.SSHAgent, value, err)
+		}
+	}
+	if sshAgentForwarding {
+		keyFile := filepath.Join(os.Getenv("SOURCE_SECRET_PATH"), "ssh-privatekey")
+		if _, err := os.Stat(keyFile); d.build.Spec.Source.SourceSecret == nil || err != nil {
+			return fmt.Errorf("%s requires a source secret with an ssh-privatekey", builderutil.SSHAgent)
+		}
+		agent, err := startSSHAgent(keyFile)
+		if err != nil {
+			return err
+		}
+		defer agent.Stop()
+		log.V(0).Infof("Forwarding ssh-agent to RUN instructions at %s", builderutil.SSHAgentSocketPath)
+		mounts = append(mounts, BuildMount{
+			Source:      agent.dir,
+			Destination: filepath.Dir(builderutil.SSHAgentSocketPath),
+		})
+	}
+	if d.caBundle != nil {
+		mounts = append(mounts, *d.caBundle)
+	}
+	mounts = append(mounts, getEntitlementMounts(d.build)...)
+	volumes, err := getBuildVolumeMounts(d.build)
+	if err != nil {
 		return err
 	}
+	mounts = append(mounts, volumes...)
 	if err = d.copyConfigMaps(d.build.Spec.Source.ConfigMaps, dir); err != nil {
 		return err
 	}
+	contexts, err := getBuildContexts(d.build)
+	if err != nil {
+		return err
+	}
+	if err = copyBuildContexts(contexts, d.inputDir, dir); err != nil {
+		return err
+	}
+	if err = applyDockerContextIgnore(d.build, dir); err != nil {
+		return err
+	}
+	targetPlatform := platform
+	if len(targetPlatform) == 0 {
+		targetPlatform = pullPlatform
+	}
+	restore, err := d.resolveFromPlatforms(filepath.Join(dir, dockerfilePath), targetPlatform, buildArgs)
+	if err != nil {
+		return err
+	}
+	defer restore()
 
 	opts := docker.BuildImageOptions{
 		Context:             ctx,
@@ -337,11 +995,26 @@ func (d *DockerBuilder) dockerBuild(ctx context.Context, dir string, tag string)
 		ContextDir:          dir,
 	}
 
+	if target, ok := buildStrategyEnv(d.build, builderutil.BuildTarget); ok && len(target) > 0 {
+		log.V(0).Infof("Building target stage %q", target)
+		opts.Target = target
+	}
+
+	// Base images are always pulled when building for a specific platform,
+	// since a local copy may be for a different one
+	if len(platform) > 0 {
+		opts.Platform = platform
+		opts.Pull = true
+	}
+
 	// Though we are capped on memory and cpu at the cgroup parent level,
 	// some build containers care what their memory limit is so they can
 	// adapt, thus we need to set the memory limit at the container level
 	// too, so that information is available to them.
 	if d.cgLimits != nil {
+		opts.CPUPeriod = d.cgLimits.CPUPeriod
+		opts.CPUQuota = d.cgLimits.CPUQuota
+		opts.CPUShares = d.cgLimits.CPUShares
 		opts.Memory = d.cgLimits.MemoryLimitBytes
 		opts.Memswap = d.cgLimits.MemorySwap
 		opts.CgroupParent = d.cgLimits.Parent
@@ -351,9 +1024,195 @@ func (d *DockerBuilder) dockerBuild(ctx context.Context, dir string, tag string)
 		opts.AuthConfigs = *auth
 	}
 
-	return d.dockerClient.BuildImage(opts)
+	if len(mounts) > 0 {
+		builder, ok := d.dockerClient.(mountingBuilder)
+		if !ok {
+			return fmt.Errorf("mounting build secrets, an ssh-agent, a CA bundle, an entitlement or build volumes is not supported by this build client")
+		}
+		err = builder.BuildImageWithMounts(opts, mounts)
+	} else {
+		err = d.dockerClient.BuildImage(opts)
+	}
+	if err != nil || epoch == nil {
+		return err
+	}
+	return makeImageReproducible(d.dockerClient, tag, *epoch)
 }
 
+// dockerBuildArgs returns the build args of the build's Docker build: those
+// of its strategy, followed by those requested from its environment, its
+// proxies and, for a reproducible build, SOURCE_DATE_EPOCH, whose time it
+// also returns.
+func dockerBuildArgs(build *buildapiv1.Build, sourceInfo *git.SourceInfo) ([]docker.BuildArg, *time.Time, error) {
+	var buildArgs []docker.BuildArg
+	if build.Spec.Strategy.DockerStrategy != nil {
+		for _, ba := range build.Spec.Strategy.DockerStrategy.BuildArgs {
+			buildArgs = append(buildArgs, docker.BuildArg{Name: ba.Name, Value: ba.Value})
+		}
+	}
+	buildArgs, err := envBuildArgs(build, buildArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if buildArgs, err = proxyBuildArgs(build, buildArgs); err != nil {
+		return nil, nil, err
+	}
+	epoch, err := getSourceDateEpoch(build, sourceInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if epoch != nil {
+		buildArgs = sourceDateEpochBuildArg(buildArgs, *epoch)
+	}
+	return buildArgs, epoch, nil
+}
+
+// secretBuildMounts returns the mounts which make each of the build's input
+// secrets available to RUN instructions at SecretMountsPath/<secret name>,
+// so that the secrets are never part of the build context or the image.
+func secretBuildMounts(secrets []buildapiv1.SecretBuildSource) []BuildMount {
+	mounts := []BuildMount{}
+	for _, s := range secrets {
+		name := s.Secret.Name
+		mounts = append(mounts, BuildMount{
+			Source:      filepath.Join(secretBuildSourceBaseMountPath, name),
+			Destination: filepath.Join(builderutil.SecretMountsPath, name),
+		})
+		log.V(3).Infof("Mounting build secret %q at %s", name, filepath.Join(builderutil.SecretMountsPath, name))
+	}
+	return mounts
+}
+
+// sensitiveBuildArgNames are the parts of the names of environment variables,
+// upper-cased, which are never passed as build args since the values of build
+// args are recorded in the image's history.
+var sensitiveBuildArgNames = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "CREDENTIAL", "PRIVATE", "API_KEY", "ACCESS_KEY"}
+
+// isSensitiveBuildArg returns whether name is one of the variables which are
+// not passed as build args.
+func isSensitiveBuildArg(name string) bool {
+	upper := strings.ToUpper(name)
+	return strings.HasPrefix(upper, "BUILD_") || hasSecretName(name)
+}
+
+// hasSecretName returns whether name, the name of a variable, suggests that
+// it holds a secret.
+func hasSecretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, sensitive := range sensitiveBuildArgNames {
+		if strings.Contains(upper, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// envBuildArgs adds build args for the variables of the build strategy's
+// environment which it requests be passed as build args to buildArgs, unless
+// buildArgs already sets them, so that buildArgs take precedence over the
+// environment and both over the defaults of the Dockerfile's ARG
+// instructions.  Naming a sensitive variable is an error, while "*" skips
+// them.
+func envBuildArgs(build *buildapiv1.Build, buildArgs []docker.BuildArg) ([]docker.BuildArg, error) {
+	value, _ := buildStrategyEnv(build, builderutil.BuildArgsFromEnv)
+	if len(strings.TrimSpace(value)) == 0 || build.Spec.Strategy.DockerStrategy == nil {
+		return buildArgs, nil
+	}
+	all := false
+	requested := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case len(name) == 0:
+		case name == "*":
+			all = true
+		case isSensitiveBuildArg(name):
+			return nil, fmt.Errorf("invalid %s value %q: %s may hold a secret and is never passed as a build arg", builderutil.BuildArgsFromEnv, value, name)
+		default:
+			requested[name] = true
+		}
+	}
+
+	set := map[string]bool{}
+	for _, arg := range buildArgs {
+		set[arg.Name] = true
+	}
+	for _, env := range build.Spec.Strategy.DockerStrategy.Env {
+		if !all && !requested[env.Name] {
+			continue
+		}
+		if env.ValueFrom != nil || isSensitiveBuildArg(env.Name) {
+			log.V(3).Infof("Not passing the environment variable %s as a build arg", env.Name)
+			continue
+		}
+		if set[env.Name] {
+			log.V(3).Infof("Not passing the environment variable %s as a build arg, the build sets it", env.Name)
+			continue
+		}
+		set[env.Name] = true
+		buildArgs = append(buildArgs, docker.BuildArg{Name: env.Name, Value: env.Value})
+		log.V(3).Infof("Passing the environment variable %s as a build arg", env.Name)
+	}
+	return buildArgs, nil
+}
+
+// proxyBuildArgs adds build args for the proxy settings of the build's git
+// source, or else those of the builder's environment, to buildArgs, unless
+// buildArgs already sets them or the build strategy's environment opts out.
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are predefined build args, which need
+// no ARG instruction, are set in the environment of RUN instructions and are
+// not committed to the image.
+func proxyBuildArgs(build *buildapiv1.Build, buildArgs []docker.BuildArg) ([]docker.BuildArg, error) {
+	if value, ok := buildStrategyEnv(build, builderutil.ProxyBuildArgs); ok && len(value) > 0 {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.ProxyBuildArgs, value, err)
+		}
+		if !enabled {
+			return buildArgs, nil
+		}
+	}
+
+	set := map[string]bool{}
+	for _, arg := range buildArgs {
+		set[arg.Name] = true
+	}
+	git := build.Spec.Source.Git
+	if git == nil {
+		git = &buildapiv1.GitBuildSource{}
+	}
+	for _, proxy := range []struct {
+		name  string
+		value *string
+	}{
+		{"HTTP_PROXY", git.HTTPProxy},
+		{"HTTPS_PROXY", git.HTTPSProxy},
+		{"NO_PROXY", git.NoProxy},
+	} {
+		value := ""
+		switch {
+		case proxy.value != nil && len(*proxy.value) > 0:
+			value = *proxy.value
+		case len(os.Getenv(proxy.name)) > 0:
+			value = os.Getenv(proxy.name)
+		default:
+			value = os.Getenv(strings.ToLower(proxy.name))
+		}
+		if len(value) == 0 {
+			continue
+		}
+		for _, name := range []string{proxy.name, strings.ToLower(proxy.name)} {
+			if !set[name] {
+				buildArgs = append(buildArgs, docker.BuildArg{Name: name, Value: value})
+			}
+		}
+		log.V(3).Infof("Passing the proxy setting %s to RUN instructions", proxy.name)
+	}
+	return buildArgs, nil
+}
+
+// getDockerfilePath returns the path of the Dockerfile of the build whose
+// source is in dir.
 func getDockerfilePath(dir string, build *buildapiv1.Build) string {
 	var contextDirPath string
 	if build.Spec.Strategy.DockerStrategy != nil && len(build.Spec.Source.ContextDir) > 0 {
@@ -361,43 +1220,111 @@ func getDockerfilePath(dir string, build *buildapiv1.Build) string {
 	} else {
 		contextDirPath = dir
 	}
+	return filepath.Join(contextDirPath, dockerfileName(contextDirPath, build))
+}
 
-	var dockerfilePath string
+// dockerfileName returns the path of the Dockerfile of the build, relative
+// to its context directory contextDir: the DockerfilePath of its strategy,
+// such as Containerfile or docker/Dockerfile.prod, or else the context's
+// Dockerfile or, if it has none, its Containerfile.
+func dockerfileName(contextDir string, build *buildapiv1.Build) string {
 	if build.Spec.Strategy.DockerStrategy != nil && len(build.Spec.Strategy.DockerStrategy.DockerfilePath) > 0 {
-		dockerfilePath = filepath.Join(contextDirPath, build.Spec.Strategy.DockerStrategy.DockerfilePath)
-	} else {
-		dockerfilePath = filepath.Join(contextDirPath, defaultDockerfilePath)
+		return filepath.FromSlash(build.Spec.Strategy.DockerStrategy.DockerfilePath)
 	}
-	return dockerfilePath
+	if _, err := os.Stat(filepath.Join(contextDir, defaultDockerfilePath)); os.IsNotExist(err) {
+		if _, err := os.Stat(filepath.Join(contextDir, defaultContainerfilePath)); err == nil {
+			return defaultContainerfilePath
+		}
+	}
+	return defaultDockerfilePath
 }
 
-// replaceLastFrom changes the last FROM instruction of node to point to the
-// given image with an optional alias.
-func replaceLastFrom(node *parser.Node, image string, alias string) error {
+// validateDockerfilePath returns an error if the DockerfilePath of the
+// build's strategy is not a path within its context directory.
+func validateDockerfilePath(build *buildapiv1.Build) error {
+	if build.Spec.Strategy.DockerStrategy == nil || len(build.Spec.Strategy.DockerStrategy.DockerfilePath) == 0 {
+		return nil
+	}
+	dockerfilePath := build.Spec.Strategy.DockerStrategy.DockerfilePath
+	if clean := path.Clean(dockerfilePath); path.IsAbs(dockerfilePath) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid dockerfilePath %q: it must be a path within the build's context directory", dockerfilePath)
+	}
+	return nil
+}
+
+// findTargetStage returns the position of the stage of node named by target,
+// the BUILD_TARGET of a build, or that of its last stage if target is empty.
+// It returns -1 if node has no stages.
+func findTargetStage(node *parser.Node, target string) (int, error) {
+	froms := dockerfile.FindAll(node, dockercmd.From)
+	if len(target) == 0 {
+		return len(froms) - 1, nil
+	}
+	for i, index := range froms {
+		// as imagebuilder does, stages without a name are named by their
+		// position
+		name := fromAlias(node.Children[index])
+		if len(name) == 0 {
+			name = strconv.Itoa(i)
+		}
+		if strings.EqualFold(name, target) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("invalid %s value %q: the Dockerfile has no stage of that name", builderutil.BuildTarget, target)
+}
+
+// lastStage returns the position of the last stage of node, or -1 if it has
+// none.
+func lastStage(node *parser.Node) int {
+	return len(dockerfile.FindAll(node, dockercmd.From)) - 1
+}
+
+// stageEnd returns the index of the child of node after the last instruction
+// of the stage at position stage, or after its last child if it has no such
+// stage.
+func stageEnd(node *parser.Node, stage int) int {
+	froms := dockerfile.FindAll(node, dockercmd.From)
+	if stage >= 0 && stage+1 < len(froms) {
+		return froms[stage+1]
+	}
+	return len(node.Children)
+}
+
+// fromAlias returns the name given to its stage by from, a FROM instruction.
+func fromAlias(from *parser.Node) string {
+	if from.Next != nil && from.Next.Next != nil && strings.ToUpper(from.Next.Next.Value) == "AS" && from.Next.Next.Next != nil {
+		return from.Next.Next.Next.Value
+	}
+	return ""
+}
+
+// replaceFrom changes the FROM instruction of the stage of node at position
+// stage to point to the given image with an optional alias.
+func replaceFrom(node *parser.Node, stage int, image string, alias string) error {
 	if node == nil {
 		return nil
 	}
-	for i := len(node.Children) - 1; i >= 0; i-- {
-		child := node.Children[i]
-		if child != nil && child.Value == dockercmd.From {
-			if child.Next == nil {
-				child.Next = &parser.Node{}
-			}
+	froms := dockerfile.FindAll(node, dockercmd.From)
+	if stage < 0 || stage >= len(froms) {
+		return nil
+	}
+	child := node.Children[froms[stage]]
+	if child.Next == nil {
+		child.Next = &parser.Node{}
+	}
 
-			log.Infof("Replaced Dockerfile FROM image %s", child.Next.Value)
-			child.Next.Value = image
-			if len(alias) != 0 {
-				if child.Next.Next == nil {
-					child.Next.Next = &parser.Node{}
-				}
-				child.Next.Next.Value = "as"
-				if child.Next.Next.Next == nil {
-					child.Next.Next.Next = &parser.Node{}
-				}
-				child.Next.Next.Next.Value = alias
-			}
-			return nil
+	log.Infof("Replaced Dockerfile FROM image %s", child.Next.Value)
+	child.Next.Value = image
+	if len(alias) != 0 {
+		if child.Next.Next == nil {
+			child.Next.Next = &parser.Node{}
+		}
+		child.Next.Next.Value = "as"
+		if child.Next.Next.Next == nil {
+			child.Next.Next.Next = &parser.Node{}
 		}
+		child.Next.Next.Next.Value = alias
 	}
 	return nil
 }
@@ -413,12 +1340,7 @@ func getLastFrom(node *parser.Node) (string, string) {
 		child := node.Children[i]
 		if child != nil && child.Value == dockercmd.From {
 			if child.Next != nil {
-				image = child.Next.Value
-				if child.Next.Next != nil && strings.ToUpper(child.Next.Next.Value) == "AS" {
-					if child.Next.Next.Next != nil {
-						alias = child.Next.Next.Next.Value
-					}
-				}
+				image, alias = child.Next.Value, fromAlias(child)
 				break
 			}
 		}
@@ -426,70 +1348,27 @@ func getLastFrom(node *parser.Node) (string, string) {
 	return image, alias
 }
 
-// appendEnv appends an ENV Dockerfile instruction as the last child of node
-// with keys and values from m.
-func appendEnv(node *parser.Node, m []dockerfile.KeyValue) error {
-	return appendKeyValueInstruction(dockerfile.Env, node, m)
+// appendEnv appends an ENV Dockerfile instruction to the stage of node at
+// position stage with keys and values from m.
+func appendEnv(node *parser.Node, stage int, m []dockerfile.KeyValue) error {
+	return appendKeyValueInstruction(dockerfile.Env, node, stage, m)
 }
 
-// appendLabel appends a LABEL Dockerfile instruction as the last child of node
-// with keys and values from m.
-func appendLabel(node *parser.Node, m []dockerfile.KeyValue) error {
+// appendLabel appends a LABEL Dockerfile instruction to the stage of node at
+// position stage with keys and values from m.
+func appendLabel(node *parser.Node, stage int, m []dockerfile.KeyValue) error {
 	if len(m) == 0 {
 		return nil
 	}
-	return appendKeyValueInstruction(dockerfile.Label, node, m)
-}
-
-// appendPostCommit appends a RUN <cmd> Dockerfile instruction as the last child of node
-func appendPostCommit(node *parser.Node, cmd string) error {
-	if len(cmd) == 0 {
-		return nil
-	}
-
-	image, alias := getLastFrom(node)
-	if len(alias) == 0 {
-		alias = postCommitAlias
-		if err := replaceLastFrom(node, image, alias); err != nil {
-			return err
-		}
-	}
-
-	if err := appendStringInstruction(dockerfile.From, node, alias); err != nil {
-		return err
-	}
-
-	if err := appendStringInstruction(dockerfile.Run, node, cmd); err != nil {
-		return err
-	}
-
-	if err := appendStringInstruction(dockerfile.From, node, alias); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// appendStringInstruction is a primitive used to avoid code duplication.
-// Callers should use a derivative of this such as appendPostCommit.
-// appendStringInstruction appends a Dockerfile instruction with string
-// syntax created by f as the last child of node with the string from cmd.
-func appendStringInstruction(f func(string) (string, error), node *parser.Node, cmd string) error {
-	if node == nil {
-		return nil
-	}
-	instruction, err := f(cmd)
-	if err != nil {
-		return err
-	}
-	return dockerfile.InsertInstructions(node, len(node.Children), instruction)
+	return appendKeyValueInstruction(dockerfile.Label, node, stage, m)
 }
 
 // appendKeyValueInstruction is a primitive used to avoid code duplication.
 // Callers should use a derivative of this such as appendEnv or appendLabel.
 // appendKeyValueInstruction appends a Dockerfile instruction with key-value
-// syntax created by f as the last child of node with keys and values from m.
-func appendKeyValueInstruction(f func([]dockerfile.KeyValue) (string, error), node *parser.Node, m []dockerfile.KeyValue) error {
+// syntax created by f to the stage of node at position stage with keys and
+// values from m.
+func appendKeyValueInstruction(f func([]dockerfile.KeyValue) (string, error), node *parser.Node, stage int, m []dockerfile.KeyValue) error {
 	if node == nil {
 		return nil
 	}
@@ -497,7 +1376,7 @@ func appendKeyValueInstruction(f func([]dockerfile.KeyValue) (string, error), no
 	if err != nil {
 		return err
 	}
-	return dockerfile.InsertInstructions(node, len(node.Children), instruction)
+	return dockerfile.InsertInstructions(node, stageEnd(node, stage), instruction)
 }
 
 // insertEnvAfterFrom inserts an ENV instruction with the environment variables
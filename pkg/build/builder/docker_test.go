@@ -7,10 +7,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
-	"github.com/MakeNowJust/heredoc"
 	docker "github.com/fsouza/go-dockerclient"
 
 	corev1 "k8s.io/api/core/v1"
@@ -19,7 +19,6 @@ import (
 	buildapiv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
 	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
-	"github.com/openshift/imagebuilder"
 
 	"github.com/openshift/library-go/pkg/git"
 )
@@ -134,206 +133,14 @@ RUN echo "hello world"
 			t.Errorf("test[%d]: %v", i, err)
 			continue
 		}
-		replaceLastFrom(got, test.image, "")
+		replaceFrom(got, lastStage(got), test.image, "")
 		if !bytes.Equal(dockerfile.Write(got), dockerfile.Write(want)) {
-			t.Errorf("test[%d]: replaceLastFrom(node, %+v) = %+v; want %+v", i, test.image, got, want)
+			t.Errorf("test[%d]: replaceFrom(node, last, %+v) = %+v; want %+v", i, test.image, got, want)
 			t.Logf("resulting Dockerfile:\n%s", dockerfile.Write(got))
 		}
 	}
 }
 
-func TestAppendPostCommit(t *testing.T) {
-	type want struct {
-		Err bool
-		Out string
-	}
-	tests := []struct {
-		description string
-		original    string
-		postCommit  buildapiv1.BuildPostCommitSpec
-		from        *corev1.ObjectReference
-		build       []buildapiv1.ImageSource
-		want        want
-	}{
-		{
-			description: "basic multi-part bash command",
-			original: heredoc.Doc(`
-				FROM busybox
-				RUN echo "hello world"
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo", "hello"},
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as <alias>
-				RUN echo "hello world"
-				FROM <alias>
-				RUN echo hello
-				FROM <alias>
-				`),
-			},
-		},
-		{
-			description: "basic bash command with args",
-			original: heredoc.Doc(`
-				FROM busybox
-				RUN touch /tmp/hello
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"ls"},
-				Args:    []string{"-l", "/tmp/hello"}},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as <alias>
-				RUN touch /tmp/hello
-				FROM <alias>
-				RUN ls -l /tmp/hello
-				FROM <alias>
-				`),
-			},
-		},
-		{
-			description: "basic bash script",
-			original: heredoc.Doc(`
-				FROM busybox
-				RUN echo "hello world"
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Script: "echo hello $1 world",
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as <alias>
-				RUN echo "hello world"
-				FROM <alias>
-				RUN /bin/sh -ic 'echo hello $1 world'
-				FROM <alias>
-				`),
-			},
-		},
-		{
-			description: "basic bash script with args",
-			original: heredoc.Doc(`
-				FROM busybox
-				RUN echo "hello world"
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Script: "echo",
-				Args:   []string{"hello", "$1", "world"},
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as <alias>
-				RUN echo "hello world"
-				FROM <alias>
-				RUN /bin/sh -ic 'echo hello $1 world'
-				FROM <alias>
-				`),
-			},
-		},
-		{
-			description: "multi-stage basic multi-part bash command",
-			original: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage
-				RUN touch /tmp/hello
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo", "hello", "$1", "world"},
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage as <alias>
-				RUN touch /tmp/hello
-				FROM <alias>
-				RUN echo hello $1 world
-				FROM <alias>
-				`),
-			},
-		},
-		{
-			description: "multi-stage basic bash command with args and alias",
-			original: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage as myimage
-				RUN touch /tmp/hello
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Command: []string{"echo"},
-				Args:    []string{"hello", "$1", "world"},
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage as myimage
-				RUN touch /tmp/hello
-				FROM myimage
-				RUN echo hello $1 world
-				FROM myimage
-				`),
-			},
-		},
-		{
-			description: "multi-stage basic bash script with aliases",
-			original: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage as appimage2
-				RUN touch /tmp/hello
-				FROM appimage2 as appimage3
-				RUN touch /tmp/hello2
-				FROM appimage3 as appimage4
-				RUN touch /tmp/hello3
-				`),
-			postCommit: buildapiv1.BuildPostCommitSpec{
-				Script: "echo hello $1 world",
-			},
-			want: want{
-				Out: heredoc.Doc(`
-				FROM busybox as appimage
-				RUN echo "hello world"
-				FROM appimage as appimage2
-				RUN touch /tmp/hello
-				FROM appimage2 as appimage3
-				RUN touch /tmp/hello2
-				FROM appimage3 as appimage4
-				RUN touch /tmp/hello3
-				FROM appimage4
-				RUN /bin/sh -ic 'echo hello $1 world'
-				FROM appimage4
-				`),
-			},
-		},
-	}
-
-	for i, test := range tests {
-		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			node, err := imagebuilder.ParseDockerfile(strings.NewReader(test.original))
-			if err != nil {
-				t.Fatal(err)
-			}
-			if err := appendPostCommit(node, buildPostCommit(test.postCommit)); err != nil {
-				t.Errorf("appendPostCommit error: %#v", err)
-			}
-			wantNode, err := imagebuilder.ParseDockerfile(strings.NewReader(strings.Replace(test.want.Out, "<alias>", postCommitAlias, -1)))
-			if err != nil {
-				t.Fatal(err)
-			}
-			if !bytes.Equal(dockerfile.Write(node), dockerfile.Write(wantNode)) {
-				t.Errorf("FAILED!")
-				t.Logf("wanted:\n%s", dockerfile.Write(wantNode))
-				t.Logf("got:\n%s", dockerfile.Write(node))
-			}
-		})
-	}
-}
-
 // TestDockerfilePath validates that we can use a Dockerfile with a custom name, and in a sub-directory
 func TestDockerfilePath(t *testing.T) {
 	tests := []struct {
@@ -369,6 +176,19 @@ func TestDockerfilePath(t *testing.T) {
 				DockerfilePath: "dockerfiles/mydockerfile",
 			},
 		},
+		// a context with a Containerfile but no Dockerfile
+		{
+			contextDir:     "somedir",
+			dockerfilePath: "Containerfile",
+			dockerStrategy: &buildapiv1.DockerBuildStrategy{},
+		},
+		// custom Containerfile path in a sub directory
+		{
+			dockerfilePath: "docker/Containerfile.prod",
+			dockerStrategy: &buildapiv1.DockerBuildStrategy{
+				DockerfilePath: "docker/Containerfile.prod",
+			},
+		},
 	}
 
 	from := "FROM openshift/origin-base"
@@ -477,7 +297,7 @@ func TestDockerfilePath(t *testing.T) {
 		}
 
 		// check that the docker client is called with the right Dockerfile parameter
-		if err = dockerBuilder.dockerBuild(context.TODO(), buildDir, ""); err != nil {
+		if err = dockerBuilder.dockerBuild(context.TODO(), buildDir, "", ""); err != nil {
 			t.Errorf("failed to build: %v", err)
 			continue
 		}
@@ -485,6 +305,302 @@ func TestDockerfilePath(t *testing.T) {
 	}
 }
 
+type fakeMountingDocker struct {
+	*FakeDocker
+	mounts []BuildMount
+}
+
+func (d *fakeMountingDocker) BuildImageWithMounts(opts docker.BuildImageOptions, mounts []BuildMount) error {
+	d.mounts = mounts
+	return d.BuildImage(opts)
+}
+
+func TestSecretMounts(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "secret-mounts")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(buildDir)
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{
+		{Secret: corev1.LocalObjectReference{Name: "netrc"}, DestinationDir: "creds"},
+	}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_SECRET_MOUNTS", Value: "true"}},
+	}
+
+	dockerClient := &fakeMountingDocker{FakeDocker: NewFakeDockerClient()}
+	dockerBuilder := &DockerBuilder{
+		dockerClient: dockerClient,
+		build:        build,
+	}
+	if err := dockerBuilder.dockerBuild(context.TODO(), buildDir, "", ""); err != nil {
+		t.Fatalf("failed to build: %v", err)
+	}
+	expected := []BuildMount{{Source: "/var/run/secrets/openshift.io/build/netrc", Destination: "/run/build-secrets/netrc"}}
+	if !reflect.DeepEqual(dockerClient.mounts, expected) {
+		t.Errorf("expected mounts %v, got %v", expected, dockerClient.mounts)
+	}
+	if _, err := os.Stat(filepath.Join(buildDir, "creds")); !os.IsNotExist(err) {
+		t.Errorf("secret should not have been copied into the build context")
+	}
+
+	// a client which cannot mount secrets fails the build rather than
+	// falling back to copying them
+	dockerBuilder.dockerClient = NewFakeDockerClient()
+	if err := dockerBuilder.dockerBuild(context.TODO(), buildDir, "", ""); err == nil {
+		t.Errorf("expected an error from a client which cannot mount secrets")
+	}
+}
+
+func TestBuildTarget(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "build-target")
+	if err != nil {
+		t.Fatalf("failed to create tmpdir: %v", err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, target := range []string{"", "test"} {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+		if len(target) > 0 {
+			build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_TARGET", Value: target}}
+		}
+		var opts docker.BuildImageOptions
+		dockerClient := NewFakeDockerClient()
+		dockerClient.buildImageFunc = func(o docker.BuildImageOptions) error {
+			opts = o
+			return nil
+		}
+		dockerBuilder := &DockerBuilder{
+			dockerClient: dockerClient,
+			build:        build,
+		}
+		if err := dockerBuilder.dockerBuild(context.TODO(), buildDir, "", ""); err != nil {
+			t.Fatalf("failed to build: %v", err)
+		}
+		if opts.Target != target {
+			t.Errorf("expected target %q, got %q", target, opts.Target)
+		}
+	}
+}
+
+func TestProxyBuildArgs(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+	os.Setenv("https_proxy", "http://env-proxy:3128")
+	os.Setenv("NO_PROXY", ".cluster.local")
+
+	gitProxy := "http://git-proxy:3128"
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{ProxyConfig: buildapiv1.ProxyConfig{HTTPProxy: &gitProxy}}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	buildArgs, err := proxyBuildArgs(build, []docker.BuildArg{{Name: "no_proxy", Value: "example.com"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []docker.BuildArg{
+		{Name: "no_proxy", Value: "example.com"},
+		{Name: "HTTP_PROXY", Value: "http://git-proxy:3128"},
+		{Name: "http_proxy", Value: "http://git-proxy:3128"},
+		{Name: "HTTPS_PROXY", Value: "http://env-proxy:3128"},
+		{Name: "https_proxy", Value: "http://env-proxy:3128"},
+		{Name: "NO_PROXY", Value: ".cluster.local"},
+	}
+	if !reflect.DeepEqual(buildArgs, expected) {
+		t.Errorf("expected build args %v, got %v", expected, buildArgs)
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_PROXY_ARGS", Value: "false"}}
+	if buildArgs, err := proxyBuildArgs(build, nil); err != nil || len(buildArgs) != 0 {
+		t.Errorf("expected no build args when opted out, got %v, %v", buildArgs, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_PROXY_ARGS", Value: "never"}}
+	if _, err := proxyBuildArgs(build, nil); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
+
+func TestEnvBuildArgs(t *testing.T) {
+	env := []corev1.EnvVar{
+		{Name: "VERSION", Value: "1.2"},
+		{Name: "GOFLAGS", Value: "-mod=vendor"},
+		{Name: "NPM_TOKEN", Value: "hunter2"},
+		{Name: "REGISTRY", ValueFrom: &corev1.EnvVarSource{}},
+	}
+	tests := []struct {
+		name      string
+		value     string
+		buildArgs []docker.BuildArg
+		expect    []docker.BuildArg
+		expectErr bool
+	}{
+		{
+			name:   "unset",
+			expect: []docker.BuildArg{{Name: "VERSION", Value: "2.0"}},
+		},
+		{
+			name:  "named",
+			value: "GOFLAGS, REGISTRY",
+			expect: []docker.BuildArg{
+				{Name: "VERSION", Value: "2.0"},
+				{Name: "GOFLAGS", Value: "-mod=vendor"},
+			},
+		},
+		{
+			name:  "all",
+			value: "*",
+			expect: []docker.BuildArg{
+				{Name: "VERSION", Value: "2.0"},
+				{Name: "GOFLAGS", Value: "-mod=vendor"},
+			},
+		},
+		{
+			name:      "sensitive",
+			value:     "VERSION,NPM_TOKEN",
+			expectErr: true,
+		},
+		{
+			name:      "builder setting",
+			value:     "BUILD_ARGS_FROM_ENV",
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+				Env: append([]corev1.EnvVar{{Name: "BUILD_ARGS_FROM_ENV", Value: test.value}}, env...),
+			}
+			buildArgs, err := envBuildArgs(build, []docker.BuildArg{{Name: "VERSION", Value: "2.0"}})
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if !test.expectErr && !reflect.DeepEqual(buildArgs, test.expect) {
+				t.Errorf("expected build args %v, got %v", test.expect, buildArgs)
+			}
+		})
+	}
+}
+
+func TestGetBuildPlatforms(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:  "platforms",
+			value: "linux/amd64, linux/arm64/v8,,linux/amd64",
+			want:  []string{"linux/amd64", "linux/arm64/v8"},
+		},
+		{
+			name:    "missing arch",
+			value:   "linux/amd64,linux",
+			wantErr: true,
+		},
+		{
+			name:    "too many components",
+			value:   "linux/arm/v7/extra",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+			if len(test.value) > 0 {
+				build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_PLATFORMS", Value: test.value}}
+			}
+			got, err := getBuildPlatforms(build)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGetLayerCacheRefs(t *testing.T) {
+	skipLayers := buildapiv1.ImageOptimizationSkipLayers
+	tests := []struct {
+		name          string
+		env           []corev1.EnvVar
+		policy        *buildapiv1.ImageOptimizationPolicy
+		wantCacheFrom []string
+		wantCacheTo   string
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "cache from and to",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CACHE_FROM", Value: "registry.example.com/ns/cache:main, registry.example.com/ns/cache"},
+				{Name: "BUILD_CACHE_TO", Value: "registry.example.com/ns/cache:main"},
+			},
+			wantCacheFrom: []string{"registry.example.com/ns/cache:main", "registry.example.com/ns/cache"},
+			wantCacheTo:   "registry.example.com/ns/cache:main",
+		},
+		{
+			name: "skip layers",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CACHE_FROM", Value: "registry.example.com/ns/cache"},
+				{Name: "BUILD_CACHE_TO", Value: "registry.example.com/ns/cache"},
+			},
+			policy: &skipLayers,
+		},
+		{
+			name: "squashed",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_CACHE_FROM", Value: "registry.example.com/ns/cache"},
+				{Name: "BUILD_SQUASH", Value: "build"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env, ImageOptimizationPolicy: test.policy}
+			cacheFrom, cacheTo := getLayerCacheRefs(build)
+			if !reflect.DeepEqual(cacheFrom, test.wantCacheFrom) || cacheTo != test.wantCacheTo {
+				t.Errorf("expected %v and %q, got %v and %q", test.wantCacheFrom, test.wantCacheTo, cacheFrom, cacheTo)
+			}
+		})
+	}
+}
+
+func TestImportLayerCache(t *testing.T) {
+	pulled := []string{}
+	dockerClient := &FakeDocker{
+		pullImageFunc: func(opts docker.PullImageOptions, searchPaths []string) error {
+			if opts.Tag == "main-3" {
+				return docker.ErrNoSuchImage
+			}
+			pulled = append(pulled, opts.Repository+":"+opts.Tag)
+			return nil
+		},
+	}
+	dockerBuilder := &DockerBuilder{dockerClient: dockerClient}
+	dockerBuilder.importLayerCache("registry.example.com/ns/cache:main")
+	expected := []string{"registry.example.com/ns/cache:main-1", "registry.example.com/ns/cache:main-2"}
+	if !reflect.DeepEqual(pulled, expected) {
+		t.Errorf("expected %v to be pulled, got %v", expected, pulled)
+	}
+}
+
 func TestEmptySource(t *testing.T) {
 	build := &buildapiv1.Build{
 		ObjectMeta: metav1.ObjectMeta{
@@ -594,3 +710,56 @@ USER 1001`
 		}
 	}
 }
+
+func TestValidateDockerfilePath(t *testing.T) {
+	for dockerfilePath, valid := range map[string]bool{
+		"":                       true,
+		"Containerfile":          true,
+		"docker/Dockerfile.prod": true,
+		"./docker/../Dockerfile": true,
+		"..Dockerfile":           true,
+		"../Dockerfile":          false,
+		"docker/../../etc/x":     false,
+		"..":                     false,
+		"/etc/Dockerfile":        false,
+	} {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{DockerfilePath: dockerfilePath}
+		if err := validateDockerfilePath(build); (err == nil) != valid {
+			t.Errorf("%q: expected valid=%v, got %v", dockerfilePath, valid, err)
+		}
+	}
+}
+
+func TestManageDockerfileInlineDockerfilePath(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "inline-dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+	dockerfile := "FROM busybox\n"
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Dockerfile = &dockerfile
+	build.Spec.Source.ContextDir = "app"
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{DockerfilePath: "docker/Containerfile"}
+	if err := ManageDockerfile(buildDir, build); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(buildDir, "app", "docker", "Containerfile"))
+	if err != nil {
+		t.Fatalf("expected the inline Dockerfile to be written to the dockerfilePath: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "FROM busybox") {
+		t.Errorf("unexpected Dockerfile %q", data)
+	}
+
+	build.Spec.Source.Dockerfile = nil
+	build.Spec.Strategy.DockerStrategy.DockerfilePath = "docker/Dockerfile.prod"
+	if err := ManageDockerfile(buildDir, build); err == nil || !strings.Contains(err.Error(), "no Dockerfile was found at app/docker/Dockerfile.prod") {
+		t.Errorf("expected an error naming the missing Dockerfile, got %v", err)
+	}
+	build.Spec.Strategy.DockerStrategy.DockerfilePath = "../Dockerfile"
+	if err := ManageDockerfile(buildDir, build); err == nil {
+		t.Errorf("expected a dockerfilePath outside the context to be rejected")
+	}
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"github.com/openshift/imagebuilder"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+// strategyBuildArgs returns the values of the build args of the build's
+// Docker strategy, keyed by name.  Build args whose values come from other
+// objects are left out, as they are only known to the build itself.
+func strategyBuildArgs(build *buildapiv1.Build) map[string]string {
+	args := make(map[string]string)
+	if build == nil || build.Spec.Strategy.DockerStrategy == nil {
+		return args
+	}
+	for _, arg := range build.Spec.Strategy.DockerStrategy.BuildArgs {
+		if arg.ValueFrom == nil {
+			args[arg.Name] = arg.Value
+		}
+	}
+	return args
+}
+
+// dockerfileStages returns the stages of the Dockerfile node, and the values
+// of the ARGs declared before its first FROM, overridden by those of
+// buildArgs, as NAME=value pairs for expanding the images of its FROM
+// instructions.  Unlike imagebuilder.NewStages, it leaves those ARGs in node,
+// so that a Dockerfile written from node still declares them.
+func dockerfileStages(node *parser.Node, buildArgs map[string]string) (imagebuilder.Stages, []string, error) {
+	args := make(map[string]string)
+	for name, value := range buildArgs {
+		args[name] = value
+	}
+	b := imagebuilder.NewBuilder(args)
+	children := node.Children
+	stages, err := imagebuilder.NewStages(node, b)
+	node.Children = children
+	if err != nil {
+		return nil, nil, err
+	}
+	return stages, b.Arguments(), nil
+}
+
+// expandImageName returns the image name of a FROM instruction, or of a
+// COPY --from flag, with the ARGs it refers to replaced by their values in
+// args.  A name which cannot be expanded is returned as it is.
+func expandImageName(name string, args []string) string {
+	expanded, err := imagebuilder.ProcessWord(name, args)
+	if err != nil || len(expanded) == 0 {
+		return name
+	}
+	return expanded
+}
@@ -13,18 +13,75 @@ import (
 	"github.com/docker/distribution/registry/api/errcode"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 
+	buildapiv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 )
 
 var (
 	// DefaultPushOrPullRetryCount is the number of retries of pushing or pulling the built Docker image
 	// into a configured repository
 	DefaultPushOrPullRetryCount = 2
-	// DefaultPushOrPullRetryDelay is the time to wait before triggering a push or pull retry
+	// DefaultPushOrPullRetryDelay is the time to wait before triggering the first push or pull retry.
+	// The delay doubles with each retry, up to DefaultPushOrPullRetryMaxDelay.
 	DefaultPushOrPullRetryDelay = 5 * time.Second
+	// DefaultPushOrPullRetryMaxDelay is the longest time to wait before triggering a push or pull retry
+	DefaultPushOrPullRetryMaxDelay = 1 * time.Minute
 )
 
+var (
+	// DefaultPushConcurrency is the largest number of blobs pushed at once.  Zero leaves it to
+	// containers/image, which pushes up to six at once.
+	DefaultPushConcurrency = 0
+	// registryPushConcurrency overrides DefaultPushConcurrency for the registries it names, such as
+	// rate-limited ones.
+	registryPushConcurrency = map[string]int{}
+)
+
+const (
+	// imageFormatDocker commits and pushes images with Docker schema2
+	// manifests.
+	imageFormatDocker = "docker"
+	// imageFormatOCI commits and pushes images with OCI image manifests, and
+	// manifest lists as OCI image indexes.
+	imageFormatOCI = "oci"
+)
+
+// DefaultImageFormat is the format of the images which the builder commits
+// and pushes.
+var DefaultImageFormat = imageFormatDocker
+
+const (
+	// imageCompressionGzip compresses pushed layers with gzip.
+	imageCompressionGzip = "gzip"
+	// imageCompressionZstd compresses pushed layers with zstd, which only
+	// OCI images can describe.
+	imageCompressionZstd = "zstd"
+	// imageCompressionZstdChunked is zstd with a table of contents for
+	// partial pulls, which the vendored containers/image cannot produce.
+	imageCompressionZstdChunked = "zstd:chunked"
+)
+
+var (
+	// DefaultImageCompression is the algorithm which compresses the layers
+	// of pushed images.
+	DefaultImageCompression = imageCompressionGzip
+	// imageCompressionLevel is the level of DefaultImageCompression, or nil
+	// for the algorithm's default.
+	imageCompressionLevel *int
+)
+
+// HermeticBuild disables networking in the containers which run the steps of
+// the build, after its sources and base images have been fetched.
+var HermeticBuild = false
+
+// pushOrPullRetryJitter is the largest fraction of a retry delay which is
+// randomly added to it, so that builds which failed together do not all
+// retry at once.
+const pushOrPullRetryJitter = 0.2
+
 // DockerClient is an interface to the Docker client that contains
 // the methods used by the common builder
 type DockerClient interface {
@@ -38,6 +95,122 @@ type DockerClient interface {
 	TagImage(name string, opts docker.TagImageOptions) error
 }
 
+// ManifestListInstance describes an image pushed for one of the platforms of
+// a multi-platform build.
+type ManifestListInstance struct {
+	// Platform is the os/arch[/variant] the image was built for
+	Platform string
+	// Digest is the digest of the image's manifest in the registry
+	Digest string
+}
+
+// manifestListPusher is implemented by DockerClients which can combine images
+// pushed for several platforms into a manifest list.
+type manifestListPusher interface {
+	PushManifestList(name string, instances []ManifestListInstance, auth docker.AuthConfiguration) (string, error)
+}
+
+// platformResolver is implemented by DockerClients which can find the image
+// for a platform among the images of a manifest list in a registry.
+type platformResolver interface {
+	// ResolvePlatformImage returns the digest reference of the image for
+	// platform of the image name in its registry.
+	ResolvePlatformImage(name, platform string, searchPaths []string) (string, error)
+}
+
+// BuildMount is a directory which is bind mounted read-only into the
+// containers that run the RUN instructions of a build, without being
+// committed to the image.
+type BuildMount struct {
+	Source      string
+	Destination string
+}
+
+// mountingBuilder is implemented by DockerClients which can provide
+// BuildMounts to the RUN instructions of a build.
+type mountingBuilder interface {
+	BuildImageWithMounts(opts docker.BuildImageOptions, mounts []BuildMount) error
+}
+
+// layerCachePusher is implemented by DockerClients which can push the images
+// making up the layers of a built image to a registry, so that later builds
+// can use them as a layer cache.
+type layerCachePusher interface {
+	PushLayerCache(name, cacheRef string, auth docker.AuthConfiguration) error
+}
+
+// imageMounter is implemented by DockerClients which can mount the root
+// filesystem of a local image so that its contents can be inspected.
+type imageMounter interface {
+	// MountImage returns the directory at which the image name is mounted
+	// and a function which unmounts it.
+	MountImage(name string) (string, func(), error)
+}
+
+// containerRunner is implemented by DockerClients which can run a command in
+// a container of a local image, much like 'docker run --rm', such as the
+// post-commit hook of a build.
+type containerRunner interface {
+	// RunContainer runs the container described by createOpts, streaming
+	// its output to the writers of attachOpts, and returns an error if it
+	// exits with a non-zero status.
+	RunContainer(createOpts docker.CreateContainerOptions, attachOpts docker.AttachToContainerOptions) error
+}
+
+// Artifact is a document, such as an SBOM, which is pushed to a registry
+// alongside the image it describes.
+type Artifact struct {
+	// MediaType is the media type of Content
+	MediaType string
+	// Content is the document itself
+	Content []byte
+	// Annotations are added to the manifest of the artifact
+	Annotations map[string]string
+	// LayerAnnotations are added to the descriptor of Content in the
+	// manifest, where cosign expects signatures
+	LayerAnnotations map[string]string
+}
+
+// artifactPusher is implemented by DockerClients which can push an Artifact
+// referring to an image which has been pushed.
+type artifactPusher interface {
+	// PushArtifact pushes artifact to the repository of name, tagged with
+	// tag, as an OCI manifest whose subject is the image with the given
+	// digest, and returns the digest of the manifest.
+	PushArtifact(name, subjectDigest, tag string, artifact Artifact, auth docker.AuthConfiguration) (string, error)
+}
+
+// artifactTag returns the tag under which the artifact of the given kind,
+// such as "sbom", for the image with digest is stored, following the
+// convention used by cosign for registries without the OCI referrers API.
+func artifactTag(digest, kind string) string {
+	return strings.Replace(digest, ":", "-", 1) + "." + kind
+}
+
+// layerCacheTag returns the name under which the index'th image, counting
+// from one, of the layer cache at cacheRef is stored.  A cacheRef without a
+// tag uses "cache".
+func layerCacheTag(cacheRef string, index int) string {
+	repository, tag := docker.ParseRepositoryTag(cacheRef)
+	if len(tag) == 0 {
+		tag = "cache"
+	}
+	return fmt.Sprintf("%s:%s-%d", repository, tag, index)
+}
+
+// parsePlatform splits a platform of the form os/arch[/variant] into its
+// components.
+func parsePlatform(platform string) (platformOS, arch, variant string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", "", fmt.Errorf("invalid platform %q: must be of the form os/arch[/variant]", platform)
+	}
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	return parts[0], parts[1], variant, nil
+}
+
 func unwrapUnauthorizedError(err error) error {
 	cause := errors.Cause(err)
 	if _, ok := cause.(idocker.ErrUnauthorizedForCredentials); ok {
@@ -51,13 +224,18 @@ func unwrapUnauthorizedError(err error) error {
 func retryImageAction(actionName string, action func() error) error {
 	var err error
 
-	for retries := 0; retries <= DefaultPushOrPullRetryCount; retries++ {
+	retries := 0
+	for ; ; retries++ {
 		err = action()
 		if err == nil {
 			return nil
 		}
-		log.V(0).Infof("Warning: %s failed, retrying in %s ...", actionName, DefaultPushOrPullRetryDelay)
-		time.Sleep(DefaultPushOrPullRetryDelay)
+		if retries == DefaultPushOrPullRetryCount || !isTransientImageError(err) {
+			break
+		}
+		delay := wait.Jitter(retryDelay(retries), pushOrPullRetryJitter)
+		log.V(0).Infof("Warning: %s failed, retrying in %s ...", actionName, delay.Round(time.Millisecond))
+		time.Sleep(delay)
 	}
 
 	if errs, ok := errors.Cause(err).(errcode.Errors); ok {
@@ -80,7 +258,213 @@ func retryImageAction(actionName string, action func() error) error {
 
 	err = unwrapUnauthorizedError(err)
 
-	return fmt.Errorf("After retrying %d times, %s image still failed due to error: %v", DefaultPushOrPullRetryCount, actionName, err)
+	return fmt.Errorf("After retrying %d times, %s image still failed due to error: %v", retries, actionName, err)
+}
+
+// pushWithRefreshedAuth pushes name with push, using auth, and when the
+// registry refuses the credentials, pushes once more with new ones if they
+// come from a credential helper, whose short-lived tokens may expire during
+// a long build or push.
+func pushWithRefreshedAuth(name string, auth docker.AuthConfiguration, push func(docker.AuthConfiguration) (string, error)) (string, error) {
+	digest, err := push(auth)
+	if err == nil || isTransientImageError(err) {
+		return digest, err
+	}
+	refreshed, ok := refreshDockerAuth(name, auth)
+	if !ok {
+		return digest, err
+	}
+	return push(refreshed)
+}
+
+// refreshDockerAuth returns new push credentials for name from its
+// credential helper, if it has one.
+var refreshDockerAuth = func(name string, auth docker.AuthConfiguration) (docker.AuthConfiguration, bool) {
+	return dockercfg.NewHelper().RefreshDockerAuth(name, dockercfg.PushAuthType, auth)
+}
+
+// retryDelay returns the time to wait before the retry following the given
+// number of earlier retries, before jitter is added.
+func retryDelay(retries int) time.Duration {
+	delay := DefaultPushOrPullRetryDelay
+	for i := 0; i < retries && delay < DefaultPushOrPullRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > DefaultPushOrPullRetryMaxDelay {
+		delay = DefaultPushOrPullRetryMaxDelay
+	}
+	return delay
+}
+
+// isTransientImageError returns false if err is one which retrying a push or
+// pull cannot fix, because the registry refused the credentials or access to
+// the repository.  Other errors, such as 5xx responses and timeouts, may
+// clear up.  A retried push does not upload again the blobs that an earlier
+// attempt completed, since they are found in the registry.
+func isTransientImageError(err error) bool {
+	cause := errors.Cause(err)
+	if _, ok := cause.(idocker.ErrUnauthorizedForCredentials); ok {
+		return false
+	}
+	var errs errcode.Errors
+	switch e := cause.(type) {
+	case errcode.Errors:
+		errs = e
+	case errcode.Error:
+		errs = errcode.Errors{e}
+	}
+	for i := range errs {
+		if registryError, ok := errs[i].(errcode.Error); ok {
+			switch registryError.Code {
+			case errcode.ErrorCodeUnauthorized, errcode.ErrorCodeDenied:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pushConcurrency returns the largest number of blobs pushed to registry at
+// once, or zero if it is not limited.
+func pushConcurrency(registry string) int {
+	if limit, ok := registryPushConcurrency[registry]; ok {
+		return limit
+	}
+	return DefaultPushConcurrency
+}
+
+// ConfigureImageFormat applies the format of the images committed and pushed
+// by the build requested by the build strategy's environment, if any.
+func ConfigureImageFormat(build *buildapiv1.Build) error {
+	value, _ := buildStrategyEnv(build, builderutil.ImageFormat)
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case "":
+	case imageFormatDocker, imageFormatOCI:
+		DefaultImageFormat = format
+	default:
+		return fmt.Errorf("invalid %s value %q, expected %q or %q", builderutil.ImageFormat, value, imageFormatDocker, imageFormatOCI)
+	}
+	return nil
+}
+
+// ConfigureImageCompression applies the compression of pushed layers, and its
+// level, requested by the build strategy's environment, if any.  It must be
+// called after ConfigureImageFormat, since zstd requires OCI images.
+func ConfigureImageCompression(build *buildapiv1.Build) error {
+	value, _ := buildStrategyEnv(build, builderutil.ImageCompression)
+	switch algorithm := strings.ToLower(strings.TrimSpace(value)); algorithm {
+	case "":
+	case imageCompressionGzip:
+		DefaultImageCompression = algorithm
+	case imageCompressionZstd:
+		if DefaultImageFormat != imageFormatOCI {
+			return fmt.Errorf("%s %q requires %s %q: Docker images cannot have zstd compressed layers", builderutil.ImageCompression, value, builderutil.ImageFormat, imageFormatOCI)
+		}
+		DefaultImageCompression = algorithm
+	case imageCompressionZstdChunked:
+		return fmt.Errorf("%s %q is not supported by this builder, use %q instead", builderutil.ImageCompression, value, imageCompressionZstd)
+	default:
+		return fmt.Errorf("invalid %s value %q, expected %q or %q", builderutil.ImageCompression, value, imageCompressionGzip, imageCompressionZstd)
+	}
+
+	value, ok := buildStrategyEnv(build, builderutil.ImageCompressionLevel)
+	if !ok || len(value) == 0 {
+		return nil
+	}
+	min, max := 1, 9
+	if DefaultImageCompression == imageCompressionZstd {
+		max = 22
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < min || level > max {
+		return fmt.Errorf("invalid %s value %q: %s levels range from %d to %d", builderutil.ImageCompressionLevel, value, DefaultImageCompression, min, max)
+	}
+	imageCompressionLevel = &level
+	return nil
+}
+
+// ConfigureHermeticBuild applies the hermetic build mode requested by the
+// build strategy's environment, if any.
+func ConfigureHermeticBuild(build *buildapiv1.Build) error {
+	value, ok := buildStrategyEnv(build, builderutil.Hermetic)
+	if !ok || len(value) == 0 {
+		return nil
+	}
+	hermetic, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q: %v", builderutil.Hermetic, value, err)
+	}
+	HermeticBuild = hermetic
+	return nil
+}
+
+// GetIsolationSpec returns the isolation of RUN instructions requested by the
+// build strategy's environment, or else by the builder pod's.
+func GetIsolationSpec(build *buildapiv1.Build) string {
+	if value, ok := buildStrategyEnv(build, builderutil.Isolation); ok && len(value) > 0 {
+		return value
+	}
+	return os.Getenv(builderutil.Isolation)
+}
+
+// ConfigurePushConcurrency applies the number of blobs pushed at once, and
+// the overrides for individual registries, requested by the build strategy's
+// environment, if any.
+func ConfigurePushConcurrency(build *buildapiv1.Build) error {
+	if value, ok := buildStrategyEnv(build, builderutil.PushConcurrency); ok && len(value) > 0 {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid %s value %q: must be a positive integer", builderutil.PushConcurrency, value)
+		}
+		DefaultPushConcurrency = limit
+	}
+	value, _ := buildStrategyEnv(build, builderutil.RegistryPushConcurrency)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return fmt.Errorf("invalid %s entry %q: must be of the form registry=concurrency", builderutil.RegistryPushConcurrency, pair)
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid %s entry %q: the concurrency must be a positive integer", builderutil.RegistryPushConcurrency, pair)
+		}
+		registryPushConcurrency[parts[0]] = limit
+	}
+	return nil
+}
+
+// ConfigureImageRetries applies the retry policy for pushes and pulls
+// requested by the build strategy's environment, if any.
+func ConfigureImageRetries(build *buildapiv1.Build) error {
+	if value, ok := buildStrategyEnv(build, builderutil.ImageRetries); ok && len(value) > 0 {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid %s value %q: must be a non-negative integer", builderutil.ImageRetries, value)
+		}
+		DefaultPushOrPullRetryCount = retries
+	}
+	if value, ok := buildStrategyEnv(build, builderutil.ImageRetryDelay); ok && len(value) > 0 {
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay <= 0 {
+			return fmt.Errorf("invalid %s value %q: must be a positive duration such as 10s", builderutil.ImageRetryDelay, value)
+		}
+		DefaultPushOrPullRetryDelay = delay
+		if DefaultPushOrPullRetryMaxDelay < delay {
+			DefaultPushOrPullRetryMaxDelay = delay
+		}
+	}
+	if value, ok := buildStrategyEnv(build, builderutil.ImageRetryMaxDelay); ok && len(value) > 0 {
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < DefaultPushOrPullRetryDelay {
+			return fmt.Errorf("invalid %s value %q: must be a duration no shorter than the retry delay", builderutil.ImageRetryMaxDelay, value)
+		}
+		DefaultPushOrPullRetryMaxDelay = delay
+	}
+	return nil
 }
 
 func removeImage(client DockerClient, name string) error {
@@ -129,6 +513,11 @@ func readInt64(filePath string) (int64, error) {
 // extractParentFromCgroupMap finds the cgroup parent in the cgroup map
 func extractParentFromCgroupMap(cgMap map[string]string) (string, error) {
 	memory, ok := cgMap["memory"]
+	if !ok {
+		// a cgroup v2 (unified) hierarchy has a single entry, with no
+		// subsystems
+		memory, ok = cgMap[""]
+	}
 	if !ok {
 		return "", fmt.Errorf("could not find memory cgroup subsystem in map %v", cgMap)
 	}
@@ -1,10 +1,17 @@
 package builder
 
 import (
+	"errors"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/docker/distribution/registry/api/errcode"
 	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
 )
 
 type FakeDocker struct {
@@ -118,6 +125,268 @@ type testcase struct {
 	fail   bool
 }
 
+func TestLayerCacheTag(t *testing.T) {
+	tests := map[string]string{
+		"registry.example.com/ns/cache:main": "registry.example.com/ns/cache:main-2",
+		"registry.example.com/ns/cache":      "registry.example.com/ns/cache:cache-2",
+		"registry.example.com:5000/cache":    "registry.example.com:5000/cache:cache-2",
+	}
+	for cacheRef, expected := range tests {
+		if actual := layerCacheTag(cacheRef, 2); actual != expected {
+			t.Errorf("%s: expected %s, got %s", cacheRef, expected, actual)
+		}
+	}
+}
+
+func TestArtifactTag(t *testing.T) {
+	digest := "sha256:3b5d2a1c0e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b"
+	expected := "sha256-3b5d2a1c0e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b.sbom"
+	if actual := artifactTag(digest, "sbom"); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestRetryImageAction(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{
+			name:     "success",
+			attempts: 1,
+		},
+		{
+			name:     "transient",
+			err:      errors.New("received unexpected HTTP status: 503 Service Unavailable"),
+			attempts: DefaultPushOrPullRetryCount + 1,
+		},
+		{
+			name:     "unauthorized",
+			err:      errcode.Errors{errcode.ErrorCodeUnauthorized.WithMessage("authentication required")},
+			attempts: 1,
+		},
+		{
+			name:     "denied",
+			err:      errcode.ErrorCodeDenied.WithMessage("requested access to the resource is denied"),
+			attempts: 1,
+		},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := retryImageAction("Push", func() error {
+			attempts++
+			return test.err
+		})
+		if (err != nil) != (test.err != nil) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, test.attempts, attempts)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(delay, maxDelay time.Duration) {
+		DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay = delay, maxDelay
+	}(DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay)
+	DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay = 5*time.Second, time.Minute
+
+	expected := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for retries, delay := range expected {
+		if actual := retryDelay(retries); actual != delay {
+			t.Errorf("retry %d: expected %s, got %s", retries, delay, actual)
+		}
+	}
+}
+
+func TestConfigureImageRetries(t *testing.T) {
+	defer func(count int, delay, maxDelay time.Duration) {
+		DefaultPushOrPullRetryCount, DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay = count, delay, maxDelay
+	}(DefaultPushOrPullRetryCount, DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_IMAGE_RETRIES", Value: "5"},
+			{Name: "BUILD_IMAGE_RETRY_DELAY", Value: "2s"},
+			{Name: "BUILD_IMAGE_RETRY_MAX_DELAY", Value: "30s"},
+		},
+	}
+	if err := ConfigureImageRetries(build); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if DefaultPushOrPullRetryCount != 5 || DefaultPushOrPullRetryDelay != 2*time.Second || DefaultPushOrPullRetryMaxDelay != 30*time.Second {
+		t.Errorf("unexpected retry policy %d, %s, %s", DefaultPushOrPullRetryCount, DefaultPushOrPullRetryDelay, DefaultPushOrPullRetryMaxDelay)
+	}
+
+	for _, env := range []corev1.EnvVar{
+		{Name: "BUILD_IMAGE_RETRIES", Value: "-1"},
+		{Name: "BUILD_IMAGE_RETRY_DELAY", Value: "soon"},
+		{Name: "BUILD_IMAGE_RETRY_MAX_DELAY", Value: "1s"},
+	} {
+		build.Spec.Strategy.SourceStrategy.Env = []corev1.EnvVar{env}
+		if err := ConfigureImageRetries(build); err == nil {
+			t.Errorf("expected an error for %s=%s", env.Name, env.Value)
+		}
+	}
+}
+
+func TestConfigureImageFormat(t *testing.T) {
+	defer func(format string) {
+		DefaultImageFormat = format
+	}(DefaultImageFormat)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	if err := ConfigureImageFormat(build); err != nil || DefaultImageFormat != "docker" {
+		t.Errorf("expected the docker format by default, got %q, %v", DefaultImageFormat, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_IMAGE_FORMAT", Value: "OCI"}}
+	if err := ConfigureImageFormat(build); err != nil || DefaultImageFormat != "oci" {
+		t.Errorf("expected the oci format, got %q, %v", DefaultImageFormat, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_IMAGE_FORMAT", Value: "v2s1"}}
+	if err := ConfigureImageFormat(build); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestConfigureImageCompression(t *testing.T) {
+	defer func(format, algorithm string, level *int) {
+		DefaultImageFormat, DefaultImageCompression, imageCompressionLevel = format, algorithm, level
+	}(DefaultImageFormat, DefaultImageCompression, imageCompressionLevel)
+
+	tests := []struct {
+		name              string
+		format            string
+		env               []corev1.EnvVar
+		expectCompression string
+		expectLevel       int
+		expectErr         bool
+	}{
+		{
+			name:              "default",
+			format:            "docker",
+			expectCompression: "gzip",
+		},
+		{
+			name:              "gzip level",
+			format:            "docker",
+			env:               []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION_LEVEL", Value: "9"}},
+			expectCompression: "gzip",
+			expectLevel:       9,
+		},
+		{
+			name:              "zstd level",
+			format:            "oci",
+			env:               []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION", Value: "zstd"}, {Name: "BUILD_IMAGE_COMPRESSION_LEVEL", Value: "19"}},
+			expectCompression: "zstd",
+			expectLevel:       19,
+		},
+		{
+			name:      "zstd docker image",
+			format:    "docker",
+			env:       []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION", Value: "zstd"}},
+			expectErr: true,
+		},
+		{
+			name:      "zstd:chunked",
+			format:    "oci",
+			env:       []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION", Value: "zstd:chunked"}},
+			expectErr: true,
+		},
+		{
+			name:      "unknown",
+			format:    "oci",
+			env:       []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION", Value: "xz"}},
+			expectErr: true,
+		},
+		{
+			name:      "gzip level out of range",
+			format:    "docker",
+			env:       []corev1.EnvVar{{Name: "BUILD_IMAGE_COMPRESSION_LEVEL", Value: "19"}},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		DefaultImageFormat, DefaultImageCompression, imageCompressionLevel = test.format, "gzip", nil
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		err := ConfigureImageCompression(build)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if DefaultImageCompression != test.expectCompression {
+			t.Errorf("%s: expected compression %q, got %q", test.name, test.expectCompression, DefaultImageCompression)
+		}
+		level := 0
+		if imageCompressionLevel != nil {
+			level = *imageCompressionLevel
+		}
+		if level != test.expectLevel {
+			t.Errorf("%s: expected level %d, got %d", test.name, test.expectLevel, level)
+		}
+	}
+}
+
+func TestConfigurePushConcurrency(t *testing.T) {
+	defer func(limit int, registries map[string]int) {
+		DefaultPushConcurrency, registryPushConcurrency = limit, registries
+	}(DefaultPushConcurrency, registryPushConcurrency)
+	DefaultPushConcurrency, registryPushConcurrency = 0, map[string]int{}
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_PUSH_CONCURRENCY", Value: "4"},
+			{Name: "BUILD_REGISTRY_PUSH_CONCURRENCY", Value: "docker.io=1, registry.example.com:5000=2"},
+		},
+	}
+	if err := ConfigurePushConcurrency(build); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for registry, expected := range map[string]int{"docker.io": 1, "registry.example.com:5000": 2, "quay.io": 4} {
+		if actual := pushConcurrency(registry); actual != expected {
+			t.Errorf("%s: expected concurrency %d, got %d", registry, expected, actual)
+		}
+	}
+
+	for _, env := range []corev1.EnvVar{
+		{Name: "BUILD_PUSH_CONCURRENCY", Value: "0"},
+		{Name: "BUILD_REGISTRY_PUSH_CONCURRENCY", Value: "quay.io"},
+		{Name: "BUILD_REGISTRY_PUSH_CONCURRENCY", Value: "quay.io=many"},
+	} {
+		build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{env}
+		if err := ConfigurePushConcurrency(build); err == nil {
+			t.Errorf("expected an error for %s=%s", env.Name, env.Value)
+		}
+	}
+}
+
+func TestGetIsolationSpec(t *testing.T) {
+	defer os.Setenv("BUILD_ISOLATION", os.Getenv("BUILD_ISOLATION"))
+	os.Setenv("BUILD_ISOLATION", "oci")
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{}
+	if spec := GetIsolationSpec(build); spec != "oci" {
+		t.Errorf("expected the builder's isolation, got %q", spec)
+	}
+	build.Spec.Strategy.SourceStrategy.Env = []corev1.EnvVar{{Name: "BUILD_ISOLATION", Value: "chroot"}}
+	if spec := GetIsolationSpec(build); spec != "chroot" {
+		t.Errorf("expected the build's isolation, got %q", spec)
+	}
+}
+
 func TestCGroupParentExtraction(t *testing.T) {
 	tcs := []testcase{
 		{
@@ -172,6 +441,13 @@ func TestCGroupParentExtraction(t *testing.T) {
 			},
 			expect: "/kubepods.slice/kubepods-podd0d034ed_5204_11e7_9710_507b9d27b5d9.slice",
 		},
+		{
+			name: "cgroupv2-systemd",
+			input: map[string]string{
+				"": "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod8d369e32_521b_11e7_8df4_507b9d27b5d9.slice/crio-fbf6fe5e4effd80b6a9b3318dd0e5f538b9c4ba8918c174768720c83b338a41f.scope",
+			},
+			expect: "kubepods-burstable-pod8d369e32_521b_11e7_8df4_507b9d27b5d9.slice",
+		},
 		{
 			name: "no-memory-entry",
 			input: map[string]string{
@@ -214,3 +490,60 @@ func TestCGroupParentExtraction(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigureHermeticBuild(t *testing.T) {
+	defer func(hermetic bool) {
+		HermeticBuild = hermetic
+	}(HermeticBuild)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	if err := ConfigureHermeticBuild(build); err != nil || HermeticBuild {
+		t.Errorf("expected networked builds by default, got %v, %v", HermeticBuild, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_HERMETIC", Value: "true"}}
+	if err := ConfigureHermeticBuild(build); err != nil || !HermeticBuild {
+		t.Errorf("expected a hermetic build, got %v, %v", HermeticBuild, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_HERMETIC", Value: "offline"}}
+	if err := ConfigureHermeticBuild(build); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
+
+func TestPushWithRefreshedAuth(t *testing.T) {
+	defer func(refresh func(string, docker.AuthConfiguration) (docker.AuthConfiguration, bool)) {
+		refreshDockerAuth = refresh
+	}(refreshDockerAuth)
+	refreshable := true
+	refreshDockerAuth = func(name string, auth docker.AuthConfiguration) (docker.AuthConfiguration, bool) {
+		return docker.AuthConfiguration{Username: "AWS", Password: "fresh"}, refreshable
+	}
+
+	unauthorized := errcode.Errors{errcode.ErrorCodeUnauthorized.WithMessage("token expired")}
+	var passwords []string
+	push := func(auth docker.AuthConfiguration) (string, error) {
+		passwords = append(passwords, auth.Password)
+		if auth.Password == "expired" {
+			return "", unauthorized
+		}
+		return "sha256:abc", nil
+	}
+	expired := docker.AuthConfiguration{Username: "AWS", Password: "expired"}
+
+	if digest, err := pushWithRefreshedAuth("registry.example.com/app", expired, push); err != nil || digest != "sha256:abc" {
+		t.Errorf("expected the push to succeed with refreshed credentials, got %q, %v", digest, err)
+	}
+	if !reflect.DeepEqual(passwords, []string{"expired", "fresh"}) {
+		t.Errorf("unexpected pushes %v", passwords)
+	}
+
+	passwords = nil
+	refreshable = false
+	if _, err := pushWithRefreshedAuth("registry.example.com/app", expired, push); err == nil {
+		t.Errorf("expected the push to fail without a credential helper")
+	}
+	if !reflect.DeepEqual(passwords, []string{"expired"}) {
+		t.Errorf("unexpected pushes %v", passwords)
+	}
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+const (
+	// entitlementSecretName is the name of the build input secret holding
+	// the entitlement certificates, and their keys, of a Red Hat
+	// subscription.
+	entitlementSecretName = "etc-pki-entitlement"
+	// rhsmConfigMapName is the name of the build input configmap holding the
+	// rhsm.conf, and the ca directory, of the subscription.
+	rhsmConfigMapName = "rhsm-conf"
+	// entitlementMountPath and rhsmMountPath are where subscription-manager
+	// looks for the entitlement and the configuration of its host when it
+	// runs in a container, such as a RUN instruction.
+	entitlementMountPath = "/run/secrets/etc-pki-entitlement"
+	rhsmMountPath        = "/run/secrets/rhsm"
+)
+
+var (
+	// builderEntitlementDir and builderRHSMDir hold the entitlement and
+	// configuration of the builder's host, or of the build volumes mounted
+	// at the same locations, which are used when the build has no input
+	// secret or configmap for them.
+	builderEntitlementDir = "/etc/pki/entitlement"
+	builderRHSMDir        = "/etc/rhsm"
+)
+
+// getEntitlementMounts returns the mounts which make a Red Hat subscription
+// available to the RUN instructions, and so the assemble script, of a build,
+// so that dnf and yum can install subscription-only RPMs without the
+// entitlement being copied into the build context or committed to the
+// image.  The entitlement is taken from the build's etc-pki-entitlement
+// input secret or else from /etc/pki/entitlement in the builder, and its
+// configuration from the build's rhsm-conf input configmap or else from
+// /etc/rhsm in the builder.  No mounts are returned without an entitlement.
+func getEntitlementMounts(build *buildapiv1.Build) []BuildMount {
+	entitlement, ok := inputSecretDir(build, entitlementSecretName)
+	if !ok {
+		entitlement = builderEntitlementDir
+	}
+	if !hasEntitlementCertificates(entitlement) {
+		return nil
+	}
+	log.V(0).Infof("Mounting the subscription entitlement from %s at %s", entitlement, entitlementMountPath)
+	mounts := []BuildMount{{Source: entitlement, Destination: entitlementMountPath}}
+
+	rhsm, ok := inputConfigMapDir(build, rhsmConfigMapName)
+	if !ok {
+		rhsm = builderRHSMDir
+	}
+	if st, err := os.Stat(rhsm); err == nil && st.IsDir() {
+		log.V(2).Infof("Mounting the subscription configuration from %s at %s", rhsm, rhsmMountPath)
+		mounts = append(mounts, BuildMount{Source: rhsm, Destination: rhsmMountPath})
+	}
+	return mounts
+}
+
+// hasEntitlementCertificates returns whether dir holds an entitlement
+// certificate, which is named <serial>.pem beside its <serial>-key.pem key.
+func hasEntitlementCertificates(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, "..") || !strings.HasSuffix(name, ".pem") || strings.HasSuffix(name, "-key.pem") {
+			continue
+		}
+		key := strings.TrimSuffix(name, ".pem") + "-key.pem"
+		if _, err := os.Stat(filepath.Join(dir, key)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestHasEntitlementCertificates(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		expect bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "certificate and key",
+			files:  []string{"1234.pem", "1234-key.pem"},
+			expect: true,
+		},
+		{
+			name:  "certificate without key",
+			files: []string{"1234.pem"},
+		},
+		{
+			name:  "key without certificate",
+			files: []string{"1234-key.pem"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "entitlement")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, file := range test.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("pem"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := hasEntitlementCertificates(dir); got != test.expect {
+				t.Errorf("expected %v, got %v", test.expect, got)
+			}
+		})
+	}
+	if hasEntitlementCertificates("/nonexistent") {
+		t.Errorf("expected no entitlement in a missing directory")
+	}
+}
+
+func TestGetEntitlementMounts(t *testing.T) {
+	defer func(entitlement, rhsm string) {
+		builderEntitlementDir, builderRHSMDir = entitlement, rhsm
+	}(builderEntitlementDir, builderRHSMDir)
+
+	tmp, err := ioutil.TempDir("", "entitlement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	builderEntitlementDir = filepath.Join(tmp, "entitlement")
+	builderRHSMDir = filepath.Join(tmp, "rhsm")
+
+	build := &buildapiv1.Build{}
+	if mounts := getEntitlementMounts(build); len(mounts) != 0 {
+		t.Errorf("expected no mounts without an entitlement, got %v", mounts)
+	}
+
+	if err := os.MkdirAll(builderEntitlementDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"1234.pem", "1234-key.pem"} {
+		if err := ioutil.WriteFile(filepath.Join(builderEntitlementDir, file), []byte("pem"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []BuildMount{{Source: builderEntitlementDir, Destination: "/run/secrets/etc-pki-entitlement"}}
+	if mounts := getEntitlementMounts(build); !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("expected mounts %v, got %v", expected, mounts)
+	}
+
+	if err := os.MkdirAll(builderRHSMDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, BuildMount{Source: builderRHSMDir, Destination: "/run/secrets/rhsm"})
+	if mounts := getEntitlementMounts(build); !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("expected mounts %v, got %v", expected, mounts)
+	}
+
+	// an input secret which is not mounted in this test holds no
+	// entitlement, and the builder's is not used in its place
+	build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{
+		{Secret: corev1.LocalObjectReference{Name: "etc-pki-entitlement"}},
+	}
+	if mounts := getEntitlementMounts(build); len(mounts) != 0 {
+		t.Errorf("expected no mounts for an empty input secret, got %v", mounts)
+	}
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// readInputKey returns the contents of the file holding key in dir, the
+// directory at which an input secret or configmap is mounted.
+var readInputKey = func(dir, key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(dir, key))
+}
+
+// ResolveBuildEnvReferences sets the values of the variables of the build
+// strategy's environment, and of its build args, which refer to a key of an
+// input secret or configmap of the build to the contents of that key in the
+// pod, where the input is mounted, so that sensitive values such as those
+// used by assemble scripts never need to be held by the Build itself.
+//
+// A variable which already has a value keeps it, and as for any variable of
+// the strategy's environment, the last one with a name takes precedence over
+// earlier ones and over the environment of the build's source.  A reference
+// which is optional is left empty if its input or key is missing.  The
+// values read from secrets are masked in the builder's output.
+func ResolveBuildEnvReferences(build *buildapiv1.Build) error {
+	lists := [][]corev1.EnvVar{buildStrategyEnvVars(build)}
+	if s := build.Spec.Strategy.DockerStrategy; s != nil {
+		lists = append(lists, s.BuildArgs)
+	}
+	for _, list := range lists {
+		for i := range list {
+			if err := resolveEnvReference(build, &list[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveEnvReference sets the value of env from the input secret or
+// configmap key it refers to, if it has no value of its own.
+func resolveEnvReference(build *buildapiv1.Build, env *corev1.EnvVar) error {
+	if env.ValueFrom == nil || len(env.Value) > 0 {
+		return nil
+	}
+	var kind, name, key, dir string
+	var optional, isInput bool
+	switch ref := env.ValueFrom; {
+	case ref.SecretKeyRef != nil:
+		kind, name, key = "secret", ref.SecretKeyRef.Name, ref.SecretKeyRef.Key
+		optional = ref.SecretKeyRef.Optional != nil && *ref.SecretKeyRef.Optional
+		dir, isInput = inputSecretDir(build, name)
+	case ref.ConfigMapKeyRef != nil:
+		kind, name, key = "configmap", ref.ConfigMapKeyRef.Name, ref.ConfigMapKeyRef.Key
+		optional = ref.ConfigMapKeyRef.Optional != nil && *ref.ConfigMapKeyRef.Optional
+		dir, isInput = inputConfigMapDir(build, name)
+	default:
+		return nil
+	}
+	if !isInput {
+		if optional {
+			log.V(2).Infof("Leaving %s empty, the %s %s is not an input of the build", env.Name, kind, name)
+			return nil
+		}
+		return fmt.Errorf("unable to resolve the value of %s: the %s %s is not an input of the build, so it is not mounted into the build pod", env.Name, kind, name)
+	}
+	data, err := readInputKey(dir, key)
+	if err != nil {
+		if os.IsNotExist(err) && optional {
+			log.V(2).Infof("Leaving %s empty, the %s %s has no key %s", env.Name, kind, name, key)
+			return nil
+		}
+		return fmt.Errorf("unable to resolve the value of %s from key %s of the %s %s: %v", env.Name, key, kind, name, err)
+	}
+	env.Value = string(data)
+	if kind == "secret" {
+		utillog.AddSecrets(env.Value)
+	}
+	log.V(0).Infof("Resolved %s from key %s of the %s %s", env.Name, key, kind, name)
+	return nil
+}
@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+func TestResolveBuildEnvReferences(t *testing.T) {
+	root, err := ioutil.TempDir("", "env-references")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, filepath.Join(root, secretBuildSourceBaseMountPath, "creds", "token"), "s3cr3t-t0ken\n")
+	writeTestFile(t, filepath.Join(root, configMapBuildSourceBaseMountPath, "settings", "mirror"), "https://mirror.example.com")
+	defer func(f func(string, string) ([]byte, error)) { readInputKey = f }(readInputKey)
+	readInputKey = func(dir, key string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(root, dir, key))
+	}
+
+	optional := true
+	secretRef := func(name, key string, optional *bool) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key, Optional: optional}}
+	}
+	configMapRef := func(name, key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}}
+	}
+	tests := []struct {
+		name   string
+		env    []corev1.EnvVar
+		expect []string
+		err    bool
+	}{
+		{
+			name: "secret and configmap",
+			env: []corev1.EnvVar{
+				{Name: "TOKEN", ValueFrom: secretRef("creds", "token", nil)},
+				{Name: "MIRROR", ValueFrom: configMapRef("settings", "mirror")},
+				{Name: "PLAIN", Value: "value"},
+			},
+			expect: []string{"s3cr3t-t0ken\n", "https://mirror.example.com", "value"},
+		},
+		{
+			name:   "value already set",
+			env:    []corev1.EnvVar{{Name: "TOKEN", Value: "resolved", ValueFrom: secretRef("creds", "token", nil)}},
+			expect: []string{"resolved"},
+		},
+		{
+			name:   "optional",
+			env:    []corev1.EnvVar{{Name: "A", ValueFrom: secretRef("other", "token", &optional)}, {Name: "B", ValueFrom: secretRef("creds", "missing", &optional)}},
+			expect: []string{"", ""},
+		},
+		{name: "not an input", env: []corev1.EnvVar{{Name: "TOKEN", ValueFrom: secretRef("other", "token", nil)}}, err: true},
+		{name: "missing key", env: []corev1.EnvVar{{Name: "TOKEN", ValueFrom: secretRef("creds", "missing", nil)}}, err: true},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{{Secret: corev1.LocalObjectReference{Name: "creds"}}}
+		build.Spec.Source.ConfigMaps = []buildapiv1.ConfigMapBuildSource{{ConfigMap: corev1.LocalObjectReference{Name: "settings"}}}
+		build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{Env: test.env}
+		err := ResolveBuildEnvReferences(build)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		var values []string
+		for _, env := range build.Spec.Strategy.SourceStrategy.Env {
+			values = append(values, env.Value)
+		}
+		if !reflect.DeepEqual(values, test.expect) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expect, values)
+		}
+	}
+	if out := utillog.Scrub("token is s3cr3t-t0ken"); out == "token is s3cr3t-t0ken" {
+		t.Errorf("expected the value of the secret to be masked, got %q", out)
+	}
+}
+
+func TestResolveBuildEnvReferencesBuildArgs(t *testing.T) {
+	defer func(f func(string, string) ([]byte, error)) { readInputKey = f }(readInputKey)
+	readInputKey = func(dir, key string) ([]byte, error) {
+		return []byte(filepath.Join(dir, key)), nil
+	}
+	build := &buildapiv1.Build{}
+	build.Spec.Source.ConfigMaps = []buildapiv1.ConfigMapBuildSource{{ConfigMap: corev1.LocalObjectReference{Name: "settings"}}}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		BuildArgs: []corev1.EnvVar{{Name: "VERSION", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "version"}}}},
+	}
+	if err := ResolveBuildEnvReferences(build); err != nil {
+		t.Fatal(err)
+	}
+	if expect := filepath.Join(configMapBuildSourceBaseMountPath, "settings", "version"); build.Spec.Strategy.DockerStrategy.BuildArgs[0].Value != expect {
+		t.Errorf("expected %s, got %s", expect, build.Spec.Strategy.DockerStrategy.BuildArgs[0].Value)
+	}
+}
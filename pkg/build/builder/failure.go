@@ -0,0 +1,181 @@
+package builder
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// FailureCode is a stable, machine-readable code for why a build failed,
+// which tooling may use to decide whether to retry it without parsing the
+// build's logs.
+type FailureCode string
+
+// The codes of build failures.  They are part of the builder's interface and
+// must not change.
+const (
+	FailureGitAuthentication     FailureCode = "GitAuthenticationFailed"
+	FailureGitRepositoryNotFound FailureCode = "GitRepositoryNotFound"
+	FailureGitHostKey            FailureCode = "GitHostKeyVerificationFailed"
+	FailureSourceVerification    FailureCode = "SourceVerificationFailed"
+	FailureDNSResolution         FailureCode = "DNSResolutionFailed"
+	FailureNetwork               FailureCode = "NetworkError"
+	FailureTLSVerification       FailureCode = "TLSVerificationFailed"
+	FailureOutOfMemory           FailureCode = "OutOfMemory"
+	FailureDiskFull              FailureCode = "DiskFull"
+	FailureRegistryUnauthorized  FailureCode = "RegistryUnauthorized"
+	FailureRegistryRateLimited   FailureCode = "RegistryRateLimited"
+	FailureRegistryUnavailable   FailureCode = "RegistryUnavailable"
+	FailureQuotaExceeded         FailureCode = "QuotaExceeded"
+	FailureImageNotFound         FailureCode = "ImageNotFound"
+	FailureUnknown               FailureCode = "Unknown"
+)
+
+// Retryable returns whether a build which failed with code may succeed if
+// it is run again unchanged.
+func (code FailureCode) Retryable() bool {
+	switch code {
+	case FailureDNSResolution, FailureNetwork, FailureRegistryRateLimited, FailureRegistryUnavailable:
+		return true
+	}
+	return false
+}
+
+// maxFailureDetail is the length to which the detail of a failure written
+// into a build's status is truncated.
+const maxFailureDetail = 512
+
+// failurePatterns map text found in the errors of the builder, of git and of
+// registries to the codes of the failures they describe.  They are matched,
+// in order, against the lowercased error, so more specific patterns come
+// first.
+var failurePatterns = []struct {
+	code     FailureCode
+	patterns []string
+}{
+	{FailureGitHostKey, []string{"host key verification failed", "failed to verify the ssh host key"}},
+	{FailureGitAuthentication, []string{"with provided credentials", "authentication failed for", "could not read username", "could not read password", "permission denied (publickey"}},
+	{FailureGitRepositoryNotFound, []string{"requested repository", "does not appear to be a git repository"}},
+	{FailureDiskFull, []string{"no space left on device", "disk quota exceeded", "not enough disk space"}},
+	{FailureOutOfMemory, []string{"out of memory", "oom-kill", "cannot allocate memory", "signal: killed", "exit status 137", "exit code 137", "exited with 137"}},
+	{FailureRegistryRateLimited, []string{"toomanyrequests", "too many requests", "rate limit"}},
+	{FailureQuotaExceeded, []string{"quota exceeded", "exceeded quota", "exceeds quota"}},
+	{FailureDNSResolution, []string{"no such host", "could not resolve host", "temporary failure in name resolution", "server misbehaving", "dial tcp: lookup "}},
+	{FailureTLSVerification, []string{"x509:", "certificate verify failed", "ssl certificate problem"}},
+	{FailureRegistryUnauthorized, []string{"unauthorized", "authentication required", "denied: requested access", "access to the requested resource is not authorized", "403 forbidden"}},
+	{FailureRegistryUnavailable, []string{"500 internal server error", "502 bad gateway", "503 service unavailable", "504 gateway timeout", "status code 50"}},
+	{FailureImageNotFound, []string{"manifest unknown", "name unknown", "repository does not exist", "no such image", "not found: manifest", "reading manifest"}},
+	{FailureNetwork, []string{"connection refused", "connection reset", "connection timed out", "no route to host", "network is unreachable", "i/o timeout", "tls handshake timeout", "unexpected eof", "could not connect to server", "the remote end hung up unexpectedly", "early eof", "rpc failed"}},
+}
+
+// credentialsInURL matches the user information of a URL, which is removed
+// from the details of failures.
+var credentialsInURL = regexp.MustCompile(`://[^/@\s]+@`)
+
+// countOOMKills returns how many of the builder's processes the kernel has
+// killed for exceeding its memory limit.
+var countOOMKills = oomKills
+
+// oomKillsAtStart is how many of the builder's processes the kernel had
+// killed before the build started, which are no part of its failure.
+var oomKillsAtStart int64
+
+// RecordOOMKillsAtStart records how many of the builder's processes the
+// kernel has killed so far, so that only those it kills afterwards are taken
+// to explain the failure of the build.
+func RecordOOMKillsAtStart() {
+	oomKillsAtStart = countOOMKills()
+}
+
+// classifyFailure returns the code of the failure which err describes.
+func classifyFailure(err error) FailureCode {
+	if err == nil {
+		return ""
+	}
+	switch err.(type) {
+	case gitAuthError:
+		return FailureGitAuthentication
+	case gitNotFoundError:
+		return FailureGitRepositoryNotFound
+	case gitHostKeyError:
+		return FailureGitHostKey
+	case sourceVerificationError:
+		return FailureSourceVerification
+	case *net.DNSError:
+		return FailureDNSResolution
+	}
+	message := strings.ToLower(err.Error())
+	for _, class := range failurePatterns {
+		for _, pattern := range class.patterns {
+			if strings.Contains(message, pattern) {
+				return class.code
+			}
+		}
+	}
+	// an error which says nothing more specific, such as the exit status of
+	// a RUN instruction, most likely followed from the kernel killing part
+	// of the build for exceeding its memory limit, if it did
+	if countOOMKills() > oomKillsAtStart {
+		return FailureOutOfMemory
+	}
+	return FailureUnknown
+}
+
+// failureDetail returns the error's message, without credentials and
+// truncated to fit into the build's status.
+func failureDetail(err error) string {
+	detail := credentialsInURL.ReplaceAllString(strings.TrimSpace(err.Error()), "://redacted@")
+	detail = strings.Join(strings.Fields(detail), " ")
+	if len(detail) > maxFailureDetail {
+		detail = detail[:maxFailureDetail-3] + "..."
+	}
+	return detail
+}
+
+// BuildFailureCode returns the failure code recorded in the build's
+// FailureCodeAnnotation, if any.
+func BuildFailureCode(build *buildapiv1.Build) FailureCode {
+	if build.Status.Phase != buildapiv1.BuildPhaseFailed {
+		return ""
+	}
+	return FailureCode(build.Annotations[builderutil.FailureCodeAnnotation])
+}
+
+// RecordBuildFailure classifies err, which failed the build, and writes the
+// code of the failure into the build's FailureCodeAnnotation, and its detail
+// into the message of the build's status, after the message, keeping its
+// reason.  Builds which were cancelled, or whose failure is already recorded,
+// are left alone.
+func RecordBuildFailure(build *buildapiv1.Build, err error) FailureCode {
+	if err == nil || build.Status.Phase == buildapiv1.BuildPhaseCancelled {
+		return ""
+	}
+	if code := BuildFailureCode(build); len(code) > 0 {
+		return code
+	}
+	code := classifyFailure(err)
+	if build.Status.Phase != buildapiv1.BuildPhaseFailed {
+		build.Status.Phase = buildapiv1.BuildPhaseFailed
+		build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		build.Status.Message = builderutil.StatusMessageGenericBuildFailed
+	}
+	message := strings.TrimSpace(build.Status.Message)
+	if len(message) > 0 {
+		message += " "
+	}
+	build.Status.Message = message + failureDetail(err)
+	setBuildFailureCode(build, code)
+	log.V(0).Infof("Build failure code: %s (retryable: %t)", code, code.Retryable())
+	return code
+}
+
+// setBuildFailureCode records code in the build's FailureCodeAnnotation.
+func setBuildFailureCode(build *buildapiv1.Build, code FailureCode) {
+	if build.Annotations == nil {
+		build.Annotations = map[string]string{}
+	}
+	build.Annotations[builderutil.FailureCodeAnnotation] = string(code)
+}
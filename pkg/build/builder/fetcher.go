@@ -0,0 +1,419 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	idocker "github.com/containers/image/v5/docker"
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+const (
+	// ociSourceScheme prefixes the references of OCI artifacts which are
+	// fetched as source.
+	ociSourceScheme = "oci://"
+	// ociTitleAnnotation names the file that a layer of an OCI artifact
+	// holds.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// orasUnpackAnnotation marks the layers of artifacts pushed by ORAS
+	// which hold a directory as a tar archive.
+	orasUnpackAnnotation = "io.deis.oras.content.unpack"
+)
+
+// SourceFetcher fetches one of the sources of a build into the build
+// directory.
+type SourceFetcher interface {
+	// Name describes the source in logs and errors.
+	Name() string
+	// Fetch fetches the source into dir, and returns what is known about
+	// the commit it was fetched from, if anything.
+	Fetch(ctx context.Context, dir string) (*git.SourceInfo, error)
+}
+
+// NewSourceFetchers returns the fetchers of the sources of build, in the
+// order they are fetched: its git source, then its binary input, streamed
+// from in, then the archive or OCI artifact named by the build strategy's
+// environment, and then the OCI artifacts it places in the build directory.
+func NewSourceFetchers(build *buildapiv1.Build, gitClient GitClient, opts GitCloneOptions, in io.Reader) ([]SourceFetcher, error) {
+	var fetchers []SourceFetcher
+	if build.Spec.Source.Git != nil {
+		fetchers = append(fetchers, &gitSourceFetcher{client: gitClient, source: build.Spec.Source.Git, revision: build.Spec.Revision, opts: opts})
+	}
+	if build.Spec.Source.Binary != nil {
+		maxSize, err := GetBinaryInputMaxSize(build)
+		if err != nil {
+			return nil, err
+		}
+		fetchers = append(fetchers, &binarySourceFetcher{in: in, source: build.Spec.Source.Binary, maxSize: maxSize})
+	}
+	value, _ := buildStrategyEnv(build, builderutil.SourceURL)
+	switch value = strings.TrimSpace(value); {
+	case len(value) == 0:
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		fetchers = append(fetchers, &archiveSourceFetcher{url: value})
+	case strings.HasPrefix(value, ociSourceScheme):
+		fetchers = append(fetchers, &ociSourceFetcher{ref: strings.TrimPrefix(value, ociSourceScheme)})
+	default:
+		return nil, fmt.Errorf("invalid %s value %q: must be an http:// or https:// URL, or an %s reference", builderutil.SourceURL, value, ociSourceScheme)
+	}
+	artifacts, err := getOCIArtifacts(build)
+	if err != nil {
+		return nil, err
+	}
+	return append(fetchers, artifacts...), nil
+}
+
+// getOCIArtifacts returns the fetchers of the OCI artifacts which the build
+// strategy's environment places in the build directory, in the order they
+// are given.  Each artifact must be pinned by its digest.
+func getOCIArtifacts(build *buildapiv1.Build) ([]SourceFetcher, error) {
+	value, ok := buildStrategyEnv(build, builderutil.OCIArtifacts)
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	var fetchers []SourceFetcher
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a reference=path pair", builderutil.OCIArtifacts, value, entry)
+		}
+		ref := strings.TrimPrefix(strings.TrimSpace(parts[0]), ociSourceScheme)
+		named, err := ireference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.OCIArtifacts, value, err)
+		}
+		if _, ok := named.(ireference.Digested); !ok {
+			return nil, fmt.Errorf("invalid %s value %q: %q must be pinned by its digest, as name@sha256:<digest>", builderutil.OCIArtifacts, value, ref)
+		}
+		dest := filepath.Clean(strings.TrimSpace(parts[1]))
+		if filepath.IsAbs(dest) || dest == ".." || strings.HasPrefix(dest, "../") {
+			return nil, fmt.Errorf("invalid %s value %q: the path of %q must be within the build's source", builderutil.OCIArtifacts, value, ref)
+		}
+		fetchers = append(fetchers, &ociSourceFetcher{ref: ref, dest: dest})
+	}
+	return fetchers, nil
+}
+
+// FetchSource empties dir and fetches the source of each of fetchers into
+// it, returning what is known about the commit the first of them which
+// knows was fetched from.  Fetching starts over if it fails transiently.
+func FetchSource(ctx context.Context, fetchers []SourceFetcher, dir string) (*git.SourceInfo, error) {
+	var sourceInfo *git.SourceInfo
+	err := retryTransientFailures(ctx, "fetch the source", func() error {
+		// It is possible for the initcontainer to get restarted, or for an
+		// earlier attempt to have failed part way, thus we must wipe out the
+		// directory if it already exists.
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		os.MkdirAll(dir, 0777)
+
+		sourceInfo = nil
+		for _, fetcher := range fetchers {
+			log.V(4).Infof("Fetching %s", fetcher.Name())
+			info, err := fetcher.Fetch(ctx, dir)
+			if err != nil {
+				return err
+			}
+			if sourceInfo == nil {
+				sourceInfo = info
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sourceInfo, nil
+}
+
+// gitSourceFetcher clones a git source.
+type gitSourceFetcher struct {
+	client   GitClient
+	source   *buildapiv1.GitBuildSource
+	revision *buildapiv1.SourceRevision
+	opts     GitCloneOptions
+}
+
+func (f *gitSourceFetcher) Name() string {
+	return fmt.Sprintf("git repository %s", f.source.URI)
+}
+
+func (f *gitSourceFetcher) Fetch(ctx context.Context, dir string) (*git.SourceInfo, error) {
+	hasGitSource, err := extractGitSource(ctx, f.client, f.source, f.revision, dir, initialURLCheckTimeout, f.opts)
+	if err != nil || !hasGitSource {
+		return nil, err
+	}
+
+	sourceInfo, errs := f.client.GetInfo(dir)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.V(0).Infof("error: Unable to retrieve Git info: %v", e)
+		}
+	}
+	if sourceInfo != nil {
+		sourceInfoJson, err := json.Marshal(*sourceInfo)
+		if err != nil {
+			log.V(0).Infof("error: Unable to serialized git source info: %v", err)
+			return sourceInfo, nil
+		}
+		err = ioutil.WriteFile(filepath.Join(buildWorkDirMount, "sourceinfo.json"), sourceInfoJson, 0644)
+		if err != nil {
+			log.V(0).Infof("error: Unable to serialized git source info: %v", err)
+			return sourceInfo, nil
+		}
+	}
+	return sourceInfo, nil
+}
+
+// binarySourceFetcher extracts the binary input streamed to the build.
+type binarySourceFetcher struct {
+	in      io.Reader
+	source  *buildapiv1.BinaryBuildSource
+	maxSize int64
+}
+
+func (f *binarySourceFetcher) Name() string {
+	return "binary input"
+}
+
+func (f *binarySourceFetcher) Fetch(ctx context.Context, dir string) (*git.SourceInfo, error) {
+	return nil, ExtractInputBinary(f.in, f.source, dir, f.maxSize)
+}
+
+// archiveSourceFetcher downloads a tar, gzipped tar or zip archive, such as
+// a release tarball, and extracts it as it arrives.  If the fragment of its
+// URL is sha256=<hex digest>, the archive must have that digest.
+type archiveSourceFetcher struct {
+	url string
+}
+
+func (f *archiveSourceFetcher) Name() string {
+	if u, err := url.Parse(f.url); err == nil {
+		return fmt.Sprintf("archive %s", redactURL(u))
+	}
+	return "archive"
+}
+
+// redactURL returns u without the credentials it may hold, to be logged.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.Fragment = ""
+	return redacted.String()
+}
+
+func (f *archiveSourceFetcher) Fetch(ctx context.Context, dir string) (*git.SourceInfo, error) {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.SourceURL, f.url, err)
+	}
+	expected := ""
+	if len(u.Fragment) > 0 {
+		if !strings.HasPrefix(u.Fragment, "sha256=") {
+			return nil, fmt.Errorf("invalid %s value %q: the fragment must be sha256=<digest>", builderutil.SourceURL, f.url)
+		}
+		expected = strings.ToLower(strings.TrimPrefix(u.Fragment, "sha256="))
+		u.Fragment = ""
+	}
+	location := redactURL(u)
+
+	log.V(0).Infof("Downloading source from %s ...", location)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %v", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: the server responded %s", location, resp.Status)
+	}
+
+	input := newBinaryInputReader(resp.Body, 0)
+	if err := extractVerifiedArchive(input, dir, expected); err != nil {
+		return nil, fmt.Errorf("unable to extract %s: %v", location, err)
+	}
+	input.done()
+	return nil, nil
+}
+
+// ociSourceFetcher pulls an OCI artifact, such as a source bundle pushed by
+// ORAS, and places each of its layers in the build directory: layers which
+// hold tar archives are extracted, and others are written to the file named
+// by their title annotation.  If the reference has a digest, the artifact's
+// manifest must have it.
+type ociSourceFetcher struct {
+	ref string
+	// dest is the directory, relative to the build directory, in which
+	// the artifact is placed, if not the build directory itself
+	dest string
+}
+
+func (f *ociSourceFetcher) Name() string {
+	if len(f.dest) > 0 && f.dest != "." {
+		return fmt.Sprintf("OCI artifact %s into %s", f.ref, f.dest)
+	}
+	return fmt.Sprintf("OCI artifact %s", f.ref)
+}
+
+func (f *ociSourceFetcher) Fetch(ctx context.Context, dir string) (*git.SourceInfo, error) {
+	named, err := ireference.ParseNormalizedNamed(f.ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.SourceURL, ociSourceScheme+f.ref, err)
+	}
+	named = ireference.TagNameOnly(named)
+	ref, err := idocker.NewReference(named)
+	if err != nil {
+		return nil, err
+	}
+	systemContext := registrySystemContext(types.SystemContext{}, named.String())
+	if auth, ok := dockercfg.NewHelper().GetDockerAuth(named.String(), dockercfg.PullAuthType); ok {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{Username: auth.Username, Password: auth.Password}
+	}
+
+	log.V(0).Infof("Pulling source from %s ...", named.String())
+	src, err := ref.NewImageSource(ctx, &systemContext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pull %s: %v", named.String(), err)
+	}
+	defer src.Close()
+	manifestBytes, manifestType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the manifest of %s: %v", named.String(), err)
+	}
+	if err := verifyManifestDigest(named, manifestBytes); err != nil {
+		return nil, err
+	}
+	if manifestType != "application/vnd.oci.image.manifest.v1+json" {
+		return nil, fmt.Errorf("%s is not an OCI artifact: its manifest is a %s", named.String(), manifestType)
+	}
+	m, err := manifest.OCI1FromManifest(manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest of %s: %v", named.String(), err)
+	}
+	if len(f.dest) > 0 && f.dest != "." {
+		if dir, err = binaryEntryPath(dir, f.dest); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, layer := range m.Layers {
+		blob, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size, MediaType: layer.MediaType}, none.NoCache)
+		if err != nil {
+			return nil, fmt.Errorf("unable to pull layer %s of %s: %v", layer.Digest, named.String(), err)
+		}
+		expected := ""
+		if strings.HasPrefix(layer.Digest.String(), "sha256:") {
+			expected = layer.Digest.Hex()
+		}
+		title := layer.Annotations[ociTitleAnnotation]
+		if layer.Annotations[orasUnpackAnnotation] == "true" || strings.HasPrefix(layer.MediaType, "application/vnd.oci.image.layer.v1.tar") {
+			err = extractVerifiedArchive(blob, dir, expected)
+		} else if len(title) == 0 {
+			err = fmt.Errorf("the layer has no %s annotation naming its file", ociTitleAnnotation)
+		} else {
+			err = writeVerifiedFile(blob, dir, title, expected)
+		}
+		blob.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to place layer %s of %s: %v", layer.Digest, named.String(), err)
+		}
+	}
+	return nil, nil
+}
+
+// verifyManifestDigest returns an error unless manifestBytes has the digest
+// of named, if it has one.
+func verifyManifestDigest(named ireference.Named, manifestBytes []byte) error {
+	digested, ok := named.(ireference.Digested)
+	if !ok {
+		return nil
+	}
+	matches, err := manifest.MatchesDigest(manifestBytes, digested.Digest())
+	if err != nil {
+		return fmt.Errorf("unable to verify the manifest of %s: %v", named.String(), err)
+	}
+	if !matches {
+		return fmt.Errorf("the manifest of %s does not have digest %s", named.String(), digested.Digest())
+	}
+	return nil
+}
+
+// verifyingReader hashes what is read through it.
+type verifyingReader struct {
+	io.Reader
+	hash hash.Hash
+}
+
+func newVerifyingReader(r io.Reader) *verifyingReader {
+	h := sha256.New()
+	return &verifyingReader{Reader: io.TeeReader(r, h), hash: h}
+}
+
+// verify reads the rest of the stream, and returns an error unless it had
+// the sha256 digest expected, if one is.
+func (r *verifyingReader) verify(expected string) error {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(r.hash.Sum(nil)); len(expected) > 0 && actual != expected {
+		return fmt.Errorf("its sha256 digest is %s rather than %s", actual, expected)
+	}
+	return nil
+}
+
+// extractVerifiedArchive extracts the archive r into dir, and returns an
+// error unless it had the sha256 digest expected, if one is.
+func extractVerifiedArchive(r io.Reader, dir, expected string) error {
+	verifying := newVerifyingReader(r)
+	if err := extractBinaryArchive(verifying, dir); err != nil {
+		return err
+	}
+	return verifying.verify(expected)
+}
+
+// writeVerifiedFile writes r to the file name in dir, and returns an error
+// unless it had the sha256 digest expected, if one is.
+func writeVerifiedFile(r io.Reader, dir, name, expected string) error {
+	path, err := binaryEntryPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	verifying := newVerifyingReader(r)
+	if err := writeBinaryEntry(verifying, path, 0644); err != nil {
+		return err
+	}
+	return verifying.verify(expected)
+}
@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	imagereference "github.com/openshift/library-go/pkg/image/reference"
+)
+
+// getFromOverrides returns the images, keyed by the name or the index of the
+// stage whose FROM they replace, which the build strategy's environment
+// requests.
+func getFromOverrides(build *buildapiv1.Build) (map[string]string, error) {
+	value, ok := buildStrategyEnv(build, builderutil.FromOverrides)
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	overrides := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a stage=image pair", builderutil.FromOverrides, value, entry)
+		}
+		stage, image := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		ref, err := imagereference.Parse(image)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.FromOverrides, value, err)
+		}
+		if _, ok := overrides[stage]; ok {
+			return nil, fmt.Errorf("invalid %s value %q: stage %q is overridden more than once", builderutil.FromOverrides, value, stage)
+		}
+		// reduce the name to a minimal canonical form for the daemon, as
+		// for the strategy's From
+		overrides[stage] = ref.DaemonMinimal().Exact()
+	}
+	return overrides, nil
+}
+
+// replaceStageFroms changes the FROM instruction of each stage of node which
+// overrides names, by its name or its index, to the image it maps the stage
+// to, keeping the stage's name.  Naming a stage the Dockerfile does not have
+// is an error, so that an override is never silently ignored.
+func replaceStageFroms(node *parser.Node, overrides map[string]string) error {
+	if node == nil || len(overrides) == 0 {
+		return nil
+	}
+	stages, _, err := dockerfileStages(node, nil)
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, stage := range stages {
+		key := stage.Name
+		image, ok := overrides[key]
+		if !ok {
+			key = strconv.Itoa(stage.Position)
+			if image, ok = overrides[key]; !ok {
+				continue
+			}
+		}
+		used[key] = true
+		for _, child := range stage.Node.Children {
+			if child.Value != dockercmd.From || child.Next == nil {
+				continue
+			}
+			log.V(0).Infof("Replaced Dockerfile FROM image %s of stage %s with %s", child.Next.Value, stage.Name, image)
+			child.Next.Value = image
+			break
+		}
+	}
+	var unused []string
+	for stage := range overrides {
+		if !used[stage] {
+			unused = append(unused, stage)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return fmt.Errorf("invalid %s value: the Dockerfile has no stage %s", builderutil.FromOverrides, strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// generateDockerfile writes a Dockerfile which copies the context into the
+// working directory of the image of the strategy's From, for a Docker
+// strategy build whose context has no Dockerfile and which names neither a
+// Dockerfile nor an inline one.  Other builds are left alone.
+func generateDockerfile(dir string, build *buildapiv1.Build) error {
+	strategy := build.Spec.Strategy.DockerStrategy
+	if strategy == nil || len(strategy.DockerfilePath) > 0 || build.Spec.Source.Dockerfile != nil {
+		return nil
+	}
+	if strategy.From == nil || strategy.From.Kind != "DockerImage" || len(strategy.From.Name) == 0 {
+		return nil
+	}
+	dockerfilePath := getDockerfilePath(dir, build)
+	if _, err := os.Stat(dockerfilePath); !os.IsNotExist(err) {
+		return nil
+	}
+	// a missing context directory is reported when the Dockerfile is read
+	if info, err := os.Stat(filepath.Dir(dockerfilePath)); err != nil || !info.IsDir() {
+		return nil
+	}
+	log.V(0).Infof("The build's context has no Dockerfile, so one which copies the context into %s is used", strategy.From.Name)
+	content := fmt.Sprintf("FROM %s\nCOPY . .\n", strategy.From.Name)
+	return ioutil.WriteFile(dockerfilePath, []byte(content), 0660)
+}
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+func TestGetFromOverrides(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect map[string]string
+		err    bool
+	}{
+		{value: ""},
+		{
+			value:  "builder=registry.example.com/approved/golang:1.15, 1 = docker.io/library/ubi8:latest",
+			expect: map[string]string{"builder": "registry.example.com/approved/golang:1.15", "1": "docker.io/ubi8"},
+		},
+		{value: "builder", err: true},
+		{value: "=golang", err: true},
+		{value: "builder=", err: true},
+		{value: "builder=Not An Image", err: true},
+		{value: "builder=golang,builder=ubi8", err: true},
+	}
+	for _, test := range tests {
+		overrides, err := getFromOverrides(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_FROM_OVERRIDES", Value: test.value}))
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error: %v", test.value, err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(overrides, test.expect) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expect, overrides)
+		}
+	}
+}
+
+func TestAddBuildParametersFromOverrides(t *testing.T) {
+	dockerfile := heredoc.Doc(`
+		ARG GO_VERSION=1.14
+		FROM golang:${GO_VERSION} AS builder
+		RUN go build
+		FROM builder AS test
+		RUN go test
+		FROM centos:7
+		COPY --from=builder /app /app
+		`)
+	tests := []struct {
+		name      string
+		overrides string
+		from      string
+		expect    []string
+		err       bool
+	}{
+		{
+			name:      "by stage name and index",
+			overrides: "builder=approved/golang:1.15,2=approved/ubi8",
+			expect:    []string{"ARG GO_VERSION=1.14", "FROM approved/golang:1.15 AS builder", "FROM builder AS test", "FROM approved/ubi8", "COPY --from=builder /app /app"},
+		},
+		{
+			name:      "over the strategy's From",
+			overrides: "2=approved/ubi8",
+			from:      "centos:8",
+			expect:    []string{"ARG GO_VERSION=1.14", "FROM golang:${GO_VERSION} AS builder", "FROM approved/ubi8"},
+		},
+		{
+			name:      "unknown stage",
+			overrides: "runtime=approved/ubi8",
+			err:       true,
+		},
+		{
+			name:      "index out of range",
+			overrides: "3=approved/ubi8",
+			err:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "from-overrides")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeTestFile(t, filepath.Join(dir, "Dockerfile"), dockerfile)
+			build := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_FROM_OVERRIDES", Value: test.overrides})
+			if len(test.from) > 0 {
+				build.Spec.Strategy.DockerStrategy.From = &corev1.ObjectReference{Kind: "DockerImage", Name: test.from}
+			}
+			err = addBuildParameters(dir, build, &git.SourceInfo{})
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err {
+				return
+			}
+			out, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range test.expect {
+				if !strings.Contains(strings.ToUpper(string(out)), strings.ToUpper(line)) {
+					t.Errorf("expected %q in:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateDockerfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate-dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "app", "index.php"), "<?php phpinfo();")
+	writeTestFile(t, filepath.Join(dir, "other", "Dockerfile"), "FROM centos:7\n")
+
+	build := &buildapiv1.Build{}
+	build.Spec.Source.ContextDir = "app"
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		From: &corev1.ObjectReference{Kind: "DockerImage", Name: "registry.example.com/php:7.4"},
+	}
+	if err := ManageDockerfile(dir, build); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "app", "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "FROM registry.example.com/php:7.4\nCOPY . .\n") {
+		t.Errorf("unexpected generated Dockerfile:\n%s", out)
+	}
+
+	// a Dockerfile in the context is used as it is
+	build.Spec.Source.ContextDir = "other"
+	if err := generateDockerfile(dir, build); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadFile(filepath.Join(dir, "other", "Dockerfile")); string(out) != "FROM centos:7\n" {
+		t.Errorf("expected the context's Dockerfile to be kept, got:\n%s", out)
+	}
+
+	// as is a missing Dockerfile named by the build, or one without a From
+	for _, modify := range []func(*buildapiv1.Build){
+		func(build *buildapiv1.Build) { build.Spec.Strategy.DockerStrategy.DockerfilePath = "Dockerfile.prod" },
+		func(build *buildapiv1.Build) { build.Spec.Strategy.DockerStrategy.From = nil },
+	} {
+		build := &buildapiv1.Build{}
+		build.Spec.Source.ContextDir = "missing"
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			From: &corev1.ObjectReference{Kind: "DockerImage", Name: "centos:7"},
+		}
+		os.MkdirAll(filepath.Join(dir, "missing"), 0755)
+		modify(build)
+		if err := generateDockerfile(dir, build); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(getDockerfilePath(dir, build)); !os.IsNotExist(err) {
+			t.Errorf("expected no Dockerfile to be generated for %#v", build.Spec.Strategy.DockerStrategy)
+		}
+	}
+}
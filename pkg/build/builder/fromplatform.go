@@ -0,0 +1,188 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+)
+
+// hostPlatform is the os/arch platform of the builder itself.
+var hostPlatform = runtime.GOOS + "/" + runtime.GOARCH
+
+// platformArgs returns the automatic platform build args, which FROM
+// --platform flags may use, of a build on hostPlatform for platform.
+func platformArgs(platform string) ([]string, error) {
+	var args []string
+	for _, p := range []struct{ prefix, platform string }{{"BUILD", hostPlatform}, {"TARGET", platform}} {
+		platformOS, arch, variant, err := parsePlatform(p.platform)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args,
+			p.prefix+"PLATFORM="+p.platform,
+			p.prefix+"OS="+platformOS,
+			p.prefix+"ARCH="+arch,
+			p.prefix+"VARIANT="+variant,
+		)
+	}
+	return args, nil
+}
+
+// resolveFromPlatforms rewrites the FROM instructions with --platform flags,
+// which the builder ignores, of the Dockerfile at dockerfilePath for a build
+// for platform, or for hostPlatform if it is empty.  The flag is removed from
+// those for the platform being built.  The base images of the others are
+// pulled for the platforms they name and referenced by the digests of those
+// images.  The returned function restores the Dockerfile, so that the build
+// for another platform starts from it again.
+func (d *DockerBuilder) resolveFromPlatforms(dockerfilePath, platform string, buildArgs []docker.BuildArg) (func(), error) {
+	restore := func() {}
+	in, err := ioutil.ReadFile(dockerfilePath)
+	if os.IsNotExist(err) {
+		// the build reports the missing Dockerfile itself
+		return restore, nil
+	}
+	if err != nil {
+		return restore, err
+	}
+	node, err := imagebuilder.ParseDockerfile(bytes.NewBuffer(in))
+	if err != nil {
+		return restore, err
+	}
+	if len(platform) == 0 {
+		platform = hostPlatform
+	}
+	targetOS, targetArch, _, err := parsePlatform(platform)
+	if err != nil {
+		return restore, err
+	}
+
+	// the flags may use the ARGs declared before the first FROM, which the
+	// build args override, and the automatic platform args
+	globals := make(map[string]string)
+	var names []string
+	for _, child := range node.Children {
+		if child.Value == dockercmd.From {
+			break
+		}
+		if child.Value != dockercmd.Arg {
+			continue
+		}
+		for n := child.Next; n != nil; n = n.Next {
+			parts := strings.SplitN(n.Value, "=", 2)
+			if _, ok := globals[parts[0]]; !ok {
+				names = append(names, parts[0])
+			}
+			globals[parts[0]] = ""
+			if len(parts) == 2 {
+				globals[parts[0]] = parts[1]
+			}
+		}
+	}
+	for _, arg := range buildArgs {
+		if _, ok := globals[arg.Name]; ok {
+			globals[arg.Name] = arg.Value
+		}
+	}
+	args, err := platformArgs(platform)
+	if err != nil {
+		return restore, err
+	}
+	for _, name := range names {
+		if value := globals[name]; len(value) > 0 {
+			args = append(args, name+"="+value)
+		}
+	}
+
+	changed := false
+	stages := make(map[string]bool)
+	for _, child := range node.Children {
+		if child.Value != dockercmd.From || child.Next == nil {
+			continue
+		}
+		resolved, err := d.resolveFromPlatform(child, args, targetOS, targetArch, stages)
+		if err != nil {
+			return restore, err
+		}
+		changed = changed || resolved
+		if child.Next.Next != nil && strings.EqualFold(child.Next.Next.Value, "as") && child.Next.Next.Next != nil {
+			stages[strings.ToLower(child.Next.Next.Next.Value)] = true
+		}
+	}
+	if !changed {
+		return restore, nil
+	}
+
+	out := dockerfile.Write(node)
+	log.V(4).Infof("Replacing dockerfile\n%s\nwith:\n%s", string(in), string(out))
+	if err := overwriteFile(dockerfilePath, out); err != nil {
+		return restore, err
+	}
+	return func() {
+		if err := overwriteFile(dockerfilePath, in); err != nil {
+			log.V(0).Infof("error: Unable to restore the Dockerfile: %v", err)
+		}
+	}, nil
+}
+
+// resolveFromPlatform removes the --platform flag, if any, from the FROM
+// instruction from, and references the image for the platform it names when
+// that is not targetOS/targetArch.  It returns whether from had the flag.
+func (d *DockerBuilder) resolveFromPlatform(from *parser.Node, args []string, targetOS, targetArch string, stages map[string]bool) (bool, error) {
+	var flag string
+	var flags []string
+	for _, f := range from.Flags {
+		if strings.HasPrefix(f, "--platform=") {
+			flag = strings.TrimPrefix(f, "--platform=")
+			continue
+		}
+		flags = append(flags, f)
+	}
+	if len(flag) == 0 {
+		return false, nil
+	}
+	from.Flags = flags
+
+	fromPlatform, err := imagebuilder.ProcessWord(flag, args)
+	if err != nil {
+		return false, fmt.Errorf("invalid FROM --platform=%s: %v", flag, err)
+	}
+	fromOS, fromArch, _, err := parsePlatform(fromPlatform)
+	if err != nil {
+		return false, fmt.Errorf("invalid FROM --platform=%s: %v", flag, err)
+	}
+	image, err := imagebuilder.ProcessWord(from.Next.Value, args)
+	if err != nil {
+		return false, fmt.Errorf("invalid FROM image %s: %v", from.Next.Value, err)
+	}
+	if (fromOS == targetOS && fromArch == targetArch) || image == "scratch" || stages[strings.ToLower(image)] {
+		return true, nil
+	}
+
+	resolver, ok := d.dockerClient.(platformResolver)
+	if !ok {
+		return false, fmt.Errorf("FROM --platform=%s is not supported by this build client", fromPlatform)
+	}
+	searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
+	ref, err := resolver.ResolvePlatformImage(image, fromPlatform, searchPaths)
+	if err != nil {
+		return false, fmt.Errorf("unable to find the %s image of %s: %v", fromPlatform, image, err)
+	}
+	log.V(0).Infof("Using %s for the %s image of %s", ref, fromPlatform, image)
+	if err := d.pullImage(ref, searchPaths); err != nil {
+		return false, fmt.Errorf("failed to pull image: %v", err)
+	}
+	from.Next.Value = ref
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	docker "github.com/fsouza/go-dockerclient"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openshift/library-go/pkg/git"
+)
+
+// fakePlatformResolver resolves the images of other platforms to
+// registry.example.com/<name>@sha256:<platform>.
+type fakePlatformResolver struct {
+	*FakeDocker
+	resolved []string
+}
+
+func (f *fakePlatformResolver) ResolvePlatformImage(name, platform string, searchPaths []string) (string, error) {
+	f.resolved = append(f.resolved, name+" "+platform)
+	return "registry.example.com/" + name + "@sha256:" + strings.Replace(platform, "/", "-", -1), nil
+}
+
+func TestResolveFromPlatforms(t *testing.T) {
+	defer func(platform string) { hostPlatform = platform }(hostPlatform)
+	hostPlatform = "linux/amd64"
+
+	dir, err := ioutil.TempDir("", "from-platforms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfilePath := filepath.Join(dir, "Dockerfile")
+	original := heredoc.Doc(`
+		ARG TOOLS_PLATFORM=linux/s390x
+		FROM --platform=$BUILDPLATFORM golang:1.15 AS build
+		RUN go build
+		FROM --platform=${TOOLS_PLATFORM} tools AS tools
+		FROM --platform=$TARGETPLATFORM build AS test
+		FROM --platform=$TARGETPLATFORM ubi8
+		COPY --from=build /app /app
+		`)
+	writeTestFile(t, dockerfilePath, original)
+
+	var pulled []string
+	fake := &fakePlatformResolver{FakeDocker: &FakeDocker{
+		pullImageFunc: func(opts docker.PullImageOptions, searchPaths []string) error {
+			pulled = append(pulled, opts.Repository)
+			return nil
+		},
+	}}
+	d := &DockerBuilder{dockerClient: fake}
+	restore, err := d.resolveFromPlatforms(dockerfilePath, "linux/arm64", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"FROM registry.example.com/golang:1.15@sha256:linux-amd64 AS build",
+		"FROM registry.example.com/tools@sha256:linux-s390x AS tools",
+		"FROM build AS test",
+		"FROM ubi8\n",
+	} {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("expected %q in:\n%s", line, out)
+		}
+	}
+	if expect := []string{"golang:1.15 linux/amd64", "tools linux/s390x"}; !reflect.DeepEqual(fake.resolved, expect) {
+		t.Errorf("expected %v to be resolved, got %v", expect, fake.resolved)
+	}
+	if len(pulled) != 2 {
+		t.Errorf("expected the resolved images to be pulled, got %v", pulled)
+	}
+
+	restore()
+	if out, _ := ioutil.ReadFile(dockerfilePath); string(out) != original {
+		t.Errorf("expected the Dockerfile to be restored, got:\n%s", out)
+	}
+
+	// the flags of a build on and for the same platform are only removed
+	fake.resolved = nil
+	restore, err = d.resolveFromPlatforms(dockerfilePath, "", []docker.BuildArg{{Name: "TOOLS_PLATFORM", Value: "linux/amd64"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+	if out, _ := ioutil.ReadFile(dockerfilePath); strings.Contains(string(out), "--platform") || len(fake.resolved) > 0 {
+		t.Errorf("expected no images to be resolved, got %v and:\n%s", fake.resolved, out)
+	}
+
+	// a client which cannot resolve the images of other platforms fails
+	writeTestFile(t, dockerfilePath, "FROM --platform=linux/ppc64le ubi8\n")
+	d.dockerClient = &FakeDocker{}
+	if _, err := d.resolveFromPlatforms(dockerfilePath, "", nil); err == nil {
+		t.Errorf("expected an error from a client which cannot resolve platform images")
+	}
+}
+
+func TestAddBuildParametersModernSyntax(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modern-syntax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "Dockerfile"), heredoc.Doc(`
+		# syntax=docker/dockerfile:1
+		FROM --platform=$BUILDPLATFORM golang:1.15 AS build
+		RUN <<EOF
+		go build -o /app
+		EOF
+		FROM ubi8
+		COPY --link --from=build /app /app
+		`))
+	build := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_FROM_OVERRIDES", Value: "build=approved/golang:1.15"})
+	if err := addBuildParameters(dir, build, &git.SourceInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"FROM --platform=$BUILDPLATFORM approved/golang:1.15 AS build\n",
+		`RUN ["/bin/sh","-c","go build -o /app\n"]`,
+		"COPY --from=build /app /app\n",
+	} {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("expected %q in:\n%s", line, out)
+		}
+	}
+}
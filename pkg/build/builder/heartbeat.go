@@ -0,0 +1,208 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// StatusReasonBuildStalled is the reason a build fails when it is stopped
+// because it made no progress for longer than its stall timeout.
+const StatusReasonBuildStalled buildapiv1.StatusReason = "BuildStalled"
+
+// heartbeatObserver is the name under which the heartbeat observes the
+// stages of the build.
+const heartbeatObserver = "heartbeat"
+
+// heartbeatInterval is how often the heartbeat file is written and the
+// build's progress is checked against its stall timeout.
+var heartbeatInterval = 10 * time.Second
+
+// lastOutput returns when the build last wrote output.
+var lastOutput = utillog.LastOutput
+
+// heartbeatStatus is the content of the heartbeat file.
+type heartbeatStatus struct {
+	Stage        buildapiv1.StageName `json:"stage,omitempty"`
+	StageStarted time.Time            `json:"stageStarted"`
+	LastProgress time.Time            `json:"lastProgress"`
+	Updated      time.Time            `json:"updated"`
+	// StallTimeout is the build's stall timeout, if it sets one
+	StallTimeout string `json:"stallTimeout,omitempty"`
+}
+
+// heartbeat holds the stage the build is in and when it last made progress.
+var heartbeat = struct {
+	sync.Mutex
+	path         string
+	stallTimeout time.Duration
+	stage        buildapiv1.StageName
+	stageStarted time.Time
+	// progress is when the build last entered a stage or transferred
+	// image content, which, along with its output, is its progress
+	progress time.Time
+	stop     chan struct{}
+	// stalled and stalledFor are the stage in which the build stopped
+	// making progress and for how long, if it did
+	stalled    buildapiv1.StageName
+	stalledFor time.Duration
+}{}
+
+// ConfigureHeartbeat arranges for the stage the build is in, and when it
+// last made progress, to be written to the file named by the build's
+// BUILD_HEARTBEAT_FILE every heartbeatInterval, for a liveness probe or a
+// sidecar to read.  If the build sets BUILD_STALL_TIMEOUT, it is stopped,
+// and fails saying which stage stalled, once it has made no progress for
+// that long, rather than sitting idle until its completion deadline.
+func ConfigureHeartbeat(build *buildapiv1.Build) error {
+	path, _ := buildStrategyEnv(build, builderutil.HeartbeatFile)
+	path = strings.TrimSpace(path)
+	var stallTimeout time.Duration
+	if value, _ := buildStrategyEnv(build, builderutil.StallTimeout); len(strings.TrimSpace(value)) > 0 {
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid %s value %q: must be a positive duration", builderutil.StallTimeout, value)
+		}
+		stallTimeout = timeout
+	}
+
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	heartbeat.path, heartbeat.stallTimeout = path, stallTimeout
+	if len(path) == 0 && stallTimeout == 0 {
+		timing.ObserveStages(heartbeatObserver, nil)
+		return nil
+	}
+	now := time.Now()
+	heartbeat.stageStarted, heartbeat.progress = now, now
+	timing.ObserveStages(heartbeatObserver, observeHeartbeatStage)
+	if heartbeat.stop == nil {
+		heartbeat.stop = make(chan struct{})
+		go beatUntilStopped(heartbeat.stop, heartbeatInterval)
+	}
+	return nil
+}
+
+// observeHeartbeatStage records that the build has entered stage, which is
+// progress.
+func observeHeartbeatStage(stage buildapiv1.StageName) {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	if stage == heartbeat.stage {
+		return
+	}
+	now := time.Now()
+	heartbeat.stage, heartbeat.stageStarted, heartbeat.progress = stage, now, now
+}
+
+// recordProgress records that the build has just made progress other than
+// writing output, such as transferring some image content.
+func recordProgress() {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	heartbeat.progress = time.Now()
+}
+
+// beatUntilStopped writes the heartbeat file and checks the build's progress
+// every interval, until stop is closed or the build stalls.
+func beatUntilStopped(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if !beat(now) {
+				return
+			}
+		}
+	}
+}
+
+// beat writes the heartbeat file, if there is one, and stops the build if it
+// has made no progress for longer than its stall timeout.  It returns false
+// if it has.
+func beat(now time.Time) bool {
+	heartbeat.Lock()
+	status := heartbeatStatus{
+		Stage:        heartbeat.stage,
+		StageStarted: heartbeat.stageStarted,
+		LastProgress: heartbeat.progress,
+		Updated:      now,
+	}
+	path, stallTimeout := heartbeat.path, heartbeat.stallTimeout
+	heartbeat.Unlock()
+	if output := lastOutput(); output.After(status.LastProgress) {
+		status.LastProgress = output
+	}
+	if stallTimeout > 0 {
+		status.StallTimeout = stallTimeout.String()
+	}
+	if len(path) > 0 {
+		if err := writeHeartbeatFile(path, status); err != nil {
+			log.V(4).Infof("Unable to write the heartbeat file %s: %v", path, err)
+		}
+	}
+
+	idle := now.Sub(status.LastProgress)
+	if stallTimeout == 0 || idle < stallTimeout {
+		return true
+	}
+	heartbeat.Lock()
+	heartbeat.stalled, heartbeat.stalledFor = status.Stage, idle.Round(time.Second)
+	heartbeat.Unlock()
+	CancelBuild(fmt.Sprintf("the build made no progress for %v, longer than its stall timeout of %v", idle.Round(time.Second), stallTimeout))
+	return false
+}
+
+// writeHeartbeatFile replaces the file at path with status, so that a reader
+// never sees it partly written.
+func writeHeartbeatFile(path string, status heartbeatStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".heartbeat")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// stopHeartbeat stops writing the heartbeat file and checking the build's
+// progress.
+func stopHeartbeat() {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	if heartbeat.stop != nil {
+		close(heartbeat.stop)
+		heartbeat.stop = nil
+	}
+}
+
+// buildStalled returns the stage in which the build stopped making progress
+// and for how long, if it did.
+func buildStalled() (buildapiv1.StageName, time.Duration) {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	return heartbeat.stalled, heartbeat.stalledFor
+}
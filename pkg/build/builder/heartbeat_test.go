@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// resetHeartbeat undoes the heartbeat configured by a test.
+func resetHeartbeat() {
+	stopHeartbeat()
+	timing.ObserveStages(heartbeatObserver, nil)
+	lastOutput = utillog.LastOutput
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	heartbeat.path, heartbeat.stallTimeout, heartbeat.stage, heartbeat.stalled, heartbeat.stalledFor = "", 0, "", "", 0
+}
+
+func TestConfigureHeartbeat(t *testing.T) {
+	defer resetHeartbeat()
+	if err := ConfigureHeartbeat(testBuildWithEnv()); err != nil || heartbeat.stop != nil {
+		t.Errorf("expected no heartbeat by default: %v", err)
+	}
+	if err := ConfigureHeartbeat(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_STALL_TIMEOUT", Value: " 15m "})); err != nil || heartbeat.stallTimeout != 15*time.Minute || heartbeat.stop == nil {
+		t.Errorf("expected a stall timeout of 15m, got %v: %v", heartbeat.stallTimeout, err)
+	}
+	for _, value := range []string{"soon", "0s", "-1m"} {
+		if err := ConfigureHeartbeat(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_STALL_TIMEOUT", Value: value})); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+	defer resetHeartbeat()
+	defer utillog.SetStage("")
+	defer func(interval time.Duration) { heartbeatInterval = interval }(heartbeatInterval)
+	heartbeatInterval = time.Hour
+
+	dir, err := ioutil.TempDir("", "heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heartbeat.json")
+	output := time.Time{}
+	lastOutput = func() time.Time { return output }
+	if err := ConfigureHeartbeat(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_HEARTBEAT_FILE", Value: path}, corev1.EnvVar{Name: "BUILD_STALL_TIMEOUT", Value: "10m"})); err != nil {
+		t.Fatal(err)
+	}
+
+	timing.SetStage(buildapiv1.StageBuild)
+	heartbeat.Lock()
+	entered := heartbeat.stageStarted
+	heartbeat.Unlock()
+	output = entered.Add(5 * time.Minute)
+	if !beat(entered.Add(12 * time.Minute)) {
+		t.Fatalf("expected output during the stage to count as progress")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status heartbeatStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Stage != buildapiv1.StageBuild || !status.LastProgress.Equal(output) || status.StallTimeout != "10m0s" {
+		t.Errorf("unexpected heartbeat %s", data)
+	}
+
+	if beat(entered.Add(16 * time.Minute)) {
+		t.Fatalf("expected the build to be stopped after 10m without progress")
+	}
+	select {
+	case <-BuildContext().Done():
+	default:
+		t.Fatalf("expected the build to be cancelled")
+	}
+	build := &buildapiv1.Build{}
+	if !SetCancelledStatus(build) || build.Status.Phase != buildapiv1.BuildPhaseFailed || build.Status.Reason != StatusReasonBuildStalled ||
+		build.Status.Message != "The build made no progress during the Build stage for 11m0s." {
+		t.Errorf("unexpected status %#v", build.Status)
+	}
+}
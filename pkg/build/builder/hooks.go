@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+)
+
+// The points of a build at which the build hook scripts run, each named by
+// the key of the configmap holding its script.
+const (
+	HookPreSource  = "pre-source"
+	HookPostSource = "post-source"
+	HookPrePush    = "pre-push"
+	HookPostPush   = "post-push"
+)
+
+// buildHookImageKey is the key of the build hooks configmap holding the
+// image which the scripts run in.
+const buildHookImageKey = "image"
+
+// buildHookMountPath is where the build hooks configmap is mounted into the
+// container which runs a script.
+const buildHookMountPath = "/var/run/build-hooks"
+
+// buildHooksDir holds the build hook scripts, each named by its hook, and the
+// image they run in.  The build controller mounts nothing there, so that the
+// authors of builds, whose secrets and configmaps it mounts under
+// /var/run/{secrets,configs}/openshift.io, cannot choose or skip the scripts
+// which platform teams use to add policy steps to every build.  The hooks
+// are part of the builder's own configuration instead: they are added to the
+// builder image of the cluster, or a configmap holding them is mounted there
+// into the build server which runs the builds of a node.
+var buildHooksDir = "/etc/openshift-builder/build-hooks"
+
+// HasBuildHooks returns whether the builder has a script for any of hooks.
+func HasBuildHooks(hooks ...string) bool {
+	for _, hook := range hooks {
+		if _, err := os.Stat(filepath.Join(buildHooksDir, hook)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RunBuildHook runs the builder's script for hook, if it has one, with env,
+// as NAME=value pairs, added to its environment.  On failure, the build's
+// status records that a hook failed it.
+func RunBuildHook(ctx context.Context, client DockerClient, build *buildapiv1.Build, cgLimits *s2iapi.CGroupLimits, hook string, env ...string) error {
+	err := runHookScript(ctx, client, build, cgLimits, hook, env)
+	if err != nil {
+		build.Status.Phase = buildapiv1.BuildPhaseFailed
+		build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		build.Status.Message = builderutil.StatusMessageBuildHookFailed
+	}
+	return err
+}
+
+// runHookScript runs the script for hook, if there is one, with the shell of
+// the build hooks image, in a container isolated as the build's RUN
+// instructions are and limited to the build's resources.  The script is
+// given the build's metadata, the name of the hook and env, but none of the
+// builder's environment or credentials.  Once the source has been fetched,
+// it is mounted, read-only, as the script's working directory.
+func runHookScript(ctx context.Context, client DockerClient, build *buildapiv1.Build, cgLimits *s2iapi.CGroupLimits, hook string, env []string) error {
+	if _, err := os.Stat(filepath.Join(buildHooksDir, hook)); os.IsNotExist(err) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(buildHooksDir, buildHookImageKey))
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return fmt.Errorf("the build hooks in %s have no %s key naming the image to run the %s build hook in", buildHooksDir, buildHookImageKey, hook)
+	}
+	image := strings.TrimSpace(string(data))
+	runner, ok := client.(containerRunner)
+	if !ok {
+		return fmt.Errorf("running build hooks is not supported by this build client")
+	}
+
+	sourceInfo, err := readSourceInfo()
+	if err != nil {
+		return fmt.Errorf("error reading git source info: %v", err)
+	}
+	hookEnv := []string{
+		"BUILD_HOOK=" + hook,
+		"OPENSHIFT_BUILD_NAME=" + build.Name,
+		"OPENSHIFT_BUILD_NAMESPACE=" + build.Namespace,
+	}
+	for _, kv := range buildInfo(build, sourceInfo) {
+		hookEnv = append(hookEnv, kv.Key+"="+kv.Value)
+	}
+	hookEnv = append(hookEnv, env...)
+
+	hostConfig := limitedHostConfig(cgLimits)
+	hostConfig.Binds = []string{buildHooksDir + ":" + buildHookMountPath + ":ro"}
+	config := &docker.Config{
+		Image:      image,
+		Entrypoint: []string{"/bin/sh", filepath.Join(buildHookMountPath, hook)},
+		Env:        hookEnv,
+	}
+	if _, err := os.Stat(InputContentPath); err == nil && hook != HookPreSource {
+		hostConfig.Binds = append(hostConfig.Binds, InputContentPath+":"+InputContentPath+":ro")
+		config.WorkingDir = InputContentPath
+	}
+
+	if err := pullHookImage(client, image); err != nil {
+		return fmt.Errorf("unable to pull the image %s of the %s build hook: %v", image, hook, err)
+	}
+	log.V(0).Infof("\nRunning the %s build hook ...", hook)
+	err = runner.RunContainer(docker.CreateContainerOptions{
+		Context:    ctx,
+		Name:       containerName("hook", build.Name, build.Namespace, hook),
+		Config:     config,
+		HostConfig: hostConfig,
+	}, docker.AttachToContainerOptions{
+		OutputStream: os.Stdout,
+		ErrorStream:  os.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("the %s build hook failed: %v", hook, err)
+	}
+	return nil
+}
+
+// pullHookImage pulls the image which build hooks run in, unless it is
+// already present, with the builder's pull credentials.
+func pullHookImage(client DockerClient, image string) error {
+	if _, err := client.InspectImage(image); err == nil {
+		return nil
+	}
+	searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
+	return pullImageFromMirrors(client, image, func(name string) error {
+		repository, tag := docker.ParseRepositoryTag(name)
+		options := docker.PullImageOptions{Repository: repository, Tag: tag}
+		if options.Tag == "" && strings.Contains(name, "@") {
+			options.Repository = name
+		}
+		return retryImageAction("Pull", func() error {
+			return client.PullImage(options, searchPaths)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+)
+
+func setBuildHooksDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "build-hooks")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	original := buildHooksDir
+	buildHooksDir = dir
+	return dir, func() {
+		buildHooksDir = original
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunHookScript(t *testing.T) {
+	dir, cleanup := setBuildHooksDir(t)
+	defer cleanup()
+	writeTestFile(t, filepath.Join(dir, HookPostPush), "env\n")
+	writeTestFile(t, filepath.Join(dir, buildHookImageKey), "registry.example.com/hooks:latest\n")
+
+	build := &buildapiv1.Build{}
+	build.Name = "app-1"
+	build.Namespace = "myproject"
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "https://example.com/app.git"}
+
+	client := &fakeRunningDocker{FakeDocker: NewFakeDockerClient()}
+	if err := runHookScript(context.Background(), client, build, nil, HookPreSource, nil); err != nil || client.createOpts != nil {
+		t.Fatalf("expected a missing hook to be skipped, got %v, %v", client.createOpts, err)
+	}
+
+	limits := &s2iapi.CGroupLimits{MemoryLimitBytes: 1024, CPUQuota: 100, Parent: "parent"}
+	if err := runHookScript(context.Background(), client, build, limits, HookPostPush, []string{"OUTPUT_IMAGE_DIGEST=sha256:1234"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.createOpts == nil {
+		t.Fatalf("expected the hook to run in a container")
+	}
+	config := client.createOpts.Config
+	if config.Image != "registry.example.com/hooks:latest" {
+		t.Errorf("expected the hook to run in the configured image, got %q", config.Image)
+	}
+	if len(config.Entrypoint) != 2 || config.Entrypoint[1] != filepath.Join(buildHookMountPath, HookPostPush) {
+		t.Errorf("expected the hook's script to be run, got %v", config.Entrypoint)
+	}
+	for _, expected := range []string{"BUILD_HOOK=post-push", "OPENSHIFT_BUILD_NAME=app-1", "OPENSHIFT_BUILD_NAMESPACE=myproject", "OPENSHIFT_BUILD_SOURCE=https://example.com/app.git", "OUTPUT_IMAGE_DIGEST=sha256:1234"} {
+		found := false
+		for _, kv := range config.Env {
+			found = found || kv == expected
+		}
+		if !found {
+			t.Errorf("expected %s in the hook's environment, got %v", expected, config.Env)
+		}
+	}
+	host := client.createOpts.HostConfig
+	if host.Memory != 1024 || host.CPUQuota != 100 || host.CgroupParent != "parent" {
+		t.Errorf("expected the build's resource limits, got %#v", host)
+	}
+	if len(host.Binds) == 0 || host.Binds[0] != dir+":"+buildHookMountPath+":ro" {
+		t.Errorf("expected the hooks to be mounted read-only, got %v", host.Binds)
+	}
+
+	client.err = errors.New("exit status 3")
+	if err := runHookScript(context.Background(), client, build, nil, HookPostPush, nil); err == nil {
+		t.Errorf("expected a failing hook to fail")
+	}
+	if err := runHookScript(context.Background(), NewFakeDockerClient(), build, nil, HookPostPush, nil); err == nil {
+		t.Errorf("expected an error from a client which cannot run containers")
+	}
+
+	os.Remove(filepath.Join(dir, buildHookImageKey))
+	if err := runHookScript(context.Background(), &fakeRunningDocker{FakeDocker: NewFakeDockerClient()}, build, nil, HookPostPush, nil); err == nil {
+		t.Errorf("expected an error when no image is configured")
+	}
+}
+
+func TestRunBuildHookFailure(t *testing.T) {
+	dir, cleanup := setBuildHooksDir(t)
+	defer cleanup()
+	writeTestFile(t, filepath.Join(dir, HookPreSource), "exit 1\n")
+
+	build := &buildapiv1.Build{}
+	if err := RunBuildHook(context.Background(), NewFakeDockerClient(), build, nil, HookPreSource); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if build.Status.Phase != buildapiv1.BuildPhaseFailed || build.Status.Message != "Build failed because of a build hook." {
+		t.Errorf("expected the build to fail because of its hook, got %#v", build.Status)
+	}
+	if !HasBuildHooks(HookPostSource, HookPreSource) || HasBuildHooks(HookPrePush) {
+		t.Errorf("expected only the pre-source hook to be found")
+	}
+}
@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// inputFile places one key of a build input secret or configmap at a path of
+// its own.
+type inputFile struct {
+	// key is the key of the secret or configmap
+	key string
+	// path is where the key is placed, relative to the destination
+	// directory of the input
+	path string
+	// mode is the mode of the file, or zero to keep that of the key
+	mode os.FileMode
+}
+
+// getInputFiles returns the files which the build strategy's environment
+// places for the input secrets and configmaps of build, keyed by the
+// directory the input is mounted at in the build pod.
+func getInputFiles(build *buildapiv1.Build) (map[string][]inputFile, error) {
+	value, _ := buildStrategyEnv(build, builderutil.BuildInputFiles)
+	files := map[string][]inputFile{}
+	paths := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected kind:name:key:path[:mode]", builderutil.BuildInputFiles, entry)
+		}
+		kind, name, key, dest := parts[0], parts[1], parts[2], parts[3]
+
+		var dir string
+		var ok bool
+		switch kind {
+		case buildVolumeSecret:
+			dir, ok = inputSecretDir(build, name)
+		case buildVolumeConfigMap:
+			dir, ok = inputConfigMapDir(build, name)
+		default:
+			return nil, fmt.Errorf("invalid %s entry %q: the kind must be %q or %q", builderutil.BuildInputFiles, entry, buildVolumeSecret, buildVolumeConfigMap)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q: %q is not an input %s of the build", builderutil.BuildInputFiles, entry, name, kind)
+		}
+		if len(key) == 0 || strings.Contains(key, "/") || strings.HasPrefix(key, "..") {
+			return nil, fmt.Errorf("invalid %s entry %q: %q is not a valid key", builderutil.BuildInputFiles, entry, key)
+		}
+		dest = path.Clean(dest)
+		if path.IsAbs(dest) || dest == "." || dest == ".." || strings.HasPrefix(dest, "../") {
+			return nil, fmt.Errorf("invalid %s entry %q: the path must be relative to the destination directory of the input", builderutil.BuildInputFiles, entry)
+		}
+		if paths[dir+"/"+dest] {
+			return nil, fmt.Errorf("invalid %s entry %q: another key of %s %q is placed at %s", builderutil.BuildInputFiles, entry, kind, name, dest)
+		}
+		paths[dir+"/"+dest] = true
+
+		file := inputFile{key: key, path: dest}
+		if len(parts) == 5 {
+			mode, err := strconv.ParseUint(parts[4], 8, 32)
+			if err != nil || mode == 0 || mode > 0777 {
+				return nil, fmt.Errorf("invalid %s entry %q: the mode must be octal permissions such as 0600", builderutil.BuildInputFiles, entry)
+			}
+			file.mode = os.FileMode(mode)
+		}
+		files[dir] = append(files[dir], file)
+	}
+	return files, nil
+}
+
+// placeInputFiles copies the keys of the input mounted at srcDir to their
+// paths under dstDir, with their modes.
+func placeInputFiles(srcDir, dstDir string, files []inputFile) error {
+	for _, file := range files {
+		src := filepath.Join(srcDir, file.key)
+		st, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("the build input at %s has no key %q", srcDir, file.key)
+		}
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		mode := file.mode
+		if mode == 0 {
+			mode = st.Mode().Perm()
+		}
+		dst := filepath.Join(dstDir, filepath.FromSlash(file.path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, data, mode); err != nil {
+			return err
+		}
+		// the file may have existed, or the umask may have masked the mode
+		if err := os.Chmod(dst, mode); err != nil {
+			return err
+		}
+		log.V(3).Infof("Placed key %q of the build input at %s at %s with mode %#o", file.key, srcDir, dst, mode)
+	}
+	return nil
+}
+
+// stageInputFiles lays out, in a new temporary directory for each input
+// secret and configmap of build which has files placed, the keys of the
+// input at their paths, and returns those directories, keyed by the
+// directory the input is mounted at in the build pod.  The caller removes
+// them.
+func stageInputFiles(build *buildapiv1.Build) (map[string]string, error) {
+	files, err := getInputFiles(build)
+	if err != nil {
+		return nil, err
+	}
+	staged := map[string]string{}
+	for srcDir, f := range files {
+		stageDir, err := ioutil.TempDir("", "input-"+filepath.Base(srcDir))
+		if err != nil {
+			return staged, err
+		}
+		staged[srcDir] = stageDir
+		if err := placeInputFiles(srcDir, stageDir, f); err != nil {
+			return staged, err
+		}
+	}
+	return staged, nil
+}
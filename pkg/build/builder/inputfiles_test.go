@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetInputFiles(t *testing.T) {
+	secretDir := filepath.Join(secretBuildSourceBaseMountPath, "creds")
+	configMapDir := filepath.Join(configMapBuildSourceBaseMountPath, "settings")
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string][]inputFile
+		expectErr bool
+	}{
+		{name: "none", value: "", expected: map[string][]inputFile{}},
+		{
+			name:  "files",
+			value: "secret:creds:token:.config/tool/credentials:0600, secret:creds:ca.crt:certs/ca.crt,configmap:settings:tool.yaml:tool.yaml:644",
+			expected: map[string][]inputFile{
+				secretDir: {
+					{key: "token", path: ".config/tool/credentials", mode: 0600},
+					{key: "ca.crt", path: "certs/ca.crt"},
+				},
+				configMapDir: {{key: "tool.yaml", path: "tool.yaml", mode: 0644}},
+			},
+		},
+		{name: "too few fields", value: "secret:creds:token", expectErr: true},
+		{name: "unknown kind", value: "path:creds:token:token", expectErr: true},
+		{name: "not an input", value: "secret:other:token:token", expectErr: true},
+		{name: "invalid key", value: "secret:creds:../token:token", expectErr: true},
+		{name: "absolute path", value: "secret:creds:token:/root/token", expectErr: true},
+		{name: "path leaving the destination", value: "secret:creds:token:../token", expectErr: true},
+		{name: "same path twice", value: "secret:creds:token:token,secret:creds:ca.crt:./token", expectErr: true},
+		{name: "invalid mode", value: "secret:creds:token:token:rw", expectErr: true},
+		{name: "mode out of range", value: "secret:creds:token:token:4755", expectErr: true},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{{Secret: corev1.LocalObjectReference{Name: "creds"}}}
+		build.Spec.Source.ConfigMaps = []buildapiv1.ConfigMapBuildSource{{ConfigMap: corev1.LocalObjectReference{Name: "settings"}}}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_INPUT_FILES", Value: test.value}},
+		}
+		files, err := getInputFiles(build)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(files, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, files)
+		}
+	}
+}
+
+func TestPlaceInputFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "input-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	srcDir := filepath.Join(root, "src")
+	dstDir := filepath.Join(root, "dst")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, contents := range map[string]string{"token": "secret", "ca.crt": "certificate"} {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, key), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = placeInputFiles(srcDir, dstDir, []inputFile{
+		{key: "token", path: ".config/tool/credentials", mode: 0600},
+		{key: "ca.crt", path: "ca.crt"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for path, expected := range map[string]struct {
+		contents string
+		mode     os.FileMode
+	}{
+		".config/tool/credentials": {"secret", 0600},
+		"ca.crt":                   {"certificate", 0644},
+	} {
+		dst := filepath.Join(dstDir, path)
+		if contents, err := ioutil.ReadFile(dst); err != nil || string(contents) != expected.contents {
+			t.Errorf("%s: expected %q, got %q: %v", path, expected.contents, contents, err)
+		}
+		if st, err := os.Stat(dst); err != nil || st.Mode().Perm() != expected.mode {
+			t.Errorf("%s: expected mode %#o, got %v: %v", path, expected.mode, st, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "token")); !os.IsNotExist(err) {
+		t.Errorf("expected only the placed keys to be copied")
+	}
+
+	if err := placeInputFiles(srcDir, dstDir, []inputFile{{key: "missing", path: "missing"}}); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
@@ -0,0 +1,120 @@
+// +build linux
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/containers/buildah"
+	"github.com/containers/buildah/util"
+
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// checkOCIIsolation and checkRootlessIsolation return why the builder is
+// unable to run RUN instructions with OCI or rootless OCI isolation, or nil
+// if it is able to.  Tests replace them.
+var (
+	checkOCIIsolation      = canUseOCIIsolation
+	checkRootlessIsolation = canUseRootlessIsolation
+)
+
+// isolationNames are the BUILD_ISOLATION values naming each isolation.
+var isolationNames = map[buildah.Isolation]string{
+	buildah.IsolationOCI:         "oci",
+	buildah.IsolationOCIRootless: "rootless",
+	buildah.IsolationChroot:      "chroot",
+}
+
+// getIsolation returns the isolation which RUN instructions are run with
+// when spec is requested.  When the builder is unable to use OCI isolation
+// it falls back to rootless OCI isolation, and from that to chroot, which
+// needs no more privileges than the builder already has, logging why.
+// "auto" asks for the strongest isolation the builder is able to use, and
+// an empty spec leaves the choice to buildah, unless the builder runs in a
+// user namespace of its own, when rootless OCI isolation is the strongest.
+func getIsolation(spec string) (buildah.Isolation, error) {
+	var isolation buildah.Isolation
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if Rootless() && (spec == "" || spec == "oci" || spec == "auto") {
+		// the builder is root only in its own user namespace, in which an
+		// OCI runtime is unable to set up cgroups
+		spec = "rootless"
+	}
+	switch spec {
+	case "":
+		return buildah.IsolationDefault, nil
+	case "chroot":
+		return buildah.IsolationChroot, nil
+	case "oci", "auto":
+		isolation = buildah.IsolationOCI
+	case "rootless":
+		isolation = buildah.IsolationOCIRootless
+	default:
+		return buildah.IsolationDefault, fmt.Errorf("unrecognized %s setting %q: must be \"oci\", \"rootless\", \"chroot\" or \"auto\"", builderutil.Isolation, spec)
+	}
+	for {
+		var err error
+		next := buildah.IsolationChroot
+		switch isolation {
+		case buildah.IsolationOCI:
+			err, next = checkOCIIsolation(), buildah.IsolationOCIRootless
+		case buildah.IsolationOCIRootless:
+			err = checkRootlessIsolation()
+		}
+		if err == nil || isolation == buildah.IsolationChroot {
+			log.V(0).Infof("Running RUN instructions with %s isolation", isolationNames[isolation])
+			return isolation, nil
+		}
+		log.V(0).Infof("Unable to use %s isolation, falling back to %s isolation: %v", isolationNames[isolation], isolationNames[next], err)
+		isolation = next
+	}
+}
+
+// canUseOCIIsolation returns why the builder is unable to run containers
+// with an OCI runtime in namespaces of their own, if it is unable to.
+func canUseOCIIsolation() error {
+	if _, err := exec.LookPath(util.Runtime()); err != nil {
+		return fmt.Errorf("the %s runtime was not found: %v", util.Runtime(), err)
+	}
+	return probeNamespaces("mount, PID, UTS and IPC namespaces", &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+	})
+}
+
+// canUseRootlessIsolation returns why the builder is unable to run
+// containers with an OCI runtime in a user namespace, if it is unable to.
+func canUseRootlessIsolation() error {
+	if _, err := exec.LookPath(util.Runtime()); err != nil {
+		return fmt.Errorf("the %s runtime was not found: %v", util.Runtime(), err)
+	}
+	if max, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(max)) == "0" {
+		return fmt.Errorf("user namespaces are disabled on the node")
+	}
+	return probeNamespaces("a user namespace", &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}},
+	})
+}
+
+// probeNamespaces returns an error if a process cannot be started with the
+// namespaces described by attr.
+func probeNamespaces(namespaces string, attr *syscall.SysProcAttr) error {
+	path, err := exec.LookPath("true")
+	if err != nil {
+		// nothing to probe with, so leave it to the runtime to fail
+		return nil
+	}
+	cmd := exec.Command(path)
+	cmd.SysProcAttr = attr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to create %s: %v", namespaces, err)
+	}
+	return nil
+}
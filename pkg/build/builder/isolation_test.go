@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package builder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containers/buildah"
+)
+
+func TestGetIsolation(t *testing.T) {
+	defer func(oci, rootless func() error) {
+		checkOCIIsolation, checkRootlessIsolation = oci, rootless
+	}(checkOCIIsolation, checkRootlessIsolation)
+	able := func() error { return nil }
+	unable := func() error { return errors.New("unable") }
+
+	tests := []struct {
+		spec          string
+		oci, rootless func() error
+		expected      buildah.Isolation
+		expectErr     bool
+	}{
+		{spec: "", oci: unable, rootless: unable, expected: buildah.IsolationDefault},
+		{spec: "chroot", oci: able, rootless: able, expected: buildah.IsolationChroot},
+		{spec: "OCI", oci: able, rootless: able, expected: buildah.IsolationOCI},
+		{spec: "oci", oci: unable, rootless: able, expected: buildah.IsolationOCIRootless},
+		{spec: "oci", oci: unable, rootless: unable, expected: buildah.IsolationChroot},
+		{spec: "rootless", oci: able, rootless: able, expected: buildah.IsolationOCIRootless},
+		{spec: "rootless", oci: able, rootless: unable, expected: buildah.IsolationChroot},
+		{spec: "auto", oci: able, rootless: able, expected: buildah.IsolationOCI},
+		{spec: "auto", oci: unable, rootless: unable, expected: buildah.IsolationChroot},
+		{spec: "vm", oci: able, rootless: able, expectErr: true},
+	}
+	for _, test := range tests {
+		checkOCIIsolation, checkRootlessIsolation = test.oci, test.rootless
+		isolation, err := getIsolation(test.spec)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: unexpected error %v", test.spec, err)
+			continue
+		}
+		if err == nil && isolation != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.spec, test.expected, isolation)
+		}
+	}
+}
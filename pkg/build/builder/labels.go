@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+const (
+	// ociRevisionLabel is the OCI image annotation holding the commit the
+	// image was built from.
+	ociRevisionLabel = "org.opencontainers.image.revision"
+	// ociSourceLabel is the OCI image annotation holding the URL of the
+	// repository the image was built from.
+	ociSourceLabel = "org.opencontainers.image.source"
+)
+
+// labelTemplateData holds the variables that the values of a build's output
+// image labels can refer to, as in "{{.Commit}}".
+type labelTemplateData struct {
+	// Commit is the ID of the commit being built
+	Commit string
+	// Ref is the git reference that was checked out
+	Ref string
+	// Source is the URL of the git repository being built
+	Source string
+	// Author is the author of the commit being built
+	Author string
+	// BuildName is the name of the build
+	BuildName string
+	// Namespace is the namespace of the build
+	Namespace string
+	// Timestamp is when the build started, or the date of a reproducible
+	// build, in RFC 3339 format
+	Timestamp string
+}
+
+// newLabelTemplateData returns the variables that the output image labels of
+// build, whose source is described by sourceInfo, can refer to.
+func newLabelTemplateData(build *buildapiv1.Build, sourceInfo *git.SourceInfo) labelTemplateData {
+	data := labelTemplateData{
+		BuildName: build.Name,
+		Namespace: build.Namespace,
+	}
+	if sourceInfo != nil {
+		data.Commit = sourceInfo.CommitID
+		data.Ref = sourceInfo.Ref
+		data.Source = sourceInfo.Location
+		data.Author = sourceInfo.AuthorName
+	}
+	if git := build.Spec.Source.Git; git != nil {
+		if len(git.Ref) > 0 {
+			data.Ref = git.Ref
+		}
+		if len(git.URI) > 0 {
+			data.Source = git.URI
+		}
+	}
+	started := time.Now()
+	if epoch, err := getSourceDateEpoch(build, sourceInfo); err == nil && epoch != nil {
+		started = *epoch
+	} else if build.Status.StartTimestamp != nil {
+		started = build.Status.StartTimestamp.Time
+	}
+	data.Timestamp = started.UTC().Format(time.RFC3339)
+	return data
+}
+
+// ociSourceLabels returns the OCI image annotations describing the commit
+// and repository in data, if the build is built from git.
+func ociSourceLabels(data labelTemplateData) map[string]string {
+	labels := map[string]string{}
+	if len(data.Commit) > 0 {
+		labels[ociRevisionLabel] = data.Commit
+	}
+	if len(data.Source) > 0 && len(data.Commit) > 0 {
+		labels[ociSourceLabel] = data.Source
+	}
+	return labels
+}
+
+// expandImageLabels returns the output image labels of build, with the
+// template variables in their values, such as "{{.Commit}}", replaced by
+// their values in data.
+func expandImageLabels(build *buildapiv1.Build, data labelTemplateData) ([]buildapiv1.ImageLabel, error) {
+	labels := make([]buildapiv1.ImageLabel, 0, len(build.Spec.Output.ImageLabels))
+	for _, lbl := range build.Spec.Output.ImageLabels {
+		if !strings.Contains(lbl.Value, "{{") {
+			labels = append(labels, lbl)
+			continue
+		}
+		tmpl, err := template.New(lbl.Name).Parse(lbl.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of image label %q: %v", lbl.Name, err)
+		}
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("error expanding the value of image label %q: %v", lbl.Name, err)
+		}
+		labels = append(labels, buildapiv1.ImageLabel{Name: lbl.Name, Value: value.String()})
+	}
+	return labels, nil
+}
@@ -0,0 +1,59 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+func TestExpandImageLabels(t *testing.T) {
+	build := makeBuild()
+	build.Name = "app-1"
+	build.Namespace = "demo"
+	started := metav1.NewTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	build.Status.StartTimestamp = &started
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "https://example.com/app.git", Ref: "main"}
+	build.Spec.Output.ImageLabels = []buildapiv1.ImageLabel{
+		{Name: "version", Value: "1.0"},
+		{Name: "org.opencontainers.image.version", Value: "{{.Ref}}-{{printf \"%.7s\" .Commit}}"},
+		{Name: "org.opencontainers.image.created", Value: "{{.Timestamp}}"},
+		{Name: "built-by", Value: "{{.Namespace}}/{{.BuildName}} from {{.Source}}"},
+	}
+	sourceInfo := &git.SourceInfo{}
+	sourceInfo.CommitID = "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee"
+
+	data := newLabelTemplateData(build, sourceInfo)
+	labels, err := expandImageLabels(build, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []buildapiv1.ImageLabel{
+		{Name: "version", Value: "1.0"},
+		{Name: "org.opencontainers.image.version", Value: "main-1575a90"},
+		{Name: "org.opencontainers.image.created", Value: "2020-01-02T03:04:05Z"},
+		{Name: "built-by", Value: "demo/app-1 from https://example.com/app.git"},
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+
+	expectedOCI := map[string]string{
+		"org.opencontainers.image.revision": "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee",
+		"org.opencontainers.image.source":   "https://example.com/app.git",
+	}
+	if oci := ociSourceLabels(data); !reflect.DeepEqual(oci, expectedOCI) {
+		t.Errorf("expected OCI labels %v, got %v", expectedOCI, oci)
+	}
+
+	for _, value := range []string{"{{.Commit", "{{.Branch}}"} {
+		build.Spec.Output.ImageLabels = []buildapiv1.ImageLabel{{Name: "bad", Value: value}}
+		if _, err := expandImageLabels(build, data); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
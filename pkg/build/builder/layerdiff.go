@@ -0,0 +1,328 @@
+package builder
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+)
+
+// layerDiffMaxFiles is the most added, removed or changed files which a
+// layer diff report lists, largest first.
+const layerDiffMaxFiles = 10
+
+// imageLayerReader is implemented by DockerClients which can read the layers
+// of local images and of images in registries.
+type imageLayerReader interface {
+	// ImageDiffIDs returns the digests of the uncompressed layers of the
+	// image name, which is local or, if remote is set, read from its
+	// registry using auth.
+	ImageDiffIDs(name string, remote bool, auth docker.AuthConfiguration) ([]string, error)
+	// ReadImageLayer calls read with the uncompressed content of the
+	// index'th layer of the image name, which is local or, if remote is
+	// set, read from its registry using auth.
+	ReadImageLayer(name string, remote bool, auth docker.AuthConfiguration, index int, read func(io.Reader) error) error
+}
+
+// layerDiffFile is a file listed in a layer diff report.
+type layerDiffFile struct {
+	Path string `json:"path"`
+	// Size is the size of the file or, for a file which changed, how much
+	// its size changed by
+	Size int64 `json:"size"`
+}
+
+// layerDiffReport describes how an image differs from the image previously
+// pushed to the same tag.  Layers are compared by their content, and the
+// layers the images share are those up to the first which differs, so the
+// files compared are those of the layers after it.
+type layerDiffReport struct {
+	Previous       string          `json:"previous"`
+	SharedLayers   int             `json:"sharedLayers"`
+	AddedLayers    int             `json:"addedLayers"`
+	RemovedLayers  int             `json:"removedLayers"`
+	SizeDelta      int64           `json:"sizeDelta"`
+	FilesAdded     int             `json:"filesAdded"`
+	FilesRemoved   int             `json:"filesRemoved"`
+	FilesChanged   int             `json:"filesChanged"`
+	LargestAdded   []layerDiffFile `json:"largestAdded,omitempty"`
+	LargestRemoved []layerDiffFile `json:"largestRemoved,omitempty"`
+	LargestChanged []layerDiffFile `json:"largestChanged,omitempty"`
+}
+
+// getLayerDiffReport returns whether the build strategy's environment
+// requests a report of how the built image differs from the one previously
+// pushed to its output tag.
+func getLayerDiffReport(build *buildapiv1.Build) (bool, error) {
+	value, ok := buildStrategyEnv(build, builderutil.LayerDiffReport)
+	if !ok || len(value) == 0 {
+		return false, nil
+	}
+	report, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %v", builderutil.LayerDiffReport, value, err)
+	}
+	return report, nil
+}
+
+// reportLayerDiff compares the local image name, built by build, with the
+// image previously pushed to pushTag, if the build asks for it, and writes
+// what changed to the build log and to the build's LayerDiffAnnotation.  The
+// report is informational, so problems making it are logged rather than
+// failing the build.
+func reportLayerDiff(client DockerClient, buildClient buildclientv1.BuildInterface, build *buildapiv1.Build, name, pushTag string) {
+	enabled, err := getLayerDiffReport(build)
+	if err != nil {
+		log.V(0).Infof("warning: %v", err)
+		return
+	}
+	if !enabled || len(pushTag) == 0 {
+		return
+	}
+	reader, ok := client.(imageLayerReader)
+	if !ok {
+		log.V(0).Infof("warning: Comparing the image with the one previously pushed is not supported by this build client")
+		return
+	}
+	auth, _ := dockercfg.NewHelper().GetDockerAuth(pushTag, dockercfg.PushAuthType)
+	previous, err := reader.ImageDiffIDs(pushTag, true, auth)
+	if err != nil {
+		log.V(0).Infof("No image previously pushed to %s could be read to compare with: %v", pushTag, err)
+		return
+	}
+	current, err := reader.ImageDiffIDs(name, false, docker.AuthConfiguration{})
+	if err != nil {
+		log.V(0).Infof("warning: Unable to read the layers of image %s: %v", name, err)
+		return
+	}
+	report, err := diffImageLayers(previous, current, func(remote bool, index int, read func(io.Reader) error) error {
+		if remote {
+			return reader.ReadImageLayer(pushTag, true, auth, index, read)
+		}
+		return reader.ReadImageLayer(name, false, docker.AuthConfiguration{}, index, read)
+	})
+	if err != nil {
+		log.V(0).Infof("warning: Unable to compare image %s with the one previously pushed to %s: %v", name, pushTag, err)
+		return
+	}
+	report.Previous = pushTag
+	log.V(0).Infof("%s", report)
+	annotateBuild(buildClient, build, builderutil.LayerDiffAnnotation, report)
+}
+
+// diffImageLayers compares the layers of an image, given by their diff IDs
+// in current, with those of the previous image, reading the content of the
+// layers which differ with read.
+func diffImageLayers(previous, current []string, read func(remote bool, index int, read func(io.Reader) error) error) (*layerDiffReport, error) {
+	shared := 0
+	for shared < len(previous) && shared < len(current) && previous[shared] == current[shared] {
+		shared++
+	}
+	report := &layerDiffReport{
+		SharedLayers:  shared,
+		AddedLayers:   len(current) - shared,
+		RemovedLayers: len(previous) - shared,
+	}
+	before, after := newLayerFiles(), newLayerFiles()
+	for i := shared; i < len(previous); i++ {
+		if err := read(true, i, before.add); err != nil {
+			return nil, fmt.Errorf("error reading layer %s of the previous image: %v", previous[i], err)
+		}
+	}
+	for i := shared; i < len(current); i++ {
+		if err := read(false, i, after.add); err != nil {
+			return nil, fmt.Errorf("error reading layer %s: %v", current[i], err)
+		}
+	}
+	report.SizeDelta = after.size - before.size
+
+	var added, removed, changed []layerDiffFile
+	for p, size := range after.files {
+		if previousSize, ok := before.files[p]; !ok {
+			added = append(added, layerDiffFile{Path: p, Size: size})
+		} else if previousSize != size {
+			changed = append(changed, layerDiffFile{Path: p, Size: size - previousSize})
+		}
+	}
+	for p, size := range before.files {
+		if _, ok := after.files[p]; !ok {
+			removed = append(removed, layerDiffFile{Path: p, Size: size})
+		}
+	}
+	// files deleted from the shared layers by only one of the images
+	for p := range after.deleted {
+		if _, ok := before.files[p]; !ok && !before.deleted[p] {
+			removed = append(removed, layerDiffFile{Path: p})
+		}
+	}
+	for p := range before.deleted {
+		if _, ok := after.files[p]; !ok && !after.deleted[p] {
+			added = append(added, layerDiffFile{Path: p})
+		}
+	}
+	report.FilesAdded, report.LargestAdded = len(added), largestFiles(added)
+	report.FilesRemoved, report.LargestRemoved = len(removed), largestFiles(removed)
+	report.FilesChanged, report.LargestChanged = len(changed), largestFiles(changed)
+	return report, nil
+}
+
+// largestFiles returns the layerDiffMaxFiles files which are largest, or
+// which changed size the most.
+func largestFiles(files []layerDiffFile) []layerDiffFile {
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if abs(files[i].Size) != abs(files[j].Size) {
+			return abs(files[i].Size) > abs(files[j].Size)
+		}
+		return files[i].Path < files[j].Path
+	})
+	if len(files) > layerDiffMaxFiles {
+		files = files[:layerDiffMaxFiles]
+	}
+	return files
+}
+
+// layerFiles holds the files which a series of layers leave, and those they
+// delete from the layers beneath them.
+type layerFiles struct {
+	// files holds the size of each file, by path
+	files   map[string]int64
+	deleted map[string]bool
+	// size is the total uncompressed size of the layers
+	size int64
+}
+
+func newLayerFiles() *layerFiles {
+	return &layerFiles{files: map[string]int64{}, deleted: map[string]bool{}}
+}
+
+// add applies the uncompressed layer read from r.
+func (l *layerFiles) add(r io.Reader) error {
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(p)
+		switch {
+		case base == ".wh..wh..opq":
+			l.remove(path.Clean(dir), false)
+		case strings.HasPrefix(base, ".wh."):
+			l.remove(path.Join(dir, strings.TrimPrefix(base, ".wh.")), true)
+		case hdr.Typeflag == tar.TypeDir:
+		default:
+			l.files[p] = hdr.Size
+			delete(l.deleted, p)
+		}
+	}
+	// count any padding after the end of the archive, as it is stored
+	if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+		return err
+	}
+	l.size += counter.n
+	return nil
+}
+
+// remove deletes the files beneath p and, unless p is a directory being made
+// opaque, p itself.
+func (l *layerFiles) remove(p string, self bool) {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for file := range l.files {
+		if (self && file == p) || strings.HasPrefix(file, prefix) {
+			delete(l.files, file)
+		}
+	}
+	if self {
+		l.deleted[p] = true
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// String describes the report for the build log.
+func (r *layerDiffReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nChanges since the image previously pushed to %s:\n", r.Previous)
+	if r.AddedLayers == 0 && r.RemovedLayers == 0 {
+		fmt.Fprintf(&b, "  The image has the same %d layers.\n", r.SharedLayers)
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	fmt.Fprintf(&b, "  Layers: %d shared, %d replaced by %d\n", r.SharedLayers, r.RemovedLayers, r.AddedLayers)
+	fmt.Fprintf(&b, "  Size: %+d bytes uncompressed\n", r.SizeDelta)
+	fmt.Fprintf(&b, "  Files: %d added, %d removed, %d changed\n", r.FilesAdded, r.FilesRemoved, r.FilesChanged)
+	for _, list := range []struct {
+		sign  string
+		files []layerDiffFile
+	}{{"+", r.LargestAdded}, {"-", r.LargestRemoved}, {"~", r.LargestChanged}} {
+		for _, file := range list.files {
+			if list.sign == "~" {
+				fmt.Fprintf(&b, "    %s %s (%+d bytes)\n", list.sign, file.Path, file.Size)
+			} else {
+				fmt.Fprintf(&b, "    %s %s (%d bytes)\n", list.sign, file.Path, file.Size)
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// annotateBuild sets the annotation key of the build to value, as JSON.
+func annotateBuild(client buildclientv1.BuildInterface, build *buildapiv1.Build, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.V(4).Infof("Unable to encode the %s annotation: %v", key, err)
+		return
+	}
+	if build.Annotations == nil {
+		build.Annotations = map[string]string{}
+	}
+	build.Annotations[key] = string(data)
+	if client == nil {
+		return
+	}
+	latest, err := client.Get(build.Name, metav1.GetOptions{})
+	if err != nil {
+		log.V(4).Infof("Unable to get build %s to set its %s annotation: %v", build.Name, key, err)
+		return
+	}
+	if latest.Annotations == nil {
+		latest.Annotations = map[string]string{}
+	}
+	latest.Annotations[key] = string(data)
+	if _, err := client.UpdateDetails(latest.Name, latest); err != nil {
+		log.V(4).Infof("Unable to set the %s annotation of build %s: %v", key, build.Name, err)
+	}
+}
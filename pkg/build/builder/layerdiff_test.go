@@ -0,0 +1,159 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
+)
+
+// testLayer returns an uncompressed layer holding files of the given sizes,
+// by path.  A size of -1 is a directory.
+func testLayer(t *testing.T, files ...interface{}) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i].(string), Mode: 0644, Typeflag: tar.TypeReg, Size: int64(files[i+1].(int))}
+		if hdr.Size < 0 {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, hdr.Size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type fakeLayerDocker struct {
+	FakeDocker
+	// local and remote hold the layers of the local image and of the
+	// image in the registry
+	local, remote [][]byte
+}
+
+func (d *fakeLayerDocker) layers(remote bool) [][]byte {
+	if remote {
+		return d.remote
+	}
+	return d.local
+}
+
+func (d *fakeLayerDocker) ImageDiffIDs(name string, remote bool, auth docker.AuthConfiguration) ([]string, error) {
+	layers := d.layers(remote)
+	if layers == nil {
+		return nil, fmt.Errorf("manifest unknown")
+	}
+	var diffIDs []string
+	for _, layer := range layers {
+		diffIDs = append(diffIDs, fmt.Sprintf("sha256:%x", sha256.Sum256(layer)))
+	}
+	return diffIDs, nil
+}
+
+func (d *fakeLayerDocker) ReadImageLayer(name string, remote bool, auth docker.AuthConfiguration, index int, read func(io.Reader) error) error {
+	return read(bytes.NewReader(d.layers(remote)[index]))
+}
+
+func TestDiffImageLayers(t *testing.T) {
+	base := testLayer(t, "usr/", -1, "usr/doc", 40, "etc/hosts", 10)
+	before := testLayer(t, "app/", -1, "app/bin", 100, "app/old", 50, "etc/conf", 10)
+	after := testLayer(t, "app/", -1, "app/bin", 300, "app/new", 20, "etc/conf", 10, "usr/.wh.doc", 0)
+	config := testLayer(t, "etc/app.d/", -1, "etc/app.d/.wh..wh..opq", 0, "etc/app.d/main", 5)
+
+	client := &fakeLayerDocker{local: [][]byte{base, after, config}, remote: [][]byte{base, before}}
+	previous, _ := client.ImageDiffIDs("", true, docker.AuthConfiguration{})
+	current, _ := client.ImageDiffIDs("", false, docker.AuthConfiguration{})
+	report, err := diffImageLayers(previous, current, func(remote bool, index int, read func(io.Reader) error) error {
+		return client.ReadImageLayer("", remote, docker.AuthConfiguration{}, index, read)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &layerDiffReport{
+		SharedLayers:   1,
+		AddedLayers:    2,
+		RemovedLayers:  1,
+		SizeDelta:      int64(len(after) + len(config) - len(before)),
+		FilesAdded:     2,
+		FilesRemoved:   2,
+		FilesChanged:   1,
+		LargestAdded:   []layerDiffFile{{Path: "/app/new", Size: 20}, {Path: "/etc/app.d/main", Size: 5}},
+		LargestRemoved: []layerDiffFile{{Path: "/app/old", Size: 50}, {Path: "/usr/doc"}},
+		LargestChanged: []layerDiffFile{{Path: "/app/bin", Size: 200}},
+	}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("expected %#v, got %#v", expect, report)
+	}
+}
+
+func TestReportLayerDiff(t *testing.T) {
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_LAYER_DIFF_REPORT", Value: "true"}},
+	}
+	buildClient := buildfake.NewSimpleClientset(build.DeepCopy()).BuildV1().Builds("ns")
+	base := testLayer(t, "etc/hosts", 10)
+
+	// there is no image to compare with before the first push
+	reportLayerDiff(&fakeLayerDocker{local: [][]byte{base}}, buildClient, build, "temp.builder.openshift.io/ns/app-1:1", "registry.example.com/ns/app:latest")
+	if _, ok := build.Annotations["openshift.io/build.layer-diff"]; ok {
+		t.Errorf("expected no report without a previous image")
+	}
+
+	client := &fakeLayerDocker{local: [][]byte{base, testLayer(t, "app", 30)}, remote: [][]byte{base, testLayer(t, "app", 10)}}
+	reportLayerDiff(client, buildClient, build, "temp.builder.openshift.io/ns/app-1:1", "registry.example.com/ns/app:latest")
+	latest, err := buildClient.Get("app-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &layerDiffReport{}
+	if err := json.Unmarshal([]byte(latest.Annotations["openshift.io/build.layer-diff"]), report); err != nil {
+		t.Fatalf("expected the report in the build's annotation: %v", err)
+	}
+	if report.Previous != "registry.example.com/ns/app:latest" || report.SharedLayers != 1 || report.FilesChanged != 1 || report.SizeDelta != 0 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	if build.Annotations["openshift.io/build.layer-diff"] != latest.Annotations["openshift.io/build.layer-diff"] {
+		t.Errorf("expected the annotation to be set on the build too")
+	}
+}
+
+func TestLayerDiffReportString(t *testing.T) {
+	report := &layerDiffReport{
+		Previous:       "registry.example.com/ns/app:latest",
+		SharedLayers:   3,
+		AddedLayers:    2,
+		RemovedLayers:  1,
+		SizeDelta:      2048,
+		FilesAdded:     1,
+		FilesChanged:   1,
+		LargestAdded:   []layerDiffFile{{Path: "/app/new", Size: 2000}},
+		LargestChanged: []layerDiffFile{{Path: "/app/bin", Size: -48}},
+	}
+	expect := "\nChanges since the image previously pushed to registry.example.com/ns/app:latest:\n" +
+		"  Layers: 3 shared, 1 replaced by 2\n" +
+		"  Size: +2048 bytes uncompressed\n" +
+		"  Files: 1 added, 0 removed, 1 changed\n" +
+		"    + /app/new (2000 bytes)\n" +
+		"    ~ /app/bin (-48 bytes)"
+	if report.String() != expect {
+		t.Errorf("expected %q, got %q", expect, report.String())
+	}
+}
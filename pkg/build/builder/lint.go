@@ -0,0 +1,178 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+const (
+	// lintModeOff skips linting the Dockerfile.
+	lintModeOff = "off"
+	// lintModeWarn logs the problems found in the Dockerfile.
+	lintModeWarn = "warn"
+	// lintModeFail fails the build if problems are found in the Dockerfile.
+	lintModeFail = "fail"
+)
+
+// lintRule is a check of the instructions of a Dockerfile, named after the
+// equivalent hadolint rule where there is one.
+type lintRule struct {
+	ID          string
+	Description string
+	// Check returns whether child, an instruction of a Dockerfile whose
+	// earlier stages have the given aliases, breaks the rule
+	Check func(child *parser.Node, aliases map[string]bool) bool
+}
+
+// lintProblem is an instruction of a Dockerfile which breaks a lintRule.
+type lintProblem struct {
+	Line int
+	Rule lintRule
+}
+
+func (p lintProblem) String() string {
+	return fmt.Sprintf("line %d: %s %s", p.Line, p.Rule.ID, p.Rule.Description)
+}
+
+// lintRules are the rules which Dockerfiles are linted with.
+var lintRules = []lintRule{
+	{
+		ID:          "DL3006",
+		Description: "Always tag the version of an image explicitly",
+		Check: func(child *parser.Node, aliases map[string]bool) bool {
+			named := lintFromImage(child, aliases)
+			return named != nil && ireference.IsNameOnly(named)
+		},
+	},
+	{
+		ID:          "DL3007",
+		Description: "Using latest is prone to errors if the image will ever update. Pin the version explicitly to a release tag",
+		Check: func(child *parser.Node, aliases map[string]bool) bool {
+			named := lintFromImage(child, aliases)
+			if tagged, ok := named.(ireference.NamedTagged); ok {
+				return tagged.Tag() == "latest"
+			}
+			return false
+		},
+	},
+	{
+		ID:          "DL3020",
+		Description: "Do not ADD from a URL, download with a RUN instruction which verifies it instead",
+		Check: func(child *parser.Node, aliases map[string]bool) bool {
+			if child.Value != dockercmd.Add {
+				return false
+			}
+			// the last argument is the destination
+			for arg := child.Next; arg != nil && arg.Next != nil; arg = arg.Next {
+				source := strings.ToLower(arg.Value)
+				if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		ID:          "DL4000",
+		Description: "MAINTAINER is deprecated, use a LABEL instead",
+		Check: func(child *parser.Node, aliases map[string]bool) bool {
+			return child.Value == dockercmd.Maintainer
+		},
+	},
+}
+
+// lintFromImage returns the image of child, if it is a FROM instruction which
+// names an image rather than an earlier stage, scratch or a build argument.
+func lintFromImage(child *parser.Node, aliases map[string]bool) ireference.Named {
+	if child.Value != dockercmd.From || child.Next == nil {
+		return nil
+	}
+	image := child.Next.Value
+	if aliases[strings.ToLower(image)] || image == "scratch" || strings.Contains(image, "$") {
+		return nil
+	}
+	named, err := ireference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil
+	}
+	if _, ok := named.(ireference.Digested); ok {
+		return nil
+	}
+	return named
+}
+
+// getDockerfileLintPolicy returns the lint mode requested by the build
+// strategy's environment, and the IDs of the rules it ignores.
+func getDockerfileLintPolicy(build *buildapiv1.Build) (string, map[string]bool, error) {
+	value, _ := buildStrategyEnv(build, builderutil.DockerfileLint)
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "":
+		mode = lintModeOff
+	case lintModeOff, lintModeWarn, lintModeFail:
+	default:
+		return "", nil, fmt.Errorf("invalid %s value %q, expected %q, %q or %q", builderutil.DockerfileLint, value, lintModeOff, lintModeWarn, lintModeFail)
+	}
+	ignored := map[string]bool{}
+	value, _ = buildStrategyEnv(build, builderutil.DockerfileLintIgnore)
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.ToUpper(strings.TrimSpace(id)); len(id) > 0 {
+			ignored[id] = true
+		}
+	}
+	return mode, ignored, nil
+}
+
+// lintDockerfile returns the problems found in node, a parsed Dockerfile, by
+// the lintRules which are not ignored.
+func lintDockerfile(node *parser.Node, ignored map[string]bool) []lintProblem {
+	var problems []lintProblem
+	aliases := map[string]bool{}
+	for _, child := range node.Children {
+		for _, rule := range lintRules {
+			if !ignored[rule.ID] && rule.Check(child, aliases) {
+				problems = append(problems, lintProblem{Line: child.StartLine, Rule: rule})
+			}
+		}
+		if child.Value == dockercmd.From && child.Next != nil && child.Next.Next != nil && strings.ToUpper(child.Next.Next.Value) == "AS" && child.Next.Next.Next != nil {
+			aliases[strings.ToLower(child.Next.Next.Next.Value)] = true
+		}
+	}
+	return problems
+}
+
+// checkDockerfileLint lints the Dockerfile of the build at dockerfilePath, if
+// requested by the build strategy's environment.  The problems found are
+// logged, and fail the build in the fail mode.
+func checkDockerfileLint(build *buildapiv1.Build, dockerfilePath string) error {
+	mode, ignored, err := getDockerfileLintPolicy(build)
+	if err != nil || mode == lintModeOff {
+		return err
+	}
+	node, err := imagebuilder.ParseFile(dockerfilePath)
+	if err != nil {
+		return err
+	}
+	problems := lintDockerfile(node, ignored)
+	if len(problems) == 0 {
+		log.V(2).Infof("The Dockerfile passed linting")
+		return nil
+	}
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, problem.String())
+	}
+	if mode == lintModeFail {
+		return fmt.Errorf("the Dockerfile failed linting:\n%s", strings.Join(lines, "\n"))
+	}
+	log.V(0).Infof("warning: The Dockerfile failed linting:\n%s", strings.Join(lines, "\n"))
+	return nil
+}
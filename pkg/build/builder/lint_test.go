@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/openshift/imagebuilder"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestLintDockerfile(t *testing.T) {
+	dockerfile := heredoc.Doc(`
+		FROM registry.access.redhat.com/ubi8/ubi:latest AS builder
+		MAINTAINER someone@example.com
+		ADD https://example.com/tool.tar.gz /tmp/
+		ADD tool.tar.gz /tmp/
+		FROM golang
+		FROM BUILDER
+		FROM busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000
+		ARG BASE
+		FROM $BASE
+		FROM scratch
+		COPY --from=builder /tmp/tool /tool
+	`)
+	node, err := imagebuilder.ParseDockerfile(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, problem := range lintDockerfile(node, nil) {
+		got = append(got, fmt.Sprintf("%d:%s", problem.Line, problem.Rule.ID))
+	}
+	expected := []string{"1:DL3007", "2:DL4000", "3:DL3020", "5:DL3006"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected problems %v, got %v", expected, got)
+	}
+
+	if problems := lintDockerfile(node, map[string]bool{"DL3007": true, "DL4000": true, "DL3020": true, "DL3006": true}); len(problems) != 0 {
+		t.Errorf("expected ignored rules to find no problems, got %v", problems)
+	}
+}
+
+func TestGetDockerfileLintPolicy(t *testing.T) {
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	if mode, _, err := getDockerfileLintPolicy(build); err != nil || mode != "off" {
+		t.Errorf("expected linting to be off by default, got %q, %v", mode, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{
+		{Name: "BUILD_DOCKERFILE_LINT", Value: "Fail"},
+		{Name: "BUILD_DOCKERFILE_LINT_IGNORE", Value: "dl4000, DL3006"},
+	}
+	mode, ignored, err := getDockerfileLintPolicy(build)
+	if err != nil || mode != "fail" || !reflect.DeepEqual(ignored, map[string]bool{"DL4000": true, "DL3006": true}) {
+		t.Errorf("expected the fail mode ignoring DL4000 and DL3006, got %q, %v, %v", mode, ignored, err)
+	}
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_DOCKERFILE_LINT", Value: "strict"}}
+	if _, _, err := getDockerfileLintPolicy(build); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func TestCheckDockerfileLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfile-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfilePath := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte("FROM busybox:latest\nRUN true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for mode, expectErr := range map[string]bool{"": false, "off": false, "warn": false, "fail": true} {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_DOCKERFILE_LINT", Value: mode}},
+		}
+		if err := checkDockerfileLint(build, dockerfilePath); (err != nil) != expectErr {
+			t.Errorf("mode %q: expected error %v, got %v", mode, expectErr, err)
+		}
+		build.Spec.Strategy.DockerStrategy.Env = append(build.Spec.Strategy.DockerStrategy.Env, corev1.EnvVar{Name: "BUILD_DOCKERFILE_LINT_IGNORE", Value: "DL3007"})
+		if err := checkDockerfileLint(build, dockerfilePath); err != nil {
+			t.Errorf("mode %q: expected no error ignoring DL3007, got %v", mode, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+// LinkLocalBuildInputs arranges for the build's input secrets and configmaps
+// to be found where a build pod mounts them, by linking there the
+// directories of the same names in secretsDir and configMapsDir, so that a
+// build can be run outside of a cluster.
+func LinkLocalBuildInputs(build *buildapiv1.Build, secretsDir, configMapsDir string) error {
+	for _, s := range build.Spec.Source.Secrets {
+		if err := linkLocalBuildInput("secret", s.Secret.Name, secretsDir, secretBuildSourceBaseMountPath); err != nil {
+			return err
+		}
+	}
+	for _, c := range build.Spec.Source.ConfigMaps {
+		if err := linkLocalBuildInput("configmap", c.ConfigMap.Name, configMapsDir, configMapBuildSourceBaseMountPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkLocalBuildInput links the directory name in dir, which holds the keys
+// of the build input of the given kind, to name in mountPath.
+func linkLocalBuildInput(kind, name, dir, mountPath string) error {
+	if len(dir) == 0 {
+		return fmt.Errorf("the build's input %s %q requires a directory of %ss", kind, name, kind)
+	}
+	source, err := filepath.Abs(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return fmt.Errorf("the build's input %s %q is not a directory in %s", kind, name, dir)
+	}
+	target := filepath.Join(mountPath, name)
+	if info, err := os.Lstat(target); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("unable to link the build's input %s %q: %s already exists", kind, name, target)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		return err
+	}
+	return os.Symlink(source, target)
+}
@@ -0,0 +1,43 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkLocalBuildInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secrets := filepath.Join(dir, "secrets")
+	mountPath := filepath.Join(dir, "mounts")
+	writeTestFile(t, filepath.Join(secrets, "npmrc", ".npmrc"), "token\n")
+
+	for i := 0; i < 2; i++ {
+		if err := linkLocalBuildInput("secret", "npmrc", secrets, mountPath); err != nil {
+			t.Fatalf("unexpected error linking the input again: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(mountPath, "npmrc", ".npmrc"))
+	if err != nil || string(data) != "token\n" {
+		t.Errorf("expected the input to be linked, got %q: %v", data, err)
+	}
+
+	if err := linkLocalBuildInput("secret", "missing", secrets, mountPath); err == nil {
+		t.Errorf("expected an error for a missing input")
+	}
+	if err := linkLocalBuildInput("secret", "npmrc", "", mountPath); err == nil {
+		t.Errorf("expected an error without a directory of inputs")
+	}
+	if err := os.MkdirAll(filepath.Join(mountPath, "mounted"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(secrets, "mounted", "key"), "value")
+	if err := linkLocalBuildInput("secret", "mounted", secrets, mountPath); err == nil {
+		t.Errorf("expected an error replacing a directory which is not a link")
+	}
+}
@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// LoadLogScrubRules reads the regular expressions whose matches are masked in
+// the builder's output from the file at path, typically a key of a ConfigMap
+// mounted into the builder pod.  The file holds one regular expression per
+// line, and blank lines and lines starting with # are ignored.
+func LoadLogScrubRules(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rules, err := readLogScrubRules(f)
+	if err != nil {
+		return fmt.Errorf("error reading log scrub rules from %s: %v", path, err)
+	}
+	utillog.AddScrubRules(rules...)
+	log.V(2).Infof("Loaded %d log scrub rules from %s", len(rules), path)
+	return nil
+}
+
+// readLogScrubRules returns the regular expressions in r, one per line.
+func readLogScrubRules(r io.Reader) ([]*regexp.Regexp, error) {
+	var rules []*regexp.Regexp
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ConfigureLogScrubbing masks the build's secrets wherever they appear in the
+// builder's output: the values of the strategy's environment variables which
+// come from secrets or whose names suggest that they hold secrets, those of
+// the build args which do or which $BUILD_SENSITIVE_ARGS names, and the
+// registry credentials of the build's pull and push secrets.
+func ConfigureLogScrubbing(build *buildapiv1.Build) {
+	utillog.AddSecrets(buildSecretValues(build)...)
+	utillog.AddSecrets(registryCredentials()...)
+}
+
+// buildSecretValues returns the values of the build's strategy environment
+// and build args which are secrets.
+func buildSecretValues(build *buildapiv1.Build) []string {
+	isSecret := func(env corev1.EnvVar) bool {
+		return (env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil) || hasSecretName(env.Name)
+	}
+	var values []string
+	for _, env := range buildStrategyEnvVars(build) {
+		if isSecret(env) {
+			values = append(values, env.Value)
+		}
+	}
+	if build.Spec.Strategy.DockerStrategy == nil {
+		return values
+	}
+	sensitive := make(map[string]bool)
+	value, _ := buildStrategyEnv(build, builderutil.SensitiveBuildArgs)
+	for _, name := range strings.Split(value, ",") {
+		sensitive[strings.TrimSpace(name)] = true
+	}
+	for _, arg := range build.Spec.Strategy.DockerStrategy.BuildArgs {
+		if isSecret(arg) || sensitive[arg.Name] {
+			values = append(values, arg.Value)
+		}
+	}
+	return values
+}
+
+// registryCredentials returns the passwords, and the encoded user names and
+// passwords, of the registry credentials of the build's pull and push
+// secrets, and of the pull secrets of its image sources.
+func registryCredentials() []string {
+	authTypes := []string{dockercfg.PullAuthType, dockercfg.PushAuthType}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, dockercfg.PullSourceAuthType) {
+			authTypes = append(authTypes, strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	helper := dockercfg.NewHelper()
+	var values []string
+	for _, authType := range authTypes {
+		searchPaths := helper.GetDockerAuthSearchPaths(authType)
+		if len(searchPaths) == 0 {
+			continue
+		}
+		cfg, err := dockercfg.GetDockerConfig(searchPaths)
+		if err != nil {
+			continue
+		}
+		for _, entry := range cfg {
+			if len(entry.Password) == 0 {
+				continue
+			}
+			values = append(values, entry.Password, base64.StdEncoding.EncodeToString([]byte(entry.Username+":"+entry.Password)))
+		}
+	}
+	return values
+}
@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+)
+
+func TestReadLogScrubRules(t *testing.T) {
+	rules, err := readLogScrubRules(strings.NewReader("# GitHub tokens\nghp_[A-Za-z0-9]+\n\n  (?i)api[_-]key=(\\S+)  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rule := range rules {
+		got = append(got, rule.String())
+	}
+	if expect := []string{"ghp_[A-Za-z0-9]+", `(?i)api[_-]key=(\S+)`}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	if _, err := readLogScrubRules(strings.NewReader("valid\n(unclosed\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+}
+
+func TestBuildSecretValues(t *testing.T) {
+	secretRef := &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "key"}}
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_SENSITIVE_ARGS", Value: "LICENSE, OTHER"},
+			{Name: "GITHUB_TOKEN", Value: "env-token"},
+			{Name: "FROM_SECRET", Value: "env-from-secret", ValueFrom: secretRef},
+			{Name: "VERSION", Value: "1.0.0"},
+		},
+		BuildArgs: []corev1.EnvVar{
+			{Name: "LICENSE", Value: "arg-license"},
+			{Name: "DB_PASSWORD", Value: "arg-password"},
+			{Name: "KEY", Value: "arg-from-secret", ValueFrom: secretRef},
+			{Name: "VERSION", Value: "1.0.0"},
+		},
+	}
+	got := buildSecretValues(build)
+	sort.Strings(got)
+	expect := []string{"arg-from-secret", "arg-license", "arg-password", "env-from-secret", "env-token"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, dockercfg.DockerConfigJsonKey), `{"auths":{"registry.example.com":{"auth":"dXNlcjpwdXNoLXBhc3N3b3Jk"}}}`)
+
+	for _, name := range []string{dockercfg.PullAuthType, dockercfg.PushAuthType} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+	os.Setenv(dockercfg.PushAuthType, dir)
+	expect := []string{"push-password", "dXNlcjpwdXNoLXBhc3N3b3Jk"}
+	if got := registryCredentials(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+var log = utillog.ToFile(os.Stderr, 2)
+
+const (
+	// contentType is the media type of the Prometheus text exposition format.
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+	// pushJob is the job that metrics are grouped under by a Pushgateway.
+	pushJob = "openshift-builder"
+)
+
+// recorder holds the measurements of the build run by this process.  There
+// is only ever one build per process, so they are kept in a package-level
+// recorder, like the Prometheus default registry.
+var recorder = struct {
+	sync.Mutex
+	bytesPulled      int64
+	bytesPushed      int64
+	layerCacheHits   int64
+	layerCacheMisses int64
+	layerCacheImages int64
+	imagePulls       map[string]float64
+	build            *buildapiv1.Build
+	result           string
+	finished         bool
+	start            time.Time
+	duration         time.Duration
+}{start: time.Now()}
+
+// AddBytesPulled records that the layers of an image pulled for the build
+// were n bytes in size.
+func AddBytesPulled(n int64) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.bytesPulled += n
+}
+
+// AddBytesPushed records that the layers of an image pushed by the build were
+// n bytes in size.
+func AddBytesPushed(n int64) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.bytesPushed += n
+}
+
+// RecordImagePull records that pulling the named image for the build took d.
+func RecordImagePull(image string, d time.Duration) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.imagePulls == nil {
+		recorder.imagePulls = map[string]float64{}
+	}
+	recorder.imagePulls[image] += d.Seconds()
+}
+
+// RecordLayerCacheImport records the number of images that were imported from
+// a registry layer cache.  An import of no images is a cache miss.
+func RecordLayerCacheImport(images int) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if images > 0 {
+		recorder.layerCacheHits++
+	} else {
+		recorder.layerCacheMisses++
+	}
+	recorder.layerCacheImages += int64(images)
+}
+
+// SetBuild records the build whose metrics are reported, and whose namespace
+// and name label them.
+func SetBuild(build *buildapiv1.Build) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.build = build
+}
+
+// RecordResult records that the build has finished, successfully if err is
+// nil.  The stage and step durations are taken from the build's status.
+func RecordResult(build *buildapiv1.Build, err error) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.build = build
+	recorder.finished = true
+	recorder.duration = time.Since(recorder.start)
+	switch {
+	case err == nil:
+		recorder.result = "Success"
+	case len(build.Status.Reason) > 0:
+		recorder.result = string(build.Status.Reason)
+	default:
+		recorder.result = "Error"
+	}
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	buf := &bytes.Buffer{}
+	labels := map[string]string{}
+	var stages []buildapiv1.StageInfo
+	if build := recorder.build; build != nil {
+		labels["namespace"] = build.Namespace
+		labels["build"] = build.Name
+		stages = build.Status.Stages
+	}
+
+	writeFamily(buf, "openshift_build_stage_duration_seconds", "gauge", "Time spent in each stage of the build.")
+	for _, stage := range stages {
+		writeSample(buf, "openshift_build_stage_duration_seconds", withLabels(labels, "stage", string(stage.Name)), float64(stage.DurationMilliseconds)/1000)
+	}
+	writeFamily(buf, "openshift_build_step_duration_seconds", "gauge", "Time spent in each step of the build.")
+	for _, stage := range stages {
+		for _, step := range stage.Steps {
+			writeSample(buf, "openshift_build_step_duration_seconds", withLabels(labels, "stage", string(stage.Name), "step", string(step.Name)), float64(step.DurationMilliseconds)/1000)
+		}
+	}
+	writeFamily(buf, "openshift_build_image_pull_duration_seconds", "gauge", "Time spent pulling each image pulled for the build.")
+	images := make([]string, 0, len(recorder.imagePulls))
+	for image := range recorder.imagePulls {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		writeSample(buf, "openshift_build_image_pull_duration_seconds", withLabels(labels, "image", image), recorder.imagePulls[image])
+	}
+	writeFamily(buf, "openshift_build_pulled_bytes_total", "counter", "Size of the layers of the images pulled for the build.")
+	writeSample(buf, "openshift_build_pulled_bytes_total", labels, float64(recorder.bytesPulled))
+	writeFamily(buf, "openshift_build_pushed_bytes_total", "counter", "Size of the layers of the images pushed by the build.")
+	writeSample(buf, "openshift_build_pushed_bytes_total", labels, float64(recorder.bytesPushed))
+	writeFamily(buf, "openshift_build_layer_cache_imports_total", "counter", "Layer cache imports, by whether any cached layers were found.")
+	writeSample(buf, "openshift_build_layer_cache_imports_total", withLabels(labels, "result", "hit"), float64(recorder.layerCacheHits))
+	writeSample(buf, "openshift_build_layer_cache_imports_total", withLabels(labels, "result", "miss"), float64(recorder.layerCacheMisses))
+	writeFamily(buf, "openshift_build_layer_cache_images_total", "counter", "Images imported from layer caches.")
+	writeSample(buf, "openshift_build_layer_cache_images_total", labels, float64(recorder.layerCacheImages))
+	if recorder.finished {
+		writeFamily(buf, "openshift_build_duration_seconds", "gauge", "Time taken by the builder to run the build.")
+		writeSample(buf, "openshift_build_duration_seconds", labels, recorder.duration.Seconds())
+		writeFamily(buf, "openshift_build_result", "gauge", "The result of the build, 1 for the result it finished with.")
+		writeSample(buf, "openshift_build_result", withLabels(labels, "result", recorder.result), 1)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeFamily(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels map[string]string, value float64) {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, labels[key]))
+	}
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %v\n", name, strings.Join(pairs, ","), value)
+	} else {
+		fmt.Fprintf(w, "%s %v\n", name, value)
+	}
+}
+
+// withLabels returns a copy of labels with the given key/value pairs added.
+func withLabels(labels map[string]string, pairs ...string) map[string]string {
+	result := map[string]string{}
+	for key, value := range labels {
+		result[key] = value
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result[pairs[i]] = pairs[i+1]
+	}
+	return result
+}
+
+// Handler returns an http.Handler which serves the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if err := Write(w); err != nil {
+			log.V(4).Infof("Error writing metrics: %v", err)
+		}
+	})
+}
+
+// Serve serves the metrics at /metrics on address until the returned
+// function is called.
+func Serve(address string) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	log.V(2).Infof("Serving metrics on %s", listener.Addr())
+	return func() { server.Close() }, nil
+}
+
+// Push replaces the metrics held by the Pushgateway at gateway for this
+// build with the current ones.
+func Push(gateway string) error {
+	recorder.Lock()
+	build := recorder.build
+	recorder.Unlock()
+	if build == nil {
+		return fmt.Errorf("no build to push metrics for")
+	}
+	buf := &bytes.Buffer{}
+	if err := Write(buf); err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s/metrics/job/%s/namespace/%s/build/%s", strings.TrimSuffix(gateway, "/"), pushJob, url.PathEscape(build.Namespace), url.PathEscape(build.Name))
+	req, err := http.NewRequest(http.MethodPut, target, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing metrics to %s failed: %s", gateway, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func testBuild() *buildapiv1.Build {
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	build.Status.Stages = []buildapiv1.StageInfo{
+		{
+			Name:                 buildapiv1.StageBuild,
+			DurationMilliseconds: 1500,
+			Steps: []buildapiv1.StepInfo{
+				{Name: buildapiv1.StepDockerBuild, DurationMilliseconds: 1500},
+			},
+		},
+	}
+	return build
+}
+
+func TestWrite(t *testing.T) {
+	recorder.bytesPulled, recorder.bytesPushed = 0, 0
+	recorder.layerCacheHits, recorder.layerCacheMisses, recorder.layerCacheImages = 0, 0, 0
+	recorder.imagePulls = nil
+	recorder.finished = false
+
+	build := testBuild()
+	SetBuild(build)
+	AddBytesPulled(100)
+	AddBytesPulled(50)
+	AddBytesPushed(200)
+	RecordLayerCacheImport(3)
+	RecordLayerCacheImport(0)
+	RecordImagePull("registry.example.com/builder:latest", 2500*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	if err := Write(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		`openshift_build_stage_duration_seconds{build="app-1",namespace="ns",stage="Build"} 1.5`,
+		`openshift_build_step_duration_seconds{build="app-1",namespace="ns",stage="Build",step="DockerBuild"} 1.5`,
+		`openshift_build_image_pull_duration_seconds{build="app-1",image="registry.example.com/builder:latest",namespace="ns"} 2.5`,
+		`openshift_build_pulled_bytes_total{build="app-1",namespace="ns"} 150`,
+		`openshift_build_pushed_bytes_total{build="app-1",namespace="ns"} 200`,
+		`openshift_build_layer_cache_imports_total{build="app-1",namespace="ns",result="hit"} 1`,
+		`openshift_build_layer_cache_imports_total{build="app-1",namespace="ns",result="miss"} 1`,
+		`openshift_build_layer_cache_images_total{build="app-1",namespace="ns"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "openshift_build_result") {
+		t.Errorf("unexpected result before the build finished:\n%s", buf.String())
+	}
+
+	build.Status.Reason = buildapiv1.StatusReasonDockerBuildFailed
+	RecordResult(build, errors.New("failed"))
+	buf.Reset()
+	if err := Write(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	line := `openshift_build_result{build="app-1",namespace="ns",result="DockerBuildFailed"} 1`
+	if !strings.Contains(buf.String(), line+"\n") {
+		t.Errorf("expected %q in metrics:\n%s", line, buf.String())
+	}
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	RecordResult(testBuild(), nil)
+	if err := Push(server.URL + "/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/openshift-builder/namespace/ns/build/app-1" {
+		t.Errorf("unexpected request %s %s", method, path)
+	}
+	if !strings.Contains(body, `result="Success"`) {
+		t.Errorf("expected the build result to be pushed:\n%s", body)
+	}
+}
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// neverContactSource is the mirrorSourcePolicy of an ImageDigestMirrorSet or
+// ImageTagMirrorSet entry whose images are only pulled from its mirrors.
+const neverContactSource = "NeverContactSource"
+
+// imageMirrorSet maps a source repository, or a registry or namespace
+// holding repositories, to the locations it is mirrored at.
+type imageMirrorSet struct {
+	Source             string   `json:"source"`
+	Mirrors            []string `json:"mirrors"`
+	MirrorSourcePolicy string   `json:"mirrorSourcePolicy"`
+}
+
+// imageMirrorPolicy is the part of an ImageContentSourcePolicy,
+// ImageDigestMirrorSet or ImageTagMirrorSet, or of a list of them, that
+// mirrors are read from.
+type imageMirrorPolicy struct {
+	Spec struct {
+		RepositoryDigestMirrors []imageMirrorSet `json:"repositoryDigestMirrors"`
+		ImageDigestMirrors      []imageMirrorSet `json:"imageDigestMirrors"`
+		ImageTagMirrors         []imageMirrorSet `json:"imageTagMirrors"`
+	} `json:"spec"`
+	Items []imageMirrorPolicy `json:"items"`
+}
+
+var (
+	// digestMirrors are the mirrors of images pulled by digest.
+	digestMirrors []imageMirrorSet
+	// tagMirrors are the mirrors of images pulled by tag.
+	tagMirrors []imageMirrorSet
+)
+
+// LoadImageMirrors reads the mirrors which base and builder images are pulled
+// from, in place of their source repositories, from the
+// ImageContentSourcePolicy, ImageDigestMirrorSet and ImageTagMirrorSet
+// objects, in YAML or JSON, in the file at path.
+func LoadImageMirrors(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	digest, tag, err := readImageMirrors(f)
+	if err != nil {
+		return fmt.Errorf("error reading image mirrors from %s: %v", path, err)
+	}
+	digestMirrors, tagMirrors = digest, tag
+	log.V(2).Infof("Loaded %d digest and %d tag image mirror sets from %s", len(digestMirrors), len(tagMirrors), path)
+	return nil
+}
+
+// readImageMirrors returns the digest and tag mirrors of the policies in r,
+// which holds one or more YAML documents or JSON objects.
+func readImageMirrors(r io.Reader) ([]imageMirrorSet, []imageMirrorSet, error) {
+	var digest, tag []imageMirrorSet
+	var add func(policy imageMirrorPolicy)
+	add = func(policy imageMirrorPolicy) {
+		digest = append(digest, policy.Spec.RepositoryDigestMirrors...)
+		digest = append(digest, policy.Spec.ImageDigestMirrors...)
+		tag = append(tag, policy.Spec.ImageTagMirrors...)
+		for _, item := range policy.Items {
+			add(item)
+		}
+	}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var policy imageMirrorPolicy
+		if err := decoder.Decode(&policy); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		add(policy)
+	}
+	for _, set := range append(append([]imageMirrorSet{}, digest...), tag...) {
+		if len(set.Source) == 0 {
+			return nil, nil, fmt.Errorf("an image mirror set has no source")
+		}
+	}
+	return digest, tag, nil
+}
+
+// imageMirrorNames returns the names which name is pulled from, in the order
+// they are tried: the mirrors of its repository, then name itself unless the
+// mirrors are never to fall back to their source.
+func imageMirrorNames(name string) []string {
+	named, err := ireference.ParseNormalizedNamed(name)
+	if err != nil {
+		return []string{name}
+	}
+	repository := named.Name()
+	var suffix string
+	var sets []imageMirrorSet
+	if digested, ok := named.(ireference.Digested); ok {
+		suffix = "@" + digested.Digest().String()
+		sets = digestMirrors
+	} else {
+		suffix = ":" + ireference.TagNameOnly(named).(ireference.Tagged).Tag()
+		sets = tagMirrors
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	contactSource := true
+	for _, set := range sets {
+		source := strings.TrimSuffix(set.Source, "/")
+		if repository != source && !strings.HasPrefix(repository, source+"/") {
+			continue
+		}
+		if set.MirrorSourcePolicy == neverContactSource {
+			contactSource = false
+		}
+		for _, mirror := range set.Mirrors {
+			mirrored := strings.TrimSuffix(mirror, "/") + strings.TrimPrefix(repository, source) + suffix
+			if !seen[mirrored] {
+				seen[mirrored] = true
+				names = append(names, mirrored)
+			}
+		}
+	}
+	if contactSource || len(names) == 0 {
+		names = append(names, name)
+	}
+	return names
+}
+
+// pullImageFromMirrors pulls name with pull from the first of its mirrors
+// which has it, or else from its source, and adds name to the image when it
+// is pulled from a mirror, so that builds referring to name use the copy.
+func pullImageFromMirrors(client DockerClient, name string, pull func(name string) error) error {
+	names := imageMirrorNames(name)
+	var err error
+	for _, mirrored := range names {
+		if mirrored == name {
+			return pull(name)
+		}
+		log.V(0).Infof("Pulling image %s from the mirror %s ...", name, mirrored)
+		if err = pull(mirrored); err != nil {
+			log.V(0).Infof("warning: Failed to pull image %s from the mirror %s: %v", name, mirrored, err)
+			continue
+		}
+		repository, tag := docker.ParseRepositoryTag(name)
+		if tag == "" && strings.Contains(name, "@") {
+			repository = name
+		}
+		return client.TagImage(mirrored, docker.TagImageOptions{Repo: repository, Tag: tag})
+	}
+	return fmt.Errorf("failed to pull image %s from any of its mirrors: %v", name, err)
+}
+
+// imageMirrorsConfigured returns whether any image mirrors were loaded.
+func imageMirrorsConfigured() bool {
+	return len(digestMirrors) > 0 || len(tagMirrors) > 0
+}
@@ -0,0 +1,136 @@
+package builder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const testMirrors = `
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: release
+spec:
+  repositoryDigestMirrors:
+  - source: quay.io/openshift-release-dev/ocp-release
+    mirrors:
+    - mirror.example.com/ocp/release
+---
+apiVersion: config.openshift.io/v1
+kind: ImageTagMirrorSet
+metadata:
+  name: ubi
+spec:
+  imageTagMirrors:
+  - source: registry.access.redhat.com
+    mirrors:
+    - mirror.example.com/rhacr
+    - backup.example.com/rhacr/
+---
+{"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "config.openshift.io/v1", "kind": "ImageDigestMirrorSet", "spec": {"imageDigestMirrors": [{"source": "docker.io/library", "mirrors": ["mirror.example.com/library"], "mirrorSourcePolicy": "NeverContactSource"}]}}]}
+`
+
+func TestImageMirrorNames(t *testing.T) {
+	defer func(digest, tag []imageMirrorSet) {
+		digestMirrors, tagMirrors = digest, tag
+	}(digestMirrors, tagMirrors)
+
+	digest, tag, err := readImageMirrors(strings.NewReader(testMirrors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digest) != 2 || len(tag) != 1 {
+		t.Fatalf("expected 2 digest and 1 tag mirror sets, got %v and %v", digest, tag)
+	}
+	digestMirrors, tagMirrors = digest, tag
+
+	tests := []struct {
+		name   string
+		expect []string
+	}{
+		{
+			name: "quay.io/openshift-release-dev/ocp-release@" + testDigest,
+			expect: []string{
+				"mirror.example.com/ocp/release@" + testDigest,
+				"quay.io/openshift-release-dev/ocp-release@" + testDigest,
+			},
+		},
+		{
+			name:   "quay.io/openshift-release-dev/ocp-release:4.4",
+			expect: []string{"quay.io/openshift-release-dev/ocp-release:4.4"},
+		},
+		{
+			name:   "quay.io/openshift-release-dev/ocp-release-nightly@" + testDigest,
+			expect: []string{"quay.io/openshift-release-dev/ocp-release-nightly@" + testDigest},
+		},
+		{
+			name: "registry.access.redhat.com/ubi8/ubi",
+			expect: []string{
+				"mirror.example.com/rhacr/ubi8/ubi:latest",
+				"backup.example.com/rhacr/ubi8/ubi:latest",
+				"registry.access.redhat.com/ubi8/ubi",
+			},
+		},
+		{
+			name:   "busybox@" + testDigest,
+			expect: []string{"mirror.example.com/library/busybox@" + testDigest},
+		},
+		{
+			name:   "busybox:1.36",
+			expect: []string{"busybox:1.36"},
+		},
+	}
+	for _, test := range tests {
+		if names := imageMirrorNames(test.name); !reflect.DeepEqual(names, test.expect) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expect, names)
+		}
+	}
+
+	if _, _, err := readImageMirrors(strings.NewReader(heredoc.Doc(`
+		spec:
+		  imageDigestMirrors:
+		  - mirrors:
+		    - mirror.example.com
+	`))); err == nil {
+		t.Errorf("expected an error for a mirror set without a source")
+	}
+}
+
+func TestPullImageFromMirrors(t *testing.T) {
+	defer func(digest, tag []imageMirrorSet) {
+		digestMirrors, tagMirrors = digest, tag
+	}(digestMirrors, tagMirrors)
+	tagMirrors = []imageMirrorSet{{Source: "registry.example.com", Mirrors: []string{"missing.example.com", "mirror.example.com"}}}
+
+	client := NewFakeDockerClient()
+	var pulled []string
+	pull := func(name string) error {
+		pulled = append(pulled, name)
+		if strings.HasPrefix(name, "missing.example.com/") {
+			return fmt.Errorf("manifest unknown")
+		}
+		return nil
+	}
+	if err := pullImageFromMirrors(client, "registry.example.com/app/base:1", pull); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"missing.example.com/app/base:1", "mirror.example.com/app/base:1"}
+	if !reflect.DeepEqual(pulled, expected) {
+		t.Errorf("expected pulls of %v, got %v", expected, pulled)
+	}
+	expectedCalls := []methodCall{{"TagImage", []interface{}{"mirror.example.com/app/base:1", docker.TagImageOptions{Repo: "registry.example.com/app/base", Tag: "1"}}}}
+	if !reflect.DeepEqual(client.callLog, expectedCalls) {
+		t.Errorf("expected calls %v, got %v", expectedCalls, client.callLog)
+	}
+
+	// images without mirrors are pulled from their source
+	pulled = nil
+	if err := pullImageFromMirrors(client, "quay.io/app/base:1", pull); err != nil || !reflect.DeepEqual(pulled, []string{"quay.io/app/base:1"}) {
+		t.Errorf("expected a pull of the source, got %v, %v", pulled, err)
+	}
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// pushedOutput is an additional output the built image was pushed to.
+type pushedOutput struct {
+	Name   string
+	Digest string
+}
+
+// getAdditionalOutputs returns the image references, besides pushTag, that
+// the build's image is also pushed to.  An entry which starts with a colon
+// is a tag in the repository of pushTag.
+func getAdditionalOutputs(build *buildapiv1.Build, pushTag string) ([]string, error) {
+	value, _ := buildStrategyEnv(build, builderutil.AdditionalOutputs)
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	repository := ""
+	if named, err := ireference.ParseNormalizedNamed(pushTag); err == nil {
+		repository = ireference.TrimNamed(named).String()
+	}
+	var outputs []string
+	seen := map[string]bool{pushTag: true}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if strings.HasPrefix(entry, ":") {
+			if len(repository) == 0 {
+				return nil, fmt.Errorf("invalid %s entry %q: the build has no output repository", builderutil.AdditionalOutputs, entry)
+			}
+			entry = repository + entry
+		}
+		named, err := ireference.ParseNormalizedNamed(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", builderutil.AdditionalOutputs, entry, err)
+		}
+		if _, ok := named.(ireference.Digested); ok {
+			return nil, fmt.Errorf("invalid %s entry %q: images cannot be pushed by digest", builderutil.AdditionalOutputs, entry)
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		outputs = append(outputs, entry)
+	}
+	return outputs, nil
+}
+
+// pushAdditionalOutputs tags image as each of outputs and pushes it with
+// push, using the push credentials for each output's registry.  The build's
+// status has room for a single digest, that of its primary output, so the
+// digest pushed to each additional output is logged.
+func pushAdditionalOutputs(client DockerClient, image string, outputs []string, push func(string, docker.AuthConfiguration) (string, error)) ([]pushedOutput, error) {
+	var pushed []pushedOutput
+	for _, output := range outputs {
+		if err := tagImage(client, image, output); err != nil {
+			return pushed, err
+		}
+		authConfig, authPresent := dockercfg.NewHelper().GetDockerAuth(output, dockercfg.PushAuthType)
+		log.V(0).Infof("\nPushing image %s ...", output)
+		digest, err := push(output, authConfig)
+		if err != nil {
+			return pushed, reportPushFailure(err, authPresent, authConfig)
+		}
+		if len(digest) > 0 {
+			log.V(0).Infof("Pushed image %s with digest %s", output, digest)
+		}
+		pushed = append(pushed, pushedOutput{Name: output, Digest: digest})
+	}
+	return pushed, nil
+}
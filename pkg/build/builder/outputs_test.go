@@ -0,0 +1,104 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetAdditionalOutputs(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		pushTag   string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			name:    "none",
+			pushTag: "registry.example.com/ns/app:latest",
+		},
+		{
+			name:     "tags and repositories",
+			value:    ":v1.0, quay.io/org/app:latest,,:latest, :v1.0",
+			pushTag:  "registry.example.com/ns/app:latest",
+			expected: []string{"registry.example.com/ns/app:v1.0", "quay.io/org/app:latest"},
+		},
+		{
+			name:     "docker hub",
+			value:    ":abc123",
+			pushTag:  "app",
+			expected: []string{"docker.io/library/app:abc123"},
+		},
+		{
+			name:      "invalid reference",
+			value:     "Not/A/Reference",
+			pushTag:   "registry.example.com/ns/app:latest",
+			expectErr: true,
+		},
+		{
+			name:      "digest",
+			value:     "quay.io/org/app@sha256:0123456789012345678901234567890123456789012345678901234567890123",
+			pushTag:   "registry.example.com/ns/app:latest",
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_ADDITIONAL_OUTPUTS", Value: test.value}},
+		}
+		outputs, err := getAdditionalOutputs(build, test.pushTag)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !reflect.DeepEqual(outputs, test.expected) {
+			t.Errorf("%s: expected outputs %v, got %v", test.name, test.expected, outputs)
+		}
+	}
+}
+
+func TestPushAdditionalOutputs(t *testing.T) {
+	client := NewFakeDockerClient()
+	var pushedNames []string
+	push := func(name string, auth docker.AuthConfiguration) (string, error) {
+		pushedNames = append(pushedNames, name)
+		if name == "quay.io/org/app:broken" {
+			return "", errors.New("denied")
+		}
+		return "sha256:" + name[len(name)-1:], nil
+	}
+
+	pushed, err := pushAdditionalOutputs(client, "registry.example.com/ns/app:latest", []string{"registry.example.com/ns/app:v1", "quay.io/org/app:2"}, push)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []pushedOutput{
+		{Name: "registry.example.com/ns/app:v1", Digest: "sha256:1"},
+		{Name: "quay.io/org/app:2", Digest: "sha256:2"},
+	}
+	if !reflect.DeepEqual(pushed, expected) {
+		t.Errorf("expected %v to be pushed, got %v", expected, pushed)
+	}
+	expectedCalls := []methodCall{
+		{"TagImage", []interface{}{"registry.example.com/ns/app:latest", docker.TagImageOptions{Repo: "registry.example.com/ns/app", Tag: "v1", Force: true}}},
+		{"TagImage", []interface{}{"registry.example.com/ns/app:latest", docker.TagImageOptions{Repo: "quay.io/org/app", Tag: "2", Force: true}}},
+	}
+	if !reflect.DeepEqual(client.callLog, expectedCalls) {
+		t.Errorf("expected calls %v, got %v", expectedCalls, client.callLog)
+	}
+
+	pushedNames = nil
+	pushed, err = pushAdditionalOutputs(client, "registry.example.com/ns/app:latest", []string{"quay.io/org/app:broken", "quay.io/org/app:3"}, push)
+	if err == nil {
+		t.Errorf("expected an error from a failed push")
+	}
+	if len(pushed) != 0 || !reflect.DeepEqual(pushedNames, []string{"quay.io/org/app:broken"}) {
+		t.Errorf("expected the pushes to stop at the failure, got %v after pushing %v", pushed, pushedNames)
+	}
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+)
+
+// getBaseImagePinning returns whether the build strategy's environment
+// requests that the base images of the Dockerfile be pinned to digests.
+func getBaseImagePinning(build *buildapiv1.Build) (bool, error) {
+	value, ok := buildStrategyEnv(build, builderutil.PinBaseImages)
+	if !ok || len(value) == 0 {
+		return false, nil
+	}
+	pin, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %v", builderutil.PinBaseImages, value, err)
+	}
+	return pin, nil
+}
+
+// resolveBaseImageDigests returns the digest references, keyed by name, of
+// the images which the Dockerfile references, as returned by
+// findReferencedImages, using the copies the client has pulled.
+func resolveBaseImageDigests(client DockerClient, imageNames []string) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, name := range baseImageNames(imageNames) {
+		named, err := ireference.ParseNormalizedNamed(name)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing base image name %s: %v", name, err)
+		}
+		if _, ok := named.(ireference.Digested); ok {
+			digests[name] = name
+			continue
+		}
+		image, err := client.InspectImage(name)
+		if err != nil {
+			return nil, fmt.Errorf("error inspecting base image %s: %v", name, err)
+		}
+		for _, repoDigest := range image.RepoDigests {
+			if ref, err := ireference.ParseNormalizedNamed(repoDigest); err == nil && ref.Name() == named.Name() {
+				digests[name] = repoDigest
+				break
+			}
+		}
+		if _, ok := digests[name]; !ok {
+			return nil, fmt.Errorf("no digest of base image %s in %s is known", name, named.Name())
+		}
+		log.V(0).Infof("Resolved base image %s to %s", name, digests[name])
+	}
+	return digests, nil
+}
+
+// pinBaseImages rewrites the Dockerfile at dockerfilePath to use the digest
+// references in place of the names of the base images, in FROM and COPY
+// --from instructions which do not refer to an earlier stage, and labels the
+// image of the stage named by target, or of the last stage, with the digests.
+// The names are matched with the ARGs declared before the first FROM,
+// overridden by buildArgs, expanded, as findReferencedImages returns them.
+func pinBaseImages(dockerfilePath string, digests map[string]string, buildArgs map[string]string, target string) error {
+	in, err := ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		return err
+	}
+	node, err := imagebuilder.ParseDockerfile(bytes.NewBuffer(in))
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	stages, args, err := dockerfileStages(node, buildArgs)
+	if err != nil {
+		return err
+	}
+	for _, stage := range stages {
+		for _, child := range stage.Node.Children {
+			switch {
+			case child.Value == dockercmd.From && child.Next != nil:
+				image := expandImageName(child.Next.Value, args)
+				if _, ok := names[image]; !ok {
+					if digest, ok := digests[image]; ok {
+						child.Next.Value = digest
+					}
+				}
+				names[stage.Name] = image
+			case child.Value == dockercmd.Copy:
+				if ref, ok := nodeHasFromRef(child); ok && len(ref) > 0 {
+					if _, ok := names[ref]; !ok {
+						if digest, ok := digests[expandImageName(ref, args)]; ok {
+							nodeReplaceFromRef(child, digest)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	pinned := make([]string, 0, len(digests))
+	for _, digest := range digests {
+		pinned = append(pinned, digest)
+	}
+	sort.Strings(pinned)
+	stage, err := findTargetStage(node, target)
+	if err != nil {
+		return err
+	}
+	if err := appendLabel(node, stage, []dockerfile.KeyValue{{Key: builderutil.BaseImagesLabel, Value: strings.Join(pinned, ",")}}); err != nil {
+		return err
+	}
+
+	out := dockerfile.Write(node)
+	log.V(4).Infof("Replacing dockerfile\n%s\nwith:\n%s", string(in), string(out))
+	return overwriteFile(dockerfilePath, out)
+}
+
+// pinnedImageNames returns imageNames with the names of the base images
+// replaced by their digest references.
+func pinnedImageNames(imageNames []string, digests map[string]string) []string {
+	pinned := make([]string, 0, len(imageNames))
+	for _, name := range imageNames {
+		if digest, ok := digests[name]; ok {
+			name = digest
+		}
+		pinned = append(pinned, name)
+	}
+	return pinned
+}
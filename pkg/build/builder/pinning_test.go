@@ -0,0 +1,129 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+const (
+	testDigest      = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testOtherDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func TestGetBaseImagePinning(t *testing.T) {
+	for value, expect := range map[string]bool{"": false, "false": false, "true": true} {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_PIN_BASE_IMAGES", Value: value}},
+		}
+		if pin, err := getBaseImagePinning(build); err != nil || pin != expect {
+			t.Errorf("%q: expected %v, got %v, %v", value, expect, pin, err)
+		}
+	}
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_PIN_BASE_IMAGES", Value: "always"}},
+	}
+	if _, err := getBaseImagePinning(build); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
+
+func TestResolveBaseImageDigests(t *testing.T) {
+	client := NewFakeDockerClient()
+	client.inspectImageFunc = func(name string) (*docker.Image, error) {
+		switch name {
+		case "busybox:1.36":
+			return &docker.Image{RepoDigests: []string{
+				"quay.io/mirror/busybox@" + testOtherDigest,
+				"docker.io/library/busybox@" + testDigest,
+			}}, nil
+		case "registry.example.com/unpushed:1":
+			return &docker.Image{}, nil
+		}
+		return nil, docker.ErrNoSuchImage
+	}
+
+	digests, err := resolveBaseImageDigests(client, []string{"busybox:1.36", "scratch", "golang@" + testDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"busybox:1.36":         "docker.io/library/busybox@" + testDigest,
+		"golang@" + testDigest: "golang@" + testDigest,
+	}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Errorf("expected digests %v, got %v", expected, digests)
+	}
+
+	if _, err := resolveBaseImageDigests(client, []string{"registry.example.com/unpushed:1"}); err == nil {
+		t.Errorf("expected an error for an image without a digest")
+	}
+	if _, err := resolveBaseImageDigests(client, []string{"missing:1"}); err == nil {
+		t.Errorf("expected an error for a missing image")
+	}
+}
+
+func TestPinBaseImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pin-base-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfilePath := filepath.Join(dir, "Dockerfile")
+	dockerfile := "FROM golang:1.20 AS builder\nRUN make\nFROM busybox:1.36\nCOPY --from=builder /app /app\nCOPY --from=golang:1.20 /usr/local/go /go\n"
+	if err := ioutil.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	digests := map[string]string{
+		"golang:1.20":  "docker.io/library/golang@" + testDigest,
+		"busybox:1.36": "docker.io/library/busybox@" + testOtherDigest,
+	}
+	if err := pinBaseImages(dockerfilePath, digests, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	images, err := findReferencedImages(dockerfilePath, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"docker.io/library/busybox@" + testOtherDigest, "docker.io/library/golang@" + testDigest}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected images %v, got %v", expected, images)
+	}
+
+	out, err := ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := `LABEL "io.openshift.build.base-images"="` + strings.Join(expected, ",") + `"`
+	if !strings.Contains(string(out), label) {
+		t.Errorf("expected the Dockerfile to contain %s, got:\n%s", label, out)
+	}
+
+	// the names of the digests are those of the images with the ARGs expanded
+	dockerfile = "ARG VERSION=1.20\nFROM golang:${VERSION}\n"
+	if err := ioutil.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinBaseImages(dockerfilePath, map[string]string{"golang:1.21": "docker.io/library/golang@" + testOtherDigest}, map[string]string{"VERSION": "1.21"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if images, err := findReferencedImages(dockerfilePath, nil, ""); err != nil || !reflect.DeepEqual(images, []string{"docker.io/library/golang@" + testOtherDigest}) {
+		t.Errorf("expected the expanded image to be pinned, got %v: %v", images, err)
+	}
+
+	if got := pinnedImageNames([]string{"busybox:1.36", "scratch"}, digests); !reflect.DeepEqual(got, []string{"docker.io/library/busybox@" + testOtherDigest, "scratch"}) {
+		t.Errorf("unexpected pinned image names %v", got)
+	}
+}
@@ -0,0 +1,282 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	idocker "github.com/containers/image/v5/docker"
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	docker "github.com/fsouza/go-dockerclient"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+	s2iutil "github.com/openshift/source-to-image/pkg/util"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+// redactedPlanValue replaces the values of sensitive build args in a plan.
+const redactedPlanValue = "<redacted>"
+
+// buildPlan is what a dry run of a build prints: what it would build from,
+// and where it would push the result.
+type buildPlan struct {
+	Strategy buildapiv1.BuildStrategyType `json:"strategy"`
+	Source   *planSource                  `json:"source,omitempty"`
+	// Dockerfile is the path of the Dockerfile of a Docker strategy build,
+	// once the build's FROM overrides are applied
+	Dockerfile string `json:"dockerfile,omitempty"`
+	Target     string `json:"target,omitempty"`
+	// Scripts is the URL of the S2I scripts of a Source strategy build
+	Scripts string `json:"scripts,omitempty"`
+	// BaseImages are the images the build is based on, with the digests to
+	// which their registries resolve them now
+	BaseImages []planImage `json:"baseImages"`
+	Platforms  []string    `json:"platforms,omitempty"`
+	// ChainedDockerfile is the path of the Dockerfile of the build's
+	// chained step, whose base images are included in BaseImages
+	ChainedDockerfile string         `json:"chainedDockerfile,omitempty"`
+	BuildArgs         []planBuildArg `json:"buildArgs,omitempty"`
+	// Labels are the labels the builder adds to the image
+	Labels map[string]string `json:"labels,omitempty"`
+	// Output is nil if the build does not push its image
+	Output *planOutput `json:"output,omitempty"`
+}
+
+// planSource is the source a build fetched.
+type planSource struct {
+	Type       buildapiv1.BuildSourceType `json:"type"`
+	URI        string                     `json:"uri,omitempty"`
+	Ref        string                     `json:"ref,omitempty"`
+	Commit     string                     `json:"commit,omitempty"`
+	ContextDir string                     `json:"contextDir,omitempty"`
+}
+
+// planImage is an image a build is based on.
+type planImage struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest,omitempty"`
+	// Error says why the digest could not be resolved
+	Error string `json:"error,omitempty"`
+}
+
+// planBuildArg is a build arg of a Docker strategy build.
+type planBuildArg struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// planOutput is where a build pushes its image.
+type planOutput struct {
+	Image string `json:"image"`
+	// AdditionalImages are the AdditionalOutputs the image is also pushed
+	// to
+	AdditionalImages []string `json:"additionalImages,omitempty"`
+	PushSecret       string   `json:"pushSecret,omitempty"`
+}
+
+// getDryRun returns whether the build strategy's environment requests that
+// the build only print its plan.
+func getDryRun(build *buildapiv1.Build) (bool, error) {
+	value, ok := buildStrategyEnv(build, builderutil.DryRun)
+	if !ok || len(value) == 0 {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %v", builderutil.DryRun, value, err)
+	}
+	return dryRun, nil
+}
+
+// newBuildPlan returns the parts of the plan of the build which do not depend
+// on its strategy: its source and its output, if it pushes to pushTag.
+func newBuildPlan(build *buildapiv1.Build, sourceInfo *git.SourceInfo, push bool, pushTag string, outputs []string) *buildPlan {
+	plan := &buildPlan{Strategy: build.Spec.Strategy.Type}
+	source := &planSource{Type: build.Spec.Source.Type, ContextDir: build.Spec.Source.ContextDir}
+	if gitSource := build.Spec.Source.Git; gitSource != nil {
+		source.URI, source.Ref = gitSource.URI, gitSource.Ref
+	}
+	if sourceInfo != nil {
+		source.Commit = sourceInfo.CommitID
+	} else if build.Spec.Revision != nil && build.Spec.Revision.Git != nil {
+		source.Commit = build.Spec.Revision.Git.Commit
+	}
+	if len(source.Type) > 0 || len(source.URI) > 0 {
+		plan.Source = source
+	}
+	if push {
+		plan.Output = &planOutput{Image: pushTag, AdditionalImages: outputs}
+		if build.Spec.Output.PushSecret != nil {
+			plan.Output.PushSecret = build.Spec.Output.PushSecret.Name
+		}
+	}
+	return plan
+}
+
+// dockerBuildPlan returns the plan of a Docker strategy build of the
+// Dockerfile at dockerfilePath, which references imageNames.
+func dockerBuildPlan(ctx context.Context, build *buildapiv1.Build, dockerfilePath string, imageNames, platforms []string, chain *chainedStep, push bool, pushTag string, outputs []string) (*buildPlan, error) {
+	sourceInfo, err := readSourceInfo()
+	if err != nil {
+		return nil, fmt.Errorf("error reading git source info: %v", err)
+	}
+	plan := newBuildPlan(build, sourceInfo, push, pushTag, outputs)
+	plan.Dockerfile = dockerfilePath
+	plan.Target, _ = buildStrategyEnv(build, builderutil.BuildTarget)
+	plan.Platforms = platforms
+	if chain != nil {
+		chainImages, err := findReferencedImages(chain.Dockerfile, strategyBuildArgs(build), "")
+		if err != nil {
+			return nil, err
+		}
+		plan.ChainedDockerfile = chain.Dockerfile
+		imageNames = append(append([]string{}, imageNames...), chainImages...)
+	}
+	plan.BaseImages = planBaseImages(ctx, imageNames)
+	buildArgs, _, err := dockerBuildArgs(build, sourceInfo)
+	if err != nil {
+		return nil, err
+	}
+	plan.BuildArgs = planBuildArgs(buildArgs)
+	labels, err := buildLabels(build, sourceInfo)
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range labels {
+		if plan.Labels == nil {
+			plan.Labels = map[string]string{}
+		}
+		plan.Labels[label.Key] = label.Value
+	}
+	return plan, nil
+}
+
+// s2iBuildPlan returns the plan of a Source strategy build of the source
+// described by sourceInfo with the builder image and the scripts of config,
+// and the runtime image, if any.
+func s2iBuildPlan(ctx context.Context, build *buildapiv1.Build, sourceInfo *git.SourceInfo, config *s2iapi.Config, runtimeImage string, chain *chainedStep, push bool, pushTag string) (*buildPlan, error) {
+	var outputs []string
+	if push {
+		var err error
+		if outputs, err = getAdditionalOutputs(build, pushTag); err != nil {
+			return nil, err
+		}
+	}
+	plan := newBuildPlan(build, sourceInfo, push, pushTag, outputs)
+	plan.Scripts = config.ScriptsURL
+	imageNames := []string{config.BuilderImage}
+	if len(runtimeImage) > 0 {
+		imageNames = append(imageNames, runtimeImage)
+	}
+	if chain != nil {
+		chainImages, err := findReferencedImages(chain.Dockerfile, strategyBuildArgs(build), "")
+		if err != nil {
+			return nil, err
+		}
+		plan.ChainedDockerfile = chain.Dockerfile
+		imageNames = append(imageNames, chainImages...)
+	}
+	plan.BaseImages = planBaseImages(ctx, imageNames)
+	if len(config.Labels) > 0 {
+		plan.Labels = config.Labels
+	}
+	return plan, nil
+}
+
+// planBuildArgs returns buildArgs with the values of those which may hold
+// credentials redacted.
+func planBuildArgs(buildArgs []docker.BuildArg) []planBuildArg {
+	var args []planBuildArg
+	for _, arg := range buildArgs {
+		value := arg.Value
+		switch {
+		case isSensitiveBuildArg(arg.Name):
+			value = redactedPlanValue
+		case strings.Contains(strings.ToUpper(arg.Name), "PROXY"):
+			value, _ = s2iutil.SafeForLoggingURL(value)
+		}
+		args = append(args, planBuildArg{Name: arg.Name, Value: value})
+	}
+	return args
+}
+
+// planBaseImages returns each of the images named by imageNames, as
+// returned by findReferencedImages, once, with the digest its registry
+// resolves it to.  The images are not pulled, and a failure to resolve one is
+// recorded in the plan rather than returned.
+func planBaseImages(ctx context.Context, imageNames []string) []planImage {
+	images := []planImage{}
+	seen := map[string]bool{}
+	for _, name := range baseImageNames(imageNames) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		image := planImage{Name: name}
+		if digest, err := resolveRemoteDigest(ctx, name); err != nil {
+			image.Error = err.Error()
+		} else {
+			image.Digest = digest
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// resolveRemoteDigest returns the digest of the manifest, or manifest list,
+// which the registry of the image name, or one of its mirrors, holds for it.
+func resolveRemoteDigest(ctx context.Context, name string) (string, error) {
+	named, err := ireference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", name, err)
+	}
+	if digested, ok := named.(ireference.Digested); ok {
+		return digested.Digest().String(), nil
+	}
+	named = ireference.TagNameOnly(named)
+	ref, err := idocker.NewReference(named)
+	if err != nil {
+		return "", err
+	}
+	systemContext := registrySystemContext(types.SystemContext{}, named.String())
+	if auth, ok := dockercfg.NewHelper().GetDockerAuth(named.String(), dockercfg.PullAuthType); ok {
+		systemContext.DockerAuthConfig = &types.DockerAuthConfig{Username: auth.Username, Password: auth.Password}
+	}
+	src, err := ref.NewImageSource(ctx, &systemContext)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	manifestBytes, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	digest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// printBuildPlan writes plan to out as indented JSON and records in the
+// build's status that it was a dry run.
+func printBuildPlan(out io.Writer, build *buildapiv1.Build, plan *buildPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.V(0).Infof("\nDry run: the build would run the following plan, but nothing was built or pushed.")
+	if _, err := fmt.Fprintf(out, "%s\n", data); err != nil {
+		return err
+	}
+	build.Status.Message = builderutil.StatusMessageDryRun
+	return nil
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// ulimitTypes are the resource limits which RUN instructions may be given,
+// those which buildah accepts.
+var ulimitTypes = map[string]bool{
+	"core":       true,
+	"cpu":        true,
+	"data":       true,
+	"fsize":      true,
+	"locks":      true,
+	"memlock":    true,
+	"msgqueue":   true,
+	"nice":       true,
+	"nofile":     true,
+	"nproc":      true,
+	"rss":        true,
+	"rtprio":     true,
+	"rttime":     true,
+	"sigpending": true,
+	"stack":      true,
+}
+
+var (
+	// buildUlimits are the type=soft:hard resource limits of RUN
+	// instructions requested by the build, which override the defaults of
+	// daemonlessProcessLimits
+	buildUlimits []string
+	// buildShmSize is the size in bytes of the /dev/shm of RUN instructions
+	// requested by the build, if any
+	buildShmSize string
+)
+
+// ConfigureProcessLimits applies the resource limits and /dev/shm size of
+// RUN instructions and assemble scripts requested by the build strategy's
+// environment, if any.
+func ConfigureProcessLimits(build *buildapiv1.Build) error {
+	value, _ := buildStrategyEnv(build, builderutil.BuildUlimits)
+	ulimits, err := parseUlimits(value)
+	if err != nil {
+		return err
+	}
+	buildUlimits = ulimits
+
+	if value, _ := buildStrategyEnv(build, builderutil.BuildShmSize); len(strings.TrimSpace(value)) > 0 {
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil || quantity.Sign() <= 0 {
+			return fmt.Errorf("invalid %s value %q: must be a positive quantity such as 512Mi", builderutil.BuildShmSize, value)
+		}
+		buildShmSize = strconv.FormatInt(quantity.Value(), 10)
+	}
+	return nil
+}
+
+// parseUlimits parses a comma-separated list of type=soft[:hard] resource
+// limits into the type=soft:hard form which buildah accepts.  A limit
+// without a hard limit has the same hard limit as its soft one.
+func parseUlimits(value string) ([]string, error) {
+	var ulimits []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q: must be of the form type=soft[:hard]", builderutil.BuildUlimits, entry)
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if !ulimitTypes[name] {
+			return nil, fmt.Errorf("invalid %s entry %q: unknown limit type %q", builderutil.BuildUlimits, entry, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid %s entry %q: the %s limit is given more than once", builderutil.BuildUlimits, entry, name)
+		}
+		seen[name] = true
+		limits := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		soft, err := strconv.ParseUint(limits[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: the soft limit must be a non-negative integer", builderutil.BuildUlimits, entry)
+		}
+		hard := soft
+		if len(limits) == 2 {
+			if hard, err = strconv.ParseUint(limits[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: the hard limit must be a non-negative integer", builderutil.BuildUlimits, entry)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid %s entry %q: the soft limit is greater than the hard limit", builderutil.BuildUlimits, entry)
+		}
+		ulimits = append(ulimits, fmt.Sprintf("%s=%d:%d", name, soft, hard))
+	}
+	return ulimits, nil
+}
+
+// mergeUlimits returns the defaults, with those of the types which
+// overrides also sets replaced by the overrides.
+func mergeUlimits(defaults, overrides []string) []string {
+	ulimitType := func(ulimit string) string {
+		return strings.SplitN(ulimit, "=", 2)[0]
+	}
+	overridden := make(map[string]bool)
+	for _, ulimit := range overrides {
+		overridden[ulimitType(ulimit)] = true
+	}
+	var merged []string
+	for _, ulimit := range defaults {
+		if !overridden[ulimitType(ulimit)] {
+			merged = append(merged, ulimit)
+		}
+	}
+	return append(merged, overrides...)
+}
@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestConfigureProcessLimits(t *testing.T) {
+	defer func() { buildUlimits, buildShmSize = nil, "" }()
+	tests := []struct {
+		name    string
+		env     []corev1.EnvVar
+		ulimits []string
+		shmSize string
+		err     bool
+	}{
+		{name: "none"},
+		{
+			name:    "ulimits",
+			env:     []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "nofile=65536:131072, NPROC=4096,"}},
+			ulimits: []string{"nofile=65536:131072", "nproc=4096:4096"},
+		},
+		{
+			name:    "shm size",
+			env:     []corev1.EnvVar{{Name: "BUILD_SHM_SIZE", Value: "2Gi"}},
+			shmSize: "2147483648",
+		},
+		{
+			name: "unknown type",
+			env:  []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "files=1024"}},
+			err:  true,
+		},
+		{
+			name: "repeated type",
+			env:  []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "nofile=1024,nofile=2048"}},
+			err:  true,
+		},
+		{
+			name: "soft above hard",
+			env:  []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "nofile=2048:1024"}},
+			err:  true,
+		},
+		{
+			name: "not a number",
+			env:  []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "nproc=unlimited"}},
+			err:  true,
+		},
+		{
+			name: "no value",
+			env:  []corev1.EnvVar{{Name: "BUILD_ULIMITS", Value: "nproc"}},
+			err:  true,
+		},
+		{
+			name: "bad shm size",
+			env:  []corev1.EnvVar{{Name: "BUILD_SHM_SIZE", Value: "lots"}},
+			err:  true,
+		},
+		{
+			name: "zero shm size",
+			env:  []corev1.EnvVar{{Name: "BUILD_SHM_SIZE", Value: "0"}},
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		buildUlimits, buildShmSize = nil, ""
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{Env: test.env}
+		err := ConfigureProcessLimits(build)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if !reflect.DeepEqual(buildUlimits, test.ulimits) || buildShmSize != test.shmSize {
+			t.Errorf("%s: expected %v and %q, got %v and %q", test.name, test.ulimits, test.shmSize, buildUlimits, buildShmSize)
+		}
+	}
+}
+
+func TestMergeUlimits(t *testing.T) {
+	defaults := []string{"nofile=1048576:1048576", "nproc=1048576:1048576"}
+	if merged := mergeUlimits(defaults, nil); !reflect.DeepEqual(merged, defaults) {
+		t.Errorf("expected the defaults %v, got %v", defaults, merged)
+	}
+	merged := mergeUlimits(defaults, []string{"nproc=4096:4096", "memlock=65536:65536"})
+	expect := []string{"nofile=1048576:1048576", "nproc=4096:4096", "memlock=65536:65536"}
+	if !reflect.DeepEqual(merged, expect) {
+		t.Errorf("expected %v, got %v", expect, merged)
+	}
+}
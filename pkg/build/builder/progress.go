@@ -0,0 +1,254 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containers/image/v5/types"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+)
+
+// progressInterval is how often the progress of image pulls and pushes is
+// reported, unless the build sets BUILD_PROGRESS_INTERVAL.  Zero disables
+// reporting.
+var progressInterval = 30 * time.Second
+
+// progressBuild and progressClient are the build whose status message is
+// updated with the progress of its pulls and pushes, and the client which
+// updates it.
+var (
+	progressBuild  *buildapiv1.Build
+	progressClient buildclientv1.BuildInterface
+)
+
+// ConfigureProgressReporting sets how often the progress of the build's image
+// pulls and pushes is logged, and arranges for it to be shown in the build's
+// status message using client.
+func ConfigureProgressReporting(build *buildapiv1.Build, client buildclientv1.BuildInterface) error {
+	if value, ok := buildStrategyEnv(build, builderutil.ProgressInterval); ok && len(value) > 0 {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid %s value %q: must be a non-negative duration", builderutil.ProgressInterval, value)
+		}
+		progressInterval = interval
+	}
+	progressBuild, progressClient = build, client
+	return nil
+}
+
+// transferProgress tracks an image pull or push, and reports how far it has
+// got every progressInterval until it is finished.
+type transferProgress struct {
+	action string
+	image  string
+	// layers and size are the number of layers of the image and their total
+	// size, or zero if they are not known
+	layers int
+	size   int64
+	start  time.Time
+
+	lock          sync.Mutex
+	layersStarted int
+	layersDone    int
+	bytes         int64
+	// countsBytes is set once the bytes transferred are being counted
+	countsBytes bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startTransferProgress starts reporting the progress of action, "Pulling"
+// or "Pushing", on image, which has the given number of layers of the given
+// total size, if known.
+func startTransferProgress(action, image string, layers int, size int64) *transferProgress {
+	p := &transferProgress{
+		action: action,
+		image:  image,
+		layers: layers,
+		size:   size,
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if progressInterval <= 0 {
+		close(p.done)
+		return p
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				message := p.String()
+				log.V(0).Infof("%s", message)
+				updateBuildProgress(message)
+			}
+		}
+	}()
+	return p
+}
+
+// finish stops reporting progress.
+func (p *transferProgress) finish() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
+
+func (p *transferProgress) layerStarted() {
+	recordProgress()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.layersStarted++
+}
+
+func (p *transferProgress) layerDone() {
+	recordProgress()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.layersDone++
+}
+
+func (p *transferProgress) addBytes(n int64) {
+	if n > 0 {
+		recordProgress()
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.countsBytes = true
+	p.bytes += n
+}
+
+// String describes how far the transfer has got.
+func (p *transferProgress) String() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	of := func(n int64, total int64) string {
+		if total > 0 {
+			return fmt.Sprintf("%d of %d", n, total)
+		}
+		return fmt.Sprintf("%d", n)
+	}
+	var details []string
+	if p.countsBytes {
+		details = append(details, fmt.Sprintf("%s layers done", of(int64(p.layersDone), int64(p.layers))))
+		details = append(details, fmt.Sprintf("%s bytes transferred", of(p.bytes, p.size)))
+	} else {
+		details = append(details, fmt.Sprintf("%s layers started", of(int64(p.layersStarted), int64(p.layers))))
+		if p.size > 0 {
+			details = append(details, fmt.Sprintf("%d bytes in total", p.size))
+		}
+	}
+	return fmt.Sprintf("%s image %s: %s after %s", p.action, p.image, strings.Join(details, ", "), time.Since(p.start).Round(time.Second))
+}
+
+// updateBuildProgress sets the status message of the build to message, so
+// that it shows whether a long pull or push is moving.  The message is
+// replaced when the build's status is next updated.
+func updateBuildProgress(message string) {
+	if progressBuild == nil || progressClient == nil {
+		return
+	}
+	latest, err := progressClient.Get(progressBuild.Name, metav1.GetOptions{})
+	if err != nil {
+		log.V(4).Infof("Unable to get build %s to report progress: %v", progressBuild.Name, err)
+		return
+	}
+	latest.Status.Message = message
+	if _, err := progressClient.UpdateDetails(latest.Name, latest); err != nil {
+		log.V(4).Infof("Unable to report progress in build %s: %v", progressBuild.Name, err)
+	}
+}
+
+// progressReportWriter passes the report of an image copy on to w, and
+// records in p the layers it says are being copied.  Without a terminal, the
+// report is the only sign of progress the copy gives.
+type progressReportWriter struct {
+	w       io.Writer
+	p       *transferProgress
+	partial []byte
+}
+
+func (r *progressReportWriter) Write(b []byte) (int, error) {
+	r.partial = append(r.partial, b...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if bytes.HasPrefix(r.partial[:i], []byte("Copying blob ")) {
+			r.p.layerStarted()
+		}
+		r.partial = r.partial[i+1:]
+	}
+	return r.w.Write(b)
+}
+
+// progressReference is an image reference whose destinations record the
+// layers and bytes pushed to them in p.
+type progressReference struct {
+	types.ImageReference
+	p *transferProgress
+}
+
+func (r *progressReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := r.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	r.p.addBytes(0)
+	return &progressDestination{ImageDestination: dest, p: r.p}, nil
+}
+
+// progressDestination is an image destination which records the layers and
+// bytes pushed to it in p.
+type progressDestination struct {
+	types.ImageDestination
+	p *transferProgress
+}
+
+func (d *progressDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	info, err := d.ImageDestination.PutBlob(ctx, &progressReader{r: stream, p: d.p}, inputInfo, cache, isConfig)
+	if err == nil && !isConfig {
+		d.p.layerDone()
+	}
+	return info, err
+}
+
+func (d *progressDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	reused, blob, err := d.ImageDestination.TryReusingBlob(ctx, info, cache, canSubstitute)
+	if err == nil && reused {
+		d.p.layerDone()
+	}
+	return reused, blob, err
+}
+
+// progressReader counts the bytes read through it in p.
+type progressReader struct {
+	r io.Reader
+	p *transferProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.addBytes(int64(n))
+	return n, err
+}
@@ -0,0 +1,140 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containers/image/v5/types"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
+)
+
+func TestConfigureProgressReporting(t *testing.T) {
+	defer func(interval time.Duration) {
+		progressInterval, progressBuild, progressClient = interval, nil, nil
+	}(progressInterval)
+
+	tests := []struct {
+		value     string
+		expected  time.Duration
+		expectErr bool
+	}{
+		{value: "", expected: 30 * time.Second},
+		{value: "5s", expected: 5 * time.Second},
+		{value: "0", expected: 0},
+		{value: "-1s", expectErr: true},
+		{value: "often", expectErr: true},
+	}
+	for _, test := range tests {
+		progressInterval = 30 * time.Second
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_PROGRESS_INTERVAL", Value: test.value}},
+		}
+		err := ConfigureProgressReporting(build, nil)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if err == nil && progressInterval != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, progressInterval)
+		}
+	}
+}
+
+func TestTransferProgressString(t *testing.T) {
+	p := &transferProgress{action: "Pulling", image: "quay.io/app:latest", layers: 3, size: 300, start: time.Now()}
+	p.layerStarted()
+	if s := p.String(); !strings.HasPrefix(s, "Pulling image quay.io/app:latest: 1 of 3 layers started, 300 bytes in total after ") {
+		t.Errorf("unexpected progress %q", s)
+	}
+	p.action = "Pushing"
+	p.addBytes(100)
+	p.layerDone()
+	if s := p.String(); !strings.HasPrefix(s, "Pushing image quay.io/app:latest: 1 of 3 layers done, 100 of 300 bytes transferred after ") {
+		t.Errorf("unexpected progress %q", s)
+	}
+}
+
+func TestProgressReportWriter(t *testing.T) {
+	p := &transferProgress{}
+	out := &bytes.Buffer{}
+	w := &progressReportWriter{w: out, p: p}
+	for _, s := range []string{"Getting image source signatures\nCopying blob sha256:a", "bc\nCopying blob sha256:def\n", "Copying config sha256:123\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if p.layersStarted != 2 {
+		t.Errorf("expected 2 layers started, got %d", p.layersStarted)
+	}
+	if !strings.Contains(out.String(), "Copying blob sha256:abc\n") {
+		t.Errorf("expected the report to be passed on, got %q", out.String())
+	}
+}
+
+// reusingDestination is an image destination which already holds the blob
+// with the digest reuse.
+type reusingDestination struct {
+	types.ImageDestination
+	reuse string
+}
+
+func (d *reusingDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	_, err := ioutil.ReadAll(stream)
+	return inputInfo, err
+}
+
+func (d *reusingDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	return string(info.Digest) == d.reuse, info, nil
+}
+
+func TestProgressDestination(t *testing.T) {
+	p := &transferProgress{}
+	dest := &progressDestination{ImageDestination: &reusingDestination{reuse: "sha256:abc"}, p: p}
+	ctx := context.Background()
+	if _, err := dest.PutBlob(ctx, strings.NewReader("layer"), types.BlobInfo{}, nil, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := dest.PutBlob(ctx, strings.NewReader("{}"), types.BlobInfo{}, nil, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dest.TryReusingBlob(ctx, types.BlobInfo{Digest: "sha256:abc"}, nil, false)
+	dest.TryReusingBlob(ctx, types.BlobInfo{Digest: "sha256:def"}, nil, false)
+	if p.layersDone != 2 || p.bytes != 7 {
+		t.Errorf("expected 2 layers and 7 bytes, got %d layers and %d bytes", p.layersDone, p.bytes)
+	}
+}
+
+func TestTransferProgressReportsToBuild(t *testing.T) {
+	defer func(interval time.Duration) {
+		progressInterval, progressBuild, progressClient = interval, nil, nil
+	}(progressInterval)
+
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1"}}
+	client := buildfake.NewSimpleClientset(build).BuildV1().Builds("ns")
+	progressInterval, progressBuild, progressClient = 10*time.Millisecond, build, client
+
+	p := startTransferProgress("Pushing", "quay.io/app:latest", 1, 10)
+	p.addBytes(5)
+	time.Sleep(50 * time.Millisecond)
+	p.finish()
+	p.finish()
+
+	latest, err := client.Get("app-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(latest.Status.Message, "Pushing image quay.io/app:latest: 0 of 1 layers done, 5 of 10 bytes transferred") {
+		t.Errorf("expected the progress in the build's status message, got %q", latest.Status.Message)
+	}
+}
@@ -0,0 +1,181 @@
+package builder
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/attestation"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/version"
+)
+
+const (
+	// provenanceArtifactKind is the suffix of the tag of attestation
+	// artifacts.
+	provenanceArtifactKind = "att"
+
+	// defaultIdentityTokenPath is where the identity token used for keyless
+	// signing is read from, unless ProvenanceIdentityToken is set.
+	defaultIdentityTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// builderID identifies this builder in provenance.
+	builderID = "https://github.com/openshift/builder"
+)
+
+// getProvenanceSigner returns the Signer for the provenance requested for
+// the build, or nil if none was requested.  A signing key takes precedence
+// over keyless signing.
+func getProvenanceSigner(build *buildapiv1.Build) (attestation.Signer, error) {
+	value, ok := buildStrategyEnv(build, builderutil.Provenance)
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.Provenance, value, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if keyPath, _ := buildStrategyEnv(build, builderutil.ProvenanceSigningKey); len(keyPath) > 0 {
+		return attestation.LoadSigner(keyPath)
+	}
+	fulcioURL, _ := buildStrategyEnv(build, builderutil.ProvenanceFulcioURL)
+	if len(fulcioURL) == 0 {
+		return nil, fmt.Errorf("%s requires either %s or %s to be set", builderutil.Provenance, builderutil.ProvenanceSigningKey, builderutil.ProvenanceFulcioURL)
+	}
+	tokenPath, _ := buildStrategyEnv(build, builderutil.ProvenanceIdentityToken)
+	if len(tokenPath) == 0 {
+		tokenPath = defaultIdentityTokenPath
+	}
+	token, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the identity token for keyless signing: %v", err)
+	}
+	log.V(0).Infof("\nRequesting a signing certificate from %s ...", fulcioURL)
+	return attestation.NewKeylessSigner(fulcioURL, string(token))
+}
+
+// provenanceBuild describes the build, which pushed the image with digest
+// to pushTag starting from baseImages, for its provenance statement.
+func provenanceBuild(build *buildapiv1.Build, pushTag, digest string, baseImages []string) attestation.Build {
+	image := pushTag
+	if named, err := ireference.ParseNormalizedNamed(pushTag); err == nil {
+		image = ireference.TrimNamed(named).String()
+	}
+	id := builderID
+	if v := version.Get().GitVersion; len(v) > 0 {
+		id += "@" + v
+	}
+	finished := time.Now().UTC()
+	provenance := attestation.Build{
+		Image:        image,
+		Digest:       digest,
+		BuilderID:    id,
+		InvocationID: string(build.UID),
+		BaseImages:   baseImages,
+		Parameters:   map[string]string{"strategy": string(build.Spec.Strategy.Type)},
+		FinishedOn:   &finished,
+	}
+	if build.Status.StartTimestamp != nil {
+		started := build.Status.StartTimestamp.UTC()
+		provenance.StartedOn = &started
+	}
+	if git := build.Spec.Source.Git; git != nil {
+		provenance.SourceURI = git.URI
+		if len(git.Ref) > 0 {
+			provenance.Parameters["ref"] = git.Ref
+		}
+		if sourceInfo, err := readSourceInfo(); err == nil && sourceInfo != nil && len(sourceInfo.CommitID) > 0 {
+			provenance.SourceCommit = sourceInfo.CommitID
+		} else if build.Spec.Revision != nil && build.Spec.Revision.Git != nil {
+			provenance.SourceCommit = build.Spec.Revision.Git.Commit
+		}
+	}
+	if len(build.Spec.Source.ContextDir) > 0 {
+		provenance.Parameters["contextDir"] = build.Spec.Source.ContextDir
+	}
+	if strategy := build.Spec.Strategy.DockerStrategy; strategy != nil {
+		provenance.EntryPoint, _ = filepath.Rel(InputContentPath, getDockerfilePath(InputContentPath, build))
+		for _, arg := range strategy.BuildArgs {
+			provenance.Parameters["buildArg:"+arg.Name] = arg.Value
+		}
+		if target, _ := buildStrategyEnv(build, builderutil.BuildTarget); len(target) > 0 {
+			provenance.Parameters["target"] = target
+		}
+		if platforms, _ := buildStrategyEnv(build, builderutil.BuildPlatforms); len(platforms) > 0 {
+			provenance.Parameters["platforms"] = platforms
+		}
+		provenance.Parameters["noCache"] = strconv.FormatBool(strategy.NoCache)
+	}
+	if strategy := build.Spec.Strategy.SourceStrategy; strategy != nil {
+		provenance.Parameters["incremental"] = strconv.FormatBool(strategy.Incremental != nil && *strategy.Incremental)
+	}
+	return provenance
+}
+
+// attachImageProvenance pushes the provenance of the image which was pushed
+// to pushTag with the given digest, signed by signer, to the repository of
+// pushTag.
+func attachImageProvenance(client DockerClient, signer attestation.Signer, build *buildapiv1.Build, pushTag, digest string, baseImages []string, auth docker.AuthConfiguration) error {
+	pusher, ok := client.(artifactPusher)
+	if !ok {
+		return fmt.Errorf("pushing provenance is not supported by this build client")
+	}
+	envelope, err := attestation.Sign(attestation.NewProvenance(provenanceBuild(build, pushTag, digest, baseImages)), signer)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	artifact := Artifact{
+		MediaType: attestation.EnvelopeMediaType,
+		Content:   content,
+		Annotations: map[string]string{
+			"predicateType": attestation.ProvenancePredicateType,
+		},
+	}
+	// Keyless signatures carry the certificate binding their key to an
+	// identity, split into the leaf and its chain as cosign does.
+	if block, chain := pem.Decode(signer.Certificates()); block != nil {
+		artifact.Annotations["dev.sigstore.cosign/certificate"] = string(pem.EncodeToMemory(block))
+		if len(chain) > 0 {
+			artifact.Annotations["dev.sigstore.cosign/chain"] = string(chain)
+		}
+	}
+	tag := artifactTag(digest, provenanceArtifactKind)
+	log.V(0).Infof("\nPushing provenance for %s ...", digest)
+	var provenanceDigest string
+	err = retryImageAction("Push", func() (pushErr error) {
+		provenanceDigest, pushErr = pusher.PushArtifact(pushTag, digest, tag, artifact, auth)
+		return pushErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push the provenance: %v", err)
+	}
+	log.V(0).Infof("Pushed provenance %s as %s", provenanceDigest, tag)
+	return nil
+}
+
+// baseImageNames returns the images referenced by a Dockerfile, as returned
+// by findReferencedImages, without "scratch".
+func baseImageNames(imageNames []string) []string {
+	names := []string{}
+	for _, name := range imageNames {
+		if name != "scratch" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
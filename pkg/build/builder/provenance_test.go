@@ -0,0 +1,168 @@
+package builder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/attestation"
+)
+
+// fakeArtifactClient is a DockerClient which records the artifacts pushed
+// with it.
+type fakeArtifactClient struct {
+	*FakeDocker
+	name, subjectDigest, tag string
+	artifact                 Artifact
+}
+
+func (c *fakeArtifactClient) PushArtifact(name, subjectDigest, tag string, artifact Artifact, auth docker.AuthConfiguration) (string, error) {
+	c.name, c.subjectDigest, c.tag, c.artifact = name, subjectDigest, tag, artifact
+	return "sha256:artifact", nil
+}
+
+func TestGetProvenanceSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyPath := filepath.Join(dir, "cosign.key")
+	writeTestFile(t, keyPath, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+
+	tests := []struct {
+		name         string
+		env          []corev1.EnvVar
+		expectSigner bool
+		expectErr    bool
+	}{
+		{
+			name: "not requested",
+		},
+		{
+			name: "disabled",
+			env:  []corev1.EnvVar{{Name: "BUILD_PROVENANCE", Value: "false"}},
+		},
+		{
+			name:      "invalid",
+			env:       []corev1.EnvVar{{Name: "BUILD_PROVENANCE", Value: "yes please"}},
+			expectErr: true,
+		},
+		{
+			name:      "no key or service",
+			env:       []corev1.EnvVar{{Name: "BUILD_PROVENANCE", Value: "true"}},
+			expectErr: true,
+		},
+		{
+			name: "key",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_PROVENANCE", Value: "true"},
+				{Name: "BUILD_PROVENANCE_SIGNING_KEY", Value: keyPath},
+			},
+			expectSigner: true,
+		},
+		{
+			name: "missing identity token",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_PROVENANCE", Value: "true"},
+				{Name: "BUILD_PROVENANCE_FULCIO_URL", Value: "https://fulcio.example.com"},
+				{Name: "BUILD_PROVENANCE_IDENTITY_TOKEN", Value: filepath.Join(dir, "missing")},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		signer, err := getProvenanceSigner(build)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.expectSigner != (signer != nil) {
+			t.Errorf("%s: unexpected signer %v", test.name, signer)
+		}
+	}
+}
+
+func TestAttachImageProvenance(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	signer, err := attestation.NewSigner(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", UID: "uid"}}
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "https://github.com/openshift/ruby-hello-world", Ref: "master"}
+	build.Spec.Revision = &buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{Commit: "0123456789"}}
+	build.Spec.Strategy.Type = buildapiv1.DockerBuildStrategyType
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		DockerfilePath: "Containerfile",
+		BuildArgs:      []corev1.EnvVar{{Name: "VERSION", Value: "1.0"}},
+	}
+	client := &fakeArtifactClient{FakeDocker: NewFakeDockerClient()}
+
+	err = attachImageProvenance(client, signer, build, "registry.example.com/ns/app:latest", "sha256:abc", baseImageNames([]string{"centos@sha256:def", "scratch"}), docker.AuthConfiguration{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.name != "registry.example.com/ns/app:latest" || client.subjectDigest != "sha256:abc" || client.tag != "sha256-abc.att" {
+		t.Errorf("unexpected push of %s for %s as %s", client.name, client.subjectDigest, client.tag)
+	}
+	if client.artifact.MediaType != attestation.EnvelopeMediaType || client.artifact.Annotations["predicateType"] != attestation.ProvenancePredicateType {
+		t.Errorf("unexpected artifact %#v", client.artifact)
+	}
+
+	envelope := attestation.Envelope{}
+	if err := json.Unmarshal(client.artifact.Content, &envelope); err != nil {
+		t.Fatalf("the envelope is not valid JSON: %v", err)
+	}
+	statement := struct {
+		Subject   []attestation.Subject `json:"subject"`
+		Predicate struct {
+			Invocation attestation.Invocation `json:"invocation"`
+			Materials  []attestation.Material `json:"materials"`
+		} `json:"predicate"`
+	}{}
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		t.Fatalf("the statement is not valid JSON: %v", err)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != "registry.example.com/ns/app" || statement.Subject[0].Digest["sha256"] != "abc" {
+		t.Errorf("unexpected subject %v", statement.Subject)
+	}
+	invocation := statement.Predicate.Invocation
+	if invocation.ConfigSource.EntryPoint != "Containerfile" || invocation.ConfigSource.Digest["sha1"] != "0123456789" {
+		t.Errorf("unexpected config source %v", invocation.ConfigSource)
+	}
+	if invocation.Parameters["buildArg:VERSION"] != "1.0" || invocation.Parameters["ref"] != "master" || invocation.Parameters["strategy"] != "Docker" {
+		t.Errorf("unexpected parameters %v", invocation.Parameters)
+	}
+	if len(statement.Predicate.Materials) != 2 || statement.Predicate.Materials[1].URI != "pkg:docker/centos" {
+		t.Errorf("unexpected materials %v", statement.Predicate.Materials)
+	}
+
+	if err := attachImageProvenance(NewFakeDockerClient(), signer, build, "registry.example.com/ns/app:latest", "sha256:abc", nil, docker.AuthConfiguration{}); err == nil {
+		t.Errorf("expected an error from a client which cannot push artifacts")
+	}
+}
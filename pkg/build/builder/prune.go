@@ -0,0 +1,184 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/containers/storage"
+	"github.com/pkg/errors"
+)
+
+// PruneOptions are the policies by which PruneStorage removes what earlier
+// builds left in their storage.
+type PruneOptions struct {
+	// MaxAge, if greater than zero, is how old containers, and images, may
+	// be before they are removed.  When it is zero, every container and
+	// every untagged image is removed, so the storage must not be in use by
+	// a build.
+	MaxAge time.Duration
+	// MaxSize, if greater than zero, is the number of bytes which the images
+	// left after MaxAge is applied may use in total.  The untagged images,
+	// and then the oldest, are removed until they fit.
+	MaxSize int64
+	// DryRun logs what would be removed without removing it.
+	DryRun bool
+}
+
+// prunePlan is what PruneStorage removes from the storage.
+type prunePlan struct {
+	// containers are the containers to remove, with their layers
+	containers []storage.Container
+	// images are the images to remove, in the order to remove them
+	images []storage.Image
+	// unmount are the layers to unmount which are left in place
+	unmount []string
+	// reclaimed is roughly the number of bytes removing the images frees
+	reclaimed int64
+}
+
+// PruneStorage removes the containers, images and mounts which builds using
+// store left behind, such as those of builds which crashed, according to
+// opts, so that storage kept on a volume between builds does not grow
+// without bound.  Containers are only ever left behind by builds, so all of
+// those older than opts.MaxAge are removed.  Images are the base images kept
+// for later builds, so tagged images are only removed once they are older
+// than opts.MaxAge, or to bring the images within opts.MaxSize.  Images in
+// read-only additional image stores, and images of the containers which are
+// kept, are never removed.
+func PruneStorage(store storage.Store, opts PruneOptions) error {
+	containers, err := store.Containers()
+	if err != nil {
+		return fmt.Errorf("unable to list containers: %v", err)
+	}
+	images, err := store.Images()
+	if err != nil {
+		return fmt.Errorf("unable to list images: %v", err)
+	}
+	layers, err := store.Layers()
+	if err != nil {
+		return fmt.Errorf("unable to list layers: %v", err)
+	}
+	plan := planPrune(containers, images, layers, store.ImageSize, time.Now(), opts)
+
+	action := "Removing"
+	if opts.DryRun {
+		action = "Would remove"
+	}
+	for _, container := range plan.containers {
+		log.V(0).Infof("%s container %s, created %s", action, pruneName(container.ID, container.Names), container.Created.Format(time.RFC3339))
+		if opts.DryRun {
+			continue
+		}
+		if err := store.DeleteContainer(container.ID); err != nil {
+			return fmt.Errorf("unable to remove container %s: %v", container.ID, err)
+		}
+	}
+	for _, image := range plan.images {
+		log.V(0).Infof("%s image %s, created %s", action, pruneName(image.ID, image.Names), image.Created.Format(time.RFC3339))
+		if opts.DryRun {
+			continue
+		}
+		if _, err := store.DeleteImage(image.ID, true); err != nil {
+			return fmt.Errorf("unable to remove image %s: %v", image.ID, err)
+		}
+	}
+	for _, layer := range plan.unmount {
+		log.V(0).Infof("%s the mount of layer %s", action, layer)
+		if opts.DryRun {
+			continue
+		}
+		// the layer may have gone with an image which was removed
+		if _, err := store.Unmount(layer, true); err != nil && errors.Cause(err) != storage.ErrLayerUnknown {
+			return fmt.Errorf("unable to unmount layer %s: %v", layer, err)
+		}
+	}
+	verb := "Pruned"
+	if opts.DryRun {
+		verb = "Would prune"
+	}
+	log.V(0).Infof("%s %d containers, %d images and %d mounts, reclaiming about %s", verb, len(plan.containers), len(plan.images), len(plan.unmount), formatDiskSpace(uint64(plan.reclaimed)))
+	return nil
+}
+
+// planPrune decides which of containers and images to remove, and which of
+// the mounted layers to unmount, as of now.  imageSize returns the size of
+// an image.
+func planPrune(containers []storage.Container, images []storage.Image, layers []storage.Layer, imageSize func(id string) (int64, error), now time.Time, opts PruneOptions) prunePlan {
+	plan := prunePlan{}
+	expired := func(created time.Time) bool {
+		return opts.MaxAge <= 0 || now.Sub(created) > opts.MaxAge
+	}
+
+	inUse := map[string]bool{}
+	keptLayers, removedLayers := map[string]bool{}, map[string]bool{}
+	for _, container := range containers {
+		if expired(container.Created) {
+			plan.containers = append(plan.containers, container)
+			removedLayers[container.LayerID] = true
+			continue
+		}
+		inUse[container.ImageID] = true
+		keptLayers[container.LayerID] = true
+	}
+
+	type candidate struct {
+		image storage.Image
+		size  int64
+	}
+	var kept []candidate
+	var total int64
+	for _, image := range images {
+		if image.ReadOnly || inUse[image.ID] {
+			continue
+		}
+		size, err := imageSize(image.ID)
+		if err != nil {
+			log.V(4).Infof("Unable to measure the size of image %s: %v", image.ID, err)
+		}
+		if expired(image.Created) && (len(image.Names) == 0 || opts.MaxAge > 0) {
+			plan.images = append(plan.images, image)
+			plan.reclaimed += size
+			continue
+		}
+		kept = append(kept, candidate{image: image, size: size})
+		total += size
+	}
+	if opts.MaxSize > 0 && total > opts.MaxSize {
+		sort.SliceStable(kept, func(i, j int) bool {
+			if untaggedI, untaggedJ := len(kept[i].image.Names) == 0, len(kept[j].image.Names) == 0; untaggedI != untaggedJ {
+				return untaggedI
+			}
+			return kept[i].image.Created.Before(kept[j].image.Created)
+		})
+		for _, c := range kept {
+			if total <= opts.MaxSize {
+				break
+			}
+			plan.images = append(plan.images, c.image)
+			plan.reclaimed += c.size
+			total -= c.size
+		}
+	}
+
+	// layers which are removed are unmounted as they are, and a build
+	// which is running may have others mounted, so mounts are only
+	// released when no build can be using the storage
+	if opts.MaxAge <= 0 {
+		for _, layer := range layers {
+			if layer.MountCount > 0 && !keptLayers[layer.ID] && !removedLayers[layer.ID] {
+				plan.unmount = append(plan.unmount, layer.ID)
+			}
+		}
+	}
+	return plan
+}
+
+// pruneName names a container or image by its first name, if it has one,
+// and its ID.
+func pruneName(id string, names []string) string {
+	if len(names) == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s (%s)", names[0], id)
+}
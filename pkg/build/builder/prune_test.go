@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containers/storage"
+)
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Now()
+	ago := func(hours int) time.Time {
+		return now.Add(-time.Duration(hours) * time.Hour)
+	}
+	containers := []storage.Container{
+		{ID: "crashed", ImageID: "base-old", LayerID: "crashed-layer", Created: ago(48)},
+		{ID: "running", ImageID: "base-new", LayerID: "running-layer", Created: ago(0)},
+	}
+	images := []storage.Image{
+		{ID: "base-old", Names: []string{"registry.example.com/base:1"}, Created: ago(100)},
+		{ID: "base-new", Names: []string{"registry.example.com/base:2"}, Created: ago(100)},
+		{ID: "builder", Names: []string{"registry.example.com/builder:latest"}, Created: ago(10)},
+		{ID: "runtime", Names: []string{"registry.example.com/runtime:latest"}, Created: ago(20)},
+		{ID: "intermediate", Created: ago(1)},
+		{ID: "shared", Names: []string{"registry.example.com/shared:latest"}, Created: ago(200), ReadOnly: true},
+	}
+	layers := []storage.Layer{
+		{ID: "crashed-layer", MountCount: 1},
+		{ID: "running-layer", MountCount: 1},
+		{ID: "extracted", MountCount: 2},
+		{ID: "unmounted"},
+	}
+	imageSize := func(id string) (int64, error) {
+		return 10, nil
+	}
+	ids := func(plan prunePlan) ([]string, []string) {
+		var containerIDs, imageIDs []string
+		for _, container := range plan.containers {
+			containerIDs = append(containerIDs, container.ID)
+		}
+		for _, image := range plan.images {
+			imageIDs = append(imageIDs, image.ID)
+		}
+		return containerIDs, imageIDs
+	}
+
+	tests := []struct {
+		name               string
+		opts               PruneOptions
+		expectContainers   []string
+		expectImages       []string
+		expectUnmount      []string
+		expectReclaimBytes int64
+	}{
+		{
+			name:               "everything unused",
+			expectContainers:   []string{"crashed", "running"},
+			expectImages:       []string{"intermediate"},
+			expectUnmount:      []string{"extracted"},
+			expectReclaimBytes: 10,
+		},
+		{
+			name:               "by age",
+			opts:               PruneOptions{MaxAge: 24 * time.Hour},
+			expectContainers:   []string{"crashed"},
+			expectImages:       []string{"base-old"},
+			expectReclaimBytes: 10,
+		},
+		{
+			name:               "by age and size",
+			opts:               PruneOptions{MaxAge: 24 * time.Hour, MaxSize: 15},
+			expectContainers:   []string{"crashed"},
+			expectImages:       []string{"base-old", "intermediate", "runtime"},
+			expectReclaimBytes: 30,
+		},
+	}
+	for _, test := range tests {
+		plan := planPrune(containers, images, layers, imageSize, now, test.opts)
+		containerIDs, imageIDs := ids(plan)
+		if !reflect.DeepEqual(containerIDs, test.expectContainers) {
+			t.Errorf("%s: expected to remove containers %v, got %v", test.name, test.expectContainers, containerIDs)
+		}
+		if !reflect.DeepEqual(imageIDs, test.expectImages) {
+			t.Errorf("%s: expected to remove images %v, got %v", test.name, test.expectImages, imageIDs)
+		}
+		if !reflect.DeepEqual(plan.unmount, test.expectUnmount) {
+			t.Errorf("%s: expected to unmount %v, got %v", test.name, test.expectUnmount, plan.unmount)
+		}
+		if plan.reclaimed != test.expectReclaimBytes {
+			t.Errorf("%s: expected to reclaim %d bytes, got %d", test.name, test.expectReclaimBytes, plan.reclaimed)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// pullPlatform is the os/arch[/variant] platform, if any, whose images are
+// pulled from manifest lists in place of those for the node's platform.
+var pullPlatform string
+
+// ConfigurePullPlatform sets the platform whose images the build pulls from
+// manifest lists, if the build strategy's environment requests one, so that
+// a build can be based on, or extract content from, the images of another
+// platform than the node's, such as linux/arm/v7 for an emulated build.
+func ConfigurePullPlatform(build *buildapiv1.Build) error {
+	pullPlatform = ""
+	value, _ := buildStrategyEnv(build, builderutil.PullPlatform)
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil
+	}
+	if _, _, _, err := parsePlatform(value); err != nil {
+		return fmt.Errorf("invalid %s value %q: %v", builderutil.PullPlatform, value, err)
+	}
+	if platforms, _ := getBuildPlatforms(build); len(platforms) > 0 {
+		return fmt.Errorf("%s cannot be combined with %s, which pulls the images of each platform built", builderutil.PullPlatform, builderutil.BuildPlatforms)
+	}
+	log.V(0).Infof("Pulling the images of manifest lists for platform %s", value)
+	pullPlatform = value
+	return nil
+}
+
+// platformImageName returns the digest reference of the image for platform
+// among those of the manifest list imageName refers to, which sc is used to
+// read.  Images which are not manifest lists are returned as they are, since
+// there is nothing to choose between.
+func platformImageName(sc *types.SystemContext, imageName, platform string) (string, error) {
+	named, err := ireference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", imageName, err)
+	}
+	ref, err := alltransports.ParseImageName("docker://" + imageName)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image name %s: %v", "docker://"+imageName, err)
+	}
+	ctx := context.TODO()
+	src, err := ref.NewImageSource(ctx, sc)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	blob, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	if !manifest.MIMETypeIsMultiImage(manifest.NormalizedMIMEType(mimeType)) {
+		return imageName, nil
+	}
+	instance, err := choosePlatformInstance(blob, mimeType, platform)
+	if err != nil {
+		return "", err
+	}
+	return named.Name() + "@" + instance, nil
+}
+
+// choosePlatformInstance returns the digest of the image for platform, in
+// os/arch[/variant] form, listed by the manifest list blob of type mimeType.
+// When platform names no variant, the first image for its os and
+// architecture is chosen.
+func choosePlatformInstance(blob []byte, mimeType, platform string) (string, error) {
+	platformOS, arch, variant, err := parsePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+	var index *manifest.OCI1Index
+	if manifest.NormalizedMIMEType(mimeType) == manifest.DockerV2ListMediaType {
+		list, err := manifest.Schema2ListFromManifest(blob)
+		if err != nil {
+			return "", err
+		}
+		if index, err = list.ToOCI1Index(); err != nil {
+			return "", err
+		}
+	} else if index, err = manifest.OCI1IndexFromManifest(blob); err != nil {
+		return "", err
+	}
+	var available []string
+	for _, instance := range index.Manifests {
+		if instance.Platform == nil {
+			continue
+		}
+		p := instance.Platform
+		if p.OS == platformOS && p.Architecture == arch && (len(variant) == 0 || p.Variant == variant) {
+			return instance.Digest.String(), nil
+		}
+		available = append(available, strings.TrimSuffix(p.OS+"/"+p.Architecture+"/"+p.Variant, "/"))
+	}
+	if len(available) == 0 {
+		return "", fmt.Errorf("no image for platform %s was found, the manifest list names no platforms", platform)
+	}
+	return "", fmt.Errorf("no image for platform %s was found, only for %s", platform, strings.Join(available, ", "))
+}
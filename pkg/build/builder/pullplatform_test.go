@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestConfigurePullPlatform(t *testing.T) {
+	defer func() { pullPlatform = "" }()
+
+	if err := ConfigurePullPlatform(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_PULL_PLATFORM", Value: " linux/arm/v7 "})); err != nil {
+		t.Fatal(err)
+	}
+	if pullPlatform != "linux/arm/v7" {
+		t.Errorf("unexpected pull platform %q", pullPlatform)
+	}
+	if err := ConfigurePullPlatform(testBuildWithEnv()); err != nil || pullPlatform != "" {
+		t.Errorf("expected no pull platform by default, got %q: %v", pullPlatform, err)
+	}
+	if err := ConfigurePullPlatform(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_PULL_PLATFORM", Value: "arm"})); err == nil {
+		t.Errorf("expected an invalid platform to be rejected")
+	}
+	build := testBuildWithEnv(
+		corev1.EnvVar{Name: "BUILD_PULL_PLATFORM", Value: "linux/arm64"},
+		corev1.EnvVar{Name: "BUILD_PLATFORMS", Value: "linux/amd64,linux/arm64"},
+	)
+	if err := ConfigurePullPlatform(build); err == nil {
+		t.Errorf("expected a pull platform to be rejected for a multi-platform build")
+	}
+}
+
+func TestChoosePlatformInstance(t *testing.T) {
+	schema2List := `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": [
+			{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 1, "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111", "platform": {"architecture": "amd64", "os": "linux"}},
+			{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 1, "digest": "sha256:6666666666666666666666666666666666666666666666666666666666666666", "platform": {"architecture": "arm", "os": "linux", "variant": "v6"}},
+			{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 1, "digest": "sha256:7777777777777777777777777777777777777777777777777777777777777777", "platform": {"architecture": "arm", "os": "linux", "variant": "v7"}}
+		]
+	}`
+	ociIndex := `{
+		"schemaVersion": 2,
+		"manifests": [
+			{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 1, "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222", "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}}
+		]
+	}`
+	tests := []struct {
+		name     string
+		blob     string
+		mimeType string
+		platform string
+		expect   string
+		err      string
+	}{
+		{
+			name:     "variant",
+			blob:     schema2List,
+			mimeType: "application/vnd.docker.distribution.manifest.list.v2+json",
+			platform: "linux/arm/v7",
+			expect:   "sha256:7777777777777777777777777777777777777777777777777777777777777777",
+		},
+		{
+			name:     "first for the architecture",
+			blob:     schema2List,
+			mimeType: "application/vnd.docker.distribution.manifest.list.v2+json",
+			platform: "linux/arm",
+			expect:   "sha256:6666666666666666666666666666666666666666666666666666666666666666",
+		},
+		{
+			name:     "OCI index",
+			blob:     ociIndex,
+			mimeType: "application/vnd.oci.image.index.v1+json",
+			platform: "linux/arm64",
+			expect:   "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		},
+		{
+			name:     "missing variant",
+			blob:     schema2List,
+			mimeType: "application/vnd.docker.distribution.manifest.list.v2+json",
+			platform: "linux/arm/v5",
+			err:      "only for linux/amd64, linux/arm/v6, linux/arm/v7",
+		},
+	}
+	for _, test := range tests {
+		instance, err := choosePlatformInstance([]byte(test.blob), test.mimeType, test.platform)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil || instance != test.expect {
+			t.Errorf("%s: expected %s, got %s: %v", test.name, test.expect, instance, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openshift/builder/pkg/build/builder/metrics"
+)
+
+// imagePull is an image which the build pulls, with the paths searched for
+// the credentials to pull it.
+type imagePull struct {
+	name        string
+	searchPaths []string
+}
+
+// pullImagesInParallel pulls each of images with pull, all at once, and
+// returns the first error, if any.  The pulls share the build's storage and
+// blob cache, so a layer which the images have in common and which one of
+// them has already stored is not downloaded again by another.  How long each
+// pull took is logged and recorded in the build's metrics.
+func pullImagesInParallel(images []imagePull, pull func(name string, searchPaths []string) error) error {
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, image imagePull) {
+			defer wg.Done()
+			start := time.Now()
+			if errs[i] = pull(image.name, image.searchPaths); errs[i] != nil {
+				return
+			}
+			elapsed := time.Since(start)
+			log.V(2).Infof("Pulled image %s in %s", image.name, elapsed.Round(time.Millisecond))
+			metrics.RecordImagePull(image.name, elapsed)
+		}(i, image)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
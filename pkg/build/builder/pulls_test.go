@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPullImagesInParallel(t *testing.T) {
+	images := []imagePull{
+		{name: "registry.example.com/builder:latest", searchPaths: []string{"/pull"}},
+		{name: "registry.example.com/runtime:latest", searchPaths: []string{"/pull"}},
+	}
+
+	// each pull waits for the other to start, so they must run at once
+	var started sync.WaitGroup
+	started.Add(len(images))
+	var lock sync.Mutex
+	var pulled []string
+	err := pullImagesInParallel(images, func(name string, searchPaths []string) error {
+		started.Done()
+		done := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			return errors.New("the pulls did not run in parallel")
+		}
+		if !reflect.DeepEqual(searchPaths, []string{"/pull"}) {
+			t.Errorf("unexpected search paths %v for %s", searchPaths, name)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		pulled = append(pulled, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pulled)
+	if expect := []string{images[0].name, images[1].name}; !reflect.DeepEqual(pulled, expect) {
+		t.Errorf("expected %v to be pulled, got %v", expect, pulled)
+	}
+
+	failed := errors.New("failed to pull")
+	err = pullImagesInParallel(images, func(name string, searchPaths []string) error {
+		if name == images[1].name {
+			return failed
+		}
+		return nil
+	})
+	if err != failed {
+		t.Errorf("expected %v, got %v", failed, err)
+	}
+}
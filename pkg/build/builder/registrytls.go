@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// registryTLSConfig is how the builder connects to one registry.
+type registryTLSConfig struct {
+	// Host is the registry's host, with its port if it is not the default
+	Host string `json:"host"`
+	// Insecure skips verifying the registry's certificate, and allows
+	// connecting to it over plain HTTP
+	Insecure bool `json:"insecure"`
+	// CertDir is a directory laid out like a host directory of
+	// /etc/containers/certs.d, holding the *.crt CA certificates which the
+	// registry's certificate is verified with, and the *.cert client
+	// certificates, each beside its *.key, which are presented to it
+	CertDir string `json:"certDir"`
+}
+
+// registryTLSConfigFile is the file that registryTLSConfigs are read from.
+type registryTLSConfigFile struct {
+	Registries []registryTLSConfig `json:"registries"`
+}
+
+// registryTLS holds the configuration of the registries which are not
+// connected to with the defaults, keyed by host.
+var registryTLS = map[string]registryTLSConfig{}
+
+// LoadRegistryTLSConfig reads, from the YAML or JSON file at path, how the
+// builder verifies and authenticates to each registry it pulls from or
+// pushes to, in place of the defaults.
+func LoadRegistryTLSConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	configs, err := readRegistryTLSConfig(f)
+	if err != nil {
+		return fmt.Errorf("error reading the registry TLS configuration from %s: %v", path, err)
+	}
+	registryTLS = configs
+	log.V(2).Infof("Loaded the TLS configuration of %d registries from %s", len(registryTLS), path)
+	return nil
+}
+
+// readRegistryTLSConfig returns the registry configurations in r, keyed by
+// host.
+func readRegistryTLSConfig(r io.Reader) (map[string]registryTLSConfig, error) {
+	var file registryTLSConfigFile
+	if err := utilyaml.NewYAMLOrJSONDecoder(r, 4096).Decode(&file); err != nil && err != io.EOF {
+		return nil, err
+	}
+	configs := map[string]registryTLSConfig{}
+	for _, config := range file.Registries {
+		config.Host = strings.TrimSuffix(strings.TrimSpace(config.Host), "/")
+		if len(config.Host) == 0 || strings.Contains(config.Host, "/") {
+			return nil, fmt.Errorf("invalid registry host %q", config.Host)
+		}
+		if _, ok := configs[config.Host]; ok {
+			return nil, fmt.Errorf("registry %s is configured more than once", config.Host)
+		}
+		if len(config.CertDir) > 0 {
+			if !filepath.IsAbs(config.CertDir) {
+				return nil, fmt.Errorf("the certificate directory %q of registry %s is not absolute", config.CertDir, config.Host)
+			}
+			if st, err := os.Stat(config.CertDir); err != nil || !st.IsDir() {
+				return nil, fmt.Errorf("the certificate directory %q of registry %s does not exist", config.CertDir, config.Host)
+			}
+		}
+		configs[config.Host] = config
+	}
+	return configs, nil
+}
+
+// registrySystemContext returns sc, changed to connect to the registry of
+// imageName as its registryTLSConfig, if any, says.
+func registrySystemContext(sc types.SystemContext, imageName string) types.SystemContext {
+	named, err := ireference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return sc
+	}
+	config, ok := registryTLS[ireference.Domain(named)]
+	if !ok {
+		return sc
+	}
+	if config.Insecure {
+		log.V(4).Infof("Not verifying the certificate of registry %s.", config.Host)
+		sc.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+	if len(config.CertDir) > 0 {
+		log.V(4).Infof("Using the certificates in %s for registry %s.", config.CertDir, config.Host)
+		sc.DockerCertPath = config.CertDir
+	}
+	return sc
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+)
+
+func TestRegistrySystemContext(t *testing.T) {
+	defer func(configs map[string]registryTLSConfig) {
+		registryTLS = configs
+	}(registryTLS)
+
+	dir, err := ioutil.TempDir("", "registry-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configs, err := readRegistryTLSConfig(strings.NewReader(`
+registries:
+- host: registry.internal:5000
+  insecure: true
+- host: quay.example.com/
+  certDir: ` + dir + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]registryTLSConfig{
+		"registry.internal:5000": {Host: "registry.internal:5000", Insecure: true},
+		"quay.example.com":       {Host: "quay.example.com", CertDir: dir},
+	}
+	if !reflect.DeepEqual(configs, expected) {
+		t.Fatalf("expected configuration %v, got %v", expected, configs)
+	}
+	registryTLS = configs
+
+	sc := types.SystemContext{AuthFilePath: "/tmp/config.json"}
+	tests := []struct {
+		image  string
+		expect types.SystemContext
+	}{
+		{
+			image:  "registry.internal:5000/app:latest",
+			expect: types.SystemContext{AuthFilePath: "/tmp/config.json", DockerInsecureSkipTLSVerify: types.OptionalBoolTrue},
+		},
+		{
+			image:  "quay.example.com/app@" + testDigest,
+			expect: types.SystemContext{AuthFilePath: "/tmp/config.json", DockerCertPath: dir},
+		},
+		{
+			image:  "registry.internal/app:latest",
+			expect: sc,
+		},
+		{
+			image:  "busybox",
+			expect: sc,
+		},
+	}
+	for _, test := range tests {
+		if got := registrySystemContext(sc, test.image); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: expected %#v, got %#v", test.image, test.expect, got)
+		}
+	}
+}
+
+func TestReadRegistryTLSConfigErrors(t *testing.T) {
+	for name, config := range map[string]string{
+		"repository":        `{"registries": [{"host": "quay.io/app", "insecure": true}]}`,
+		"duplicate":         `{"registries": [{"host": "quay.io"}, {"host": "quay.io"}]}`,
+		"relative cert dir": `{"registries": [{"host": "quay.io", "certDir": "certs"}]}`,
+		"missing cert dir":  `{"registries": [{"host": "quay.io", "certDir": "/nonexistent/certs"}]}`,
+	} {
+		if _, err := readRegistryTLSConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// serviceAccountTokenPath is where the build pod's bound service account
+// token is read from.
+var serviceAccountTokenPath = dockercfg.ServiceAccountTokenPath
+
+// getRegistryTokenRegistries returns the registries which the build
+// authenticates with its service account token: that of its output, if it
+// is pushed to an image stream in the integrated registry, unless the build
+// strategy's environment turns the token off, and those the environment
+// lists.
+func getRegistryTokenRegistries(build *buildapiv1.Build) ([]string, error) {
+	value, _ := buildStrategyEnv(build, builderutil.RegistryTokenAuth)
+	value = strings.TrimSpace(value)
+	var registries []string
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		if !enabled {
+			return nil, nil
+		}
+		value = ""
+	}
+	if to := build.Spec.Output.To; to != nil && to.Kind == "ImageStreamTag" && len(build.Status.OutputDockerImageReference) > 0 {
+		if named, err := ireference.ParseNormalizedNamed(build.Status.OutputDockerImageReference); err == nil {
+			registries = append(registries, ireference.Domain(named))
+		}
+	}
+	for _, registry := range strings.Split(value, ",") {
+		registry = strings.TrimSpace(registry)
+		if len(registry) == 0 {
+			continue
+		}
+		if strings.ContainsAny(registry, "/@ ") {
+			return nil, fmt.Errorf("invalid %s value %q: %q is not a registry host", builderutil.RegistryTokenAuth, value, registry)
+		}
+		registries = append(registries, registry)
+	}
+	return registries, nil
+}
+
+// ConfigureRegistryTokenAuth authenticates pushes to, and pulls from, the
+// integrated registry, and the other registries that the build strategy's
+// environment lists, with the build pod's bound service account token when
+// the build's secrets hold no credentials for them, so that builds need no
+// dockercfg secret on clusters which no longer generate them.  The token is
+// read again, to pick up the kubelet's rotation of it, when a registry
+// refuses it during a long push.
+func ConfigureRegistryTokenAuth(build *buildapiv1.Build) error {
+	registries, err := getRegistryTokenRegistries(build)
+	if err != nil {
+		return err
+	}
+	keychain := dockercfg.ResolverChain{}
+	for _, resolver := range dockercfg.Keychain {
+		if _, ok := resolver.(dockercfg.ServiceAccountTokenResolver); ok {
+			continue
+		}
+		keychain = append(keychain, resolver)
+		// the token is only used for registries the secrets have nothing for
+		if _, ok := resolver.(dockercfg.MountedSecretResolver); ok && len(registries) > 0 {
+			keychain = append(keychain, dockercfg.ServiceAccountTokenResolver{TokenPath: serviceAccountTokenPath, Registries: registries})
+			log.V(4).Infof("Authenticating with the service account token for registries %s", strings.Join(registries, ", "))
+		}
+	}
+	dockercfg.Keychain = keychain
+	return nil
+}
@@ -0,0 +1,92 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
+)
+
+func registryTokenBuild(kind, value string) *buildapiv1.Build {
+	build := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_REGISTRY_TOKEN_AUTH", Value: value})
+	build.Spec.Output.To = &corev1.ObjectReference{Kind: kind, Name: "app:latest"}
+	build.Status.OutputDockerImageReference = "image-registry.openshift-image-registry.svc:5000/ns/app:latest"
+	return build
+}
+
+func TestGetRegistryTokenRegistries(t *testing.T) {
+	tests := []struct {
+		kind, value string
+		expect      []string
+		expectErr   bool
+	}{
+		{kind: "ImageStreamTag", expect: []string{"image-registry.openshift-image-registry.svc:5000"}},
+		{kind: "ImageStreamTag", value: "true", expect: []string{"image-registry.openshift-image-registry.svc:5000"}},
+		{kind: "ImageStreamTag", value: "false"},
+		{kind: "DockerImage"},
+		{kind: "DockerImage", value: "registry.example.com, mirror.example.com:5000", expect: []string{"registry.example.com", "mirror.example.com:5000"}},
+		{kind: "DockerImage", value: "registry.example.com/ns", expectErr: true},
+	}
+	for _, test := range tests {
+		registries, err := getRegistryTokenRegistries(registryTokenBuild(test.kind, test.value))
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s %q: unexpected error: %v", test.kind, test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(registries, test.expect) {
+			t.Errorf("%s %q: expected %v, got %v", test.kind, test.value, test.expect, registries)
+		}
+	}
+}
+
+func TestConfigureRegistryTokenAuth(t *testing.T) {
+	defer func(keychain dockercfg.ResolverChain, path string) {
+		dockercfg.Keychain, serviceAccountTokenPath = keychain, path
+	}(dockercfg.Keychain, serviceAccountTokenPath)
+	dir, err := ioutil.TempDir("", "registry-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serviceAccountTokenPath = filepath.Join(dir, "token")
+	writeTestFile(t, serviceAccountTokenPath, "first-token")
+	defer os.Setenv(dockercfg.PushAuthType, os.Getenv(dockercfg.PushAuthType))
+	os.Setenv(dockercfg.PushAuthType, filepath.Join(dir, "no-push-secret"))
+
+	// configuring each build replaces the resolver of the one before
+	build := registryTokenBuild("ImageStreamTag", "")
+	for i := 0; i < 2; i++ {
+		if err := ConfigureRegistryTokenAuth(build); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dockercfg.Keychain) != 4 {
+		t.Fatalf("expected the token to be added to the keychain once, got %#v", dockercfg.Keychain)
+	}
+	if _, ok := dockercfg.Keychain[1].(dockercfg.ServiceAccountTokenResolver); !ok {
+		t.Errorf("expected the token to follow the mounted secret, got %#v", dockercfg.Keychain)
+	}
+
+	name := build.Status.OutputDockerImageReference
+	auth, ok := dockercfg.NewHelper().GetDockerAuth(name, dockercfg.PushAuthType)
+	if !ok || auth.Password != "first-token" {
+		t.Fatalf("expected the service account token, got %#v, %v", auth, ok)
+	}
+	writeTestFile(t, serviceAccountTokenPath, "second-token")
+	if refreshed, ok := refreshDockerAuth(name, auth); !ok || refreshed.Password != "second-token" {
+		t.Errorf("expected the rotated token when the registry refuses the first, got %#v, %v", refreshed, ok)
+	}
+
+	if err := ConfigureRegistryTokenAuth(registryTokenBuild("ImageStreamTag", "false")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dockercfg.NewHelper().GetDockerAuth(name, dockercfg.PushAuthType); ok || len(dockercfg.Keychain) != 3 {
+		t.Errorf("expected no service account token when it is turned off")
+	}
+}
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+const (
+	// sourceDateEpochEnv is the variable holding the date, in seconds since
+	// the epoch, which tools following the reproducible-builds.org
+	// convention use in place of the current time.
+	sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+	// gitDateFormat is the format in which git prints the date of a commit.
+	gitDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+)
+
+// imageNormalizer is implemented by DockerClients which can rewrite a local
+// image so that it does not depend on when it was built.
+type imageNormalizer interface {
+	// NormalizeImage dates the image name, and the history and files of
+	// its layers, no later than created, and removes the metadata from its
+	// configuration which differs between builds.
+	NormalizeImage(name string, created time.Time) error
+}
+
+// isReproducibleBuild returns whether build is to produce a reproducible
+// image.  Invalid values are reported by getSourceDateEpoch.
+func isReproducibleBuild(build *buildapiv1.Build) bool {
+	value, _ := buildStrategyEnv(build, builderutil.Reproducible)
+	reproducible, err := strconv.ParseBool(value)
+	return err == nil && reproducible
+}
+
+// getSourceDateEpoch returns the date of the image built by build, if it is
+// to be reproducible: SOURCE_DATE_EPOCH, if the build strategy's environment
+// sets it, or else the date of the commit described by sourceInfo.
+func getSourceDateEpoch(build *buildapiv1.Build, sourceInfo *git.SourceInfo) (*time.Time, error) {
+	value, ok := buildStrategyEnv(build, builderutil.Reproducible)
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	reproducible, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.Reproducible, value, err)
+	}
+	if !reproducible {
+		return nil, nil
+	}
+
+	if value, ok := buildStrategyEnv(build, sourceDateEpochEnv); ok && len(value) > 0 {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", sourceDateEpochEnv, value, err)
+		}
+		epoch := time.Unix(seconds, 0).UTC()
+		return &epoch, nil
+	}
+	if sourceInfo == nil || len(sourceInfo.Date) == 0 {
+		return nil, fmt.Errorf("%s requires a git source, or %s to be set", builderutil.Reproducible, sourceDateEpochEnv)
+	}
+	date, err := time.Parse(gitDateFormat, sourceInfo.Date)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the date %q of commit %s: %v", sourceInfo.Date, sourceInfo.CommitID, err)
+	}
+	epoch := date.UTC()
+	return &epoch, nil
+}
+
+// sourceDateEpochBuildArg adds the SOURCE_DATE_EPOCH build arg for epoch to
+// buildArgs, unless they already set it.
+func sourceDateEpochBuildArg(buildArgs []docker.BuildArg, epoch time.Time) []docker.BuildArg {
+	for _, arg := range buildArgs {
+		if arg.Name == sourceDateEpochEnv {
+			return buildArgs
+		}
+	}
+	return append(buildArgs, docker.BuildArg{Name: sourceDateEpochEnv, Value: strconv.FormatInt(epoch.Unix(), 10)})
+}
+
+// makeImageReproducible rewrites the local image name, which was just built,
+// so that it is the same whenever the build is repeated.
+func makeImageReproducible(client DockerClient, name string, epoch time.Time) error {
+	normalizer, ok := client.(imageNormalizer)
+	if !ok {
+		return fmt.Errorf("%s is not supported by this build client", builderutil.Reproducible)
+	}
+	log.V(0).Infof("Dating image %s at %s", name, epoch.Format(time.RFC3339))
+	return normalizer.NormalizeImage(name, epoch)
+}
+
+// clampLayerTimestamps copies the layer tar stream r to w, with the
+// modification times of its files clamped to created and their access and
+// change times removed, and returns whether any of them changed.
+func clampLayerTimestamps(r io.Reader, w io.Writer, created time.Time) (bool, error) {
+	changed := false
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		if hdr.ModTime.After(created) {
+			hdr.ModTime = created
+			changed = true
+		}
+		if !hdr.AccessTime.IsZero() || !hdr.ChangeTime.IsZero() {
+			hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+			changed = true
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return false, err
+		}
+	}
+	return changed, tw.Close()
+}
+
+// normalizeImageConfig returns the image configuration config dated created,
+// with the history it records dated likewise, the layers it lists replaced
+// by diffIDs, and the ID and host name of the container it was committed
+// from removed.
+func normalizeImageConfig(config []byte, created time.Time, diffIDs []string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(config))
+	decoder.UseNumber()
+	var c map[string]interface{}
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("error parsing the image configuration: %v", err)
+	}
+	date := created.UTC().Format(time.RFC3339)
+	c["created"] = date
+	if history, ok := c["history"].([]interface{}); ok {
+		for _, h := range history {
+			if entry, ok := h.(map[string]interface{}); ok {
+				entry["created"] = date
+			}
+		}
+	}
+	if rootfs, ok := c["rootfs"].(map[string]interface{}); ok {
+		rootfs["diff_ids"] = diffIDs
+	}
+	delete(c, "container")
+	for _, key := range []string{"config", "container_config"} {
+		if containerConfig, ok := c[key].(map[string]interface{}); ok {
+			if _, ok := containerConfig["Hostname"]; ok {
+				containerConfig["Hostname"] = ""
+			}
+		}
+	}
+	return json.Marshal(c)
+}
@@ -0,0 +1,149 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+func TestGetSourceDateEpoch(t *testing.T) {
+	sourceInfo := &git.SourceInfo{}
+	sourceInfo.Date = "Thu Jan 9 17:56:34 2020 -0500"
+	commitDate := time.Date(2020, 1, 9, 22, 56, 34, 0, time.UTC)
+	epochZero := time.Unix(0, 0).UTC()
+
+	tests := []struct {
+		name       string
+		env        []corev1.EnvVar
+		sourceInfo *git.SourceInfo
+		expect     *time.Time
+		expectErr  bool
+	}{
+		{name: "not reproducible", sourceInfo: sourceInfo},
+		{name: "disabled", env: []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "false"}}, sourceInfo: sourceInfo},
+		{name: "commit date", env: []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "true"}}, sourceInfo: sourceInfo, expect: &commitDate},
+		{
+			name:       "SOURCE_DATE_EPOCH",
+			env:        []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "true"}, {Name: "SOURCE_DATE_EPOCH", Value: "0"}},
+			sourceInfo: sourceInfo,
+			expect:     &epochZero,
+		},
+		{name: "no git source", env: []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "true"}}, expectErr: true},
+		{name: "invalid", env: []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "always"}}, sourceInfo: sourceInfo, expectErr: true},
+		{
+			name:       "invalid SOURCE_DATE_EPOCH",
+			env:        []corev1.EnvVar{{Name: "BUILD_REPRODUCIBLE", Value: "true"}, {Name: "SOURCE_DATE_EPOCH", Value: "yesterday"}},
+			sourceInfo: sourceInfo,
+			expectErr:  true,
+		},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		epoch, err := getSourceDateEpoch(build, test.sourceInfo)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(epoch, test.expect) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expect, epoch)
+		}
+	}
+}
+
+func TestClampLayerTimestamps(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	layer := func(modTimes ...time.Time) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for i, modTime := range modTimes {
+			content := []byte("file")
+			if err := tw.WriteHeader(&tar.Header{Name: string(rune('a' + i)), Mode: 0644, Size: int64(len(content)), ModTime: modTime, Format: tar.FormatPAX}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var out bytes.Buffer
+	changed, err := clampLayerTimestamps(bytes.NewReader(layer(old, old)), &out, created)
+	if err != nil || changed {
+		t.Errorf("expected a layer of old files to be unchanged, got %v, %v", changed, err)
+	}
+
+	out.Reset()
+	changed, err = clampLayerTimestamps(bytes.NewReader(layer(old, newer)), &out, created)
+	if err != nil || !changed {
+		t.Fatalf("expected a layer of new files to change, got %v, %v", changed, err)
+	}
+	tr := tar.NewReader(&out)
+	for _, expect := range []time.Time{old, created} {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(expect) {
+			t.Errorf("%s: expected modification time %v, got %v", hdr.Name, expect, hdr.ModTime)
+		}
+		if content, err := ioutil.ReadAll(tr); err != nil || string(content) != "file" {
+			t.Errorf("%s: unexpected content %q, %v", hdr.Name, content, err)
+		}
+	}
+}
+
+func TestNormalizeImageConfig(t *testing.T) {
+	config := []byte(`{
+		"created": "2021-06-01T12:34:56.789Z",
+		"container": "0123456789ab",
+		"config": {"Hostname": "0123456789ab", "Env": ["PATH=/bin"], "Memory": 1073741824},
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:old"]},
+		"history": [{"created": "2019-06-01T00:00:00Z", "created_by": "base"}, {"created": "2021-06-01T12:34:56.789Z", "created_by": "RUN make", "empty_layer": true}]
+	}`)
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	normalized, err := normalizeImageConfig(config, created, []string{"sha256:new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(normalized, &got); err != nil {
+		t.Fatal(err)
+	}
+	var expect map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"created": "2020-01-01T00:00:00Z",
+		"config": {"Hostname": "", "Env": ["PATH=/bin"], "Memory": 1073741824},
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:new"]},
+		"history": [{"created": "2020-01-01T00:00:00Z", "created_by": "base"}, {"created": "2020-01-01T00:00:00Z", "created_by": "RUN make", "empty_layer": true}]
+	}`), &expect); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected configuration %s, got %s", expect, normalized)
+	}
+	if !bytes.Contains(normalized, []byte(`"Memory":1073741824`)) {
+		t.Errorf("expected numbers to be kept as they were, got %s", normalized)
+	}
+
+	again, err := normalizeImageConfig(normalized, created, []string{"sha256:new"})
+	if err != nil || !bytes.Equal(again, normalized) {
+		t.Errorf("expected normalizing to be idempotent, got %s, %v", again, err)
+	}
+}
@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+var (
+	// transientRetries is how many times a stage of the build which failed
+	// transiently is retried within the build pod.
+	transientRetries = 2
+	// transientRetryDelay is the time to wait before the first retry of a
+	// stage, which doubles with each further retry, up to
+	// transientRetryMaxDelay.
+	transientRetryDelay = 5 * time.Second
+)
+
+// transientRetryMaxDelay is the longest time to wait before retrying a
+// stage.
+const transientRetryMaxDelay = 1 * time.Minute
+
+// ConfigureTransientRetries applies the policy for retrying the stages of
+// the build which fail transiently requested by the build strategy's
+// environment, if any.  Pushes and pulls follow ConfigureImageRetries.
+func ConfigureTransientRetries(build *buildapiv1.Build) error {
+	if value, ok := buildStrategyEnv(build, builderutil.TransientRetries); ok && len(value) > 0 {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid %s value %q: must be a non-negative integer", builderutil.TransientRetries, value)
+		}
+		transientRetries = retries
+	}
+	if value, ok := buildStrategyEnv(build, builderutil.TransientRetryDelay); ok && len(value) > 0 {
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay <= 0 || delay > transientRetryMaxDelay {
+			return fmt.Errorf("invalid %s value %q: must be a positive duration no longer than %v", builderutil.TransientRetryDelay, value, transientRetryMaxDelay)
+		}
+		transientRetryDelay = delay
+	}
+	return nil
+}
+
+// retryTransientFailures runs stage, which must be safe to run again from
+// the start, and runs it again while it fails with an error which
+// classifyFailure finds to be retryable, such as a DNS timeout or the remote
+// hanging up, so that a blip does not fail the whole build.  Only the stage
+// is retried, and not the work of the build before it.
+func retryTransientFailures(ctx context.Context, name string, stage func() error) error {
+	delay := transientRetryDelay
+	for retries := 0; ; retries++ {
+		err := stage()
+		if err == nil || retries == transientRetries || ctx.Err() != nil {
+			return err
+		}
+		code := classifyFailure(err)
+		if !code.Retryable() {
+			return err
+		}
+		pause := wait.Jitter(delay, pushOrPullRetryJitter)
+		log.V(0).Infof("warning: Failed to %s (%s), retrying in %s ...: %v", name, code, pause.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+		if delay *= 2; delay > transientRetryMaxDelay {
+			delay = transientRetryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/library-go/pkg/git"
+)
+
+func TestConfigureTransientRetries(t *testing.T) {
+	defer func(retries int, delay time.Duration) {
+		transientRetries, transientRetryDelay = retries, delay
+	}(transientRetries, transientRetryDelay)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_TRANSIENT_RETRIES", Value: "4"},
+			{Name: "BUILD_TRANSIENT_RETRY_DELAY", Value: "2s"},
+		},
+	}
+	if err := ConfigureTransientRetries(build); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if transientRetries != 4 || transientRetryDelay != 2*time.Second {
+		t.Errorf("unexpected retry policy %d, %s", transientRetries, transientRetryDelay)
+	}
+
+	for _, env := range []corev1.EnvVar{
+		{Name: "BUILD_TRANSIENT_RETRIES", Value: "-1"},
+		{Name: "BUILD_TRANSIENT_RETRY_DELAY", Value: "soon"},
+		{Name: "BUILD_TRANSIENT_RETRY_DELAY", Value: "1h"},
+	} {
+		build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{env}
+		if err := ConfigureTransientRetries(build); err == nil {
+			t.Errorf("expected an error for %s=%s", env.Name, env.Value)
+		}
+	}
+}
+
+// flakyFetcher fails with each of errs in turn before writing a file.
+type flakyFetcher struct {
+	errs    []error
+	fetches int
+}
+
+func (f *flakyFetcher) Name() string {
+	return "flaky source"
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, dir string) (*git.SourceInfo, error) {
+	f.fetches++
+	// a partial fetch must not break the next attempt
+	if err := ioutil.WriteFile(filepath.Join(dir, "partial"), nil, 0644); err != nil {
+		return nil, err
+	}
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return &git.SourceInfo{}, ioutil.WriteFile(filepath.Join(dir, "source"), nil, 0644)
+}
+
+func TestFetchSourceRetries(t *testing.T) {
+	defer func(retries int, delay time.Duration, count func() int64) {
+		transientRetries, transientRetryDelay, countOOMKills = retries, delay, count
+	}(transientRetries, transientRetryDelay, countOOMKills)
+	transientRetries, transientRetryDelay = 2, time.Millisecond
+	countOOMKills = func() int64 { return 0 }
+
+	tmp, err := ioutil.TempDir("", "fetch-retries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "src")
+
+	hangup := errors.New("fatal: the remote end hung up unexpectedly")
+	tests := []struct {
+		name          string
+		errs          []error
+		expectFetches int
+		expectErr     bool
+	}{
+		{
+			name:          "transient",
+			errs:          []error{hangup, errors.New("dial tcp: lookup github.com on 10.0.0.10:53: read udp: i/o timeout")},
+			expectFetches: 3,
+		},
+		{
+			name:          "too many transient failures",
+			errs:          []error{hangup, hangup, hangup},
+			expectFetches: 3,
+			expectErr:     true,
+		},
+		{
+			name:          "not transient",
+			errs:          []error{gitAuthError("https://example.com/app.git")},
+			expectFetches: 1,
+			expectErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := &flakyFetcher{errs: test.errs}
+			_, err := FetchSource(context.Background(), []SourceFetcher{fetcher}, dir)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if fetcher.fetches != test.expectFetches {
+				t.Errorf("expected %d fetches, got %d", test.expectFetches, fetcher.fetches)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "source")); (err == nil) == test.expectErr {
+				t.Errorf("expected the source to be fetched %v, got %v", !test.expectErr, err)
+			}
+		})
+	}
+}
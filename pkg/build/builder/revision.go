@@ -0,0 +1,206 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// The kinds of ref a build's git source may name.
+const (
+	RefTypeBranch = "branch"
+	RefTypeTag    = "tag"
+	RefTypeCommit = "commit"
+	// RefTypeOther is a ref which is neither a branch nor a tag, such as
+	// that of a pull request
+	RefTypeOther = "ref"
+)
+
+// revisionDetailsFile is where the clone step records the details of the
+// revision of the source it fetched, for the steps which follow it.
+var revisionDetailsFile = filepath.Join(buildWorkDirMount, "revision.json")
+
+// RevisionDetails are the details of the revision of a build's git source
+// beyond those which the build's spec.revision holds.
+type RevisionDetails struct {
+	Commit string `json:"commit"`
+	// Ref is the ref which the build names, or else the default branch
+	// which was cloned
+	Ref string `json:"ref,omitempty"`
+	// RefType says whether Ref is a branch, a tag, a commit or another ref
+	RefType string `json:"refType,omitempty"`
+	// Tags are the tags which point at the commit
+	Tags       []string          `json:"tags,omitempty"`
+	Author     RevisionUser      `json:"author"`
+	Committer  RevisionUser      `json:"committer"`
+	Submodules []SubmoduleCommit `json:"submodules,omitempty"`
+}
+
+// RevisionUser is the author or committer of a commit.
+type RevisionUser struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	// Date is in RFC 3339 format
+	Date string `json:"date,omitempty"`
+}
+
+// SubmoduleCommit is the commit which a submodule of the source, nested
+// submodules included, was checked out at.
+type SubmoduleCommit struct {
+	Path   string `json:"path"`
+	URL    string `json:"url,omitempty"`
+	Commit string `json:"commit"`
+}
+
+// fullCommitID matches a full SHA-1 or SHA-256 commit ID.
+var fullCommitID = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// GetRevisionDetails returns the details of the revision of the build's git
+// source which was fetched into dir, or nil if the build has no git source.
+func GetRevisionDetails(gitClient GitClient, build *buildapiv1.Build, dir string) (*RevisionDetails, error) {
+	if build.Spec.Source.Git == nil {
+		return nil, nil
+	}
+	format := strings.Join([]string{"%H", "%an", "%ae", "%aI", "%cn", "%ce", "%cI"}, "%x00")
+	out, _, err := gitClient.Run(dir, "log", "-1", "--format="+format, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the commit of the source: %v", err)
+	}
+	fields := strings.Split(out, "\x00")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("unable to read the commit of the source: unexpected output %q", out)
+	}
+	details := &RevisionDetails{
+		Commit:    fields[0],
+		Author:    RevisionUser{Name: fields[1], Email: fields[2], Date: fields[3]},
+		Committer: RevisionUser{Name: fields[4], Email: fields[5], Date: fields[6]},
+	}
+	details.Ref, details.RefType = resolveRefType(gitClient, dir, build.Spec.Source.Git.Ref, details.Commit)
+	if out, _, err := gitClient.Run(dir, "tag", "--points-at", "HEAD"); err == nil && len(out) > 0 {
+		details.Tags = strings.Split(out, "\n")
+	}
+	if details.Submodules, err = submoduleCommits(gitClient, dir); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// resolveRefType returns the ref which was checked out at commit, and what
+// kind of ref it is.  Without a ref, it is the branch which HEAD is on.
+func resolveRefType(gitClient GitClient, dir, ref, commit string) (string, string) {
+	if len(ref) == 0 {
+		branch, _, err := gitClient.Run(dir, "symbolic-ref", "--short", "HEAD")
+		if err != nil {
+			return "", ""
+		}
+		return branch, RefTypeBranch
+	}
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		return ref, RefTypeBranch
+	case strings.HasPrefix(ref, "refs/tags/"):
+		return ref, RefTypeTag
+	case strings.HasPrefix(ref, "refs/"):
+		return ref, RefTypeOther
+	}
+	if _, _, err := gitClient.Run(dir, "rev-parse", "--verify", "--quiet", "refs/tags/"+ref); err == nil {
+		return ref, RefTypeTag
+	}
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/origin/"} {
+		if _, _, err := gitClient.Run(dir, "rev-parse", "--verify", "--quiet", prefix+ref); err == nil {
+			return ref, RefTypeBranch
+		}
+	}
+	if fullCommitID.MatchString(ref) || (len(ref) >= 4 && strings.HasPrefix(commit, ref)) {
+		return ref, RefTypeCommit
+	}
+	return ref, RefTypeOther
+}
+
+// submoduleCommits returns the commits which the submodules checked out in
+// dir, and the submodules nested in them, are at, with the URLs they were
+// cloned from, without any credentials.
+func submoduleCommits(gitClient GitClient, dir string) ([]SubmoduleCommit, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err != nil {
+		return nil, nil
+	}
+	out, _, err := gitClient.Run(dir, "submodule", "--quiet", "foreach", "--recursive", `printf '%s\t%s\t%s\n' "$displaypath" "$sha1" "$(git config --get remote.origin.url)"`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the commits of the submodules of the source: %v", err)
+	}
+	var submodules []SubmoduleCommit
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		submodules = append(submodules, SubmoduleCommit{
+			Path:   fields[0],
+			Commit: fields[1],
+			URL:    credentialsInURL.ReplaceAllString(fields[2], "://"),
+		})
+	}
+	return submodules, nil
+}
+
+// RecordRevisionDetails saves details for the steps of the build which
+// follow the clone step, and writes them to the termination message of the
+// clone step's container, from where tooling can read them without
+// re-cloning the source.
+func RecordRevisionDetails(details *RevisionDetails) {
+	if details == nil {
+		return
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		log.V(0).Infof("error: Unable to serialize the details of the source revision: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(revisionDetailsFile, data, 0644); err != nil {
+		log.V(0).Infof("error: Unable to save the details of the source revision: %v", err)
+	}
+	log.V(4).Infof("Source revision details: %s", data)
+	if len(data) > terminationMessageLimit {
+		log.V(0).Infof("warning: The details of the source revision are %d bytes, too large for the termination message", len(data))
+		return
+	}
+	if err := ioutil.WriteFile(terminationMessagePath, data, 0644); err != nil {
+		log.V(2).Infof("Unable to write the details of the source revision to the termination message: %v", err)
+	}
+}
+
+// addRevisionLabels adds the kind of the ref which was built and the tags
+// of the commit in details, if any, to labels.
+func addRevisionLabels(labels map[string]string, details *RevisionDetails) {
+	if details == nil {
+		return
+	}
+	if len(details.RefType) > 0 {
+		labels[builderutil.DefaultDockerLabelNamespace+"build.commit.ref-type"] = details.RefType
+	}
+	if len(details.Tags) > 0 {
+		labels[builderutil.DefaultDockerLabelNamespace+"build.commit.tags"] = strings.Join(details.Tags, ",")
+	}
+}
+
+// readRevisionDetails returns the details of the revision of the source
+// which the clone step recorded, if it recorded any.
+func readRevisionDetails() *RevisionDetails {
+	data, err := ioutil.ReadFile(revisionDetailsFile)
+	if err != nil {
+		return nil
+	}
+	details := &RevisionDetails{}
+	if err := json.Unmarshal(data, details); err != nil {
+		log.V(0).Infof("error: Unable to read the details of the source revision: %v", err)
+		return nil
+	}
+	return details
+}
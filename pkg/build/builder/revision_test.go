@@ -0,0 +1,115 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetRevisionDetails(t *testing.T) {
+	repo, err := initializeTestGitRepo("revision")
+	defer repo.cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.addSubmodule(); err != nil {
+		t.Skipf("unable to add a submodule: %v", err)
+	}
+	if err := repo.addCommit(); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo.Path
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("tag", "v1.0")
+	git("tag", "-a", "-m", "release", "release-1.0")
+	branch := git("symbolic-ref", "--short", "HEAD")
+	commit, err := repo.getRef(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subCommit, err := repo.Submodule.getRef(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "file://" + repo.Path}
+	details, err := GetRevisionDetails(NewGitClient(nil), build, repo.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Commit != commit || details.Ref != branch || details.RefType != RefTypeBranch {
+		t.Errorf("expected commit %s of branch %s, got %#v", commit, branch, details)
+	}
+	if expect := []string{"release-1.0", "v1.0"}; !reflect.DeepEqual(details.Tags, expect) {
+		t.Errorf("expected tags %v, got %v", expect, details.Tags)
+	}
+	if details.Author.Name != "Me Myself" || details.Committer.Email != "me@example.com" || len(details.Author.Date) == 0 {
+		t.Errorf("unexpected author and committer %#v, %#v", details.Author, details.Committer)
+	}
+	if len(details.Submodules) != 1 || details.Submodules[0].Path != "sub" || details.Submodules[0].Commit != subCommit {
+		t.Errorf("expected submodule sub at %s, got %#v", subCommit, details.Submodules)
+	}
+
+	for ref, expect := range map[string]string{
+		"v1.0":                 RefTypeTag,
+		"refs/tags/v1.0":       RefTypeTag,
+		branch:                 RefTypeBranch,
+		"refs/heads/" + branch: RefTypeBranch,
+		commit:                 RefTypeCommit,
+		commit[:8]:             RefTypeCommit,
+		"refs/pull/1/head":     RefTypeOther,
+	} {
+		build.Spec.Source.Git.Ref = ref
+		details, err := GetRevisionDetails(NewGitClient(nil), build, repo.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if details.Ref != ref || details.RefType != expect {
+			t.Errorf("%s: expected a %s, got %s", ref, expect, details.RefType)
+		}
+	}
+
+	build.Spec.Source.Git = nil
+	if details, err := GetRevisionDetails(NewGitClient(nil), build, repo.Path); details != nil || err != nil {
+		t.Errorf("expected no details without a git source, got %#v, %v", details, err)
+	}
+}
+
+func TestReadRevisionDetails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revision-details")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { revisionDetailsFile = file }(revisionDetailsFile)
+	revisionDetailsFile = filepath.Join(dir, "revision.json")
+
+	if details := readRevisionDetails(); details != nil {
+		t.Errorf("expected no details, got %#v", details)
+	}
+	writeTestFile(t, revisionDetailsFile, `{"commit":"abc","ref":"v1","refType":"tag","tags":["v1","latest"]}`)
+	details := readRevisionDetails()
+	if details == nil || details.Commit != "abc" || details.RefType != RefTypeTag {
+		t.Fatalf("unexpected details %#v", details)
+	}
+	labels := map[string]string{}
+	addRevisionLabels(labels, details)
+	expect := map[string]string{"io.openshift.build.commit.ref-type": "tag", "io.openshift.build.commit.tags": "v1,latest"}
+	if !reflect.DeepEqual(labels, expect) {
+		t.Errorf("expected labels %v, got %v", expect, labels)
+	}
+}
@@ -0,0 +1,336 @@
+// +build linux
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containers/buildah"
+	"github.com/containers/storage"
+	"github.com/containers/storage/pkg/idtools"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// rootlessReexecEnv is set in the environment of the builder once it has
+// been re-executed in a user namespace of its own.  Its value is the number
+// of IDs mapped into the namespace.
+const rootlessReexecEnv = "_BUILDER_ROOTLESS_IDS"
+
+// capSysAdmin is the bit of CAP_SYS_ADMIN in a capability set.
+const capSysAdmin = 21
+
+// Rootless returns whether the builder is running in a user namespace of its
+// own, in which it is root without being privileged on the node.
+func Rootless() bool {
+	return len(os.Getenv(rootlessReexecEnv)) > 0
+}
+
+// rootlessSingleID returns whether the builder's user namespace maps only
+// the builder's own user and group, so that files in images cannot be owned
+// by any other.
+func rootlessSingleID() bool {
+	return os.Getenv(rootlessReexecEnv) == "1"
+}
+
+// Unprivileged returns whether the builder lacks CAP_SYS_ADMIN, as it does
+// when its pod is not run with the privileged SCC.
+func Unprivileged() bool {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	return !hasCapability(string(status), capSysAdmin)
+}
+
+// hasCapability returns whether the effective capabilities listed in status,
+// the contents of /proc/<pid>/status, include capability.
+func hasCapability(status string, capability uint) bool {
+	for _, line := range strings.Split(status, "\n") {
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		return err == nil && caps&(1<<capability) != 0
+	}
+	return false
+}
+
+// rootlessRequested returns whether the builder pod's BUILD_ROOTLESS asks
+// for the builder to run in a user namespace of its own: always when it is
+// "true", or only when the builder is unprivileged when it is "auto".
+func rootlessRequested() (bool, error) {
+	value := os.Getenv(builderutil.Rootless)
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	case "auto":
+		return Unprivileged(), nil
+	}
+	return false, fmt.Errorf("invalid %s value %q: must be \"true\", \"false\" or \"auto\"", builderutil.Rootless, value)
+}
+
+// MaybeReexecRootless re-executes the builder in new user and mount
+// namespaces, in which it is root, when BUILD_ROOTLESS asks for it, and
+// exits with the status of the re-executed builder.  The builder's user and
+// group are mapped to root in the namespace, along with the subordinate IDs
+// assigned to them in /etc/subuid and /etc/subgid when newuidmap and
+// newgidmap are available to map them.  In the re-executed builder, and when
+// no user namespace is needed, it returns at once.
+func MaybeReexecRootless() {
+	if Rootless() {
+		waitForIDMappings()
+		return
+	}
+	requested, err := rootlessRequested()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !requested {
+		return
+	}
+	os.Exit(reexecRootless())
+}
+
+// waitForIDMappings waits, in the re-executed builder, for the builder which
+// started it to map the IDs of its user namespace.
+func waitForIDMappings() {
+	ready := os.NewFile(3, "id-mappings")
+	ioutil.ReadAll(ready)
+	ready.Close()
+}
+
+// reexecRootless runs the builder again in new user and mount namespaces,
+// returning its exit status.
+func reexecRootless() int {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to set up a user namespace: %v\n", err)
+		return 1
+	}
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	uids, gids := rootlessIDMappings(os.Geteuid(), os.Getegid())
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", rootlessReexecEnv, mappedIDs(uids)))
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to create a user namespace: %v\n", err)
+		return 1
+	}
+	r.Close()
+	if err := writeIDMappings(cmd.Process.Pid, uids, gids); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to map IDs into the user namespace: %v\n", err)
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 1
+	}
+	w.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	err = cmd.Wait()
+	signal.Stop(signals)
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Exited() {
+		return status.ExitStatus()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return 1
+}
+
+// rootlessIDMappings returns the IDs mapped into the builder's user
+// namespace: the builder's user and group as root, followed by their
+// subordinate IDs when newuidmap and newgidmap can map those.
+func rootlessIDMappings(uid, gid int) ([]idtools.IDMap, []idtools.IDMap) {
+	uids := []idtools.IDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	gids := []idtools.IDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	if _, err := exec.LookPath("newuidmap"); err != nil {
+		return uids, gids
+	}
+	if _, err := exec.LookPath("newgidmap"); err != nil {
+		return uids, gids
+	}
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	group := strconv.Itoa(gid)
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	mappings, err := idtools.NewIDMappings(name, group)
+	if err != nil {
+		return uids, gids
+	}
+	return append(uids, shiftIDMaps(mappings.UIDs())...), append(gids, shiftIDMaps(mappings.GIDs())...)
+}
+
+// shiftIDMaps moves maps, which start at ID 0, to start at ID 1, after root.
+func shiftIDMaps(maps []idtools.IDMap) []idtools.IDMap {
+	shifted := make([]idtools.IDMap, 0, len(maps))
+	for _, m := range maps {
+		m.ContainerID++
+		shifted = append(shifted, m)
+	}
+	return shifted
+}
+
+// mappedIDs returns the number of IDs which maps map.
+func mappedIDs(maps []idtools.IDMap) int {
+	n := 0
+	for _, m := range maps {
+		n += m.Size
+	}
+	return n
+}
+
+// writeIDMappings maps uids and gids into the user namespace of process pid.
+// A process may map its own user and group itself, but only newuidmap and
+// newgidmap may map subordinate IDs.
+func writeIDMappings(pid int, uids, gids []idtools.IDMap) error {
+	if len(uids) == 1 && len(gids) == 1 {
+		proc := fmt.Sprintf("/proc/%d", pid)
+		if err := ioutil.WriteFile(filepath.Join(proc, "uid_map"), []byte(idMapLines(uids)), 0); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(proc, "setgroups"), []byte("deny"), 0); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(proc, "gid_map"), []byte(idMapLines(gids)), 0)
+	}
+	for command, maps := range map[string][]idtools.IDMap{"newuidmap": uids, "newgidmap": gids} {
+		args := []string{strconv.Itoa(pid)}
+		for _, m := range maps {
+			args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+		}
+		if out, err := exec.Command(command, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", command, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// idMapLines formats maps as the contents of a uid_map or gid_map file.
+func idMapLines(maps []idtools.IDMap) string {
+	lines := ""
+	for _, m := range maps {
+		lines += fmt.Sprintf("%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+	}
+	return lines
+}
+
+// ConfigureRootlessStorage adjusts storeOptions for a builder running in a
+// user namespace of its own.  Unless the build or the builder pod sets
+// BUILD_STORAGE_DRIVER, overlay storage is mounted by fuse-overlayfs where
+// /dev/fuse is available, or natively where the kernel allows overlay mounts
+// in a user namespace, and otherwise the vfs driver is used.  When the namespace maps
+// only the builder's own IDs, the files of images cannot be owned by any
+// other, so errors changing their owners are ignored.  Storage directories
+// which the builder cannot write to are moved to a temporary directory.
+func ConfigureRootlessStorage(build *buildapiv1.Build, storeOptions *storage.StoreOptions) {
+	if !Rootless() {
+		return
+	}
+	if _, ok := requestedStorageDriver(build); !ok {
+		driver, option, reason := rootlessStorageDriver(storeOptions.GraphDriverName)
+		log.V(0).Infof("Using the %s storage driver: %s", driver, reason)
+		if driver != storeOptions.GraphDriverName {
+			storeOptions.GraphDriverName = driver
+			storeOptions.GraphDriverOptions = storageDriverOptions(storeOptions.GraphDriverOptions, driver)
+		}
+		if len(option) > 0 {
+			storeOptions.GraphDriverOptions = append(storeOptions.GraphDriverOptions, option)
+		}
+	}
+	if rootlessSingleID() {
+		storeOptions.GraphDriverOptions = append(storeOptions.GraphDriverOptions, storeOptions.GraphDriverName+".ignore_chown_errors=true")
+	}
+	for _, dir := range []*string{&storeOptions.GraphRoot, &storeOptions.RunRoot} {
+		if err := os.MkdirAll(*dir, 0700); err == nil && syscall.Access(*dir, 2) == nil {
+			continue
+		}
+		writable := filepath.Join(os.TempDir(), "containers", filepath.Base(*dir))
+		log.V(0).Infof("Storing %s under %s instead, the builder cannot write to it", *dir, writable)
+		*dir = writable
+	}
+}
+
+// rootlessStorageDriver returns the storage driver which a builder in a user
+// namespace of its own uses in place of driver, any option it needs, and why
+// it was chosen.
+func rootlessStorageDriver(driver string) (string, string, string) {
+	if driver != "" && driver != "overlay" && driver != "overlay2" {
+		return driver, "", "it was configured"
+	}
+	if path, err := exec.LookPath("fuse-overlayfs"); err == nil {
+		if _, err := os.Stat("/dev/fuse"); err == nil {
+			return "overlay", "overlay.mount_program=" + path, "layers are mounted by fuse-overlayfs"
+		}
+	}
+	err := probeOverlay(os.TempDir())
+	if err != nil {
+		return "vfs", "", fmt.Sprintf("neither fuse-overlayfs nor native overlay mounts are available (%v)", err)
+	}
+	return "overlay", "", "the kernel supports overlay mounts in user namespaces"
+}
+
+// probeOverlay returns an error if an overlay filesystem cannot be mounted
+// in a new directory under dir.
+func probeOverlay(dir string) error {
+	probe, err := ioutil.TempDir(dir, "overlay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(probe)
+	for _, sub := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.Mkdir(filepath.Join(probe, sub), 0700); err != nil {
+			return err
+		}
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", filepath.Join(probe, "lower"), filepath.Join(probe, "upper"), filepath.Join(probe, "work"))
+	merged := filepath.Join(probe, "merged")
+	if err := syscall.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return err
+	}
+	return syscall.Unmount(merged, 0)
+}
+
+// rootlessBuildOptions drops the resource limits from opts of a builder in a
+// user namespace of its own, which may not set up cgroups for the containers
+// it runs.  They are still bound by the limits of the builder's own cgroup.
+func rootlessBuildOptions(opts *buildah.CommonBuildOptions) *buildah.CommonBuildOptions {
+	if !Rootless() {
+		return opts
+	}
+	if opts.Memory != 0 || opts.CPUQuota != 0 || opts.CPUShares != 0 || len(opts.CgroupParent) > 0 {
+		log.V(2).Infof("Not passing resource limits on to build containers, which are bound by the builder's own in a user namespace")
+	}
+	opts.CPUPeriod, opts.CPUQuota, opts.CPUShares = 0, 0, 0
+	opts.Memory, opts.MemorySwap = 0, 0
+	opts.CgroupParent = ""
+	return opts
+}
@@ -0,0 +1,102 @@
+// +build linux
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/containers/buildah"
+	"github.com/containers/storage"
+	"github.com/containers/storage/pkg/idtools"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestHasCapability(t *testing.T) {
+	status := "Name:\tbuilder\nCapInh:\t0000000000000000\nCapEff:\t00000000a80425fb\n"
+	if hasCapability(status, capSysAdmin) {
+		t.Errorf("expected CAP_SYS_ADMIN not to be found in the default capabilities")
+	}
+	if !hasCapability("CapEff:\t000001ffffffffff\n", capSysAdmin) {
+		t.Errorf("expected CAP_SYS_ADMIN to be found in the capabilities of a privileged process")
+	}
+}
+
+func TestRootlessRequested(t *testing.T) {
+	defer os.Setenv("BUILD_ROOTLESS", os.Getenv("BUILD_ROOTLESS"))
+	for value, expected := range map[string]bool{"": false, "false": false, "TRUE": true} {
+		os.Setenv("BUILD_ROOTLESS", value)
+		if requested, err := rootlessRequested(); err != nil || requested != expected {
+			t.Errorf("%q: expected %v, got %v, %v", value, expected, requested, err)
+		}
+	}
+	os.Setenv("BUILD_ROOTLESS", "sometimes")
+	if _, err := rootlessRequested(); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
+
+func TestIDMapLines(t *testing.T) {
+	uids := append([]idtools.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+		shiftIDMaps([]idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}})...)
+	if lines := idMapLines(uids); lines != "0 1000 1\n1 100000 65536\n" {
+		t.Errorf("unexpected ID mappings %q", lines)
+	}
+	if n := mappedIDs(uids); n != 65537 {
+		t.Errorf("expected 65537 mapped IDs, got %d", n)
+	}
+}
+
+func TestConfigureRootlessStorage(t *testing.T) {
+	defer os.Setenv(rootlessReexecEnv, os.Getenv(rootlessReexecEnv))
+	defer os.Setenv("BUILD_STORAGE_DRIVER", os.Getenv("BUILD_STORAGE_DRIVER"))
+	os.Setenv("BUILD_STORAGE_DRIVER", "vfs")
+	dir, err := ioutil.TempDir("", "rootless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Unsetenv(rootlessReexecEnv)
+	options := storage.StoreOptions{GraphDriverName: "vfs", GraphRoot: dir, RunRoot: dir}
+	ConfigureRootlessStorage(&buildapiv1.Build{}, &options)
+	if len(options.GraphDriverOptions) != 0 {
+		t.Errorf("expected the storage of a builder which is not rootless to be left alone, got %v", options.GraphDriverOptions)
+	}
+
+	os.Setenv(rootlessReexecEnv, "1")
+	ConfigureRootlessStorage(&buildapiv1.Build{}, &options)
+	if !reflect.DeepEqual(options.GraphDriverOptions, []string{"vfs.ignore_chown_errors=true"}) {
+		t.Errorf("expected chown errors to be ignored with a single ID mapped, got %v", options.GraphDriverOptions)
+	}
+	if options.GraphRoot != dir || options.RunRoot != dir {
+		t.Errorf("expected writable storage directories to be kept, got %q and %q", options.GraphRoot, options.RunRoot)
+	}
+}
+
+func TestRootlessIsolation(t *testing.T) {
+	defer os.Setenv(rootlessReexecEnv, os.Getenv(rootlessReexecEnv))
+	defer func(oci, rootless func() error) {
+		checkOCIIsolation, checkRootlessIsolation = oci, rootless
+	}(checkOCIIsolation, checkRootlessIsolation)
+	checkOCIIsolation = func() error { return nil }
+	checkRootlessIsolation = func() error { return nil }
+
+	os.Setenv(rootlessReexecEnv, "65537")
+	for _, spec := range []string{"", "oci", "auto", "rootless"} {
+		if isolation, err := getIsolation(spec); err != nil || isolation != buildah.IsolationOCIRootless {
+			t.Errorf("%q: expected rootless isolation, got %v, %v", spec, isolation, err)
+		}
+	}
+	if isolation, err := getIsolation("chroot"); err != nil || isolation != buildah.IsolationChroot {
+		t.Errorf("expected chroot isolation, got %v, %v", isolation, err)
+	}
+
+	opts := rootlessBuildOptions(&buildah.CommonBuildOptions{Memory: 1024, CPUQuota: 50000, CgroupParent: "/kubepods", Ulimit: []string{"nofile=1024:1024"}})
+	if !reflect.DeepEqual(opts, &buildah.CommonBuildOptions{Ulimit: []string{"nofile=1024:1024"}}) {
+		t.Errorf("expected the resource limits to be dropped, got %#v", opts)
+	}
+}
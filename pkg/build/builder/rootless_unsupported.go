@@ -0,0 +1,22 @@
+// +build !linux
+
+package builder
+
+import (
+	"github.com/containers/storage"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+// Rootless returns false.
+func Rootless() bool {
+	return false
+}
+
+// MaybeReexecRootless does nothing.
+func MaybeReexecRootless() {
+}
+
+// ConfigureRootlessStorage does nothing.
+func ConfigureRootlessStorage(build *buildapiv1.Build, storeOptions *storage.StoreOptions) {
+}
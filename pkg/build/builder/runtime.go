@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+	s2iconstants "github.com/openshift/source-to-image/pkg/api/constants"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+)
+
+// runtimeBuildAlias is a unique key to use for an alias of the stage which
+// runs assemble, when its artifacts are copied into a runtime image.
+var runtimeBuildAlias = "s2ibuild" + strings.Replace(string(uuid.NewUUID()), "-", "", -1)
+
+// s2iRuntime is the runtime image into which the artifacts of a Source
+// strategy build are copied, and how they are copied.
+type s2iRuntime struct {
+	Image     string
+	Artifacts s2iapi.VolumeList
+	// WorkingDir is the working directory of the runtime image, under
+	// which the artifacts are copied
+	WorkingDir string
+	// ScriptsDir holds the assemble-runtime and run scripts in the runtime
+	// image
+	ScriptsDir string
+	// User is the default user of the runtime image
+	User string
+	// AssembleUser runs assemble-runtime, if it is not User
+	AssembleUser string
+}
+
+// getRuntimeImage returns the runtime image that the build strategy's
+// environment requests the build's artifacts be copied into, if any.
+func getRuntimeImage(build *buildapiv1.Build) string {
+	image, _ := buildStrategyEnv(build, builderutil.RuntimeImage)
+	return strings.TrimSpace(image)
+}
+
+// getS2IRuntime describes the runtime image, which must already be present,
+// the way S2I does: the artifacts are listed by the build strategy's
+// environment or else by the image's assemble-input-files label, and the
+// image's scripts-url label locates its scripts.
+func getS2IRuntime(client DockerClient, build *buildapiv1.Build, image string) (*s2iRuntime, error) {
+	img, err := client.InspectImage(image)
+	if err != nil {
+		return nil, err
+	}
+	labels := img.ContainerConfig.Labels
+	runtime := &s2iRuntime{
+		Image:      image,
+		WorkingDir: img.ContainerConfig.WorkingDir,
+		User:       img.ContainerConfig.User,
+	}
+	if user := labels[s2iconstants.AssembleRuntimeUserLabel]; user != runtime.User {
+		runtime.AssembleUser = user
+	}
+
+	mapping, _ := buildStrategyEnv(build, builderutil.RuntimeArtifacts)
+	source := builderutil.RuntimeArtifacts
+	if len(strings.TrimSpace(mapping)) == 0 {
+		mapping = labels[s2iconstants.AssembleInputFilesLabel]
+		source = fmt.Sprintf("the %s label of %s", s2iconstants.AssembleInputFilesLabel, image)
+	}
+	if len(strings.TrimSpace(mapping)) == 0 {
+		return nil, fmt.Errorf("no runtime artifacts: set %s or the %s label of %s", builderutil.RuntimeArtifacts, s2iconstants.AssembleInputFilesLabel, image)
+	}
+	if err := runtime.Artifacts.Set(strings.TrimSpace(mapping)); err != nil {
+		return nil, fmt.Errorf("invalid runtime artifacts in %s: %v", source, err)
+	}
+	for _, artifact := range runtime.Artifacts {
+		switch {
+		case !path.IsAbs(artifact.Source):
+			return nil, fmt.Errorf("invalid runtime artifacts mapping %q -> %q in %s: the source must be an absolute path", artifact.Source, artifact.Destination, source)
+		case path.IsAbs(artifact.Destination):
+			return nil, fmt.Errorf("invalid runtime artifacts mapping %q -> %q in %s: the destination must be a relative path", artifact.Source, artifact.Destination, source)
+		}
+	}
+
+	scriptsURL := labels[s2iconstants.ScriptsURLLabel]
+	if len(scriptsURL) == 0 {
+		scriptsURL = labels[s2iconstants.DeprecatedScriptsURLLabel]
+	}
+	if !strings.HasPrefix(scriptsURL, "image://") {
+		return nil, fmt.Errorf("unable to locate the scripts of runtime image %s: its %s label must be an image:// URL", image, s2iconstants.ScriptsURLLabel)
+	}
+	runtime.ScriptsDir = strings.TrimPrefix(scriptsURL, "image://")
+	return runtime, nil
+}
+
+// appendRuntimeStage appends a stage to node, the Dockerfile generated for a
+// Source strategy build, which copies the build's artifacts into the runtime
+// image, runs its assemble-runtime script and makes its run script the
+// image's command.  The ENV and LABEL instructions of the stage which ran
+// assemble are repeated, so that the image is described as it would be
+// without a runtime image.
+func appendRuntimeStage(node *parser.Node, runtime *s2iRuntime) error {
+	froms := dockerfile.FindAll(node, dockercmd.From)
+	if len(froms) == 0 {
+		return fmt.Errorf("no FROM instruction in the generated Dockerfile")
+	}
+	image, alias := getLastFrom(node)
+	if len(alias) == 0 {
+		alias = runtimeBuildAlias
+		if err := replaceFrom(node, len(froms)-1, image, alias); err != nil {
+			return err
+		}
+	}
+	var inherited []*parser.Node
+	for _, child := range node.Children[froms[len(froms)-1]+1:] {
+		if child.Value == dockercmd.Env || child.Value == dockercmd.Label {
+			inherited = append(inherited, child)
+		}
+	}
+
+	from, err := dockerfile.From(runtime.Image)
+	if err != nil {
+		return err
+	}
+	if err := dockerfile.InsertInstructions(node, len(node.Children), from); err != nil {
+		return err
+	}
+	node.Children = append(node.Children, inherited...)
+
+	workingDir := runtime.WorkingDir
+	if len(workingDir) == 0 {
+		workingDir = "/"
+	}
+	owner := runtime.User
+	if len(runtime.AssembleUser) > 0 {
+		owner = runtime.AssembleUser
+	}
+	chown := ""
+	if len(owner) > 0 {
+		chown = " --chown=" + owner + ":0"
+	}
+	var instructions []string
+	for _, artifact := range runtime.Artifacts {
+		destination := path.Join(workingDir, artifact.Destination, path.Base(artifact.Source))
+		instructions = append(instructions, fmt.Sprintf("COPY --from=%s%s %s %s", alias, chown, artifact.Source, destination))
+	}
+	if len(runtime.AssembleUser) > 0 {
+		instructions = append(instructions, "USER "+runtime.AssembleUser)
+	}
+	instructions = append(instructions, "RUN "+path.Join(runtime.ScriptsDir, s2iconstants.AssembleRuntime))
+	if len(runtime.AssembleUser) > 0 {
+		user := runtime.User
+		if len(user) == 0 {
+			user = "0"
+		}
+		instructions = append(instructions, "USER "+user)
+	}
+	instructions = append(instructions, "CMD "+path.Join(runtime.ScriptsDir, s2iconstants.Run))
+	return dockerfile.InsertInstructions(node, len(node.Children), strings.Join(instructions, "\n"))
+}
@@ -0,0 +1,193 @@
+package builder
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+	"github.com/openshift/imagebuilder"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+)
+
+func TestGetS2IRuntime(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []corev1.EnvVar
+		labels   map[string]string
+		expected *s2iRuntime
+		wantErr  bool
+	}{
+		{
+			name: "labels",
+			labels: map[string]string{
+				"io.openshift.s2i.assemble-input-files":  "/opt/app-root/app.jar:deployments;/opt/app-root/lib:.",
+				"io.openshift.s2i.scripts-url":           "image:///usr/libexec/s2i",
+				"io.openshift.s2i.assemble-runtime-user": "root",
+			},
+			expected: &s2iRuntime{
+				Image: "runtime:latest",
+				Artifacts: s2iapi.VolumeList{
+					{Source: "/opt/app-root/app.jar", Destination: "deployments"},
+					{Source: "/opt/app-root/lib", Destination: "."},
+				},
+				WorkingDir:   "/opt/app-root",
+				ScriptsDir:   "/usr/libexec/s2i",
+				User:         "1001",
+				AssembleUser: "root",
+			},
+		},
+		{
+			name: "environment",
+			env:  []corev1.EnvVar{{Name: "BUILD_RUNTIME_ARTIFACTS", Value: "/tmp/app:bin"}},
+			labels: map[string]string{
+				"io.openshift.s2i.assemble-input-files": "/opt/app-root/app.jar:deployments",
+				"io.s2i.scripts-url":                    "image:///usr/local/s2i",
+			},
+			expected: &s2iRuntime{
+				Image:      "runtime:latest",
+				Artifacts:  s2iapi.VolumeList{{Source: "/tmp/app", Destination: "bin"}},
+				WorkingDir: "/opt/app-root",
+				ScriptsDir: "/usr/local/s2i",
+				User:       "1001",
+			},
+		},
+		{
+			name:    "no artifacts",
+			labels:  map[string]string{"io.openshift.s2i.scripts-url": "image:///usr/libexec/s2i"},
+			wantErr: true,
+		},
+		{
+			name:    "relative source",
+			env:     []corev1.EnvVar{{Name: "BUILD_RUNTIME_ARTIFACTS", Value: "app.jar:deployments"}},
+			labels:  map[string]string{"io.openshift.s2i.scripts-url": "image:///usr/libexec/s2i"},
+			wantErr: true,
+		},
+		{
+			name:    "absolute destination",
+			env:     []corev1.EnvVar{{Name: "BUILD_RUNTIME_ARTIFACTS", Value: "/tmp/app.jar:/deployments"}},
+			labels:  map[string]string{"io.openshift.s2i.scripts-url": "image:///usr/libexec/s2i"},
+			wantErr: true,
+		},
+		{
+			name:    "no scripts",
+			env:     []corev1.EnvVar{{Name: "BUILD_RUNTIME_ARTIFACTS", Value: "/tmp/app.jar:deployments"}},
+			labels:  map[string]string{"io.openshift.s2i.scripts-url": "https://example.com/s2i"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &FakeDocker{
+				inspectImageFunc: func(name string) (*docker.Image, error) {
+					return &docker.Image{ContainerConfig: docker.Config{User: "1001", WorkingDir: "/opt/app-root", Labels: test.labels}}, nil
+				},
+			}
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{Env: test.env}
+			runtime, err := getS2IRuntime(client, build, "runtime:latest")
+			if test.wantErr != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(runtime, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, runtime)
+			}
+		})
+	}
+}
+
+func TestAppendRuntimeStage(t *testing.T) {
+	original := heredoc.Doc(`
+		FROM builder:latest
+		LABEL "io.openshift.build.name"="app-1"
+		ENV "OPENSHIFT_BUILD_NAME"="app-1"
+		USER root
+		COPY upload/src /tmp/src
+		USER 1001
+		RUN /usr/libexec/s2i/assemble
+		CMD /usr/libexec/s2i/run
+		`)
+	tests := []struct {
+		name    string
+		runtime *s2iRuntime
+		want    string
+	}{
+		{
+			name: "runtime user",
+			runtime: &s2iRuntime{
+				Image:      "runtime:latest",
+				Artifacts:  s2iapi.VolumeList{{Source: "/opt/app-root/app.jar", Destination: "deployments"}},
+				WorkingDir: "/opt/app-root",
+				ScriptsDir: "/usr/libexec/s2i",
+				User:       "1001",
+			},
+			want: heredoc.Doc(`
+				FROM builder:latest as <alias>
+				LABEL "io.openshift.build.name"="app-1"
+				ENV "OPENSHIFT_BUILD_NAME"="app-1"
+				USER root
+				COPY upload/src /tmp/src
+				USER 1001
+				RUN /usr/libexec/s2i/assemble
+				CMD /usr/libexec/s2i/run
+				FROM runtime:latest
+				LABEL "io.openshift.build.name"="app-1"
+				ENV "OPENSHIFT_BUILD_NAME"="app-1"
+				COPY --from=<alias> --chown=1001:0 /opt/app-root/app.jar /opt/app-root/deployments/app.jar
+				RUN /usr/libexec/s2i/assemble-runtime
+				CMD /usr/libexec/s2i/run
+				`),
+		},
+		{
+			name: "assemble runtime user",
+			runtime: &s2iRuntime{
+				Image:        "runtime:latest",
+				Artifacts:    s2iapi.VolumeList{{Source: "/opt/app-root/lib", Destination: "."}},
+				ScriptsDir:   "/usr/libexec/s2i",
+				AssembleUser: "root",
+			},
+			want: heredoc.Doc(`
+				FROM builder:latest as <alias>
+				LABEL "io.openshift.build.name"="app-1"
+				ENV "OPENSHIFT_BUILD_NAME"="app-1"
+				USER root
+				COPY upload/src /tmp/src
+				USER 1001
+				RUN /usr/libexec/s2i/assemble
+				CMD /usr/libexec/s2i/run
+				FROM runtime:latest
+				LABEL "io.openshift.build.name"="app-1"
+				ENV "OPENSHIFT_BUILD_NAME"="app-1"
+				COPY --from=<alias> --chown=root:0 /opt/app-root/lib /lib
+				USER root
+				RUN /usr/libexec/s2i/assemble-runtime
+				USER 0
+				CMD /usr/libexec/s2i/run
+				`),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := imagebuilder.ParseDockerfile(strings.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := appendRuntimeStage(node, test.runtime); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			wantNode, err := imagebuilder.ParseDockerfile(strings.NewReader(strings.Replace(test.want, "<alias>", runtimeBuildAlias, -1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dockerfile.Write(node), dockerfile.Write(wantNode)) {
+				t.Errorf("wanted:\n%s\ngot:\n%s", dockerfile.Write(wantNode), dockerfile.Write(node))
+			}
+		})
+	}
+}
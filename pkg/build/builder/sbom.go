@@ -0,0 +1,394 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/version"
+)
+
+const (
+	// sbomFormatSPDX selects an SPDX 2.3 JSON document.
+	sbomFormatSPDX = "spdx"
+	// sbomFormatCycloneDX selects a CycloneDX 1.4 JSON document.
+	sbomFormatCycloneDX = "cyclonedx"
+
+	spdxMediaType      = "application/spdx+json"
+	cycloneDXMediaType = "application/vnd.cyclonedx+json"
+
+	// sbomArtifactKind is the suffix of the tag of SBOM artifacts.
+	sbomArtifactKind = "sbom"
+
+	// terminationMessagePath is where the build container's termination
+	// message is written.
+	terminationMessagePath = "/dev/termination-log"
+	// terminationMessageLimit is the largest termination message that
+	// Kubernetes keeps.
+	terminationMessageLimit = 4096
+)
+
+// sbomPackage is a package installed in an image.
+type sbomPackage struct {
+	Name    string
+	Version string
+	Arch    string
+	// Type is the package URL type of the package manager which installed
+	// the package, such as rpm
+	Type string
+	// Distro is the ID of the distribution the package belongs to, if known
+	Distro string
+}
+
+// purl returns the package URL of the package.
+func (p sbomPackage) purl() string {
+	purl := "pkg:" + p.Type + "/"
+	if len(p.Distro) > 0 {
+		purl += p.Distro + "/"
+	}
+	purl += p.Name + "@" + p.Version
+	if len(p.Arch) > 0 {
+		purl += "?arch=" + p.Arch
+	}
+	return purl
+}
+
+// getSBOMFormat returns the SBOM format requested for the build, if any.
+func getSBOMFormat(build *buildapiv1.Build) (string, error) {
+	format, _ := buildStrategyEnv(build, builderutil.SBOMFormat)
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "", sbomFormatSPDX, sbomFormatCycloneDX:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid %s value %q, expected %q or %q", builderutil.SBOMFormat, format, sbomFormatSPDX, sbomFormatCycloneDX)
+}
+
+// generateImageSBOM returns an SBOM of the packages installed in the local
+// image name, in the format requested for the build, or nil if none was
+// requested.  If requested, the SBOM is also written to the termination
+// message of the build container.
+func generateImageSBOM(client DockerClient, build *buildapiv1.Build, name string) (*Artifact, error) {
+	format, err := getSBOMFormat(build)
+	if err != nil || len(format) == 0 {
+		return nil, err
+	}
+	mounter, ok := client.(imageMounter)
+	if !ok {
+		return nil, fmt.Errorf("generating an SBOM is not supported by this build client")
+	}
+	log.V(0).Infof("\nGenerating %s SBOM ...", format)
+	root, unmount, err := mounter.MountImage(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to mount %s: %v", name, err)
+	}
+	defer unmount()
+	packages, err := findImagePackages(root)
+	if err != nil {
+		return nil, err
+	}
+	sbom, err := generateSBOM(format, build, packages)
+	if err != nil {
+		return nil, err
+	}
+	log.V(0).Infof("Generated SBOM listing %d packages", len(packages))
+
+	if value, ok := buildStrategyEnv(build, builderutil.SBOMTerminationMessage); ok && len(value) > 0 {
+		write, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.SBOMTerminationMessage, value, err)
+		}
+		if write {
+			writeSBOMTerminationMessage(terminationMessagePath, sbom.Content)
+		}
+	}
+	return sbom, nil
+}
+
+// writeSBOMTerminationMessage writes sbom to the termination message at path,
+// unless it is too large to be kept.
+func writeSBOMTerminationMessage(path string, sbom []byte) {
+	if len(sbom) > terminationMessageLimit {
+		log.V(0).Infof("warning: The SBOM is %d bytes, too large for the termination message", len(sbom))
+		return
+	}
+	if err := ioutil.WriteFile(path, sbom, 0644); err != nil {
+		log.V(0).Infof("warning: Failed to write the SBOM to the termination message: %v", err)
+	}
+}
+
+// attachImageSBOM pushes sbom to the repository of pushTag, referring to the
+// image which was pushed there with the given digest.
+func attachImageSBOM(client DockerClient, pushTag, digest string, sbom *Artifact, auth docker.AuthConfiguration) error {
+	pusher, ok := client.(artifactPusher)
+	if !ok {
+		return fmt.Errorf("pushing an SBOM is not supported by this build client")
+	}
+	tag := artifactTag(digest, sbomArtifactKind)
+	log.V(0).Infof("\nPushing SBOM for %s ...", digest)
+	var sbomDigest string
+	err := retryImageAction("Push", func() (pushErr error) {
+		sbomDigest, pushErr = pusher.PushArtifact(pushTag, digest, tag, *sbom, auth)
+		return pushErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push the SBOM: %v", err)
+	}
+	log.V(0).Infof("Pushed SBOM %s as %s", sbomDigest, tag)
+	return nil
+}
+
+// findImagePackages returns the packages recorded in the rpm, dpkg and apk
+// databases under root.
+func findImagePackages(root string) ([]sbomPackage, error) {
+	distro := readOSReleaseID(root)
+	packages := []sbomPackage{}
+	for _, dbPath := range []string{"var/lib/rpm", "usr/lib/sysimage/rpm"} {
+		if _, err := os.Stat(filepath.Join(root, dbPath)); err != nil {
+			continue
+		}
+		rpms, err := queryRPMDatabase(root, "/"+dbPath)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, rpms...)
+		break
+	}
+	if f, err := os.Open(filepath.Join(root, "var/lib/dpkg/status")); err == nil {
+		debs, err := readDpkgStatus(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, debs...)
+	}
+	if f, err := os.Open(filepath.Join(root, "lib/apk/db/installed")); err == nil {
+		apks, err := readApkInstalled(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, apks...)
+	}
+	for i := range packages {
+		packages[i].Distro = distro
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Version < packages[j].Version
+	})
+	return packages, nil
+}
+
+// readOSReleaseID returns the ID from the os-release file under root.
+func readOSReleaseID(root string) string {
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "ID=") {
+				return strings.Trim(strings.TrimPrefix(line, "ID="), `"'`)
+			}
+		}
+	}
+	return ""
+}
+
+// queryRPMDatabase lists the packages in the rpm database at dbPath under
+// root, using the builder's own rpm.
+func queryRPMDatabase(root, dbPath string) ([]sbomPackage, error) {
+	if _, err := exec.LookPath("rpm"); err != nil {
+		return nil, fmt.Errorf("the image has an rpm database, but rpm is not available to read it")
+	}
+	cmd := exec.Command("rpm", "--root", root, "--dbpath", dbPath, "-qa", "--qf", `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\n`)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the rpm database: %v", err)
+	}
+	packages := []sbomPackage{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		arch := fields[2]
+		if arch == "(none)" {
+			arch = ""
+		}
+		packages = append(packages, sbomPackage{Name: fields[0], Version: fields[1], Arch: arch, Type: "rpm"})
+	}
+	return packages, nil
+}
+
+// readControlStanzas calls fn with the fields of each of the blank line
+// separated stanzas of a dpkg status or apk installed database.  Continuation
+// lines are ignored.
+func readControlStanzas(scanner *bufio.Scanner, separator string, fn func(map[string]string)) error {
+	fields := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			if len(fields) > 0 {
+				fn(fields)
+			}
+			fields = map[string]string{}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		parts := strings.SplitN(line, separator, 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+	return scanner.Err()
+}
+
+// readDpkgStatus lists the installed packages in a dpkg status database.
+func readDpkgStatus(r io.Reader) ([]sbomPackage, error) {
+	packages := []sbomPackage{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	err := readControlStanzas(scanner, ":", func(fields map[string]string) {
+		if !strings.HasSuffix(fields["Status"], " installed") {
+			return
+		}
+		packages = append(packages, sbomPackage{Name: fields["Package"], Version: fields["Version"], Arch: fields["Architecture"], Type: "deb"})
+	})
+	return packages, err
+}
+
+// readApkInstalled lists the packages in an apk installed database.
+func readApkInstalled(r io.Reader) ([]sbomPackage, error) {
+	packages := []sbomPackage{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	err := readControlStanzas(scanner, ":", func(fields map[string]string) {
+		if len(fields["P"]) == 0 {
+			return
+		}
+		packages = append(packages, sbomPackage{Name: fields["P"], Version: fields["V"], Arch: fields["A"], Type: "apk"})
+	})
+	return packages, err
+}
+
+// sbomSubject returns the name of the image described by the build's SBOM.
+func sbomSubject(build *buildapiv1.Build) string {
+	if len(build.Status.OutputDockerImageReference) > 0 {
+		return build.Status.OutputDockerImageReference
+	}
+	return build.Namespace + "/" + build.Name
+}
+
+// generateSBOM returns an SBOM listing packages in the given format.
+func generateSBOM(format string, build *buildapiv1.Build, packages []sbomPackage) (*Artifact, error) {
+	created := time.Now().UTC().Format(time.RFC3339)
+	tool := "openshift-builder-" + version.Get().GitVersion
+	subject := sbomSubject(build)
+
+	var document interface{}
+	var mediaType string
+	switch format {
+	case sbomFormatSPDX:
+		type spdxExternalRef struct {
+			ReferenceCategory string `json:"referenceCategory"`
+			ReferenceType     string `json:"referenceType"`
+			ReferenceLocator  string `json:"referenceLocator"`
+		}
+		type spdxPackage struct {
+			Name             string            `json:"name"`
+			SPDXID           string            `json:"SPDXID"`
+			VersionInfo      string            `json:"versionInfo"`
+			DownloadLocation string            `json:"downloadLocation"`
+			FilesAnalyzed    bool              `json:"filesAnalyzed"`
+			ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+		}
+		spdxPackages := []spdxPackage{}
+		for i, p := range packages {
+			spdxPackages = append(spdxPackages, spdxPackage{
+				Name:             p.Name,
+				SPDXID:           fmt.Sprintf("SPDXRef-Package-%s-%d", p.Type, i),
+				VersionInfo:      p.Version,
+				DownloadLocation: "NOASSERTION",
+				ExternalRefs: []spdxExternalRef{{
+					ReferenceCategory: "PACKAGE-MANAGER",
+					ReferenceType:     "purl",
+					ReferenceLocator:  p.purl(),
+				}},
+			})
+		}
+		document = map[string]interface{}{
+			"spdxVersion":       "SPDX-2.3",
+			"dataLicense":       "CC0-1.0",
+			"SPDXID":            "SPDXRef-DOCUMENT",
+			"name":              subject,
+			"documentNamespace": fmt.Sprintf("https://openshift.io/spdx/%s/%s-%s", build.Namespace, build.Name, build.UID),
+			"creationInfo": map[string]interface{}{
+				"created":  created,
+				"creators": []string{"Tool: " + tool},
+			},
+			"packages": spdxPackages,
+		}
+		mediaType = spdxMediaType
+	case sbomFormatCycloneDX:
+		type cycloneDXComponent struct {
+			Type    string `json:"type"`
+			Name    string `json:"name"`
+			Version string `json:"version,omitempty"`
+			PURL    string `json:"purl,omitempty"`
+		}
+		components := []cycloneDXComponent{}
+		for _, p := range packages {
+			components = append(components, cycloneDXComponent{Type: "library", Name: p.Name, Version: p.Version, PURL: p.purl()})
+		}
+		document = map[string]interface{}{
+			"bomFormat":   "CycloneDX",
+			"specVersion": "1.4",
+			"version":     1,
+			"metadata": map[string]interface{}{
+				"timestamp": created,
+				"tools":     []map[string]string{{"name": tool}},
+				"component": cycloneDXComponent{Type: "container", Name: subject},
+			},
+			"components": components,
+		}
+		mediaType = cycloneDXMediaType
+	default:
+		return nil, fmt.Errorf("unknown SBOM format %q", format)
+	}
+
+	content := &bytes.Buffer{}
+	encoder := json.NewEncoder(content)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return &Artifact{
+		MediaType: mediaType,
+		Content:   content.Bytes(),
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": created,
+		},
+	}, nil
+}
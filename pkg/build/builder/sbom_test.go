@@ -0,0 +1,206 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+const testDpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.31-13
+Description: GNU C Library
+ continuation: not a field
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.1-2
+`
+
+const testApkInstalled = `C:Q1abc=
+P:musl
+V:1.2.2-r7
+A:x86_64
+
+P:busybox
+V:1.33.1-r6
+A:x86_64
+`
+
+// fakeMountingClient is a DockerClient which mounts every image at root.
+type fakeMountingClient struct {
+	*FakeDocker
+	root    string
+	mounted []string
+}
+
+func (c *fakeMountingClient) MountImage(name string) (string, func(), error) {
+	c.mounted = append(c.mounted, name)
+	return c.root, func() {}, nil
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestFindImagePackages(t *testing.T) {
+	root, err := ioutil.TempDir("", "sbom-root")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, filepath.Join(root, "etc/os-release"), "NAME=\"Debian GNU/Linux\"\nID=debian\n")
+	writeTestFile(t, filepath.Join(root, "var/lib/dpkg/status"), testDpkgStatus)
+	writeTestFile(t, filepath.Join(root, "lib/apk/db/installed"), testApkInstalled)
+
+	packages, err := findImagePackages(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []sbomPackage{
+		{Name: "bash", Version: "5.1-2", Arch: "amd64", Type: "deb", Distro: "debian"},
+		{Name: "busybox", Version: "1.33.1-r6", Arch: "x86_64", Type: "apk", Distro: "debian"},
+		{Name: "libc6", Version: "2.31-13", Arch: "amd64", Type: "deb", Distro: "debian"},
+		{Name: "musl", Version: "1.2.2-r7", Arch: "x86_64", Type: "apk", Distro: "debian"},
+	}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("expected packages %#v, got %#v", expected, packages)
+	}
+	if purl := packages[0].purl(); purl != "pkg:deb/debian/bash@5.1-2?arch=amd64" {
+		t.Errorf("unexpected purl %s", purl)
+	}
+}
+
+func TestGenerateSBOM(t *testing.T) {
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", UID: "uid"}}
+	build.Status.OutputDockerImageReference = "registry.example.com/ns/app:latest"
+	packages := []sbomPackage{{Name: "bash", Version: "5.1-2", Arch: "amd64", Type: "deb"}}
+
+	sbom, err := generateSBOM(sbomFormatSPDX, build, packages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spdx := struct {
+		SPDXVersion       string `json:"spdxVersion"`
+		Name              string `json:"name"`
+		DocumentNamespace string `json:"documentNamespace"`
+		Packages          []struct {
+			Name         string `json:"name"`
+			ExternalRefs []struct {
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}{}
+	if err := json.Unmarshal(sbom.Content, &spdx); err != nil {
+		t.Fatalf("SPDX document is not valid JSON: %v", err)
+	}
+	if sbom.MediaType != spdxMediaType || spdx.SPDXVersion != "SPDX-2.3" || spdx.Name != build.Status.OutputDockerImageReference {
+		t.Errorf("unexpected SPDX document %s", string(sbom.Content))
+	}
+	if spdx.DocumentNamespace != "https://openshift.io/spdx/ns/app-1-uid" {
+		t.Errorf("unexpected document namespace %s", spdx.DocumentNamespace)
+	}
+	if len(spdx.Packages) != 1 || len(spdx.Packages[0].ExternalRefs) != 1 || spdx.Packages[0].ExternalRefs[0].ReferenceLocator != "pkg:deb/bash@5.1-2?arch=amd64" {
+		t.Errorf("unexpected SPDX packages %s", string(sbom.Content))
+	}
+
+	sbom, err = generateSBOM(sbomFormatCycloneDX, build, packages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cyclonedx := struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			Name string `json:"name"`
+			PURL string `json:"purl"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(sbom.Content, &cyclonedx); err != nil {
+		t.Fatalf("CycloneDX document is not valid JSON: %v", err)
+	}
+	if sbom.MediaType != cycloneDXMediaType || cyclonedx.BOMFormat != "CycloneDX" || len(cyclonedx.Components) != 1 || cyclonedx.Components[0].PURL != "pkg:deb/bash@5.1-2?arch=amd64" {
+		t.Errorf("unexpected CycloneDX document %s", string(sbom.Content))
+	}
+
+	if _, err := generateSBOM("unknown", build, packages); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestGenerateImageSBOM(t *testing.T) {
+	root, err := ioutil.TempDir("", "sbom-root")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, filepath.Join(root, "var/lib/dpkg/status"), testDpkgStatus)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	client := &fakeMountingClient{FakeDocker: NewFakeDockerClient(), root: root}
+
+	sbom, err := generateImageSBOM(client, build, "image")
+	if err != nil || sbom != nil || len(client.mounted) != 0 {
+		t.Errorf("expected no SBOM without a format, got %v, %v", sbom, err)
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_SBOM_FORMAT", Value: "SPDX"}}
+	sbom, err = generateImageSBOM(client, build, "image")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sbom == nil || !strings.Contains(string(sbom.Content), "pkg:deb/libc6@2.31-13?arch=amd64") {
+		t.Errorf("expected an SPDX document listing libc6, got %v", sbom)
+	}
+	if !reflect.DeepEqual(client.mounted, []string{"image"}) {
+		t.Errorf("expected image to be mounted, got %v", client.mounted)
+	}
+
+	if _, err := generateImageSBOM(NewFakeDockerClient(), build, "image"); err == nil {
+		t.Errorf("expected an error from a client which cannot mount images")
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_SBOM_FORMAT", Value: "swid"}}
+	if _, err := generateImageSBOM(client, build, "image"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestWriteSBOMTerminationMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termination")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "termination-log")
+
+	writeSBOMTerminationMessage(path, make([]byte, terminationMessageLimit+1))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected an SBOM too large for the termination message not to be written")
+	}
+	writeSBOMTerminationMessage(path, []byte("{}"))
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "{}" {
+		t.Errorf("expected the SBOM to be written to the termination message, got %q, %v", string(data), err)
+	}
+}
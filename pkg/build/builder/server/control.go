@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	builderapiv1 "github.com/openshift/builder/pkg/build/builder/api/v1"
+)
+
+// maxFinishedBuilds is how many finished builds started through the control
+// API are remembered, with their output.
+const maxFinishedBuilds = 50
+
+// build is a build started through the control API.  It collects the output
+// of its command.
+type build struct {
+	sync.Mutex
+	status    builderapiv1.BuildStatus
+	output    []byte
+	cancel    context.CancelFunc
+	cancelled bool
+	// changed is closed, and replaced, whenever the build's output or
+	// status changes
+	changed chan struct{}
+}
+
+func (b *build) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.output = append(b.output, p...)
+	b.notify()
+	return len(p), nil
+}
+
+// notify wakes those waiting for the build to change.  The build must be
+// locked.
+func (b *build) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// started records that the build's command has started.
+func (b *build) started() {
+	b.Lock()
+	defer b.Unlock()
+	b.status.Phase = builderapiv1.PhaseRunning
+	b.status.StartTime = time.Now().UTC().Format(time.RFC3339)
+	b.notify()
+}
+
+// finish records that the build's command exited with code, or was
+// cancelled.
+func (b *build) finish(code int) {
+	b.Lock()
+	defer b.Unlock()
+	switch {
+	case b.cancelled:
+		b.status.Phase = builderapiv1.PhaseCancelled
+	case code == 0:
+		b.status.Phase = builderapiv1.PhaseSucceeded
+	default:
+		b.status.Phase = builderapiv1.PhaseFailed
+	}
+	b.status.ExitCode = int32(code)
+	b.status.CompletionTime = time.Now().UTC().Format(time.RFC3339)
+	b.notify()
+}
+
+// stop cancels the build.
+func (b *build) stop() {
+	b.Lock()
+	defer b.Unlock()
+	if !b.status.Phase.Finished() {
+		b.cancelled = true
+	}
+	b.cancel()
+}
+
+// snapshot returns the build's status.
+func (b *build) snapshot() builderapiv1.BuildStatus {
+	b.Lock()
+	defer b.Unlock()
+	return b.status
+}
+
+// outputSince returns the build's output after the first offset bytes,
+// whether the build has finished, and a channel which is closed when the
+// build changes.
+func (b *build) outputSince(offset int) ([]byte, bool, <-chan struct{}) {
+	b.Lock()
+	defer b.Unlock()
+	// output is only ever appended to, so what is returned stays as it is
+	return b.output[offset:], b.status.Phase.Finished(), b.changed
+}
+
+// serveControl serves the methods of the Builder service of builder.proto.
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == builderapiv1.BuildsPath {
+		if r.Method != http.MethodPost {
+			http.Error(w, "builds must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.startBuild(w, r)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, builderapiv1.BuildsPath+"/")
+	method, suffix := http.MethodGet, ""
+	for _, m := range []struct{ method, suffix string }{{http.MethodGet, builderapiv1.LogsSuffix}, {http.MethodPost, builderapiv1.CancelSuffix}} {
+		if strings.HasSuffix(id, m.suffix) {
+			id, method, suffix = strings.TrimSuffix(id, m.suffix), m.method, m.suffix
+			break
+		}
+	}
+	s.buildsLock.Lock()
+	b := s.builds[id]
+	s.buildsLock.Unlock()
+	if b == nil {
+		http.Error(w, fmt.Sprintf("no build %q", id), http.StatusNotFound)
+		return
+	}
+	if r.Method != method {
+		http.Error(w, fmt.Sprintf("%s requests are not accepted at %s", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+		return
+	}
+	switch suffix {
+	case builderapiv1.LogsSuffix:
+		streamOutput(w, r, b)
+	case builderapiv1.CancelSuffix:
+		b.stop()
+		writeJSON(w, b.snapshot())
+	default:
+		writeJSON(w, b.snapshot())
+	}
+}
+
+// startBuild queues the build requested by the body of r.
+func (s *Server) startBuild(w http.ResponseWriter, r *http.Request) {
+	var req builderapiv1.StartBuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid build request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !Commands[req.Command] {
+		http.Error(w, fmt.Sprintf("unknown command %q", req.Command), http.StatusBadRequest)
+		return
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, fmt.Sprintf("unable to name the build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &build{
+		status:  builderapiv1.BuildStatus{ID: hex.EncodeToString(id), Command: req.Command, Phase: builderapiv1.PhaseQueued},
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+	s.buildsLock.Lock()
+	if s.builds == nil {
+		s.builds = map[string]*build{}
+	}
+	s.builds[b.status.ID] = b
+	s.buildsLock.Unlock()
+
+	go func() {
+		defer cancel()
+		code := s.run(ctx, Request{Command: req.Command, Args: req.Args, Env: req.Env}, b, b.started)
+		b.finish(code)
+		s.retire(b.status.ID)
+	}()
+	writeJSON(w, b.snapshot())
+}
+
+// retire forgets the oldest finished builds once there are more than
+// maxFinishedBuilds of them, after the build id finishes.
+func (s *Server) retire(id string) {
+	s.buildsLock.Lock()
+	defer s.buildsLock.Unlock()
+	s.finished = append(s.finished, id)
+	for len(s.finished) > maxFinishedBuilds {
+		delete(s.builds, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// streamOutput writes the output of b to w as it is written, until b
+// finishes or the client goes away.
+func streamOutput(w http.ResponseWriter, r *http.Request, b *build) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	for {
+		output, finished, changed := b.outputSince(offset)
+		if len(output) > 0 {
+			if _, err := w.Write(output); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			offset += len(output)
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeJSON writes v to w as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.V(4).Infof("Unable to write a response: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	builderapiv1 "github.com/openshift/builder/pkg/build/builder/api/v1"
+)
+
+// controlClient returns a client of the control API served on socket.
+func controlClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// call makes a request of the control API, decoding a JSON response into
+// status, and returns the body of any other response.
+func call(t *testing.T, client *http.Client, method, path string, body interface{}, status *builderapiv1.BuildStatus) string {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, "http://builder"+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: unexpected error: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: unexpected error: %v", method, path, err)
+	}
+	if status != nil {
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: unexpected response %s: %s", method, path, resp.Status, out)
+		}
+		if err := json.Unmarshal(out, status); err != nil {
+			t.Fatalf("%s %s: unexpected response %q: %v", method, path, out, err)
+		}
+	}
+	return string(out)
+}
+
+func TestControlAPI(t *testing.T) {
+	socket, stop := startServer(t)
+	defer stop()
+	client := controlClient(socket)
+
+	var slow, queued, status builderapiv1.BuildStatus
+	call(t, client, http.MethodPost, "/v1/builds", builderapiv1.StartBuildRequest{
+		Command: "openshift-docker-build",
+		Args:    []string{"-test.run=TestHelperProcess"},
+		Env:     []string{"BUILD=slow", "BUILD_SERVER_TEST_SLEEP=1"},
+	}, &slow)
+	call(t, client, http.MethodPost, "/v1/builds", builderapiv1.StartBuildRequest{
+		Command: "openshift-git-clone",
+		Args:    []string{"-test.run=TestHelperProcess"},
+		Env:     []string{"BUILD=queued", "BUILD_SERVER_TEST_EXIT=2"},
+	}, &queued)
+	if len(slow.ID) == 0 || slow.ID == queued.ID || queued.Phase != builderapiv1.PhaseQueued {
+		t.Fatalf("unexpected builds %#v and %#v", slow, queued)
+	}
+
+	// the slow build's output streams as it runs, while the queued build
+	// waits for it
+	resp, err := client.Get("http://builder/v1/builds/" + slow.ID + "/logs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, "BUILD=slow") {
+		t.Fatalf("unexpected output %q: %v", line, err)
+	}
+	if call(t, client, http.MethodGet, "/v1/builds/"+slow.ID, nil, &status); status.Phase != builderapiv1.PhaseRunning || len(status.StartTime) == 0 {
+		t.Errorf("expected the first build to be running, got %#v", status)
+	}
+	if call(t, client, http.MethodGet, "/v1/builds/"+queued.ID, nil, &status); status.Phase != builderapiv1.PhaseQueued {
+		t.Errorf("expected the second build to wait for the first, got %#v", status)
+	}
+
+	// cancelling the slow build ends its output
+	call(t, client, http.MethodPost, "/v1/builds/"+slow.ID+"/cancel", nil, &status)
+	done := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the output of a cancelled build to end")
+	}
+	if call(t, client, http.MethodGet, "/v1/builds/"+slow.ID, nil, &status); status.Phase != builderapiv1.PhaseCancelled || len(status.CompletionTime) == 0 {
+		t.Errorf("expected the first build to be cancelled, got %#v", status)
+	}
+
+	// following the logs of the queued build waits for it to finish
+	if logs := call(t, client, http.MethodGet, "/v1/builds/"+queued.ID+"/logs", nil, nil); !strings.Contains(logs, "running as openshift-git-clone with BUILD=queued") {
+		t.Errorf("unexpected output %q", logs)
+	}
+	if call(t, client, http.MethodGet, "/v1/builds/"+queued.ID, nil, &status); status.Phase != builderapiv1.PhaseFailed || status.ExitCode != 2 {
+		t.Errorf("expected the second build to fail with exit code 2, got %#v", status)
+	}
+
+	if out := call(t, client, http.MethodGet, "/v1/builds/unknown", nil, nil); !strings.Contains(out, "no build") {
+		t.Errorf("expected an unknown build not to be found, got %q", out)
+	}
+	if out := call(t, client, http.MethodGet, "/v1/builds/"+queued.ID+"/cancel", nil, nil); !strings.Contains(out, "not accepted") {
+		t.Errorf("expected cancel to need a POST, got %q", out)
+	}
+}
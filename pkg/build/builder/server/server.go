@@ -0,0 +1,574 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	builderapiv1 "github.com/openshift/builder/pkg/build/builder/api/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+var log = utillog.ToFile(os.Stderr, 2)
+
+const (
+	// buildsPath is the path at which the server accepts builds.
+	buildsPath = "/builds"
+	// exitCodeTrailer is the HTTP trailer holding the exit code of the
+	// command run for a request.
+	exitCodeTrailer = "X-Build-Exit-Code"
+)
+
+// maxStepGap is how long the server waits for the next step of a build
+// before giving up on it, as when its pod is deleted between steps.
+var maxStepGap = 10 * time.Minute
+
+// Commands are the builder commands which the server runs for its clients.
+var Commands = map[string]bool{
+	"openshift-sti-build":             true,
+	"openshift-docker-build":          true,
+	"openshift-git-clone":             true,
+	"openshift-manage-dockerfile":     true,
+	"openshift-extract-image-content": true,
+}
+
+// lastSteps are the commands which are the last steps of the builds which
+// run them.
+var lastSteps = map[string]bool{
+	"openshift-sti-build":    true,
+	"openshift-docker-build": true,
+}
+
+// buildUID matches the UIDs of builds.
+var buildUID = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// ForwardedDirs are the directories of the build pod which hold the build's
+// secrets and configmaps, such as its source, push and pull secrets, its
+// inputs and its CAs.  The client sends the files under them with its
+// request, and the command sees them at the same paths, in place of those of
+// the server's own container.
+var ForwardedDirs = []string{
+	"/var/run/secrets/openshift.io",
+	"/var/run/configs/openshift.io",
+	"/var/run/secrets/kubernetes.io/serviceaccount",
+}
+
+// serverOnlyEnv are the environment variables which configure the builder of
+// the node, its storage and how it isolates builds.  The commands the server
+// runs take them from the server's environment alone, never from a client's.
+var serverOnlyEnv = map[string]bool{
+	builderutil.AllowedUIDs:           true,
+	builderutil.DropCapabilities:      true,
+	builderutil.BuildCacheDir:         true,
+	builderutil.StorageDriver:         true,
+	builderutil.BuildWorkDir:          true,
+	builderutil.Rootless:              true,
+	builderutil.AdditionalImageStores: true,
+	builderutil.Isolation:             true,
+	"BUILD_STORAGE_CONF_PATH":         true,
+	"BUILD_BLOBCACHE_DIR":             true,
+	stepMountsEnv:                     true,
+	"PATH":                            true,
+	"LD_PRELOAD":                      true,
+	"LD_LIBRARY_PATH":                 true,
+}
+
+// Request asks the server to run one of the builder's commands.
+type Request struct {
+	// Command is the name of the command, one of Commands.
+	Command string `json:"command"`
+	// Args are the command's arguments, such as --loglevel.
+	Args []string `json:"args,omitempty"`
+	// Env is the environment of the command, such as the BUILD it is to
+	// run, which is added to the server's own.
+	Env []string `json:"env,omitempty"`
+	// Files are the contents, by path, of the files under the ForwardedDirs
+	// of the client's container.
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// Server runs the builder commands which the build pods of its node request,
+// one at a time, each in a process of its own so that no state is left over
+// from one build to the next.  The steps of a build, from its first to its
+// last, run before those of any other, in a work directory of the build's
+// own, with the secrets and configmaps which its pod forwards mounted where
+// the builder expects them.  The commands share the server's storage, so
+// images pulled for one build are there for the next, and its configuration,
+// such as its storage settings.  Only processes of the server's own user may
+// connect to it.  Besides running a build for as long as a client waits for
+// it, the server serves the control API of builder.proto, which starts builds
+// and reports on them.
+type Server struct {
+	// Executable is the builder binary which runs the commands.
+	Executable string
+	// Env is the environment which every command runs with, unless a
+	// request overrides it.
+	Env []string
+	// WorkDir holds the work directory of each build, named by its ID,
+	// which its steps use in place of /tmp.
+	WorkDir string
+	// ForwardFiles runs each command with the files which its client
+	// forwards mounted over ForwardedDirs, in place of the server's own.
+	// Otherwise the files are ignored.
+	ForwardFiles bool
+
+	// lock guards owner, the build whose steps the server runs until its
+	// last step, running, whether one of its steps is running, idle, which
+	// gives up on it if its next step does not come, and changed, which is
+	// closed, and replaced, when a step finishes
+	lock    sync.Mutex
+	owner   string
+	running bool
+	idle    *time.Timer
+	changed chan struct{}
+
+	// steps counts the steps run which are not those of builds with UIDs
+	steps int64
+
+	// builds are those started through the control API, and finished the
+	// IDs of those which have finished, oldest first
+	buildsLock sync.Mutex
+	builds     map[string]*build
+	finished   []string
+}
+
+// Listen listens on the unix socket at path, replacing any left behind by
+// a server which did not stop cleanly.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve accepts builds on listener, from processes of the server's own user,
+// until it is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(buildsPath, s)
+	mux.HandleFunc(builderapiv1.BuildsPath, s.serveControl)
+	mux.HandleFunc(builderapiv1.BuildsPath+"/", s.serveControl)
+	return http.Serve(&peerListener{Listener: listener, uid: uint32(os.Getuid())}, mux)
+}
+
+// peerListener accepts only the connections of processes of the user uid,
+// as the kernel reports them, so that clients cannot claim to be anyone
+// else.
+type peerListener struct {
+	net.Listener
+	uid uint32
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err == nil && uid == l.uid {
+			return conn, nil
+		}
+		if err != nil {
+			log.V(0).Infof("Refused a connection: %v", err)
+		} else {
+			log.V(0).Infof("Refused a connection from user %d", uid)
+		}
+		conn.Close()
+	}
+}
+
+// ServeHTTP runs the command requested by the body of a POST, streaming its
+// output in the response, and sets the exitCodeTrailer to its exit code.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "builds must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid build request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !Commands[req.Command] {
+		http.Error(w, fmt.Sprintf("unknown command %q", req.Command), http.StatusBadRequest)
+		return
+	}
+	if err := checkFiles(req.Files); err != nil {
+		http.Error(w, fmt.Sprintf("invalid build request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Trailer", exitCodeTrailer)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	out := &flushWriter{w: w}
+	if flusher, ok := w.(http.Flusher); ok {
+		out.flusher = flusher
+	}
+	code := s.run(r.Context(), req, out, func() {})
+	w.Header().Set(exitCodeTrailer, strconv.Itoa(code))
+}
+
+// checkFiles returns an error unless files are all under ForwardedDirs.
+func checkFiles(files map[string][]byte) error {
+	for path := range files {
+		if forwardedDir(path) == "" {
+			return fmt.Errorf("the file %q is not under a forwarded directory", path)
+		}
+	}
+	return nil
+}
+
+// forwardedDir returns the one of ForwardedDirs which path, a clean absolute
+// path, is under, or "".
+func forwardedDir(path string) string {
+	if path != filepath.Clean(path) || !filepath.IsAbs(path) {
+		return ""
+	}
+	for _, dir := range ForwardedDirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return dir
+		}
+	}
+	return ""
+}
+
+// ReadForwardedFiles returns the contents of the files under ForwardedDirs,
+// by path, for a Request.  The hidden data directories of mounted secrets and
+// configmaps are skipped, as their keys are read through the links to them.
+func ReadForwardedFiles() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, dir := range ForwardedDirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if strings.HasPrefix(info.Name(), "..") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if target, err := os.Stat(path); err != nil || !target.Mode().IsRegular() {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[path] = data
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// writeFiles writes files, as ReadForwardedFiles returns them, under dir.
+func writeFiles(dir string, files map[string][]byte) error {
+	for path, data := range files {
+		file := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs the command requested by req once the steps of other builds, and
+// the step of its own build before it, have finished, calling started when
+// it starts, copying its output to out, and returns its exit code.  The
+// command is asked to stop, as the builder is when its pod is deleted, if ctx
+// is cancelled, such as when the client goes away.
+func (s *Server) run(ctx context.Context, req Request, out io.Writer, started func()) int {
+	id, steps := s.buildID(req.Env)
+	if !s.acquire(ctx, id) {
+		return 1
+	}
+	code := 1
+	defer func() {
+		s.release(id, !steps || lastSteps[req.Command] || code != 0)
+	}()
+	if ctx.Err() != nil {
+		return code
+	}
+	started()
+
+	var env []string
+	dir := ""
+	if len(s.WorkDir) > 0 {
+		dir = filepath.Join(s.WorkDir, id)
+		if err := os.MkdirAll(filepath.Join(dir, "build"), 0700); err != nil {
+			fmt.Fprintf(out, "error: unable to create the build's work directory: %v\n", err)
+			return code
+		}
+		env = append(env, builderutil.BuildWorkDir+"="+dir)
+	}
+	// the files of the step's container are removed once it finishes, as
+	// those of the build's next step may differ
+	mounts := ""
+	if s.ForwardFiles {
+		var err error
+		if mounts, err = ioutil.TempDir(dir, "mounts"); err != nil {
+			fmt.Fprintf(out, "error: unable to create the build's work directory: %v\n", err)
+			return code
+		}
+		defer os.RemoveAll(mounts)
+		if err := writeFiles(mounts, req.Files); err != nil {
+			fmt.Fprintf(out, "error: unable to write the files of the build's pod: %v\n", err)
+			return code
+		}
+		env = append(env, stepMountsEnv+"="+mounts)
+	}
+	code = s.runCommand(ctx, req, mergeEnv(req.Env, append(env, s.Env...)), mounts, out)
+	return code
+}
+
+// buildID returns the ID of the build which env, that of one of its steps,
+// runs, and whether it has other steps, which share the ID.  The ID of a
+// build is its UID, or, if it has none, a new ID of its single step.
+func (s *Server) buildID(env []string) (string, bool) {
+	var build struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(envValue(env, "BUILD")), &build); err == nil && buildUID.MatchString(build.Metadata.UID) {
+		return build.Metadata.UID, true
+	}
+	return fmt.Sprintf("step-%d", atomic.AddInt64(&s.steps, 1)), false
+}
+
+// acquire waits until the server is running the steps of no build other
+// than id, and none of its, and makes id the build whose steps it runs.  It
+// returns false if ctx is cancelled first.
+func (s *Server) acquire(ctx context.Context, id string) bool {
+	for {
+		s.lock.Lock()
+		if (len(s.owner) == 0 || s.owner == id) && !s.running {
+			s.owner, s.running = id, true
+			if s.idle != nil {
+				s.idle.Stop()
+				s.idle = nil
+			}
+			s.lock.Unlock()
+			return true
+		}
+		if s.changed == nil {
+			s.changed = make(chan struct{})
+		}
+		changed := s.changed
+		s.lock.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release records that a step of the build id has finished.  If it was the
+// last, or if the next does not come within maxStepGap, the build's work
+// directory is removed and the server runs the steps of other builds.
+func (s *Server) release(id string, last bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running = false
+	if last {
+		s.finish(id)
+		return
+	}
+	s.idle = time.AfterFunc(maxStepGap, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.owner == id && !s.running {
+			log.V(0).Infof("Gave up waiting for the next step of build %s", id)
+			s.finish(id)
+		}
+	})
+	s.notify()
+}
+
+// finish forgets the build id, whose steps have finished, and removes its
+// work directory.  The server must be locked.
+func (s *Server) finish(id string) {
+	s.owner, s.idle = "", nil
+	if len(s.WorkDir) > 0 {
+		if err := os.RemoveAll(filepath.Join(s.WorkDir, id)); err != nil {
+			log.V(0).Infof("Unable to remove the work directory of build %s: %v", id, err)
+		}
+	}
+	s.notify()
+}
+
+// notify wakes those waiting for a step to finish.  The server must be
+// locked.
+func (s *Server) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// runCommand runs the command requested by req with env, and with the files
+// forwarded by its client in mounts, unless it is empty, copying its output
+// to out, and returns its exit code.
+func (s *Server) runCommand(ctx context.Context, req Request, env []string, mounts string, out io.Writer) int {
+	cmd := exec.Command(s.Executable, req.Args...)
+	// the builder chooses the command it runs by the name it is run as
+	cmd.Args[0] = req.Command
+	if len(mounts) > 0 {
+		cmd = stepCommand(s.Executable, req)
+	}
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = out, out
+	log.V(0).Infof("Running %s", req.Command)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(out, "error: unable to run %s: %v\n", req.Command, err)
+		return 1
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(syscall.SIGTERM)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(out, "error: %s failed: %v\n", req.Command, err)
+		return 1
+	}
+	return 0
+}
+
+// envValue returns the value of the variable name in env.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			value = strings.TrimPrefix(kv, name+"=")
+		}
+	}
+	return value
+}
+
+// mergeEnv returns the environment of a command run with the variables in
+// env for a client with those in client: the first value of each variable in
+// env, with the client's variables added, except those which env sets and
+// those in serverOnlyEnv.  The
+// server's socket is left out, so that the commands it runs build for
+// themselves rather than handing their builds back to it.
+func mergeEnv(client, env []string) []string {
+	merged := []string{}
+	index := map[string]int{}
+	for i, list := range [][]string{env, client} {
+		for _, kv := range list {
+			name := strings.SplitN(kv, "=", 2)[0]
+			if name == builderutil.BuildServerSocket {
+				continue
+			}
+			if _, ok := index[name]; ok || (i == 1 && serverOnlyEnv[name]) {
+				continue
+			}
+			index[name] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// flushWriter flushes each write to the client, so that a build's output
+// reaches it as the build runs.
+type flushWriter struct {
+	lock    sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.w.Write(b)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// Submit asks the server listening on the unix socket at path to run req,
+// copies the command's output to out as it runs, and returns its exit code.
+func Submit(path string, req Request, out io.Writer) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Post("http://builder"+buildsPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return 0, fmt.Errorf("the build server at %s refused the build: %s: %s", path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return 0, fmt.Errorf("lost the connection to the build server at %s: %v", path, err)
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get(exitCodeTrailer))
+	if err != nil {
+		return 0, fmt.Errorf("the build server at %s did not report how %s exited", path, req.Command)
+	}
+	return code, nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess stands in for the builder in the commands run by the
+// server in tests.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("BUILD_SERVER_TEST_HELPER") != "1" {
+		return
+	}
+	fmt.Printf("running as %s with BUILD=%s\n", filepath.Base(os.Args[0]), os.Getenv("BUILD"))
+	fmt.Fprintf(os.Stderr, "socket=%q\n", os.Getenv("BUILD_SERVER_SOCKET"))
+	if dir := os.Getenv("BUILD_WORK_DIR"); len(dir) > 0 {
+		if _, err := os.Stat(filepath.Join(dir, "build")); err != nil {
+			fmt.Printf("no work directory: %v\n", err)
+		}
+		fmt.Printf("work dir=%s\n", filepath.Base(dir))
+	}
+	if os.Getenv("BUILD_SERVER_TEST_SLEEP") == "1" {
+		time.Sleep(30 * time.Second)
+	}
+	code, _ := strconv.Atoi(os.Getenv("BUILD_SERVER_TEST_EXIT"))
+	os.Exit(code)
+}
+
+func TestMergeEnv(t *testing.T) {
+	merged := mergeEnv(
+		[]string{"BUILD={}", "BUILD_LOG_FORMAT=json", "BUILD_SERVER_SOCKET=/run/builder.sock", "PATH=/tmp", "BUILD_STORAGE_DRIVER=vfs", "BUILD_ISOLATION=chroot"},
+		[]string{"PATH=/usr/bin", "BUILD_SERVER_SOCKET=/run/builder.sock", "BUILD_LOG_FORMAT=text", "BUILD_STORAGE_DRIVER=overlay"},
+	)
+	if expected := []string{"PATH=/usr/bin", "BUILD_LOG_FORMAT=text", "BUILD_STORAGE_DRIVER=overlay", "BUILD={}"}; !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+}
+
+// startServer starts a server on a new socket, whose commands run
+// TestHelperProcess, and returns the socket and a function which stops it.
+func startServer(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "builder.sock")
+	listener, err := Listen(socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{Executable: os.Args[0], Env: []string{"BUILD_SERVER_TEST_HELPER=1", "BUILD_SERVER_SOCKET=" + socket}}
+	go s.Serve(listener)
+	return socket, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	socket, stop := startServer(t)
+	defer stop()
+
+	for _, exit := range []int{0, 3} {
+		out := &bytes.Buffer{}
+		code, err := Submit(socket, Request{
+			Command: "openshift-docker-build",
+			Args:    []string{"-test.run=TestHelperProcess"},
+			Env:     []string{"BUILD={}", fmt.Sprintf("BUILD_SERVER_TEST_EXIT=%d", exit)},
+		}, out)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code != exit {
+			t.Errorf("expected exit code %d, got %d", exit, code)
+		}
+		if !strings.Contains(out.String(), "running as openshift-docker-build with BUILD={}\n") || !strings.Contains(out.String(), "socket=\"\"\n") {
+			t.Errorf("unexpected output %q", out.String())
+		}
+	}
+
+	if _, err := Submit(socket, Request{Command: "rm"}, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected an unknown command to be refused, got %v", err)
+	}
+}
+
+func TestBuildSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Server{Executable: os.Args[0], Env: []string{"BUILD_SERVER_TEST_HELPER=1"}, WorkDir: dir}
+	step := func(command, uid string, out *bytes.Buffer) int {
+		return s.run(context.Background(), Request{
+			Command: command,
+			Args:    []string{"-test.run=TestHelperProcess"},
+			Env:     []string{`BUILD={"metadata":{"uid":"` + uid + `"}}`},
+		}, out, func() {})
+	}
+
+	out := &bytes.Buffer{}
+	if code := step("openshift-git-clone", "a", out); code != 0 || !strings.Contains(out.String(), "work dir=a\n") {
+		t.Fatalf("unexpected exit code %d and output %q", code, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+		t.Errorf("expected the work directory to be kept for the build's next step: %v", err)
+	}
+
+	// the steps of another build wait for the last step of the first
+	done := make(chan int)
+	other := &bytes.Buffer{}
+	go func() { done <- step("openshift-docker-build", "b", other) }()
+	select {
+	case <-done:
+		t.Fatalf("expected the second build to wait for the first, got %q", other.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if code := step("openshift-docker-build", "a", out); code != 0 {
+		t.Fatalf("unexpected exit code %d and output %q", code, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("expected the work directory to be removed after the build's last step: %v", err)
+	}
+	if code := <-done; code != 0 || !strings.Contains(other.String(), "work dir=b\n") {
+		t.Errorf("unexpected exit code %d and output %q", code, other.String())
+	}
+
+	// a build whose next step does not come is given up on
+	defer func(gap time.Duration) { maxStepGap = gap }(maxStepGap)
+	maxStepGap = 10 * time.Millisecond
+	step("openshift-git-clone", "c", out)
+	go func() { done <- step("openshift-docker-build", "d", other) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the server to give up on the first build")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c")); !os.IsNotExist(err) {
+		t.Errorf("expected the work directory of the abandoned build to be removed: %v", err)
+	}
+}
+
+func TestForwardedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dirs []string) { ForwardedDirs = dirs }(ForwardedDirs)
+	secrets := filepath.Join(dir, "secrets")
+	ForwardedDirs = []string{secrets, filepath.Join(dir, "configs")}
+
+	// a secret as the kubelet mounts it, its keys linking to its data
+	data := filepath.Join(secrets, "push", "..2020_01_01")
+	if err := os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(data, ".dockerconfigjson"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..2020_01_01", filepath.Join(secrets, "push", "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..data/.dockerconfigjson", filepath.Join(secrets, "push", ".dockerconfigjson")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ReadForwardedFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(secrets, "push", ".dockerconfigjson")
+	if expected := map[string][]byte{path: []byte("{}")}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+	if err := checkFiles(files); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, path := range []string{"/etc/passwd", secrets, secrets + "/../../etc/passwd", "push/.dockerconfigjson"} {
+		if err := checkFiles(map[string][]byte{path: nil}); err == nil {
+			t.Errorf("expected %q to be refused", path)
+		}
+	}
+
+	mounts := filepath.Join(dir, "mounts")
+	if err := writeFiles(mounts, files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(mounts, path)); err != nil || string(data) != "{}" {
+		t.Errorf("expected the file to be written under the mounts, got %q, %v", string(data), err)
+	}
+}
+
+func TestPeerListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, test := range []struct {
+		uid      uint32
+		accepted bool
+	}{
+		{uid: uint32(os.Getuid()) + 1},
+		{uid: uint32(os.Getuid()), accepted: true},
+	} {
+		socket := filepath.Join(dir, fmt.Sprintf("builder-%d.sock", i))
+		listener, err := Listen(socket)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer listener.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			if conn, err := (&peerListener{Listener: listener, uid: test.uid}).Accept(); err == nil {
+				accepted <- conn
+			}
+		}()
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer conn.Close()
+		if !test.accepted {
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("expected the connection of another user to be closed, got %v", err)
+			}
+			continue
+		}
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(10 * time.Second):
+			t.Errorf("expected the connection of the server's user to be accepted")
+		}
+	}
+}
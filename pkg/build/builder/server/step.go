@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/containers/storage/pkg/reexec"
+)
+
+const (
+	// StepCommand is the name the server runs the builder as to mount the
+	// files forwarded by a client before running the command it requested.
+	StepCommand = "openshift-builder-server-step"
+	// stepMountsEnv names the directory holding the files forwarded by the
+	// client of a step.
+	stepMountsEnv = "BUILD_SERVER_STEP_MOUNTS"
+)
+
+// privateDirs are the directories of the server's container which a step
+// writes to, which it is given copies of.
+var privateDirs = []string{
+	"/etc/pki/tls/certs",
+	"/etc/docker/certs.d",
+}
+
+func init() {
+	reexec.Register(StepCommand, runStep)
+}
+
+// stepCommand returns the command which runs the command requested by req,
+// with executable, the builder, once the files forwarded by its client are
+// mounted over ForwardedDirs in a mount namespace of its own.
+func stepCommand(executable string, req Request) *exec.Cmd {
+	cmd := exec.Command(executable, append([]string{req.Command}, req.Args...)...)
+	cmd.Args[0] = StepCommand
+	cmd.SysProcAttr = stepSysProcAttr()
+	return cmd
+}
+
+// runStep runs as StepCommand, mounting the files forwarded by its client
+// and then replacing itself with the command named by its first argument.
+func runStep() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "error: %s needs the command to run\n", StepCommand)
+		os.Exit(1)
+	}
+	mounts := os.Getenv(stepMountsEnv)
+	if err := mountForwardedDirs(mounts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: unable to mount the files of the build's pod: %v\n", err)
+		os.Exit(1)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: unable to find the builder: %v\n", err)
+		os.Exit(1)
+	}
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, stepMountsEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	err = execCommand(executable, os.Args[1:], env)
+	fmt.Fprintf(os.Stderr, "error: unable to run %s: %v\n", os.Args[1], err)
+	os.Exit(1)
+}
@@ -0,0 +1,100 @@
+// +build linux
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// stepSysProcAttr starts a step in a mount namespace of its own, so that the
+// files of one build's pod are never seen by the steps of another.
+func stepSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+}
+
+// mountForwardedDirs mounts the directories under mounts, read-only, over
+// ForwardedDirs, hiding the server's own secrets and configmaps, which are
+// none of the build's.  A directory which the client did not forward is
+// mounted empty.
+func mountForwardedDirs(mounts string) error {
+	// keep the mounts from propagating back to the server's namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	for _, dir := range ForwardedDirs {
+		source := filepath.Join(mounts, dir)
+		if err := os.MkdirAll(source, 0700); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := unix.Mount(source, dir, "", unix.MS_BIND, ""); err != nil {
+			return err
+		}
+		if err := unix.Mount("", dir, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+			return err
+		}
+	}
+	// the builder adds the CAs of the build to the trust of its container,
+	// which the steps of other builds must not share
+	for _, dir := range privateDirs {
+		private := filepath.Join(mounts, "private", dir)
+		if err := copyTree(dir, private); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := unix.Mount(private, dir, "", unix.MS_BIND, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the files, links and directories under src to dst, if src
+// exists.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == src {
+				return os.MkdirAll(dst, 0755)
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// execCommand replaces the process with executable, run as args[0], which
+// the builder chooses its command by.
+func execCommand(executable string, args, env []string) error {
+	return unix.Exec(executable, args, env)
+}
@@ -0,0 +1,20 @@
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func stepSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func mountForwardedDirs(mounts string) error {
+	return fmt.Errorf("mounting the files of a build's pod is not supported on this platform")
+}
+
+func execCommand(executable string, args, env []string) error {
+	return fmt.Errorf("running build steps is not supported on this platform")
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	ireference "github.com/containers/image/v5/docker/reference"
+	docker "github.com/fsouza/go-dockerclient"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/attestation"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+const (
+	// signatureArtifactKind is the suffix of the tag of cosign signatures.
+	signatureArtifactKind = "sig"
+	// signingKeyFile is the key in the signing secret holding the private
+	// key, as named by cosign.
+	signingKeyFile = "cosign.key"
+
+	simpleSigningMediaType    = "application/vnd.dev.cosign.simplesigning.v1+json"
+	simpleSigningType         = "cosign container image signature"
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// imageSigning is how the images pushed by a build are signed.
+type imageSigning struct {
+	signer attestation.Signer
+	// annotations are included in the signed payload
+	annotations map[string]string
+}
+
+// getImageSigning returns how the build's images are to be signed, or nil
+// if signing was not requested.  The key is read from the build input secret
+// named by the build's ImageSigningSecret.
+func getImageSigning(build *buildapiv1.Build) (*imageSigning, error) {
+	name, _ := buildStrategyEnv(build, builderutil.ImageSigningSecret)
+	if name = strings.TrimSpace(name); len(name) == 0 {
+		return nil, nil
+	}
+	dir, ok := inputSecretDir(build, name)
+	if !ok {
+		return nil, fmt.Errorf("image signing secret %q is not a build input secret", name)
+	}
+	signer, err := attestation.LoadSigner(filepath.Join(dir, signingKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{}
+	value, _ := buildStrategyEnv(build, builderutil.ImageSigningAnnotations)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: must be of the form key=value", builderutil.ImageSigningAnnotations, pair)
+		}
+		annotations[parts[0]] = parts[1]
+	}
+	return &imageSigning{signer: signer, annotations: annotations}, nil
+}
+
+// simpleSigningPayload returns the payload which cosign signs to assert that
+// the image with digest in repository is authentic.
+func simpleSigningPayload(repository, digest string, annotations map[string]string) ([]byte, error) {
+	payload := struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+		Optional map[string]string `json:"optional"`
+	}{}
+	payload.Critical.Identity.DockerReference = repository
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = simpleSigningType
+	if len(annotations) > 0 {
+		payload.Optional = annotations
+	}
+	return json.Marshal(payload)
+}
+
+// signImage signs the image which was pushed to pushTag with the given
+// digest and pushes the signature where cosign looks for it.  The vendored
+// build API has no field for the signature in the build's status output, so
+// its reference is logged.
+func signImage(client DockerClient, signing *imageSigning, pushTag, digest string, auth docker.AuthConfiguration) error {
+	pusher, ok := client.(artifactPusher)
+	if !ok {
+		return fmt.Errorf("pushing image signatures is not supported by this build client")
+	}
+	named, err := ireference.ParseNormalizedNamed(pushTag)
+	if err != nil {
+		return fmt.Errorf("error parsing image name %s: %v", pushTag, err)
+	}
+	repository := ireference.TrimNamed(named).String()
+	payload, err := simpleSigningPayload(repository, digest, signing.annotations)
+	if err != nil {
+		return err
+	}
+	sig, err := signing.signer.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("unable to sign %s: %v", digest, err)
+	}
+	artifact := Artifact{
+		MediaType: simpleSigningMediaType,
+		Content:   payload,
+		LayerAnnotations: map[string]string{
+			cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+		},
+	}
+	tag := artifactTag(digest, signatureArtifactKind)
+	log.V(0).Infof("\nSigning image %s ...", digest)
+	var signatureDigest string
+	err = retryImageAction("Push", func() (pushErr error) {
+		signatureDigest, pushErr = pusher.PushArtifact(pushTag, digest, tag, artifact, auth)
+		return pushErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push the signature: %v", err)
+	}
+	reference := repository + ":" + tag
+	if len(signatureDigest) > 0 {
+		reference = repository + "@" + signatureDigest
+	}
+	log.V(0).Infof("Pushed signature %s for %s@%s", reference, repository, digest)
+	return nil
+}
@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/attestation"
+)
+
+func TestGetImageSigning(t *testing.T) {
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{}
+	signing, err := getImageSigning(build)
+	if signing != nil || err != nil {
+		t.Errorf("expected no signing without a secret, got %v, %v", signing, err)
+	}
+
+	build.Spec.Strategy.DockerStrategy.Env = []corev1.EnvVar{{Name: "BUILD_IMAGE_SIGNING_SECRET", Value: "cosign"}}
+	if _, err := getImageSigning(build); err == nil {
+		t.Errorf("expected an error for a secret which is not a build input secret")
+	}
+
+	build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{{Secret: corev1.LocalObjectReference{Name: "cosign"}}}
+	if _, err := getImageSigning(build); err == nil {
+		t.Errorf("expected an error for a secret which is not mounted")
+	}
+}
+
+func TestSignImage(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	signer, err := attestation.NewSigner(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	signing := &imageSigning{signer: signer, annotations: map[string]string{"build": "app-1"}}
+	client := &fakeArtifactClient{FakeDocker: NewFakeDockerClient()}
+
+	if err := signImage(client, signing, "registry.example.com/ns/app:latest", "sha256:abc", docker.AuthConfiguration{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.name != "registry.example.com/ns/app:latest" || client.subjectDigest != "sha256:abc" || client.tag != "sha256-abc.sig" {
+		t.Errorf("unexpected push of %s for %s as %s", client.name, client.subjectDigest, client.tag)
+	}
+	if client.artifact.MediaType != simpleSigningMediaType {
+		t.Errorf("unexpected media type %s", client.artifact.MediaType)
+	}
+
+	payload := struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+		Optional map[string]string `json:"optional"`
+	}{}
+	if err := json.Unmarshal(client.artifact.Content, &payload); err != nil {
+		t.Fatalf("the payload is not valid JSON: %v", err)
+	}
+	if payload.Critical.Identity.DockerReference != "registry.example.com/ns/app" || payload.Critical.Image.DockerManifestDigest != "sha256:abc" || payload.Critical.Type != simpleSigningType {
+		t.Errorf("unexpected payload %s", string(client.artifact.Content))
+	}
+	if payload.Optional["build"] != "app-1" {
+		t.Errorf("expected the annotations in the payload, got %s", string(client.artifact.Content))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(client.artifact.LayerAnnotations[cosignSignatureAnnotation])
+	if err != nil {
+		t.Fatalf("the signature is not base64 encoded: %v", err)
+	}
+	sum := sha256.Sum256(client.artifact.Content)
+	if !ecdsa.VerifyASN1(&key.PublicKey, sum[:], sig) {
+		t.Errorf("the signature does not verify")
+	}
+
+	if err := signImage(NewFakeDockerClient(), signing, "registry.example.com/ns/app:latest", "sha256:abc", docker.AuthConfiguration{}); err == nil {
+		t.Errorf("expected an error from a client which cannot push artifacts")
+	}
+}
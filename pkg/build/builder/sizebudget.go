@@ -0,0 +1,104 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// imageSizer is implemented by DockerClients which can measure the layers
+// of a local image.
+type imageSizer interface {
+	// ImageSize returns the total size of the layers of the image name,
+	// uncompressed and, if compressed is set, compressed as they would be
+	// pushed.
+	ImageSize(name string, compressed bool) (int64, int64, error)
+}
+
+// imageSizeBudget is the largest size, in bytes, that a build's image may
+// be.  Zero sizes are not limited.
+type imageSizeBudget struct {
+	uncompressed int64
+	compressed   int64
+	// warn logs a warning, rather than failing the build, when the image
+	// is too large
+	warn bool
+}
+
+// getImageSizeBudget returns the size budget of the image built by build,
+// or nil if it has none.
+func getImageSizeBudget(build *buildapiv1.Build) (*imageSizeBudget, error) {
+	budget := &imageSizeBudget{}
+	for name, size := range map[string]*int64{
+		builderutil.ImageSizeBudget:           &budget.uncompressed,
+		builderutil.CompressedImageSizeBudget: &budget.compressed,
+	} {
+		value, _ := buildStrategyEnv(build, name)
+		if value = strings.TrimSpace(value); len(value) == 0 {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid %s value %q: must be a positive quantity such as 500Mi", name, value)
+		}
+		*size = quantity.Value()
+	}
+
+	action, _ := buildStrategyEnv(build, builderutil.ImageSizeBudgetAction)
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "", "fail":
+	case "warn":
+		budget.warn = true
+	default:
+		return nil, fmt.Errorf("invalid %s value %q: must be \"fail\" or \"warn\"", builderutil.ImageSizeBudgetAction, action)
+	}
+
+	if budget.uncompressed == 0 && budget.compressed == 0 {
+		return nil, nil
+	}
+	return budget, nil
+}
+
+// checkImageSizeBudget returns an error if the local image name, built by
+// build, exceeds the size budget of the build, unless the build only warns
+// about it.
+func checkImageSizeBudget(client DockerClient, build *buildapiv1.Build, name string) error {
+	budget, err := getImageSizeBudget(build)
+	if err != nil || budget == nil {
+		return err
+	}
+	sizer, ok := client.(imageSizer)
+	if !ok {
+		return fmt.Errorf("checking the size of the image is not supported by this build client")
+	}
+	uncompressed, compressed, err := sizer.ImageSize(name, budget.compressed > 0)
+	if err != nil {
+		return fmt.Errorf("error measuring image %s: %v", name, err)
+	}
+	if budget.compressed > 0 {
+		log.V(0).Infof("The layers of image %s are %d bytes, or %d bytes compressed", name, uncompressed, compressed)
+	} else {
+		log.V(0).Infof("The layers of image %s are %d bytes", name, uncompressed)
+	}
+
+	var exceeded []string
+	if budget.uncompressed > 0 && uncompressed > budget.uncompressed {
+		exceeded = append(exceeded, fmt.Sprintf("its size of %d bytes exceeds the budget of %d bytes", uncompressed, budget.uncompressed))
+	}
+	if budget.compressed > 0 && compressed > budget.compressed {
+		exceeded = append(exceeded, fmt.Sprintf("its compressed size of %d bytes exceeds the budget of %d bytes", compressed, budget.compressed))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("image %s is too large: %s", name, strings.Join(exceeded, ", and "))
+	if budget.warn {
+		log.V(0).Infof("warning: %s", message)
+		return nil
+	}
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+type fakeSizingDocker struct {
+	FakeDocker
+	uncompressed, compressed int64
+}
+
+func (d *fakeSizingDocker) ImageSize(name string, compressed bool) (int64, int64, error) {
+	if !compressed {
+		return d.uncompressed, 0, nil
+	}
+	return d.uncompressed, d.compressed, nil
+}
+
+func TestCheckImageSizeBudget(t *testing.T) {
+	client := &fakeSizingDocker{uncompressed: 300 * 1024 * 1024, compressed: 100 * 1024 * 1024}
+	tests := []struct {
+		name      string
+		env       []corev1.EnvVar
+		expectErr bool
+	}{
+		{name: "no budget"},
+		{name: "within budget", env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "500Mi"}, {Name: "BUILD_COMPRESSED_IMAGE_SIZE_BUDGET", Value: "100Mi"}}},
+		{name: "too large", env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "200Mi"}}, expectErr: true},
+		{name: "too large compressed", env: []corev1.EnvVar{{Name: "BUILD_COMPRESSED_IMAGE_SIZE_BUDGET", Value: "50M"}}, expectErr: true},
+		{
+			name: "warn",
+			env:  []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "200Mi"}, {Name: "BUILD_IMAGE_SIZE_BUDGET_ACTION", Value: "warn"}},
+		},
+		{name: "invalid budget", env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "big"}}, expectErr: true},
+		{name: "negative budget", env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "-1Mi"}}, expectErr: true},
+		{name: "invalid action", env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "1Gi"}, {Name: "BUILD_IMAGE_SIZE_BUDGET_ACTION", Value: "ignore"}}, expectErr: true},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		if err := checkImageSizeBudget(client, build, "image"); (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_IMAGE_SIZE_BUDGET", Value: "1Gi"}},
+	}
+	if err := checkImageSizeBudget(NewFakeDockerClient(), build, "image"); err == nil {
+		t.Errorf("expected an error for a client which cannot measure images")
+	}
+}
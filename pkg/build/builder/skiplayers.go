@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openshift/imagebuilder"
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+// skipLayersProblems returns the instructions of the Dockerfile node which
+// cannot be built faithfully when its layers are skipped.  When a stage
+// declares a VOLUME, the volume's contents are saved before each later RUN
+// instruction of the stage and restored after it, which in a build that
+// commits the whole stage as one layer loses the owner, and can change the
+// permissions, of what earlier instructions added to the volume.
+func skipLayersProblems(node *parser.Node) []string {
+	var problems []string
+	var volumes []string
+	for _, child := range node.Children {
+		switch child.Value {
+		case dockercmd.From:
+			volumes = nil
+		case dockercmd.Volume:
+			for arg := child.Next; arg != nil; arg = arg.Next {
+				volumes = append(volumes, arg.Value)
+			}
+		case dockercmd.Run:
+			if len(volumes) > 0 {
+				problems = append(problems, fmt.Sprintf("line %d: RUN after VOLUME %s may change the owner and permissions of the volume's contents", child.StartLine, strings.Join(volumes, " ")))
+			}
+		}
+	}
+	return problems
+}
+
+// checkSkipLayers checks that the Dockerfile of the build at dockerfilePath
+// can be built with the image optimization policy of the build's strategy.
+// The SkipLayers policy fails the build when it cannot, and the
+// SkipLayersAndWarn policy logs why and builds the image anyway.
+func checkSkipLayers(build *buildapiv1.Build, dockerfilePath string) error {
+	s := build.Spec.Strategy.DockerStrategy
+	if s == nil || s.ImageOptimizationPolicy == nil || *s.ImageOptimizationPolicy == buildapiv1.ImageOptimizationNone {
+		return nil
+	}
+	policy := *s.ImageOptimizationPolicy
+	node, err := imagebuilder.ParseFile(dockerfilePath)
+	if err != nil {
+		return err
+	}
+	problems := skipLayersProblems(node)
+	if len(problems) == 0 {
+		return nil
+	}
+	if policy == buildapiv1.ImageOptimizationSkipLayersAndWarn {
+		log.V(0).Infof("warning: The Dockerfile is not fully supported by the %s image optimization policy:\n%s", policy, strings.Join(problems, "\n"))
+		return nil
+	}
+	return fmt.Errorf("the Dockerfile is not supported by the %s image optimization policy, use %s or %s instead:\n%s", policy, buildapiv1.ImageOptimizationSkipLayersAndWarn, buildapiv1.ImageOptimizationNone, strings.Join(problems, "\n"))
+}
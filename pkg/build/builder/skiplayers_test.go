@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/openshift/imagebuilder"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestCheckSkipLayers(t *testing.T) {
+	volumeThenRun := heredoc.Doc(`
+		FROM centos:7
+		COPY data /var/lib/app
+		VOLUME ["/var/lib/app"]
+		RUN chown -R 1001 /var/lib/app
+		`)
+	supported := heredoc.Doc(`
+		FROM centos:7 AS builder
+		VOLUME /cache
+		FROM centos:7
+		RUN yum install -y httpd
+		VOLUME /var/www /var/log/httpd
+		CMD ["httpd"]
+		`)
+	none := buildapiv1.ImageOptimizationNone
+	skipLayers := buildapiv1.ImageOptimizationSkipLayers
+	skipLayersAndWarn := buildapiv1.ImageOptimizationSkipLayersAndWarn
+	tests := []struct {
+		name       string
+		dockerfile string
+		policy     *buildapiv1.ImageOptimizationPolicy
+		err        bool
+	}{
+		{name: "default policy", dockerfile: volumeThenRun},
+		{name: "keeping layers", dockerfile: volumeThenRun, policy: &none},
+		{name: "skipping layers", dockerfile: volumeThenRun, policy: &skipLayers, err: true},
+		{name: "skipping layers and warning", dockerfile: volumeThenRun, policy: &skipLayersAndWarn},
+		{name: "supported", dockerfile: supported, policy: &skipLayers},
+	}
+	dir, err := ioutil.TempDir("", "skip-layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range tests {
+		path := filepath.Join(dir, "Dockerfile")
+		writeTestFile(t, path, test.dockerfile)
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{ImageOptimizationPolicy: test.policy}
+		if err := checkSkipLayers(build, path); (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestSkipLayersProblems(t *testing.T) {
+	node, err := imagebuilder.ParseDockerfile(strings.NewReader(heredoc.Doc(`
+		FROM centos:7
+		VOLUME /data /logs
+		COPY data /data
+		RUN ls /data
+		`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := skipLayersProblems(node)
+	expect := "line 4: RUN after VOLUME /data /logs may change the owner and permissions of the volume's contents"
+	if len(problems) != 1 || problems[0] != expect {
+		t.Errorf("expected %q, got %q", expect, problems)
+	}
+}
@@ -1,21 +1,26 @@
 package builder
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
 
 	"github.com/containers/buildah"
+	ireference "github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/storage"
@@ -24,6 +29,7 @@ import (
 	buildapiv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/builder/pkg/build/builder/cmd/dockercfg"
 	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
 	"github.com/openshift/library-go/pkg/git"
 	s2igit "github.com/openshift/source-to-image/pkg/scm/git"
 )
@@ -38,10 +44,101 @@ const (
 	// timeoutIncrementFactor is the factor to use when increasing
 	// the timeout after each unsuccessful try
 	timeoutIncrementFactor = 4
+
+	// maxParallelImageSourcePulls is how many of the images that source is
+	// extracted from are pulled at once.
+	maxParallelImageSourcePulls = 4
 )
 
+// minSparseCheckoutGitVersion is the first version of git whose
+// sparse-checkout set command takes --cone, as sparse checkouts need.  The
+// whole repository is cloned with older versions, such as that of the RHEL 7
+// builder image.
+var minSparseCheckoutGitVersion = []int{2, 35}
+
+// NewGitClient returns a GitClient which runs git with the given environment.
+func NewGitClient(env []string) GitClient {
+	return &gitClient{Repository: git.NewRepositoryWithEnv(env), env: env}
+}
+
+// gitClient extends git.Repository with the ability to run git subcommands
+// which it does not wrap, using the same environment.
+type gitClient struct {
+	git.Repository
+	env []string
+}
+
+// Run executes git with the given arguments in dir and returns its trimmed
+// standard output and standard error.  A non-zero exit is reported as a
+// *git.GitError.
+func (c *gitClient) Run(dir string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	log.V(4).Infof("Executing git %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(BuildContext(), "git", args...)
+	cmd.Dir = dir
+	cmd.Env = c.env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	out, errOut := strings.TrimRight(stdout.String(), "\n"), strings.TrimRight(stderr.String(), "\n")
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out, errOut, &git.GitError{Err: exitErr, Stdout: out, Stderr: errOut}
+	}
+	return out, errOut, err
+}
+
+// gitVersion returns the numbers of the version of git which gitClient runs,
+// such as [2 39 5] for "git version 2.39.5".
+func gitVersion(gitClient GitClient) ([]int, error) {
+	out, _, err := gitClient.Run("", "version")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return nil, fmt.Errorf("unexpected git version %q", out)
+	}
+	var version []int
+	for _, part := range strings.Split(fields[2], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			// such as the "windows" of 2.39.0.windows.1
+			break
+		}
+		version = append(version, n)
+	}
+	if len(version) == 0 {
+		return nil, fmt.Errorf("unexpected git version %q", out)
+	}
+	return version, nil
+}
+
+// versionAtLeast returns whether version is min or later.
+func versionAtLeast(version, min []int) bool {
+	for i, n := range min {
+		v := 0
+		if i < len(version) {
+			v = version[i]
+		}
+		if v != n {
+			return v > n
+		}
+	}
+	return true
+}
+
+// formatVersion returns version as dotted numbers.
+func formatVersion(version []int) string {
+	parts := make([]string, len(version))
+	for i, n := range version {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
 type gitAuthError string
 type gitNotFoundError string
+type gitHostKeyError string
 
 func (e gitAuthError) Error() string {
 	return fmt.Sprintf("failed to fetch requested repository %q with provided credentials", string(e))
@@ -51,45 +148,124 @@ func (e gitNotFoundError) Error() string {
 	return fmt.Sprintf("requested repository %q not found", string(e))
 }
 
-// GitClone clones the source associated with a build(if any) into the specified directory
-func GitClone(ctx context.Context, gitClient GitClient, gitSource *buildapiv1.GitBuildSource, revision *buildapiv1.SourceRevision, dir string) (*git.SourceInfo, error) {
+func (e gitHostKeyError) Error() string {
+	return fmt.Sprintf("failed to verify the SSH host key of the server of repository %q: the key is unknown or has changed, check the known_hosts key of the source secret or the %s policy", string(e), builderutil.SSHHostKeyPolicy)
+}
 
-	// It is possible for the initcontainer to get restarted, thus we must wipe out the directory if it already exists.
-	err := os.RemoveAll(dir)
-	if err != nil {
-		return nil, err
+// GitCloneOptions holds the optional settings that control how GitClone
+// fetches a repository.
+type GitCloneOptions struct {
+	// Depth, if greater than zero, limits the clone to that many commits of
+	// history.  Refs and commits which fall outside of the shallow window are
+	// fetched individually before being checked out.
+	Depth int
+	// LFS enables fetching and checking out Git LFS objects when the
+	// repository tracks files with LFS.
+	LFS bool
+	// SparseCheckoutDir, if set, limits the working tree to the files at the
+	// top of the repository and those under this directory, using a cone mode
+	// sparse checkout of a blobless partial clone.  Only submodules under the
+	// directory are fetched.
+	SparseCheckoutDir string
+}
+
+// GetGitCloneOptions returns the clone options requested by the build
+// strategy's environment.
+func GetGitCloneOptions(build *buildapiv1.Build) (GitCloneOptions, error) {
+	opts := GitCloneOptions{}
+	if value, ok := buildStrategyEnv(build, builderutil.GitCloneDepth); ok && len(value) > 0 {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			return opts, fmt.Errorf("invalid %s value %q: must be a non-negative integer", builderutil.GitCloneDepth, value)
+		}
+		opts.Depth = depth
 	}
-	os.MkdirAll(dir, 0777)
+	if value, ok := buildStrategyEnv(build, builderutil.GitLFS); ok && len(value) > 0 {
+		lfs, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s value %q: %v", builderutil.GitLFS, value, err)
+		}
+		opts.LFS = lfs
+	}
+	if value, ok := buildStrategyEnv(build, builderutil.GitSparseCheckout); ok && len(value) > 0 {
+		sparse, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s value %q: %v", builderutil.GitSparseCheckout, value, err)
+		}
+		contextDir := strings.Trim(path.Clean("/"+build.Spec.Source.ContextDir), "/")
+		switch {
+		case !sparse:
+		case len(contextDir) == 0:
+			log.V(0).Infof("Ignoring %s, the build does not specify a context directory", builderutil.GitSparseCheckout)
+		default:
+			opts.SparseCheckoutDir = contextDir
+		}
+	}
+	return opts, nil
+}
 
-	hasGitSource, err := extractGitSource(ctx, gitClient, gitSource, revision, dir, initialURLCheckTimeout)
+// GetGitSubmoduleSecrets returns the prefixes of the keys of the build's
+// source secret, keyed by git host, that the build strategy's environment
+// requests be used to authenticate to repositories on hosts other than that
+// of the main source, typically submodules.  They are taken from the source
+// secret, as it is the only secret mounted while the source is fetched.
+func GetGitSubmoduleSecrets(build *buildapiv1.Build) (map[string]string, error) {
+	value, ok := buildStrategyEnv(build, builderutil.GitSubmoduleSecrets)
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+	if build.Spec.Source.SourceSecret == nil {
+		return nil, fmt.Errorf("invalid %s value %q: the build has no source secret to hold the credentials", builderutil.GitSubmoduleSecrets, value)
+	}
+	hostPrefixes := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: must be of the form host=prefix", builderutil.GitSubmoduleSecrets, pair)
+		}
+		hostPrefixes[parts[0]] = parts[1]
+	}
+	return hostPrefixes, nil
+}
 
-	if err != nil {
-		return nil, err
+// GetSSHHostKeyPolicy returns how the build strategy's environment requests
+// that the keys of git hosts cloned from over ssh are verified, and the
+// known_hosts key of the build's source secret, mounted at sourceSecretDir,
+// which they are verified against, if it has one.  The source secret is the
+// only secret mounted while the source is fetched.  Strict verification
+// requires known_hosts, as no host would otherwise be trusted.
+func GetSSHHostKeyPolicy(build *buildapiv1.Build, sourceSecretDir string) (string, string, error) {
+	policy, _ := buildStrategyEnv(build, builderutil.SSHHostKeyPolicy)
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	switch policy {
+	case "", "strict", "accept-new", "insecure":
+	default:
+		return "", "", fmt.Errorf("invalid %s value %q: must be \"strict\", \"accept-new\" or \"insecure\"", builderutil.SSHHostKeyPolicy, policy)
 	}
 
-	var sourceInfo *git.SourceInfo
-	if hasGitSource {
-		var errs []error
-		sourceInfo, errs = gitClient.GetInfo(dir)
-		if len(errs) > 0 {
-			for _, e := range errs {
-				log.V(0).Infof("error: Unable to retrieve Git info: %v", e)
-			}
-		}
-		if sourceInfo != nil {
-			sourceInfoJson, err := json.Marshal(*sourceInfo)
-			if err != nil {
-				log.V(0).Infof("error: Unable to serialized git source info: %v", err)
-				return sourceInfo, nil
-			}
-			err = ioutil.WriteFile(filepath.Join(buildWorkDirMount, "sourceinfo.json"), sourceInfoJson, 0644)
-			if err != nil {
-				log.V(0).Infof("error: Unable to serialized git source info: %v", err)
-				return sourceInfo, nil
-			}
+	knownHosts := ""
+	if build.Spec.Source.SourceSecret != nil && len(sourceSecretDir) > 0 {
+		if _, err := os.Stat(filepath.Join(sourceSecretDir, "known_hosts")); err == nil {
+			knownHosts = filepath.Join(sourceSecretDir, "known_hosts")
 		}
 	}
-	return sourceInfo, nil
+	if policy == "strict" && len(knownHosts) == 0 {
+		return "", "", fmt.Errorf("invalid %s value %q: the build's source secret has no known_hosts key holding the keys of the hosts to trust", builderutil.SSHHostKeyPolicy, policy)
+	}
+	return policy, knownHosts, nil
+}
+
+// GitClone clones the source associated with a build(if any) into the specified directory
+func GitClone(ctx context.Context, gitClient GitClient, gitSource *buildapiv1.GitBuildSource, revision *buildapiv1.SourceRevision, dir string, opts GitCloneOptions) (*git.SourceInfo, error) {
+	var fetchers []SourceFetcher
+	if gitSource != nil {
+		fetchers = append(fetchers, &gitSourceFetcher{client: gitClient, source: gitSource, revision: revision, opts: opts})
+	}
+	return FetchSource(ctx, fetchers, dir)
 }
 
 // ManageDockerfile manipulates the dockerfile for docker builds.
@@ -98,17 +274,30 @@ func GitClone(ctx context.Context, gitClient GitClient, gitSource *buildapiv1.Gi
 // in the working directory (accounting for contextdir+dockerfilepath)
 // with new FROM image information based on the imagestream/imagetrigger
 // and also adds some env and label values to the dockerfile based on
-// the build information.
+// the build information.  A docker build whose context has no dockerfile
+// is given one based on its From image.  The dockerfile of a docker build is
+// the one named by its dockerfilePath or else, if the context has a
+// Containerfile but no Dockerfile, its Containerfile.
 func ManageDockerfile(dir string, build *buildapiv1.Build) error {
 	os.MkdirAll(dir, 0777)
 	log.V(5).Infof("Checking for presence of a Dockerfile")
+	if err := validateDockerfilePath(build); err != nil {
+		return err
+	}
 	// a Dockerfile has been specified, create or overwrite into the destination
 	if dockerfileSource := build.Spec.Source.Dockerfile; dockerfileSource != nil {
 		baseDir := dir
 		if len(build.Spec.Source.ContextDir) != 0 {
 			baseDir = filepath.Join(baseDir, build.Spec.Source.ContextDir)
 		}
-		if err := ioutil.WriteFile(filepath.Join(baseDir, "Dockerfile"), []byte(*dockerfileSource), 0660); err != nil {
+		dockerfilePath := filepath.Join(baseDir, defaultDockerfilePath)
+		if strategy := build.Spec.Strategy.DockerStrategy; strategy != nil && len(strategy.DockerfilePath) > 0 {
+			dockerfilePath = filepath.Join(baseDir, filepath.FromSlash(strategy.DockerfilePath))
+		}
+		if err := os.MkdirAll(filepath.Dir(dockerfilePath), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dockerfilePath, []byte(*dockerfileSource), 0660); err != nil {
 			return err
 		}
 	}
@@ -116,6 +305,9 @@ func ManageDockerfile(dir string, build *buildapiv1.Build) error {
 	// We only mutate the dockerfile if this is a docker strategy build, otherwise
 	// we leave it as it was provided.
 	if build.Spec.Strategy.DockerStrategy != nil {
+		if err := generateDockerfile(dir, build); err != nil {
+			return err
+		}
 		sourceInfo, err := readSourceInfo()
 		if err != nil {
 			return fmt.Errorf("error reading git source info: %v", err)
@@ -136,19 +328,44 @@ func ExtractImageContent(ctx context.Context, dockerClient DockerClient, store s
 	case build.Spec.Strategy.CustomStrategy != nil:
 		forcePull = build.Spec.Strategy.CustomStrategy.ForcePull
 	}
-	// extract source from an Image if specified
+	// pull each image that source is extracted from once, in parallel,
+	// then copy from them in the order the build lists them, so that
+	// later paths still overwrite earlier ones
+	sources := map[string]*imageSource{}
+	var pulls []*imageSource
+	defer func() {
+		for _, source := range pulls {
+			source.unmount()
+		}
+	}()
 	for i, image := range build.Spec.Source.Images {
-		if len(image.Paths) == 0 {
+		if len(image.Paths) == 0 || sources[image.From.Name] != nil {
 			continue
 		}
 		imageSecretIndex := i
 		if image.PullSecret == nil {
 			imageSecretIndex = -1
 		}
-		err := extractSourceFromImage(ctx, dockerClient, store, image.From.Name, dir, imageSecretIndex, image.Paths, forcePull, blobCacheDirectory)
+		source, err := newImageSource(dockerClient, image.From.Name, imageSecretIndex, forcePull)
 		if err != nil {
 			return err
 		}
+		sources[image.From.Name] = source
+		pulls = append(pulls, source)
+	}
+	if len(pulls) > 1 {
+		log.V(0).Infof("Pulling %d images that source is extracted from ...", len(pulls))
+	}
+	if err := mountImageSources(ctx, store, pulls, blobCacheDirectory); err != nil {
+		return err
+	}
+	for _, image := range build.Spec.Source.Images {
+		if len(image.Paths) == 0 {
+			continue
+		}
+		if err := sources[image.From.Name].extract(image.Paths, dir); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -188,6 +405,9 @@ func checkRemoteGit(gitClient GitClient, url string, initialTimeout time.Duratio
 	if err != nil {
 		combinedOut := out + errOut
 		switch {
+		case strings.Contains(combinedOut, "Host key verification failed"):
+			log.V(0).Infof("%s", strings.TrimSpace(errOut))
+			return gitHostKeyError(url)
 		case strings.Contains(combinedOut, "Authentication failed"):
 			return gitAuthError(url)
 		case strings.Contains(combinedOut, "not found"):
@@ -208,12 +428,14 @@ func checkSourceURI(gitClient GitClient, rawurl string, timeout time.Duration) e
 }
 
 // ExtractInputBinary processes the provided input stream as directed by BinaryBuildSource
-// into dir.
-func ExtractInputBinary(in io.Reader, source *buildapiv1.BinaryBuildSource, dir string) error {
+// into dir, as it is received.  Inputs larger than maxSize bytes are refused,
+// unless maxSize is zero.
+func ExtractInputBinary(in io.Reader, source *buildapiv1.BinaryBuildSource, dir string, maxSize int64) error {
 	os.MkdirAll(dir, 0777)
 	if source == nil {
 		return nil
 	}
+	input := newBinaryInputReader(in, maxSize)
 
 	var path string
 	if len(source.AsFile) > 0 {
@@ -225,33 +447,29 @@ func ExtractInputBinary(in io.Reader, source *buildapiv1.BinaryBuildSource, dir
 			return err
 		}
 		defer f.Close()
-		n, err := io.Copy(f, os.Stdin)
-		if err != nil {
+		if _, err := io.Copy(f, input); err != nil {
 			return err
 		}
-		log.V(4).Infof("Received %d bytes into %s", n, path)
+		input.done()
+		log.V(4).Infof("Received %d bytes into %s", input.n, path)
 		return nil
 	}
 
 	log.V(0).Infof("Receiving source from STDIN as archive ...")
 
-	args := []string{"-x", "-o", "-m", "-f", "-", "-C", dir}
-	if log.Is(6) {
-		args = append(args, "-v")
+	err := extractBinaryArchive(input, dir)
+	if input.err != nil {
+		return input.err
 	}
-
-	cmd := exec.Command("bsdtar", args...)
-	cmd.Stdin = in
-	out, err := cmd.CombinedOutput()
-	log.V(4).Infof("Extracting...\n%s", string(out))
 	if err != nil {
 		return fmt.Errorf("unable to extract binary build input, must be a zip, tar, or gzipped tar, or specified as a file: %v", err)
 	}
+	input.done()
 
 	return nil
 }
 
-func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *buildapiv1.GitBuildSource, revision *buildapiv1.SourceRevision, dir string, timeout time.Duration) (bool, error) {
+func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *buildapiv1.GitBuildSource, revision *buildapiv1.SourceRevision, dir string, timeout time.Duration, opts GitCloneOptions) (bool, error) {
 	if gitSource == nil {
 		return false, nil
 	}
@@ -269,9 +487,38 @@ func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *build
 
 	// check if we specify a commit, ref, or branch to check out
 	// Recursive clone if we're not going to checkout a ref and submodule update later
+	sparse := len(opts.SparseCheckoutDir) > 0
+	if sparse {
+		version, err := gitVersion(gitClient)
+		switch {
+		case err != nil:
+			log.V(0).Infof("warning: Cloning the whole repository, as a sparse checkout needs git %s or later and its version is unknown: %v", formatVersion(minSparseCheckoutGitVersion), err)
+			sparse = false
+		case !versionAtLeast(version, minSparseCheckoutGitVersion):
+			log.V(0).Infof("warning: Cloning the whole repository, as a sparse checkout needs git %s or later, not %s", formatVersion(minSparseCheckoutGitVersion), formatVersion(version))
+			sparse = false
+		}
+	}
 	if !usingRef {
-		cloneOptions = append(cloneOptions, "--recursive")
-		cloneOptions = append(cloneOptions, git.Shallow)
+		// submodules outside of a sparse checkout are skipped, so they are
+		// updated separately once it is configured
+		if !sparse {
+			cloneOptions = append(cloneOptions, "--recursive")
+		}
+		if opts.Depth > 0 {
+			cloneOptions = append(cloneOptions, fmt.Sprintf("--depth=%d", opts.Depth))
+		} else {
+			cloneOptions = append(cloneOptions, git.Shallow)
+		}
+	} else if opts.Depth > 0 {
+		// Fetch the tips of all branches so that a branch or tag ref can be
+		// checked out directly.  Anything outside of the shallow window is
+		// fetched by PotentialPRRetryAsFetch below.
+		cloneOptions = append(cloneOptions, fmt.Sprintf("--depth=%d", opts.Depth), "--no-single-branch")
+	}
+	if sparse {
+		// Servers which do not support partial clones ignore the filter
+		cloneOptions = append(cloneOptions, "--sparse", "--filter=blob:none")
 	}
 
 	log.V(3).Infof("Cloning source from %s", gitSource.URI)
@@ -280,10 +527,17 @@ func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *build
 	if !log.Is(5) {
 		cloneOptions = append(cloneOptions, "--quiet")
 	}
+	timing.SetStage(buildapiv1.StageFetchInputs)
 	startTime := metav1.Now()
 	if err := gitClient.CloneWithOptions(dir, gitSource.URI, cloneOptions...); err != nil {
 		return true, err
 	}
+	if sparse {
+		log.V(3).Infof("Limiting checkout to %s", opts.SparseCheckoutDir)
+		if _, _, err := gitClient.Run(dir, "sparse-checkout", "set", "--cone", opts.SparseCheckoutDir); err != nil {
+			return true, fmt.Errorf("unable to set up sparse checkout of %q: %v", opts.SparseCheckoutDir, err)
+		}
+	}
 
 	timing.RecordNewStep(ctx, buildapiv1.StageFetchInputs, buildapiv1.StepFetchGitSource, startTime, metav1.Now())
 
@@ -303,7 +557,20 @@ func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *build
 		}
 
 		// Recursively update --init
-		if err := gitClient.SubmoduleUpdate(dir, true, true); err != nil {
+		if !sparse {
+			if err := gitClient.SubmoduleUpdate(dir, true, true); err != nil {
+				return true, err
+			}
+		}
+	}
+	if sparse {
+		if _, _, err := gitClient.Run(dir, "submodule", "update", "--init", "--recursive", "--", opts.SparseCheckoutDir); err != nil {
+			return true, err
+		}
+	}
+
+	if opts.LFS {
+		if err := fetchGitLFSObjects(gitClient, dir); err != nil {
 			return true, err
 		}
 	}
@@ -317,6 +584,75 @@ func extractGitSource(ctx context.Context, gitClient GitClient, gitSource *build
 	return true, nil
 }
 
+// fetchGitLFSObjects replaces Git LFS pointer files in the working tree at
+// dir with their content.  git-lfs runs with the same environment as the
+// clone, so it reuses the credentials and proxy settings configured from the
+// build's source secret.
+func fetchGitLFSObjects(gitClient GitClient, dir string) error {
+	uses, err := usesGitLFS(dir)
+	if err != nil {
+		return err
+	}
+	if !uses {
+		log.V(4).Infof("Repository does not track any files with Git LFS")
+		return nil
+	}
+	if _, _, err := gitClient.Run(dir, "lfs", "version"); err != nil {
+		return fmt.Errorf("repository tracks files with Git LFS, but git-lfs is not available: %v", err)
+	}
+	log.V(0).Infof("Fetching Git LFS objects ...")
+	if _, _, err := gitClient.Run(dir, "lfs", "install", "--local"); err != nil {
+		return fmt.Errorf("unable to configure Git LFS: %v", err)
+	}
+	if _, _, err := gitClient.Run(dir, "lfs", "fetch"); err != nil {
+		return fmt.Errorf("unable to fetch Git LFS objects: %v", err)
+	}
+	if _, _, err := gitClient.Run(dir, "lfs", "checkout"); err != nil {
+		return fmt.Errorf("unable to check out Git LFS objects: %v", err)
+	}
+	return nil
+}
+
+// errGitLFSFound stops the walk of usesGitLFS once a path using Git LFS is
+// found.
+var errGitLFSFound = errors.New("found a path using Git LFS")
+
+// usesGitLFS reports whether any .gitattributes file in the working tree at
+// dir assigns the lfs filter to a path.
+func usesGitLFS(dir string) (bool, error) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || info.Name() != ".gitattributes" {
+			return nil
+		}
+		lines, err := ReadLines(path)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, attr := range fields[1:] {
+				if attr == "filter=lfs" {
+					return errGitLFSFound
+				}
+			}
+		}
+		return nil
+	})
+	if err == errGitLFSFound {
+		return true, nil
+	}
+	return false, err
+}
+
 func copyImageSourceFromFilesytem(sourceDir, destDir string) error {
 	// Setup destination directory
 	fi, err := os.Stat(destDir)
@@ -348,22 +684,36 @@ func copyImageSourceFromFilesytem(sourceDir, destDir string) error {
 	return nil
 }
 
-func extractSourceFromImage(ctx context.Context, dockerClient DockerClient, store storage.Store, image, buildDir string, imageSecretIndex int, paths []buildapiv1.ImageSourcePath, forcePull bool, blobCacheDirectory string) error {
-	log.V(4).Infof("Extracting image source from image %s", image)
+// imageSource is an image whose content is copied into the build directory.
+type imageSource struct {
+	image         string
+	pullPolicy    buildah.PullPolicy
+	systemContext types.SystemContext
+	builder       *buildah.Builder
+	mountPath     string
+	// authDir holds the auth file of the image's pull secret, if it has
+	// one
+	authDir string
+}
 
-	pullPolicy := buildah.PullIfMissing
+// newImageSource returns the imageSource for image, pulled with the pull
+// secret of the build's image source at imageSecretIndex, if it is not -1.
+// Images referred to by digest are only pulled if they are missing, even if
+// forcePull is set, since their content cannot change.
+func newImageSource(dockerClient DockerClient, image string, imageSecretIndex int, forcePull bool) (*imageSource, error) {
+	source := &imageSource{image: image, pullPolicy: buildah.PullIfMissing}
 	if forcePull {
-		pullPolicy = buildah.PullAlways
+		source.pullPolicy = buildah.PullAlways
 	}
-
-	/*
-		storeOptions := storage.DefaultStoreOptions
-		storeOptions.GraphDriverName = "overlay"
-		store, err := storage.GetStore(storeOptions)
+	if strings.Contains(image, "@") {
+		named, err := ireference.ParseNormalizedNamed(image)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("invalid image source reference %q: %v", image, err)
 		}
-	*/
+		if _, ok := named.(ireference.Canonical); ok {
+			source.pullPolicy = buildah.PullIfMissing
+		}
+	}
 
 	var auths *docker.AuthConfigurations
 	var err error
@@ -372,31 +722,74 @@ func extractSourceFromImage(ctx context.Context, dockerClient DockerClient, stor
 		if len(pullSecretPath) > 0 {
 			auths, err = GetDockerAuthConfiguration(pullSecretPath)
 			if err != nil {
-				return fmt.Errorf("error reading docker auth configuration: %v", err)
+				return nil, fmt.Errorf("error reading docker auth configuration: %v", err)
 			}
 		}
 	}
 
-	var systemContext types.SystemContext
-	systemContext.AuthFilePath = "/tmp/config.json"
+	if daemonless, ok := dockerClient.(*DaemonlessClient); ok {
+		source.systemContext = daemonless.SystemContext
+	}
+	source.systemContext = registrySystemContext(source.systemContext, image)
+	source.systemContext.AuthFilePath = authFilePath
 
+	// the image sources are pulled in parallel, so each pull secret is
+	// written to an auth file of its own, rather than to the one shared
+	// with sources which may have another secret for the same registry
 	if auths != nil {
+		if source.authDir, err = ioutil.TempDir("", "image-source-auth"); err != nil {
+			return nil, err
+		}
+		source.systemContext.AuthFilePath = filepath.Join(source.authDir, "auth.json")
 		for registry, ac := range auths.Configs {
 			log.V(5).Infof("Setting authentication for registry %q using %q.", registry, ac.ServerAddress)
-			if err := config.SetAuthentication(&systemContext, registry, ac.Username, ac.Password); err != nil {
-				return err
+			if err := config.SetAuthentication(&source.systemContext, registry, ac.Username, ac.Password); err != nil {
+				source.unmount()
+				return nil, err
 			}
-			if err := config.SetAuthentication(&systemContext, ac.ServerAddress, ac.Username, ac.Password); err != nil {
-				return err
+			if err := config.SetAuthentication(&source.systemContext, ac.ServerAddress, ac.Username, ac.Password); err != nil {
+				source.unmount()
+				return nil, err
 			}
 		}
 	}
 
+	// content is extracted from the image of a manifest list for the
+	// build's pull platform, which cannot change once it is chosen
+	if len(pullPlatform) > 0 {
+		name, err := platformImageName(&source.systemContext, image, pullPlatform)
+		if err != nil {
+			source.unmount()
+			return nil, fmt.Errorf("unable to find the %s image of %s: %v", pullPlatform, image, err)
+		}
+		if name != image {
+			log.V(0).Infof("Using %s for the %s image of %s", name, pullPlatform, image)
+			source.image, source.pullPolicy = name, buildah.PullIfMissing
+		}
+	}
+	return source, nil
+}
+
+// mount pulls the image, if need be, and mounts its content.  Blobs in
+// blobCacheDirectory are reused rather than pulled again.
+func (s *imageSource) mount(ctx context.Context, store storage.Store, blobCacheDirectory string) error {
+	log.V(4).Infof("Extracting image source from image %s", s.image)
+
+	/*
+		storeOptions := storage.DefaultStoreOptions
+		storeOptions.GraphDriverName = "overlay"
+		store, err := storage.GetStore(storeOptions)
+		if err != nil {
+			return err
+		}
+	*/
+
 	builderOptions := buildah.BuilderOptions{
-		FromImage:        image,
-		PullPolicy:       pullPolicy,
+		FromImage:        s.image,
+		PullPolicy:       s.pullPolicy,
 		ReportWriter:     os.Stdout,
-		SystemContext:    &systemContext,
+		SystemContext:    &s.systemContext,
+		BlobDirectory:    blobCacheDirectory,
 		DropCapabilities: dropCapabilities(),
 		CommonBuildOpts: &buildah.CommonBuildOptions{
 			HTTPProxy: true,
@@ -407,33 +800,70 @@ func extractSourceFromImage(ctx context.Context, dockerClient DockerClient, stor
 	if err != nil {
 		return fmt.Errorf("error creating buildah builder: %v", err)
 	}
+	s.builder = builder
 
-	mountPath, err := builder.Mount("")
-	defer func() {
-		err := builder.Unmount()
-		if err != nil {
-			klog.Errorf("failed to unmount: %v", err)
-		}
-	}()
+	s.mountPath, err = builder.Mount("")
 	if err != nil {
-		return fmt.Errorf("error mounting image content from image %s: %v", image, err)
+		return fmt.Errorf("error mounting image content from image %s: %v", s.image, err)
+	}
+	return nil
+}
+
+// unmount unmounts the content of the image, if it was mounted, and removes
+// the auth file of its pull secret.
+func (s *imageSource) unmount() {
+	if len(s.authDir) > 0 {
+		os.RemoveAll(s.authDir)
+	}
+	if s.builder == nil {
+		return
+	}
+	if err := s.builder.Unmount(); err != nil {
+		klog.Errorf("failed to unmount: %v", err)
 	}
+}
 
+// extract copies paths from the mounted content of the image into buildDir.
+func (s *imageSource) extract(paths []buildapiv1.ImageSourcePath, buildDir string) error {
 	for _, path := range paths {
 		destPath := filepath.Join(buildDir, path.DestinationDir)
 		// Paths ending with "/." are truncated by filepath.Join
 		// Add it back to preserve copy behavior per docs:
 		// https://docs.okd.io/latest/dev_guide/builds/build_inputs.html#image-source
-		sourcePath := filepath.Join(mountPath, path.SourcePath)
+		sourcePath := filepath.Join(s.mountPath, path.SourcePath)
 		if strings.HasSuffix(path.SourcePath, "/.") {
 			sourcePath = sourcePath + "/."
 		}
-		log.V(4).Infof("Extracting path %s from image %s to %s", path.SourcePath, image, path.DestinationDir)
+		log.V(4).Infof("Extracting path %s from image %s to %s", path.SourcePath, s.image, path.DestinationDir)
 		err := copyImageSourceFromFilesytem(sourcePath, destPath)
 		if err != nil {
 			return fmt.Errorf("error copying source path %s to %s: %v", path.SourcePath, path.DestinationDir, err)
 		}
 	}
+	return nil
+}
 
+// mountImageSources mounts sources, pulling up to maxParallelImageSourcePulls
+// of them at a time, and returns the first error, if any.  Sources which were
+// mounted stay mounted even if others fail.
+func mountImageSources(ctx context.Context, store storage.Store, sources []*imageSource, blobCacheDirectory string) error {
+	errs := make([]error, len(sources))
+	slots := make(chan struct{}, maxParallelImageSourcePulls)
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source *imageSource) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs[i] = source.mount(ctx, store, blobCacheDirectory)
+		}(i, source)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
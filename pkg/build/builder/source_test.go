@@ -10,13 +10,18 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containers/buildah"
+	"github.com/containers/image/v5/pkg/docker/config"
+
 	buildapiv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/builder/pkg/build/builder/timing"
-	"github.com/openshift/library-go/pkg/git"
 )
 
 func TestCheckRemoteGit(t *testing.T) {
@@ -24,7 +29,7 @@ func TestCheckRemoteGit(t *testing.T) {
 		w.WriteHeader(http.StatusUnauthorized)
 	}))
 	defer server.Close()
-	gitRepo := git.NewRepositoryWithEnv([]string{"GIT_ASKPASS=true", fmt.Sprintf("HOME=%s", os.TempDir())})
+	gitRepo := NewGitClient([]string{"GIT_ASKPASS=true", fmt.Sprintf("HOME=%s", os.TempDir())})
 
 	var err error
 	err = checkRemoteGit(gitRepo, server.URL, 10*time.Second)
@@ -178,11 +183,11 @@ func TestUnqualifiedClone(t *testing.T) {
 	}
 	destDir, err := ioutil.TempDir("", "clone-dest-")
 	defer os.RemoveAll(destDir)
-	client := git.NewRepositoryWithEnv([]string{})
+	client := NewGitClient([]string{})
 	source := &buildapiv1.GitBuildSource{URI: "file://" + repo.Path}
 	revision := buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{}}
 	ctx := timing.NewContext(context.Background())
-	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second); err != nil {
+	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second, GitCloneOptions{}); err != nil {
 		t.Errorf("%v", err)
 	}
 	for _, f := range repo.Files {
@@ -218,7 +223,7 @@ func TestCloneFromRef(t *testing.T) {
 	}
 	destDir, err := ioutil.TempDir("", "commit-dest-")
 	defer os.RemoveAll(destDir)
-	client := git.NewRepositoryWithEnv([]string{})
+	client := NewGitClient([]string{})
 	firstCommitRef, err := repo.getRef(-1)
 	if err != nil {
 		t.Errorf("%v", err)
@@ -229,7 +234,7 @@ func TestCloneFromRef(t *testing.T) {
 	}
 	revision := buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{}}
 	ctx := timing.NewContext(context.Background())
-	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second); err != nil {
+	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second, GitCloneOptions{}); err != nil {
 		t.Errorf("%v", err)
 	}
 	for _, f := range repo.Files[:len(repo.Files)-1] {
@@ -277,14 +282,14 @@ func TestCloneFromBranch(t *testing.T) {
 	}
 	destDir, err := ioutil.TempDir("", "branch-dest-")
 	defer os.RemoveAll(destDir)
-	client := git.NewRepositoryWithEnv([]string{})
+	client := NewGitClient([]string{})
 	source := &buildapiv1.GitBuildSource{
 		URI: "file://" + repo.Path,
 		Ref: "test",
 	}
 	revision := buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{}}
 	ctx := timing.NewContext(context.Background())
-	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second); err != nil {
+	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second, GitCloneOptions{}); err != nil {
 		t.Errorf("%v", err)
 	}
 	for _, f := range repo.Files[:len(repo.Files)-1] {
@@ -305,6 +310,436 @@ func TestCloneFromBranch(t *testing.T) {
 	}
 }
 
+func TestShallowCloneFromRef(t *testing.T) {
+	repo, err := initializeTestGitRepo("shallow")
+	defer repo.cleanup()
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := repo.addCommit(); err != nil {
+			t.Errorf("unable to add commit: %v", err)
+		}
+	}
+	destDir, err := ioutil.TempDir("", "shallow-dest-")
+	defer os.RemoveAll(destDir)
+	client := NewGitClient([]string{})
+	// the first commit is outside of a shallow window of two commits
+	firstCommitRef, err := repo.getRef(-3)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	source := &buildapiv1.GitBuildSource{
+		URI: "file://" + repo.Path,
+		Ref: firstCommitRef,
+	}
+	revision := buildapiv1.SourceRevision{Git: &buildapiv1.GitSourceRevision{}}
+	ctx := timing.NewContext(context.Background())
+	if _, err = extractGitSource(ctx, client, source, &revision, destDir, 10*time.Second, GitCloneOptions{Depth: 2}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	headCmd := exec.Command("git", "rev-parse", "HEAD")
+	headCmd.Dir = destDir
+	out, err := headCmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to read HEAD: %q", out)
+	}
+	if head := strings.TrimSpace(string(out)); head != firstCommitRef {
+		t.Errorf("expected HEAD to be %s, got %s", firstCommitRef, head)
+	}
+	shallowCmd := exec.Command("git", "rev-parse", "--is-shallow-repository")
+	shallowCmd.Dir = destDir
+	out, err = shallowCmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to check for a shallow repository: %q", out)
+	}
+	if strings.TrimSpace(string(out)) != "true" {
+		t.Errorf("expected a shallow repository")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, path.Base(repo.Files[len(repo.Files)-1]))); !os.IsNotExist(err) {
+		t.Errorf("last file should not exist in this checkout")
+	}
+}
+
+func TestSparseCheckout(t *testing.T) {
+	repo, err := initializeTestGitRepo("sparse")
+	defer repo.cleanup()
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repo.Path, "app"), 0777); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo.Path, "app", "Dockerfile"), []byte("FROM scratch"), 0666); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := repo.addSubmodule(); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := repo.addCommit(); err != nil {
+		t.Errorf("unable to add commit: %v", err)
+	}
+	destDir, err := ioutil.TempDir("", "sparse-dest-")
+	defer os.RemoveAll(destDir)
+	client := NewGitClient([]string{})
+	source := &buildapiv1.GitBuildSource{
+		URI: "file://" + repo.Path,
+	}
+	ctx := timing.NewContext(context.Background())
+	if _, err = extractGitSource(ctx, client, source, nil, destDir, 10*time.Second, GitCloneOptions{SparseCheckoutDir: "app"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, f := range []string{"initial-file", "app/Dockerfile"} {
+		if _, err := os.Stat(filepath.Join(destDir, f)); err != nil {
+			t.Errorf("expected %s to be checked out: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub", path.Base(repo.Submodule.Files[0]))); !os.IsNotExist(err) {
+		t.Errorf("submodule outside of the sparse checkout should not be cloned")
+	}
+}
+
+// TestSparseCheckoutOldGit clones with a sparse checkout using a git which
+// reports a version too old for one, which clones the whole repository.
+func TestSparseCheckoutOldGit(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skipf("git is not installed: %v", err)
+	}
+	repo, err := initializeTestGitRepo("old-git")
+	defer repo.cleanup()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := repo.addSubmodule(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := repo.addCommit(); err != nil {
+		t.Fatalf("unable to add commit: %v", err)
+	}
+
+	// a git which claims to be that of RHEL 7, and fails if it is asked
+	// for a sparse checkout
+	binDir, err := ioutil.TempDir("", "old-git-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"version) echo 'git version 1.8.3.1' ;;\n" +
+		"*--sparse*|*sparse-checkout*) echo \"unknown option\" >&2; exit 129 ;;\n" +
+		"*) exec " + gitPath + " \"$@\" ;;\n" +
+		"esac\n"
+	if err := ioutil.WriteFile(filepath.Join(binDir, "git"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	destDir, err := ioutil.TempDir("", "sparse-dest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	client := NewGitClient(os.Environ())
+	if version, err := gitVersion(client); err != nil || versionAtLeast(version, minSparseCheckoutGitVersion) {
+		t.Fatalf("expected an old version of git, got %v, %v", version, err)
+	}
+	source := &buildapiv1.GitBuildSource{URI: "file://" + repo.Path}
+	ctx := timing.NewContext(context.Background())
+	if _, err = extractGitSource(ctx, client, source, nil, destDir, 10*time.Second, GitCloneOptions{SparseCheckoutDir: "app"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "sub", path.Base(repo.Submodule.Files[0]))); err != nil {
+		t.Errorf("expected the whole repository, with its submodules, to be cloned: %v", err)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for _, test := range []struct {
+		version  []int
+		expected bool
+	}{
+		{version: []int{2, 35}, expected: true},
+		{version: []int{2, 39, 5}, expected: true},
+		{version: []int{3}, expected: true},
+		{version: []int{2, 34, 9}},
+		{version: []int{1, 8, 3, 1}},
+		{version: []int{2}},
+	} {
+		if got := versionAtLeast(test.version, minSparseCheckoutGitVersion); got != test.expected {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.version, got)
+		}
+	}
+}
+
+func TestGetGitCloneOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        []corev1.EnvVar
+		contextDir string
+		want       GitCloneOptions
+		wantErr    bool
+	}{
+		{
+			name: "unset",
+			want: GitCloneOptions{},
+		},
+		{
+			name: "depth",
+			env:  []corev1.EnvVar{{Name: "BUILD_GIT_CLONE_DEPTH", Value: "10"}},
+			want: GitCloneOptions{Depth: 10},
+		},
+		{
+			name:    "invalid depth",
+			env:     []corev1.EnvVar{{Name: "BUILD_GIT_CLONE_DEPTH", Value: "ten"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative depth",
+			env:     []corev1.EnvVar{{Name: "BUILD_GIT_CLONE_DEPTH", Value: "-1"}},
+			wantErr: true,
+		},
+		{
+			name: "lfs",
+			env:  []corev1.EnvVar{{Name: "BUILD_GIT_LFS", Value: "true"}},
+			want: GitCloneOptions{LFS: true},
+		},
+		{
+			name:    "invalid lfs",
+			env:     []corev1.EnvVar{{Name: "BUILD_GIT_LFS", Value: "maybe"}},
+			wantErr: true,
+		},
+		{
+			name:       "sparse checkout",
+			env:        []corev1.EnvVar{{Name: "BUILD_GIT_SPARSE_CHECKOUT", Value: "true"}},
+			contextDir: "/services/api/",
+			want:       GitCloneOptions{SparseCheckoutDir: "services/api"},
+		},
+		{
+			name: "sparse checkout without context dir",
+			env:  []corev1.EnvVar{{Name: "BUILD_GIT_SPARSE_CHECKOUT", Value: "true"}},
+			want: GitCloneOptions{},
+		},
+		{
+			name:       "sparse checkout disabled",
+			env:        []corev1.EnvVar{{Name: "BUILD_GIT_SPARSE_CHECKOUT", Value: "false"}},
+			contextDir: "services/api",
+			want:       GitCloneOptions{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			build.Spec.Source.ContextDir = test.contextDir
+			got, err := GetGitCloneOptions(build)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if !test.wantErr && got != test.want {
+				t.Errorf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGetGitSubmoduleSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          []corev1.EnvVar
+		sourceSecret bool
+		want         map[string]string
+		wantErr      bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:         "hosts",
+			env:          []corev1.EnvVar{{Name: "BUILD_GIT_SUBMODULE_SECRETS", Value: "github.com=gh, gitlab.example.com:8443=gl,"}},
+			sourceSecret: true,
+			want: map[string]string{
+				"github.com":              "gh",
+				"gitlab.example.com:8443": "gl",
+			},
+		},
+		{
+			name:         "missing prefix",
+			env:          []corev1.EnvVar{{Name: "BUILD_GIT_SUBMODULE_SECRETS", Value: "github.com"}},
+			sourceSecret: true,
+			wantErr:      true,
+		},
+		{
+			name:    "no source secret",
+			env:     []corev1.EnvVar{{Name: "BUILD_GIT_SUBMODULE_SECRETS", Value: "github.com=gh"}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			if test.sourceSecret {
+				build.Spec.Source.SourceSecret = &corev1.LocalObjectReference{Name: "source"}
+			}
+			got, err := GetGitSubmoduleSecrets(build)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSSHHostKeyPolicy(t *testing.T) {
+	withKnownHosts, err := ioutil.TempDir("", "source-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(withKnownHosts)
+	writeTestFile(t, filepath.Join(withKnownHosts, "known_hosts"), "github.com ssh-ed25519 AAAA\n")
+	withoutKnownHosts, err := ioutil.TempDir("", "source-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(withoutKnownHosts)
+
+	tests := []struct {
+		name           string
+		env            []corev1.EnvVar
+		secretDir      string
+		wantPolicy     string
+		wantKnownHosts string
+		wantErr        bool
+	}{
+		{
+			name:      "unset",
+			secretDir: withoutKnownHosts,
+		},
+		{
+			name:           "strict",
+			env:            []corev1.EnvVar{{Name: "BUILD_SSH_HOST_KEY_POLICY", Value: "Strict"}},
+			secretDir:      withKnownHosts,
+			wantPolicy:     "strict",
+			wantKnownHosts: filepath.Join(withKnownHosts, "known_hosts"),
+		},
+		{
+			name:      "strict without known hosts",
+			env:       []corev1.EnvVar{{Name: "BUILD_SSH_HOST_KEY_POLICY", Value: "strict"}},
+			secretDir: withoutKnownHosts,
+			wantErr:   true,
+		},
+		{
+			name:           "accept-new",
+			env:            []corev1.EnvVar{{Name: "BUILD_SSH_HOST_KEY_POLICY", Value: "accept-new"}},
+			secretDir:      withKnownHosts,
+			wantPolicy:     "accept-new",
+			wantKnownHosts: filepath.Join(withKnownHosts, "known_hosts"),
+		},
+		{
+			name:      "unknown policy",
+			env:       []corev1.EnvVar{{Name: "BUILD_SSH_HOST_KEY_POLICY", Value: "ask"}},
+			secretDir: withKnownHosts,
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			build.Spec.Source.SourceSecret = &corev1.LocalObjectReference{Name: "source"}
+			policy, knownHosts, err := GetSSHHostKeyPolicy(build, test.secretDir)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if policy != test.wantPolicy || knownHosts != test.wantKnownHosts {
+				t.Errorf("expected policy %q and known hosts %q, got %q and %q", test.wantPolicy, test.wantKnownHosts, policy, knownHosts)
+			}
+		})
+	}
+}
+
+func TestUsesGitLFS(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		// links holds symbolic links to create, by path, which the walk
+		// fails on if it reaches them
+		links map[string]string
+		want  bool
+	}{
+		{
+			name: "no attributes",
+		},
+		{
+			name:       "no lfs filter",
+			attributes: map[string]string{".gitattributes": "*.sh text eol=lf\n"},
+		},
+		{
+			name:       "commented lfs filter",
+			attributes: map[string]string{".gitattributes": "# *.bin filter=lfs diff=lfs merge=lfs -text\n"},
+		},
+		{
+			name:       "lfs filter",
+			attributes: map[string]string{".gitattributes": "\n*.bin filter=lfs diff=lfs merge=lfs -text\n"},
+			want:       true,
+		},
+		{
+			name:       "nested lfs filter",
+			attributes: map[string]string{"assets/.gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n"},
+			want:       true,
+		},
+		{
+			name:       "ignored git directory",
+			attributes: map[string]string{".git/info/.gitattributes": "*.bin filter=lfs\n"},
+		},
+		{
+			name:       "stops at the first lfs filter",
+			attributes: map[string]string{"a/.gitattributes": "*.bin filter=lfs\n"},
+			links:      map[string]string{"b/.gitattributes": "missing"},
+			want:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "lfs-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.attributes {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, target := range test.links {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := usesGitLFS(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
 func TestCopyImageSourceFromFilesystem(t *testing.T) {
 
 	testCases := []struct {
@@ -490,3 +925,98 @@ func verifyLink(filename string, expectedSrc string, t *testing.T) {
 		t.Errorf("file %s is not a symlink, mode is: %v", filename, mode)
 	}
 }
+
+func TestNewImageSource(t *testing.T) {
+	digest := "quay.io/openshift/tools@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		image     string
+		forcePull bool
+		expected  buildah.PullPolicy
+		expectErr bool
+	}{
+		{image: "quay.io/openshift/tools:latest", expected: buildah.PullIfMissing},
+		{image: "quay.io/openshift/tools:latest", forcePull: true, expected: buildah.PullAlways},
+		{image: digest, expected: buildah.PullIfMissing},
+		{image: digest, forcePull: true, expected: buildah.PullIfMissing},
+		{image: "quay.io/openshift/tools@sha256:short", expectErr: true},
+	}
+	for _, test := range tests {
+		source, err := newImageSource(NewFakeDockerClient(), test.image, -1, test.forcePull)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.image, err)
+			continue
+		}
+		if err == nil && source.pullPolicy != test.expected {
+			t.Errorf("%s, forcePull %t: expected pull policy %v, got %v", test.image, test.forcePull, test.expected, source.pullPolicy)
+		}
+	}
+}
+
+func TestNewImageSourcePullSecrets(t *testing.T) {
+	root, err := ioutil.TempDir("", "image-source-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	var sources []*imageSource
+	defer func() {
+		for _, source := range sources {
+			source.unmount()
+		}
+	}()
+	// two image sources from the same registry, with different secrets
+	for i, auth := range []string{"Zm9vOmJhcg==", "Zm9vOmJhejI="} {
+		secretDir := filepath.Join(root, fmt.Sprintf("secret%d", i))
+		os.MkdirAll(secretDir, 0700)
+		if err := ioutil.WriteFile(filepath.Join(secretDir, ".dockerconfigjson"), []byte(`{"auths": {"quay.io": {"auth": "`+auth+`"}}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("PULL_SOURCE_DOCKERCFG_PATH_%d", i)
+		os.Setenv(name, secretDir)
+		defer os.Unsetenv(name)
+		source, err := newImageSource(NewFakeDockerClient(), fmt.Sprintf("quay.io/openshift/tools%d:latest", i), i, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+
+	for i, expected := range []string{"bar", "baz2"} {
+		if _, password, err := config.GetAuthentication(&sources[i].systemContext, "quay.io"); err != nil || password != expected {
+			t.Errorf("image source %d: expected the password of its own secret %q, got %q: %v", i, expected, password, err)
+		}
+	}
+	authDir := sources[0].authDir
+	sources[0].unmount()
+	if _, err := os.Stat(authDir); !os.IsNotExist(err) {
+		t.Errorf("expected the auth file to be removed when the source is unmounted: %v", err)
+	}
+}
+
+func TestImageSourceExtract(t *testing.T) {
+	root, err := ioutil.TempDir("", "image-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	mountPath := filepath.Join(root, "mount")
+	buildDir := filepath.Join(root, "build")
+	for name, contents := range map[string]string{"a/app.jar": "a", "b/app.jar": "b"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(mountPath, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(mountPath, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := &imageSource{image: "image", mountPath: mountPath}
+	err = source.extract([]buildapiv1.ImageSourcePath{
+		{SourcePath: "/a/app.jar", DestinationDir: "lib"},
+		{SourcePath: "/b/app.jar", DestinationDir: "lib"},
+	}, buildDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(buildDir, "lib", "app.jar")); err != nil || string(contents) != "b" {
+		t.Errorf("expected the later path to be copied last, got %q: %v", contents, err)
+	}
+}
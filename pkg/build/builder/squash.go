@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// SquashPolicy is which layers of a built image are squashed into one.
+type SquashPolicy string
+
+const (
+	// SquashNone keeps the layers of the image.
+	SquashNone SquashPolicy = "none"
+	// SquashBuild squashes the layers that the build adds onto its base
+	// image into one.
+	SquashBuild SquashPolicy = "build"
+	// SquashAll squashes all of the layers of the image, including those
+	// of its base image, into one.
+	SquashAll SquashPolicy = "all"
+)
+
+// GetSquashPolicy returns which layers of the image built by build are
+// squashed when it is committed.
+func GetSquashPolicy(build *buildapiv1.Build) (SquashPolicy, error) {
+	value, _ := buildStrategyEnv(build, builderutil.Squash)
+	switch policy := SquashPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return SquashNone, nil
+	case SquashNone, SquashBuild, SquashAll:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid %s value %q: must be %q, %q or %q", builderutil.Squash, value, SquashNone, SquashBuild, SquashAll)
+}
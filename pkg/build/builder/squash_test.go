@@ -0,0 +1,28 @@
+package builder
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetSquashPolicy(t *testing.T) {
+	for value, expect := range map[string]SquashPolicy{"": SquashNone, "none": SquashNone, "build": SquashBuild, " All ": SquashAll} {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{
+			Env: []corev1.EnvVar{{Name: "BUILD_SQUASH", Value: value}},
+		}
+		if policy, err := GetSquashPolicy(build); err != nil || policy != expect {
+			t.Errorf("%q: expected %q, got %q, %v", value, expect, policy, err)
+		}
+	}
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_SQUASH", Value: "true"}},
+	}
+	if _, err := GetSquashPolicy(build); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
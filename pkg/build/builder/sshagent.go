@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// sshAgentSocket is the name of the ssh-agent socket within its directory.
+const sshAgentSocket = "agent.sock"
+
+// sshAgent is an ssh-agent process whose socket, in dir, can be made
+// available to the RUN instructions of a build.
+type sshAgent struct {
+	cmd *exec.Cmd
+	dir string
+}
+
+// startSSHAgent starts an ssh-agent holding the private keys in keyFiles.
+func startSSHAgent(keyFiles ...string) (*sshAgent, error) {
+	dir, err := ioutil.TempDir("", "ssh-agent")
+	if err != nil {
+		return nil, err
+	}
+	// RUN instructions may run as any user
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	socket := filepath.Join(dir, sshAgentSocket)
+	agent := &sshAgent{
+		cmd: exec.Command("ssh-agent", "-D", "-a", socket),
+		dir: dir,
+	}
+	if err := agent.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("unable to start ssh-agent: %v", err)
+	}
+
+	// wait for the agent to listen on its socket
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if i == 50 {
+			agent.Stop()
+			return nil, fmt.Errorf("timed out waiting for ssh-agent to start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := os.Chmod(socket, 0666); err != nil {
+		agent.Stop()
+		return nil, err
+	}
+
+	for _, keyFile := range keyFiles {
+		// keys are passed on stdin, since ssh-add refuses key files which
+		// are readable by others, as mounted secrets usually are
+		key, err := os.Open(keyFile)
+		if err != nil {
+			agent.Stop()
+			return nil, err
+		}
+		add := exec.Command("ssh-add", "-")
+		add.Stdin = key
+		add.Env = append(os.Environ(), "SSH_AUTH_SOCK="+socket)
+		out, err := add.CombinedOutput()
+		key.Close()
+		if err != nil {
+			agent.Stop()
+			return nil, fmt.Errorf("unable to add %s to ssh-agent: %v: %s", keyFile, err, string(out))
+		}
+	}
+	log.V(2).Infof("Started ssh-agent on %s with %d keys", socket, len(keyFiles))
+	return agent, nil
+}
+
+// Stop terminates the agent and removes its socket.
+func (a *sshAgent) Stop() {
+	if a.cmd.Process != nil {
+		a.cmd.Process.Kill()
+		a.cmd.Wait()
+	}
+	os.RemoveAll(a.dir)
+}
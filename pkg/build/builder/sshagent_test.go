@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartSSHAgent(t *testing.T) {
+	if _, err := exec.LookPath("ssh-agent"); err != nil {
+		t.Skip("ssh-agent is not available")
+	}
+	dir, err := ioutil.TempDir("", "ssh-agent-test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	key := filepath.Join(dir, "ssh-privatekey")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("unable to generate key: %v: %s", err, string(out))
+	}
+	// mounted secrets are usually readable by others
+	if err := os.Chmod(key, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	agent, err := startSSHAgent(key)
+	if err != nil {
+		t.Fatalf("unable to start agent: %v", err)
+	}
+	list := exec.Command("ssh-add", "-l")
+	list.Env = append(os.Environ(), "SSH_AUTH_SOCK="+filepath.Join(agent.dir, sshAgentSocket))
+	out, err := list.CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to list keys: %v: %s", err, string(out))
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "ED25519") {
+		t.Errorf("expected a single key in the agent, got %s", string(out))
+	}
+
+	agent.Stop()
+	if _, err := os.Stat(agent.dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", agent.dir)
+	}
+}
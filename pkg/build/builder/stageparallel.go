@@ -0,0 +1,218 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	dockercmd "github.com/openshift/imagebuilder/dockerfile/command"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	"github.com/openshift/builder/pkg/build/builder/util/dockerfile"
+)
+
+// stageParallelism is the number of independent stages of a multi-stage
+// Dockerfile which are built at the same time.
+var stageParallelism = 1
+
+// ConfigureStageParallelism sets the number of independent stages of a
+// multi-stage Dockerfile which are built at the same time, if the build
+// strategy's environment requests more than one.
+func ConfigureStageParallelism(build *buildapiv1.Build) error {
+	stageParallelism = 1
+	value, _ := buildStrategyEnv(build, builderutil.StageParallelism)
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("invalid %s value %q: must be a positive integer", builderutil.StageParallelism, value)
+	}
+	stageParallelism = limit
+	return nil
+}
+
+// parallelStage is a stage of a multi-stage Dockerfile which depends on no
+// other stage, and so can be built at the same time as others.
+type parallelStage struct {
+	name string
+	// dockerfile holds the ARGs declared before the first FROM of the
+	// Dockerfile, followed by the instructions of the stage
+	dockerfile []byte
+}
+
+// independentStages returns the stages of the Dockerfile node, up to but not
+// including target, or its last stage if target is empty, which are based on
+// an image rather than an earlier stage and copy from no earlier stage.
+// buildArgs override the ARGs declared before the first FROM when expanding
+// the images of FROM instructions.
+func independentStages(node *parser.Node, buildArgs map[string]string, target string) ([]parallelStage, error) {
+	stages, args, err := dockerfileStages(node, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	last := len(stages) - 1
+	if len(target) > 0 {
+		for i, stage := range stages {
+			if stage.Name == target {
+				last = i
+				break
+			}
+		}
+	}
+	var header []*parser.Node
+	for _, child := range node.Children {
+		if child.Value == dockercmd.From {
+			break
+		}
+		header = append(header, child)
+	}
+
+	earlier := make(map[string]bool)
+	var independent []parallelStage
+	for _, stage := range stages[:last] {
+		dependent := false
+		for _, child := range stage.Node.Children {
+			switch {
+			case child.Value == dockercmd.From && child.Next != nil:
+				dependent = dependent || earlier[expandImageName(child.Next.Value, args)]
+			case child.Value == dockercmd.Copy:
+				if ref, ok := nodeHasFromRef(child); ok {
+					dependent = dependent || earlier[expandImageName(ref, args)]
+				}
+			}
+		}
+		earlier[stage.Name] = true
+		earlier[strconv.Itoa(stage.Position)] = true
+		if dependent {
+			continue
+		}
+		children := append(append([]*parser.Node{}, header...), stage.Node.Children...)
+		independent = append(independent, parallelStage{
+			name:       stage.Name,
+			dockerfile: dockerfile.Write(&parser.Node{Children: children}),
+		})
+	}
+	return independent, nil
+}
+
+// buildStagesInParallel builds each of stages with build, up to workers of
+// them at a time, and returns the first error, if any.  Each line which a
+// stage's build writes is prefixed by the stage's name, and the output of a
+// stage is held back until the stages before it have finished, so that the
+// output written to out is the same however the builds interleave.
+func buildStagesInParallel(stages []parallelStage, workers int, out io.Writer, build func(stage parallelStage, out io.Writer) error) error {
+	names := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		names = append(names, stage.name)
+	}
+	log.V(0).Infof("Building %d independent stages, %d at a time: %s", len(stages), workers, strings.Join(names, ", "))
+
+	output := newOrderedOutput(out, len(stages))
+	errs := make([]error, len(stages))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, stage := range stages {
+		wg.Add(1)
+		go func(i int, stage parallelStage) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			defer output.finish(i)
+			w := &linePrefixWriter{out: output.writer(i), prefix: []byte(fmt.Sprintf("[%s] ", stage.name)), start: true}
+			if errs[i] = build(stage, w); errs[i] != nil {
+				errs[i] = fmt.Errorf("error building stage %s: %v", stage.name, errs[i])
+			}
+		}(i, stage)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderedOutput writes the outputs of a number of concurrent writers to out
+// one after another, in order.  The output of the first writer which has not
+// finished is written as it comes, and that of the others is buffered until
+// it is their turn.
+type orderedOutput struct {
+	lock    sync.Mutex
+	out     io.Writer
+	current int
+	buffers []bytes.Buffer
+	done    []bool
+}
+
+func newOrderedOutput(out io.Writer, count int) *orderedOutput {
+	return &orderedOutput{out: out, buffers: make([]bytes.Buffer, count), done: make([]bool, count)}
+}
+
+// writer returns the writer of the ith output.
+func (o *orderedOutput) writer(i int) io.Writer {
+	return orderedOutputWriter{output: o, index: i}
+}
+
+// finish records that the ith output is complete, and writes the buffered
+// outputs which follow it up to the first which is not.
+func (o *orderedOutput) finish(i int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.done[i] = true
+	for o.current < len(o.done) && o.done[o.current] {
+		o.current++
+		if o.current < len(o.buffers) {
+			o.out.Write(o.buffers[o.current].Bytes())
+			o.buffers[o.current].Reset()
+		}
+	}
+}
+
+type orderedOutputWriter struct {
+	output *orderedOutput
+	index  int
+}
+
+func (w orderedOutputWriter) Write(p []byte) (int, error) {
+	o := w.output
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if w.index == o.current {
+		return o.out.Write(p)
+	}
+	return o.buffers[w.index].Write(p)
+}
+
+// linePrefixWriter writes to out with each line prefixed by prefix.
+type linePrefixWriter struct {
+	out    io.Writer
+	prefix []byte
+	// start is whether the next byte written starts a line
+	start bool
+}
+
+func (w *linePrefixWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if w.start {
+			buf.Write(w.prefix)
+		}
+		buf.Write(line)
+		w.start = line[len(line)-1] == '\n'
+	}
+	if _, err := w.out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
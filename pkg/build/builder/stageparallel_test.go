@@ -0,0 +1,91 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/openshift/imagebuilder"
+)
+
+func TestIndependentStages(t *testing.T) {
+	dockerfile := heredoc.Doc(`
+		ARG GO_IMAGE=golang:1.15
+		FROM ${GO_IMAGE} AS tools
+		RUN go get example.com/tool
+		FROM node:14 AS web
+		RUN npm ci
+		FROM alpine:3.12 AS builder
+		COPY --from=1 /dist /dist
+		FROM alpine:3.12
+		COPY --from=busybox:1.32 /bin/busybox /bin/busybox
+		RUN apk add git
+		FROM tools
+		COPY --from=builder /app /app
+		`)
+	tests := []struct {
+		target string
+		expect []string
+	}{
+		{expect: []string{"tools", "web", "3"}},
+		{target: "builder", expect: []string{"tools", "web"}},
+		{target: "tools"},
+	}
+	for _, test := range tests {
+		node, err := imagebuilder.ParseDockerfile(strings.NewReader(dockerfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages, err := independentStages(node, nil, test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, stage := range stages {
+			names = append(names, stage.name)
+		}
+		if !reflect.DeepEqual(names, test.expect) {
+			t.Errorf("target %q: expected stages %v, got %v", test.target, test.expect, names)
+		}
+		if len(stages) > 0 {
+			if expect := "ARG GO_IMAGE=golang:1.15\nFROM ${GO_IMAGE} AS tools\nRUN go get example.com/tool\n"; string(stages[0].dockerfile) != expect {
+				t.Errorf("target %q: expected Dockerfile %q, got %q", test.target, expect, stages[0].dockerfile)
+			}
+		}
+	}
+}
+
+func TestBuildStagesInParallel(t *testing.T) {
+	stages := []parallelStage{{name: "a"}, {name: "b"}, {name: "c"}}
+	// the later stages finish first, and write in several pieces
+	delays := map[string]time.Duration{"a": 30 * time.Millisecond, "b": 10 * time.Millisecond}
+	var out bytes.Buffer
+	err := buildStagesInParallel(stages, 3, &out, func(stage parallelStage, w io.Writer) error {
+		fmt.Fprintf(w, "STEP 1")
+		time.Sleep(delays[stage.name])
+		fmt.Fprintf(w, " of %s\nSTEP 2\n", stage.name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "[a] STEP 1 of a\n[a] STEP 2\n[b] STEP 1 of b\n[b] STEP 2\n[c] STEP 1 of c\n[c] STEP 2\n"
+	if out.String() != expect {
+		t.Errorf("expected output %q, got %q", expect, out.String())
+	}
+
+	err = buildStagesInParallel(stages, 2, &out, func(stage parallelStage, w io.Writer) error {
+		if stage.name == "b" {
+			return fmt.Errorf("RUN failed")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "error building stage b: RUN failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
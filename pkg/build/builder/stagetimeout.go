@@ -0,0 +1,140 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// StatusReasonStageTimedOut is the reason a build fails when one of its
+// stages runs for longer than the timeout set for it.
+const StatusReasonStageTimedOut buildapiv1.StatusReason = "StageTimedOut"
+
+// timedStages are the stages which BUILD_STAGE_TIMEOUTS may set timeouts for.
+var timedStages = []buildapiv1.StageName{
+	buildapiv1.StageFetchInputs,
+	buildapiv1.StagePullImages,
+	buildapiv1.StageBuild,
+	buildapiv1.StagePostCommit,
+	buildapiv1.StagePushImage,
+}
+
+// stageTimeoutObserver is the name under which stage timeouts observe the
+// stages of the build.
+const stageTimeoutObserver = "stage-timeouts"
+
+// stageTimer times the stage the build is in against the timeout set for it.
+var stageTimer = struct {
+	sync.Mutex
+	timeouts map[buildapiv1.StageName]time.Duration
+	stage    buildapiv1.StageName
+	timer    *time.Timer
+	// timedOut and timeout are the stage which exceeded its timeout, if
+	// one did, and that timeout
+	timedOut buildapiv1.StageName
+	timeout  time.Duration
+}{}
+
+// getStageTimeouts returns the timeouts of the stages listed in the build's
+// BUILD_STAGE_TIMEOUTS, a comma-separated list of stage=duration pairs.
+func getStageTimeouts(build *buildapiv1.Build) (map[buildapiv1.StageName]time.Duration, error) {
+	timeouts := map[buildapiv1.StageName]time.Duration{}
+	value, _ := buildStrategyEnv(build, builderutil.StageTimeouts)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q: must be of the form stage=duration", builderutil.StageTimeouts, pair)
+		}
+		stage := buildapiv1.StageName(strings.TrimSpace(parts[0]))
+		known := false
+		for _, timed := range timedStages {
+			known = known || stage == timed
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid %s entry %q: the stage must be one of %v", builderutil.StageTimeouts, pair, timedStages)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: the timeout must be a positive duration", builderutil.StageTimeouts, pair)
+		}
+		timeouts[stage] = timeout
+	}
+	return timeouts, nil
+}
+
+// ConfigureStageTimeouts arranges for the build to be stopped, and to fail,
+// if any of its stages runs for longer than the timeout the build sets for
+// it.  Unlike the build's completion deadline, which only says that the
+// whole build took too long, the failure names the stage which did.
+func ConfigureStageTimeouts(build *buildapiv1.Build) error {
+	timeouts, err := getStageTimeouts(build)
+	if err != nil {
+		return err
+	}
+	stageTimer.Lock()
+	defer stageTimer.Unlock()
+	stageTimer.timeouts = timeouts
+	if len(timeouts) == 0 {
+		timing.ObserveStages(stageTimeoutObserver, nil)
+		return nil
+	}
+	timing.ObserveStages(stageTimeoutObserver, startStageTimer)
+	return nil
+}
+
+// startStageTimer starts timing stage against its timeout, if it has one,
+// and stops timing the stage the build was in before.
+func startStageTimer(stage buildapiv1.StageName) {
+	stageTimer.Lock()
+	defer stageTimer.Unlock()
+	if stage == stageTimer.stage {
+		return
+	}
+	stageTimer.stage = stage
+	if stageTimer.timer != nil {
+		stageTimer.timer.Stop()
+		stageTimer.timer = nil
+	}
+	timeout, ok := stageTimer.timeouts[stage]
+	if !ok {
+		return
+	}
+	stageTimer.timer = time.AfterFunc(timeout, func() {
+		stageTimer.Lock()
+		if stageTimer.stage != stage {
+			stageTimer.Unlock()
+			return
+		}
+		stageTimer.timedOut, stageTimer.timeout = stage, timeout
+		stageTimer.Unlock()
+		CancelBuild(fmt.Sprintf("the %s stage did not finish within its timeout of %v", stage, timeout))
+	})
+}
+
+// stopStageTimer stops timing the stage the build is in.
+func stopStageTimer() {
+	stageTimer.Lock()
+	defer stageTimer.Unlock()
+	if stageTimer.timer != nil {
+		stageTimer.timer.Stop()
+		stageTimer.timer = nil
+	}
+	stageTimer.stage = ""
+}
+
+// stageTimedOut returns the stage which exceeded its timeout and that
+// timeout, if one did.
+func stageTimedOut() (buildapiv1.StageName, time.Duration) {
+	stageTimer.Lock()
+	defer stageTimer.Unlock()
+	return stageTimer.timedOut, stageTimer.timeout
+}
@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+	utillog "github.com/openshift/builder/pkg/build/builder/util/log"
+)
+
+// resetStageTimeouts undoes the stage timeouts configured by a test.
+func resetStageTimeouts() {
+	stopStageTimer()
+	timing.ObserveStages(stageTimeoutObserver, nil)
+	stageTimer.Lock()
+	defer stageTimer.Unlock()
+	stageTimer.timeouts, stageTimer.timedOut, stageTimer.timeout = nil, "", 0
+}
+
+func TestGetStageTimeouts(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  map[buildapiv1.StageName]time.Duration
+		expectErr bool
+	}{
+		{value: "", expected: map[buildapiv1.StageName]time.Duration{}},
+		{
+			value: "FetchInputs=5m, PushImage = 1h30m",
+			expected: map[buildapiv1.StageName]time.Duration{
+				buildapiv1.StageFetchInputs: 5 * time.Minute,
+				buildapiv1.StagePushImage:   90 * time.Minute,
+			},
+		},
+		{value: "FetchInputs", expectErr: true},
+		{value: "Clone=5m", expectErr: true},
+		{value: "FetchInputs=soon", expectErr: true},
+		{value: "FetchInputs=0s", expectErr: true},
+	}
+	for _, test := range tests {
+		timeouts, err := getStageTimeouts(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_STAGE_TIMEOUTS", Value: test.value}))
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(timeouts, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, timeouts)
+		}
+	}
+}
+
+func TestStageTimeout(t *testing.T) {
+	resetCancellation()
+	defer resetCancellation()
+	defer resetStageTimeouts()
+	defer utillog.SetStage("")
+
+	if err := ConfigureStageTimeouts(testBuildWithEnv(corev1.EnvVar{Name: "BUILD_STAGE_TIMEOUTS", Value: "PullImages=20ms,PushImage=50ms"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the build moves on from PullImages before its timeout
+	timing.SetStage(buildapiv1.StagePullImages)
+	timing.SetStage(buildapiv1.StageBuild)
+	select {
+	case <-BuildContext().Done():
+		t.Fatalf("expected the build not to be stopped by the timeout of a stage it finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	timing.SetStage(buildapiv1.StagePushImage)
+	select {
+	case <-BuildContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the build to be stopped when PushImage exceeded its timeout")
+	}
+	build := &buildapiv1.Build{}
+	if !SetCancelledStatus(build) || build.Status.Phase != buildapiv1.BuildPhaseFailed || build.Status.Reason != StatusReasonStageTimedOut ||
+		build.Status.Message != "The PushImage stage of the build did not finish within its timeout of 50ms." {
+		t.Errorf("unexpected status %#v", build.Status)
+	}
+}
@@ -2,16 +2,17 @@ package builder
 
 import (
 	"bytes"
-	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/docker/distribution/reference"
 	dockerclient "github.com/fsouza/go-dockerclient"
 
 	s2iapi "github.com/openshift/source-to-image/pkg/api"
@@ -134,7 +135,7 @@ func makeVolumeSpec(src localObjectBuildSource, mountPath string) s2iapi.VolumeS
 // and S2I config validator
 func (s *S2IBuilder) Build() error {
 	var err error
-	ctx := timing.NewContext(context.Background())
+	ctx := timing.NewContext(BuildContext())
 	defer func() {
 		s.build.Status.Stages = timing.AppendStageAndStepInfo(s.build.Status.Stages, timing.GetStages(ctx))
 		HandleBuildStatusUpdate(s.build, s.client, nil)
@@ -165,6 +166,29 @@ func (s *S2IBuilder) Build() error {
 	injections := s2iapi.VolumeList{}
 	injections = append(injections, injectSecrets(s.build.Spec.Source.Secrets)...)
 	injections = append(injections, injectConfigMaps(s.build.Spec.Source.ConfigMaps)...)
+	stagedInputs, err := stageInputFiles(s.build)
+	defer func() {
+		for _, dir := range stagedInputs {
+			os.RemoveAll(dir)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	for i := range injections {
+		if staged, ok := stagedInputs[injections[i].Source]; ok {
+			injections[i].Source = staged
+		}
+	}
+
+	imageLabels, err := s2iBuildLabels(s.build, sourceInfo)
+	if err != nil {
+		return err
+	}
+	epoch, err := getSourceDateEpoch(s.build, sourceInfo)
+	if err != nil {
+		return err
+	}
 
 	buildTag := randomBuildTag(s.build.Namespace, s.build.Name)
 	scriptDownloadProxyConfig, err := scriptProxyConfig(s.build)
@@ -182,8 +206,26 @@ func (s *S2IBuilder) Build() error {
 	if s.build.Spec.Strategy.SourceStrategy.Incremental != nil {
 		incremental = *s.build.Spec.Strategy.SourceStrategy.Incremental
 	}
+	incrementalFrom, incrementalSearchPaths, err := getIncrementalFrom(s.build, pushTag)
+	if err != nil {
+		return err
+	}
+	overrides, err := getS2IOverrides(s.build)
+	if err != nil {
+		return err
+	}
 
 	srcDir := InputContentPath
+	chain, err := getChainedStep(s.build, srcDir)
+	if err != nil {
+		return err
+	}
+	// with a chained step, the image built from the source is only the
+	// first of two
+	stepTag := buildTag
+	if chain != nil {
+		stepTag = randomBuildTag(s.build.Namespace, s.build.Name)
+	}
 	contextDir := ""
 	if len(s.build.Spec.Source.ContextDir) != 0 {
 		contextDir = filepath.Clean(s.build.Spec.Source.ContextDir)
@@ -191,11 +233,14 @@ func (s *S2IBuilder) Build() error {
 			contextDir = ""
 		}
 	}
+	if err := applyS2IContextIgnore(s.build, filepath.Join(srcDir, contextDir)); err != nil {
+		return err
+	}
 
 	config := &s2iapi.Config{
 		// Save some processing time by not cleaning up (the container will go away anyway)
 		PreserveWorkingDir: true,
-		WorkingDir:         "/tmp",
+		WorkingDir:         tmpDir,
 		DockerConfig:       &s2iapi.DockerConfig{Endpoint: s.dockerSocket},
 		DockerCfgPath:      os.Getenv(dockercfg.PullAuthType),
 		LabelNamespace:     builderutil.DefaultDockerLabelNamespace,
@@ -205,10 +250,10 @@ func (s *S2IBuilder) Build() error {
 		BuilderImage:       s.build.Spec.Strategy.SourceStrategy.From.Name,
 		BuilderPullPolicy:  s2iapi.PullAlways,
 		Incremental:        incremental,
-		IncrementalFromTag: pushTag,
+		IncrementalFromTag: incrementalFrom,
 
-		Environment: buildEnvVars(s.build, sourceInfo),
-		Labels:      s2iBuildLabels(s.build, sourceInfo),
+		Environment: buildEnvVars(s.build, sourceInfo, epoch),
+		Labels:      imageLabels,
 
 		Source:     &s2igit.URL{URL: url.URL{Path: srcDir}, Type: s2igit.URLTypeLocal},
 		ContextDir: contextDir,
@@ -216,7 +261,7 @@ func (s *S2IBuilder) Build() error {
 		ForceCopy:  true,
 		Injections: injections,
 
-		AsDockerfile: "/tmp/dockercontext/Dockerfile",
+		AsDockerfile: filepath.Join(tmpDir, "dockercontext", "Dockerfile"),
 
 		ScriptDownloadProxyConfig: scriptDownloadProxyConfig,
 		BlockOnBuild:              true,
@@ -224,26 +269,53 @@ func (s *S2IBuilder) Build() error {
 		KeepSymlinks: true,
 	}
 
+	dryRun, err := getDryRun(s.build)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		plan, err := s2iBuildPlan(ctx, s.build, sourceInfo, config, getRuntimeImage(s.build), chain, push, pushTag)
+		if err != nil {
+			return err
+		}
+		return printBuildPlan(os.Stdout, s.build, plan)
+	}
+
 	// If DockerCfgPath is provided in buildapiv1.Config, then attempt to read the
 	// dockercfg file and get the authentication for pulling the images.
 
+	// the builder and runtime images are pulled at the same time
+	var pulls []imagePull
+	searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
 	if s.build.Spec.Strategy.SourceStrategy.ForcePull || !isImagePresent(s.dockerClient, config.BuilderImage) {
+		pulls = append(pulls, imagePull{name: config.BuilderImage, searchPaths: searchPaths})
+	}
+	runtimeImage := getRuntimeImage(s.build)
+	if len(runtimeImage) > 0 && (s.build.Spec.Strategy.SourceStrategy.ForcePull || !isImagePresent(s.dockerClient, runtimeImage)) {
+		pulls = append(pulls, imagePull{name: runtimeImage, searchPaths: searchPaths})
+	}
+	if len(pulls) > 0 {
+		timing.SetStage(buildapiv1.StagePullImages)
 		startTime := metav1.Now()
-		searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType)
-		err = s.pullImage(config.BuilderImage, searchPaths)
+		err = pullImagesInParallel(pulls, s.pullImage)
 		timing.RecordNewStep(ctx, buildapiv1.StagePullImages, buildapiv1.StepPullBaseImage, startTime, metav1.Now())
 		if err != nil {
 			return err
 		}
 	}
 
+	var runtime *s2iRuntime
+	if len(runtimeImage) > 0 {
+		if runtime, err = getS2IRuntime(s.dockerClient, s.build, runtimeImage); err != nil {
+			return err
+		}
+	}
+
 	if config.Incremental {
 		if s.build.Spec.Strategy.SourceStrategy.ForcePull || !isImagePresent(s.dockerClient, config.IncrementalFromTag) {
-			// Per @bparees the dockercfg.PushTypeAuth is needed to use the same credentials/authentication that
-			// we used to push the image previously.
-			searchPaths := dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PushAuthType)
+			timing.SetStage(buildapiv1.StagePullImages)
 			startTime := metav1.Now()
-			err = s.pullImage(config.IncrementalFromTag, searchPaths)
+			err = s.pullImage(config.IncrementalFromTag, incrementalSearchPaths)
 			timing.RecordNewStep(ctx, buildapiv1.StagePullImages, buildapiv1.StepPullInputImage, startTime, metav1.Now())
 			// If there was an error, the incremental image may not exist. Treat the build as a normal s2i build.
 			if err != nil {
@@ -264,7 +336,10 @@ func (s *S2IBuilder) Build() error {
 	if err != nil {
 		return err
 	}
-	if len(assembleUser) > 0 {
+	if len(overrides.AssembleUser) > 0 {
+		log.V(4).Infof("Using assemble user %s", overrides.AssembleUser)
+		config.AssembleUser = overrides.AssembleUser
+	} else if len(assembleUser) > 0 {
 		log.V(4).Infof("Using builder image assemble user %s", assembleUser)
 		config.AssembleUser = assembleUser
 	}
@@ -274,14 +349,21 @@ func (s *S2IBuilder) Build() error {
 		return err
 	}
 	destination := labels[s2iconstants.DestinationLabel]
-	if len(destination) > 0 {
+	if len(overrides.Destination) > 0 {
+		log.V(4).Infof("Using destination %s", overrides.Destination)
+		config.Destination = overrides.Destination
+	} else if len(destination) > 0 {
 		log.V(4).Infof("Using builder image destination %s", destination)
 		config.Destination = destination
 	}
+	if len(overrides.ScriptsURL) > 0 {
+		log.V(4).Infof("Using scripts URL %s", overrides.ScriptsURL)
+		config.ScriptsURL = overrides.ScriptsURL
+	}
 	if len(config.ScriptsURL) == 0 {
 		scriptsURL := labels[s2iconstants.ScriptsURLLabel]
 		if len(scriptsURL) > 0 {
-			log.V(4).Infof("Using builder scripts URL %s", destination)
+			log.V(4).Infof("Using builder scripts URL %s", scriptsURL)
 			config.ImageScriptsURL = scriptsURL
 		}
 	}
@@ -347,14 +429,14 @@ func (s *S2IBuilder) Build() error {
 
 	opts := dockerclient.BuildImageOptions{
 		Context:             ctx,
-		Name:                buildTag,
+		Name:                stepTag,
 		RmTmpContainer:      true,
 		ForceRmTmpContainer: true,
 		OutputStream:        os.Stdout,
 		Dockerfile:          defaultDockerfilePath,
 		NoCache:             false,
-		Pull:                s.build.Spec.Strategy.SourceStrategy.ForcePull,
-		ContextDir:          "/tmp/dockercontext",
+		Pull:                s.build.Spec.Strategy.SourceStrategy.ForcePull && !imageMirrorsConfigured(),
+		ContextDir:          filepath.Join(tmpDir, "dockercontext"),
 	}
 
 	if s.cgLimits != nil {
@@ -377,6 +459,29 @@ func (s *S2IBuilder) Build() error {
 		opts.AuthConfigs = *pullAuthConfigs
 	}
 
+	var mounts []BuildMount
+	caBundle, removeCABundle, err := getCABundleMount(s.build)
+	if err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageGenericBuildFailed
+		return err
+	}
+	defer removeCABundle()
+	if caBundle != nil {
+		mounts = append(mounts, *caBundle)
+	}
+	mounts = append(mounts, getEntitlementMounts(s.build)...)
+	volumes, err := getBuildVolumeMounts(s.build)
+	if err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageGenericBuildFailed
+		return err
+	}
+	mounts = append(mounts, volumes...)
+
+	timing.SetStage(buildapiv1.StageBuild)
 	startTime := metav1.Now()
 	if _, err := os.Stat(config.AsDockerfile); !os.IsNotExist(err) {
 		in, err := ioutil.ReadFile(config.AsDockerfile)
@@ -387,16 +492,29 @@ func (s *S2IBuilder) Build() error {
 		if err != nil {
 			return err
 		}
-		// Append post commit
-		if err := appendPostCommit(node, buildPostCommit(s.build.Spec.PostCommit)); err != nil {
-			return err
+		if runtime != nil {
+			if err := appendRuntimeStage(node, runtime); err != nil {
+				return err
+			}
 		}
 		out := dockerfile.Write(node)
 		log.V(4).Infof("Replacing dockerfile\n%s\nwith:\n%s", string(in), string(out))
 		overwriteFile(config.AsDockerfile, out)
 	}
 	// TODO pass ImageOptimization policy to the build?
-	err = s.dockerClient.BuildImage(opts)
+	if len(mounts) > 0 {
+		builder, ok := s.dockerClient.(mountingBuilder)
+		if !ok {
+			err = fmt.Errorf("mounting a CA bundle, an entitlement or build volumes is not supported by this build client")
+		} else {
+			err = builder.BuildImageWithMounts(opts, mounts)
+		}
+	} else {
+		err = s.dockerClient.BuildImage(opts)
+	}
+	if err == nil && epoch != nil {
+		err = makeImageReproducible(s.dockerClient, stepTag, *epoch)
+	}
 	timing.RecordNewStep(ctx, buildapiv1.StageBuild, buildapiv1.StepDockerBuild, startTime, metav1.Now())
 	if err != nil {
 		// TODO: Create new error states
@@ -405,6 +523,60 @@ func (s *S2IBuilder) Build() error {
 		s.build.Status.Message = builderutil.StatusMessageGenericBuildFailed
 		return err
 	}
+	if chain != nil {
+		startTime = metav1.Now()
+		err = buildChainedStep(ctx, s.dockerClient, s.build, chain, stepTag, buildTag, s.cgLimits)
+		timing.RecordNewStep(ctx, buildapiv1.StageBuild, buildapiv1.StepDockerBuild, startTime, metav1.Now())
+		if removeErr := removeImage(s.dockerClient, stepTag); removeErr != nil {
+			log.V(0).Infof("warning: Failed to remove the image of the first step %v: %v", stepTag, removeErr)
+		}
+		if err != nil {
+			s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			s.build.Status.Message = builderutil.StatusMessageChainedBuildFailed
+			return err
+		}
+	}
+	if err = runPostCommitHook(ctx, s.dockerClient, s.build, buildTag, s.cgLimits); err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonPostCommitHookFailed
+		s.build.Status.Message = builderutil.StatusMessagePostCommitHookFailed
+		return err
+	}
+	if err = runTestStage(ctx, s.dockerClient, s.build, buildTag, s.cgLimits); err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageTestStageFailed
+		return err
+	}
+	if err = checkImageSizeBudget(s.dockerClient, s.build, buildTag); err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageImageSizeBudgetExceeded
+		return err
+	}
+	if push {
+		reportLayerDiff(s.dockerClient, s.client, s.build, buildTag, pushTag)
+	}
+	if err = scanImage(s.dockerClient, s.build, buildTag); err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageVulnerabilityScanFailed
+		return err
+	}
+	if err = extractImageArtifacts(s.dockerClient, s.build, buildTag, ""); err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageExtractArtifactsFailed
+		return err
+	}
+	sbom, err := generateImageSBOM(s.dockerClient, s.build, buildTag)
+	if err != nil {
+		s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+		s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+		s.build.Status.Message = builderutil.StatusMessageGenerateSBOMFailed
+		return err
+	}
 	if push {
 		if err = tagImage(s.dockerClient, buildTag, pushTag); err != nil {
 			return err
@@ -419,6 +591,28 @@ func (s *S2IBuilder) Build() error {
 		} else {
 			log.V(3).Infof("No push secret provided")
 		}
+		signer, err := getProvenanceSigner(s.build)
+		if err != nil {
+			s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			s.build.Status.Message = builderutil.StatusMessageSignProvenanceFailed
+			return err
+		}
+		signing, err := getImageSigning(s.build)
+		if err != nil {
+			s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+			s.build.Status.Reason = buildapiv1.StatusReasonGenericBuildFailed
+			s.build.Status.Message = builderutil.StatusMessageSignImageFailed
+			return err
+		}
+		outputs, err := getAdditionalOutputs(s.build, pushTag)
+		if err != nil {
+			return err
+		}
+		if err := RunBuildHook(ctx, s.dockerClient, s.build, s.cgLimits, HookPrePush, "OUTPUT_IMAGE="+pushTag); err != nil {
+			return err
+		}
+		timing.SetStage(buildapiv1.StagePushImage)
 		log.V(0).Infof("\nPushing image %s ...", pushTag)
 		startTime := metav1.Now()
 		digest, err := s.pushImage(pushTag, pushAuthConfig)
@@ -439,11 +633,130 @@ func (s *S2IBuilder) Build() error {
 			}
 			HandleBuildStatusUpdate(s.build, s.client, nil)
 		}
+
+		if len(outputs) > 0 {
+			startTime = metav1.Now()
+			_, err := pushAdditionalOutputs(s.dockerClient, pushTag, outputs, s.pushImage)
+
+			timing.RecordNewStep(ctx, buildapiv1.StagePushImage, buildapiv1.StepPushImage, startTime, metav1.Now())
+
+			if err != nil {
+				s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				s.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				s.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				HandleBuildStatusUpdate(s.build, s.client, nil)
+				return err
+			}
+		}
 		log.V(0).Infof("Push successful")
+
+		if sbom != nil && len(digest) > 0 {
+			if err := attachImageSBOM(s.dockerClient, pushTag, digest, sbom, pushAuthConfig); err != nil {
+				s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				s.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				s.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				return err
+			}
+		}
+		if signer != nil && len(digest) > 0 {
+			baseImages := []string{s.build.Spec.Strategy.SourceStrategy.From.Name}
+			if runtime != nil {
+				baseImages = append(baseImages, runtime.Image)
+			}
+			if err := attachImageProvenance(s.dockerClient, signer, s.build, pushTag, digest, baseImages, pushAuthConfig); err != nil {
+				s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				s.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				s.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				return err
+			}
+		}
+		if signing != nil && len(digest) > 0 {
+			if err := signImage(s.dockerClient, signing, pushTag, digest, pushAuthConfig); err != nil {
+				s.build.Status.Phase = buildapiv1.BuildPhaseFailed
+				s.build.Status.Reason = buildapiv1.StatusReasonPushImageToRegistryFailed
+				s.build.Status.Message = builderutil.StatusMessagePushImageToRegistryFailed
+				return err
+			}
+		}
+		if err := RunBuildHook(ctx, s.dockerClient, s.build, s.cgLimits, HookPostPush, "OUTPUT_IMAGE="+pushTag, "OUTPUT_IMAGE_DIGEST="+digest); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// getIncrementalFrom returns the image whose artifacts an incremental build
+// reuses, and the paths searched for the credentials to pull it.  That is the
+// build's output, pulled with the credentials which pushed it, unless the
+// build strategy's environment names another image, pulled with the build's
+// pull secret or with the credentials in the named build input secret.
+func getIncrementalFrom(build *buildapiv1.Build, pushTag string) (string, []string, error) {
+	image, _ := buildStrategyEnv(build, builderutil.IncrementalFrom)
+	if image = strings.TrimSpace(image); len(image) == 0 {
+		// Per @bparees the dockercfg.PushTypeAuth is needed to use the same credentials/authentication that
+		// we used to push the image previously.
+		return pushTag, dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PushAuthType), nil
+	}
+	if _, err := reference.ParseNormalizedNamed(image); err != nil {
+		return "", nil, fmt.Errorf("invalid %s value %q: %v", builderutil.IncrementalFrom, image, err)
+	}
+	name, _ := buildStrategyEnv(build, builderutil.IncrementalFromSecret)
+	if name = strings.TrimSpace(name); len(name) == 0 {
+		return image, dockercfg.NewHelper().GetDockerAuthSearchPaths(dockercfg.PullAuthType), nil
+	}
+	dir, ok := inputSecretDir(build, name)
+	if !ok {
+		return "", nil, fmt.Errorf("incremental image pull secret %q is not a build input secret", name)
+	}
+	return image, []string{dir}, nil
+}
+
+// s2iOverrides are settings of the S2I request which the build strategy's
+// environment makes in place of those of the builder image and the build's
+// scripts.
+type s2iOverrides struct {
+	// AssembleUser runs assemble in place of the builder image's
+	// assemble-user label or default user
+	AssembleUser string
+	// ScriptsURL is where the S2I scripts are fetched from, in place of the
+	// build's scripts or the builder image's scripts-url label
+	ScriptsURL string
+	// Destination is the directory into which sources and scripts are
+	// copied, in place of the builder image's destination label
+	Destination string
+}
+
+// getS2IOverrides returns the settings of the S2I request which the build
+// strategy's environment overrides.
+func getS2IOverrides(build *buildapiv1.Build) (*s2iOverrides, error) {
+	overrides := &s2iOverrides{}
+	if user, _ := buildStrategyEnv(build, builderutil.AssembleUser); len(user) > 0 {
+		if strings.ContainsAny(user, " \t\n") {
+			return nil, fmt.Errorf("invalid %s value %q: must be a user name or UID, optionally followed by :group", builderutil.AssembleUser, user)
+		}
+		overrides.AssembleUser = user
+	}
+	if scriptsURL, _ := buildStrategyEnv(build, builderutil.ScriptsURL); len(scriptsURL) > 0 {
+		u, err := url.Parse(scriptsURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", builderutil.ScriptsURL, scriptsURL, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "file", "image":
+		default:
+			return nil, fmt.Errorf("invalid %s value %q: the scheme must be http, https, file or image", builderutil.ScriptsURL, scriptsURL)
+		}
+		overrides.ScriptsURL = scriptsURL
+	}
+	if destination, _ := buildStrategyEnv(build, builderutil.S2IDestination); len(destination) > 0 {
+		if !path.IsAbs(destination) {
+			return nil, fmt.Errorf("invalid %s value %q: must be an absolute path", builderutil.S2IDestination, destination)
+		}
+		overrides.Destination = path.Clean(destination)
+	}
+	return overrides, nil
+}
+
 // setupPullSecret provides a Docker authentication configuration when the
 // PullSecret is specified.
 func (s *S2IBuilder) setupPullSecret() (*dockerclient.AuthConfigurations, error) {
@@ -465,18 +778,20 @@ func (s *S2IBuilder) setupPullSecret() (*dockerclient.AuthConfigurations, error)
 
 func (s *S2IBuilder) pullImage(name string, searchPaths []string) error {
 	log.V(2).Infof("Explicitly pulling image %s", name)
-	repository, tag := dockerclient.ParseRepositoryTag(name)
-	options := dockerclient.PullImageOptions{
-		Repository: repository,
-		Tag:        tag,
-	}
+	return pullImageFromMirrors(s.dockerClient, name, func(name string) error {
+		repository, tag := dockerclient.ParseRepositoryTag(name)
+		options := dockerclient.PullImageOptions{
+			Repository: repository,
+			Tag:        tag,
+		}
 
-	if options.Tag == "" && strings.Contains(name, "@") {
-		options.Repository = name
-	}
+		if options.Tag == "" && strings.Contains(name, "@") {
+			options.Repository = name
+		}
 
-	return retryImageAction("Pull", func() (pullErr error) {
-		return s.dockerClient.PullImage(options, searchPaths)
+		return retryImageAction("Pull", func() (pullErr error) {
+			return s.dockerClient.PullImage(options, searchPaths)
+		})
 	})
 }
 
@@ -493,7 +808,9 @@ func (s *S2IBuilder) pushImage(name string, authConfig dockerclient.AuthConfigur
 	var err error
 	sha := ""
 	retryImageAction("Push", func() (pushErr error) {
-		sha, err = s.dockerClient.PushImage(options, authConfig)
+		sha, err = pushWithRefreshedAuth(name, authConfig, func(authConfig dockerclient.AuthConfiguration) (string, error) {
+			return s.dockerClient.PushImage(options, authConfig)
+		})
 		return err
 	})
 	return sha, err
@@ -507,18 +824,23 @@ func (s *S2IBuilder) pushImage(name string, authConfig dockerclient.AuthConfigur
 // 2. In case of repeated Keys, the last Value takes precedence right here,
 //    instead of deferring what to do with repeated environment variables to the
 //    Docker runtime.
-func buildEnvVars(build *buildapiv1.Build, sourceInfo *git.SourceInfo) s2iapi.EnvironmentList {
+func buildEnvVars(build *buildapiv1.Build, sourceInfo *git.SourceInfo, epoch *time.Time) s2iapi.EnvironmentList {
 	bi := buildInfo(build, sourceInfo)
 	envVars := &s2iapi.EnvironmentList{}
 	for _, item := range bi {
 		envVars.Set(fmt.Sprintf("%s=%s", item.Key, item.Value))
 	}
+	if epoch != nil {
+		if _, ok := buildStrategyEnv(build, sourceDateEpochEnv); !ok {
+			envVars.Set(fmt.Sprintf("%s=%d", sourceDateEpochEnv, epoch.Unix()))
+		}
+	}
 	return *envVars
 }
 
 // s2iBuildLabels returns a slice of KeyValue pairs in a format that appendLabel can
 // consume.
-func s2iBuildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) map[string]string {
+func s2iBuildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) (map[string]string, error) {
 	labels := map[string]string{}
 	if sourceInfo == nil {
 		sourceInfo = &git.SourceInfo{}
@@ -535,12 +857,22 @@ func s2iBuildLabels(build *buildapiv1.Build, sourceInfo *git.SourceInfo) map[str
 		// differ from git's view (see PotentialPRRetryAsFetch for details).
 		labels[builderutil.DefaultDockerLabelNamespace+"build.commit.ref"] = build.Spec.Source.Git.Ref
 	}
+	addRevisionLabels(labels, readRevisionDetails())
+
+	data := newLabelTemplateData(build, sourceInfo)
+	for k, v := range ociSourceLabels(data) {
+		labels[k] = v
+	}
+	imageLabels, err := expandImageLabels(build, data)
+	if err != nil {
+		return nil, err
+	}
 
 	// override autogenerated labels
-	for _, lbl := range build.Spec.Output.ImageLabels {
+	for _, lbl := range imageLabels {
 		labels[lbl.Name] = lbl.Value
 	}
-	return labels
+	return labels, nil
 }
 
 // scriptProxyConfig determines a proxy configuration for downloading
@@ -256,9 +256,11 @@ func TestBuildEnvVars(t *testing.T) {
 		},
 	}
 	expectedLabelMap := map[string]string{
-		"io.openshift.build.commit.id": "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee",
-		"io.openshift.build.name":      "openshift-test-1-build",
-		"io.openshift.build.namespace": "openshift-demo",
+		"io.openshift.build.commit.id":      "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee",
+		"io.openshift.build.name":           "openshift-test-1-build",
+		"io.openshift.build.namespace":      "openshift-demo",
+		"org.opencontainers.image.revision": "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee",
+		"org.opencontainers.image.source":   "http://localhost/123",
 	}
 
 	mockBuild := makeBuild()
@@ -267,12 +269,15 @@ func TestBuildEnvVars(t *testing.T) {
 	mockBuild.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "http://localhost/123"}
 	sourceInfo := &git.SourceInfo{}
 	sourceInfo.CommitID = "1575a90c569a7cc0eea84fbd3304d9df37c9f5ee"
-	resultedEnvList := buildEnvVars(mockBuild, sourceInfo)
+	resultedEnvList := buildEnvVars(mockBuild, sourceInfo, nil)
 	if !reflect.DeepEqual(expectedEnvList, resultedEnvList) {
 		t.Errorf("Expected EnvironmentList to match:\n%#v\ngot:\n%#v", expectedEnvList, resultedEnvList)
 	}
 
-	resultedLabelList := buildLabels(mockBuild, sourceInfo)
+	resultedLabelList, err := buildLabels(mockBuild, sourceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
 	resultedLabelMap := map[string]string{}
 	for _, label := range resultedLabelList {
 		resultedLabelMap[label.Key] = label.Value
@@ -336,6 +341,124 @@ func TestIncrementalPullError(t *testing.T) {
 	}
 }
 
+func TestGetIncrementalFrom(t *testing.T) {
+	defer os.Setenv("PUSH_DOCKERCFG_PATH", os.Getenv("PUSH_DOCKERCFG_PATH"))
+	defer os.Setenv("PULL_DOCKERCFG_PATH", os.Getenv("PULL_DOCKERCFG_PATH"))
+	os.Setenv("PUSH_DOCKERCFG_PATH", "/var/run/secrets/push")
+	os.Setenv("PULL_DOCKERCFG_PATH", "/var/run/secrets/pull")
+
+	testCases := []struct {
+		name           string
+		env            []corev1.EnvVar
+		expectedImage  string
+		expectedSearch []string
+		expectErr      bool
+	}{
+		{
+			name:           "output",
+			expectedImage:  "registry.example.com/ns/app:latest",
+			expectedSearch: []string{"/var/run/secrets/push"},
+		},
+		{
+			name:           "image",
+			env:            []corev1.EnvVar{{Name: "BUILD_INCREMENTAL_FROM", Value: "central.example.com/ci/app:latest"}},
+			expectedImage:  "central.example.com/ci/app:latest",
+			expectedSearch: []string{"/var/run/secrets/pull"},
+		},
+		{
+			name: "image with secret",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_INCREMENTAL_FROM", Value: "central.example.com/ci/app:latest"},
+				{Name: "BUILD_INCREMENTAL_FROM_SECRET", Value: "central"},
+			},
+			expectedImage:  "central.example.com/ci/app:latest",
+			expectedSearch: []string{"/var/run/secrets/openshift.io/build/central"},
+		},
+		{
+			name: "secret which is not an input",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_INCREMENTAL_FROM", Value: "central.example.com/ci/app:latest"},
+				{Name: "BUILD_INCREMENTAL_FROM_SECRET", Value: "other"},
+			},
+			expectErr: true,
+		},
+		{
+			name:      "invalid image",
+			env:       []corev1.EnvVar{{Name: "BUILD_INCREMENTAL_FROM", Value: "Not/A/Reference"}},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{{Secret: corev1.LocalObjectReference{Name: "central"}}}
+			build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{Env: tc.env}
+			image, searchPaths, err := getIncrementalFrom(build, "registry.example.com/ns/app:latest")
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if image != tc.expectedImage || !reflect.DeepEqual(searchPaths, tc.expectedSearch) {
+				t.Errorf("expected %s from %v, got %s from %v", tc.expectedImage, tc.expectedSearch, image, searchPaths)
+			}
+		})
+	}
+}
+
+func TestGetS2IOverrides(t *testing.T) {
+	testCases := []struct {
+		name      string
+		env       []corev1.EnvVar
+		expected  *s2iOverrides
+		expectErr bool
+	}{
+		{
+			name:     "none",
+			expected: &s2iOverrides{},
+		},
+		{
+			name: "all",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_ASSEMBLE_USER", Value: "1002:0"},
+				{Name: "BUILD_SCRIPTS_URL", Value: "https://artifacts.example.com/s2i/ruby"},
+				{Name: "BUILD_S2I_DESTINATION", Value: "/opt/app-root/"},
+			},
+			expected: &s2iOverrides{
+				AssembleUser: "1002:0",
+				ScriptsURL:   "https://artifacts.example.com/s2i/ruby",
+				Destination:  "/opt/app-root",
+			},
+		},
+		{
+			name:      "invalid user",
+			env:       []corev1.EnvVar{{Name: "BUILD_ASSEMBLE_USER", Value: "app user"}},
+			expectErr: true,
+		},
+		{
+			name:      "unsupported scripts URL",
+			env:       []corev1.EnvVar{{Name: "BUILD_SCRIPTS_URL", Value: "ftp://artifacts.example.com/s2i"}},
+			expectErr: true,
+		},
+		{
+			name:      "relative destination",
+			env:       []corev1.EnvVar{{Name: "BUILD_S2I_DESTINATION", Value: "tmp"}},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{Env: tc.env}
+			overrides, err := getS2IOverrides(build)
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(overrides, tc.expected) {
+				t.Errorf("expected %#v, got %#v", tc.expected, overrides)
+			}
+		})
+	}
+}
+
 func TestGetAssembleUser(t *testing.T) {
 	testCases := []struct {
 		name              string
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containers/storage"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+	buildclientv1 "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
+)
+
+// storageEnv returns the value of the storage setting name in the build
+// strategy's environment, or else in the builder pod's, and whether either
+// sets it.
+func storageEnv(build *buildapiv1.Build, name string) (string, bool) {
+	if value, ok := buildStrategyEnv(build, name); ok && len(value) > 0 {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// requestedStorageDriver returns the storage driver which the build, or the
+// builder pod, asks for, if either does.
+func requestedStorageDriver(build *buildapiv1.Build) (string, bool) {
+	driver, ok := storageEnv(build, builderutil.StorageDriver)
+	return strings.ToLower(strings.TrimSpace(driver)), ok
+}
+
+// ConfigureStorage applies the storage driver and driver options which the
+// build strategy's environment, or else the builder pod's, asks for to
+// storeOptions.  "overlay" mounts layers with the kernel's overlay
+// filesystem, "fuse-overlayfs" with fuse-overlayfs, and "vfs" copies them;
+// other drivers are used as they are named.  Options for another driver
+// than the one chosen are dropped, as the driver would reject them.
+func ConfigureStorage(build *buildapiv1.Build, storeOptions *storage.StoreOptions) error {
+	if value, ok := storageEnv(build, builderutil.StorageOptions); ok {
+		var options []string
+		if err := json.Unmarshal([]byte(value), &options); err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", builderutil.StorageOptions, value, err)
+		}
+		storeOptions.GraphDriverOptions = options
+	}
+	driver, ok := requestedStorageDriver(build)
+	if !ok {
+		return nil
+	}
+	switch driver {
+	case "fuse-overlayfs":
+		path, err := exec.LookPath("fuse-overlayfs")
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", builderutil.StorageDriver, driver, err)
+		}
+		storeOptions.GraphDriverOptions = append(storageDriverOptions(storeOptions.GraphDriverOptions, "overlay"), "overlay.mount_program="+path)
+		storeOptions.GraphDriverName = "overlay"
+	case "overlay":
+		storeOptions.GraphDriverOptions = storageDriverOptions(storeOptions.GraphDriverOptions, driver)
+		storeOptions.GraphDriverName = driver
+	default:
+		if driver != storeOptions.GraphDriverName {
+			storeOptions.GraphDriverOptions = storageDriverOptions(storeOptions.GraphDriverOptions, driver)
+		}
+		storeOptions.GraphDriverName = driver
+	}
+	log.V(2).Infof("Using the %s storage driver with options %v", storeOptions.GraphDriverName, storeOptions.GraphDriverOptions)
+	return nil
+}
+
+// storageDriverOptions returns those of options which are for driver, and
+// which do not name the program which mounts its layers, so that the kernel
+// mounts them.
+func storageDriverOptions(options []string, driver string) []string {
+	var kept []string
+	for _, option := range options {
+		key := strings.ToLower(strings.SplitN(option, "=", 2)[0])
+		i := strings.Index(key, ".")
+		if i < 0 || (i > 0 && key[:i] != driver) || strings.HasSuffix(key, ".mount_program") {
+			continue
+		}
+		kept = append(kept, option)
+	}
+	return kept
+}
+
+// ReportVFSFallback warns, in the log and in the build's status message,
+// when the build's storage ended up using the vfs driver without asking
+// for it.  The vfs driver copies every layer in full, which makes builds
+// several times slower than with overlay.
+func ReportVFSFallback(build *buildapiv1.Build, client buildclientv1.BuildInterface, driver string) {
+	if requested, _ := requestedStorageDriver(build); driver != "vfs" || requested == "vfs" {
+		return
+	}
+	log.V(0).Infof("warning: Container storage is using the vfs storage driver, which copies every layer in full and makes builds several times slower.")
+	log.V(0).Infof("warning: Overlay storage was unavailable; set %s to choose a storage driver, or %s=vfs to acknowledge this one.", builderutil.StorageDriver, builderutil.StorageDriver)
+	build.Status.Message = builderutil.StatusMessageVFSStorageFallback
+	if client == nil {
+		return
+	}
+	latest, err := client.Get(build.Name, metav1.GetOptions{})
+	if err != nil {
+		log.V(4).Infof("Unable to get build %s to record the storage driver: %v", build.Name, err)
+		return
+	}
+	latest.Status.Message = build.Status.Message
+	if _, err := client.UpdateDetails(latest.Name, latest); err != nil {
+		log.V(4).Infof("Unable to record the storage driver in build %s: %v", build.Name, err)
+	}
+}
@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containers/storage"
+
+	buildfake "github.com/openshift/client-go/build/clientset/versioned/fake"
+)
+
+func TestConfigureStorage(t *testing.T) {
+	defer os.Setenv("BUILD_STORAGE_DRIVER", os.Getenv("BUILD_STORAGE_DRIVER"))
+	os.Setenv("BUILD_STORAGE_DRIVER", "overlay")
+	defaults := storage.StoreOptions{
+		GraphDriverName:    "overlay",
+		GraphDriverOptions: []string{"overlay.mountopt=nodev", "overlay.mount_program=/usr/bin/fuse-overlayfs", ".imagestore=/var/lib/shared"},
+	}
+
+	tests := []struct {
+		name            string
+		env             []corev1.EnvVar
+		expectedDriver  string
+		expectedOptions []string
+		expectErr       bool
+	}{
+		{
+			name:            "the pod's native overlay",
+			expectedDriver:  "overlay",
+			expectedOptions: []string{"overlay.mountopt=nodev", ".imagestore=/var/lib/shared"},
+		},
+		{
+			name:            "the build's vfs",
+			env:             []corev1.EnvVar{{Name: "BUILD_STORAGE_DRIVER", Value: "VFS"}},
+			expectedDriver:  "vfs",
+			expectedOptions: []string{".imagestore=/var/lib/shared"},
+		},
+		{
+			name: "the build's options",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_STORAGE_DRIVER", Value: "vfs"},
+				{Name: "BUILD_STORAGE_OPTIONS", Value: `["vfs.ignore_chown_errors=true"]`},
+			},
+			expectedDriver:  "vfs",
+			expectedOptions: []string{"vfs.ignore_chown_errors=true"},
+		},
+		{
+			name:      "invalid options",
+			env:       []corev1.EnvVar{{Name: "BUILD_STORAGE_OPTIONS", Value: "overlay.mountopt=nodev"}},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		options := defaults
+		options.GraphDriverOptions = append([]string{}, defaults.GraphDriverOptions...)
+		err := ConfigureStorage(testBuildWithEnv(test.env...), &options)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if options.GraphDriverName != test.expectedDriver || !reflect.DeepEqual(options.GraphDriverOptions, test.expectedOptions) {
+			t.Errorf("%s: expected %s with %v, got %s with %v", test.name, test.expectedDriver, test.expectedOptions, options.GraphDriverName, options.GraphDriverOptions)
+		}
+	}
+}
+
+func TestReportVFSFallback(t *testing.T) {
+	defer os.Setenv("BUILD_STORAGE_DRIVER", os.Getenv("BUILD_STORAGE_DRIVER"))
+	os.Unsetenv("BUILD_STORAGE_DRIVER")
+
+	build := testBuildWithEnv()
+	client := buildfake.NewSimpleClientset(build.DeepCopy()).BuildV1().Builds("ns")
+	ReportVFSFallback(build, client, "overlay")
+	if build.Status.Message != "" {
+		t.Errorf("expected no warning with overlay storage, got %q", build.Status.Message)
+	}
+
+	acknowledged := testBuildWithEnv(corev1.EnvVar{Name: "BUILD_STORAGE_DRIVER", Value: "vfs"})
+	ReportVFSFallback(acknowledged, client, "vfs")
+	if acknowledged.Status.Message != "" {
+		t.Errorf("expected no warning when the build asked for vfs storage, got %q", acknowledged.Status.Message)
+	}
+
+	ReportVFSFallback(build, client, "vfs")
+	latest, err := client.Get("app-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if build.Status.Message != "The build used the vfs storage driver, which is much slower than overlay; see the build log." || latest.Status.Message != build.Status.Message {
+		t.Errorf("expected the fallback to vfs in the build's status message, got %q and %q", build.Status.Message, latest.Status.Message)
+	}
+}
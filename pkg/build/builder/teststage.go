@@ -0,0 +1,202 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// testStage is a command which tests the built image, and where the reports
+// it writes are collected from and to.
+type testStage struct {
+	Command string
+	// ResultsDir is the directory of the container in which the command
+	// writes its reports, if any
+	ResultsDir string
+	// Output is the directory of the build pod to which the reports are
+	// copied, or else they are summarized in the termination message
+	Output string
+}
+
+// junitSuites is the part of a JUnit XML report, whose root is either a
+// testsuites or a testsuite element, that is summarized.
+type junitSuites struct {
+	XMLName  xml.Name
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Errors   int           `xml:"errors,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+	Suites   []junitSuites `xml:"testsuite"`
+}
+
+// totals returns the counts of s, adding up those of its suites when it is a
+// testsuites element without its own counts.
+func (s junitSuites) totals() (tests, failures, errors, skipped int) {
+	if s.Tests > 0 || len(s.Suites) == 0 {
+		return s.Tests, s.Failures, s.Errors, s.Skipped
+	}
+	for _, suite := range s.Suites {
+		t, f, e, sk := suite.totals()
+		tests, failures, errors, skipped = tests+t, failures+f, errors+e, skipped+sk
+	}
+	return tests, failures, errors, skipped
+}
+
+// getTestStage returns the test stage requested by the build strategy's
+// environment, if any.
+func getTestStage(build *buildapiv1.Build) (*testStage, error) {
+	command, _ := buildStrategyEnv(build, builderutil.TestCommand)
+	if len(strings.TrimSpace(command)) == 0 {
+		return nil, nil
+	}
+	stage := &testStage{Command: command}
+	if dir, _ := buildStrategyEnv(build, builderutil.TestResultsDir); len(dir) > 0 {
+		if !path.IsAbs(dir) || path.Clean(dir) == "/" {
+			return nil, fmt.Errorf("invalid %s value %q: it must be an absolute path other than /", builderutil.TestResultsDir, dir)
+		}
+		stage.ResultsDir = path.Clean(dir)
+	}
+	if output, _ := buildStrategyEnv(build, builderutil.TestResultsOutput); len(output) > 0 {
+		if len(stage.ResultsDir) == 0 {
+			return nil, fmt.Errorf("%s requires %s", builderutil.TestResultsOutput, builderutil.TestResultsDir)
+		}
+		if !filepath.IsAbs(output) {
+			return nil, fmt.Errorf("invalid %s value %q: it must be an absolute path", builderutil.TestResultsOutput, output)
+		}
+		stage.Output = output
+	}
+	return stage, nil
+}
+
+// runTestStage runs the test stage requested for the build, if any, in a
+// container of image, the image which was just built, with the build's
+// resource limits.  The reports the tests write are collected whether or not
+// they pass, and it fails if the tests exit with a non-zero status.
+func runTestStage(ctx context.Context, client DockerClient, build *buildapiv1.Build, image string, cgLimits *s2iapi.CGroupLimits) error {
+	stage, err := getTestStage(build)
+	if err != nil || stage == nil {
+		return err
+	}
+	runner, ok := client.(containerRunner)
+	if !ok {
+		return fmt.Errorf("running a test stage is not supported by this build client")
+	}
+	createOpts := docker.CreateContainerOptions{
+		Context: ctx,
+		Name:    containerName("test", build.Name, build.Namespace, "stage"),
+		Config: &docker.Config{
+			Image:      image,
+			Entrypoint: []string{"/bin/sh", "-c"},
+			Cmd:        []string{stage.Command},
+		},
+		HostConfig: limitedHostConfig(cgLimits),
+	}
+	var results string
+	if len(stage.ResultsDir) > 0 {
+		if results, err = ioutil.TempDir("", "test-results"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(results)
+		// the tests may run as any user
+		if err := os.Chmod(results, 0777); err != nil {
+			return err
+		}
+		createOpts.HostConfig.Binds = []string{results + ":" + stage.ResultsDir + ":rw"}
+	}
+	attachOpts := docker.AttachToContainerOptions{
+		OutputStream: os.Stdout,
+		ErrorStream:  os.Stderr,
+	}
+	log.V(0).Infof("\nRunning test stage ...")
+	log.V(4).Infof("Test stage command %q", stage.Command)
+	runErr := runner.RunContainer(createOpts, attachOpts)
+
+	if len(results) > 0 {
+		if len(stage.Output) > 0 {
+			if err := copyTestResults(results, stage.Output); err != nil {
+				log.V(0).Infof("warning: Failed to copy the test results to %s: %v", stage.Output, err)
+			}
+		} else if summary := summarizeTestResults(results); len(summary) > 0 {
+			log.V(0).Infof("Test results:\n%s", summary)
+			writeTestTerminationMessage(terminationMessagePath, summary)
+		}
+	}
+	if runErr != nil {
+		return fmt.Errorf("test stage failed: %v", runErr)
+	}
+	return nil
+}
+
+// copyTestResults copies the files under dir to the same paths under output.
+func copyTestResults(dir, output string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(output, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			log.V(2).Infof("Copying the test result %s to %s", rel, target)
+			return ioutil.WriteFile(target, data, 0644)
+		}
+		return nil
+	})
+}
+
+// summarizeTestResults returns a line for each file under dir, with the
+// counts of a JUnit XML report.
+func summarizeTestResults(dir string) []byte {
+	var summary bytes.Buffer
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, file)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil
+		}
+		var suites junitSuites
+		if err := xml.Unmarshal(data, &suites); err != nil || (suites.XMLName.Local != "testsuites" && suites.XMLName.Local != "testsuite") {
+			fmt.Fprintf(&summary, "%s: not a JUnit report\n", rel)
+			return nil
+		}
+		tests, failures, errors, skipped := suites.totals()
+		fmt.Fprintf(&summary, "%s: %d tests, %d failures, %d errors, %d skipped\n", rel, tests, failures, errors, skipped)
+		return nil
+	})
+	return summary.Bytes()
+}
+
+// writeTestTerminationMessage writes the summary of the test results to the
+// termination message at path, truncated to the size which is kept.
+func writeTestTerminationMessage(path string, summary []byte) {
+	if len(summary) > terminationMessageLimit {
+		summary = summary[:terminationMessageLimit]
+	}
+	if err := ioutil.WriteFile(path, summary, 0644); err != nil {
+		log.V(0).Infof("warning: Failed to write the test results to the termination message: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/builder/pkg/build/builder/timing"
+)
+
+func TestGetTestStage(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []corev1.EnvVar
+		expect    *testStage
+		expectErr bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "command",
+			env:    []corev1.EnvVar{{Name: "BUILD_TEST_COMMAND", Value: "make test"}},
+			expect: &testStage{Command: "make test"},
+		},
+		{
+			name: "results",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_TEST_COMMAND", Value: "make test"},
+				{Name: "BUILD_TEST_RESULTS_DIR", Value: "/tmp/results/"},
+				{Name: "BUILD_TEST_RESULTS_OUTPUT", Value: "/var/results"},
+			},
+			expect: &testStage{Command: "make test", ResultsDir: "/tmp/results", Output: "/var/results"},
+		},
+		{
+			name: "relative results directory",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_TEST_COMMAND", Value: "make test"},
+				{Name: "BUILD_TEST_RESULTS_DIR", Value: "results"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "output without results directory",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_TEST_COMMAND", Value: "make test"},
+				{Name: "BUILD_TEST_RESULTS_OUTPUT", Value: "/var/results"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			stage, err := getTestStage(build)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if (stage == nil) != (test.expect == nil) || (stage != nil && *stage != *test.expect) {
+				t.Errorf("expected %#v, got %#v", test.expect, stage)
+			}
+		})
+	}
+}
+
+func TestSummarizeTestResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"suite.xml":  `<testsuite name="unit" tests="3" failures="1" errors="0" skipped="1"></testsuite>`,
+		"suites.xml": `<testsuites><testsuite tests="2" failures="1"/><testsuite tests="4" errors="2"/></testsuites>`,
+		"out.txt":    "PASS",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "out.txt: not a JUnit report\n" +
+		"suite.xml: 3 tests, 1 failures, 0 errors, 1 skipped\n" +
+		"suites.xml: 6 tests, 1 failures, 2 errors, 0 skipped\n"
+	if summary := string(summarizeTestResults(dir)); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestRunTestStage(t *testing.T) {
+	ctx := timing.NewContext(context.Background())
+	output, err := ioutil.TempDir("", "test-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(output)
+
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_TEST_COMMAND", Value: "make test"},
+			{Name: "BUILD_TEST_RESULTS_DIR", Value: "/tmp/results"},
+			{Name: "BUILD_TEST_RESULTS_OUTPUT", Value: output},
+		},
+	}
+	client := &fakeRunningDocker{
+		FakeDocker: NewFakeDockerClient(),
+		// the tests write a report to the mounted results directory, and fail
+		run: func(createOpts docker.CreateContainerOptions) {
+			if len(createOpts.HostConfig.Binds) != 1 || !strings.HasSuffix(createOpts.HostConfig.Binds[0], ":/tmp/results:rw") {
+				t.Fatalf("expected the results directory to be mounted, got %v", createOpts.HostConfig.Binds)
+			}
+			results := strings.Split(createOpts.HostConfig.Binds[0], ":")[0]
+			if err := ioutil.WriteFile(filepath.Join(results, "junit.xml"), []byte("<testsuite/>"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		},
+		err: errors.New("exit status 2"),
+	}
+	if err := runTestStage(ctx, client, build, "image", nil); err == nil {
+		t.Errorf("expected the test stage to fail")
+	}
+	if client.createOpts.Config.Image != "image" || client.createOpts.Config.Cmd[0] != "make test" {
+		t.Errorf("expected the tests to run in the built image, got %#v", client.createOpts.Config)
+	}
+	if _, err := os.Stat(filepath.Join(output, "junit.xml")); err != nil {
+		t.Errorf("expected the report to be collected from the failed tests: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package timing
 import (
 	"context"
 	"os"
+	"sort"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -34,6 +35,35 @@ func RecordNewStep(ctx context.Context, stageName buildapiv1.StageName, stepName
 	*stages = newStages
 }
 
+// stageObservers are called, in the order of their names, with each stage
+// that the build enters.
+var stageObservers = map[string]func(buildapiv1.StageName){}
+
+// ObserveStages arranges for observer to be called with each stage that the
+// build enters, in place of any observer already registered under name, or
+// for nothing to be called for name if observer is nil.
+func ObserveStages(name string, observer func(buildapiv1.StageName)) {
+	if observer == nil {
+		delete(stageObservers, name)
+		return
+	}
+	stageObservers[name] = observer
+}
+
+// SetStage records that the build has entered stageName, so that output
+// which follows can be attributed to it.
+func SetStage(stageName buildapiv1.StageName) {
+	utillog.SetStage(string(stageName))
+	names := make([]string, 0, len(stageObservers))
+	for name := range stageObservers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stageObservers[name](stageName)
+	}
+}
+
 // GetStages returns all stages and steps currently stored in the context
 func GetStages(ctx context.Context) []buildapiv1.StageInfo {
 	stages := fromContext(ctx)
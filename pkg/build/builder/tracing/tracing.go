@@ -0,0 +1,201 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+const (
+	// serviceName is the name of the service the builder's spans belong to.
+	serviceName = "openshift-builder"
+	// scopeName is the instrumentation scope of the builder's spans.
+	scopeName = "github.com/openshift/builder"
+	// spanKindInternal is the OTLP kind of spans for work done in-process.
+	spanKindInternal = 1
+	// tracesPath is the path at which OTLP/HTTP collectors accept traces.
+	tracesPath = "/v1/traces"
+)
+
+// traceParentRegexp matches a version 00 W3C traceparent header value,
+// capturing its trace and parent span IDs.
+var traceParentRegexp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// The OTLP/HTTP JSON encoding of an export request.  Trace and span IDs are
+// hex encoded and 64-bit integers are encoded as strings.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func stringAttribute(key, value string) attribute {
+	return attribute{Key: key, Value: attributeValue{StringValue: value}}
+}
+
+// TracesURL returns the URL to which traces are exported, given the values
+// of $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, which is used as it is, and of
+// $OTEL_EXPORTER_OTLP_ENDPOINT, to which the OTLP traces path is added.
+func TracesURL(tracesEndpoint, endpoint string) string {
+	if len(tracesEndpoint) > 0 {
+		return tracesEndpoint
+	}
+	if len(endpoint) > 0 {
+		return strings.TrimSuffix(endpoint, "/") + tracesPath
+	}
+	return ""
+}
+
+// spans returns a span for each of the stages of build and a child span for
+// each of their steps.  The spans belong to the trace of traceParent, a W3C
+// traceparent header value, if one is given, so that the build is traced
+// alongside whatever started it.  Otherwise they belong to a trace derived
+// from the build's UID, which the spans of each of the build's containers
+// share.
+func spans(build *buildapiv1.Build, stages []buildapiv1.StageInfo, traceParent string) ([]span, error) {
+	var traceID, parentID string
+	if len(traceParent) > 0 {
+		match := traceParentRegexp.FindStringSubmatch(traceParent)
+		if match == nil {
+			return nil, fmt.Errorf("invalid trace parent %q", traceParent)
+		}
+		traceID, parentID = match[1], match[2]
+	} else {
+		id := string(build.UID)
+		if len(id) == 0 {
+			id = build.Namespace + "/" + build.Name
+		}
+		sum := sha256.Sum256([]byte(id))
+		traceID = hex.EncodeToString(sum[:16])
+	}
+
+	result := []span{}
+	for _, stage := range stages {
+		stageID, err := newSpanID()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, newSpan(traceID, stageID, parentID, string(stage.Name), stage.StartTime.Time, stage.DurationMilliseconds,
+			stringAttribute("openshift.build.stage", string(stage.Name))))
+		for _, step := range stage.Steps {
+			stepID, err := newSpanID()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, newSpan(traceID, stepID, stageID, string(step.Name), step.StartTime.Time, step.DurationMilliseconds,
+				stringAttribute("openshift.build.stage", string(stage.Name)),
+				stringAttribute("openshift.build.step", string(step.Name))))
+		}
+	}
+	return result, nil
+}
+
+func newSpan(traceID, spanID, parentID, name string, start time.Time, durationMilliseconds int64, attributes ...attribute) span {
+	end := start.Add(time.Duration(durationMilliseconds) * time.Millisecond)
+	return span{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		ParentSpanID:      parentID,
+		Name:              name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attributes,
+	}
+}
+
+func newSpanID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Export sends the stage and step timings of build to the OTLP/HTTP
+// collector at url as spans, with the build's namespace and name as
+// attributes of their resource.  traceParent, if set, is the W3C traceparent
+// of the trace the spans are part of.
+func Export(url string, build *buildapiv1.Build, stages []buildapiv1.StageInfo, traceParent string) error {
+	if len(stages) == 0 {
+		return nil
+	}
+	s, err := spans(build, stages, traceParent)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: []attribute{
+				stringAttribute("service.name", serviceName),
+				stringAttribute("k8s.namespace.name", build.Namespace),
+				stringAttribute("openshift.build.name", build.Name),
+			}},
+			ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: s}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting spans to %s failed: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func testBuild() (*buildapiv1.Build, time.Time) {
+	start := time.Unix(1600000000, 0)
+	build := &buildapiv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", UID: "uid"}}
+	build.Status.Stages = []buildapiv1.StageInfo{
+		{
+			Name:                 buildapiv1.StageBuild,
+			StartTime:            metav1.NewTime(start),
+			DurationMilliseconds: 1500,
+			Steps: []buildapiv1.StepInfo{
+				{Name: buildapiv1.StepDockerBuild, StartTime: metav1.NewTime(start), DurationMilliseconds: 1500},
+			},
+		},
+	}
+	return build, start
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		tracesEndpoint, endpoint, expected string
+	}{
+		{},
+		{endpoint: "http://collector:4318", expected: "http://collector:4318/v1/traces"},
+		{endpoint: "http://collector:4318/", expected: "http://collector:4318/v1/traces"},
+		{tracesEndpoint: "http://collector:4318/traces", endpoint: "http://other:4318", expected: "http://collector:4318/traces"},
+	}
+	for _, test := range tests {
+		if url := TracesURL(test.tracesEndpoint, test.endpoint); url != test.expected {
+			t.Errorf("%q, %q: expected %q, got %q", test.tracesEndpoint, test.endpoint, test.expected, url)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	build, start := testBuild()
+	result, err := spans(build, build.Status.Stages, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected a stage and a step span, got %#v", result)
+	}
+	stage, step := result[0], result[1]
+	if stage.Name != "Build" || len(stage.ParentSpanID) != 0 || step.Name != "DockerBuild" || step.ParentSpanID != stage.SpanID {
+		t.Errorf("expected the step span to be a child of the stage span, got %#v", result)
+	}
+	if len(stage.TraceID) != 32 || stage.TraceID != step.TraceID {
+		t.Errorf("expected the spans to share a trace, got %#v", result)
+	}
+	if stage.StartTimeUnixNano != "1600000000000000000" || stage.EndTimeUnixNano != "1600000001500000000" {
+		t.Errorf("expected the stage to run from %v for 1.5s, got %#v", start, stage)
+	}
+
+	again, err := spans(build, build.Status.Stages, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again[0].TraceID != stage.TraceID {
+		t.Errorf("expected the spans of a build to share a trace, got %s and %s", stage.TraceID, again[0].TraceID)
+	}
+
+	result, err = spans(build, build.Status.Stages, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result[0].TraceID != "0af7651916cd43dd8448eb211c80319c" || result[0].ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("expected the stage span to be a child of the trace parent, got %#v", result[0])
+	}
+
+	if _, err := spans(build, build.Status.Stages, "invalid"); err == nil {
+		t.Errorf("expected an error for an invalid trace parent")
+	}
+}
+
+func TestExport(t *testing.T) {
+	build, _ := testBuild()
+	var request exportRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("unexpected request body %s: %v", body, err)
+		}
+	}))
+	defer server.Close()
+
+	if err := Export(server.URL+"/v1/traces", build, build.Status.Stages, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("unexpected request %#v", request)
+	}
+	attributes := map[string]string{}
+	for _, a := range request.ResourceSpans[0].Resource.Attributes {
+		attributes[a.Key] = a.Value.StringValue
+	}
+	if attributes["k8s.namespace.name"] != "ns" || attributes["openshift.build.name"] != "app-1" || attributes["service.name"] != "openshift-builder" {
+		t.Errorf("unexpected resource attributes %v", attributes)
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	build, _ := testBuild()
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if err := Export(server.URL+"/v1/traces", build, build.Status.Stages, ""); err == nil {
+		t.Errorf("expected an error when the collector rejects the spans")
+	}
+}
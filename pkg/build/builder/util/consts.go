@@ -8,9 +8,427 @@ const (
 	// DropCapabilities is an environment variable that contains a list of capabilities to drop when
 	// executing a Source build
 	DropCapabilities = "DROP_CAPS"
+	// LogFormat is an environment variable selecting the format of the builder's output, "text" (the
+	// default) or "json", which writes each line as a JSON record with its time, stage and severity
+	LogFormat = "BUILD_LOG_FORMAT"
+	// LogScrubRulesPath is an environment variable of the builder pod holding the path of a file, such
+	// as a key of a mounted ConfigMap, of regular expressions, one per line, whose matches are masked in
+	// the builder's output along with the build's secrets
+	LogScrubRulesPath = "BUILD_LOG_SCRUB_RULES_PATH"
+	// SensitiveBuildArgs is a build strategy environment variable holding a comma separated list of the
+	// names of build args whose values are masked in the builder's output
+	SensitiveBuildArgs = "BUILD_SENSITIVE_ARGS"
+	// MetricsAddress is an environment variable holding the address on which the builder serves
+	// Prometheus metrics about the build at /metrics while it runs
+	MetricsAddress = "BUILD_METRICS_ADDRESS"
+	// MetricsPushgateway is an environment variable holding the URL of a Prometheus Pushgateway to which
+	// the builder pushes metrics about the build once it finishes
+	MetricsPushgateway = "BUILD_METRICS_PUSHGATEWAY"
+	// OTLPEndpoint is an environment variable holding the base URL of an OpenTelemetry collector to
+	// which the builder exports the timings of the stages of the build as spans over OTLP/HTTP
+	OTLPEndpoint = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	// OTLPTracesEndpoint is an environment variable holding the full URL to which the builder exports
+	// spans, in place of the traces path under OTLPEndpoint
+	OTLPTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	// TraceParent is an environment variable holding the W3C traceparent of the trace, such as that of
+	// the pipeline which started the build, that the spans of the build are part of
+	TraceParent = "TRACEPARENT"
+	// BuildCacheDir is an environment variable naming a directory, typically a persistent volume, in
+	// which a blob cache is kept for each BuildConfig between builds
+	BuildCacheDir = "BUILD_CACHE_DIR"
+	// BuildCacheMaxSize is an environment variable limiting the total size of the caches under
+	// BuildCacheDir, as a quantity such as 10Gi
+	BuildCacheMaxSize = "BUILD_CACHE_MAX_SIZE"
+	// BuildCacheMaxAge is an environment variable giving the duration after which an unused cache under
+	// BuildCacheDir is removed
+	BuildCacheMaxAge = "BUILD_CACHE_MAX_AGE"
+	// StorageDriver is a build strategy environment variable, which overrides the builder pod's own,
+	// choosing the container storage driver: "overlay" for the kernel's overlay filesystem,
+	// "fuse-overlayfs" for overlay mounted by fuse-overlayfs, "vfs", or any other driver by name
+	StorageDriver = "BUILD_STORAGE_DRIVER"
+	// StorageOptions is a build strategy environment variable, which overrides the builder pod's own,
+	// holding a JSON list of container storage driver options, such as ["overlay.mountopt=nodev"]
+	StorageOptions = "BUILD_STORAGE_OPTIONS"
+	// BuildServerSocket is an environment variable naming the unix socket on which
+	// openshift-builder-server accepts builds.  When it names a socket which exists, the other builder
+	// commands hand their builds off to the server listening on it rather than running them themselves
+	BuildServerSocket = "BUILD_SERVER_SOCKET"
+	// BuildWorkDir is an environment variable which openshift-builder-server sets for each step of a
+	// build it runs, naming the directory of the build's own which holds, in place of /tmp, the files
+	// its steps share, so that they are not mixed up with those of the builds run before it
+	BuildWorkDir = "BUILD_WORK_DIR"
+	// Rootless is an environment variable which, when "true", runs the builder in a user namespace of its
+	// own, in which it is root, so that its pod need not be privileged.  When "auto", it does so only when
+	// the builder lacks CAP_SYS_ADMIN
+	Rootless = "BUILD_ROOTLESS"
+	// AdditionalImageStores is an environment variable holding a comma-separated list of the
+	// directories of read-only container storage, such as the node's own mounted into the build pod,
+	// whose images and layers are used in place of pulling them again.  Only the overlay storage
+	// driver supports them
+	AdditionalImageStores = "BUILD_ADDITIONAL_IMAGE_STORES"
+	// BuildCacheFrom is a build strategy environment variable holding a comma-separated list of image
+	// references whose layer cache, exported by BuildCacheTo, is imported before a Docker strategy build
+	BuildCacheFrom = "BUILD_CACHE_FROM"
+	// BuildCacheTo is a build strategy environment variable holding an image reference to which the layer
+	// cache of a Docker strategy build is exported
+	BuildCacheTo = "BUILD_CACHE_TO"
+	// SecretMounts is a build strategy environment variable that makes a Docker strategy build mount its
+	// input secrets read-only under SecretMountsPath for RUN instructions instead of copying them into the
+	// build context
+	SecretMounts = "BUILD_SECRET_MOUNTS"
+	// SecretMountsPath is the directory under which input secrets are mounted when SecretMounts is set
+	SecretMountsPath = "/run/build-secrets"
+	// SSHAgent is a build strategy environment variable that makes a Docker strategy build start an
+	// ssh-agent holding the source secret's ssh-privatekey and mount its socket at SSHAgentSocketPath for
+	// RUN instructions
+	SSHAgent = "BUILD_SSH_AGENT"
+	// SSHAgentSocketPath is the path of the ssh-agent socket in RUN instructions when SSHAgent is set
+	SSHAgentSocketPath = "/run/build-ssh/agent.sock"
+	// GitCloneDepth is a build strategy environment variable that limits git clones to the given
+	// number of commits of history
+	GitCloneDepth = "BUILD_GIT_CLONE_DEPTH"
+	// GitLFS is a build strategy environment variable that enables fetching Git LFS objects after a
+	// git clone
+	GitLFS = "BUILD_GIT_LFS"
+	// GitSparseCheckout is a build strategy environment variable that limits the checkout of a git
+	// source to the build's context directory
+	GitSparseCheckout = "BUILD_GIT_SPARSE_CHECKOUT"
+	// BuildPlatforms is a build strategy environment variable holding a comma-separated list of
+	// os/arch[/variant] platforms that a Docker strategy build produces images for, pushed as a manifest
+	// list
+	BuildPlatforms = "BUILD_PLATFORMS"
+	// PullPlatform is a build strategy environment variable holding the os/arch[/variant] platform, such
+	// as linux/arm/v7, whose images are pulled from manifest lists, for the base images of the build and
+	// the images its content is extracted from, in place of those for the node's platform
+	PullPlatform = "BUILD_PULL_PLATFORM"
+	// BuildTarget is a build strategy environment variable naming the stage of a multi-stage Dockerfile
+	// that a Docker strategy build stops at, as with docker build --target
+	BuildTarget = "BUILD_TARGET"
+	// StageParallelism is a build strategy environment variable holding the number of stages of a
+	// multi-stage Dockerfile which depend on no other stage that a daemonless build builds at the same
+	// time, before building the whole Dockerfile from their cached layers.  The default of 1 builds the
+	// stages one after another
+	StageParallelism = "BUILD_STAGE_PARALLELISM"
+	// SBOMFormat is a build strategy environment variable selecting the format, "spdx" or "cyclonedx", of
+	// an SBOM generated for the built image and pushed alongside it
+	SBOMFormat = "BUILD_SBOM_FORMAT"
+	// SBOMTerminationMessage is a build strategy environment variable that makes the builder also write
+	// the SBOM to the termination message of the build container, if it fits
+	SBOMTerminationMessage = "BUILD_SBOM_TERMINATION_MESSAGE"
+	// Provenance is a build strategy environment variable that makes the builder push a signed in-toto
+	// statement of the SLSA provenance of the image alongside it
+	Provenance = "BUILD_PROVENANCE"
+	// ProvenanceSigningKey is a build strategy environment variable holding the path of an unencrypted PEM
+	// private key, such as one mounted from a secret, which signs the provenance
+	ProvenanceSigningKey = "BUILD_PROVENANCE_SIGNING_KEY"
+	// ProvenanceFulcioURL is a build strategy environment variable holding the URL of a Fulcio certificate
+	// authority, which is used to sign the provenance keylessly when ProvenanceSigningKey is not set
+	ProvenanceFulcioURL = "BUILD_PROVENANCE_FULCIO_URL"
+	// ProvenanceIdentityToken is a build strategy environment variable holding the path of the OIDC token,
+	// such as a projected service account token, which identifies the build for keyless signing
+	ProvenanceIdentityToken = "BUILD_PROVENANCE_IDENTITY_TOKEN"
+	// ImageSigningSecret is a build strategy environment variable naming a build input secret whose
+	// cosign.key, an unencrypted PEM private key, signs the pushed image in the way cosign does
+	ImageSigningSecret = "BUILD_IMAGE_SIGNING_SECRET"
+	// ImageSigningAnnotations is a build strategy environment variable holding a comma-separated list of
+	// key=value annotations which are included in the payload signed by ImageSigningSecret
+	ImageSigningAnnotations = "BUILD_IMAGE_SIGNING_ANNOTATIONS"
+	// ImageRetries is a build strategy environment variable holding the number of times a failed push or
+	// pull of an image is retried
+	ImageRetries = "BUILD_IMAGE_RETRIES"
+	// ImageRetryDelay is a build strategy environment variable holding the duration, such as 5s, before
+	// the first retry of a push or pull, which doubles with each further retry
+	ImageRetryDelay = "BUILD_IMAGE_RETRY_DELAY"
+	// ImageRetryMaxDelay is a build strategy environment variable holding the longest duration before a
+	// retry of a push or pull
+	ImageRetryMaxDelay = "BUILD_IMAGE_RETRY_MAX_DELAY"
+	// TransientRetries is a build strategy environment variable holding the number of times a stage of the
+	// build, such as fetching the source, is retried within the build pod after a transient failure
+	TransientRetries = "BUILD_TRANSIENT_RETRIES"
+	// TransientRetryDelay is a build strategy environment variable holding the duration, such as 5s, before
+	// the first retry of a stage after a transient failure, which doubles with each further retry
+	TransientRetryDelay = "BUILD_TRANSIENT_RETRY_DELAY"
+	// PushConcurrency is a build strategy environment variable holding the largest number of blobs that
+	// are pushed at once.  The vendored containers/image pushes no more than six at once in any case.
+	PushConcurrency = "BUILD_PUSH_CONCURRENCY"
+	// RegistryPushConcurrency is a build strategy environment variable holding a comma-separated list of
+	// registry=concurrency pairs, overriding PushConcurrency for pushes to those registries
+	RegistryPushConcurrency = "BUILD_REGISTRY_PUSH_CONCURRENCY"
+	// RegistryTokenAuth is a build strategy environment variable choosing which registries are
+	// authenticated with the build pod's bound service account token when the build's secrets hold no
+	// credentials for them: "true" (the default), for the integrated registry that an ImageStreamTag
+	// output is pushed to, "false", for none, or a comma-separated list of further registry hosts
+	RegistryTokenAuth = "BUILD_REGISTRY_TOKEN_AUTH"
+	// ProgressInterval is a build strategy environment variable holding how often the progress of image
+	// pulls and pushes is logged and shown in the build's status message, 30s by default.  "0" disables it.
+	ProgressInterval = "BUILD_PROGRESS_INTERVAL"
+	// ImageFormat is a build strategy environment variable selecting the format, "docker" (the default) or
+	// "oci", of the manifests of the images which a build commits and pushes
+	ImageFormat = "BUILD_IMAGE_FORMAT"
+	// ImageCompression is a build strategy environment variable selecting the compression, "gzip" (the
+	// default) or "zstd", of the layers of pushed images.  zstd requires the "oci" ImageFormat
+	ImageCompression = "BUILD_IMAGE_COMPRESSION"
+	// ImageCompressionLevel is a build strategy environment variable holding the level of the
+	// ImageCompression, from 1 to 9 for gzip or 1 to 22 for zstd.  Higher levels make smaller layers,
+	// which pull faster, but take longer to push
+	ImageCompressionLevel = "BUILD_IMAGE_COMPRESSION_LEVEL"
+	// IncrementalFrom is a build strategy environment variable holding the image whose artifacts an
+	// incremental Source strategy build reuses, such as one built in another cluster, in place of the
+	// build's output
+	IncrementalFrom = "BUILD_INCREMENTAL_FROM"
+	// IncrementalFromSecret is a build strategy environment variable naming a build input secret, of
+	// type kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg, holding the credentials which
+	// pull the IncrementalFrom image.  Without it, the image is pulled with the build's pull secret
+	IncrementalFromSecret = "BUILD_INCREMENTAL_FROM_SECRET"
+	// RuntimeImage is a build strategy environment variable naming an image into which a Source strategy
+	// build copies the artifacts of assemble, runs the image's assemble-runtime script, and whose run
+	// script becomes the command of the built image
+	RuntimeImage = "BUILD_RUNTIME_IMAGE"
+	// RuntimeArtifacts is a build strategy environment variable holding a semicolon-separated list of
+	// source:destination pairs, each an absolute path in the builder image and a directory relative to
+	// the working directory of the RuntimeImage, which overrides the assemble-input-files label of the
+	// RuntimeImage
+	RuntimeArtifacts = "BUILD_RUNTIME_ARTIFACTS"
+	// ChainedDockerfile is a build strategy environment variable holding the path, relative to the
+	// build's context directory, of the Dockerfile of a second step of the build, which builds the
+	// image the build outputs from the ChainedArtifacts of the image built by the first
+	ChainedDockerfile = "BUILD_CHAINED_DOCKERFILE"
+	// ChainedArtifacts is a build strategy environment variable holding a semicolon-separated list of
+	// source:destination pairs, each an absolute path in the image built by the first step of a build
+	// and a directory relative to the context of the ChainedDockerfile, into which it is copied
+	ChainedArtifacts = "BUILD_CHAINED_ARTIFACTS"
+	// AssembleUser is a build strategy environment variable holding the user, as a name or UID optionally
+	// followed by :group, which runs the assemble script of a Source strategy build in place of the
+	// builder image's assemble-user label or default user.  AllowedUIDs still applies
+	AssembleUser = "BUILD_ASSEMBLE_USER"
+	// ScriptsURL is a build strategy environment variable holding the http, https, file or image URL
+	// from which a Source strategy build fetches its S2I scripts, in place of the strategy's scripts
+	// or the builder image's scripts-url label
+	ScriptsURL = "BUILD_SCRIPTS_URL"
+	// S2IDestination is a build strategy environment variable holding the absolute directory into which
+	// a Source strategy build copies sources and scripts, in place of the builder image's destination
+	// label
+	S2IDestination = "BUILD_S2I_DESTINATION"
+	// Hermetic is a build strategy environment variable which, when true, runs every RUN instruction,
+	// assemble script and post-commit hook of a build with networking disabled.  Sources and base images
+	// are still fetched beforehand
+	Hermetic = "BUILD_HERMETIC"
+	// CABundleSecret is a build strategy environment variable naming a build input secret whose PEM
+	// encoded CA certificates are trusted by the RUN instructions and assemble script of a build, such as
+	// those of a TLS-intercepting proxy, without being committed to the image
+	CABundleSecret = "BUILD_CA_BUNDLE_SECRET"
+	// CompletionWebhook is a build strategy environment variable holding the http or https URL to which
+	// the builder POSTs a JSON summary of the build, with its status, output digest and durations, when
+	// the build finishes
+	CompletionWebhook = "BUILD_COMPLETION_WEBHOOK"
+	// CompletionWebhookSecret is a build strategy environment variable naming a build input secret whose
+	// authorization key is sent as the Authorization header of the CompletionWebhook request
+	CompletionWebhookSecret = "BUILD_COMPLETION_WEBHOOK_SECRET"
+	// CloudEventsSink is a build strategy environment variable holding the http or https URL of a
+	// CloudEvents sink, such as a Knative broker, to which the builder sends events when the build
+	// starts, enters each stage, completes and fails.  It overrides KnativeSink
+	CloudEventsSink = "BUILD_CLOUDEVENTS_SINK"
+	// KnativeSink is an environment variable, which a Knative SinkBinding sets, holding the URL of the
+	// CloudEvents sink of the builder pod
+	KnativeSink = "K_SINK"
+	// ProxyBuildArgs is a build strategy environment variable which, when false, stops a Docker strategy
+	// build from passing the proxy settings of its git source, or else of the builder, to its RUN
+	// instructions as the HTTP_PROXY, HTTPS_PROXY and NO_PROXY build args
+	ProxyBuildArgs = "BUILD_PROXY_ARGS"
+	// BuildArgsFromEnv is a build strategy environment variable holding a comma-separated list of the
+	// names of other variables of the build strategy's environment, or "*" for all of them, which a
+	// Docker strategy build also passes as build args, unless its buildArgs set them.  Variables whose
+	// names look sensitive, such as *_TOKEN or *PASSWORD*, and the BUILD_* settings of the builder are
+	// never passed
+	BuildArgsFromEnv = "BUILD_ARGS_FROM_ENV"
+	// BuildVolumes is a build strategy environment variable holding a comma-separated list of
+	// kind:name:destination volumes which are mounted read-only at the absolute destination for the RUN
+	// instructions and assemble script of a build, without being committed to the image.  The kind is
+	// "secret" or "configmap", naming a build input secret or configmap, or "path", naming an absolute
+	// directory of the build pod, such as a CSI volume mounted into it
+	BuildVolumes = "BUILD_VOLUMES"
+	// BuildInputFiles is a build strategy environment variable holding a comma-separated list of
+	// kind:name:key:path[:mode] entries, each of which places the key of a build input secret or
+	// configmap at the relative path under the input's destination directory, with the octal file
+	// mode, such as 0600, if one is given.  The kind is "secret" or "configmap".  Inputs with entries
+	// only provide the keys their entries list
+	BuildInputFiles = "BUILD_INPUT_FILES"
+	// BuildUlimits is a build strategy environment variable holding a comma-separated list of
+	// type=soft[:hard] resource limits, such as nofile=65536:65536 or nproc=4096, of the RUN instructions
+	// and assemble script of a build.  The types are those of ulimit(1), such as nofile, nproc, memlock
+	// and stack, and types which are not listed keep the builder's defaults
+	BuildUlimits = "BUILD_ULIMITS"
+	// BuildShmSize is a build strategy environment variable holding the size, as a quantity such as 512Mi
+	// or 2Gi, of the /dev/shm of the RUN instructions and assemble script of a build, such as those
+	// running browsers for tests
+	BuildShmSize = "BUILD_SHM_SIZE"
+	// PullBandwidthLimit is a build strategy environment variable holding the rate, in bytes per second
+	// as a quantity such as 20Mi, which the blobs of the images a build pulls from registries are read
+	// at, shared by all of the build's pulls
+	PullBandwidthLimit = "BUILD_PULL_BANDWIDTH_LIMIT"
+	// PushBandwidthLimit is a build strategy environment variable holding the rate, in bytes per second
+	// as a quantity such as 20Mi, which the blobs of the images a build pushes to registries are sent
+	// at, shared by all of the build's pushes
+	PushBandwidthLimit = "BUILD_PUSH_BANDWIDTH_LIMIT"
+	// ContextExcludes is a build strategy environment variable holding a comma-separated list of
+	// .dockerignore patterns, such as .git or **/node_modules, of files which are excluded from the
+	// build's context, including the secrets and configmaps copied into it.  For a Docker strategy build
+	// they follow the patterns of the context's .containerignore, or else its .dockerignore, and for a
+	// Source strategy build those of its .s2iignore
+	ContextExcludes = "BUILD_CONTEXT_EXCLUDES"
+	// TestCommand is a build strategy environment variable holding a shell command which tests the built
+	// image, in a container of it, after the post-commit hook.  The build fails if the command does
+	TestCommand = "BUILD_TEST_COMMAND"
+	// TestResultsDir is a build strategy environment variable holding the absolute directory of the
+	// TestCommand's container in which it writes reports, such as JUnit XML, which are collected after it
+	// runs.  Without TestResultsOutput, the reports are summarized in the termination message of the
+	// build container
+	TestResultsDir = "BUILD_TEST_RESULTS_DIR"
+	// TestResultsOutput is a build strategy environment variable holding the absolute directory of the
+	// build pod, such as a mounted volume, to which the reports in TestResultsDir are copied
+	TestResultsOutput = "BUILD_TEST_RESULTS_OUTPUT"
+	// ArtifactPaths is a build strategy environment variable holding a comma-separated list of absolute
+	// paths of the built image, such as compiled binaries, which are copied into ArtifactsOutput.  A build
+	// without an output image only extracts its artifacts
+	ArtifactPaths = "BUILD_ARTIFACT_PATHS"
+	// ArtifactsOutput is a build strategy environment variable holding the absolute directory of the build
+	// pod, such as a volume shared with a sidecar, into which the ArtifactPaths are copied
+	ArtifactsOutput = "BUILD_ARTIFACTS_OUTPUT"
+	// DockerfileLint is a build strategy environment variable selecting whether the Dockerfile of a Docker
+	// strategy build is linted before it is built: "off" (the default), "warn", which logs the problems
+	// found, or "fail", which also fails the build
+	DockerfileLint = "BUILD_DOCKERFILE_LINT"
+	// DockerfileLintIgnore is a build strategy environment variable holding a comma-separated list of the
+	// IDs of lint rules, such as DL3007, which DockerfileLint skips
+	DockerfileLintIgnore = "BUILD_DOCKERFILE_LINT_IGNORE"
+	// PinBaseImages is a build strategy environment variable which, if true, resolves the images that the
+	// Dockerfile of a Docker strategy build is based on to digests before it is built, builds from those
+	// digests, and records them in the BaseImagesLabel of the built image
+	PinBaseImages = "BUILD_PIN_BASE_IMAGES"
+	// FromOverrides is a build strategy environment variable holding a comma-separated list of
+	// stage=image pairs, each of which replaces the image in the FROM instruction of a stage of the
+	// Dockerfile of a Docker strategy build, named by its AS name or its index from 0.  The overrides are
+	// applied after the strategy's From and the build's image sources, and the build fails if the
+	// Dockerfile has no such stage
+	FromOverrides = "BUILD_FROM_OVERRIDES"
+	// BuildContexts is a build strategy environment variable holding a comma-separated list of
+	// name=source pairs, each of which adds a named context to a Docker strategy build which the
+	// Dockerfile can COPY --from by name, as with --build-context.  The source is either a directory,
+	// relative to the root of the build's source, or image://, or docker-image://, followed by an image
+	// reference
+	BuildContexts = "BUILD_CONTEXTS"
+	// DryRun is a build strategy environment variable which, if true, has the builder resolve the build's
+	// source, Dockerfile, base image digests, build args, labels and output, print them as a JSON plan
+	// and exit without building or pushing anything
+	DryRun = "BUILD_DRY_RUN"
+	// AdditionalOutputs is a build strategy environment variable holding a comma-separated list of image
+	// references that the built image is pushed to as well as the build's output.  An entry starting with
+	// a colon, such as :v1.0, is a tag in the repository of the build's output
+	AdditionalOutputs = "BUILD_ADDITIONAL_OUTPUTS"
+	// GitSubmoduleSecrets is a build strategy environment variable holding a comma-separated list of
+	// host=prefix pairs, naming the prefix of the keys of the build's source secret holding the
+	// credentials used for git repositories, such as submodules, on that host.  gitlab.example.com=gitlab,
+	// for instance, uses the gitlab-username, gitlab-password, gitlab-token, gitlab-ssh-privatekey,
+	// gitlab-known_hosts and gitlab-ca.crt keys
+	GitSubmoduleSecrets = "BUILD_GIT_SUBMODULE_SECRETS"
+	// SSHHostKeyPolicy is a build strategy environment variable selecting how the keys of git hosts
+	// cloned from over ssh are verified: "strict" fails on hosts whose keys are not known, "accept-new"
+	// trusts the keys of new hosts but fails on changed keys, and "insecure" never verifies them.  By
+	// default they are verified against the known_hosts of the source secret, if it has one.  The
+	// known_hosts key of the source secret holds the keys of all hosts, and is required by "strict"
+	SSHHostKeyPolicy = "BUILD_SSH_HOST_KEY_POLICY"
+	// GitSigningKeys is a build strategy environment variable holding a comma-separated list of the keys
+	// of the build's source secret holding the keys against which the signature of the commit fetched
+	// from the build's git source is verified: OpenPGP public keys, and an allowed_signers key holding an
+	// ssh allowed signers file for SSH signatures.  Unsigned commits fail the build
+	GitSigningKeys = "BUILD_GIT_SIGNING_KEYS"
+	// GitAllowedRefs is a build strategy environment variable holding a comma-separated list of
+	// patterns, such as main or release-*, of the refs of the build's git source which may be built.
+	// A build of any other ref, or of the default branch if it has none, fails
+	GitAllowedRefs = "BUILD_GIT_ALLOWED_REFS"
+	// SourceURL is a build strategy environment variable holding the http:// or https:// URL of a tar,
+	// gzipped tar or zip archive, such as a release tarball, or the oci:// reference of an OCI artifact,
+	// such as a source bundle pushed by ORAS, which is fetched into the build directory after any git or
+	// binary source.  An archive URL ending in #sha256=<digest> must have that digest
+	SourceURL = "BUILD_SOURCE_URL"
+	// OCIArtifacts is a build strategy environment variable holding a comma-separated list of
+	// reference=path pairs, each of which pulls an OCI artifact, such as a helm chart, wasm module or
+	// model pushed by ORAS, into the directory at path, relative to the root of the build's source,
+	// after the build's other sources are fetched.  Each reference must be pinned by its digest, as
+	// name@sha256:<digest>, and the artifact's manifest must have that digest
+	OCIArtifacts = "BUILD_OCI_ARTIFACTS"
+	// Reproducible is a build strategy environment variable which, if true, dates the image built from a
+	// commit by the commit's date, or by the SOURCE_DATE_EPOCH variable of the build strategy's
+	// environment if it is set, and leaves out the metadata that differs between builds, so that building
+	// the same commit again produces an image with the same digest.  The date is passed to the build as
+	// SOURCE_DATE_EPOCH, and the timestamps of the image's history and of the files its layers add are
+	// clamped to it
+	Reproducible = "BUILD_REPRODUCIBLE"
+	// Squash is a build strategy environment variable selecting which layers of the built image are
+	// squashed into one when it is committed: "none" (the default), "build", which squashes the layers
+	// added by the build onto its base image, or "all", which also squashes the base image's layers, so
+	// that the image has a single layer
+	Squash = "BUILD_SQUASH"
+	// ImageSizeBudget is a build strategy environment variable holding the largest total uncompressed
+	// size of the layers of the built image, as a quantity such as 500Mi
+	ImageSizeBudget = "BUILD_IMAGE_SIZE_BUDGET"
+	// CompressedImageSizeBudget is a build strategy environment variable holding the largest total size
+	// of the layers of the built image, as a quantity such as 200Mi, when compressed as they are pushed
+	CompressedImageSizeBudget = "BUILD_COMPRESSED_IMAGE_SIZE_BUDGET"
+	// ImageSizeBudgetAction is a build strategy environment variable selecting what happens when the
+	// built image exceeds ImageSizeBudget or CompressedImageSizeBudget: "fail" (the default), which fails
+	// the build, or "warn", which logs a warning
+	ImageSizeBudgetAction = "BUILD_IMAGE_SIZE_BUDGET_ACTION"
+	// LayerDiffReport is a build strategy environment variable which, if true, compares the built image
+	// with the image previously pushed to the build's output tag before pushing it, and reports the layers
+	// which changed, how much its size changed by, and the files added, removed and changed, in the build
+	// log and in the build's LayerDiffAnnotation
+	LayerDiffReport = "BUILD_LAYER_DIFF_REPORT"
+	// VulnerabilityScanner is a build strategy environment variable naming the scanner that the built
+	// image is checked with before it is pushed: either the unix:// URL of the socket of a scanner
+	// sidecar, which is sent the packages installed in the image, or the path of a scanner command, which
+	// is run with the directory holding the image's root filesystem and the image's name as arguments.
+	// Either reports the vulnerabilities it finds as JSON
+	VulnerabilityScanner = "BUILD_VULNERABILITY_SCANNER"
+	// VulnerabilitySeverityThreshold is a build strategy environment variable holding the lowest
+	// severity, "low", "medium", "high" (the default) or "critical", of the vulnerabilities found by the
+	// VulnerabilityScanner which keep the image from being pushed
+	VulnerabilitySeverityThreshold = "BUILD_VULNERABILITY_SEVERITY_THRESHOLD"
+	// BinaryInputMaxSize is a build strategy environment variable which, when set to a quantity
+	// such as 2Gi, is the most that a binary build may stream to the builder as its input
+	BinaryInputMaxSize = "BUILD_BINARY_MAX_SIZE"
+	// StageTimeouts is a build strategy environment variable holding a comma-separated list of
+	// stage=duration pairs, such as FetchInputs=5m,PushImage=20m, which fail the build if any of the
+	// FetchInputs, PullImages, Build, PostCommit or PushImage stages runs for longer than its timeout
+	StageTimeouts = "BUILD_STAGE_TIMEOUTS"
+	// HeartbeatFile is a build strategy environment variable holding the path of a file which the builder
+	// rewrites every few seconds with the stage the build is in and when it last made progress, as JSON,
+	// for a liveness probe or a sidecar to read
+	HeartbeatFile = "BUILD_HEARTBEAT_FILE"
+	// StallTimeout is a build strategy environment variable holding a duration, such as 15m, after which
+	// a build which has made no progress, by writing output, transferring image content or moving on to
+	// its next stage, is stopped and fails
+	StallTimeout = "BUILD_STALL_TIMEOUT"
+	// DiskMinFree is a build strategy environment variable holding the space, as a quantity such as 1Gi,
+	// which must stay free on the volumes holding the container storage and the build's context, below
+	// which the build is stopped and fails rather than running out of space
+	DiskMinFree = "BUILD_DISK_MIN_FREE"
+	// Isolation is a build strategy environment variable, which overrides the builder pod's own, choosing
+	// how RUN instructions are isolated: "oci", "rootless", "chroot", or "auto" for the strongest of those
+	// that the builder is able to use.  A requested isolation which cannot be used falls back to a weaker one
+	Isolation = "BUILD_ISOLATION"
 
 	// DefaultDockerLabelNamespace is the key of a Build label, whose values are build metadata.
 	DefaultDockerLabelNamespace = "io.openshift."
+	// BaseImagesLabel is the image label holding a comma-separated list of the digests of the images that
+	// the image was built from, when PinBaseImages is set.
+	BaseImagesLabel = DefaultDockerLabelNamespace + "build.base-images"
+	// LayerDiffAnnotation is the Build annotation holding, as JSON, how the built image differs from the
+	// image previously pushed to the build's output tag, when LayerDiffReport is set.
+	LayerDiffAnnotation = "openshift.io/build.layer-diff"
+	// FailureCodeAnnotation is the Build annotation holding the machine-readable code of the build's
+	// failure, such as NetworkError or OutOfMemory, when it fails.
+	FailureCodeAnnotation = "openshift.io/build.failure-code"
 
 	StatusMessageCannotCreateBuildPodSpec        = "Failed to create pod spec."
 	StatusMessageCannotCreateBuildPod            = "Failed creating build pod."
@@ -21,15 +439,30 @@ const (
 	StatusMessageExceededRetryTimeout            = "Build did not complete and retrying timed out."
 	StatusMessageMissingPushSecret               = "Missing push secret."
 	StatusMessagePostCommitHookFailed            = "Build failed because of post commit hook."
+	StatusMessageTestStageFailed                 = "Build failed because of the test stage."
+	StatusMessageBuildHookFailed                 = "Build failed because of a build hook."
+	StatusMessageExtractArtifactsFailed          = "Failed to extract the artifacts from the image."
+	StatusMessageChainedBuildFailed              = "Failed to build the chained step of the build."
 	StatusMessagePushImageToRegistryFailed       = "Failed to push the image to the registry."
+	StatusMessageGenerateSBOMFailed              = "Failed to generate the SBOM for the image."
+	StatusMessageSignProvenanceFailed            = "Failed to set up signing of the provenance of the image."
+	StatusMessageSignImageFailed                 = "Failed to set up signing of the image."
 	StatusMessagePullBuilderImageFailed          = "Failed pulling builder image."
 	StatusMessageFetchSourceFailed               = "Failed to fetch the input source."
 	StatusMessageInvalidContextDirectory         = "The supplied context directory does not exist."
+	StatusMessageSourceVerificationFailed        = "The revision of the input source failed verification."
 	StatusMessageCancelledBuild                  = "The build was cancelled by the user."
 	StatusMessageDockerBuildFailed               = "Dockerfile build strategy has failed."
+	StatusMessageDockerfileLintFailed            = "The Dockerfile failed linting."
+	StatusMessageSkipLayersUnsupported           = "The Dockerfile cannot be built without its layers."
+	StatusMessagePinBaseImagesFailed             = "Failed to resolve the base images to digests."
+	StatusMessageImageSizeBudgetExceeded         = "The image exceeds its size budget."
+	StatusMessageVulnerabilityScanFailed         = "The image failed its vulnerability scan."
 	StatusMessageBuildPodExists                  = "The pod for this build already exists and is older than the build."
 	StatusMessageNoBuildContainerStatus          = "The pod for this build has no container statuses indicating success or failure."
 	StatusMessageFailedContainer                 = "The pod for this build has at least one container with a non-zero exit status."
+	StatusMessageDryRun                          = "The build was a dry run, so no image was built or pushed; see the build log for its plan."
+	StatusMessageVFSStorageFallback              = "The build used the vfs storage driver, which is much slower than overlay; see the build log."
 	StatusMessageGenericBuildFailed              = "Generic Build failure - check logs for details."
 	StatusMessageUnresolvableEnvironmentVariable = "Unable to resolve build environment variable reference."
 	StatusMessageCannotRetrieveServiceAccount    = "Unable to look up the service account associated with this build."
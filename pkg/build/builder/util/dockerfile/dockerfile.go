@@ -164,3 +164,25 @@ func nextValues(node *parser.Node) []string {
 	}
 	return values
 }
+
+// RemoveLinkFlags removes the --link flags, which only change how BuildKit
+// caches the layers they create, from the COPY and ADD instructions of node,
+// as the builder rejects flags it does not know.
+func RemoveLinkFlags(node *parser.Node) {
+	if node == nil {
+		return
+	}
+	for _, child := range node.Children {
+		if child == nil || (child.Value != command.Copy && child.Value != command.Add) {
+			continue
+		}
+		var flags []string
+		for _, flag := range child.Flags {
+			if flag == "--link" || strings.HasPrefix(flag, "--link=") {
+				continue
+			}
+			flags = append(flags, flag)
+		}
+		child.Flags = flags
+	}
+}
@@ -0,0 +1,237 @@
+package dockerfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/openshift/imagebuilder/dockerfile/command"
+)
+
+// escapeDirective matches the parser directive which changes the escape
+// character of a Dockerfile.
+var escapeDirective = regexp.MustCompile(`^#[ \t]*escape[ \t]*=[ \t]*(.)`)
+
+// defaultShell is the shell which runs shell form RUN instructions in a stage
+// without a SHELL instruction.
+var defaultShell = []string{"/bin/sh", "-c"}
+
+// runShebangScript is run by /bin/sh to run a here-document which starts with
+// a #! line, passed as its first argument, as an executable of its own, so
+// that the interpreter it names runs it.
+const runShebangScript = `f=$(mktemp) && printf '%s' "$1" > "$f" && chmod +x "$f" && "$f"; status=$?; rm -f "$f"; exit $status`
+
+// heredoc is a here-document redirection, <<[-]["]WORD["], in the command of
+// an instruction.
+type heredoc struct {
+	// delimiter is the WORD which ends the here-document's body
+	delimiter string
+	// stripTabs is set by <<-, which strips leading tabs from the body
+	stripTabs bool
+	// start and end are the offsets of the redirection in the command
+	start, end int
+}
+
+// ExpandHeredocs returns the Dockerfile in with each RUN instruction which
+// uses here-documents, which the Dockerfile parser does not understand,
+// rewritten as an exec form RUN instruction which runs the same script with
+// the shell of its stage.  A RUN instruction whose command is only a
+// here-document runs the here-document as a script, with the interpreter of
+// its #! line if it has one.  Otherwise the command and the here-documents it
+// reads are run together by the shell.  Here-documents in other instructions,
+// such as COPY, are not supported.
+func ExpandHeredocs(in []byte) ([]byte, error) {
+	lines := strings.Split(string(in), "\n")
+	escape := "\\"
+	shell := defaultShell
+	var out []string
+	directives := true
+	for i := 0; i < len(lines); {
+		trimmed := strings.TrimSpace(lines[i])
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			if match := escapeDirective.FindStringSubmatch(strings.ToLower(trimmed)); directives && match != nil {
+				escape = match[1]
+			}
+			directives = directives && len(trimmed) > 0
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+		directives = false
+
+		// join the lines of the instruction, skipping the comments and
+		// blank lines between them as the parser does
+		start := i
+		var instruction string
+		for ; i < len(lines); i++ {
+			line := strings.TrimRightFunc(lines[i], isSpace)
+			if trimmed := strings.TrimSpace(line); i > start && (len(trimmed) == 0 || strings.HasPrefix(trimmed, "#")) {
+				continue
+			}
+			if !strings.HasSuffix(line, escape) {
+				instruction += line
+				i++
+				break
+			}
+			instruction += strings.TrimSuffix(line, escape)
+		}
+
+		prefix, keyword, flags, cmd := splitInstruction(instruction)
+		switch keyword {
+		case command.From:
+			shell = defaultShell
+		case command.Shell:
+			var args []string
+			if err := json.Unmarshal([]byte(cmd), &args); err == nil && len(args) > 0 {
+				shell = args
+			}
+		}
+		heredocs := findHeredocs(cmd)
+		if len(heredocs) == 0 || strings.HasPrefix(cmd, "[") {
+			out = append(out, lines[start:i]...)
+			continue
+		}
+		if keyword != command.Run {
+			return nil, fmt.Errorf("line %d: here-documents are only supported in RUN instructions, not %s", start+1, strings.ToUpper(keyword))
+		}
+
+		// the bodies of the here-documents follow the instruction in the
+		// order of their redirections
+		var bodies [][]string
+		for _, doc := range heredocs {
+			var body []string
+			for ; ; i++ {
+				if i >= len(lines) {
+					return nil, fmt.Errorf("line %d: here-document %s is not terminated", start+1, doc.delimiter)
+				}
+				line := strings.TrimSuffix(lines[i], "\r")
+				if doc.stripTabs {
+					line = strings.TrimLeft(line, "\t")
+				}
+				if line == doc.delimiter {
+					i++
+					break
+				}
+				body = append(body, line)
+			}
+			bodies = append(bodies, body)
+		}
+
+		var args []string
+		if doc := heredocs[0]; len(heredocs) == 1 && doc.start == 0 && doc.end == len(cmd) {
+			script := strings.Join(bodies[0], "\n") + "\n"
+			if strings.HasPrefix(script, "#!") {
+				args = []string{"/bin/sh", "-c", runShebangScript, "sh", script}
+			} else {
+				args = append(append([]string{}, shell...), script)
+			}
+		} else {
+			// the shell reads the here-documents itself, so they are
+			// passed to it with the delimiters which end them
+			script := cmd + "\n"
+			for j, doc := range heredocs {
+				for _, line := range bodies[j] {
+					script += line + "\n"
+				}
+				script += doc.delimiter + "\n"
+			}
+			args = append(append([]string{}, shell...), script)
+		}
+		exec, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, strings.Join(append(append([]string{prefix}, flags...), string(exec)), " "))
+	}
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// splitInstruction splits a Dockerfile instruction into the words before
+// its command, its lower case keyword, the flags which follow the keyword
+// and its command.  The keyword of an ONBUILD instruction is that of the
+// instruction it triggers.
+func splitInstruction(instruction string) (prefix, keyword string, flags []string, cmd string) {
+	rest := strings.TrimLeftFunc(instruction, isSpace)
+	word := func() string {
+		end := strings.IndexFunc(rest, isSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		w := rest[:end]
+		rest = strings.TrimLeftFunc(rest[end:], isSpace)
+		return w
+	}
+	prefix = word()
+	keyword = strings.ToLower(prefix)
+	if keyword == command.Onbuild && len(rest) > 0 {
+		inner := word()
+		prefix += " " + inner
+		keyword = strings.ToLower(inner)
+	}
+	for strings.HasPrefix(rest, "--") {
+		flags = append(flags, word())
+	}
+	return prefix, keyword, flags, strings.TrimRightFunc(rest, isSpace)
+}
+
+// findHeredocs returns the here-document redirections in the shell command
+// cmd, skipping those in quotes and here-strings.
+func findHeredocs(cmd string) []heredoc {
+	var heredocs []heredoc
+	var quote byte
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == '\\' && quote != '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(cmd[i:], "<<<"):
+			i += 2
+		case strings.HasPrefix(cmd[i:], "<<"):
+			doc := heredoc{start: i}
+			j := i + 2
+			if j < len(cmd) && cmd[j] == '-' {
+				doc.stripTabs = true
+				j++
+			}
+			var delimQuote byte
+			if j < len(cmd) && (cmd[j] == '\'' || cmd[j] == '"') {
+				delimQuote = cmd[j]
+				j++
+			}
+			k := j
+			for k < len(cmd) && (cmd[k] == '_' || isLetter(cmd[k]) || (k > j && cmd[k] >= '0' && cmd[k] <= '9')) {
+				k++
+			}
+			doc.delimiter = cmd[j:k]
+			if delimQuote != 0 {
+				if k >= len(cmd) || cmd[k] != delimQuote {
+					doc.delimiter = ""
+				}
+				k++
+			}
+			if len(doc.delimiter) == 0 {
+				i++
+				continue
+			}
+			doc.end = k
+			heredocs = append(heredocs, doc)
+			i = k - 1
+		}
+	}
+	return heredocs
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r'
+}
@@ -0,0 +1,133 @@
+package dockerfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandHeredocs(t *testing.T) {
+	testCases := map[string]struct {
+		in   string
+		want [][]string
+		err  bool
+	}{
+		"script": {
+			in: `FROM busybox
+RUN <<EOF
+set -e
+echo "hello $USER" > /greeting
+EOF
+USER 1001
+`,
+			want: [][]string{{"/bin/sh", "-c", "set -e\necho \"hello $USER\" > /greeting\n"}},
+		},
+		"script with flags and the stage's shell": {
+			in: `FROM busybox
+SHELL ["/bin/bash", "-eo", "pipefail", "-c"]
+RUN --mount=type=cache,target=/root/.cache <<-'EOF'
+	echo one
+	echo two
+	EOF
+`,
+			want: [][]string{{"/bin/bash", "-eo", "pipefail", "-c", "echo one\necho two\n"}},
+		},
+		"shell is reset by FROM": {
+			in: `FROM busybox
+SHELL ["/bin/bash", "-c"]
+FROM busybox
+run <<EOF
+true
+EOF
+`,
+			want: [][]string{{"/bin/sh", "-c", "true\n"}},
+		},
+		"script with an interpreter": {
+			in: `FROM python:3
+RUN <<EOF
+#!/usr/bin/env python3
+print("hello")
+EOF
+`,
+			want: [][]string{{"/bin/sh", "-c", runShebangScript, "sh", "#!/usr/bin/env python3\nprint(\"hello\")\n"}},
+		},
+		"command reading here-documents": {
+			in: `FROM busybox
+RUN cat <<EOF1 > /a && \
+    cat <<"EOF2" > /b
+first $HOME
+EOF1
+second $HOME
+EOF2
+`,
+			want: [][]string{{"/bin/sh", "-c", "cat <<EOF1 > /a &&     cat <<\"EOF2\" > /b\nfirst $HOME\nEOF1\nsecond $HOME\nEOF2\n"}},
+		},
+		"not here-documents": {
+			in: `FROM busybox
+RUN echo "<<EOF" && cat <<< "here-string" && echo $((1<<2))
+RUN ["sh", "-c", "cat <<EOF"]
+`,
+			want: [][]string{{`echo "<<EOF" && cat <<< "here-string" && echo $((1<<2))`}, {"sh", "-c", "cat <<EOF"}},
+		},
+		"unterminated": {
+			in: `FROM busybox
+RUN <<EOF
+echo
+`,
+			err: true,
+		},
+		"in COPY": {
+			in: `FROM busybox
+COPY <<EOF /etc/motd
+hello
+EOF
+`,
+			err: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			out, err := ExpandHeredocs([]byte(tc.in))
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.err {
+				return
+			}
+			node, err := Parse(strings.NewReader(string(out)))
+			if err != nil {
+				t.Fatalf("unable to parse\n%s\n%v", out, err)
+			}
+			var got [][]string
+			for _, i := range FindAll(node, "run") {
+				got = append(got, nextValues(node.Children[i]))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q from:\n%s", got, tc.want, out)
+			}
+		})
+	}
+}
+
+func TestExpandHeredocsKeepsOtherLines(t *testing.T) {
+	in := "# escape=`\nFROM busybox\nRUN echo a `\n  && echo b\n\n# a comment\nCOPY --link . /src\n"
+	out, err := ExpandHeredocs([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("expected the Dockerfile to be unchanged, got:\n%s", out)
+	}
+}
+
+func TestRemoveLinkFlags(t *testing.T) {
+	node, err := Parse(strings.NewReader("FROM busybox\nCOPY --link --chown=1001 . /src\nADD --link=true file /\nCOPY --from=build /a /b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RemoveLinkFlags(node)
+	want := "FROM busybox\nCOPY --chown=1001 . /src\nADD file /\nCOPY --from=build /a /b\n"
+	if got := string(Write(node)); got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// lastOutput is when the process last wrote to its redirected standard
+// output, in nanoseconds since the Unix epoch, or zero if it has not.
+var lastOutput int64
+
+// LastOutput returns when the process, or one of its children such as a RUN
+// instruction or an assemble script, last wrote to its standard output since
+// it was redirected, or the zero time if it has not.  The builder's own log
+// messages go to its standard error, so they are not counted.
+func LastOutput() time.Time {
+	if nanos := atomic.LoadInt64(&lastOutput); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// outputActivityWriter records when it is written to as the last output of
+// the process.
+type outputActivityWriter struct {
+	io.WriteCloser
+}
+
+func (w outputActivityWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(&lastOutput, time.Now().UnixNano())
+	return w.WriteCloser.Write(p)
+}
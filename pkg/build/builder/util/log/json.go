@@ -0,0 +1,146 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// klogHeader matches the header which klog prefixes its lines with, such as
+// "I1015 12:00:00.000000   12345 file.go:10] ".
+var klogHeader = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ [^ \]]+\] `)
+
+var klogSeverities = map[string]string{
+	"I": "INFO",
+	"W": "WARNING",
+	"E": "ERROR",
+	"F": "FATAL",
+}
+
+// jsonRecord is a single line of output formatted as JSON.
+type jsonRecord struct {
+	Time     string `json:"time"`
+	Severity string `json:"severity"`
+	Stage    string `json:"stage,omitempty"`
+	Stream   string `json:"stream"`
+	Event    string `json:"event,omitempty"`
+	Message  string `json:"message"`
+}
+
+// jsonLog holds the state shared by all of the JSON writers: the build stage
+// which output currently belongs to and, once output is redirected, where
+// stage transitions are written.
+var jsonLog = struct {
+	sync.Mutex
+	out   io.Writer
+	stage string
+}{}
+
+// SetStage records the build stage that subsequent output belongs to.  When
+// output is formatted as JSON, entering a new stage is itself logged.
+func SetStage(stage string) {
+	jsonLog.Lock()
+	defer jsonLog.Unlock()
+	if stage == jsonLog.stage {
+		return
+	}
+	jsonLog.stage = stage
+	if jsonLog.out != nil {
+		writeJSONRecord(jsonLog.out, jsonRecord{
+			Severity: "INFO",
+			Stream:   "builder",
+			Event:    "stage",
+			Message:  fmt.Sprintf("Starting stage %s", stage),
+		})
+	}
+}
+
+// Stage returns the build stage that output currently belongs to.
+func Stage() string {
+	jsonLog.Lock()
+	defer jsonLog.Unlock()
+	return jsonLog.stage
+}
+
+// writeJSONRecord writes record to w, filling in its time and stage.  The
+// caller must hold the jsonLog lock.
+func writeJSONRecord(w io.Writer, record jsonRecord) {
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	record.Stage = jsonLog.stage
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		fmt.Fprintf(w, "%s\n", record.Message)
+	}
+}
+
+// parseSeverity returns the severity of a line of output and the line with
+// any klog header removed.  Lines are INFO unless they carry a klog header or
+// start with "error:" or "warning:", as the builder's own messages do.
+func parseSeverity(line string) (string, string) {
+	if match := klogHeader.FindStringSubmatch(line); match != nil {
+		return klogSeverities[match[1]], line[len(match[0]):]
+	}
+	lower := strings.ToLower(strings.TrimSpace(line))
+	switch {
+	case strings.HasPrefix(lower, "error:"), strings.HasPrefix(lower, "fatal:"):
+		return "ERROR", line
+	case strings.HasPrefix(lower, "warning:"), strings.HasPrefix(lower, "warn:"):
+		return "WARNING", line
+	}
+	return "INFO", line
+}
+
+// jsonWriter turns each line written to it into a JSON record.
+type jsonWriter struct {
+	out    io.Writer
+	stream string
+	buf    bytes.Buffer
+}
+
+// NewJSONWriter returns a writer which writes each line written to it to out
+// as a JSON record with a timestamp, the current build stage, the name of the
+// stream the line was written to and its severity, with its secrets masked as
+// Scrub does.  Close writes any final unterminated line.
+func NewJSONWriter(out io.Writer, stream string) io.WriteCloser {
+	return &jsonWriter{out: out, stream: stream}
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf.Next(i + 1))
+		w.writeLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+func (w *jsonWriter) Close() error {
+	if w.buf.Len() > 0 {
+		w.writeLine(w.buf.String())
+		w.buf.Reset()
+	}
+	return nil
+}
+
+func (w *jsonWriter) writeLine(line string) {
+	// blank lines are only used to space out the text output
+	if len(strings.TrimSpace(line)) == 0 {
+		return
+	}
+	severity, message := parseSeverity(line)
+	jsonLog.Lock()
+	defer jsonLog.Unlock()
+	writeJSONRecord(w.out, jsonRecord{
+		Severity: severity,
+		Stream:   w.stream,
+		Message:  Scrub(message),
+	})
+}
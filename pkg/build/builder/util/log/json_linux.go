@@ -0,0 +1,121 @@
+package log
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// restoreTimeout is how long restoring the original streams waits for the
+// output written to the pipes which replaced them.
+var restoreTimeout = 5 * time.Second
+
+// RedirectToJSON replaces the standard output and standard error of the
+// process, which child processes such as git and the build's RUN
+// instructions inherit, with pipes whose lines are written to the original
+// standard output as JSON records.  The returned function restores the
+// original streams once everything written to the pipes has been formatted.
+func RedirectToJSON() (func(), error) {
+	outFd, err := unix.Dup(int(os.Stdout.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	out := os.NewFile(uintptr(outFd), "stdout")
+	restore, err := redirectStreams(func(name string) io.WriteCloser {
+		return NewJSONWriter(out, name)
+	})
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	jsonLog.Lock()
+	jsonLog.out = out
+	jsonLog.Unlock()
+	return func() {
+		restore()
+		jsonLog.Lock()
+		jsonLog.out = nil
+		jsonLog.Unlock()
+		out.Close()
+	}, nil
+}
+
+// redirectStreams replaces the standard output and standard error of the process
+// with pipes whose output is copied to the writers newWriter returns for
+// them.  The returned function restores the original streams once everything
+// written to the pipes has been copied, or once restoreTimeout has passed, as
+// a process which outlives the build, such as an ssh-agent, may hold the
+// pipes open.
+func redirectStreams(newWriter func(name string) io.WriteCloser) (func(), error) {
+
+	type redirect struct {
+		fd    int
+		saved int
+		pipe  *os.File
+		done  chan struct{}
+	}
+	var redirects []redirect
+	restore := func() {
+		for _, r := range redirects {
+			// replacing the pipe closes its last write end, unless a
+			// child process still has it open
+			unix.Dup3(r.saved, r.fd, 0)
+			unix.Close(r.saved)
+		}
+		timeout := time.After(restoreTimeout)
+		for _, r := range redirects {
+			select {
+			case <-r.done:
+			case <-timeout:
+				// closing the read end ends the copy, dropping whatever
+				// the remaining writers write from now on
+				r.pipe.Close()
+				<-r.done
+			}
+		}
+	}
+
+	for _, stream := range []struct {
+		file *os.File
+		name string
+	}{
+		{os.Stdout, "stdout"},
+		{os.Stderr, "stderr"},
+	} {
+		fd := int(stream.file.Fd())
+		saved, err := unix.Dup(fd)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			unix.Close(saved)
+			restore()
+			return nil, err
+		}
+		err = unix.Dup3(int(w.Fd()), fd, 0)
+		w.Close()
+		if err != nil {
+			r.Close()
+			unix.Close(saved)
+			restore()
+			return nil, err
+		}
+		done := make(chan struct{})
+		writer := newWriter(stream.name)
+		if stream.name == "stdout" {
+			writer = outputActivityWriter{writer}
+		}
+		go func() {
+			defer close(done)
+			defer r.Close()
+			io.Copy(writer, r)
+			writer.Close()
+		}()
+		redirects = append(redirects, redirect{fd: fd, saved: saved, pipe: r, done: done})
+	}
+	return restore, nil
+}
@@ -0,0 +1,39 @@
+// +build linux
+
+package log
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRedirectToJSONRestoreTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { restoreTimeout = timeout }(restoreTimeout)
+	restoreTimeout = 100 * time.Millisecond
+
+	restore, err := RedirectToJSON()
+	if err != nil {
+		t.Skipf("unable to redirect the output: %v", err)
+	}
+	// a process which outlives the build, holding the pipe open
+	sleep := exec.Command("sleep", "30")
+	sleep.Stdout = os.Stdout
+	if err := sleep.Start(); err != nil {
+		restore()
+		t.Fatalf("unable to start a child process: %v", err)
+	}
+	defer sleep.Process.Kill()
+
+	restored := make(chan struct{})
+	go func() {
+		restore()
+		close(restored)
+	}()
+	select {
+	case <-restored:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the streams to be restored while a child process holds them open")
+	}
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	jsonLog.out = out
+	defer func() {
+		jsonLog.out = nil
+		jsonLog.stage = ""
+	}()
+
+	SetStage("FetchInputs")
+	w := NewJSONWriter(out, "stderr")
+	w.Write([]byte("Cloning \"https://example.com/app.git\" ...\n\nwarning: Failed to remove"))
+	SetStage("FetchInputs")
+	SetStage("Build")
+	w.Write([]byte(" the temporary tag\r\nI1015 12:00:00.000000   42 builder.go:10] starting\n"))
+	w.Write([]byte("E1015 12:00:01.000000   42 builder.go:11] failed\nSTEP 1: FROM busybox"))
+	w.Close()
+
+	expected := []jsonRecord{
+		{Severity: "INFO", Stage: "FetchInputs", Stream: "builder", Event: "stage", Message: "Starting stage FetchInputs"},
+		{Severity: "INFO", Stage: "FetchInputs", Stream: "stderr", Message: "Cloning \"https://example.com/app.git\" ..."},
+		{Severity: "INFO", Stage: "Build", Stream: "builder", Event: "stage", Message: "Starting stage Build"},
+		{Severity: "WARNING", Stage: "Build", Stream: "stderr", Message: "warning: Failed to remove the temporary tag"},
+		{Severity: "INFO", Stage: "Build", Stream: "stderr", Message: "starting"},
+		{Severity: "ERROR", Stage: "Build", Stream: "stderr", Message: "failed"},
+		{Severity: "INFO", Stage: "Build", Stream: "stderr", Message: "STEP 1: FROM busybox"},
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d records, got %d:\n%s", len(expected), len(lines), out.String())
+	}
+	for i, line := range lines {
+		var record jsonRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("record %d is not JSON: %v: %s", i, err, line)
+		}
+		if len(record.Time) == 0 {
+			t.Errorf("record %d has no time: %s", i, line)
+		}
+		record.Time = ""
+		if record != expected[i] {
+			t.Errorf("record %d: expected %#v, got %#v", i, expected[i], record)
+		}
+	}
+}
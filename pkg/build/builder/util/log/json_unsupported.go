@@ -0,0 +1,10 @@
+// +build !linux
+
+package log
+
+import "errors"
+
+// RedirectToJSON is only supported on Linux.
+func RedirectToJSON() (func(), error) {
+	return nil, errors.New("JSON log output is only supported on Linux")
+}
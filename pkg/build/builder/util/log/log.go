@@ -47,7 +47,7 @@ func (klogger) V(level int) Logger {
 }
 
 func (klogger) Infof(format string, args ...interface{}) {
-	klog.InfoDepth(2, fmt.Sprintf(format, args...))
+	klog.InfoDepth(2, Scrub(fmt.Sprintf(format, args...)))
 }
 
 // kverbose handles klog.V(x) calls
@@ -68,7 +68,7 @@ func (kverbose) V(level int) Logger {
 
 func (g kverbose) Infof(format string, args ...interface{}) {
 	if g.Verbose {
-		klog.InfoDepth(2, fmt.Sprintf(format, args...))
+		klog.InfoDepth(2, Scrub(fmt.Sprintf(format, args...)))
 	}
 }
 
@@ -96,7 +96,7 @@ func (f file) V(level int) Logger {
 }
 
 func (f file) Infof(format string, args ...interface{}) {
-	fmt.Fprintf(f.w, format, args...)
+	fmt.Fprint(f.w, Scrub(fmt.Sprintf(format, args...)))
 	if !strings.HasSuffix(format, "\n") {
 		fmt.Fprintln(f.w)
 	}
@@ -0,0 +1,150 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets and the matches of the scrub rules in the
+// output of the builder.
+const Redacted = "*****"
+
+// minSecretLength is the length below which values are not scrubbed from the
+// output, as short values such as "1" or "true" would mask far more than the
+// secret.
+const minSecretLength = 4
+
+// scrubbing holds the secrets which are masked in the output and the rules
+// whose matches are.
+var scrubbing = struct {
+	sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+	rules    []*regexp.Regexp
+}{}
+
+// AddSecrets adds values which are masked wherever they appear in the output
+// written through the scrubbing and JSON writers, and by Scrub.  Values
+// shorter than four characters are ignored.
+func AddSecrets(values ...string) {
+	scrubbing.Lock()
+	defer scrubbing.Unlock()
+	known := make(map[string]bool)
+	for _, secret := range scrubbing.secrets {
+		known[secret] = true
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) < minSecretLength || known[value] {
+			continue
+		}
+		known[value] = true
+		scrubbing.secrets = append(scrubbing.secrets, value)
+	}
+	// the longest secrets are replaced first, so that one which contains
+	// another is masked as a whole
+	sort.SliceStable(scrubbing.secrets, func(i, j int) bool {
+		return len(scrubbing.secrets[i]) > len(scrubbing.secrets[j])
+	})
+	var pairs []string
+	for _, secret := range scrubbing.secrets {
+		pairs = append(pairs, secret, Redacted)
+	}
+	scrubbing.replacer = strings.NewReplacer(pairs...)
+}
+
+// AddScrubRules adds regular expressions whose matches are masked in the
+// output.  Only the text of the submatches of a rule with groups, such as
+// `password=(\S+)`, is masked, and all of the text of a rule without.
+func AddScrubRules(rules ...*regexp.Regexp) {
+	scrubbing.Lock()
+	defer scrubbing.Unlock()
+	scrubbing.rules = append(scrubbing.rules, rules...)
+}
+
+// resetScrubbing forgets the secrets and the rules.
+func resetScrubbing() {
+	scrubbing.Lock()
+	defer scrubbing.Unlock()
+	scrubbing.secrets, scrubbing.replacer, scrubbing.rules = nil, nil, nil
+}
+
+// Scrub returns s with the secrets and the matches of the scrub rules masked.
+func Scrub(s string) string {
+	scrubbing.RLock()
+	defer scrubbing.RUnlock()
+	if scrubbing.replacer != nil {
+		s = scrubbing.replacer.Replace(s)
+	}
+	for _, rule := range scrubbing.rules {
+		s = scrubRule(rule, s)
+	}
+	return s
+}
+
+// scrubRule masks the matches of rule in s, or their submatches if rule has
+// groups.
+func scrubRule(rule *regexp.Regexp, s string) string {
+	if rule.NumSubexp() == 0 {
+		return rule.ReplaceAllLiteralString(s, Redacted)
+	}
+	var out strings.Builder
+	last := 0
+	for _, match := range rule.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(match); i += 2 {
+			start, end := match[i], match[i+1]
+			// groups which did not match, or are nested in an earlier
+			// one, are skipped
+			if start < 0 || start < last {
+				continue
+			}
+			out.WriteString(s[last:start])
+			out.WriteString(Redacted)
+			last = end
+		}
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
+
+// scrubWriter masks the secrets in each line written to it.
+type scrubWriter struct {
+	out io.Writer
+	buf bytes.Buffer
+}
+
+// NewScrubWriter returns a writer which writes what is written to it to out,
+// a line at a time, with the secrets and the matches of the scrub rules
+// masked.  Lines end with a newline or a carriage return, which progress
+// output uses to redraw a line.  Close writes any final unterminated line.
+func NewScrubWriter(out io.Writer) io.WriteCloser {
+	return &scrubWriter{out: out}
+}
+
+func (w *scrubWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexAny(w.buf.Bytes(), "\r\n")
+		if i < 0 {
+			break
+		}
+		line := string(w.buf.Next(i + 1))
+		if _, err := io.WriteString(w.out, Scrub(line[:i])+line[i:]); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *scrubWriter) Close() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, Scrub(w.buf.String()))
+	w.buf.Reset()
+	return err
+}
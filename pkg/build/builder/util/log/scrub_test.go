@@ -0,0 +1,71 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	defer resetScrubbing()
+	AddSecrets("s3cr3t", "s3cr3t-longer", "1", "", "s3cr3t")
+	AddScrubRules(regexp.MustCompile(`ghp_[A-Za-z0-9]+`), regexp.MustCompile(`(?i)password=(\S+)|token: (\S+)`))
+
+	tests := map[string]string{
+		"no secrets":                             "no secrets",
+		"using s3cr3t":                           "using *****",
+		"using s3cr3t-longer":                    "using *****",
+		"step 1 of 1":                            "step 1 of 1",
+		"token ghp_abc123 found":                 "token ***** found",
+		"PASSWORD=hunter2 user=admin token: abc": "PASSWORD=***** user=admin token: *****",
+	}
+	for in, expect := range tests {
+		if got := Scrub(in); got != expect {
+			t.Errorf("%q: expected %q, got %q", in, expect, got)
+		}
+	}
+}
+
+func TestScrubWriter(t *testing.T) {
+	defer resetScrubbing()
+	AddSecrets("s3cr3t")
+
+	out := &bytes.Buffer{}
+	w := NewScrubWriter(out)
+	w.Write([]byte("Pulling with s3"))
+	w.Write([]byte("cr3t\nprogress s3cr3t\rprogress done\nfinal s3cr3"))
+	w.Write([]byte("t"))
+	w.Close()
+	if expect := "Pulling with *****\nprogress *****\rprogress done\nfinal *****"; out.String() != expect {
+		t.Errorf("expected %q, got %q", expect, out.String())
+	}
+}
+
+func TestLoggerScrubs(t *testing.T) {
+	defer resetScrubbing()
+	AddSecrets("s3cr3t")
+
+	out := &bytes.Buffer{}
+	ToFile(out, 2).V(0).Infof("Logging in with %s", "s3cr3t")
+	if expect := "Logging in with *****\n"; out.String() != expect {
+		t.Errorf("expected %q, got %q", expect, out.String())
+	}
+}
+
+func TestJSONWriterScrubs(t *testing.T) {
+	defer resetScrubbing()
+	AddSecrets("s3cr3t")
+
+	out := &bytes.Buffer{}
+	w := NewJSONWriter(out, "stdout")
+	w.Write([]byte("error: login failed with s3cr3t\n"))
+	w.Close()
+	var record jsonRecord
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "error: login failed with *****"; record.Message != expect {
+		t.Errorf("expected %q, got %q", expect, record.Message)
+	}
+}
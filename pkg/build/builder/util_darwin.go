@@ -1,6 +1,8 @@
 package builder
 
 import (
+	"syscall"
+
 	s2iapi "github.com/openshift/source-to-image/pkg/api"
 )
 
@@ -24,3 +26,29 @@ func GetCGroupLimits() (*s2iapi.CGroupLimits, error) {
 func getCgroupParent() (string, error) {
 	return "", nil
 }
+
+// killChildProcesses sends sig to each of the processes descended from the
+// builder and returns their IDs.
+func killChildProcesses(sig syscall.Signal) []int {
+	return nil
+}
+
+// oomKills returns how many of the builder's processes the kernel has killed
+// for exceeding its memory limit.
+func oomKills() int64 {
+	return 0
+}
+
+// diskSpace returns the device of the filesystem holding path, and the bytes
+// available to the builder and in total on it.
+func diskSpace(path string) (uint64, uint64, uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, 0, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	return uint64(st.Dev), fs.Bavail * uint64(fs.Bsize), fs.Blocks * uint64(fs.Bsize), nil
+}
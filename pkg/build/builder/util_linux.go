@@ -1,48 +1,128 @@
 package builder
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	s2iapi "github.com/openshift/source-to-image/pkg/api"
 )
 
+// cgroupRoot is where the builder's cgroup hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// maxCgroupMemory is the largest memory limit passed on to build containers.
+// math.MaxInt64 seems to give cgroups trouble, this value is still 92
+// terabytes, so it ought to be sufficiently large for our purposes.
+const maxCgroupMemory = 92233720368547
+
 // GetCGroupLimits returns a struct populated with cgroup limit values gathered
-// from the local /sys/fs/cgroup filesystem.  Overflow values are set to
-// math.MaxInt64.
+// from the local /sys/fs/cgroup filesystem, which may be either a cgroup v1
+// or a cgroup v2 hierarchy.  Overflow values are set to math.MaxInt64.
 func GetCGroupLimits() (*s2iapi.CGroupLimits, error) {
-	byteLimit, err := readInt64("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	limits, err := readCGroupLimits(cgroupRoot)
 	if err != nil {
 		// for systems without cgroups builds should succeed
-		if _, err := os.Stat("/sys/fs/cgroup"); os.IsNotExist(err) {
+		if _, err := os.Stat(cgroupRoot); os.IsNotExist(err) {
 			return &s2iapi.CGroupLimits{}, nil
 		}
 		return nil, fmt.Errorf("cannot determine cgroup limits: %v", err)
 	}
-	// math.MaxInt64 seems to give cgroups trouble, this value is
-	// still 92 terabytes, so it ought to be sufficiently large for
-	// our purposes.
-	if byteLimit > 92233720368547 {
-		byteLimit = 92233720368547
-	}
 
 	parent, err := getCgroupParent()
 	if err != nil {
 		return nil, fmt.Errorf("read cgroup parent: %v", err)
 	}
+	limits.Parent = parent
+	return limits, nil
+}
+
+// isCgroupV2 returns whether the cgroup hierarchy at root is a cgroup v2
+// (unified) one.
+func isCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
 
-	return &s2iapi.CGroupLimits{
-		// Though we are capped on memory and cpu at the cgroup parent level,
-		// some build containers care what their memory limit is so they can
-		// adapt, thus we need to set the memory limit at the container level
-		// too, so that information is available to them.
-		MemoryLimitBytes: byteLimit,
-		// Set memoryswap==memorylimit, this ensures no swapping occurs.
-		// see: https://docs.docker.com/engine/reference/run/#runtime-constraints-on-cpu-and-memory
-		MemorySwap: byteLimit,
-		Parent:     parent,
-	}, nil
+// readCGroupLimits returns the memory and CPU limits of the cgroup hierarchy
+// at root.
+func readCGroupLimits(root string) (*s2iapi.CGroupLimits, error) {
+	limits := &s2iapi.CGroupLimits{}
+	if isCgroupV2(root) {
+		memory, err := readCgroupMax(filepath.Join(root, "memory.max"))
+		if err != nil {
+			return nil, err
+		}
+		limits.MemoryLimitBytes = memory
+		// cpu.max holds the quota and the period, with a quota of "max"
+		// when there is none
+		if data, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+			if fields := strings.Fields(string(data)); len(fields) == 2 && fields[0] != "max" {
+				quota, quotaErr := strconv.ParseInt(fields[0], 10, 64)
+				period, periodErr := strconv.ParseInt(fields[1], 10, 64)
+				if quotaErr == nil && periodErr == nil {
+					limits.CPUQuota, limits.CPUPeriod = quota, period
+				}
+			}
+		}
+	} else {
+		memory, err := readInt64(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+		if err != nil {
+			return nil, err
+		}
+		limits.MemoryLimitBytes = memory
+		// a quota of -1 means that there is none
+		if quota, err := readInt64(filepath.Join(root, "cpu", "cpu.cfs_quota_us")); err == nil && quota > 0 {
+			if period, err := readInt64(filepath.Join(root, "cpu", "cpu.cfs_period_us")); err == nil {
+				limits.CPUQuota, limits.CPUPeriod = quota, period
+			}
+		}
+	}
+	if limits.MemoryLimitBytes > maxCgroupMemory {
+		limits.MemoryLimitBytes = maxCgroupMemory
+	}
+	// Though we are capped on memory and cpu at the cgroup parent level,
+	// some build containers care what their memory limit is so they can
+	// adapt, thus we need to set the memory limit at the container level
+	// too, so that information is available to them.
+	// Set memoryswap==memorylimit, this ensures no swapping occurs.
+	// see: https://docs.docker.com/engine/reference/run/#runtime-constraints-on-cpu-and-memory
+	limits.MemorySwap = limits.MemoryLimitBytes
+	return limits, nil
+}
+
+// readCgroupMax reads a cgroup limit which is "max" when there is none, as
+// those of cgroup v2 and the pids.max of cgroup v1 are.
+func readCgroupMax(filePath string) (int64, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return -1, err
+	}
+	if strings.TrimSpace(string(data)) == "max" {
+		return math.MaxInt64, nil
+	}
+	return readInt64(filePath)
+}
+
+// getPidsLimit returns the most processes the cgroup hierarchy at root may
+// hold, or zero if there is no limit or it cannot be read.
+func getPidsLimit(root string) int64 {
+	path := filepath.Join(root, "pids", "pids.max")
+	if isCgroupV2(root) {
+		path = filepath.Join(root, "pids.max")
+	}
+	limit, err := readCgroupMax(path)
+	if err != nil || limit == math.MaxInt64 {
+		return 0
+	}
+	return limit
 }
 
 // getCgroupParent determines the parent cgroup for a container from
@@ -55,3 +135,83 @@ func getCgroupParent() (string, error) {
 	log.V(6).Infof("found cgroup values map: %v", cgMap)
 	return extractParentFromCgroupMap(cgMap)
 }
+
+// killChildProcesses sends sig to each of the processes descended from the
+// builder, such as git and the processes run by RUN instructions, and
+// returns their IDs.
+func killChildProcesses(sig syscall.Signal) []int {
+	children := map[int][]int{}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// the command name in parentheses may itself hold spaces and
+		// parentheses, and the parent's ID is the second field after it
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+	var killed []int
+	pending := children[os.Getpid()]
+	for len(pending) > 0 {
+		pid := pending[0]
+		pending = append(pending[1:], children[pid]...)
+		if err := syscall.Kill(pid, sig); err == nil {
+			killed = append(killed, pid)
+		}
+	}
+	return killed
+}
+
+// oomKills returns how many of the builder's processes the kernel has killed
+// for exceeding its memory limit.
+func oomKills() int64 {
+	return cgroupOOMKills(cgroupRoot)
+}
+
+// cgroupOOMKills returns the oom_kill count of the memory controller of the
+// cgroup hierarchy at root, or 0 if it cannot be read.
+func cgroupOOMKills(root string) int64 {
+	path := filepath.Join(root, "memory.events")
+	if !isCgroupV2(root) {
+		path = filepath.Join(root, "memory", "memory.oom_control")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.ParseInt(fields[1], 10, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+// diskSpace returns the device of the filesystem holding path, and the bytes
+// available to the builder and in total on it.
+func diskSpace(path string) (uint64, uint64, uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, 0, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	return uint64(st.Dev), fs.Bavail * uint64(fs.Bsize), fs.Blocks * uint64(fs.Bsize), nil
+}
@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	s2iapi "github.com/openshift/source-to-image/pkg/api"
+)
+
+// cgroupHierarchy writes files, relative to a new temporary directory, which
+// it returns.
+func cgroupHierarchy(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadCGroupLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		expected  *s2iapi.CGroupLimits
+		pids      int64
+		expectErr bool
+	}{
+		{
+			name: "v1",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "536870912\n",
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"pids/pids.max":                "1024\n",
+			},
+			expected: &s2iapi.CGroupLimits{MemoryLimitBytes: 536870912, MemorySwap: 536870912, CPUQuota: 50000, CPUPeriod: 100000},
+			pids:     1024,
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"pids/pids.max":                "max\n",
+			},
+			expected: &s2iapi.CGroupLimits{MemoryLimitBytes: 92233720368547, MemorySwap: 92233720368547},
+		},
+		{
+			name: "v2",
+			files: map[string]string{
+				"cgroup.controllers": "cpuset cpu io memory pids\n",
+				"memory.max":         "536870912\n",
+				"cpu.max":            "50000 100000\n",
+				"pids.max":           "1024\n",
+			},
+			expected: &s2iapi.CGroupLimits{MemoryLimitBytes: 536870912, MemorySwap: 536870912, CPUQuota: 50000, CPUPeriod: 100000},
+			pids:     1024,
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpuset cpu io memory pids\n",
+				"memory.max":         "max\n",
+				"cpu.max":            "max 100000\n",
+				"pids.max":           "max\n",
+			},
+			expected: &s2iapi.CGroupLimits{MemoryLimitBytes: 92233720368547, MemorySwap: 92233720368547},
+		},
+		{
+			name:      "v2 without the memory controller",
+			files:     map[string]string{"cgroup.controllers": "cpu\n"},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		root := cgroupHierarchy(t, test.files)
+		defer os.RemoveAll(root)
+		limits, err := readCGroupLimits(root)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(limits, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, limits)
+		}
+		if pids := getPidsLimit(root); pids != test.pids {
+			t.Errorf("%s: expected a pids limit of %d, got %d", test.name, test.pids, pids)
+		}
+	}
+}
+
+func TestCgroupOOMKills(t *testing.T) {
+	v2 := cgroupHierarchy(t, map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"memory.events":      "low 0\nhigh 0\nmax 12\noom 2\noom_kill 2\n",
+	})
+	defer os.RemoveAll(v2)
+	if count := cgroupOOMKills(v2); count != 2 {
+		t.Errorf("expected 2 OOM kills in the cgroup v2 hierarchy, got %d", count)
+	}
+	v1 := cgroupHierarchy(t, map[string]string{
+		"memory/memory.oom_control": "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n",
+	})
+	defer os.RemoveAll(v1)
+	if count := cgroupOOMKills(v1); count != 1 {
+		t.Errorf("expected 1 OOM kill in the cgroup v1 hierarchy, got %d", count)
+	}
+	empty := cgroupHierarchy(t, nil)
+	defer os.RemoveAll(empty)
+	if count := cgroupOOMKills(empty); count != 0 {
+		t.Errorf("expected no OOM kills without a memory controller, got %d", count)
+	}
+}
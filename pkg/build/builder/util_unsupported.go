@@ -4,6 +4,7 @@ package builder
 
 import (
 	"errors"
+	"syscall"
 
 	s2iapi "github.com/openshift/source-to-image/pkg/api"
 )
@@ -28,3 +29,21 @@ func GetCGroupLimits() (*s2iapi.CGroupLimits, error) {
 func getCgroupParent() (string, error) {
 	return "", errors.New("getCgroupParent is unsupported on this platform")
 }
+
+// killChildProcesses sends sig to each of the processes descended from the
+// builder and returns their IDs.
+func killChildProcesses(sig syscall.Signal) []int {
+	return nil
+}
+
+// oomKills returns how many of the builder's processes the kernel has killed
+// for exceeding its memory limit.
+func oomKills() int64 {
+	return 0
+}
+
+// diskSpace returns the device of the filesystem holding path, and the bytes
+// available to the builder and in total on it.
+func diskSpace(path string) (uint64, uint64, uint64, error) {
+	return 0, 0, 0, errors.New("diskSpace is unsupported on this platform")
+}
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// StatusReasonSourceVerificationFailed is the reason a build fails when the
+// revision of its source fails the verification which its build strategy's
+// environment requests.
+const StatusReasonSourceVerificationFailed buildapiv1.StatusReason = "SourceVerificationFailed"
+
+// allowedSignersKey is the signing key of the build's source secret which
+// holds the ssh allowed signers file against which SSH signatures are
+// verified.  Its other signing keys are OpenPGP public keys.
+const allowedSignersKey = "allowed_signers"
+
+// sourceVerificationError says why the revision of the build's source failed
+// verification.
+type sourceVerificationError string
+
+func (e sourceVerificationError) Error() string {
+	return "source verification failed: " + string(e)
+}
+
+// SourceVerificationPolicy is how the revision of a build's git source is
+// verified once it has been fetched.
+type SourceVerificationPolicy struct {
+	// KeyFiles, if set, are the files of the build's source secret holding
+	// the keys against which the signature of the commit is verified
+	KeyFiles []string
+	// AllowedRefs, if set, are the patterns, as matched by path.Match, of
+	// the refs which may be built
+	AllowedRefs []string
+}
+
+// GetSourceVerificationPolicy returns how the build strategy's environment
+// requests that the revision of the build's source is verified, or nil if
+// it is not.  The signing keys are taken from the build's source secret,
+// mounted at sourceSecretDir, as it is the only secret mounted while the
+// source is fetched and verified.
+func GetSourceVerificationPolicy(build *buildapiv1.Build, sourceSecretDir string) (*SourceVerificationPolicy, error) {
+	policy := &SourceVerificationPolicy{}
+	if value, _ := buildStrategyEnv(build, builderutil.GitSigningKeys); len(strings.TrimSpace(value)) > 0 {
+		value = strings.TrimSpace(value)
+		if build.Spec.Source.SourceSecret == nil || len(sourceSecretDir) == 0 {
+			return nil, fmt.Errorf("invalid %s value %q: the build has no source secret to hold the signing keys", builderutil.GitSigningKeys, value)
+		}
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); len(key) == 0 {
+				continue
+			}
+			keyFile := filepath.Join(sourceSecretDir, key)
+			if key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+				return nil, fmt.Errorf("invalid %s value %q: %q is not the name of a key of a secret", builderutil.GitSigningKeys, value, key)
+			}
+			if _, err := os.Stat(keyFile); err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: the build's source secret has no %q key", builderutil.GitSigningKeys, value, key)
+			}
+			policy.KeyFiles = append(policy.KeyFiles, keyFile)
+		}
+	}
+	if value, _ := buildStrategyEnv(build, builderutil.GitAllowedRefs); len(value) > 0 {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); len(pattern) == 0 {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %q is not a valid pattern: %v", builderutil.GitAllowedRefs, value, pattern, err)
+			}
+			policy.AllowedRefs = append(policy.AllowedRefs, pattern)
+		}
+	}
+	if len(policy.KeyFiles) == 0 && len(policy.AllowedRefs) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// refAllowed returns whether ref, or ref without its refs/heads/ or
+// refs/tags/ prefix, matches one of patterns.
+func refAllowed(ref string, patterns []string) bool {
+	short := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, short); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySource checks that the ref of the build's git source, fetched into
+// dir by git run with gitEnv, is one which policy allows, and that the commit
+// checked out is signed by one of its keys.  A revision which fails is
+// reported as a sourceVerificationError.
+func VerifySource(gitEnv []string, build *buildapiv1.Build, dir string, policy *SourceVerificationPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if build.Spec.Source.Git == nil {
+		return sourceVerificationError("the build's source is not a git repository")
+	}
+	gitClient := NewGitClient(gitEnv)
+
+	if len(policy.AllowedRefs) > 0 {
+		ref := build.Spec.Source.Git.Ref
+		if len(ref) == 0 {
+			// the repository's default branch was cloned
+			ref, _, _ = gitClient.Run(dir, "symbolic-ref", "--short", "HEAD")
+		}
+		if !refAllowed(ref, policy.AllowedRefs) {
+			return sourceVerificationError(fmt.Sprintf("the ref %q is not one of the refs allowed by %s: %s", ref, builderutil.GitAllowedRefs, strings.Join(policy.AllowedRefs, ", ")))
+		}
+		log.V(0).Infof("The ref %q is allowed to be built", ref)
+	}
+
+	if len(policy.KeyFiles) == 0 {
+		return nil
+	}
+	commit, _, err := gitClient.Run(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("unable to find the commit to verify: %v", err)
+	}
+	gnupgHome, err := ioutil.TempDir("", "gnupg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(gnupgHome)
+	args, err := importSigningKeys(gnupgHome, policy.KeyFiles)
+	if err != nil {
+		return err
+	}
+	args = append(args, "verify-commit", "HEAD")
+	if _, errOut, err := NewGitClient(append(gitEnv, "GNUPGHOME="+gnupgHome)).Run(dir, args...); err != nil {
+		if len(errOut) == 0 {
+			errOut = err.Error()
+		}
+		return sourceVerificationError(fmt.Sprintf("the signature of commit %s could not be verified against the keys of %s: %s", commit, builderutil.GitSigningKeys, strings.Join(strings.Fields(errOut), " ")))
+	}
+	log.V(0).Infof("Verified the signature of commit %s", commit)
+	return nil
+}
+
+// importSigningKeys imports the OpenPGP public keys in keyFiles into the
+// keyring in gnupgHome, and returns the arguments with which git uses the
+// allowed signers file among them, if there is one, to verify SSH
+// signatures.
+func importSigningKeys(gnupgHome string, keyFiles []string) ([]string, error) {
+	var args []string
+	for _, keyFile := range keyFiles {
+		if filepath.Base(keyFile) == allowedSignersKey {
+			args = append(args, "-c", "gpg.ssh.allowedSignersFile="+keyFile)
+			continue
+		}
+		cmd := exec.Command("gpg", "--homedir", gnupgHome, "--batch", "--import", keyFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("unable to import the signing key %q: %v: %s", filepath.Base(keyFile), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetSourceVerificationPolicy(t *testing.T) {
+	secretDir, err := ioutil.TempDir("", "source-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secretDir)
+	writeTestFile(t, filepath.Join(secretDir, "allowed_signers"), "")
+	writeTestFile(t, filepath.Join(secretDir, "release.asc"), "")
+
+	tests := []struct {
+		name         string
+		env          []corev1.EnvVar
+		sourceSecret bool
+		expect       *SourceVerificationPolicy
+		err          bool
+	}{
+		{name: "none"},
+		{
+			name:         "keys",
+			env:          []corev1.EnvVar{{Name: "BUILD_GIT_SIGNING_KEYS", Value: "allowed_signers, release.asc"}},
+			sourceSecret: true,
+			expect:       &SourceVerificationPolicy{KeyFiles: []string{filepath.Join(secretDir, "allowed_signers"), filepath.Join(secretDir, "release.asc")}},
+		},
+		{
+			name:   "refs",
+			env:    []corev1.EnvVar{{Name: "BUILD_GIT_ALLOWED_REFS", Value: "main, release-*,"}},
+			expect: &SourceVerificationPolicy{AllowedRefs: []string{"main", "release-*"}},
+		},
+		{
+			name: "keys without a source secret",
+			env:  []corev1.EnvVar{{Name: "BUILD_GIT_SIGNING_KEYS", Value: "allowed_signers"}},
+			err:  true,
+		},
+		{
+			name:         "missing key",
+			env:          []corev1.EnvVar{{Name: "BUILD_GIT_SIGNING_KEYS", Value: "allowed_signers,other.asc"}},
+			sourceSecret: true,
+			err:          true,
+		},
+		{
+			name:         "not a key",
+			env:          []corev1.EnvVar{{Name: "BUILD_GIT_SIGNING_KEYS", Value: "../allowed_signers"}},
+			sourceSecret: true,
+			err:          true,
+		},
+		{
+			name: "bad pattern",
+			env:  []corev1.EnvVar{{Name: "BUILD_GIT_ALLOWED_REFS", Value: "release-["}},
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		if test.sourceSecret {
+			build.Spec.Source.SourceSecret = &corev1.LocalObjectReference{Name: "source"}
+		}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		policy, err := GetSourceVerificationPolicy(build, secretDir)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(policy, test.expect) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expect, policy)
+		}
+	}
+}
+
+func TestRefAllowed(t *testing.T) {
+	patterns := []string{"main", "release-*", "refs/tags/v*"}
+	for ref, expect := range map[string]bool{
+		"main":                  true,
+		"refs/heads/main":       true,
+		"release-4.6":           true,
+		"refs/heads/release-1":  true,
+		"refs/tags/v1.0":        true,
+		"feature":               false,
+		"refs/heads/feature":    false,
+		"release/4.6":           false,
+		"refs/pull/1/head":      false,
+		"":                      false,
+		"refs/heads/maintained": false,
+	} {
+		if allowed := refAllowed(ref, patterns); allowed != expect {
+			t.Errorf("%q: expected %v, got %v", ref, expect, allowed)
+		}
+	}
+}
+
+func TestVerifySource(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is required to sign commits")
+	}
+	repo, err := initializeTestGitRepo("verify")
+	defer repo.cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.addCommit(); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo.Path
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	branch := git("symbolic-ref", "--short", "HEAD")
+
+	keys, err := ioutil.TempDir("", "signing-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keys)
+	keyFile := filepath.Join(keys, "..key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "me@example.com", "-f", keyFile).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	publicKey, err := ioutil.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(keys, "allowed_signers"), "me@example.com "+string(publicKey))
+
+	build := &buildapiv1.Build{}
+	build.Spec.Source.Git = &buildapiv1.GitBuildSource{URI: "file://" + repo.Path}
+	env := []string{"GIT_ASKPASS=true"}
+
+	// the unsigned commit is rejected
+	err = VerifySource(env, build, repo.Path, &SourceVerificationPolicy{KeyFiles: []string{filepath.Join(keys, "allowed_signers")}})
+	if _, ok := err.(sourceVerificationError); !ok {
+		t.Fatalf("expected an unsigned commit to fail verification, got %v", err)
+	}
+	if code := classifyFailure(err); code != FailureSourceVerification {
+		t.Errorf("expected failure code %s, got %s", FailureSourceVerification, code)
+	}
+
+	git("-c", "gpg.format=ssh", "-c", "user.signingkey="+keyFile, "commit", "--allow-empty", "-S", "-m", "signed")
+	if err := VerifySource(env, build, repo.Path, &SourceVerificationPolicy{KeyFiles: []string{filepath.Join(keys, "allowed_signers")}, AllowedRefs: []string{branch}}); err != nil {
+		t.Errorf("expected the signed commit of %s to be verified: %v", branch, err)
+	}
+
+	// the default branch is not allowed
+	err = VerifySource(env, build, repo.Path, &SourceVerificationPolicy{AllowedRefs: []string{"release-*"}})
+	if _, ok := err.(sourceVerificationError); !ok {
+		t.Errorf("expected %s not to be allowed, got %v", branch, err)
+	}
+	build.Spec.Source.Git.Ref = "release-1"
+	if err := VerifySource(env, build, repo.Path, &SourceVerificationPolicy{AllowedRefs: []string{"release-*"}}); err != nil {
+		t.Errorf("expected release-1 to be allowed: %v", err)
+	}
+
+	// a signer who is not allowed is rejected
+	writeTestFile(t, filepath.Join(keys, "allowed_signers"), "")
+	if err := VerifySource(env, build, repo.Path, &SourceVerificationPolicy{KeyFiles: []string{filepath.Join(keys, "allowed_signers")}}); err == nil {
+		t.Errorf("expected a commit signed with an unknown key to fail verification")
+	}
+}
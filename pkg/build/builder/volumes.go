@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+const (
+	// buildVolumeSecret, buildVolumeConfigMap and buildVolumePath are the
+	// kinds of build volumes.
+	buildVolumeSecret    = "secret"
+	buildVolumeConfigMap = "configmap"
+	buildVolumePath      = "path"
+)
+
+// getBuildVolumeMounts returns the mounts of the build volumes declared by the
+// build strategy's environment, which make input secrets and configmaps, or
+// directories of the build pod, available to the RUN instructions and
+// assemble script of a build, such as an .npmrc, a pip.conf or model files.
+func getBuildVolumeMounts(build *buildapiv1.Build) ([]BuildMount, error) {
+	value, _ := buildStrategyEnv(build, builderutil.BuildVolumes)
+	var mounts []BuildMount
+	destinations := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected kind:name:destination", builderutil.BuildVolumes, entry)
+		}
+		kind, name, destination := parts[0], parts[1], parts[2]
+		if !path.IsAbs(destination) || path.Clean(destination) == "/" {
+			return nil, fmt.Errorf("invalid %s entry %q: the destination must be an absolute path other than /", builderutil.BuildVolumes, entry)
+		}
+		destination = path.Clean(destination)
+		if destinations[destination] {
+			return nil, fmt.Errorf("invalid %s entry %q: another volume is mounted at %s", builderutil.BuildVolumes, entry, destination)
+		}
+		destinations[destination] = true
+
+		var source string
+		switch kind {
+		case buildVolumeSecret:
+			dir, ok := inputSecretDir(build, name)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s entry %q: %q is not an input secret of the build", builderutil.BuildVolumes, entry, name)
+			}
+			source = dir
+		case buildVolumeConfigMap:
+			dir, ok := inputConfigMapDir(build, name)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s entry %q: %q is not an input configmap of the build", builderutil.BuildVolumes, entry, name)
+			}
+			source = dir
+		case buildVolumePath:
+			if !path.IsAbs(name) {
+				return nil, fmt.Errorf("invalid %s entry %q: the path must be absolute", builderutil.BuildVolumes, entry)
+			}
+			if st, err := os.Stat(name); err != nil || !st.IsDir() {
+				return nil, fmt.Errorf("invalid %s entry %q: %s is not a directory of the build pod", builderutil.BuildVolumes, entry, name)
+			}
+			source = name
+		default:
+			return nil, fmt.Errorf("invalid %s entry %q: the kind must be %q, %q or %q", builderutil.BuildVolumes, entry, buildVolumeSecret, buildVolumeConfigMap, buildVolumePath)
+		}
+		log.V(0).Infof("Mounting the build volume %s %q at %s", kind, name, destination)
+		mounts = append(mounts, BuildMount{Source: source, Destination: destination})
+	}
+	return mounts, nil
+}
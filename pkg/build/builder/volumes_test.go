@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetBuildVolumeMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name      string
+		value     string
+		expect    []BuildMount
+		expectErr bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:  "secret, configmap and path",
+			value: "secret:npmrc:/opt/app-root/src/.npmrc.d, configmap:pip:/etc/pip/,path:" + dir + ":/models",
+			expect: []BuildMount{
+				{Source: "/var/run/secrets/openshift.io/build/npmrc", Destination: "/opt/app-root/src/.npmrc.d"},
+				{Source: "/var/run/configs/openshift.io/build/pip", Destination: "/etc/pip"},
+				{Source: dir, Destination: "/models"},
+			},
+		},
+		{
+			name:      "not an input secret",
+			value:     "secret:other:/run/other",
+			expectErr: true,
+		},
+		{
+			name:      "not an input configmap",
+			value:     "configmap:npmrc:/run/npmrc",
+			expectErr: true,
+		},
+		{
+			name:      "missing path",
+			value:     "path:" + dir + "/missing:/models",
+			expectErr: true,
+		},
+		{
+			name:      "relative destination",
+			value:     "secret:npmrc:npmrc",
+			expectErr: true,
+		},
+		{
+			name:      "root destination",
+			value:     "secret:npmrc:/",
+			expectErr: true,
+		},
+		{
+			name:      "duplicate destination",
+			value:     "secret:npmrc:/run/npmrc,configmap:pip:/run/npmrc/",
+			expectErr: true,
+		},
+		{
+			name:      "unknown kind",
+			value:     "csi:models:/models",
+			expectErr: true,
+		},
+		{
+			name:      "malformed",
+			value:     "secret:npmrc",
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Source.Secrets = []buildapiv1.SecretBuildSource{{Secret: corev1.LocalObjectReference{Name: "npmrc"}}}
+			build.Spec.Source.ConfigMaps = []buildapiv1.ConfigMapBuildSource{{ConfigMap: corev1.LocalObjectReference{Name: "pip"}}}
+			build.Spec.Strategy.SourceStrategy = &buildapiv1.SourceBuildStrategy{
+				Env: []corev1.EnvVar{{Name: "BUILD_VOLUMES", Value: test.value}},
+			}
+			mounts, err := getBuildVolumeMounts(build)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(mounts, test.expect) {
+				t.Errorf("expected mounts %v, got %v", test.expect, mounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+const (
+	// scannerSocketScheme prefixes the socket of a scanner sidecar.
+	scannerSocketScheme = "unix://"
+	// scannerTimeout is how long a scan may take.
+	scannerTimeout = 10 * time.Minute
+	// defaultSeverityThreshold is the lowest severity which fails a scan
+	// unless VulnerabilitySeverityThreshold says otherwise.
+	defaultSeverityThreshold = "high"
+)
+
+// severities ranks the severities of vulnerabilities.  Others, such as
+// "unknown" or "negligible", rank below all of them.
+var severities = map[string]int{
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// vulnerability is a vulnerability found in an image by a scanner.
+type vulnerability struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	FixedIn  string `json:"fixedIn,omitempty"`
+}
+
+// scanReport is what a scanner reports.
+type scanReport struct {
+	Vulnerabilities []vulnerability `json:"vulnerabilities"`
+}
+
+// scanRequest is what a scanner sidecar is sent.
+type scanRequest struct {
+	Image string `json:"image"`
+	// Packages are the package URLs of the packages installed in the image
+	Packages []string `json:"packages"`
+}
+
+// getVulnerabilityScanner returns the scanner that the image of build is
+// checked with, if any, and the lowest severity of the vulnerabilities which
+// fail the check.
+func getVulnerabilityScanner(build *buildapiv1.Build) (string, string, error) {
+	scanner, _ := buildStrategyEnv(build, builderutil.VulnerabilityScanner)
+	if scanner = strings.TrimSpace(scanner); len(scanner) == 0 {
+		return "", "", nil
+	}
+	if !strings.HasPrefix(scanner, scannerSocketScheme) && !strings.HasPrefix(scanner, "/") {
+		return "", "", fmt.Errorf("invalid %s value %q: must be a %s URL or an absolute path", builderutil.VulnerabilityScanner, scanner, scannerSocketScheme)
+	}
+	threshold, _ := buildStrategyEnv(build, builderutil.VulnerabilitySeverityThreshold)
+	threshold = strings.ToLower(strings.TrimSpace(threshold))
+	if len(threshold) == 0 {
+		threshold = defaultSeverityThreshold
+	}
+	if _, ok := severities[threshold]; !ok {
+		return "", "", fmt.Errorf("invalid %s value %q: must be \"low\", \"medium\", \"high\" or \"critical\"", builderutil.VulnerabilitySeverityThreshold, threshold)
+	}
+	return scanner, threshold, nil
+}
+
+// scanImage checks the local image name, built by build, with the scanner
+// requested for the build, if any, and returns an error if it finds
+// vulnerabilities at or above the threshold severity, after writing a
+// summary of them to the termination message of the build container.
+func scanImage(client DockerClient, build *buildapiv1.Build, name string) error {
+	scanner, threshold, err := getVulnerabilityScanner(build)
+	if err != nil || len(scanner) == 0 {
+		return err
+	}
+	mounter, ok := client.(imageMounter)
+	if !ok {
+		return fmt.Errorf("scanning the image for vulnerabilities is not supported by this build client")
+	}
+	log.V(0).Infof("\nScanning image %s for vulnerabilities ...", name)
+	root, unmount, err := mounter.MountImage(name)
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v", name, err)
+	}
+	defer unmount()
+
+	var report *scanReport
+	if strings.HasPrefix(scanner, scannerSocketScheme) {
+		var packages []sbomPackage
+		if packages, err = findImagePackages(root); err != nil {
+			return err
+		}
+		report, err = scanWithSidecar(strings.TrimPrefix(scanner, scannerSocketScheme), name, packages)
+	} else {
+		report, err = scanWithCommand(scanner, root, name)
+	}
+	if err != nil {
+		return fmt.Errorf("error scanning image %s: %v", name, err)
+	}
+
+	summary, blocking := summarizeScan(report, threshold)
+	log.V(0).Infof("%s", summary)
+	if blocking == 0 {
+		return nil
+	}
+	writeScanTerminationMessage(terminationMessagePath, summary)
+	return fmt.Errorf("image %s has %d vulnerabilities of %s or higher severity", name, blocking, threshold)
+}
+
+// scanWithSidecar sends the packages installed in the image name to the
+// scanner sidecar listening on socket, and returns its report.
+func scanWithSidecar(socket, name string, packages []sbomPackage) (*scanReport, error) {
+	request := scanRequest{Image: name, Packages: []string{}}
+	for _, p := range packages {
+		request.Packages = append(request.Packages, p.purl())
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: scannerTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Post("http://scanner/scan", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("the scanner responded %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	report := &scanReport{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, fmt.Errorf("error parsing the scanner's report: %v", err)
+	}
+	return report, nil
+}
+
+// scanWithCommand runs the scanner command with the directory holding the
+// root filesystem of the image name, and returns its report.
+func scanWithCommand(command, root, name string) (*scanReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scannerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, root, name)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	report := &scanReport{}
+	if err := json.Unmarshal(out, report); err != nil {
+		return nil, fmt.Errorf("error parsing the scanner's report: %v", err)
+	}
+	return report, nil
+}
+
+// summarizeScan returns a summary of report, listing the vulnerabilities at
+// or above threshold, and how many of them there are.
+func summarizeScan(report *scanReport, threshold string) (string, int) {
+	counts := map[string]int{}
+	var blocking []vulnerability
+	for _, v := range report.Vulnerabilities {
+		severity := strings.ToLower(v.Severity)
+		counts[severity]++
+		if severities[severity] >= severities[threshold] {
+			blocking = append(blocking, v)
+		}
+	}
+	if len(report.Vulnerabilities) == 0 {
+		return "The vulnerability scan found no vulnerabilities.", 0
+	}
+
+	ranked := make([]string, 0, len(counts))
+	for severity := range counts {
+		ranked = append(ranked, severity)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if severities[ranked[i]] != severities[ranked[j]] {
+			return severities[ranked[i]] > severities[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	var found []string
+	for _, severity := range ranked {
+		found = append(found, fmt.Sprintf("%d %s", counts[severity], severity))
+	}
+	sort.SliceStable(blocking, func(i, j int) bool {
+		return severities[strings.ToLower(blocking[i].Severity)] > severities[strings.ToLower(blocking[j].Severity)]
+	})
+
+	summary := fmt.Sprintf("The vulnerability scan found %s.", strings.Join(found, ", "))
+	for _, v := range blocking {
+		line := fmt.Sprintf("\n%s (%s) in %s %s", v.ID, strings.ToLower(v.Severity), v.Package, v.Version)
+		if len(v.FixedIn) > 0 {
+			line += ", fixed in " + v.FixedIn
+		}
+		summary += line
+	}
+	return summary, len(blocking)
+}
+
+// writeScanTerminationMessage writes summary to the termination message at
+// path, truncated to the length which is kept.
+func writeScanTerminationMessage(path, summary string) {
+	if len(summary) > terminationMessageLimit {
+		end := strings.LastIndex(summary[:terminationMessageLimit], "\n")
+		if end < 0 {
+			end = terminationMessageLimit
+		}
+		summary = summary[:end]
+	}
+	if err := ioutil.WriteFile(path, []byte(summary), 0644); err != nil {
+		log.V(0).Infof("warning: Failed to write the vulnerability scan summary to the termination message: %v", err)
+	}
+}
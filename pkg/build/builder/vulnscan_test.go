@@ -0,0 +1,175 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+var testScanReport = scanReport{Vulnerabilities: []vulnerability{
+	{ID: "CVE-2021-0001", Severity: "Medium", Package: "bash", Version: "5.1-2"},
+	{ID: "CVE-2021-0002", Severity: "High", Package: "libc6", Version: "2.31-13", FixedIn: "2.31-14"},
+	{ID: "CVE-2021-0003", Severity: "Critical", Package: "openssl", Version: "1.1.1k"},
+	{ID: "CVE-2021-0004", Severity: "Negligible", Package: "bash", Version: "5.1-2"},
+}}
+
+func TestGetVulnerabilityScanner(t *testing.T) {
+	tests := []struct {
+		env             []corev1.EnvVar
+		expectScanner   string
+		expectThreshold string
+		expectErr       bool
+	}{
+		{},
+		{env: []corev1.EnvVar{{Name: "BUILD_VULNERABILITY_SCANNER", Value: "unix:///var/run/scanner.sock"}}, expectScanner: "unix:///var/run/scanner.sock", expectThreshold: "high"},
+		{
+			env:             []corev1.EnvVar{{Name: "BUILD_VULNERABILITY_SCANNER", Value: "/usr/bin/scan"}, {Name: "BUILD_VULNERABILITY_SEVERITY_THRESHOLD", Value: "Critical"}},
+			expectScanner:   "/usr/bin/scan",
+			expectThreshold: "critical",
+		},
+		{env: []corev1.EnvVar{{Name: "BUILD_VULNERABILITY_SCANNER", Value: "scan"}}, expectErr: true},
+		{env: []corev1.EnvVar{{Name: "BUILD_VULNERABILITY_SCANNER", Value: "/usr/bin/scan"}, {Name: "BUILD_VULNERABILITY_SEVERITY_THRESHOLD", Value: "severe"}}, expectErr: true},
+	}
+	for _, test := range tests {
+		build := &buildapiv1.Build{}
+		build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+		scanner, threshold, err := getVulnerabilityScanner(build)
+		if (err != nil) != test.expectErr || scanner != test.expectScanner || threshold != test.expectThreshold {
+			t.Errorf("%v: expected %q and %q, got %q, %q, %v", test.env, test.expectScanner, test.expectThreshold, scanner, threshold, err)
+		}
+	}
+}
+
+func TestSummarizeScan(t *testing.T) {
+	summary, blocking := summarizeScan(&testScanReport, "high")
+	expected := "The vulnerability scan found 1 critical, 1 high, 1 medium, 1 negligible.\n" +
+		"CVE-2021-0003 (critical) in openssl 1.1.1k\n" +
+		"CVE-2021-0002 (high) in libc6 2.31-13, fixed in 2.31-14"
+	if summary != expected || blocking != 2 {
+		t.Errorf("expected %d blocking vulnerabilities and summary:\n%s\ngot %d and:\n%s", 2, expected, blocking, summary)
+	}
+	if _, blocking := summarizeScan(&testScanReport, "critical"); blocking != 1 {
+		t.Errorf("expected 1 critical vulnerability, got %d", blocking)
+	}
+	if summary, blocking := summarizeScan(&scanReport{}, "low"); blocking != 0 || !strings.Contains(summary, "no vulnerabilities") {
+		t.Errorf("unexpected summary of an empty report: %d, %s", blocking, summary)
+	}
+}
+
+func TestScanWithSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "scanner.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request scanRequest
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scan" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(testScanReport)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	packages := []sbomPackage{{Name: "bash", Version: "5.1-2", Arch: "amd64", Type: "deb", Distro: "debian"}}
+	report, err := scanWithSidecar(socket, "image", packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*report, testScanReport) {
+		t.Errorf("expected report %v, got %v", testScanReport, *report)
+	}
+	expected := scanRequest{Image: "image", Packages: []string{"pkg:deb/debian/bash@5.1-2?arch=amd64"}}
+	if !reflect.DeepEqual(request, expected) {
+		t.Errorf("expected request %v, got %v", expected, request)
+	}
+}
+
+func TestScanWithCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scanner := filepath.Join(dir, "scan")
+	script := "#!/bin/sh\n[ \"$1\" = /root ] && [ \"$2\" = image ] || exit 1\necho '{\"vulnerabilities\": [{\"id\": \"CVE-2021-0002\", \"severity\": \"High\"}]}'\n"
+	if err := ioutil.WriteFile(scanner, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	report, err := scanWithCommand(scanner, "/root", "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Vulnerabilities) != 1 || report.Vulnerabilities[0].ID != "CVE-2021-0002" {
+		t.Errorf("unexpected report %v", report)
+	}
+	if _, err := scanWithCommand(scanner, "/other", "image"); err == nil {
+		t.Errorf("expected an error from a failing scanner")
+	}
+}
+
+func TestScanImage(t *testing.T) {
+	root, err := ioutil.TempDir("", "scan-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	scanner := filepath.Join(root, "scan")
+	if err := ioutil.WriteFile(scanner, []byte("#!/bin/sh\necho '{\"vulnerabilities\": [{\"id\": \"CVE-2021-0001\", \"severity\": \"Medium\"}]}'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	build := &buildapiv1.Build{}
+	build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{
+		Env: []corev1.EnvVar{{Name: "BUILD_VULNERABILITY_SCANNER", Value: scanner}},
+	}
+	client := &fakeMountingClient{FakeDocker: NewFakeDockerClient(), root: root}
+	if err := scanImage(client, build, "image"); err != nil {
+		t.Errorf("expected vulnerabilities below the threshold to pass, got %v", err)
+	}
+	if !reflect.DeepEqual(client.mounted, []string{"image"}) {
+		t.Errorf("expected image to be mounted, got %v", client.mounted)
+	}
+	if err := scanImage(NewFakeDockerClient(), build, "image"); err == nil {
+		t.Errorf("expected an error from a client which cannot mount images")
+	}
+}
+
+func TestWriteScanTerminationMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termination")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "termination-log")
+
+	summary := "The vulnerability scan found many." + strings.Repeat("\nCVE-2021-0002 (high) in libc6 2.31-13", 200)
+	writeScanTerminationMessage(path, summary)
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) > terminationMessageLimit || !strings.HasPrefix(summary, string(written)) || strings.HasSuffix(string(written), "\n") {
+		t.Errorf("expected the summary to be truncated at a line, got %d bytes", len(written))
+	}
+}
@@ -0,0 +1,166 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+	builderutil "github.com/openshift/builder/pkg/build/builder/util"
+)
+
+// completionWebhookTimeout is how long the builder waits for the
+// CompletionWebhook to accept a build's summary.
+const completionWebhookTimeout = 30 * time.Second
+
+// completionWebhookAuthorizationKey is the key of the CompletionWebhookSecret
+// holding the value of the Authorization header.
+const completionWebhookAuthorizationKey = "authorization"
+
+// buildSummary is what the builder tells the CompletionWebhook of a build.
+type buildSummary struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Phase is Complete, Failed or Cancelled
+	Phase   buildapiv1.BuildPhase   `json:"phase"`
+	Reason  buildapiv1.StatusReason `json:"reason,omitempty"`
+	Message string                  `json:"message,omitempty"`
+	// FailureCode is the machine-readable code of the build's failure
+	FailureCode FailureCode `json:"failureCode,omitempty"`
+	// Error is the error which failed the build
+	Error        string `json:"error,omitempty"`
+	OutputImage  string `json:"outputImage,omitempty"`
+	OutputDigest string `json:"outputDigest,omitempty"`
+	// Revision holds the details of the revision of the source built
+	Revision       *RevisionDetails `json:"revision,omitempty"`
+	StartTime      string           `json:"startTime,omitempty"`
+	CompletionTime string           `json:"completionTime"`
+	// DurationSeconds is how long the build took from StartTime
+	DurationSeconds float64        `json:"durationSeconds,omitempty"`
+	Stages          []stageSummary `json:"stages,omitempty"`
+}
+
+// stageSummary is how long a stage of the build took.
+type stageSummary struct {
+	Name                 buildapiv1.StageName `json:"name"`
+	DurationMilliseconds int64                `json:"durationMilliseconds"`
+}
+
+// getCompletionWebhook returns the URL to which the build's summary is sent
+// and the Authorization header sent with it, if the build strategy's
+// environment requests one.
+func getCompletionWebhook(build *buildapiv1.Build) (string, string, error) {
+	value, _ := buildStrategyEnv(build, builderutil.CompletionWebhook)
+	if value = strings.TrimSpace(value); len(value) == 0 {
+		return "", "", nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", "", fmt.Errorf("invalid %s value: it must be an http or https URL", builderutil.CompletionWebhook)
+	}
+	name, _ := buildStrategyEnv(build, builderutil.CompletionWebhookSecret)
+	if len(name) == 0 {
+		return value, "", nil
+	}
+	dir, ok := inputSecretDir(build, name)
+	if !ok {
+		return "", "", fmt.Errorf("invalid %s value %q: it is not an input secret of the build", builderutil.CompletionWebhookSecret, name)
+	}
+	authorization, err := ioutil.ReadFile(filepath.Join(dir, completionWebhookAuthorizationKey))
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read the %s key of the input secret %q: %v", completionWebhookAuthorizationKey, name, err)
+	}
+	return value, strings.TrimSpace(string(authorization)), nil
+}
+
+// summarizeBuild returns the summary of the build, which buildErr failed if
+// it is set.
+func summarizeBuild(build *buildapiv1.Build, buildErr error) buildSummary {
+	now := time.Now()
+	summary := buildSummary{
+		Name:           build.Name,
+		Namespace:      build.Namespace,
+		Phase:          buildapiv1.BuildPhaseComplete,
+		OutputImage:    build.Status.OutputDockerImageReference,
+		CompletionTime: now.UTC().Format(time.RFC3339),
+		Revision:       readRevisionDetails(),
+	}
+	if buildErr != nil {
+		summary.Phase = buildapiv1.BuildPhaseFailed
+		if build.Status.Phase == buildapiv1.BuildPhaseCancelled {
+			summary.Phase = buildapiv1.BuildPhaseCancelled
+		}
+		summary.Reason = build.Status.Reason
+		summary.Message = build.Status.Message
+		summary.FailureCode = BuildFailureCode(build)
+		summary.Error = buildErr.Error()
+	}
+	if build.Status.Output.To != nil {
+		summary.OutputDigest = build.Status.Output.To.ImageDigest
+	}
+	if start := build.Status.StartTimestamp; start != nil {
+		summary.StartTime = start.UTC().Format(time.RFC3339)
+		summary.DurationSeconds = now.Sub(start.Time).Seconds()
+	}
+	for _, stage := range build.Status.Stages {
+		summary.Stages = append(summary.Stages, stageSummary{Name: stage.Name, DurationMilliseconds: stage.DurationMilliseconds})
+	}
+	return summary
+}
+
+// NotifyBuildCompletion POSTs a summary of the build, which buildErr failed
+// if it is set, to the CompletionWebhook requested by the build strategy's
+// environment, if any.  The build's result does not depend on the webhook,
+// so failing to reach it is only logged.
+func NotifyBuildCompletion(build *buildapiv1.Build, buildErr error) {
+	webhook, authorization, err := getCompletionWebhook(build)
+	if err != nil {
+		log.V(0).Infof("warning: Unable to notify the build's completion webhook: %v", err)
+		return
+	}
+	if len(webhook) == 0 {
+		return
+	}
+	if err := postBuildSummary(webhook, authorization, summarizeBuild(build, buildErr)); err != nil {
+		log.V(0).Infof("warning: Unable to notify the build's completion webhook: %v", err)
+		return
+	}
+	log.V(4).Infof("Notified the build's completion webhook")
+}
+
+// postBuildSummary POSTs summary to webhook as JSON, with the Authorization
+// header authorization, if set.
+func postBuildSummary(webhook, authorization string, summary buildSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	// the URL is not logged, as it may hold a token
+	client := &http.Client{Timeout: completionWebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildapiv1 "github.com/openshift/api/build/v1"
+)
+
+func TestGetCompletionWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []corev1.EnvVar
+		expectURL string
+		expectErr bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:      "url",
+			env:       []corev1.EnvVar{{Name: "BUILD_COMPLETION_WEBHOOK", Value: "https://ci.example.com/hooks/build"}},
+			expectURL: "https://ci.example.com/hooks/build",
+		},
+		{
+			name:      "not http",
+			env:       []corev1.EnvVar{{Name: "BUILD_COMPLETION_WEBHOOK", Value: "ftp://ci.example.com/hooks/build"}},
+			expectErr: true,
+		},
+		{
+			name: "secret which is not an input",
+			env: []corev1.EnvVar{
+				{Name: "BUILD_COMPLETION_WEBHOOK", Value: "https://ci.example.com/hooks/build"},
+				{Name: "BUILD_COMPLETION_WEBHOOK_SECRET", Value: "webhook-token"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := &buildapiv1.Build{}
+			build.Spec.Strategy.DockerStrategy = &buildapiv1.DockerBuildStrategy{Env: test.env}
+			webhook, _, err := getCompletionWebhook(build)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if webhook != test.expectURL {
+				t.Errorf("expected %q, got %q", test.expectURL, webhook)
+			}
+		})
+	}
+}
+
+func TestSummarizeBuild(t *testing.T) {
+	build := &buildapiv1.Build{}
+	build.Name = "app-1"
+	build.Namespace = "myproject"
+	start := metav1.NewTime(time.Now().Add(-time.Minute))
+	build.Status.StartTimestamp = &start
+	build.Status.OutputDockerImageReference = "registry.example.com/myproject/app:latest"
+	build.Status.Output.To = &buildapiv1.BuildStatusOutputTo{ImageDigest: "sha256:1234"}
+	build.Status.Stages = []buildapiv1.StageInfo{{Name: buildapiv1.StageBuild, DurationMilliseconds: 40000}}
+
+	summary := summarizeBuild(build, nil)
+	if summary.Phase != buildapiv1.BuildPhaseComplete || summary.OutputDigest != "sha256:1234" || len(summary.Stages) != 1 {
+		t.Errorf("unexpected summary %#v", summary)
+	}
+	if summary.DurationSeconds < 60 {
+		t.Errorf("expected the build to have taken a minute, got %v seconds", summary.DurationSeconds)
+	}
+
+	build.Status.Phase = buildapiv1.BuildPhaseCancelled
+	build.Status.Reason = buildapiv1.StatusReasonCancelledBuild
+	summary = summarizeBuild(build, errors.New("cancelled"))
+	if summary.Phase != buildapiv1.BuildPhaseCancelled || summary.Reason != buildapiv1.StatusReasonCancelledBuild || summary.Error != "cancelled" {
+		t.Errorf("unexpected summary %#v", summary)
+	}
+}
+
+func TestPostBuildSummary(t *testing.T) {
+	var received buildSummary
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+		if r.URL.Path == "/rejected" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	summary := buildSummary{Name: "app-1", Namespace: "myproject", Phase: buildapiv1.BuildPhaseFailed}
+	if err := postBuildSummary(server.URL+"/hooks/build", "Bearer token", summary); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received.Name != "app-1" || received.Phase != buildapiv1.BuildPhaseFailed {
+		t.Errorf("unexpected summary %#v", received)
+	}
+	if authorization != "Bearer token" {
+		t.Errorf("expected the Authorization header to be sent, got %q", authorization)
+	}
+	if err := postBuildSummary(server.URL+"/rejected", "", summary); err == nil {
+		t.Errorf("expected an error when the webhook rejects the summary")
+	}
+}